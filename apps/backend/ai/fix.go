@@ -0,0 +1,74 @@
+package ai
+
+import (
+	"fmt"
+	"strings"
+
+	"go-backend/sqltools"
+)
+
+const fixSystemPrompt = `You are a PostgreSQL expert helping a user understand why their query failed.
+Explain the cause of the error in plain language in at most three sentences, then give a corrected
+query in one ` + "```sql" + ` code block. Only use tables and columns listed in the schema and keep the
+user's intent. If the query cannot be fixed without more information, say what is missing and omit the code block.`
+
+// QueryError is the server-reported failure of a query, as PostgreSQL
+// describes it.
+type QueryError struct {
+	Severity string `json:"severity"`
+	Code     string `json:"code"`
+	Message  string `json:"message"`
+	Detail   string `json:"detail,omitempty"`
+	Hint     string `json:"hint,omitempty"`
+	Position int    `json:"position,omitempty"` // 1-based character offset into the query
+}
+
+// BuildFixPrompt asks the model to explain qerr and correct sql. The schema
+// section favours the tables the query and error message mention.
+func BuildFixPrompt(sql string, qerr QueryError, cat *sqltools.Catalog) CompletionRequest {
+	var b strings.Builder
+	b.WriteString("Database schema:\n")
+	b.WriteString(SchemaContext(sql+" "+qerr.Message, cat))
+	b.WriteString("\nQuery:\n```sql\n")
+	b.WriteString(sql)
+	b.WriteString("\n```\n\nError:\n")
+	fmt.Fprintf(&b, "%s: %s (SQLSTATE %s)\n", qerr.Severity, qerr.Message, qerr.Code)
+	if qerr.Detail != "" {
+		fmt.Fprintf(&b, "DETAIL: %s\n", qerr.Detail)
+	}
+	if qerr.Hint != "" {
+		fmt.Fprintf(&b, "HINT: %s\n", qerr.Hint)
+	}
+	if marker := errorMarker(sql, qerr.Position); marker != "" {
+		b.WriteString("Location:\n")
+		b.WriteString(marker)
+	}
+
+	return CompletionRequest{
+		System:      fixSystemPrompt,
+		Messages:    []Message{{Role: "user", Content: b.String()}},
+		Temperature: 0,
+		MaxTokens:   1024,
+	}
+}
+
+// errorMarker renders the line containing the error position with a caret
+// under the offending character, the way psql does.
+func errorMarker(sql string, position int) string {
+	runes := []rune(sql)
+	if position < 1 || position > len(runes) {
+		return ""
+	}
+	offset := position - 1
+
+	lineStart := offset
+	for lineStart > 0 && runes[lineStart-1] != '\n' {
+		lineStart--
+	}
+	lineEnd := offset
+	for lineEnd < len(runes) && runes[lineEnd] != '\n' {
+		lineEnd++
+	}
+
+	return string(runes[lineStart:lineEnd]) + "\n" + strings.Repeat(" ", offset-lineStart) + "^\n"
+}
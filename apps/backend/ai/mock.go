@@ -0,0 +1,64 @@
+package ai
+
+import (
+	"context"
+	"strings"
+)
+
+// MockProvider answers without calling out to a model. With a fixed response
+// it is a test double; without one it picks the table from the prompt's
+// schema that best matches the question and selects from it, which is enough
+// to exercise the assistant end to end in local development.
+type MockProvider struct {
+	Response string
+}
+
+func NewMockProvider(response string) *MockProvider {
+	return &MockProvider{Response: response}
+}
+
+func (p *MockProvider) Name() string {
+	return "mock"
+}
+
+func (p *MockProvider) Complete(ctx context.Context, req CompletionRequest) (*CompletionResponse, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	var prompt strings.Builder
+	prompt.WriteString(req.System)
+	for _, m := range req.Messages {
+		prompt.WriteString(m.Content)
+	}
+
+	content := p.Response
+	if content == "" {
+		content = "```sql\nSELECT * FROM " + mockTable(req) + " LIMIT 100\n```\nReturns the first rows of the most relevant table."
+	}
+
+	return &CompletionResponse{
+		Content:          content,
+		Model:            "mock",
+		PromptTokens:     prompt.Len() / 4,
+		CompletionTokens: len(content) / 4,
+	}, nil
+}
+
+// mockTable returns the first table listed in the prompt's schema section,
+// which SchemaContext orders by relevance to the question.
+func mockTable(req CompletionRequest) string {
+	for _, m := range req.Messages {
+		for _, line := range strings.Split(m.Content, "\n") {
+			if !strings.HasPrefix(line, schemaLinePrefix) || strings.HasPrefix(line, schemaLinePrefix+"...") {
+				continue
+			}
+			name := strings.TrimPrefix(line, schemaLinePrefix)
+			if i := strings.IndexAny(name, " ("); i > 0 {
+				name = name[:i]
+			}
+			return name
+		}
+	}
+	return "information_schema.tables"
+}
@@ -0,0 +1,117 @@
+package ai
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+
+	"go-backend/sqltools"
+)
+
+// maxSchemaChars keeps the schema section of a prompt within a size every
+// provider accepts; the least relevant tables are dropped first.
+const maxSchemaChars = 24000
+
+const sqlSystemPrompt = `You are a PostgreSQL expert helping a user query their own database.
+Write a single read-only SELECT statement (CTEs are fine) that answers the user's question.
+Only use tables and columns listed in the schema. Qualify tables outside the public schema.
+Never modify data or schema. Add a LIMIT when the result could be large.
+Reply with the SQL in one ` + "```sql" + ` code block followed by a one-sentence explanation.`
+
+// schemaLinePrefix marks table lines in the prompt; MockProvider reads them back.
+const schemaLinePrefix = "- "
+
+// BuildSQLPrompt grounds a natural-language question in the database schema.
+func BuildSQLPrompt(question string, cat *sqltools.Catalog) CompletionRequest {
+	var b strings.Builder
+	b.WriteString("Database schema:\n")
+	b.WriteString(SchemaContext(question, cat))
+	b.WriteString("\nQuestion: ")
+	b.WriteString(strings.TrimSpace(question))
+
+	return CompletionRequest{
+		System:      sqlSystemPrompt,
+		Messages:    []Message{{Role: "user", Content: b.String()}},
+		Temperature: 0,
+		MaxTokens:   1024,
+	}
+}
+
+// SchemaContext renders the catalog one table per line, most relevant to the
+// question first, truncated to maxSchemaChars.
+func SchemaContext(question string, cat *sqltools.Catalog) string {
+	if cat == nil || len(cat.Tables) == 0 {
+		return "(no tables)\n"
+	}
+
+	words := questionWords(question)
+	tables := make([]sqltools.CatalogTable, len(cat.Tables))
+	copy(tables, cat.Tables)
+	sort.SliceStable(tables, func(i, j int) bool {
+		return relevance(tables[i], words) > relevance(tables[j], words)
+	})
+
+	var b strings.Builder
+	for _, t := range tables {
+		cols := make([]string, 0, len(t.Columns))
+		for _, c := range t.Columns {
+			cols = append(cols, c.Name+" "+c.Type)
+		}
+		kind := ""
+		if t.IsView {
+			kind = " (view)"
+		}
+		line := fmt.Sprintf("%s%s.%s%s(%s)\n", schemaLinePrefix, t.Schema, t.Name, kind, strings.Join(cols, ", "))
+		if b.Len()+len(line) > maxSchemaChars {
+			b.WriteString("- ... more tables omitted\n")
+			break
+		}
+		b.WriteString(line)
+	}
+	return b.String()
+}
+
+var wordPattern = regexp.MustCompile(`[a-z0-9_]+`)
+
+func questionWords(question string) map[string]bool {
+	words := make(map[string]bool)
+	for _, w := range wordPattern.FindAllString(strings.ToLower(question), -1) {
+		words[w] = true
+		// crude singularisation so "orders" matches a table named "order"
+		words[strings.TrimSuffix(w, "s")] = true
+	}
+	return words
+}
+
+func relevance(t sqltools.CatalogTable, words map[string]bool) int {
+	score := 0
+	name := strings.ToLower(t.Name)
+	if words[name] || words[strings.TrimSuffix(name, "s")] {
+		score += 10
+	}
+	for _, c := range t.Columns {
+		if words[strings.ToLower(c.Name)] {
+			score++
+		}
+	}
+	return score
+}
+
+var sqlFence = regexp.MustCompile("(?s)```(?:sql|postgresql|pgsql)?\\s*\\n?(.*?)```")
+
+// ExtractSQL pulls the SQL statement out of a model reply, preferring a
+// fenced code block and falling back to the whole reply.
+func ExtractSQL(content string) string {
+	sql := content
+	if m := sqlFence.FindStringSubmatch(content); m != nil {
+		sql = m[1]
+	}
+	sql = strings.TrimSpace(sql)
+	return strings.TrimSpace(strings.TrimRight(sql, ";"))
+}
+
+// ExtractExplanation returns the prose outside the SQL code block.
+func ExtractExplanation(content string) string {
+	return strings.TrimSpace(sqlFence.ReplaceAllString(content, ""))
+}
@@ -0,0 +1,133 @@
+package ai
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"go-backend/sqltools"
+)
+
+func testCatalog() *sqltools.Catalog {
+	return &sqltools.Catalog{Tables: []sqltools.CatalogTable{
+		{Schema: "public", Name: "customers", Columns: []sqltools.CatalogColumn{{Name: "id", Type: "integer"}, {Name: "email", Type: "text"}}},
+		{Schema: "public", Name: "orders", Columns: []sqltools.CatalogColumn{{Name: "id", Type: "integer"}, {Name: "total", Type: "numeric"}}},
+		{Schema: "reporting", Name: "daily_sales", IsView: true, Columns: []sqltools.CatalogColumn{{Name: "day", Type: "date"}}},
+	}}
+}
+
+func TestBuildSQLPrompt(t *testing.T) {
+	req := BuildSQLPrompt("  What is the total of all orders?  ", testCatalog())
+
+	if req.System != sqlSystemPrompt || req.Temperature != 0 || req.MaxTokens == 0 {
+		t.Errorf("request = %+v", req)
+	}
+	if len(req.Messages) != 1 || req.Messages[0].Role != "user" {
+		t.Fatalf("messages = %+v", req.Messages)
+	}
+	content := req.Messages[0].Content
+	if !strings.HasPrefix(content, "Database schema:\n- public.orders(id integer, total numeric)\n") {
+		t.Errorf("the table the question names is not listed first:\n%s", content)
+	}
+	if !strings.Contains(content, "- reporting.daily_sales (view)(day date)\n") {
+		t.Errorf("view is not marked:\n%s", content)
+	}
+	if !strings.HasSuffix(content, "\nQuestion: What is the total of all orders?") {
+		t.Errorf("question is not last or not trimmed:\n%s", content)
+	}
+}
+
+func TestSchemaContext(t *testing.T) {
+	if got := SchemaContext("anything", nil); got != "(no tables)\n" {
+		t.Errorf("empty schema = %q", got)
+	}
+
+	cat := &sqltools.Catalog{}
+	for i := 0; i < 2000; i++ {
+		cat.Tables = append(cat.Tables, sqltools.CatalogTable{Schema: "public", Name: "table_with_a_long_name",
+			Columns: []sqltools.CatalogColumn{{Name: "id", Type: "integer"}}})
+	}
+	got := SchemaContext("", cat)
+	if len(got) > maxSchemaChars+len("- ... more tables omitted\n") || !strings.HasSuffix(got, "- ... more tables omitted\n") {
+		t.Errorf("schema of %d characters is not truncated", len(got))
+	}
+}
+
+func TestBuildFixPrompt(t *testing.T) {
+	sql := "SELECT id,\n  totl FROM orders"
+	qerr := QueryError{Severity: "ERROR", Code: "42703", Message: `column "totl" does not exist`,
+		Hint: `Perhaps you meant to reference the column "orders.total".`, Position: 14}
+	req := BuildFixPrompt(sql, qerr, testCatalog())
+
+	content := req.Messages[0].Content
+	for _, want := range []string{
+		"Database schema:\n- public.orders(",
+		"Query:\n```sql\n" + sql + "\n```\n",
+		`ERROR: column "totl" does not exist (SQLSTATE 42703)` + "\n",
+		"HINT: Perhaps you meant",
+		"Location:\n  totl FROM orders\n  ^\n",
+	} {
+		if !strings.Contains(content, want) {
+			t.Errorf("prompt lacks %q:\n%s", want, content)
+		}
+	}
+	if req.System != fixSystemPrompt {
+		t.Error("fix prompt uses another system prompt")
+	}
+
+	if marker := errorMarker("SELECT 1", 0); marker != "" {
+		t.Errorf("marker without a position = %q", marker)
+	}
+	if marker := errorMarker("SELECT 'é', x", 13); marker != "SELECT 'é', x\n            ^\n" {
+		t.Errorf("marker counts bytes, not characters: %q", marker)
+	}
+}
+
+func TestExtractSQL(t *testing.T) {
+	tests := []struct {
+		name, content, sql, explanation string
+	}{
+		{"sql fence", "```sql\nSELECT 1;\n```\nReturns one.", "SELECT 1", "Returns one."},
+		{"postgresql fence", "Here:\n```postgresql\nSELECT 2\n```", "SELECT 2", "Here:"},
+		{"bare fence", "```\nSELECT 3;;\n```", "SELECT 3", ""},
+		{"first of two fences", "```sql\nSELECT 4\n```\nor\n```sql\nSELECT 5\n```", "SELECT 4", "or"},
+		{"no fence", "  SELECT 6;  ", "SELECT 6", "SELECT 6;"},
+		{"multiline", "```sql\nSELECT a\nFROM t\nWHERE b;\n```", "SELECT a\nFROM t\nWHERE b", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := ExtractSQL(tt.content); got != tt.sql {
+				t.Errorf("ExtractSQL = %q, want %q", got, tt.sql)
+			}
+			if got := ExtractExplanation(tt.content); got != tt.explanation {
+				t.Errorf("ExtractExplanation = %q, want %q", got, tt.explanation)
+			}
+		})
+	}
+}
+
+func TestMockProvider(t *testing.T) {
+	req := BuildSQLPrompt("how many orders", testCatalog())
+
+	completion, err := NewMockProvider("").Complete(context.Background(), req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := ExtractSQL(completion.Content); got != "SELECT * FROM public.orders LIMIT 100" {
+		t.Errorf("SQL = %q, want a select from the most relevant table", got)
+	}
+	if completion.PromptTokens == 0 || completion.CompletionTokens == 0 {
+		t.Errorf("tokens = %d, %d", completion.PromptTokens, completion.CompletionTokens)
+	}
+
+	fixed, err := NewMockProvider("```sql\nSELECT 1\n```").Complete(context.Background(), req)
+	if err != nil || fixed.Content != "```sql\nSELECT 1\n```" {
+		t.Errorf("fixed response = %+v, %v", fixed, err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := NewMockProvider("").Complete(ctx, req); err == nil {
+		t.Error("answered after the request was cancelled")
+	}
+}
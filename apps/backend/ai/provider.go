@@ -0,0 +1,65 @@
+// Package ai talks to language model providers on behalf of the SQL
+// assistant. Handlers depend only on the Provider interface; which
+// implementation is used is decided once at startup from configuration.
+package ai
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// ErrNotConfigured is returned when no provider has been set up.
+var ErrNotConfigured = errors.New("AI provider is not configured")
+
+type Message struct {
+	Role    string `json:"role"`
+	Content string `json:"content"`
+}
+
+type CompletionRequest struct {
+	System      string
+	Messages    []Message
+	Temperature float64
+	MaxTokens   int
+}
+
+type CompletionResponse struct {
+	Content          string
+	Model            string
+	PromptTokens     int
+	CompletionTokens int
+}
+
+// Provider is a chat-completion backend.
+type Provider interface {
+	Name() string
+	Complete(ctx context.Context, req CompletionRequest) (*CompletionResponse, error)
+}
+
+type Config struct {
+	Provider string // "openai", "mock" or empty to disable
+	BaseURL  string
+	APIKey   string
+	Model    string
+	Timeout  time.Duration
+}
+
+// NewProvider builds the provider named in cfg. An empty provider name
+// returns (nil, nil) so the server can run without AI features.
+func NewProvider(cfg Config) (Provider, error) {
+	switch cfg.Provider {
+	case "":
+		return nil, nil
+	case "openai":
+		if cfg.APIKey == "" {
+			return nil, fmt.Errorf("AI_API_KEY is required for the openai provider")
+		}
+		return NewOpenAIProvider(cfg.BaseURL, cfg.APIKey, cfg.Model, cfg.Timeout), nil
+	case "mock":
+		return NewMockProvider(""), nil
+	default:
+		return nil, fmt.Errorf("unknown AI provider %q", cfg.Provider)
+	}
+}
@@ -0,0 +1,294 @@
+// Package audit keeps an append-only record of security-relevant actions:
+// who did what, to what, from where.
+package audit
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"go-backend/database"
+	"go-backend/middleware"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/rs/zerolog/log"
+)
+
+// Actions recorded in the audit log. Names are "<target>.<verb>" so filters
+// can select a whole family with "<target>.*".
+const (
+	ActionConnectionCreated = "connection.created"
+	ActionConnectionDeleted = "connection.deleted"
+	ActionConnectionTested  = "connection.tested"
+
+	ActionQueryExecuted = "query.executed"
+
+	ActionInvitationSent      = "invitation.sent"
+	ActionInvitationResent    = "invitation.resent"
+	ActionInvitationAccepted  = "invitation.accepted"
+	ActionInvitationCancelled = "invitation.cancelled"
+
+	ActionMemberRoleChanged  = "member.role_changed"
+	ActionMemberSuspended    = "member.suspended"
+	ActionMemberReactivated  = "member.reactivated"
+	ActionMemberRemoved      = "member.removed"
+	ActionOwnershipOffered   = "ownership.transfer_created"
+	ActionOwnershipAccepted  = "ownership.transfer_accepted"
+	ActionOwnershipCancelled = "ownership.transfer_cancelled"
+
+	ActionProjectMemberChanged   = "project_member.role_changed"
+	ActionProjectMemberRemoved   = "project_member.removed"
+	ActionProjectConnectionSet   = "project.connection_linked"
+	ActionProjectConnectionUnset = "project.connection_unlinked"
+	ActionAccessPolicyChanged    = "access_policy.changed"
+	ActionAccessPolicyDeleted    = "access_policy.deleted"
+	ActionMaskingRuleChanged     = "masking_rule.changed"
+	ActionMaskingRuleDeleted     = "masking_rule.deleted"
+
+	ActionPlanChanged          = "organization.plan_changed"
+	ActionPlanOverrideSaved    = "organization.plan_override_saved"
+	ActionPlanOverrideDeleted  = "organization.plan_override_deleted"
+	ActionBillingAnchorChanged = "organization.billing_anchor_changed"
+	ActionPlanSaved            = "plan.saved"
+	ActionPlanDeleted          = "plan.deleted"
+
+	ActionAuditLogExported = "audit_log.exported"
+
+	ActionAPIKeyCreated = "api_key.created"
+	ActionAPIKeyRevoked = "api_key.revoked"
+
+	ActionServiceAccountCreated = "service_account.created"
+	ActionServiceAccountUpdated = "service_account.updated"
+	ActionServiceAccountDeleted = "service_account.deleted"
+
+	ActionDomainAdded    = "domain.added"
+	ActionDomainVerified = "domain.verified"
+	ActionDomainUpdated  = "domain.updated"
+	ActionDomainRemoved  = "domain.removed"
+	ActionMemberJoined   = "member.joined_by_domain"
+
+	ActionSSOConfigured     = "sso.configured"
+	ActionSSODeleted        = "sso.deleted"
+	ActionSSOLogin          = "sso.login"
+	ActionMemberProvisioned = "member.provisioned_by_sso"
+
+	ActionSCIMTokenCreated      = "scim_token.created"
+	ActionSCIMTokenRevoked      = "scim_token.revoked"
+	ActionSCIMGroupCreated      = "scim_group.created"
+	ActionSCIMGroupUpdated      = "scim_group.updated"
+	ActionSCIMGroupMapped       = "scim_group.mapped"
+	ActionSCIMGroupDeleted      = "scim_group.deleted"
+	ActionMemberProvisionedSCIM = "member.provisioned_by_scim"
+	ActionMemberUpdatedSCIM     = "member.updated_by_scim"
+)
+
+// Target types.
+const (
+	TargetConnection     = "connection"
+	TargetInvitation     = "invitation"
+	TargetMember         = "member"
+	TargetOrganization   = "organization"
+	TargetProject        = "project"
+	TargetPolicy         = "access_policy"
+	TargetMaskingRule    = "masking_rule"
+	TargetPlan           = "plan"
+	TargetAPIKey         = "api_key"
+	TargetServiceAccount = "service_account"
+	TargetDomain         = "domain"
+	TargetSSOConnection  = "sso_connection"
+	TargetSCIMToken      = "scim_token"
+	TargetSCIMGroup      = "scim_group"
+)
+
+// Event is an action to record. OrganizationID is empty for actions outside
+// any organization, such as changes to a user's own database connection.
+type Event struct {
+	OrganizationID string
+	Action         string
+	TargetType     string
+	TargetID       string
+	Metadata       map[string]interface{}
+}
+
+// Entry is a recorded event.
+type Entry struct {
+	ID             int64                  `json:"id"`
+	OrganizationID *string                `json:"organization_id"`
+	ActorID        *string                `json:"actor_id"`
+	ActorEmail     *string                `json:"actor_email"`
+	ActorRole      *string                `json:"actor_role"`
+	Action         string                 `json:"action"`
+	TargetType     string                 `json:"target_type"`
+	TargetID       string                 `json:"target_id"`
+	Metadata       map[string]interface{} `json:"metadata"`
+	IPAddress      *string                `json:"ip_address"`
+	UserAgent      *string                `json:"user_agent"`
+	RequestID      *string                `json:"request_id"`
+	CreatedAt      time.Time              `json:"created_at"`
+	PrevHash       *string                `json:"prev_hash"`
+	Hash           *string                `json:"hash"`
+}
+
+type Logger struct {
+	db *database.PostgresDB
+}
+
+func NewLogger(db *database.PostgresDB) *Logger {
+	return &Logger{db: db}
+}
+
+// Record writes an event done by the authenticated user behind r. The
+// action has already happened by the time it is recorded, so a failure to
+// write is logged rather than returned. Events done with an API key name it
+// in their metadata.
+func (l *Logger) Record(r *http.Request, e Event) {
+	var actorID, actorEmail, actorRole *string
+	if claims := middleware.GetUserClaims(r.Context()); claims != nil {
+		actorID, actorEmail, actorRole = nullable(claims.UserID), nullable(claims.Email), nullable(claims.Role)
+		if claims.APIKeyID != "" {
+			metadata := map[string]interface{}{"api_key_id": claims.APIKeyID}
+			for k, v := range e.Metadata {
+				metadata[k] = v
+			}
+			e.Metadata = metadata
+		}
+	}
+	l.insert(r, actorID, actorEmail, actorRole, e)
+}
+
+// RecordAs writes an event done by a user who is not authenticated by the
+// request itself, such as someone accepting an invitation by its token.
+func (l *Logger) RecordAs(r *http.Request, actorID, actorEmail string, e Event) {
+	l.insert(r, nullable(actorID), nullable(actorEmail), nil, e)
+}
+
+// RecordSystem writes an event with no user behind it, such as a billing
+// provider webhook.
+func (l *Logger) RecordSystem(r *http.Request, e Event) {
+	l.insert(r, nil, nil, nullable("system"), e)
+}
+
+func (l *Logger) insert(r *http.Request, actorID, actorEmail, actorRole *string, e Event) {
+	metadata := e.Metadata
+	if metadata == nil {
+		metadata = map[string]interface{}{}
+	}
+	encoded, err := json.Marshal(metadata)
+	if err == nil {
+		metadata, err = normalizeMetadata(encoded)
+	}
+	if err != nil {
+		log.Error().Err(err).Str("action", e.Action).Msg("Failed to encode audit metadata")
+		encoded, metadata = []byte("{}"), map[string]interface{}{}
+	}
+
+	entry := Entry{
+		OrganizationID: nullable(e.OrganizationID),
+		ActorID:        actorID,
+		ActorEmail:     actorEmail,
+		ActorRole:      actorRole,
+		Action:         e.Action,
+		TargetType:     e.TargetType,
+		TargetID:       e.TargetID,
+		Metadata:       metadata,
+		IPAddress:      nullable(clientIP(r)),
+		UserAgent:      nullable(truncateRunes(r.UserAgent(), maxUserAgentLength)),
+		RequestID:      nullable(middleware.GetRequestID(r.Context())),
+		CreatedAt:      time.Now().UTC().Truncate(time.Microsecond),
+	}
+
+	// The request may be cancelled once the response is written; the entry
+	// must still be saved
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if err := l.append(ctx, &entry, encoded); err != nil {
+		log.Error().Err(err).
+			Str("action", e.Action).
+			Str("organization_id", e.OrganizationID).
+			Str("target_id", e.TargetID).
+			Msg("Failed to write audit log entry")
+	}
+}
+
+// maxUserAgentLength is the size of audit_log.user_agent, in characters.
+const maxUserAgentLength = 512
+
+// clientIP is the address the request came from. X-Forwarded-For is set by
+// the client when there is no proxy in front, so a value that is not an IP
+// address falls back to the connection's, rather than failing the insert.
+func clientIP(r *http.Request) string {
+	if ip := net.ParseIP(middleware.ClientIP(r)); ip != nil {
+		return ip.String()
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	if ip := net.ParseIP(host); ip != nil {
+		return ip.String()
+	}
+	return ""
+}
+
+// truncateRunes cuts s to at most n characters without splitting one.
+// Invalid UTF-8, which Postgres would refuse, is replaced first.
+func truncateRunes(s string, n int) string {
+	s = strings.ToValidUTF8(s, "\uFFFD")
+	if utf8.RuneCountInString(s) <= n {
+		return s
+	}
+	return string([]rune(s)[:n])
+}
+
+// append links the entry to the end of its organization's chain and saves
+// it. Appends to one chain are serialized so each entry follows the last one
+// committed.
+func (l *Logger) append(ctx context.Context, e *Entry, encodedMetadata []byte) error {
+	tx, err := l.db.GetPool().Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	orgID := deref(e.OrganizationID)
+	if _, err := tx.Exec(ctx, `SELECT pg_advisory_xact_lock(hashtext('audit_log'), hashtext($1))`, orgID); err != nil {
+		return err
+	}
+
+	// Entries written before chaining have no hash; the chain starts after them
+	var prevHash *string
+	cond, args := chainCondition(orgID)
+	err = tx.QueryRow(ctx, `SELECT hash FROM audit_log WHERE `+cond+` ORDER BY id DESC LIMIT 1`, args...).Scan(&prevHash)
+	if err != nil && !errors.Is(err, pgx.ErrNoRows) {
+		return err
+	}
+	e.PrevHash = nullable(deref(prevHash))
+	hash := ComputeHash(*e, deref(prevHash))
+	e.Hash = &hash
+
+	err = tx.QueryRow(ctx, `
+		INSERT INTO audit_log
+		(organization_id, actor_id, actor_email, actor_role, action, target_type, target_id,
+			metadata, ip_address, user_agent, request_id, created_at, prev_hash, hash)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14)
+		RETURNING id
+	`, e.OrganizationID, e.ActorID, e.ActorEmail, e.ActorRole, e.Action, e.TargetType, e.TargetID,
+		encodedMetadata, e.IPAddress, e.UserAgent, e.RequestID, e.CreatedAt, e.PrevHash, e.Hash).Scan(&e.ID)
+	if err != nil {
+		return err
+	}
+	return tx.Commit(ctx)
+}
+
+func nullable(s string) *string {
+	if s == "" {
+		return nil
+	}
+	return &s
+}
@@ -0,0 +1,66 @@
+package audit
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+func TestClientIP(t *testing.T) {
+	tests := []struct {
+		name       string
+		forwarded  string
+		remoteAddr string
+		want       string
+	}{
+		{"forwarded", "203.0.113.7, 10.0.0.1", "10.0.0.1:4000", "203.0.113.7"},
+		{"forwarded IPv6", "2001:db8::1", "10.0.0.1:4000", "2001:db8::1"},
+		{"connection", "", "198.51.100.2:4000", "198.51.100.2"},
+		{"forwarded garbage", strings.Repeat("x", 300), "198.51.100.2:4000", "198.51.100.2"},
+		{"forwarded hostname", "evil.example.com", "[2001:db8::2]:4000", "2001:db8::2"},
+		{"no address at all", "nonsense", "pipe", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest("GET", "/", nil)
+			r.RemoteAddr = tt.remoteAddr
+			if tt.forwarded != "" {
+				r.Header.Set("X-Forwarded-For", tt.forwarded)
+			}
+			if got := clientIP(r); got != tt.want {
+				t.Errorf("clientIP = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestTruncateRunes(t *testing.T) {
+	tests := []struct {
+		name string
+		in   string
+		n    int
+		want string
+	}{
+		{"short", "curl/8.0", 512, "curl/8.0"},
+		{"ASCII", "abcdef", 3, "abc"},
+		{"multi-byte at the cut", "aé日本", 3, "aé日"},
+		{"invalid UTF-8", "a\xffb", 512, "a�b"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := truncateRunes(tt.in, tt.n)
+			if got != tt.want {
+				t.Errorf("truncateRunes(%q, %d) = %q, want %q", tt.in, tt.n, got, tt.want)
+			}
+			if !utf8.ValidString(got) {
+				t.Errorf("truncateRunes(%q, %d) is not valid UTF-8", tt.in, tt.n)
+			}
+		})
+	}
+
+	long := strings.Repeat("日", maxUserAgentLength+1)
+	if got := truncateRunes(long, maxUserAgentLength); utf8.RuneCountInString(got) != maxUserAgentLength {
+		t.Errorf("kept %d characters", utf8.RuneCountInString(got))
+	}
+}
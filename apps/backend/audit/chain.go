@@ -0,0 +1,290 @@
+package audit
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"time"
+)
+
+// Each organization's entries form a hash chain: an entry's hash covers its
+// content and the hash of the organization's previous entry, so editing,
+// removing or reordering an entry breaks every hash after it. Entries outside
+// any organization form a chain of their own.
+
+// chainedEntry is the content an entry's hash covers. Field order and
+// encoding must never change, or existing chains stop verifying.
+type chainedEntry struct {
+	PrevHash       string                 `json:"prev_hash"`
+	OrganizationID string                 `json:"organization_id"`
+	ActorID        string                 `json:"actor_id"`
+	ActorEmail     string                 `json:"actor_email"`
+	ActorRole      string                 `json:"actor_role"`
+	Action         string                 `json:"action"`
+	TargetType     string                 `json:"target_type"`
+	TargetID       string                 `json:"target_id"`
+	Metadata       map[string]interface{} `json:"metadata"`
+	IPAddress      string                 `json:"ip_address"`
+	UserAgent      string                 `json:"user_agent"`
+	RequestID      string                 `json:"request_id"`
+	CreatedAt      string                 `json:"created_at"`
+}
+
+// ComputeHash returns the hash e should have given the hash of the entry
+// before it in its chain.
+func ComputeHash(e Entry, prevHash string) string {
+	metadata := e.Metadata
+	if metadata == nil {
+		metadata = map[string]interface{}{}
+	}
+	encoded, _ := json.Marshal(chainedEntry{
+		PrevHash:       prevHash,
+		OrganizationID: deref(e.OrganizationID),
+		ActorID:        deref(e.ActorID),
+		ActorEmail:     deref(e.ActorEmail),
+		ActorRole:      deref(e.ActorRole),
+		Action:         e.Action,
+		TargetType:     e.TargetType,
+		TargetID:       e.TargetID,
+		Metadata:       metadata,
+		IPAddress:      deref(e.IPAddress),
+		UserAgent:      deref(e.UserAgent),
+		RequestID:      deref(e.RequestID),
+		CreatedAt:      chainTime(e.CreatedAt),
+	})
+	sum := sha256.Sum256(encoded)
+	return hex.EncodeToString(sum[:])
+}
+
+// chainTime formats t the way it reads back from the database, which keeps
+// microseconds.
+func chainTime(t time.Time) string {
+	return t.UTC().Truncate(time.Microsecond).Format(time.RFC3339Nano)
+}
+
+// normalizeMetadata returns metadata as it will read back from the JSONB
+// column, so the hash computed on insert matches the one computed on
+// verification.
+func normalizeMetadata(encoded []byte) (map[string]interface{}, error) {
+	metadata := map[string]interface{}{}
+	if err := json.Unmarshal(encoded, &metadata); err != nil {
+		return nil, err
+	}
+	return metadata, nil
+}
+
+func deref(s *string) string {
+	if s == nil {
+		return ""
+	}
+	return *s
+}
+
+// Reasons a chain fails verification.
+const (
+	BreakHashMismatch     = "hash_mismatch"     // the entry was changed after it was written
+	BreakChainBroken      = "chain_broken"      // an entry before it was removed, reordered or changed
+	BreakMissingHash      = "missing_hash"      // the entry was written around the chain
+	BreakCheckpointMissed = "checkpoint_missed" // the entry a checkpoint covers is gone
+	BreakCheckpointHash   = "checkpoint_hash"   // the entry differs from the one a checkpoint covers
+	BreakBadSignature     = "bad_signature"     // a checkpoint was not signed by the signing key
+)
+
+// maxBreaks caps the breaks listed in one verification.
+const maxBreaks = 100
+
+// Break is one place a chain fails verification.
+type Break struct {
+	Reason       string `json:"reason"`
+	EntryID      int64  `json:"entry_id,omitempty"`
+	CheckpointID int64  `json:"checkpoint_id,omitempty"`
+	Expected     string `json:"expected,omitempty"`
+	Actual       string `json:"actual,omitempty"`
+}
+
+// Verification is the outcome of walking a chain.
+type Verification struct {
+	OrganizationID string `json:"organization_id,omitempty"`
+	Valid          bool   `json:"valid"`
+	// Entries counts the chained entries checked. Entries written before
+	// chaining was introduced are counted in UnchainedEntries instead.
+	Entries          int64  `json:"entries"`
+	UnchainedEntries int64  `json:"unchained_entries"`
+	FirstEntryID     int64  `json:"first_entry_id,omitempty"`
+	LastEntryID      int64  `json:"last_entry_id,omitempty"`
+	LastHash         string `json:"last_hash,omitempty"`
+	// CheckpointsChecked counts the checkpoints whose entries were compared;
+	// UnverifiedSignatures counts those signed by a key not at hand.
+	CheckpointsChecked   int       `json:"checkpoints_checked"`
+	UnverifiedSignatures int       `json:"unverified_signatures"`
+	Breaks               []Break   `json:"breaks"`
+	BreaksTruncated      bool      `json:"breaks_truncated,omitempty"`
+	VerifiedAt           time.Time `json:"verified_at"`
+}
+
+// Verifier checks the entries of one chain, fed to it in id order, against
+// their hashes and the chain's checkpoints.
+type Verifier struct {
+	result      Verification
+	checkpoints []Checkpoint
+	next        int
+	prevHash    string
+	chained     bool
+	// partial means the entries start mid-chain, so the first entry's
+	// prev_hash is taken on trust and earlier checkpoints are skipped.
+	partial bool
+}
+
+// NewVerifier checks checkpoint signatures against key, when given, and
+// returns a verifier for the chain they belong to.
+func NewVerifier(checkpoints []Checkpoint, key ed25519.PublicKey) *Verifier {
+	v := &Verifier{checkpoints: append([]Checkpoint(nil), checkpoints...)}
+	sort.Slice(v.checkpoints, func(i, j int) bool {
+		return v.checkpoints[i].LastEntryID < v.checkpoints[j].LastEntryID
+	})
+
+	keyID := ""
+	if key != nil {
+		keyID = KeyID(key)
+	}
+	for _, c := range v.checkpoints {
+		switch {
+		case keyID == "" || c.KeyID != keyID:
+			v.result.UnverifiedSignatures++
+		case !c.Verify(key):
+			v.addBreak(Break{Reason: BreakBadSignature, CheckpointID: c.ID, EntryID: c.LastEntryID})
+		}
+	}
+	return v
+}
+
+// Partial lets the entries start mid-chain, as in a filtered export.
+func (v *Verifier) Partial() *Verifier {
+	v.partial = true
+	return v
+}
+
+func (v *Verifier) addBreak(b Break) {
+	if len(v.result.Breaks) >= maxBreaks {
+		v.result.BreaksTruncated = true
+		return
+	}
+	v.result.Breaks = append(v.result.Breaks, b)
+}
+
+// Add checks the next entry of the chain.
+func (v *Verifier) Add(e Entry) {
+	if e.Hash == nil {
+		if v.chained {
+			v.addBreak(Break{Reason: BreakMissingHash, EntryID: e.ID})
+		} else {
+			v.result.UnchainedEntries++
+		}
+		v.passCheckpoints(e.ID, nil)
+		return
+	}
+
+	prevHash := deref(e.PrevHash)
+	switch {
+	case !v.chained && v.partial:
+		// Nothing to compare the first entry's link with
+	case prevHash != v.prevHash:
+		v.addBreak(Break{Reason: BreakChainBroken, EntryID: e.ID, Expected: v.prevHash, Actual: prevHash})
+	}
+	if !v.chained {
+		v.chained = true
+		v.result.FirstEntryID = e.ID
+	}
+
+	if want := ComputeHash(e, prevHash); want != *e.Hash {
+		v.addBreak(Break{Reason: BreakHashMismatch, EntryID: e.ID, Expected: want, Actual: *e.Hash})
+	}
+
+	v.prevHash = *e.Hash
+	v.result.Entries++
+	v.result.LastEntryID = e.ID
+	v.result.LastHash = *e.Hash
+	v.passCheckpoints(e.ID, e.Hash)
+}
+
+// passCheckpoints compares the checkpoints up to entry id with it.
+func (v *Verifier) passCheckpoints(id int64, hash *string) {
+	for ; v.next < len(v.checkpoints) && v.checkpoints[v.next].LastEntryID <= id; v.next++ {
+		c := v.checkpoints[v.next]
+		if v.partial && (v.result.FirstEntryID == 0 || c.LastEntryID < v.result.FirstEntryID) {
+			continue
+		}
+		v.result.CheckpointsChecked++
+		switch {
+		case c.LastEntryID < id:
+			v.addBreak(Break{Reason: BreakCheckpointMissed, CheckpointID: c.ID, EntryID: c.LastEntryID, Expected: c.LastHash})
+		case hash == nil || *hash != c.LastHash:
+			v.addBreak(Break{Reason: BreakCheckpointHash, CheckpointID: c.ID, EntryID: id, Expected: c.LastHash, Actual: deref(hash)})
+		}
+	}
+}
+
+// Finish reports the verification. Checkpoints past the last entry mean
+// entries were removed from the end of the chain, unless the entries are
+// partial.
+func (v *Verifier) Finish() *Verification {
+	if !v.partial {
+		for ; v.next < len(v.checkpoints); v.next++ {
+			c := v.checkpoints[v.next]
+			v.result.CheckpointsChecked++
+			v.addBreak(Break{Reason: BreakCheckpointMissed, CheckpointID: c.ID, EntryID: c.LastEntryID, Expected: c.LastHash})
+		}
+	}
+
+	v.result.Valid = len(v.result.Breaks) == 0
+	if v.result.Breaks == nil {
+		v.result.Breaks = []Break{}
+	}
+	v.result.VerifiedAt = time.Now().UTC()
+	return &v.result
+}
+
+// Verify walks the chain of the organization, or of entries outside any
+// organization when orgID is empty, and checks it against the stored
+// checkpoints and key.
+func (l *Logger) Verify(ctx context.Context, orgID string, key ed25519.PublicKey) (*Verification, error) {
+	checkpoints, err := l.Checkpoints(ctx, orgID, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	v := NewVerifier(checkpoints, key)
+	cond, args := chainCondition(orgID)
+	rows, err := l.db.Query(ctx, `SELECT `+entryColumns+` FROM audit_log WHERE `+cond+` ORDER BY id`, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read audit chain: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		e, err := scanEntry(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read audit chain: %w", err)
+		}
+		v.Add(e)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read audit chain: %w", err)
+	}
+
+	result := v.Finish()
+	result.OrganizationID = orgID
+	return result, nil
+}
+
+// chainCondition selects the entries of one chain.
+func chainCondition(orgID string) (string, []interface{}) {
+	if orgID == "" {
+		return "organization_id IS NULL", nil
+	}
+	return "organization_id = $1", []interface{}{orgID}
+}
@@ -0,0 +1,345 @@
+package audit
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"math"
+	"reflect"
+	"testing"
+	"time"
+)
+
+var testOrg = "o1"
+
+// testChain returns n entries of one organization's chain, ids 1 to n,
+// hashed the way insert hashes them.
+func testChain(n int) []Entry {
+	start := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	entries := make([]Entry, n)
+	prev := ""
+	for i := range entries {
+		e := Entry{
+			ID:             int64(i + 1),
+			OrganizationID: &testOrg,
+			Action:         "project.updated",
+			TargetType:     "project",
+			TargetID:       "p1",
+			Metadata:       map[string]interface{}{"step": float64(i)},
+			CreatedAt:      start.Add(time.Duration(i) * time.Minute),
+		}
+		hash := ComputeHash(e, prev)
+		if prev != "" {
+			e.PrevHash = stringPtr(prev)
+		}
+		e.Hash = &hash
+		entries[i] = e
+		prev = hash
+	}
+	return entries
+}
+
+func stringPtr(s string) *string { return &s }
+
+func newTestKey(t *testing.T) (ed25519.PublicKey, ed25519.PrivateKey) {
+	t.Helper()
+	public, private, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return public, private
+}
+
+// signCheckpoint returns a checkpoint over e signed with key.
+func signCheckpoint(id int64, e Entry, key ed25519.PrivateKey) Checkpoint {
+	c := Checkpoint{
+		ID:             id,
+		OrganizationID: e.OrganizationID,
+		LastEntryID:    e.ID,
+		LastHash:       *e.Hash,
+		KeyID:          KeyID(key.Public().(ed25519.PublicKey)),
+		CreatedAt:      e.CreatedAt.Add(time.Minute),
+	}
+	c.Signature = base64.StdEncoding.EncodeToString(ed25519.Sign(key, c.payload()))
+	return c
+}
+
+func verify(entries []Entry, checkpoints []Checkpoint, key ed25519.PublicKey, partial bool) *Verification {
+	v := NewVerifier(checkpoints, key)
+	if partial {
+		v.Partial()
+	}
+	for _, e := range entries {
+		v.Add(e)
+	}
+	return v.Finish()
+}
+
+func breakReasons(result *Verification) []string {
+	reasons := []string{}
+	for _, b := range result.Breaks {
+		reasons = append(reasons, b.Reason)
+	}
+	return reasons
+}
+
+func TestVerifierBreaks(t *testing.T) {
+	public, private := newTestKey(t)
+
+	tests := []struct {
+		name string
+		// edit returns the entries and checkpoints to verify, given an
+		// intact chain of five entries
+		edit func(entries []Entry) ([]Entry, []Checkpoint)
+		want []string
+	}{
+		{
+			"intact",
+			func(entries []Entry) ([]Entry, []Checkpoint) {
+				return entries, []Checkpoint{signCheckpoint(1, entries[2], private), signCheckpoint(2, entries[4], private)}
+			},
+			[]string{},
+		},
+		{
+			"entry changed",
+			func(entries []Entry) ([]Entry, []Checkpoint) {
+				entries[2].Action = "project.deleted"
+				return entries, nil
+			},
+			[]string{BreakHashMismatch},
+		},
+		{
+			"entry removed",
+			func(entries []Entry) ([]Entry, []Checkpoint) {
+				return append(entries[:2:2], entries[3:]...), nil
+			},
+			[]string{BreakChainBroken},
+		},
+		{
+			"entries reordered",
+			func(entries []Entry) ([]Entry, []Checkpoint) {
+				entries[1], entries[2] = entries[2], entries[1]
+				return entries, nil
+			},
+			[]string{BreakChainBroken, BreakChainBroken, BreakChainBroken},
+		},
+		{
+			"entry written around the chain",
+			func(entries []Entry) ([]Entry, []Checkpoint) {
+				entries[3].Hash, entries[3].PrevHash = nil, nil
+				return entries, nil
+			},
+			[]string{BreakMissingHash, BreakChainBroken},
+		},
+		{
+			"unchained entries before the chain",
+			func(entries []Entry) ([]Entry, []Checkpoint) {
+				old := Entry{ID: 0, Action: "project.created"}
+				return append([]Entry{old}, entries...), nil
+			},
+			[]string{},
+		},
+		{
+			"checkpointed entry removed",
+			func(entries []Entry) ([]Entry, []Checkpoint) {
+				checkpoints := []Checkpoint{signCheckpoint(1, entries[2], private)}
+				return append(entries[:2:2], entries[3:]...), checkpoints
+			},
+			[]string{BreakChainBroken, BreakCheckpointMissed},
+		},
+		{
+			"entries removed from the end",
+			func(entries []Entry) ([]Entry, []Checkpoint) {
+				return entries[:3], []Checkpoint{signCheckpoint(1, entries[4], private)}
+			},
+			[]string{BreakCheckpointMissed},
+		},
+		{
+			"checkpointed entry differs",
+			func(entries []Entry) ([]Entry, []Checkpoint) {
+				c := signCheckpoint(1, entries[2], private)
+				rewritten := testChain(5)
+				rewritten[2].TargetID = "p2"
+				prev := *rewritten[1].Hash
+				for i := 2; i < len(rewritten); i++ {
+					hash := ComputeHash(rewritten[i], prev)
+					rewritten[i].PrevHash, rewritten[i].Hash = stringPtr(prev), &hash
+					prev = hash
+				}
+				return rewritten, []Checkpoint{c}
+			},
+			[]string{BreakCheckpointHash},
+		},
+		{
+			"checkpoint tampered with",
+			func(entries []Entry) ([]Entry, []Checkpoint) {
+				c := signCheckpoint(1, entries[2], private)
+				c.LastEntryID = 4
+				c.LastHash = *entries[3].Hash
+				return entries, []Checkpoint{c}
+			},
+			[]string{BreakBadSignature},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			entries, checkpoints := tt.edit(testChain(5))
+			result := verify(entries, checkpoints, public, false)
+			if got := breakReasons(result); !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("breaks = %v, want %v", got, tt.want)
+			}
+			if result.Valid != (len(tt.want) == 0) {
+				t.Errorf("valid = %v with breaks %v", result.Valid, tt.want)
+			}
+		})
+	}
+}
+
+func TestVerifierCapsBreaks(t *testing.T) {
+	entries := testChain(maxBreaks + 10)
+	for i := range entries {
+		entries[i].Action = "tampered"
+	}
+	result := verify(entries, nil, nil, false)
+	if len(result.Breaks) != maxBreaks || !result.BreaksTruncated {
+		t.Errorf("breaks = %d, truncated = %v", len(result.Breaks), result.BreaksTruncated)
+	}
+}
+
+func TestVerifierPartial(t *testing.T) {
+	public, private := newTestKey(t)
+	entries := testChain(6)
+	checkpoints := []Checkpoint{
+		signCheckpoint(1, entries[1], private),
+		signCheckpoint(2, entries[3], private),
+		signCheckpoint(3, entries[5], private),
+	}
+
+	tests := []struct {
+		name        string
+		entries     []Entry
+		want        []string
+		checkpoints int
+	}{
+		{"middle of the chain", entries[2:5], []string{}, 1},
+		{"tail of the chain", entries[2:], []string{}, 2},
+		{"gap inside the export", append(append([]Entry{}, entries[2]), entries[4:]...), []string{BreakChainBroken, BreakCheckpointMissed}, 2},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result := verify(tt.entries, checkpoints, public, true)
+			if got := breakReasons(result); !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("breaks = %v, want %v", got, tt.want)
+			}
+			if result.CheckpointsChecked != tt.checkpoints {
+				t.Errorf("checkpoints checked = %d, want %d", result.CheckpointsChecked, tt.checkpoints)
+			}
+			if result.FirstEntryID != tt.entries[0].ID {
+				t.Errorf("first entry = %d, want %d", result.FirstEntryID, tt.entries[0].ID)
+			}
+		})
+	}
+
+	if result := verify(entries[2:5], checkpoints, public, false); result.Valid {
+		t.Error("an export starting mid-chain verified as a whole chain")
+	}
+}
+
+func TestComputeHashSurvivesJSONB(t *testing.T) {
+	type detail struct {
+		Name  string `json:"name"`
+		Count int    `json:"count"`
+	}
+	raw := map[string]interface{}{
+		"big":    uint64(math.MaxUint64),
+		"ratio":  0.1,
+		"detail": detail{Name: "orders", Count: 3},
+		"tags":   []string{"b", "a"},
+		"none":   nil,
+	}
+	encoded, err := json.Marshal(raw)
+	if err != nil {
+		t.Fatal(err)
+	}
+	normalized, err := normalizeMetadata(encoded)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// What the JSONB column hands back
+	var stored map[string]interface{}
+	if err := json.Unmarshal(encoded, &stored); err != nil {
+		t.Fatal(err)
+	}
+
+	created := time.Date(2026, 3, 1, 12, 0, 0, 123456789, time.FixedZone("CET", 3600))
+	written := Entry{OrganizationID: &testOrg, Action: "sql.executed", Metadata: normalized, CreatedAt: created}
+	read := Entry{OrganizationID: &testOrg, Action: "sql.executed", Metadata: stored, CreatedAt: created.UTC().Truncate(time.Microsecond)}
+
+	if ComputeHash(written, "prev") != ComputeHash(read, "prev") {
+		t.Error("hash changed on the way through the database")
+	}
+	written.Metadata = raw
+	if ComputeHash(written, "prev") == ComputeHash(read, "prev") {
+		t.Error("unnormalized metadata hashed like what reads back; the test no longer covers normalization")
+	}
+	if ComputeHash(read, "prev") == ComputeHash(read, "other") {
+		t.Error("hash does not cover the previous hash")
+	}
+	if ComputeHash(Entry{}, "") != ComputeHash(Entry{Metadata: map[string]interface{}{}}, "") {
+		t.Error("nil and empty metadata hash differently")
+	}
+}
+
+func TestCheckpointVerify(t *testing.T) {
+	public, private := newTestKey(t)
+	otherPublic, _ := newTestKey(t)
+	entry := testChain(1)[0]
+
+	tests := []struct {
+		name string
+		edit func(*Checkpoint)
+		key  ed25519.PublicKey
+		want bool
+	}{
+		{"signed by key", func(*Checkpoint) {}, public, true},
+		{"other key", func(*Checkpoint) {}, otherPublic, false},
+		{"hash changed", func(c *Checkpoint) { c.LastHash = "x" + c.LastHash[1:] }, public, false},
+		{"entry changed", func(c *Checkpoint) { c.LastEntryID++ }, public, false},
+		{"organization changed", func(c *Checkpoint) { c.OrganizationID = nil }, public, false},
+		{"time changed", func(c *Checkpoint) { c.CreatedAt = c.CreatedAt.Add(time.Second) }, public, false},
+		{"time read back in another zone", func(c *Checkpoint) { c.CreatedAt = c.CreatedAt.In(time.FixedZone("CET", 3600)) }, public, true},
+		{"signature not base64", func(c *Checkpoint) { c.Signature = "not base64!" }, public, false},
+		{"signature empty", func(c *Checkpoint) { c.Signature = "" }, public, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := signCheckpoint(1, entry, private)
+			tt.edit(&c)
+			if got := c.Verify(tt.key); got != tt.want {
+				t.Errorf("Verify = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestVerifierCountsCheckpointsOfOtherKeys(t *testing.T) {
+	public, private := newTestKey(t)
+	otherPublic, _ := newTestKey(t)
+	entries := testChain(2)
+	checkpoints := []Checkpoint{signCheckpoint(1, entries[1], private)}
+
+	for name, key := range map[string]ed25519.PublicKey{"no key": nil, "other key": otherPublic} {
+		result := verify(entries, checkpoints, key, false)
+		if !result.Valid || result.UnverifiedSignatures != 1 {
+			t.Errorf("%s: valid = %v, unverified = %d", name, result.Valid, result.UnverifiedSignatures)
+		}
+	}
+	if result := verify(entries, checkpoints, public, false); result.UnverifiedSignatures != 0 {
+		t.Errorf("unverified = %d with the signing key", result.UnverifiedSignatures)
+	}
+}
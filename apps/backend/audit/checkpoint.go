@@ -0,0 +1,259 @@
+package audit
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"go-backend/database"
+
+	"github.com/rs/zerolog/log"
+)
+
+// checkpointSettle keeps a checkpoint off entries written in the last few
+// seconds, whose transactions may not have committed yet.
+const checkpointSettle = 30 * time.Second
+
+// Checkpoint is a signed statement of a chain's last entry and hash at a
+// point in time. Once a checkpoint has left the database, removing entries
+// from the end of its chain can no longer go unnoticed.
+type Checkpoint struct {
+	ID             int64     `json:"id"`
+	OrganizationID *string   `json:"organization_id"`
+	LastEntryID    int64     `json:"last_entry_id"`
+	LastHash       string    `json:"last_hash"`
+	KeyID          string    `json:"key_id"`
+	Signature      string    `json:"signature"`
+	CreatedAt      time.Time `json:"created_at"`
+}
+
+// signedCheckpoint is the content a checkpoint's signature covers. Field
+// order and encoding must never change.
+type signedCheckpoint struct {
+	OrganizationID string `json:"organization_id"`
+	LastEntryID    int64  `json:"last_entry_id"`
+	LastHash       string `json:"last_hash"`
+	KeyID          string `json:"key_id"`
+	CreatedAt      string `json:"created_at"`
+}
+
+func (c *Checkpoint) payload() []byte {
+	encoded, _ := json.Marshal(signedCheckpoint{
+		OrganizationID: deref(c.OrganizationID),
+		LastEntryID:    c.LastEntryID,
+		LastHash:       c.LastHash,
+		KeyID:          c.KeyID,
+		CreatedAt:      chainTime(c.CreatedAt),
+	})
+	return encoded
+}
+
+// Verify reports whether key signed the checkpoint.
+func (c *Checkpoint) Verify(key ed25519.PublicKey) bool {
+	signature, err := base64.StdEncoding.DecodeString(c.Signature)
+	if err != nil {
+		return false
+	}
+	return ed25519.Verify(key, c.payload(), signature)
+}
+
+// KeyID names a signing key by its public half.
+func KeyID(key ed25519.PublicKey) string {
+	sum := sha256.Sum256(key)
+	return hex.EncodeToString(sum[:8])
+}
+
+// ParseSigningKey reads a base64 Ed25519 private key, either the 32-byte
+// seed or the 64-byte expanded form.
+func ParseSigningKey(encoded string) (ed25519.PrivateKey, error) {
+	raw, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, fmt.Errorf("signing key is not valid base64: %w", err)
+	}
+	switch len(raw) {
+	case ed25519.SeedSize:
+		return ed25519.NewKeyFromSeed(raw), nil
+	case ed25519.PrivateKeySize:
+		return ed25519.PrivateKey(raw), nil
+	}
+	return nil, fmt.Errorf("signing key must be a %d-byte seed or %d-byte private key", ed25519.SeedSize, ed25519.PrivateKeySize)
+}
+
+// ParsePublicKey reads a base64 Ed25519 public key.
+func ParsePublicKey(encoded string) (ed25519.PublicKey, error) {
+	raw, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, fmt.Errorf("public key is not valid base64: %w", err)
+	}
+	if len(raw) != ed25519.PublicKeySize {
+		return nil, fmt.Errorf("public key must be %d bytes", ed25519.PublicKeySize)
+	}
+	return ed25519.PublicKey(raw), nil
+}
+
+// Checkpoints returns the chain's checkpoints, newest first, up to limit
+// when it is positive.
+func (l *Logger) Checkpoints(ctx context.Context, orgID string, limit int) ([]Checkpoint, error) {
+	cond, args := chainCondition(orgID)
+	sql := `
+		SELECT id, organization_id, last_entry_id, last_hash, key_id, signature, created_at
+		FROM audit_checkpoints WHERE ` + cond + ` ORDER BY id DESC`
+	if limit > 0 {
+		args = append(args, limit)
+		sql += fmt.Sprintf(" LIMIT $%d", len(args))
+	}
+
+	rows, err := l.db.Query(ctx, sql, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch audit checkpoints: %w", err)
+	}
+	defer rows.Close()
+
+	checkpoints := []Checkpoint{}
+	for rows.Next() {
+		var c Checkpoint
+		if err := rows.Scan(&c.ID, &c.OrganizationID, &c.LastEntryID, &c.LastHash, &c.KeyID, &c.Signature, &c.CreatedAt); err != nil {
+			return nil, fmt.Errorf("failed to fetch audit checkpoints: %w", err)
+		}
+		checkpoints = append(checkpoints, c)
+	}
+	return checkpoints, rows.Err()
+}
+
+// Checkpointer signs checkpoints for every chain with new entries and
+// appends them to a file kept away from the database.
+type Checkpointer struct {
+	db   *database.PostgresDB
+	key  ed25519.PrivateKey
+	file string
+	mu   sync.Mutex
+}
+
+// NewCheckpointer signs with key and, when file is set, appends each
+// checkpoint to it as a line of JSON.
+func NewCheckpointer(db *database.PostgresDB, key ed25519.PrivateKey, file string) *Checkpointer {
+	return &Checkpointer{db: db, key: key, file: file}
+}
+
+// PublicKey is the key checkpoints verify against.
+func (c *Checkpointer) PublicKey() ed25519.PublicKey {
+	return c.key.Public().(ed25519.PublicKey)
+}
+
+// Run writes a checkpoint for each chain with entries newer than the last
+// checkpoint and returns them.
+func (c *Checkpointer) Run(ctx context.Context) ([]Checkpoint, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	var watermark int64
+	if err := c.db.QueryRow(ctx, `SELECT COALESCE(MAX(last_entry_id), 0) FROM audit_checkpoints`).Scan(&watermark); err != nil {
+		return nil, fmt.Errorf("failed to read checkpoint watermark: %w", err)
+	}
+
+	rows, err := c.db.Query(ctx, `
+		SELECT a.organization_id, a.id, a.hash
+		FROM audit_log a
+		JOIN (
+			SELECT MAX(id) AS id FROM audit_log
+			WHERE id > $1 AND hash IS NOT NULL AND created_at < $2
+			GROUP BY organization_id
+		) latest ON latest.id = a.id
+		ORDER BY a.id
+	`, watermark, time.Now().Add(-checkpointSettle))
+	if err != nil {
+		return nil, fmt.Errorf("failed to find chains to checkpoint: %w", err)
+	}
+
+	keyID := KeyID(c.PublicKey())
+	now := time.Now().UTC().Truncate(time.Microsecond)
+	var checkpoints []Checkpoint
+	for rows.Next() {
+		cp := Checkpoint{KeyID: keyID, CreatedAt: now}
+		if err := rows.Scan(&cp.OrganizationID, &cp.LastEntryID, &cp.LastHash); err != nil {
+			rows.Close()
+			return nil, fmt.Errorf("failed to find chains to checkpoint: %w", err)
+		}
+		cp.Signature = base64.StdEncoding.EncodeToString(ed25519.Sign(c.key, cp.payload()))
+		checkpoints = append(checkpoints, cp)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to find chains to checkpoint: %w", err)
+	}
+
+	for i := range checkpoints {
+		cp := &checkpoints[i]
+		err := c.db.QueryRow(ctx, `
+			INSERT INTO audit_checkpoints (organization_id, last_entry_id, last_hash, key_id, signature, created_at)
+			VALUES ($1, $2, $3, $4, $5, $6)
+			RETURNING id
+		`, cp.OrganizationID, cp.LastEntryID, cp.LastHash, cp.KeyID, cp.Signature, cp.CreatedAt).Scan(&cp.ID)
+		if err != nil {
+			return checkpoints[:i], fmt.Errorf("failed to save audit checkpoint: %w", err)
+		}
+	}
+
+	if err := c.appendToFile(checkpoints); err != nil {
+		return checkpoints, err
+	}
+	return checkpoints, nil
+}
+
+func (c *Checkpointer) appendToFile(checkpoints []Checkpoint) error {
+	if c.file == "" || len(checkpoints) == 0 {
+		return nil
+	}
+
+	f, err := os.OpenFile(c.file, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o600)
+	if err != nil {
+		return fmt.Errorf("failed to open checkpoint file: %w", err)
+	}
+	enc := json.NewEncoder(f)
+	for _, cp := range checkpoints {
+		if err := enc.Encode(cp); err != nil {
+			f.Close()
+			return fmt.Errorf("failed to write checkpoint file: %w", err)
+		}
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return fmt.Errorf("failed to write checkpoint file: %w", err)
+	}
+	return f.Close()
+}
+
+// Start calls Run every interval until the returned stop function is called.
+func (c *Checkpointer) Start(interval time.Duration) (stop func()) {
+	ticker := time.NewTicker(interval)
+	done := make(chan struct{})
+
+	go func() {
+		for {
+			select {
+			case <-ticker.C:
+				ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+				checkpoints, err := c.Run(ctx)
+				cancel()
+				if err != nil {
+					log.Error().Err(err).Msg("Failed to write audit checkpoints")
+				} else if len(checkpoints) > 0 {
+					log.Info().Int("checkpoints", len(checkpoints)).Msg("Audit checkpoints written")
+				}
+			case <-done:
+				ticker.Stop()
+				return
+			}
+		}
+	}()
+
+	var once sync.Once
+	return func() { once.Do(func() { close(done) }) }
+}
@@ -0,0 +1,133 @@
+package audit
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// MaxPageSize caps a page of audit entries.
+const MaxPageSize = 500
+
+// Filter selects audit entries. Empty fields match everything. Action
+// matches exactly, or a whole family when it ends in ".*".
+type Filter struct {
+	OrganizationID string
+	ActorID        string
+	ActorRole      string
+	Action         string
+	TargetType     string
+	TargetID       string
+	From           *time.Time
+	To             *time.Time
+	// Before returns entries older than the entry with this ID, for paging
+	// back from the newest.
+	Before int64
+	Limit  int
+}
+
+func (f Filter) where() (string, []interface{}) {
+	var conds []string
+	var args []interface{}
+	add := func(cond string, arg interface{}) {
+		args = append(args, arg)
+		conds = append(conds, fmt.Sprintf(cond, len(args)))
+	}
+
+	if f.OrganizationID != "" {
+		add("organization_id = $%d", f.OrganizationID)
+	}
+	if f.ActorID != "" {
+		add("actor_id = $%d", f.ActorID)
+	}
+	if f.ActorRole != "" {
+		add("actor_role = $%d", f.ActorRole)
+	}
+	if f.Action != "" {
+		if family, ok := strings.CutSuffix(f.Action, ".*"); ok {
+			add("action LIKE $%d", escapeLike(family)+".%")
+		} else {
+			add("action = $%d", f.Action)
+		}
+	}
+	if f.TargetType != "" {
+		add("target_type = $%d", f.TargetType)
+	}
+	if f.TargetID != "" {
+		add("target_id = $%d", f.TargetID)
+	}
+	if f.From != nil {
+		add("created_at >= $%d", *f.From)
+	}
+	if f.To != nil {
+		add("created_at < $%d", *f.To)
+	}
+	if f.Before > 0 {
+		add("id < $%d", f.Before)
+	}
+
+	if len(conds) == 0 {
+		return "", args
+	}
+	return "WHERE " + strings.Join(conds, " AND "), args
+}
+
+func escapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(s)
+}
+
+const entryColumns = `id, organization_id, actor_id, actor_email, actor_role, action, target_type, target_id,
+	metadata, ip_address, user_agent, request_id, created_at, prev_hash, hash`
+
+func scanEntry(row pgx.Row) (Entry, error) {
+	var e Entry
+	err := row.Scan(&e.ID, &e.OrganizationID, &e.ActorID, &e.ActorEmail, &e.ActorRole, &e.Action,
+		&e.TargetType, &e.TargetID, &e.Metadata, &e.IPAddress, &e.UserAgent, &e.RequestID, &e.CreatedAt,
+		&e.PrevHash, &e.Hash)
+	return e, err
+}
+
+// Query returns a page of entries matching f, newest first.
+func (l *Logger) Query(ctx context.Context, f Filter) ([]Entry, error) {
+	if f.Limit <= 0 || f.Limit > MaxPageSize {
+		f.Limit = MaxPageSize
+	}
+
+	entries := []Entry{}
+	err := l.Each(ctx, f, func(e Entry) error {
+		entries = append(entries, e)
+		return nil
+	})
+	return entries, err
+}
+
+// Each calls fn for every entry matching f, newest first, stopping at the
+// first error. A zero Limit means no limit.
+func (l *Logger) Each(ctx context.Context, f Filter, fn func(Entry) error) error {
+	where, args := f.where()
+	sql := `SELECT ` + entryColumns + ` FROM audit_log ` + where + ` ORDER BY id DESC`
+	if f.Limit > 0 {
+		args = append(args, f.Limit)
+		sql += fmt.Sprintf(" LIMIT $%d", len(args))
+	}
+
+	rows, err := l.db.Query(ctx, sql, args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		e, err := scanEntry(rows)
+		if err != nil {
+			return err
+		}
+		if err := fn(e); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
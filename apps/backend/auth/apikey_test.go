@@ -0,0 +1,58 @@
+package auth
+
+import (
+	"testing"
+	"time"
+)
+
+func TestAPIKeyUsable(t *testing.T) {
+	now := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	at := func(d time.Duration) *time.Time {
+		v := now.Add(d)
+		return &v
+	}
+
+	tests := []struct {
+		name      string
+		revokedAt *time.Time
+		expiresAt *time.Time
+		member    bool
+		want      bool
+	}{
+		{"no expiry", nil, nil, true, true},
+		{"expires later", nil, at(time.Hour), true, true},
+		{"revoked", at(-time.Hour), nil, true, false},
+		{"revoked before it expires", at(-time.Hour), at(time.Hour), true, false},
+		{"expired", nil, at(-time.Second), true, false},
+		{"expires now", nil, at(0), true, false},
+		{"organization key of a non-member", nil, nil, false, false},
+		{"organization key of a non-member, not yet expired", nil, at(time.Hour), false, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := apiKeyUsable(tt.revokedAt, tt.expiresAt, tt.member, now); got != tt.want {
+				t.Errorf("apiKeyUsable = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestGenerateAPIKey(t *testing.T) {
+	key, hash, prefix, err := GenerateAPIKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !IsAPIKey(key) {
+		t.Errorf("%q is not recognized as an API key", key)
+	}
+	if hash != HashAPIKey(key) || hash == key {
+		t.Errorf("hash = %q", hash)
+	}
+	if len(prefix) != len(APIKeyPrefix)+8 || key[:len(prefix)] != prefix {
+		t.Errorf("prefix = %q of %q", prefix, key)
+	}
+	if other, _, _, _ := GenerateAPIKey(); other == key {
+		t.Error("generated the same key twice")
+	}
+}
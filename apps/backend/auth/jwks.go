@@ -0,0 +1,377 @@
+package auth
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// minRefreshInterval bounds how often a token with an unknown kid can make
+// the key set refetch its source.
+const minRefreshInterval = time.Minute
+
+// PublicKey is one verification key of a key set.
+type PublicKey struct {
+	ID        string // "kid"; empty for PEM keys, which are tried for every token
+	Algorithm string // "alg" the key is restricted to, if the JWK names one
+	Key       crypto.PublicKey
+}
+
+// KeySet holds the public keys asymmetric JWTs are verified with, loaded
+// from a JWKS URL or a local file of JWKS JSON or PEM keys. Every key in the
+// source is valid at once, so an issuer rotating keys can publish the new
+// key next to the old one. When a refresh fails the previous keys are kept.
+type KeySet struct {
+	url        string
+	file       string
+	httpClient *http.Client
+
+	mu          sync.RWMutex
+	keys        []PublicKey
+	lastAttempt time.Time
+	now         func() time.Time
+}
+
+// NewJWKSKeySet returns a key set fetched from a JWKS endpoint, such as
+// Better Auth's /api/auth/jwks.
+func NewJWKSKeySet(url string, timeout time.Duration) *KeySet {
+	if timeout == 0 {
+		timeout = 10 * time.Second
+	}
+	return &KeySet{
+		url:        url,
+		httpClient: &http.Client{Timeout: timeout},
+		now:        time.Now,
+	}
+}
+
+// NewJWKSKeySetWithClient returns a key set fetched from a JWKS endpoint
+// with client, for callers that restrict where requests may go.
+func NewJWKSKeySetWithClient(url string, client *http.Client) *KeySet {
+	return &KeySet{
+		url:        url,
+		httpClient: client,
+		now:        time.Now,
+	}
+}
+
+// NewFileKeySet returns a key set read from a file holding a JWKS document
+// or one or more PEM public keys or certificates.
+func NewFileKeySet(path string) *KeySet {
+	return &KeySet{file: path, now: time.Now}
+}
+
+// Refresh reloads the keys from their source.
+func (s *KeySet) Refresh(ctx context.Context) error {
+	s.mu.Lock()
+	s.lastAttempt = s.now()
+	s.mu.Unlock()
+
+	var data []byte
+	var err error
+	if s.url != "" {
+		data, err = s.fetch(ctx)
+	} else {
+		data, err = os.ReadFile(s.file)
+	}
+	if err != nil {
+		return err
+	}
+
+	keys, err := ParsePublicKeys(data)
+	if err != nil {
+		return err
+	}
+	if len(keys) == 0 {
+		return errors.New("key source contains no usable signing keys")
+	}
+
+	s.mu.Lock()
+	s.keys = keys
+	s.mu.Unlock()
+	return nil
+}
+
+func (s *KeySet) fetch(ctx context.Context) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, s.url, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to build JWKS request: %w", err)
+	}
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := s.httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch JWKS: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("JWKS endpoint returned %s", resp.Status)
+	}
+	return io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+}
+
+// Start refreshes the keys every interval until the returned function is
+// called.
+func (s *KeySet) Start(interval time.Duration) func() {
+	ticker := time.NewTicker(interval)
+	done := make(chan struct{})
+
+	go func() {
+		for {
+			select {
+			case <-ticker.C:
+				s.refreshLogged()
+			case <-done:
+				ticker.Stop()
+				return
+			}
+		}
+	}()
+
+	var once sync.Once
+	return func() { once.Do(func() { close(done) }) }
+}
+
+func (s *KeySet) refreshLogged() {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	if err := s.Refresh(ctx); err != nil {
+		log.Warn().Err(err).Msg("Failed to refresh JWT signing keys - keeping previous keys")
+	}
+}
+
+// Lookup returns the keys that may have signed a token with the given kid and
+// alg. A kid the set does not know triggers a refresh, at most one a minute,
+// so tokens signed with a newly published key are accepted right away.
+func (s *KeySet) Lookup(kid, alg string) []crypto.PublicKey {
+	keys := s.match(kid, alg)
+	if len(keys) > 0 || kid == "" {
+		return keys
+	}
+
+	s.mu.Lock()
+	stale := s.now().Sub(s.lastAttempt) >= minRefreshInterval
+	if stale {
+		// Claim the attempt so concurrent requests do not refetch too.
+		s.lastAttempt = s.now()
+	}
+	s.mu.Unlock()
+	if !stale {
+		return nil
+	}
+
+	s.refreshLogged()
+	return s.match(kid, alg)
+}
+
+func (s *KeySet) match(kid, alg string) []crypto.PublicKey {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var keys []crypto.PublicKey
+	for _, k := range s.keys {
+		if kid != "" && k.ID != "" && k.ID != kid {
+			continue
+		}
+		if k.Algorithm != "" && k.Algorithm != alg {
+			continue
+		}
+		if !keyFitsAlgorithm(k.Key, alg) {
+			continue
+		}
+		keys = append(keys, k.Key)
+	}
+	return keys
+}
+
+// Size is the number of keys currently loaded.
+func (s *KeySet) Size() int {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return len(s.keys)
+}
+
+func keyFitsAlgorithm(key crypto.PublicKey, alg string) bool {
+	switch k := key.(type) {
+	case *rsa.PublicKey:
+		return strings.HasPrefix(alg, "RS") || strings.HasPrefix(alg, "PS")
+	case *ecdsa.PublicKey:
+		switch alg {
+		case "ES256":
+			return k.Curve == elliptic.P256()
+		case "ES384":
+			return k.Curve == elliptic.P384()
+		case "ES512":
+			return k.Curve == elliptic.P521()
+		}
+	case ed25519.PublicKey:
+		return alg == "EdDSA"
+	}
+	return false
+}
+
+type jwk struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Alg string `json:"alg"`
+	Use string `json:"use"`
+	Crv string `json:"crv"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+// ParsePublicKeys reads a JWKS document or PEM-encoded public keys and
+// certificates. JWKs that are not signing keys, or of an unsupported type,
+// are skipped.
+func ParsePublicKeys(data []byte) ([]PublicKey, error) {
+	trimmed := strings.TrimSpace(string(data))
+	if strings.HasPrefix(trimmed, "{") {
+		return parseJWKS([]byte(trimmed))
+	}
+	return parsePEMKeys([]byte(trimmed))
+}
+
+func parseJWKS(data []byte) ([]PublicKey, error) {
+	var set struct {
+		Keys []jwk `json:"keys"`
+	}
+	if err := json.Unmarshal(data, &set); err != nil {
+		return nil, fmt.Errorf("failed to decode JWKS: %w", err)
+	}
+
+	var keys []PublicKey
+	for _, k := range set.Keys {
+		if k.Use != "" && k.Use != "sig" {
+			continue
+		}
+		key, err := k.publicKey()
+		if err != nil {
+			log.Warn().Err(err).Str("kid", k.Kid).Msg("Skipping unusable JWK")
+			continue
+		}
+		keys = append(keys, PublicKey{ID: k.Kid, Algorithm: k.Alg, Key: key})
+	}
+	return keys, nil
+}
+
+func (k jwk) publicKey() (crypto.PublicKey, error) {
+	switch k.Kty {
+	case "RSA":
+		n, err := decodeBigInt(k.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := decodeBigInt(k.E)
+		if err != nil {
+			return nil, err
+		}
+		if !e.IsInt64() || e.Int64() > 1<<31-1 {
+			return nil, errors.New("RSA exponent is too large")
+		}
+		return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+	case "EC":
+		var curve elliptic.Curve
+		switch k.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return nil, fmt.Errorf("unsupported EC curve %q", k.Crv)
+		}
+		x, err := decodeBigInt(k.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := decodeBigInt(k.Y)
+		if err != nil {
+			return nil, err
+		}
+		if !curve.IsOnCurve(x, y) {
+			return nil, errors.New("EC point is not on the curve")
+		}
+		return &ecdsa.PublicKey{Curve: curve, X: x, Y: y}, nil
+	case "OKP":
+		if k.Crv != "Ed25519" {
+			return nil, fmt.Errorf("unsupported OKP curve %q", k.Crv)
+		}
+		x, err := base64.RawURLEncoding.DecodeString(k.X)
+		if err != nil {
+			return nil, fmt.Errorf("invalid key value: %w", err)
+		}
+		if len(x) != ed25519.PublicKeySize {
+			return nil, errors.New("Ed25519 key has the wrong length")
+		}
+		return ed25519.PublicKey(x), nil
+	default:
+		return nil, fmt.Errorf("unsupported key type %q", k.Kty)
+	}
+}
+
+func decodeBigInt(value string) (*big.Int, error) {
+	b, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil || len(b) == 0 {
+		return nil, errors.New("invalid key value")
+	}
+	return new(big.Int).SetBytes(b), nil
+}
+
+func parsePEMKeys(data []byte) ([]PublicKey, error) {
+	var keys []PublicKey
+	for {
+		var block *pem.Block
+		block, data = pem.Decode(data)
+		if block == nil {
+			break
+		}
+
+		var key crypto.PublicKey
+		var err error
+		switch block.Type {
+		case "PUBLIC KEY":
+			key, err = x509.ParsePKIXPublicKey(block.Bytes)
+		case "RSA PUBLIC KEY":
+			key, err = x509.ParsePKCS1PublicKey(block.Bytes)
+		case "CERTIFICATE":
+			var cert *x509.Certificate
+			cert, err = x509.ParseCertificate(block.Bytes)
+			if err == nil {
+				key = cert.PublicKey
+			}
+		default:
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse %s: %w", strings.ToLower(block.Type), err)
+		}
+		keys = append(keys, PublicKey{Key: key})
+	}
+	if len(keys) == 0 {
+		return nil, errors.New("no PEM public keys found")
+	}
+	return keys, nil
+}
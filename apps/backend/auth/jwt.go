@@ -1,58 +1,194 @@
 package auth
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"time"
 
+	"go-backend/database"
+
 	"github.com/golang-jwt/jwt/v5"
 )
 
+var (
+	hmacMethods       = []string{"HS256", "HS384", "HS512"}
+	asymmetricMethods = []string{"RS256", "RS384", "RS512", "PS256", "PS384", "PS512", "ES256", "ES384", "ES512", "EdDSA"}
+)
+
 type JWTValidator struct {
-	secret []byte
+	secret             []byte
+	keys               *KeySet
+	parser             *jwt.Parser
+	roles              RoleLookup
+	trustEmailVerified bool
+}
+
+// RoleLookup returns the role the server gives a user. Tokens only prove who
+// the user is: a role claim from an external issuer must not make anyone a
+// platform admin.
+type RoleLookup func(ctx context.Context, userID string) (string, error)
+
+// DBRoleLookup reads roles from the backend's users table, like the session
+// stores.
+func DBRoleLookup(db *database.PostgresDB) RoleLookup {
+	return func(ctx context.Context, userID string) (string, error) {
+		var role string
+		if err := db.QueryRow(ctx, roleQuery, userID).Scan(&role); err != nil {
+			return "", fmt.Errorf("failed to look up role: %w", err)
+		}
+		return role, nil
+	}
 }
 
 type UserClaims struct {
 	UserID string `json:"sub"`
 	Email  string `json:"email"`
 	Role   string `json:"role"`
+	// EmailVerified is the standard email_verified claim; sessions carry
+	// Better Auth's flag.
+	EmailVerified bool `json:"email_verified"`
 	jwt.RegisteredClaims
+
+	// Set only when the request authenticated with an API key; tokens
+	// cannot carry them.
+	APIKeyID          string   `json:"-"`
+	Scopes            []string `json:"-"`
+	KeyOrganizationID string   `json:"-"`
+
+	// SessionID is the Better Auth session a cookie-authenticated request
+	// came with.
+	SessionID string `json:"-"`
 }
 
-func NewJWTValidator(betterAuthSecret string) (*JWTValidator, error) {
-	if betterAuthSecret == "" {
-		return nil, errors.New("better auth secret is required")
+// HasScope reports whether an API key request was granted scope.
+func (c *UserClaims) HasScope(scope string) bool {
+	for _, s := range c.Scopes {
+		if s == scope {
+			return true
+		}
 	}
-	
-	return &JWTValidator{
-		secret: []byte(betterAuthSecret),
-	}, nil
+	return false
 }
 
-func (v *JWTValidator) ValidateToken(tokenString string) (*UserClaims, error) {
-	token, err := jwt.ParseWithClaims(tokenString, &UserClaims{}, func(token *jwt.Token) (interface{}, error) {
-		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
-			return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
-		}
-		return v.secret, nil
-	})
-	
+// JWTConfig says which tokens a JWTValidator accepts. HMAC tokens are
+// verified with Secret and asymmetric ones against Keys; at least one is
+// required. Issuer and Audience are checked when set, and ClockSkew is the
+// leeway allowed on exp, nbf and iat.
+//
+// The role claim is ignored in favour of Roles. The email_verified claim is
+// taken from HMAC tokens, which only Better Auth can sign, and from
+// asymmetric ones only with TrustEmailVerified, which needs an Issuer.
+type JWTConfig struct {
+	Secret             string
+	Keys               *KeySet
+	Issuer             string
+	Audience           string
+	ClockSkew          time.Duration
+	Roles              RoleLookup
+	TrustEmailVerified bool
+}
+
+func NewJWTValidator(cfg JWTConfig) (*JWTValidator, error) {
+	if cfg.Secret == "" && cfg.Keys == nil {
+		return nil, errors.New("a JWT secret or signing keys are required")
+	}
+	if cfg.Roles == nil {
+		return nil, errors.New("a role lookup is required")
+	}
+	if cfg.TrustEmailVerified && cfg.Issuer == "" {
+		return nil, errors.New("trusting email_verified requires a JWT issuer")
+	}
+
+	var methods []string
+	if cfg.Secret != "" {
+		methods = append(methods, hmacMethods...)
+	}
+	if cfg.Keys != nil {
+		methods = append(methods, asymmetricMethods...)
+	}
+
+	opts := []jwt.ParserOption{
+		jwt.WithValidMethods(methods),
+		jwt.WithLeeway(cfg.ClockSkew),
+		jwt.WithIssuedAt(),
+	}
+	if cfg.Issuer != "" {
+		opts = append(opts, jwt.WithIssuer(cfg.Issuer))
+	}
+	if cfg.Audience != "" {
+		opts = append(opts, jwt.WithAudience(cfg.Audience))
+	}
+
+	v := &JWTValidator{
+		keys:               cfg.Keys,
+		parser:             jwt.NewParser(opts...),
+		roles:              cfg.Roles,
+		trustEmailVerified: cfg.TrustEmailVerified,
+	}
+	if cfg.Secret != "" {
+		v.secret = []byte(cfg.Secret)
+	}
+	return v, nil
+}
+
+func (v *JWTValidator) ValidateToken(ctx context.Context, tokenString string) (*UserClaims, error) {
+	token, err := v.parser.ParseWithClaims(tokenString, &UserClaims{}, v.keyFunc)
 	if err != nil {
 		return nil, fmt.Errorf("failed to parse token: %w", err)
 	}
-	
+
 	if claims, ok := token.Claims.(*UserClaims); ok && token.Valid {
-		if claims.ExpiresAt != nil && claims.ExpiresAt.Time.Before(time.Now()) {
-			return nil, errors.New("token has expired")
-		}
-		
 		// Map the sub claim to UserID for compatibility
 		if claims.UserID == "" && claims.Subject != "" {
 			claims.UserID = claims.Subject
 		}
-		
+		// Service accounts act only through API keys
+		if IsServiceAccount(claims.UserID) {
+			return nil, errors.New("service accounts cannot sign in interactively")
+		}
+
+		if _, hmac := token.Method.(*jwt.SigningMethodHMAC); !hmac && !v.trustEmailVerified {
+			claims.EmailVerified = false
+		}
+		role, err := v.roles(ctx, claims.UserID)
+		if err != nil {
+			return nil, err
+		}
+		if role == "" {
+			role = "user"
+		}
+		claims.Role = role
+
 		return claims, nil
 	}
-	
+
 	return nil, errors.New("invalid token")
-} 
\ No newline at end of file
+}
+
+// keyFunc picks the keys a token may be signed with: the shared secret for
+// HMAC, otherwise every key in the set matching the token's kid and alg, so
+// tokens from an old and a new key both verify while a rotation is underway.
+func (v *JWTValidator) keyFunc(token *jwt.Token) (interface{}, error) {
+	if _, ok := token.Method.(*jwt.SigningMethodHMAC); ok {
+		if v.secret == nil {
+			return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
+		}
+		return v.secret, nil
+	}
+
+	if v.keys == nil {
+		return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
+	}
+	kid, _ := token.Header["kid"].(string)
+	keys := v.keys.Lookup(kid, token.Method.Alg())
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("no signing key for kid %q", kid)
+	}
+
+	set := jwt.VerificationKeySet{}
+	for _, key := range keys {
+		set.Keys = append(set.Keys, key)
+	}
+	return set, nil
+}
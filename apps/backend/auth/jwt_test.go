@@ -0,0 +1,177 @@
+package auth
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const (
+	testJWTSecret = "test-better-auth-secret"
+	testIssuer    = "https://idp.example.com"
+)
+
+// newTestJWKS serves a JWKS with one Ed25519 key and returns the key set
+// reading it and the private key.
+func newTestJWKS(t *testing.T) (*KeySet, ed25519.PrivateKey) {
+	t.Helper()
+	public, private, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"keys": [{"kty": "OKP", "crv": "Ed25519", "kid": "k1", "use": "sig", "x": "` +
+			base64.RawURLEncoding.EncodeToString(public) + `"}]}`))
+	}))
+	t.Cleanup(srv.Close)
+
+	keys := NewJWKSKeySet(srv.URL, 5*time.Second)
+	if err := keys.Refresh(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	return keys, private
+}
+
+func testClaims(edit func(jwt.MapClaims)) jwt.MapClaims {
+	now := time.Now()
+	claims := jwt.MapClaims{
+		"sub":            "u1",
+		"email":          "ada@example.com",
+		"email_verified": true,
+		"role":           "admin",
+		"iss":            testIssuer,
+		"iat":            now.Unix(),
+		"exp":            now.Add(5 * time.Minute).Unix(),
+	}
+	if edit != nil {
+		edit(claims)
+	}
+	return claims
+}
+
+func signEdDSA(t *testing.T, key ed25519.PrivateKey, claims jwt.MapClaims) string {
+	t.Helper()
+	token := jwt.NewWithClaims(jwt.SigningMethodEdDSA, claims)
+	token.Header["kid"] = "k1"
+	raw, err := token.SignedString(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return raw
+}
+
+func signHMAC(t *testing.T, claims jwt.MapClaims) string {
+	t.Helper()
+	raw, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString([]byte(testJWTSecret))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return raw
+}
+
+// roles is a RoleLookup over a fixed map.
+func roles(byUser map[string]string) RoleLookup {
+	return func(_ context.Context, userID string) (string, error) {
+		return byUser[userID], nil
+	}
+}
+
+func TestValidateTokenTakesRoleFromLookup(t *testing.T) {
+	keys, key := newTestJWKS(t)
+
+	tests := []struct {
+		name  string
+		users map[string]string
+		token string
+		role  string
+	}{
+		{"JWKS token claiming admin", map[string]string{"u1": "user"}, signEdDSA(t, key, testClaims(nil)), "user"},
+		{"JWKS token of an unknown user", nil, signEdDSA(t, key, testClaims(nil)), "user"},
+		{"HMAC token claiming admin", map[string]string{"u1": "user"}, signHMAC(t, testClaims(nil)), "user"},
+		{"admin claiming nothing", map[string]string{"u1": "admin"},
+			signEdDSA(t, key, testClaims(func(c jwt.MapClaims) { delete(c, "role") })), "admin"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			v, err := NewJWTValidator(JWTConfig{Secret: testJWTSecret, Keys: keys, Roles: roles(tt.users)})
+			if err != nil {
+				t.Fatal(err)
+			}
+			claims, err := v.ValidateToken(context.Background(), tt.token)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if claims.Role != tt.role {
+				t.Errorf("role = %q, want %q", claims.Role, tt.role)
+			}
+		})
+	}
+}
+
+func TestValidateTokenFailsWhenRoleLookupFails(t *testing.T) {
+	keys, key := newTestJWKS(t)
+	v, err := NewJWTValidator(JWTConfig{Keys: keys, Roles: func(context.Context, string) (string, error) {
+		return "", errors.New("database unavailable")
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if claims, err := v.ValidateToken(context.Background(), signEdDSA(t, key, testClaims(nil))); err == nil {
+		t.Fatalf("accepted as %+v", claims)
+	}
+}
+
+func TestValidateTokenEmailVerified(t *testing.T) {
+	keys, key := newTestJWKS(t)
+
+	tests := []struct {
+		name     string
+		cfg      JWTConfig
+		token    string
+		verified bool
+	}{
+		{"HMAC token", JWTConfig{Secret: testJWTSecret}, signHMAC(t, testClaims(nil)), true},
+		{"JWKS token", JWTConfig{Keys: keys}, signEdDSA(t, key, testClaims(nil)), false},
+		{"JWKS token from a trusted issuer", JWTConfig{Keys: keys, Issuer: testIssuer, TrustEmailVerified: true},
+			signEdDSA(t, key, testClaims(nil)), true},
+		{"unverified JWKS token from a trusted issuer", JWTConfig{Keys: keys, Issuer: testIssuer, TrustEmailVerified: true},
+			signEdDSA(t, key, testClaims(func(c jwt.MapClaims) { c["email_verified"] = false })), false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.cfg.Roles = roles(nil)
+			v, err := NewJWTValidator(tt.cfg)
+			if err != nil {
+				t.Fatal(err)
+			}
+			claims, err := v.ValidateToken(context.Background(), tt.token)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if claims.EmailVerified != tt.verified {
+				t.Errorf("email verified = %v, want %v", claims.EmailVerified, tt.verified)
+			}
+		})
+	}
+}
+
+func TestNewJWTValidatorRefusesTrustWithoutIssuer(t *testing.T) {
+	keys, _ := newTestJWKS(t)
+	if _, err := NewJWTValidator(JWTConfig{Keys: keys, Roles: roles(nil), TrustEmailVerified: true}); err == nil {
+		t.Error("trusted email_verified from any issuer")
+	}
+	if _, err := NewJWTValidator(JWTConfig{Keys: keys}); err == nil {
+		t.Error("created a validator without a role lookup")
+	}
+}
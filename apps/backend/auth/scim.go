@@ -0,0 +1,29 @@
+package auth
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"fmt"
+	"strings"
+)
+
+// SCIMTokenPrefix starts every SCIM token. SCIM tokens authenticate an
+// organization's identity provider on the SCIM routes only and are never
+// accepted as API keys.
+const SCIMTokenPrefix = "scim_"
+
+// IsSCIMToken reports whether a bearer token is a SCIM token.
+func IsSCIMToken(token string) bool {
+	return strings.HasPrefix(token, SCIMTokenPrefix)
+}
+
+// GenerateSCIMToken returns a new token, the hash it is stored under and
+// the prefix shown to identify it later. Tokens are hashed like API keys.
+func GenerateSCIMToken() (token, hash, prefix string, err error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", "", "", fmt.Errorf("failed to generate SCIM token: %w", err)
+	}
+	token = SCIMTokenPrefix + base64.RawURLEncoding.EncodeToString(b)
+	return token, HashAPIKey(token), token[:len(SCIMTokenPrefix)+8], nil
+}
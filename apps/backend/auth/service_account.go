@@ -0,0 +1,21 @@
+package auth
+
+import "strings"
+
+// ServiceAccountPrefix starts the user ID of every service account. Better
+// Auth never issues IDs with it, so a session or token naming one is forged
+// or misconfigured.
+const ServiceAccountPrefix = "sa_"
+
+// ServiceAccountEmailDomain holds the synthetic addresses service accounts
+// are stored under. The .invalid TLD can never receive mail.
+const ServiceAccountEmailDomain = "service-accounts.invalid"
+
+// RoleServiceAccount is the role in the claims of a request made with a
+// service account's API key, and so the actor_role of its audit entries.
+const RoleServiceAccount = "service_account"
+
+// IsServiceAccount reports whether userID belongs to a service account.
+func IsServiceAccount(userID string) bool {
+	return strings.HasPrefix(userID, ServiceAccountPrefix)
+}
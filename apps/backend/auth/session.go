@@ -0,0 +1,309 @@
+package auth
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"go-backend/database"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/rs/zerolog/log"
+)
+
+// SessionCookieNames are the cookies Better Auth keeps its session token in;
+// the __Secure- variant is used when the app is served over HTTPS.
+var SessionCookieNames = []string{"better-auth.session_token", "__Secure-better-auth.session_token"}
+
+// ErrInvalidSession is returned for unsigned, unknown or expired sessions.
+var ErrInvalidSession = errors.New("invalid or expired session")
+
+// Session is a validated Better Auth session and the user it belongs to.
+type Session struct {
+	ID        string    `json:"id"`
+	UserID    string    `json:"user_id"`
+	Email     string    `json:"email"`
+	Role      string    `json:"role"`
+	ExpiresAt time.Time `json:"expires_at"`
+	// EmailVerified is Better Auth's emailVerified flag for the user.
+	EmailVerified bool `json:"email_verified"`
+}
+
+// Claims returns the session as the claims handlers read from the request
+// context.
+func (s *Session) Claims() *UserClaims {
+	return &UserClaims{UserID: s.UserID, Email: s.Email, Role: s.Role, EmailVerified: s.EmailVerified, SessionID: s.ID}
+}
+
+// SessionStore looks up the session a Better Auth token belongs to. It
+// returns ErrInvalidSession when there is none.
+type SessionStore interface {
+	Lookup(ctx context.Context, token string) (*Session, error)
+}
+
+// SessionValidator checks Better Auth session cookies: the cookie's signature
+// against BETTER_AUTH_SECRET, then the token against the session store.
+// Validated sessions are cached in Redis, when there is one, for at most
+// cacheTTL, so a session revoked in Better Auth stops working within that
+// time.
+type SessionValidator struct {
+	secret   []byte
+	store    SessionStore
+	cache    sessionCache
+	cacheTTL time.Duration
+	now      func() time.Time
+}
+
+// sessionCache is the part of the Redis client the validator uses.
+type sessionCache interface {
+	Get(ctx context.Context, key string, dest interface{}) error
+	Set(ctx context.Context, key string, value interface{}, expiration time.Duration) error
+}
+
+func NewSessionValidator(betterAuthSecret string, store SessionStore, redis *database.RedisClient, cacheTTL time.Duration) (*SessionValidator, error) {
+	if betterAuthSecret == "" {
+		return nil, errors.New("better auth secret is required")
+	}
+	if store == nil {
+		return nil, errors.New("session store is required")
+	}
+	v := &SessionValidator{
+		secret:   []byte(betterAuthSecret),
+		store:    store,
+		cacheTTL: cacheTTL,
+		now:      time.Now,
+	}
+	if redis != nil {
+		v.cache = redis
+	}
+	return v, nil
+}
+
+// SessionCookie returns the Better Auth session cookie sent with r, if any.
+func SessionCookie(r *http.Request) *http.Cookie {
+	for _, name := range SessionCookieNames {
+		if cookie, err := r.Cookie(name); err == nil && cookie.Value != "" {
+			return cookie
+		}
+	}
+	return nil
+}
+
+// Validate resolves a session cookie value to its session.
+func (v *SessionValidator) Validate(ctx context.Context, cookieValue string) (*Session, error) {
+	token, err := v.verifyCookie(cookieValue)
+	if err != nil {
+		return nil, err
+	}
+
+	key := sessionCacheKey(token)
+	var session Session
+	if v.cacheGet(ctx, key, &session) && session.ExpiresAt.After(v.now()) {
+		return &session, nil
+	}
+
+	found, err := v.store.Lookup(ctx, token)
+	if err != nil {
+		return nil, err
+	}
+	if !found.ExpiresAt.After(v.now()) {
+		return nil, ErrInvalidSession
+	}
+	if found.Role == "" {
+		found.Role = "user"
+	}
+
+	v.cacheSet(ctx, key, found)
+	return found, nil
+}
+
+// verifyCookie checks the HMAC-SHA256 signature Better Auth appends to the
+// token ("<token>.<base64 signature>", URL-encoded) and returns the token.
+func (v *SessionValidator) verifyCookie(value string) (string, error) {
+	if decoded, err := url.PathUnescape(value); err == nil {
+		value = decoded
+	}
+
+	dot := strings.LastIndexByte(value, '.')
+	if dot <= 0 {
+		return "", ErrInvalidSession
+	}
+	token, signature := value[:dot], value[dot+1:]
+
+	got, err := base64.StdEncoding.DecodeString(signature)
+	if err != nil {
+		return "", ErrInvalidSession
+	}
+	mac := hmac.New(sha256.New, v.secret)
+	mac.Write([]byte(token))
+	if !hmac.Equal(got, mac.Sum(nil)) {
+		return "", ErrInvalidSession
+	}
+	return token, nil
+}
+
+// SignToken returns the session cookie value for a Better Auth session
+// token, for sessions the backend creates itself.
+func (v *SessionValidator) SignToken(token string) string {
+	return signSessionToken(v.secret, token)
+}
+
+func signSessionToken(secret []byte, token string) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(token))
+	return url.QueryEscape(token + "." + base64.StdEncoding.EncodeToString(mac.Sum(nil)))
+}
+
+// Tokens are hashed before they are used as cache keys so Redis never holds
+// a usable session token.
+func sessionCacheKey(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return "session:" + hex.EncodeToString(sum[:])
+}
+
+// The cache is best effort: without Redis, or when it fails, every request
+// goes to the session store.
+
+func (v *SessionValidator) cacheGet(ctx context.Context, key string, dest interface{}) bool {
+	if v.cache == nil || v.cacheTTL <= 0 {
+		return false
+	}
+	return v.cache.Get(ctx, key, dest) == nil
+}
+
+func (v *SessionValidator) cacheSet(ctx context.Context, key string, session *Session) {
+	if v.cache == nil || v.cacheTTL <= 0 {
+		return
+	}
+	ttl := v.cacheTTL
+	if remaining := session.ExpiresAt.Sub(v.now()); remaining < ttl {
+		ttl = remaining
+	}
+	if err := v.cache.Set(ctx, key, session, ttl); err != nil {
+		log.Warn().Err(err).Msg("Failed to cache session")
+	}
+}
+
+// Both session stores take the role from the backend's users table, the one
+// platform admins manage roles in, and never from Better Auth, whose users
+// could set their own at sign-up.
+const roleQuery = `SELECT COALESCE((SELECT role FROM users WHERE user_id = $1), '')`
+
+// DBSessionStore reads Better Auth's "session" and "user" tables directly.
+type DBSessionStore struct {
+	db *database.PostgresDB
+}
+
+func NewDBSessionStore(db *database.PostgresDB) *DBSessionStore {
+	return &DBSessionStore{db: db}
+}
+
+func (s *DBSessionStore) Lookup(ctx context.Context, token string) (*Session, error) {
+	var session Session
+	err := s.db.QueryRow(ctx, `
+		SELECT s.id, s."userId", u.email, s."expiresAt", COALESCE(u."emailVerified", FALSE)
+		FROM "session" s
+		JOIN "user" u ON u.id = s."userId"
+		WHERE s.token = $1
+	`, token).Scan(&session.ID, &session.UserID, &session.Email, &session.ExpiresAt, &session.EmailVerified)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, ErrInvalidSession
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to look up session: %w", err)
+	}
+	if err := s.db.QueryRow(ctx, roleQuery, session.UserID).Scan(&session.Role); err != nil {
+		return nil, fmt.Errorf("failed to look up role: %w", err)
+	}
+	return &session, nil
+}
+
+// HTTPSessionStore asks a Better Auth server for the session, for deployments
+// where the backend cannot reach Better Auth's database. url is its
+// get-session endpoint, e.g. http://localhost:3000/api/auth/get-session.
+// The role Better Auth reports is ignored.
+type HTTPSessionStore struct {
+	db         *database.PostgresDB
+	url        string
+	secret     []byte
+	httpClient *http.Client
+}
+
+func NewHTTPSessionStore(db *database.PostgresDB, sessionURL, betterAuthSecret string, timeout time.Duration) *HTTPSessionStore {
+	if timeout == 0 {
+		timeout = 10 * time.Second
+	}
+	return &HTTPSessionStore{
+		db:         db,
+		url:        sessionURL,
+		secret:     []byte(betterAuthSecret),
+		httpClient: &http.Client{Timeout: timeout},
+	}
+}
+
+type betterAuthSessionResponse struct {
+	User struct {
+		ID            string `json:"id"`
+		Email         string `json:"email"`
+		EmailVerified bool   `json:"emailVerified"`
+	} `json:"user"`
+	Session struct {
+		ID        string    `json:"id"`
+		UserID    string    `json:"userId"`
+		ExpiresAt time.Time `json:"expiresAt"`
+	} `json:"session"`
+}
+
+func (s *HTTPSessionStore) Lookup(ctx context.Context, token string) (*Session, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, s.url, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to build session request: %w", err)
+	}
+	// Better Auth only accepts the token in its signed cookie form.
+	req.Header.Set("Cookie", SessionCookieNames[0]+"="+signSessionToken(s.secret, token))
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := s.httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to reach session endpoint: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusUnauthorized || resp.StatusCode == http.StatusForbidden {
+		return nil, ErrInvalidSession
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("session endpoint returned %s", resp.Status)
+	}
+
+	// Better Auth answers 200 with a null body when there is no session.
+	var body *betterAuthSessionResponse
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return nil, fmt.Errorf("failed to decode session response: %w", err)
+	}
+	if body == nil || body.User.ID == "" {
+		return nil, ErrInvalidSession
+	}
+
+	session := &Session{
+		ID:        body.Session.ID,
+		UserID:    body.User.ID,
+		Email:     body.User.Email,
+		ExpiresAt: body.Session.ExpiresAt,
+
+		EmailVerified: body.User.EmailVerified,
+	}
+	if err := s.db.QueryRow(ctx, roleQuery, session.UserID).Scan(&session.Role); err != nil {
+		return nil, fmt.Errorf("failed to look up role: %w", err)
+	}
+	return session, nil
+}
@@ -0,0 +1,180 @@
+package auth
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/url"
+	"testing"
+	"time"
+)
+
+const testSessionSecret = "test-better-auth-secret"
+
+// fakeSessionStore holds sessions by token and counts lookups.
+type fakeSessionStore struct {
+	sessions map[string]*Session
+	lookups  int
+}
+
+func (s *fakeSessionStore) Lookup(_ context.Context, token string) (*Session, error) {
+	s.lookups++
+	session, ok := s.sessions[token]
+	if !ok {
+		return nil, ErrInvalidSession
+	}
+	found := *session
+	return &found, nil
+}
+
+// fakeCache keeps JSON values the way RedisClient does, ignoring expiry.
+type fakeCache map[string][]byte
+
+func (c fakeCache) Get(_ context.Context, key string, dest interface{}) error {
+	data, ok := c[key]
+	if !ok {
+		return errors.New("key not found")
+	}
+	return json.Unmarshal(data, dest)
+}
+
+func (c fakeCache) Set(_ context.Context, key string, value interface{}, _ time.Duration) error {
+	data, err := json.Marshal(value)
+	if err != nil {
+		return err
+	}
+	c[key] = data
+	return nil
+}
+
+func newTestSessionValidator(t *testing.T, store SessionStore, now time.Time) *SessionValidator {
+	t.Helper()
+	v, err := NewSessionValidator(testSessionSecret, store, nil, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	v.now = func() time.Time { return now }
+	return v
+}
+
+func TestVerifyCookie(t *testing.T) {
+	v := newTestSessionValidator(t, &fakeSessionStore{}, time.Now())
+	signed := v.SignToken("tok-123")
+	raw, err := url.QueryUnescape(signed)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	mac := hmac.New(sha256.New, []byte("another-secret"))
+	mac.Write([]byte("tok-123"))
+	otherSecret := "tok-123." + base64.StdEncoding.EncodeToString(mac.Sum(nil))
+
+	tests := []struct {
+		name   string
+		cookie string
+		token  string // empty when the cookie is rejected
+	}{
+		{"URL-encoded", signed, "tok-123"},
+		{"decoded", raw, "tok-123"},
+		{"token with dots", v.SignToken("a.b.c"), "a.b.c"},
+		{"signed with another secret", url.QueryEscape(otherSecret), ""},
+		{"signature of another token", "tok-124" + raw[len("tok-123"):], ""},
+		{"signature not base64", "tok-123.not*base64", ""},
+		{"signature truncated", raw[:len(raw)-4], ""},
+		{"no signature", "tok-123", ""},
+		{"empty token", raw[len("tok-123"):], ""},
+		{"empty", "", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			token, err := v.verifyCookie(tt.cookie)
+			if tt.token == "" {
+				if !errors.Is(err, ErrInvalidSession) {
+					t.Fatalf("accepted as %q, err = %v", token, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if token != tt.token {
+				t.Errorf("token = %q, want %q", token, tt.token)
+			}
+		})
+	}
+}
+
+func TestValidateSession(t *testing.T) {
+	now := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	store := &fakeSessionStore{sessions: map[string]*Session{
+		"live":    {ID: "s1", UserID: "u1", ExpiresAt: now.Add(time.Hour)},
+		"expired": {ID: "s2", UserID: "u1", ExpiresAt: now.Add(-time.Second)},
+		"admin":   {ID: "s3", UserID: "u2", Role: "admin", ExpiresAt: now.Add(time.Hour)},
+	}}
+	v := newTestSessionValidator(t, store, now)
+
+	tests := []struct {
+		name   string
+		cookie string
+		role   string // empty when the session is rejected
+	}{
+		{"live session", v.SignToken("live"), "user"},
+		{"admin session", v.SignToken("admin"), "admin"},
+		{"expired session", v.SignToken("expired"), ""},
+		{"unknown session", v.SignToken("unknown"), ""},
+		{"bad signature", "live.AAAA", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			session, err := v.Validate(context.Background(), tt.cookie)
+			if tt.role == "" {
+				if !errors.Is(err, ErrInvalidSession) {
+					t.Fatalf("accepted %+v, err = %v", session, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if session.Role != tt.role {
+				t.Errorf("role = %q, want %q", session.Role, tt.role)
+			}
+		})
+	}
+}
+
+func TestValidateSessionCache(t *testing.T) {
+	now := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	store := &fakeSessionStore{sessions: map[string]*Session{
+		"tok": {ID: "s1", UserID: "u1", ExpiresAt: now.Add(time.Hour)},
+	}}
+	cache := fakeCache{}
+	v := newTestSessionValidator(t, store, now)
+	v.cache = cache
+	cookie := v.SignToken("tok")
+
+	for i := 0; i < 2; i++ {
+		if _, err := v.Validate(context.Background(), cookie); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if store.lookups != 1 {
+		t.Fatalf("store looked up %d times, want 1", store.lookups)
+	}
+
+	// The session was revoked in Better Auth and its cached copy has
+	// expired, even if Redis still holds it
+	delete(store.sessions, "tok")
+	v.now = func() time.Time { return now.Add(2 * time.Hour) }
+	if session, err := v.Validate(context.Background(), cookie); !errors.Is(err, ErrInvalidSession) {
+		t.Fatalf("accepted the expired cached session %+v, err = %v", session, err)
+	}
+	if store.lookups != 2 {
+		t.Errorf("store looked up %d times, want 2", store.lookups)
+	}
+}
@@ -0,0 +1,206 @@
+// Package authz decides who may call each API route. Every route declares a
+// Permission (a resource and an action), every Permission has a Rule, and
+// Evaluate checks a Rule against the authenticated principal and the roles
+// the user in the path holds in the organization and project in the path.
+// Handlers keep only the checks that depend on the request body or on the
+// row being changed, such as who may change an owner.
+package authz
+
+import (
+	"net/http"
+
+	"go-backend/auth"
+	"go-backend/models"
+)
+
+// Organization roles, lowest first.
+const (
+	RoleMember = "member"
+	RoleAdmin  = "admin"
+	RoleOwner  = "owner"
+)
+
+// orgRoleRank orders organization roles; a role allows everything the roles
+// ranked below it do.
+var orgRoleRank = map[string]int{
+	RoleMember: 1,
+	RoleAdmin:  2,
+	RoleOwner:  3,
+}
+
+// ProjectRoleRank orders project roles the same way.
+var ProjectRoleRank = map[string]int{
+	models.ProjectRoleViewer: 1,
+	models.ProjectRoleEditor: 2,
+	models.ProjectRoleAdmin:  3,
+}
+
+// OrgRoleAtLeast reports whether role is min or above.
+func OrgRoleAtLeast(role, min string) bool {
+	return orgRoleRank[role] >= orgRoleRank[min] && orgRoleRank[role] > 0
+}
+
+// ProjectRoleAtLeast reports whether role is min or above.
+func ProjectRoleAtLeast(role, min string) bool {
+	return ProjectRoleRank[role] >= ProjectRoleRank[min] && ProjectRoleRank[role] > 0
+}
+
+// Subject says whose behalf a route may be called on.
+type Subject int
+
+const (
+	// SubjectAny allows any authenticated principal.
+	SubjectAny Subject = iota
+	// SubjectSelf requires the user in the path to be the principal, or a
+	// platform admin acting for them.
+	SubjectSelf
+	// SubjectSelfOnly requires the user in the path to be the principal.
+	SubjectSelfOnly
+	// SubjectPlatformAdmin requires a platform admin.
+	SubjectPlatformAdmin
+)
+
+// Rule is what a Permission requires.
+type Rule struct {
+	Subject Subject
+	// OrgRole and ProjectRole are the lowest roles the user in the path
+	// must hold in the organization and project in the path.
+	OrgRole     string
+	ProjectRole string
+	// Scope is the scope an API key needs; API keys cannot be used when it
+	// is empty.
+	Scope string
+	// AdminOverride lets platform admins past the role checks.
+	AdminOverride bool
+	// SubjectField names the JSON field of the request body holding the
+	// user, for routes with none in the path.
+	SubjectField string
+}
+
+// Principal is who made a request.
+type Principal struct {
+	UserID            string
+	PlatformAdmin     bool
+	APIKey            bool
+	Scopes            []string
+	KeyOrganizationID string
+	// SessionID is the Better Auth session the request came with, if any.
+	SessionID string
+}
+
+// PrincipalFromClaims describes the caller authenticated with claims; nil
+// claims give an anonymous principal.
+func PrincipalFromClaims(claims *auth.UserClaims) Principal {
+	if claims == nil {
+		return Principal{}
+	}
+	return Principal{
+		UserID:            claims.UserID,
+		PlatformAdmin:     claims.Role == "admin" && claims.APIKeyID == "",
+		APIKey:            claims.APIKeyID != "",
+		Scopes:            claims.Scopes,
+		KeyOrganizationID: claims.KeyOrganizationID,
+		SessionID:         claims.SessionID,
+	}
+}
+
+func (p Principal) hasScope(scope string) bool {
+	for _, s := range p.Scopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// Target is what a request acts on: the user, organization and project in
+// its path and the roles that user holds in them. An empty role means the
+// user is not an active member or cannot see the project.
+type Target struct {
+	UserID         string
+	OrganizationID string
+	ProjectID      string
+	OrgRole        string
+	ProjectRole    string
+	// SSORequired is set when the organization enforces SSO and the
+	// principal did not sign in through it.
+	SSORequired bool
+}
+
+// Decision is the outcome of Evaluate. Refusals carry the status and
+// message to report them with.
+type Decision struct {
+	Allowed bool
+	Status  int
+	Reason  string
+}
+
+var allow = Decision{Allowed: true}
+
+func deny(status int, reason string) Decision {
+	return Decision{Status: status, Reason: reason}
+}
+
+// Evaluate decides whether p may do what rule guards on t. Missing
+// memberships are reported as 404 so callers cannot probe for organizations
+// and projects they do not belong to.
+func Evaluate(rule Rule, p Principal, t Target) Decision {
+	if p.UserID == "" {
+		return deny(http.StatusUnauthorized, "Authentication required")
+	}
+
+	switch rule.Subject {
+	case SubjectSelf:
+		if t.UserID != p.UserID && !p.PlatformAdmin {
+			return deny(http.StatusForbidden, "Access denied")
+		}
+	case SubjectSelfOnly:
+		if t.UserID != p.UserID {
+			return deny(http.StatusForbidden, "Access denied")
+		}
+	case SubjectPlatformAdmin:
+		if !p.PlatformAdmin {
+			return deny(http.StatusForbidden, "Insufficient permissions")
+		}
+	}
+
+	if p.APIKey {
+		if rule.Scope == "" {
+			return deny(http.StatusForbidden, "This endpoint cannot be used with an API key")
+		}
+		if !p.hasScope(rule.Scope) {
+			return deny(http.StatusForbidden, "API key lacks the "+rule.Scope+" scope")
+		}
+		if p.KeyOrganizationID != "" && t.OrganizationID != p.KeyOrganizationID {
+			return deny(http.StatusForbidden, "API key is restricted to another organization")
+		}
+	}
+
+	if rule.AdminOverride && p.PlatformAdmin {
+		return allow
+	}
+
+	if t.SSORequired {
+		return deny(http.StatusForbidden, "This organization requires signing in with SSO")
+	}
+
+	if rule.OrgRole != "" {
+		if t.OrgRole == "" {
+			return deny(http.StatusNotFound, "Organization not found or access denied")
+		}
+		if !OrgRoleAtLeast(t.OrgRole, rule.OrgRole) {
+			return deny(http.StatusForbidden, "Insufficient permissions")
+		}
+	}
+
+	if rule.ProjectRole != "" {
+		if t.ProjectRole == "" {
+			return deny(http.StatusNotFound, "Project not found or access denied")
+		}
+		if !ProjectRoleAtLeast(t.ProjectRole, rule.ProjectRole) {
+			return deny(http.StatusForbidden, "Insufficient permissions")
+		}
+	}
+
+	return allow
+}
@@ -0,0 +1,225 @@
+package authz
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"go-backend/auth"
+	"go-backend/models"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+func TestEvaluate(t *testing.T) {
+	user := Principal{UserID: "u1"}
+	admin := Principal{UserID: "admin", PlatformAdmin: true}
+	key := func(scopes ...string) Principal {
+		return Principal{UserID: "u1", APIKey: true, Scopes: scopes}
+	}
+	orgKey := Principal{UserID: "u1", APIKey: true, Scopes: []string{auth.ScopeProjectsRead}, KeyOrganizationID: "o1"}
+
+	tests := []struct {
+		name      string
+		rule      Rule
+		principal Principal
+		target    Target
+		status    int // 0 when allowed
+	}{
+		{"anonymous", Rule{Subject: SubjectAny}, Principal{}, Target{}, http.StatusUnauthorized},
+		{"any subject", Rule{Subject: SubjectAny}, user, Target{UserID: "u2"}, 0},
+
+		{"self on self", Rule{Subject: SubjectSelf}, user, Target{UserID: "u1"}, 0},
+		{"self on other", Rule{Subject: SubjectSelf}, user, Target{UserID: "u2"}, http.StatusForbidden},
+		{"self without path user", Rule{Subject: SubjectSelf}, user, Target{}, http.StatusForbidden},
+		{"self by platform admin", Rule{Subject: SubjectSelf}, admin, Target{UserID: "u2"}, 0},
+		{"self only on self", Rule{Subject: SubjectSelfOnly}, user, Target{UserID: "u1"}, 0},
+		{"self only by platform admin", Rule{Subject: SubjectSelfOnly}, admin, Target{UserID: "u2"}, http.StatusForbidden},
+		{"platform admin", Rule{Subject: SubjectPlatformAdmin}, admin, Target{}, 0},
+		{"platform admin by user", Rule{Subject: SubjectPlatformAdmin}, user, Target{}, http.StatusForbidden},
+
+		{"not a member", Rule{Subject: SubjectSelf, OrgRole: RoleMember}, user,
+			Target{UserID: "u1", OrganizationID: "o1"}, http.StatusNotFound},
+		{"member needs member", Rule{Subject: SubjectSelf, OrgRole: RoleMember}, user,
+			Target{UserID: "u1", OrganizationID: "o1", OrgRole: RoleMember}, 0},
+		{"member needs admin", Rule{Subject: SubjectSelf, OrgRole: RoleAdmin}, user,
+			Target{UserID: "u1", OrganizationID: "o1", OrgRole: RoleMember}, http.StatusForbidden},
+		{"owner needs admin", Rule{Subject: SubjectSelf, OrgRole: RoleAdmin}, user,
+			Target{UserID: "u1", OrganizationID: "o1", OrgRole: RoleOwner}, 0},
+		{"unknown org role", Rule{Subject: SubjectSelf, OrgRole: RoleMember}, user,
+			Target{UserID: "u1", OrganizationID: "o1", OrgRole: "guest"}, http.StatusForbidden},
+		{"platform admin gets the path user's roles", Rule{Subject: SubjectSelf, OrgRole: RoleAdmin}, admin,
+			Target{UserID: "u2", OrganizationID: "o1", OrgRole: RoleMember}, http.StatusForbidden},
+		{"admin override", Rule{Subject: SubjectSelf, OrgRole: RoleAdmin, AdminOverride: true}, admin,
+			Target{UserID: "u2", OrganizationID: "o1"}, 0},
+
+		{"no project access", Rule{Subject: SubjectSelf, ProjectRole: models.ProjectRoleViewer}, user,
+			Target{UserID: "u1", ProjectID: "p1"}, http.StatusNotFound},
+		{"viewer needs viewer", Rule{Subject: SubjectSelf, ProjectRole: models.ProjectRoleViewer}, user,
+			Target{UserID: "u1", ProjectID: "p1", ProjectRole: models.ProjectRoleViewer}, 0},
+		{"viewer needs editor", Rule{Subject: SubjectSelf, ProjectRole: models.ProjectRoleEditor}, user,
+			Target{UserID: "u1", ProjectID: "p1", ProjectRole: models.ProjectRoleViewer}, http.StatusForbidden},
+		{"admin needs editor", Rule{Subject: SubjectSelf, ProjectRole: models.ProjectRoleEditor}, user,
+			Target{UserID: "u1", ProjectID: "p1", ProjectRole: models.ProjectRoleAdmin}, 0},
+
+		{"key on keyless rule", Rule{Subject: SubjectSelf}, key(auth.ScopeSQLWrite), Target{UserID: "u1"}, http.StatusForbidden},
+		{"key with scope", Rule{Subject: SubjectSelf, Scope: auth.ScopeSQLRead}, key(auth.ScopeSQLRead), Target{UserID: "u1"}, 0},
+		{"key without scope", Rule{Subject: SubjectSelf, Scope: auth.ScopeSQLWrite}, key(auth.ScopeSQLRead), Target{UserID: "u1"}, http.StatusForbidden},
+		{"key in its organization", Rule{Subject: SubjectSelf, Scope: auth.ScopeProjectsRead}, orgKey,
+			Target{UserID: "u1", OrganizationID: "o1"}, 0},
+		{"key in another organization", Rule{Subject: SubjectSelf, Scope: auth.ScopeProjectsRead}, orgKey,
+			Target{UserID: "u1", OrganizationID: "o2"}, http.StatusForbidden},
+
+		{"SSO required", Rule{Subject: SubjectSelf, OrgRole: RoleMember}, user,
+			Target{UserID: "u1", OrganizationID: "o1", OrgRole: RoleMember, SSORequired: true}, http.StatusForbidden},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := Evaluate(tt.rule, tt.principal, tt.target)
+			if tt.status == 0 {
+				if !d.Allowed {
+					t.Fatalf("denied with %d %q, want allowed", d.Status, d.Reason)
+				}
+				return
+			}
+			if d.Allowed {
+				t.Fatalf("allowed, want %d", tt.status)
+			}
+			if d.Status != tt.status {
+				t.Fatalf("status = %d (%q), want %d", d.Status, d.Reason, tt.status)
+			}
+		})
+	}
+}
+
+func TestPlatformAdminAPIKeyIsNotAdmin(t *testing.T) {
+	claims := &auth.UserClaims{UserID: "admin", Role: "admin", APIKeyID: "k1", Scopes: []string{auth.ScopeAuditRead}}
+	p := PrincipalFromClaims(claims)
+	if p.PlatformAdmin {
+		t.Fatal("an API key carried the platform admin role")
+	}
+
+	if d := Evaluate(Rule{Subject: SubjectPlatformAdmin, Scope: auth.ScopeAuditRead}, p, Target{}); d.Allowed {
+		t.Error("API key passed a platform admin rule")
+	}
+	if d := Evaluate(Policy[AuditLogRead], p, Target{UserID: "u2", OrganizationID: "o1"}); d.Allowed {
+		t.Error("API key acted for another user")
+	}
+	if d := Evaluate(Policy[AuditLogRead], p, Target{UserID: "admin", OrganizationID: "o1"}); d.Allowed {
+		t.Error("API key was let past the role checks")
+	}
+
+	claims.APIKeyID = ""
+	if !PrincipalFromClaims(claims).PlatformAdmin {
+		t.Error("session of a platform admin is not an admin")
+	}
+}
+
+func TestEveryRouteHasARule(t *testing.T) {
+	for route, perm := range Routes {
+		if _, ok := Policy[perm]; !ok {
+			t.Errorf("%s declares %s, which has no rule", route, perm)
+		}
+	}
+}
+
+func TestUserCreateNeedsTheCallersID(t *testing.T) {
+	rule := Policy[Routes["POST /api/v1/users"]]
+	body := `{"user_id":"u2","email":"b@example.com","role":"admin"}`
+	r := httptest.NewRequest(http.MethodPost, "/api/v1/users", strings.NewReader(body))
+
+	target := Target{UserID: bodySubject(r, rule.SubjectField)}
+	if d := Evaluate(rule, Principal{UserID: "u1"}, target); d.Allowed {
+		t.Error("created a user row for someone else")
+	}
+	if d := Evaluate(rule, Principal{UserID: "u2"}, target); !d.Allowed {
+		t.Errorf("denied creating one's own row: %d %q", d.Status, d.Reason)
+	}
+
+	rest, err := io.ReadAll(r.Body)
+	if err != nil || string(rest) != body {
+		t.Errorf("body left for the handler = %q, %v", rest, err)
+	}
+}
+
+func TestAIFixNeedsWriteScope(t *testing.T) {
+	rule := Policy[Routes["POST "+usersPrefix+"/sql/ai/fix"]]
+	read := Principal{UserID: "u1", APIKey: true, Scopes: []string{auth.ScopeSQLRead}}
+	if d := Evaluate(rule, read, Target{UserID: "u1"}); d.Allowed {
+		t.Error("a read-only key ran a query through the AI fix")
+	}
+	write := Principal{UserID: "u1", APIKey: true, Scopes: []string{auth.ScopeSQLWrite}}
+	if d := Evaluate(rule, write, Target{UserID: "u1"}); !d.Allowed {
+		t.Errorf("denied a sql:write key: %d %q", d.Status, d.Reason)
+	}
+}
+
+func TestJWKSTokenClaimingAdminIsNotPlatformAdmin(t *testing.T) {
+	public, private, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, err := x509.MarshalPKIXPublicKey(public)
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "keys.pem")
+	if err := os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	keys := auth.NewFileKeySet(path)
+	if err := keys.Refresh(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	v, err := auth.NewJWTValidator(auth.JWTConfig{Keys: keys, Roles: func(context.Context, string) (string, error) {
+		return "user", nil
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	token, err := jwt.NewWithClaims(jwt.SigningMethodEdDSA, jwt.MapClaims{
+		"sub":  "u1",
+		"role": "admin",
+		"exp":  time.Now().Add(time.Minute).Unix(),
+	}).SignedString(private)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	claims, err := v.ValidateToken(context.Background(), token)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p := PrincipalFromClaims(claims); p.PlatformAdmin {
+		t.Error("a token's role claim made its subject a platform admin")
+	}
+}
+
+func TestSchemaDescriptionsNeedProjectEditor(t *testing.T) {
+	rule := Policy[Routes["PUT "+projPrefix+"/sql/docs/descriptions"]]
+	target := func(role string) Target {
+		return Target{UserID: "u1", OrganizationID: "o1", OrgRole: RoleMember, ProjectID: "p1", ProjectRole: role}
+	}
+	user := Principal{UserID: "u1"}
+	if d := Evaluate(rule, user, target(models.ProjectRoleViewer)); d.Allowed {
+		t.Error("a project viewer edited descriptions")
+	}
+	if d := Evaluate(rule, user, target(models.ProjectRoleEditor)); !d.Allowed {
+		t.Errorf("denied a project editor: %d %q", d.Status, d.Reason)
+	}
+	if _, ok := Routes["PUT "+usersPrefix+"/sql/docs/descriptions"]; ok {
+		t.Error("descriptions can still be written outside a project")
+	}
+}
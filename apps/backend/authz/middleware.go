@@ -0,0 +1,196 @@
+package authz
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"time"
+
+	"go-backend/database"
+	"go-backend/middleware"
+
+	"github.com/gorilla/mux"
+	"github.com/rs/zerolog/log"
+)
+
+type contextKey string
+
+const grantKey contextKey = "authzGrant"
+
+// Grant is an allowed request: the permission it was checked against and
+// what it acts on. Project is set for routes that require a project role.
+type Grant struct {
+	Permission Permission
+	Principal  Principal
+	Target     Target
+	Project    *ProjectAccess
+}
+
+// FromContext returns the request's Grant, or nil outside an authorized
+// route.
+func FromContext(ctx context.Context) *Grant {
+	if g, ok := ctx.Value(grantKey).(*Grant); ok {
+		return g
+	}
+	return nil
+}
+
+// Authorizer enforces the Policy on every route of a router.
+type Authorizer struct {
+	db *database.PostgresDB
+}
+
+func NewAuthorizer(db *database.PostgresDB) *Authorizer {
+	return &Authorizer{db: db}
+}
+
+// Middleware looks up the matched route's Permission, resolves the roles
+// its Rule needs and refuses the request unless Evaluate allows it. It runs
+// after authentication.
+func (a *Authorizer) Middleware() func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			perm, ok := routePermission(r)
+			if !ok {
+				log.Error().
+					Str("method", r.Method).
+					Str("path", r.URL.Path).
+					Msg("Route declares no permission - refusing")
+
+				http.Error(w, "Access denied", http.StatusForbidden)
+				return
+			}
+			rule := Policy[perm]
+
+			grant := &Grant{
+				Permission: perm,
+				Principal:  PrincipalFromClaims(middleware.GetUserClaims(r.Context())),
+				Target:     targetFromPath(r),
+			}
+			if rule.SubjectField != "" && grant.Target.UserID == "" {
+				grant.Target.UserID = bodySubject(r, rule.SubjectField)
+			}
+			if err := a.resolve(r.Context(), rule, grant); err != nil {
+				log.Error().Err(err).Str("permission", perm.String()).Msg("Failed to resolve roles")
+				http.Error(w, "Failed to check permissions", http.StatusInternalServerError)
+				return
+			}
+
+			decision := Evaluate(rule, grant.Principal, grant.Target)
+			if !decision.Allowed {
+				log.Warn().
+					Str("user_id", grant.Principal.UserID).
+					Str("permission", perm.String()).
+					Int("status", decision.Status).
+					Str("path", r.URL.Path).
+					Msg("Access denied")
+
+				http.Error(w, decision.Reason, decision.Status)
+				return
+			}
+
+			next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), grantKey, grant)))
+		})
+	}
+}
+
+// resolve loads the roles rule checks for the user in the path. Roles are
+// those of the path user, so a platform admin acting for someone gets
+// theirs.
+func (a *Authorizer) resolve(ctx context.Context, rule Rule, g *Grant) error {
+	t := &g.Target
+	if t.UserID == "" || (rule.OrgRole == "" && rule.ProjectRole == "") {
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	if rule.ProjectRole != "" && t.ProjectID != "" {
+		access, err := LoadProjectAccess(ctx, a.db, t.UserID, t.OrganizationID, t.ProjectID)
+		if errors.Is(err, ErrProjectNotFound) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		g.Project = access
+		t.OrgRole, t.ProjectRole = access.OrgRole, access.Role
+	} else {
+		if t.OrganizationID == "" {
+			return nil
+		}
+		role, err := loadOrganizationRole(ctx, a.db, t.UserID, t.OrganizationID)
+		if err != nil {
+			return err
+		}
+		t.OrgRole = role
+	}
+
+	required, err := ssoRequired(ctx, a.db, g.Principal, *t)
+	if err != nil {
+		return err
+	}
+	t.SSORequired = required
+	return nil
+}
+
+func targetFromPath(r *http.Request) Target {
+	vars := mux.Vars(r)
+	userID := vars["userId"]
+	if userID == "" {
+		userID = vars["user_id"]
+	}
+	return Target{
+		UserID:         userID,
+		OrganizationID: vars["orgId"],
+		ProjectID:      vars["projectId"],
+	}
+}
+
+// maxSubjectBody caps how much of a body is read to find its subject.
+const maxSubjectBody = 1 << 20
+
+// bodySubject returns the string in field of r's JSON body, or "" when
+// there is none, and leaves the body for the handler to read again.
+func bodySubject(r *http.Request, field string) string {
+	if r.Body == nil {
+		return ""
+	}
+	rest := r.Body
+	body, err := io.ReadAll(io.LimitReader(rest, maxSubjectBody))
+	r.Body = struct {
+		io.Reader
+		io.Closer
+	}{io.MultiReader(bytes.NewReader(body), rest), rest}
+	if err != nil {
+		return ""
+	}
+
+	var fields map[string]json.RawMessage
+	var subject string
+	if json.Unmarshal(body, &fields) != nil || json.Unmarshal(fields[field], &subject) != nil {
+		return ""
+	}
+	return subject
+}
+
+func routePermission(r *http.Request) (Permission, bool) {
+	route := mux.CurrentRoute(r)
+	if route == nil {
+		return Permission{}, false
+	}
+	template, err := route.GetPathTemplate()
+	if err != nil {
+		return Permission{}, false
+	}
+	perm, ok := Routes[r.Method+" "+template]
+	if !ok {
+		return Permission{}, false
+	}
+	_, ok = Policy[perm]
+	return perm, ok
+}
@@ -0,0 +1,321 @@
+package authz
+
+import (
+	"go-backend/auth"
+	"go-backend/models"
+)
+
+// Permission is an action on a kind of resource.
+type Permission struct {
+	Resource string
+	Action   string
+}
+
+func (p Permission) String() string {
+	return p.Resource + ":" + p.Action
+}
+
+// Permissions declared by the routes.
+var (
+	UserCreate         = Permission{"user", "create"}
+	UserProvision      = Permission{"user", "provision"}
+	UserRead           = Permission{"user", "read"}
+	UserUpdate         = Permission{"user", "update"}
+	UserResourceRead   = Permission{"user_resource", "read"}
+	UserResourceCreate = Permission{"user_resource", "create"}
+
+	ConnectionWrite = Permission{"connection", "write"}
+	ConnectionTest  = Permission{"connection", "test"}
+
+	SQLExecute     = Permission{"sql", "execute"}
+	SQLHistoryRead = Permission{"sql_history", "read"}
+	SQLAssist      = Permission{"sql", "assist"}
+	SQLGenerate    = Permission{"sql", "generate"}
+	SQLFix         = Permission{"sql", "fix"}
+	SchemaRead     = Permission{"schema", "read"}
+
+	APIKeyRead   = Permission{"api_key", "read"}
+	APIKeyCreate = Permission{"api_key", "create"}
+	APIKeyRevoke = Permission{"api_key", "revoke"}
+
+	OrganizationList   = Permission{"organization", "list"}
+	OrganizationCreate = Permission{"organization", "create"}
+	OrganizationRead   = Permission{"organization", "read"}
+	OrganizationJoin   = Permission{"organization", "join"}
+	UsageRead          = Permission{"usage", "read"}
+	BillingRead        = Permission{"billing", "read"}
+	BillingCheckout    = Permission{"billing", "checkout"}
+	AuditLogRead       = Permission{"audit_log", "read"}
+	AuditLogExport     = Permission{"audit_log", "export"}
+
+	OrganizationAPIKeyRead  = Permission{"organization_api_key", "read"}
+	OrganizationAPIKeyWrite = Permission{"organization_api_key", "write"}
+	ServiceAccountRead      = Permission{"service_account", "read"}
+	ServiceAccountWrite     = Permission{"service_account", "write"}
+	DomainRead              = Permission{"domain", "read"}
+	DomainWrite             = Permission{"domain", "write"}
+	SSORead                 = Permission{"sso", "read"}
+	SSOWrite                = Permission{"sso", "write"}
+	SCIMTokenRead           = Permission{"scim_token", "read"}
+	SCIMTokenWrite          = Permission{"scim_token", "write"}
+	SCIMGroupRead           = Permission{"scim_group", "read"}
+	SCIMGroupWrite          = Permission{"scim_group", "write"}
+
+	MemberList        = Permission{"member", "list"}
+	MemberUpdate      = Permission{"member", "update"}
+	OwnershipRead     = Permission{"ownership_transfer", "read"}
+	OwnershipWrite    = Permission{"ownership_transfer", "write"}
+	InvitationList    = Permission{"invitation", "list"}
+	InvitationWrite   = Permission{"invitation", "write"}
+	InvitationAccept  = Permission{"invitation", "accept"}
+	ProjectInvitation = Permission{"project_invitation", "create"}
+
+	ProjectList         = Permission{"project", "list"}
+	ProjectCreate       = Permission{"project", "create"}
+	ProjectRead         = Permission{"project", "read"}
+	ProjectUpdate       = Permission{"project", "update"}
+	ProjectMemberList   = Permission{"project_member", "list"}
+	ProjectMemberSet    = Permission{"project_member", "set"}
+	ProjectMemberRemove = Permission{"project_member", "remove"}
+	ProjectConnection   = Permission{"project_connection", "write"}
+	SavedQueryRead      = Permission{"saved_query", "read"}
+	SavedQueryWrite     = Permission{"saved_query", "write"}
+	AccessPolicyRead    = Permission{"access_policy", "read"}
+	AccessPolicyWrite   = Permission{"access_policy", "write"}
+	MaskingRuleRead     = Permission{"masking_rule", "read"}
+	MaskingRuleWrite    = Permission{"masking_rule", "write"}
+	ProjectQuery        = Permission{"project_sql", "execute"}
+	ProjectSchemaRead   = Permission{"project_schema", "read"}
+	ProjectSchemaWrite  = Permission{"project_schema", "write"}
+
+	MetricsWrite = Permission{"metrics", "write"}
+	MetricsRead  = Permission{"metrics", "read"}
+
+	PlanRead               = Permission{"plan", "read"}
+	PlanWrite              = Permission{"plan", "write"}
+	EntitlementsRead       = Permission{"entitlements", "read"}
+	OrganizationPlanWrite  = Permission{"organization_plan", "write"}
+	PlatformAuditLogRead   = Permission{"platform_audit_log", "read"}
+	PlatformAuditLogExport = Permission{"platform_audit_log", "export"}
+	PlatformAuditLogSign   = Permission{"platform_audit_log", "checkpoint"}
+)
+
+// Policy is the Rule for each Permission.
+var Policy = map[Permission]Rule{
+	UserCreate:         {Subject: SubjectSelf, SubjectField: "user_id"},
+	UserProvision:      {Subject: SubjectPlatformAdmin},
+	UserRead:           {Subject: SubjectSelf},
+	UserUpdate:         {Subject: SubjectSelf},
+	UserResourceRead:   {Subject: SubjectSelf},
+	UserResourceCreate: {Subject: SubjectSelf},
+
+	ConnectionWrite: {Subject: SubjectSelf},
+	ConnectionTest:  {Subject: SubjectSelf},
+
+	SQLExecute:     {Subject: SubjectSelf, Scope: auth.ScopeSQLWrite},
+	SQLHistoryRead: {Subject: SubjectSelf, Scope: auth.ScopeSQLRead},
+	SQLAssist:      {Subject: SubjectSelf, Scope: auth.ScopeSQLRead},
+	SQLGenerate:    {Subject: SubjectSelf, Scope: auth.ScopeSQLRead},
+	SQLFix:         {Subject: SubjectSelf, Scope: auth.ScopeSQLWrite}, // runs the query to reproduce its error
+	SchemaRead:     {Subject: SubjectSelf, Scope: auth.ScopeSchemaRead},
+
+	// Keys are managed only by their owner in person
+	APIKeyRead:   {Subject: SubjectSelfOnly},
+	APIKeyCreate: {Subject: SubjectSelfOnly},
+	APIKeyRevoke: {Subject: SubjectSelfOnly},
+
+	OrganizationList:   {Subject: SubjectSelf, Scope: auth.ScopeOrganizationsRead},
+	OrganizationCreate: {Subject: SubjectSelf},
+	OrganizationRead:   {Subject: SubjectSelf, OrgRole: RoleMember, Scope: auth.ScopeOrganizationsRead},
+	// Joining by domain trusts the session's verified email
+	OrganizationJoin: {Subject: SubjectSelfOnly},
+	UsageRead:        {Subject: SubjectSelf, OrgRole: RoleMember, Scope: auth.ScopeOrganizationsRead},
+	BillingRead:      {Subject: SubjectSelf, OrgRole: RoleMember},
+	BillingCheckout:  {Subject: SubjectSelf, OrgRole: RoleAdmin},
+	AuditLogRead:     {Subject: SubjectSelf, OrgRole: RoleAdmin, Scope: auth.ScopeAuditRead, AdminOverride: true},
+	AuditLogExport:   {Subject: SubjectSelf, OrgRole: RoleAdmin, Scope: auth.ScopeAuditRead, AdminOverride: true},
+
+	OrganizationAPIKeyRead:  {Subject: SubjectSelfOnly, OrgRole: RoleAdmin},
+	OrganizationAPIKeyWrite: {Subject: SubjectSelfOnly, OrgRole: RoleAdmin},
+	ServiceAccountRead:      {Subject: SubjectSelf, OrgRole: RoleAdmin},
+	ServiceAccountWrite:     {Subject: SubjectSelf, OrgRole: RoleAdmin},
+	DomainRead:              {Subject: SubjectSelf, OrgRole: RoleAdmin, Scope: auth.ScopeOrganizationsRead},
+	DomainWrite:             {Subject: SubjectSelf, OrgRole: RoleAdmin, Scope: auth.ScopeOrganizationsWrite},
+	SSORead:                 {Subject: SubjectSelf, OrgRole: RoleAdmin, Scope: auth.ScopeOrganizationsRead},
+	SSOWrite:                {Subject: SubjectSelfOnly, OrgRole: RoleAdmin},
+	SCIMTokenRead:           {Subject: SubjectSelfOnly, OrgRole: RoleAdmin},
+	SCIMTokenWrite:          {Subject: SubjectSelfOnly, OrgRole: RoleAdmin},
+	SCIMGroupRead:           {Subject: SubjectSelf, OrgRole: RoleAdmin, Scope: auth.ScopeOrganizationsRead},
+	SCIMGroupWrite:          {Subject: SubjectSelfOnly, OrgRole: RoleAdmin},
+
+	// Who may change which member is decided per member by the handlers
+	MemberList:      {Subject: SubjectSelf, OrgRole: RoleMember, Scope: auth.ScopeOrganizationsRead},
+	MemberUpdate:    {Subject: SubjectSelf, OrgRole: RoleMember, Scope: auth.ScopeOrganizationsWrite},
+	OwnershipRead:   {Subject: SubjectSelf, OrgRole: RoleMember},
+	OwnershipWrite:  {Subject: SubjectSelf, OrgRole: RoleMember},
+	InvitationList:  {Subject: SubjectSelf, OrgRole: RoleAdmin, Scope: auth.ScopeOrganizationsRead},
+	InvitationWrite: {Subject: SubjectSelf, OrgRole: RoleAdmin, Scope: auth.ScopeOrganizationsWrite},
+	// The invitation token and the invited email are checked by the handler
+	InvitationAccept:  {Subject: SubjectAny},
+	ProjectInvitation: {Subject: SubjectSelf, ProjectRole: models.ProjectRoleAdmin, Scope: auth.ScopeProjectsWrite},
+
+	ProjectList:         {Subject: SubjectSelf, OrgRole: RoleMember, Scope: auth.ScopeProjectsRead},
+	ProjectCreate:       {Subject: SubjectSelf, OrgRole: RoleMember, Scope: auth.ScopeProjectsWrite},
+	ProjectRead:         {Subject: SubjectSelf, ProjectRole: models.ProjectRoleViewer, Scope: auth.ScopeProjectsRead},
+	ProjectUpdate:       {Subject: SubjectSelf, ProjectRole: models.ProjectRoleAdmin, Scope: auth.ScopeProjectsWrite},
+	ProjectMemberList:   {Subject: SubjectSelf, ProjectRole: models.ProjectRoleViewer, Scope: auth.ScopeProjectsRead},
+	ProjectMemberSet:    {Subject: SubjectSelf, ProjectRole: models.ProjectRoleAdmin, Scope: auth.ScopeProjectsWrite},
+	ProjectMemberRemove: {Subject: SubjectSelf, ProjectRole: models.ProjectRoleViewer, Scope: auth.ScopeProjectsWrite},
+	ProjectConnection:   {Subject: SubjectSelf, ProjectRole: models.ProjectRoleAdmin, Scope: auth.ScopeProjectsWrite},
+	SavedQueryRead:      {Subject: SubjectSelf, ProjectRole: models.ProjectRoleViewer, Scope: auth.ScopeProjectsRead},
+	SavedQueryWrite:     {Subject: SubjectSelf, ProjectRole: models.ProjectRoleEditor, Scope: auth.ScopeProjectsWrite},
+	AccessPolicyRead:    {Subject: SubjectSelf, ProjectRole: models.ProjectRoleAdmin, Scope: auth.ScopeProjectsRead},
+	AccessPolicyWrite:   {Subject: SubjectSelf, ProjectRole: models.ProjectRoleAdmin, Scope: auth.ScopeProjectsWrite},
+	MaskingRuleRead:     {Subject: SubjectSelf, ProjectRole: models.ProjectRoleAdmin, Scope: auth.ScopeProjectsRead},
+	MaskingRuleWrite:    {Subject: SubjectSelf, ProjectRole: models.ProjectRoleAdmin, Scope: auth.ScopeProjectsWrite},
+	ProjectQuery:        {Subject: SubjectSelf, ProjectRole: models.ProjectRoleViewer, Scope: auth.ScopeSQLRead},
+	ProjectSchemaRead:   {Subject: SubjectSelf, ProjectRole: models.ProjectRoleViewer, Scope: auth.ScopeSchemaRead},
+	ProjectSchemaWrite:  {Subject: SubjectSelf, ProjectRole: models.ProjectRoleEditor, Scope: auth.ScopeSchemaWrite},
+
+	MetricsWrite: {Subject: SubjectAny},
+	MetricsRead:  {Subject: SubjectAny},
+
+	PlanRead:               {Subject: SubjectPlatformAdmin},
+	PlanWrite:              {Subject: SubjectPlatformAdmin},
+	EntitlementsRead:       {Subject: SubjectPlatformAdmin},
+	OrganizationPlanWrite:  {Subject: SubjectPlatformAdmin},
+	PlatformAuditLogRead:   {Subject: SubjectPlatformAdmin},
+	PlatformAuditLogExport: {Subject: SubjectPlatformAdmin},
+	PlatformAuditLogSign:   {Subject: SubjectPlatformAdmin},
+}
+
+const (
+	usersPrefix = "/api/v1/users/{user_id}"
+	orgPrefix   = "/api/v1/users/{userId}/organizations/{orgId}"
+	projPrefix  = orgPrefix + "/projects/{projectId}"
+)
+
+// Routes is the Permission each route declares, keyed by method and path
+// template. Routes missing here are refused.
+var Routes = map[string]Permission{
+	"POST /api/v1/users":                                    UserCreate,
+	"GET " + usersPrefix:                                    UserRead,
+	"PUT " + usersPrefix:                                    UserUpdate,
+	"POST " + usersPrefix + "/resources":                    UserResourceCreate,
+	"GET " + usersPrefix + "/resources":                     UserResourceRead,
+	"POST " + usersPrefix + "/database-config":              ConnectionWrite,
+	"DELETE " + usersPrefix + "/database-config":            ConnectionWrite,
+	"POST " + usersPrefix + "/database-config/test":         ConnectionTest,
+	"POST " + usersPrefix + "/database-config/test-url":     ConnectionTest,
+	"POST " + usersPrefix + "/sql/execute":                  SQLExecute,
+	"GET " + usersPrefix + "/sql/schema":                    SchemaRead,
+	"GET " + usersPrefix + "/sql/history":                   SQLHistoryRead,
+	"GET " + usersPrefix + "/sql/history/shapes":            SQLHistoryRead,
+	"POST " + usersPrefix + "/sql/complete":                 SQLAssist,
+	"POST " + usersPrefix + "/sql/lint":                     SQLAssist,
+	"POST " + usersPrefix + "/sql/format":                   SQLAssist,
+	"POST " + usersPrefix + "/sql/ai/generate":              SQLGenerate,
+	"POST " + usersPrefix + "/sql/ai/fix":                   SQLFix,
+	"GET " + usersPrefix + "/sql/docs":                      SchemaRead,
+	"GET " + usersPrefix + "/api-keys":                      APIKeyRead,
+	"POST " + usersPrefix + "/api-keys":                     APIKeyCreate,
+	"DELETE " + usersPrefix + "/api-keys/{keyId}":           APIKeyRevoke,
+	"GET /api/v1/users/{userId}/organizations":              OrganizationList,
+	"POST /api/v1/users/{userId}/organizations":             OrganizationCreate,
+	"GET /api/v1/users/{userId}/organizations/joinable":     OrganizationJoin,
+	"POST /api/v1/users/{userId}/organizations/auto-join":   OrganizationJoin,
+	"GET " + orgPrefix:                                      OrganizationRead,
+	"GET " + orgPrefix + "/usage":                           UsageRead,
+	"GET " + orgPrefix + "/usage/cycles":                    UsageRead,
+	"GET " + orgPrefix + "/billing":                         BillingRead,
+	"POST " + orgPrefix + "/billing/checkout":               BillingCheckout,
+	"GET " + orgPrefix + "/audit-log":                       AuditLogRead,
+	"GET " + orgPrefix + "/audit-log/export":                AuditLogExport,
+	"GET " + orgPrefix + "/audit-log/verify":                AuditLogRead,
+	"GET " + orgPrefix + "/audit-log/checkpoints":           AuditLogRead,
+	"GET " + orgPrefix + "/api-keys":                        OrganizationAPIKeyRead,
+	"POST " + orgPrefix + "/api-keys":                       OrganizationAPIKeyWrite,
+	"DELETE " + orgPrefix + "/api-keys/{keyId}":             OrganizationAPIKeyWrite,
+	"GET " + orgPrefix + "/service-accounts":                ServiceAccountRead,
+	"POST " + orgPrefix + "/service-accounts":               ServiceAccountWrite,
+	"PUT " + orgPrefix + "/service-accounts/{accountId}":    ServiceAccountWrite,
+	"DELETE " + orgPrefix + "/service-accounts/{accountId}": ServiceAccountWrite,
+	"GET " + orgPrefix + "/domains":                         DomainRead,
+	"POST " + orgPrefix + "/domains":                        DomainWrite,
+	"PUT " + orgPrefix + "/domains/{domainId}":              DomainWrite,
+	"DELETE " + orgPrefix + "/domains/{domainId}":           DomainWrite,
+	"POST " + orgPrefix + "/domains/{domainId}/verify":      DomainWrite,
+	"GET " + orgPrefix + "/sso":                             SSORead,
+	"PUT " + orgPrefix + "/sso":                             SSOWrite,
+	"DELETE " + orgPrefix + "/sso":                          SSOWrite,
+	"GET " + orgPrefix + "/scim/tokens":                     SCIMTokenRead,
+	"POST " + orgPrefix + "/scim/tokens":                    SCIMTokenWrite,
+	"DELETE " + orgPrefix + "/scim/tokens/{tokenId}":        SCIMTokenWrite,
+	"GET " + orgPrefix + "/scim/groups":                     SCIMGroupRead,
+	"PUT " + orgPrefix + "/scim/groups/{groupId}":           SCIMGroupWrite,
+
+	"GET " + orgPrefix + "/members":                            MemberList,
+	"DELETE " + orgPrefix + "/members/{memberId}":              MemberUpdate,
+	"PUT " + orgPrefix + "/members/{memberId}/role":            MemberUpdate,
+	"POST " + orgPrefix + "/members/{memberId}/suspend":        MemberUpdate,
+	"POST " + orgPrefix + "/members/{memberId}/reactivate":     MemberUpdate,
+	"GET " + orgPrefix + "/ownership-transfer":                 OwnershipRead,
+	"POST " + orgPrefix + "/ownership-transfer":                OwnershipWrite,
+	"DELETE " + orgPrefix + "/ownership-transfer":              OwnershipWrite,
+	"POST " + orgPrefix + "/ownership-transfer/accept":         OwnershipWrite,
+	"POST " + orgPrefix + "/invitations":                       InvitationWrite,
+	"POST " + orgPrefix + "/invitations/bulk":                  InvitationWrite,
+	"GET " + orgPrefix + "/invitations":                        InvitationList,
+	"DELETE " + orgPrefix + "/invitations/{invitationId}":      InvitationWrite,
+	"POST " + orgPrefix + "/invitations/{invitationId}/resend": InvitationWrite,
+
+	"GET " + orgPrefix + "/projects":                   ProjectList,
+	"POST " + orgPrefix + "/projects":                  ProjectCreate,
+	"GET " + projPrefix:                                ProjectRead,
+	"PUT " + projPrefix:                                ProjectUpdate,
+	"GET " + projPrefix + "/members":                   ProjectMemberList,
+	"PUT " + projPrefix + "/members/{memberId}":        ProjectMemberSet,
+	"DELETE " + projPrefix + "/members/{memberId}":     ProjectMemberRemove,
+	"PUT " + projPrefix + "/connection":                ProjectConnection,
+	"DELETE " + projPrefix + "/connection":             ProjectConnection,
+	"GET " + projPrefix + "/queries":                   SavedQueryRead,
+	"POST " + projPrefix + "/queries":                  SavedQueryWrite,
+	"PUT " + projPrefix + "/queries/{queryId}":         SavedQueryWrite,
+	"DELETE " + projPrefix + "/queries/{queryId}":      SavedQueryWrite,
+	"GET " + projPrefix + "/policies":                  AccessPolicyRead,
+	"POST " + projPrefix + "/policies":                 AccessPolicyWrite,
+	"PUT " + projPrefix + "/policies/{policyId}":       AccessPolicyWrite,
+	"DELETE " + projPrefix + "/policies/{policyId}":    AccessPolicyWrite,
+	"GET " + projPrefix + "/masking-rules":             MaskingRuleRead,
+	"POST " + projPrefix + "/masking-rules":            MaskingRuleWrite,
+	"GET " + projPrefix + "/masking-rules/suggestions": MaskingRuleRead,
+	"PUT " + projPrefix + "/masking-rules/{ruleId}":    MaskingRuleWrite,
+	"DELETE " + projPrefix + "/masking-rules/{ruleId}": MaskingRuleWrite,
+	"POST " + projPrefix + "/sql/execute":              ProjectQuery,
+	"GET " + projPrefix + "/sql/schema":                ProjectSchemaRead,
+	"GET " + projPrefix + "/sql/docs":                  ProjectSchemaRead,
+	"GET " + projPrefix + "/sql/docs/descriptions":     ProjectSchemaRead,
+	"PUT " + projPrefix + "/sql/docs/descriptions":     ProjectSchemaWrite,
+	"POST " + projPrefix + "/invitations":              ProjectInvitation,
+
+	"POST /api/v1/invitations/{token}/accept": InvitationAccept,
+
+	"POST /api/v1/metrics":        MetricsWrite,
+	"GET /api/v1/metrics":         MetricsRead,
+	"GET /api/v1/metrics/summary": MetricsRead,
+
+	"POST /api/v1/admin/users":                                 UserProvision,
+	"GET /api/v1/admin/plans":                                  PlanRead,
+	"PUT /api/v1/admin/plans/{planId}":                         PlanWrite,
+	"DELETE /api/v1/admin/plans/{planId}":                      PlanWrite,
+	"GET /api/v1/admin/organizations/{orgId}/entitlements":     EntitlementsRead,
+	"PUT /api/v1/admin/organizations/{orgId}/plan":             OrganizationPlanWrite,
+	"PUT /api/v1/admin/organizations/{orgId}/billing-anchor":   OrganizationPlanWrite,
+	"PUT /api/v1/admin/organizations/{orgId}/plan-override":    OrganizationPlanWrite,
+	"DELETE /api/v1/admin/organizations/{orgId}/plan-override": OrganizationPlanWrite,
+	"GET /api/v1/admin/audit-log":                              PlatformAuditLogRead,
+	"GET /api/v1/admin/audit-log/export":                       PlatformAuditLogExport,
+	"GET /api/v1/admin/audit-log/verify":                       PlatformAuditLogRead,
+	"GET /api/v1/admin/audit-log/checkpoints":                  PlatformAuditLogRead,
+	"POST /api/v1/admin/audit-log/checkpoints":                 PlatformAuditLogSign,
+}
@@ -0,0 +1,88 @@
+package authz
+
+import (
+	"context"
+	"errors"
+
+	"go-backend/database"
+	"go-backend/models"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// ErrProjectNotFound is returned when a project does not exist or the user
+// cannot see it.
+var ErrProjectNotFound = errors.New("project not found or access denied")
+
+// EffectiveProjectRole works out a member's role on a project. Organization
+// owners and admins administer every project. Other members get the role
+// granted in project_members, raised to viewer on public projects and for
+// members whose access covers all projects. An empty role means no access.
+func EffectiveProjectRole(orgRole, projectAccess, granted string, isPublic bool) string {
+	if orgRole == RoleOwner || orgRole == RoleAdmin {
+		return models.ProjectRoleAdmin
+	}
+	if granted == "" && (projectAccess != "specific" || isPublic) {
+		return models.ProjectRoleViewer
+	}
+	return granted
+}
+
+// ProjectAccess is what a user may do on one project.
+type ProjectAccess struct {
+	UserID           string
+	OrganizationID   string
+	ProjectID        string
+	OrgRole          string
+	Role             string
+	ReadOnly         bool
+	ConnectionUserID *string
+}
+
+// Allows reports whether the access includes role.
+func (a *ProjectAccess) Allows(role string) bool {
+	return ProjectRoleAtLeast(a.Role, role)
+}
+
+// LoadProjectAccess resolves userID's role on a project of orgID, returning
+// ErrProjectNotFound when the project does not exist or the user cannot see
+// it.
+func LoadProjectAccess(ctx context.Context, db *database.PostgresDB, userID, orgID, projectID string) (*ProjectAccess, error) {
+	a := &ProjectAccess{UserID: userID, OrganizationID: orgID, ProjectID: projectID}
+	var projectAccessType, granted string
+	var isPublic bool
+	err := db.QueryRow(ctx, `
+		SELECT om.role, om.project_access, COALESCE(pm.role, ''), p.is_public, p.read_only, p.connection_user_id
+		FROM projects p
+		INNER JOIN organization_members om
+			ON om.organization_id = p.organization_id AND om.user_id = $1 AND om.status = 'active'
+		LEFT JOIN project_members pm ON pm.project_id = p.id AND pm.user_id = $1
+		WHERE p.id = $2 AND p.organization_id = $3
+	`, userID, projectID, orgID).Scan(&a.OrgRole, &projectAccessType, &granted, &isPublic, &a.ReadOnly, &a.ConnectionUserID)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, ErrProjectNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	a.Role = EffectiveProjectRole(a.OrgRole, projectAccessType, granted, isPublic)
+	if a.Role == "" {
+		return nil, ErrProjectNotFound
+	}
+	return a, nil
+}
+
+// loadOrganizationRole returns userID's active role in orgID, or "" when
+// they are not an active member.
+func loadOrganizationRole(ctx context.Context, db *database.PostgresDB, userID, orgID string) (string, error) {
+	var role string
+	err := db.QueryRow(ctx, `
+		SELECT role FROM organization_members
+		WHERE organization_id = $1 AND user_id = $2 AND status = 'active'
+	`, orgID, userID).Scan(&role)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return "", nil
+	}
+	return role, err
+}
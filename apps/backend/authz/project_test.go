@@ -0,0 +1,53 @@
+package authz
+
+import (
+	"testing"
+
+	"go-backend/models"
+)
+
+func TestEffectiveProjectRole(t *testing.T) {
+	tests := []struct {
+		name          string
+		orgRole       string
+		projectAccess string
+		granted       string
+		isPublic      bool
+		want          string
+	}{
+		{"owner", RoleOwner, "specific", "", false, models.ProjectRoleAdmin},
+		{"admin granted viewer", RoleAdmin, "specific", models.ProjectRoleViewer, false, models.ProjectRoleAdmin},
+		{"member of all projects", RoleMember, "all", "", false, models.ProjectRoleViewer},
+		{"member of all projects granted editor", RoleMember, "all", models.ProjectRoleEditor, false, models.ProjectRoleEditor},
+		{"member of specific projects, not granted", RoleMember, "specific", "", false, ""},
+		{"member of specific projects, granted", RoleMember, "specific", models.ProjectRoleAdmin, false, models.ProjectRoleAdmin},
+		{"public project", RoleMember, "specific", "", true, models.ProjectRoleViewer},
+		{"public project granted editor", RoleMember, "specific", models.ProjectRoleEditor, true, models.ProjectRoleEditor},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := EffectiveProjectRole(tt.orgRole, tt.projectAccess, tt.granted, tt.isPublic); got != tt.want {
+				t.Errorf("EffectiveProjectRole = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestProjectAccessAllows(t *testing.T) {
+	roles := []string{models.ProjectRoleViewer, models.ProjectRoleEditor, models.ProjectRoleAdmin}
+	for i, role := range roles {
+		access := &ProjectAccess{Role: role}
+		for j, min := range roles {
+			if got := access.Allows(min); got != (i >= j) {
+				t.Errorf("%s allows %s = %v", role, min, got)
+			}
+		}
+	}
+
+	for _, role := range []string{"", "owner", "guest"} {
+		if (&ProjectAccess{Role: role}).Allows(models.ProjectRoleViewer) {
+			t.Errorf("role %q allows viewer", role)
+		}
+	}
+}
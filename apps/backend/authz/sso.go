@@ -0,0 +1,31 @@
+package authz
+
+import (
+	"context"
+
+	"go-backend/database"
+)
+
+// ssoRequired reports whether the organization enforces SSO and the
+// principal's session did not come from signing in through it. It applies
+// to members acting for themselves with a session or JWT; API keys are
+// minted by members who signed in, and owners are exempt so an organization
+// whose IdP is down or misconfigured can still be managed.
+func ssoRequired(ctx context.Context, db *database.PostgresDB, p Principal, t Target) (bool, error) {
+	if t.OrgRole == "" || t.OrgRole == RoleOwner || p.UserID != t.UserID || p.APIKey {
+		return false, nil
+	}
+
+	var required bool
+	err := db.QueryRow(ctx, `
+		SELECT EXISTS (
+			SELECT 1 FROM sso_connections c
+			WHERE c.organization_id = $1 AND c.enabled AND c.enforced AND c.entitled
+				AND NOT EXISTS (
+					SELECT 1 FROM sso_sessions s
+					WHERE s.session_id = $2 AND s.organization_id = $1
+				)
+		)
+	`, t.OrganizationID, p.SessionID).Scan(&required)
+	return required, err
+}
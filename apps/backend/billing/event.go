@@ -0,0 +1,106 @@
+package billing
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// stripeEvent is the envelope of a Stripe webhook event.
+type stripeEvent struct {
+	ID      string `json:"id"`
+	Type    string `json:"type"`
+	Created int64  `json:"created"`
+	Data    struct {
+		Object json.RawMessage `json:"object"`
+	} `json:"data"`
+}
+
+type stripeCheckoutSession struct {
+	ID                string            `json:"id"`
+	URL               string            `json:"url"`
+	Customer          string            `json:"customer"`
+	Subscription      string            `json:"subscription"`
+	ClientReferenceID string            `json:"client_reference_id"`
+	Metadata          map[string]string `json:"metadata"`
+}
+
+type stripeSubscription struct {
+	ID                string            `json:"id"`
+	Customer          string            `json:"customer"`
+	Status            string            `json:"status"`
+	CancelAtPeriodEnd bool              `json:"cancel_at_period_end"`
+	CurrentPeriodEnd  int64             `json:"current_period_end"`
+	Metadata          map[string]string `json:"metadata"`
+	Items             struct {
+		Data []struct {
+			CurrentPeriodEnd int64 `json:"current_period_end"`
+			Price            struct {
+				ID string `json:"id"`
+			} `json:"price"`
+		} `json:"data"`
+	} `json:"items"`
+}
+
+// parseEvent decodes a Stripe-format event. Unknown types decode to an
+// Event with no object so callers can acknowledge and ignore them.
+func parseEvent(payload []byte) (*Event, error) {
+	var raw stripeEvent
+	if err := json.Unmarshal(payload, &raw); err != nil {
+		return nil, fmt.Errorf("invalid event payload: %w", err)
+	}
+	if raw.ID == "" || raw.Type == "" {
+		return nil, fmt.Errorf("invalid event payload: missing id or type")
+	}
+
+	event := &Event{ID: raw.ID, Type: raw.Type, Created: time.Unix(raw.Created, 0)}
+
+	switch raw.Type {
+	case EventCheckoutCompleted:
+		var session stripeCheckoutSession
+		if err := json.Unmarshal(raw.Data.Object, &session); err != nil {
+			return nil, fmt.Errorf("invalid checkout session: %w", err)
+		}
+		orgID := session.ClientReferenceID
+		if orgID == "" {
+			orgID = session.Metadata["organization_id"]
+		}
+		event.Checkout = &CheckoutSession{
+			ID:             session.ID,
+			URL:            session.URL,
+			CustomerID:     session.Customer,
+			SubscriptionID: session.Subscription,
+			OrganizationID: orgID,
+		}
+
+	case EventSubscriptionCreated, EventSubscriptionUpdated, EventSubscriptionDeleted:
+		var sub stripeSubscription
+		if err := json.Unmarshal(raw.Data.Object, &sub); err != nil {
+			return nil, fmt.Errorf("invalid subscription: %w", err)
+		}
+		subscription := &Subscription{
+			ID:                sub.ID,
+			CustomerID:        sub.Customer,
+			Status:            sub.Status,
+			OrganizationID:    sub.Metadata["organization_id"],
+			CancelAtPeriodEnd: sub.CancelAtPeriodEnd,
+		}
+		periodEnd := sub.CurrentPeriodEnd
+		if len(sub.Items.Data) > 0 {
+			subscription.PriceID = sub.Items.Data[0].Price.ID
+			// Newer API versions report the period per item
+			if periodEnd == 0 {
+				periodEnd = sub.Items.Data[0].CurrentPeriodEnd
+			}
+		}
+		if periodEnd > 0 {
+			subscription.CurrentPeriodEnd = time.Unix(periodEnd, 0)
+		}
+		if raw.Type == EventSubscriptionDeleted && subscription.Status == "" {
+			subscription.Status = "canceled"
+		}
+		event.Subscription = subscription
+	}
+
+	return event, nil
+}
@@ -0,0 +1,165 @@
+// Package billing talks to the payment provider that sells plans.
+// Handlers depend only on the Provider interface; which implementation is
+// used is decided once at startup from configuration. Events arrive in the
+// Stripe format, which the mock provider also uses, so the rest of the
+// server never sees provider-specific payloads.
+package billing
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// ErrNotConfigured is returned when no provider has been set up.
+var ErrNotConfigured = errors.New("billing provider is not configured")
+
+// Event types the server acts on.
+const (
+	EventCheckoutCompleted   = "checkout.session.completed"
+	EventSubscriptionCreated = "customer.subscription.created"
+	EventSubscriptionUpdated = "customer.subscription.updated"
+	EventSubscriptionDeleted = "customer.subscription.deleted"
+)
+
+type CustomerParams struct {
+	Email          string
+	Name           string
+	OrganizationID string
+}
+
+type Customer struct {
+	ID string
+}
+
+type CheckoutParams struct {
+	CustomerID     string
+	PriceID        string
+	OrganizationID string
+	SuccessURL     string
+	CancelURL      string
+}
+
+type CheckoutSession struct {
+	ID             string `json:"id"`
+	URL            string `json:"url"`
+	CustomerID     string `json:"customer_id,omitempty"`
+	SubscriptionID string `json:"subscription_id,omitempty"`
+	OrganizationID string `json:"organization_id,omitempty"`
+}
+
+type Subscription struct {
+	ID                string    `json:"id"`
+	CustomerID        string    `json:"customer_id"`
+	Status            string    `json:"status"`
+	PriceID           string    `json:"price_id"`
+	OrganizationID    string    `json:"organization_id,omitempty"`
+	CurrentPeriodEnd  time.Time `json:"current_period_end"`
+	CancelAtPeriodEnd bool      `json:"cancel_at_period_end"`
+}
+
+// Entitled reports whether the subscription should keep its plan. Past-due
+// subscriptions keep it while the provider retries payment.
+func (s *Subscription) Entitled() bool {
+	switch s.Status {
+	case "active", "trialing", "past_due":
+		return true
+	}
+	return false
+}
+
+// Event is a verified webhook event. Only the object matching its type is
+// set.
+type Event struct {
+	ID           string
+	Type         string
+	Created      time.Time
+	Checkout     *CheckoutSession
+	Subscription *Subscription
+}
+
+// Outdated reports whether a subscription event must not be applied over
+// what was last applied for its organization: providers do not guarantee
+// delivery order, so an older event must not undo a newer one, and the end
+// of a subscription the organization has since replaced does not affect it.
+// An empty currentSubscriptionID or zero lastEventAt means none is known.
+func (e *Event) Outdated(currentSubscriptionID string, lastEventAt time.Time) bool {
+	if !lastEventAt.IsZero() && e.Created.Before(lastEventAt) {
+		return true
+	}
+	return e.Type == EventSubscriptionDeleted && e.Subscription != nil &&
+		currentSubscriptionID != "" && currentSubscriptionID != e.Subscription.ID
+}
+
+// PlanSource is where a subscription event takes its organization's plan
+// from.
+type PlanSource int
+
+const (
+	// PlanUnchanged leaves the plan alone.
+	PlanUnchanged PlanSource = iota
+	// PlanFromPrice is the plan sold under the subscription's price.
+	PlanFromPrice
+	// PlanDefault is the default plan.
+	PlanDefault
+)
+
+// PlanSource is the plan the event entitles its organization to: the one
+// sold under its price while the subscription is in good standing, the
+// default plan once it has ended. It depends only on the event, so applying
+// an event again leads to the same plan.
+func (e *Event) PlanSource() PlanSource {
+	sub := e.Subscription
+	if sub == nil {
+		return PlanUnchanged
+	}
+	if e.Type != EventSubscriptionDeleted && sub.Entitled() {
+		return PlanFromPrice
+	}
+	// Incomplete subscriptions have not been paid for yet and never
+	// granted a plan, so there is nothing to take away
+	if sub.Status == "incomplete" {
+		return PlanUnchanged
+	}
+	return PlanDefault
+}
+
+// Provider is a subscription billing backend.
+type Provider interface {
+	Name() string
+	CreateCustomer(ctx context.Context, params CustomerParams) (*Customer, error)
+	CreateCheckoutSession(ctx context.Context, params CheckoutParams) (*CheckoutSession, error)
+	// ParseWebhook verifies the signature header against the raw request
+	// body and decodes the event.
+	ParseWebhook(payload []byte, signature string) (*Event, error)
+}
+
+type Config struct {
+	Provider      string // "stripe", "mock" or empty to disable
+	BaseURL       string
+	APIKey        string
+	WebhookSecret string
+	Timeout       time.Duration
+}
+
+// NewProvider builds the provider named in cfg. An empty provider name
+// returns (nil, nil) so the server can run without billing.
+func NewProvider(cfg Config) (Provider, error) {
+	switch cfg.Provider {
+	case "":
+		return nil, nil
+	case "stripe":
+		if cfg.APIKey == "" {
+			return nil, fmt.Errorf("BILLING_API_KEY is required for the stripe provider")
+		}
+		if cfg.WebhookSecret == "" {
+			return nil, fmt.Errorf("BILLING_WEBHOOK_SECRET is required for the stripe provider")
+		}
+		return NewStripeProvider(cfg.BaseURL, cfg.APIKey, cfg.WebhookSecret, cfg.Timeout), nil
+	case "mock":
+		return NewMockProvider(cfg.WebhookSecret), nil
+	default:
+		return nil, fmt.Errorf("unknown billing provider %q", cfg.Provider)
+	}
+}
@@ -0,0 +1,95 @@
+package billing
+
+import (
+	"testing"
+	"time"
+)
+
+func subscriptionEvent(eventType, subscriptionID, status, priceID string, created time.Time) *Event {
+	return &Event{
+		ID:      "evt_" + subscriptionID + "_" + status,
+		Type:    eventType,
+		Created: created,
+		Subscription: &Subscription{
+			ID:         subscriptionID,
+			Status:     status,
+			PriceID:    priceID,
+			CustomerID: "cus_1",
+		},
+	}
+}
+
+func TestParseEventSubscription(t *testing.T) {
+	event, err := parseEvent([]byte(`{"id":"evt_1","type":"customer.subscription.updated","created":1700000000,
+		"data":{"object":{"id":"sub_1","customer":"cus_1","status":"active","cancel_at_period_end":true,
+			"metadata":{"organization_id":"o1"},
+			"items":{"data":[{"current_period_end":1702592000,"price":{"id":"price_team"}}]}}}}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	sub := event.Subscription
+	if event.ID != "evt_1" || !event.Created.Equal(time.Unix(1700000000, 0)) || sub == nil {
+		t.Fatalf("event = %+v", event)
+	}
+	if sub.ID != "sub_1" || sub.CustomerID != "cus_1" || sub.OrganizationID != "o1" || sub.PriceID != "price_team" ||
+		!sub.CancelAtPeriodEnd || !sub.CurrentPeriodEnd.Equal(time.Unix(1702592000, 0)) {
+		t.Errorf("subscription = %+v", sub)
+	}
+
+	if _, err := parseEvent([]byte(`{"type":"customer.subscription.updated"}`)); err == nil {
+		t.Error("parsed an event without an ID")
+	}
+}
+
+func TestPlanChangeIsIdempotent(t *testing.T) {
+	t1 := time.Unix(1700000000, 0)
+	t2 := t1.Add(time.Hour)
+	created := subscriptionEvent(EventSubscriptionCreated, "sub_1", "active", "price_team", t1)
+
+	// Applying an event again, as on a retry, lands on the same plan
+	if created.Outdated("sub_1", t1) {
+		t.Fatal("an event was outdated by itself")
+	}
+	if created.PlanSource() != PlanFromPrice {
+		t.Fatalf("plan source = %v", created.PlanSource())
+	}
+
+	// A late delivery of it must not undo what came after
+	if !created.Outdated("sub_1", t2) {
+		t.Error("an older event was applied over a newer one")
+	}
+
+	// Ending a replaced subscription leaves the plan of the new one
+	deleted := subscriptionEvent(EventSubscriptionDeleted, "sub_1", "canceled", "price_team", t2)
+	if !deleted.Outdated("sub_2", t1) {
+		t.Error("the end of a replaced subscription was applied")
+	}
+	if deleted.Outdated("sub_1", t1) || deleted.PlanSource() != PlanDefault {
+		t.Error("the end of the current subscription did not restore the default plan")
+	}
+	if deleted.Outdated("", time.Time{}) {
+		t.Error("an event was outdated with nothing applied yet")
+	}
+}
+
+func TestPlanSource(t *testing.T) {
+	now := time.Now()
+	tests := []struct {
+		name  string
+		event *Event
+		want  PlanSource
+	}{
+		{"active", subscriptionEvent(EventSubscriptionUpdated, "sub_1", "active", "price_team", now), PlanFromPrice},
+		{"trialing", subscriptionEvent(EventSubscriptionCreated, "sub_1", "trialing", "price_team", now), PlanFromPrice},
+		{"past due", subscriptionEvent(EventSubscriptionUpdated, "sub_1", "past_due", "price_team", now), PlanFromPrice},
+		{"incomplete", subscriptionEvent(EventSubscriptionCreated, "sub_1", "incomplete", "price_team", now), PlanUnchanged},
+		{"unpaid", subscriptionEvent(EventSubscriptionUpdated, "sub_1", "unpaid", "price_team", now), PlanDefault},
+		{"deleted while active", subscriptionEvent(EventSubscriptionDeleted, "sub_1", "active", "price_team", now), PlanDefault},
+		{"checkout", &Event{Type: EventCheckoutCompleted, Checkout: &CheckoutSession{ID: "cs_1"}}, PlanUnchanged},
+	}
+	for _, tt := range tests {
+		if got := tt.event.PlanSource(); got != tt.want {
+			t.Errorf("%s: plan source = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
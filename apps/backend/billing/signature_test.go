@@ -0,0 +1,83 @@
+package billing
+
+import (
+	"bytes"
+	"errors"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+const testSecret = "whsec_test"
+
+func TestVerifySignature(t *testing.T) {
+	now := time.Now()
+	payload := []byte(`{"id":"evt_1","type":"customer.subscription.updated"}`)
+	valid := Sign(payload, testSecret, now)
+	v1 := strings.Split(valid, ",")[1]
+
+	tests := []struct {
+		name    string
+		payload []byte
+		header  string
+		ok      bool
+	}{
+		{"valid", payload, valid, true},
+		{"rotated secret alongside", payload, valid + ",v1=" + computeSignature("1", payload, "whsec_old"), true},
+		{"another secret", payload, Sign(payload, "whsec_other", now), false},
+		{"changed payload", bytes.Replace(payload, []byte("evt_1"), []byte("evt_2"), 1), valid, false},
+		{"changed timestamp", payload, "t=" + strconv.FormatInt(now.Unix()-1, 10) + "," + v1, false},
+		{"stale", payload, Sign(payload, testSecret, now.Add(-SignatureTolerance-time.Second)), false},
+		{"from the future", payload, Sign(payload, testSecret, now.Add(SignatureTolerance+time.Second)), false},
+		{"just within tolerance", payload, Sign(payload, testSecret, now.Add(-SignatureTolerance+time.Second)), true},
+		{"no signature", payload, strings.Split(valid, ",")[0], false},
+		{"no timestamp", payload, v1, false},
+		{"bad timestamp", payload, "t=soon," + v1, false},
+		{"empty", payload, "", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := VerifySignature(tt.payload, tt.header, testSecret, now)
+			if tt.ok && err != nil {
+				t.Fatal(err)
+			}
+			if !tt.ok && !errors.Is(err, ErrInvalidSignature) {
+				t.Fatalf("error = %v, want an invalid signature", err)
+			}
+		})
+	}
+}
+
+func TestReplayedEvent(t *testing.T) {
+	p := NewMockProvider(testSecret)
+	payload := []byte(`{"id":"evt_1","type":"customer.subscription.updated","created":1700000000,
+		"data":{"object":{"id":"sub_1","customer":"cus_1","status":"active"}}}`)
+
+	// A provider retry and a replay of the same request carry the same
+	// event ID, which the webhook handler records to apply it once
+	first, err := p.ParseWebhook(payload, Sign(payload, testSecret, time.Now()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	again, err := p.ParseWebhook(payload, Sign(payload, testSecret, time.Now().Add(-time.Minute)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if first.ID != "evt_1" || again.ID != first.ID {
+		t.Errorf("event IDs = %q, %q, want evt_1 twice", first.ID, again.ID)
+	}
+
+	// So a replay can neither be given a fresh ID nor be sent once the
+	// signature is stale
+	signature := Sign(payload, testSecret, time.Now())
+	renamed := bytes.Replace(payload, []byte(`"evt_1"`), []byte(`"evt_2"`), 1)
+	if _, err := p.ParseWebhook(renamed, signature); !errors.Is(err, ErrInvalidSignature) {
+		t.Errorf("event with a new ID under the old signature: error = %v", err)
+	}
+	stale := Sign(payload, testSecret, time.Now().Add(-SignatureTolerance-time.Minute))
+	if _, err := p.ParseWebhook(payload, stale); !errors.Is(err, ErrInvalidSignature) {
+		t.Errorf("stale replay: error = %v", err)
+	}
+}
@@ -0,0 +1,134 @@
+package billing
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const defaultStripeBaseURL = "https://api.stripe.com"
+
+// StripeProvider calls the Stripe API, or anything that speaks it such as
+// stripe-mock running locally (set BILLING_API_URL=http://localhost:12111).
+type StripeProvider struct {
+	baseURL       string
+	apiKey        string
+	webhookSecret string
+	httpClient    *http.Client
+	now           func() time.Time
+}
+
+func NewStripeProvider(baseURL, apiKey, webhookSecret string, timeout time.Duration) *StripeProvider {
+	if baseURL == "" {
+		baseURL = defaultStripeBaseURL
+	}
+	if timeout == 0 {
+		timeout = 30 * time.Second
+	}
+	return &StripeProvider{
+		baseURL:       strings.TrimRight(baseURL, "/"),
+		apiKey:        apiKey,
+		webhookSecret: webhookSecret,
+		httpClient:    &http.Client{Timeout: timeout},
+		now:           time.Now,
+	}
+}
+
+func (p *StripeProvider) Name() string {
+	return "stripe"
+}
+
+type stripeError struct {
+	Error *struct {
+		Type    string `json:"type"`
+		Message string `json:"message"`
+	} `json:"error"`
+}
+
+func (p *StripeProvider) CreateCustomer(ctx context.Context, params CustomerParams) (*Customer, error) {
+	form := url.Values{}
+	form.Set("email", params.Email)
+	if params.Name != "" {
+		form.Set("name", params.Name)
+	}
+	form.Set("metadata[organization_id]", params.OrganizationID)
+
+	var customer struct {
+		ID string `json:"id"`
+	}
+	if err := p.post(ctx, "/v1/customers", form, &customer); err != nil {
+		return nil, err
+	}
+	return &Customer{ID: customer.ID}, nil
+}
+
+func (p *StripeProvider) CreateCheckoutSession(ctx context.Context, params CheckoutParams) (*CheckoutSession, error) {
+	form := url.Values{}
+	form.Set("mode", "subscription")
+	form.Set("customer", params.CustomerID)
+	form.Set("line_items[0][price]", params.PriceID)
+	form.Set("line_items[0][quantity]", "1")
+	form.Set("success_url", params.SuccessURL)
+	form.Set("cancel_url", params.CancelURL)
+	form.Set("client_reference_id", params.OrganizationID)
+	form.Set("metadata[organization_id]", params.OrganizationID)
+	// Copied onto the subscription so its events can be matched to the
+	// organization without a lookup
+	form.Set("subscription_data[metadata][organization_id]", params.OrganizationID)
+
+	var session stripeCheckoutSession
+	if err := p.post(ctx, "/v1/checkout/sessions", form, &session); err != nil {
+		return nil, err
+	}
+	return &CheckoutSession{
+		ID:             session.ID,
+		URL:            session.URL,
+		CustomerID:     params.CustomerID,
+		OrganizationID: params.OrganizationID,
+	}, nil
+}
+
+func (p *StripeProvider) ParseWebhook(payload []byte, signature string) (*Event, error) {
+	if err := VerifySignature(payload, signature, p.webhookSecret, p.now()); err != nil {
+		return nil, err
+	}
+	return parseEvent(payload)
+}
+
+func (p *StripeProvider) post(ctx context.Context, path string, form url.Values, dest interface{}) error {
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, p.baseURL+path, strings.NewReader(form.Encode()))
+	if err != nil {
+		return err
+	}
+	httpReq.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	httpReq.Header.Set("Authorization", "Bearer "+p.apiKey)
+
+	resp, err := p.httpClient.Do(httpReq)
+	if err != nil {
+		return fmt.Errorf("billing request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	data, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return fmt.Errorf("failed to read billing response: %w", err)
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		var apiErr stripeError
+		if json.Unmarshal(data, &apiErr) == nil && apiErr.Error != nil {
+			return fmt.Errorf("billing provider returned %d: %s", resp.StatusCode, apiErr.Error.Message)
+		}
+		return fmt.Errorf("billing provider returned %d", resp.StatusCode)
+	}
+
+	if err := json.Unmarshal(data, dest); err != nil {
+		return fmt.Errorf("invalid billing response: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,145 @@
+// Command audit-verify checks an audit log export against signed checkpoints
+// without access to the database:
+//
+//	audit-verify -entries audit-log.jsonl -checkpoints audit-checkpoints.jsonl -public-key <base64>
+//
+// The entries are a JSON lines export from the audit log export endpoint and
+// the checkpoints come from AUDIT_CHECKPOINT_FILE or the checkpoints
+// endpoint. It prints one verification per chain and exits with status 1 if
+// any chain fails.
+package main
+
+import (
+	"bufio"
+	"crypto/ed25519"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+
+	"go-backend/audit"
+)
+
+func main() {
+	entriesPath := flag.String("entries", "", "audit log export in JSON lines (required)")
+	checkpointsPath := flag.String("checkpoints", "", "checkpoints in JSON lines")
+	publicKey := flag.String("public-key", "", "base64 Ed25519 public key the checkpoints are signed with")
+	partial := flag.Bool("partial", false, "the export does not cover each chain from its first entry to its latest checkpoint")
+	flag.Parse()
+
+	if *entriesPath == "" {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	ok, err := run(*entriesPath, *checkpointsPath, *publicKey, *partial)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "audit-verify:", err)
+		os.Exit(2)
+	}
+	if !ok {
+		os.Exit(1)
+	}
+}
+
+func run(entriesPath, checkpointsPath, publicKey string, partial bool) (bool, error) {
+	var key ed25519.PublicKey
+	if publicKey != "" {
+		var err error
+		if key, err = audit.ParsePublicKey(publicKey); err != nil {
+			return false, err
+		}
+	} else if checkpointsPath != "" {
+		fmt.Fprintln(os.Stderr, "audit-verify: no -public-key given, checkpoint signatures are not checked")
+	}
+
+	entries := map[string][]audit.Entry{}
+	err := readLines(entriesPath, func(line []byte) error {
+		var e audit.Entry
+		if err := json.Unmarshal(line, &e); err != nil {
+			return err
+		}
+		chain := chainOf(e.OrganizationID)
+		entries[chain] = append(entries[chain], e)
+		return nil
+	})
+	if err != nil {
+		return false, fmt.Errorf("failed to read entries: %w", err)
+	}
+
+	checkpoints := map[string][]audit.Checkpoint{}
+	if checkpointsPath != "" {
+		err := readLines(checkpointsPath, func(line []byte) error {
+			var c audit.Checkpoint
+			if err := json.Unmarshal(line, &c); err != nil {
+				return err
+			}
+			chain := chainOf(c.OrganizationID)
+			checkpoints[chain] = append(checkpoints[chain], c)
+			return nil
+		})
+		if err != nil {
+			return false, fmt.Errorf("failed to read checkpoints: %w", err)
+		}
+	}
+
+	chains := make([]string, 0, len(entries))
+	for chain := range entries {
+		chains = append(chains, chain)
+	}
+	sort.Strings(chains)
+
+	valid := true
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	for _, chain := range chains {
+		// Exports list the newest entries first
+		chainEntries := entries[chain]
+		sort.Slice(chainEntries, func(i, j int) bool { return chainEntries[i].ID < chainEntries[j].ID })
+
+		v := audit.NewVerifier(checkpoints[chain], key)
+		if partial {
+			v.Partial()
+		}
+		for _, e := range chainEntries {
+			v.Add(e)
+		}
+		result := v.Finish()
+		result.OrganizationID = chain
+		valid = valid && result.Valid
+		if err := enc.Encode(result); err != nil {
+			return false, err
+		}
+	}
+	return valid, nil
+}
+
+// chainOf names the chain an entry or checkpoint belongs to; entries
+// outside any organization share the empty name.
+func chainOf(orgID *string) string {
+	if orgID == nil {
+		return ""
+	}
+	return *orgID
+}
+
+func readLines(path string, fn func([]byte) error) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 0, 64*1024), 16*1024*1024)
+	for n := 1; scanner.Scan(); n++ {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		if err := fn(scanner.Bytes()); err != nil {
+			return fmt.Errorf("line %d: %w", n, err)
+		}
+	}
+	return scanner.Err()
+}
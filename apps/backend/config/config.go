@@ -14,6 +14,16 @@ type Config struct {
 	RedisURL     string
 	JWTSecret    string
 	BetterAuthSecret string
+	BetterAuthSessionURL      string
+	BetterAuthSessionCacheTTL time.Duration
+	
+	JWTJWKSURL            string
+	JWTPublicKeyFile      string
+	JWTIssuer             string
+	JWTAudience           string
+	JWTClockSkew          time.Duration
+	JWTKeyRefreshInterval time.Duration
+	JWTTrustEmailVerified bool
 	
 	SSHHost     string
 	SSHPort     string
@@ -23,6 +33,37 @@ type Config struct {
 	RateLimitRPS int
 	RateLimitBurst int
 	
+	AIProvider string
+	AIBaseURL  string
+	AIAPIKey   string
+	AIModel    string
+	AITimeout  time.Duration
+	
+	BillingProvider      string
+	BillingAPIURL        string
+	BillingAPIKey        string
+	BillingWebhookSecret string
+	BillingTimeout       time.Duration
+	
+	AuditSigningKey         string
+	AuditCheckpointFile     string
+	AuditCheckpointInterval time.Duration
+	
+	AppURL             string
+	MailProvider       string
+	MailFrom           string
+	SMTPHost           string
+	SMTPPort           int
+	SMTPUsername       string
+	SMTPPassword       string
+	MailTimeout        time.Duration
+	MailOutboxInterval time.Duration
+	
+	APIURL          string
+	SSOCookieDomain string
+	SSOTimeout      time.Duration
+	SSOMockIdP      bool
+	
 	LogLevel string
 }
 
@@ -35,6 +76,16 @@ func Load() (*Config, error) {
 		RedisURL:     getEnv("REDIS_URL", "redis://localhost:6379"),
 		JWTSecret:    getEnv("JWT_SECRET", ""),
 		BetterAuthSecret: getEnv("BETTER_AUTH_SECRET", ""),
+		BetterAuthSessionURL:      getEnv("BETTER_AUTH_SESSION_URL", ""),
+		BetterAuthSessionCacheTTL: getEnvDuration("BETTER_AUTH_SESSION_CACHE_TTL", time.Minute),
+		
+		JWTJWKSURL:            getEnv("JWT_JWKS_URL", ""),
+		JWTPublicKeyFile:      getEnv("JWT_PUBLIC_KEY_FILE", ""),
+		JWTIssuer:             getEnv("JWT_ISSUER", ""),
+		JWTAudience:           getEnv("JWT_AUDIENCE", ""),
+		JWTClockSkew:          getEnvDuration("JWT_CLOCK_SKEW", 30*time.Second),
+		JWTKeyRefreshInterval: getEnvDuration("JWT_KEY_REFRESH_INTERVAL", 15*time.Minute),
+		JWTTrustEmailVerified: getEnvBool("JWT_TRUST_EMAIL_VERIFIED", false),
 		
 		SSHHost:     getEnv("SSH_HOST", ""),
 		SSHPort:     getEnv("SSH_PORT", "22"),
@@ -44,6 +95,37 @@ func Load() (*Config, error) {
 		RateLimitRPS:   getEnvInt("RATE_LIMIT_RPS", 100),
 		RateLimitBurst: getEnvInt("RATE_LIMIT_BURST", 200),
 		
+		AIProvider: getEnv("AI_PROVIDER", ""),
+		AIBaseURL:  getEnv("AI_BASE_URL", "https://api.openai.com/v1"),
+		AIAPIKey:   getEnv("AI_API_KEY", ""),
+		AIModel:    getEnv("AI_MODEL", "gpt-4o-mini"),
+		AITimeout:  getEnvDuration("AI_TIMEOUT", 60*time.Second),
+		
+		BillingProvider:      getEnv("BILLING_PROVIDER", ""),
+		BillingAPIURL:        getEnv("BILLING_API_URL", "https://api.stripe.com"),
+		BillingAPIKey:        getEnv("BILLING_API_KEY", ""),
+		BillingWebhookSecret: getEnv("BILLING_WEBHOOK_SECRET", ""),
+		BillingTimeout:       getEnvDuration("BILLING_TIMEOUT", 30*time.Second),
+		
+		AuditSigningKey:         getEnv("AUDIT_SIGNING_KEY", ""),
+		AuditCheckpointFile:     getEnv("AUDIT_CHECKPOINT_FILE", ""),
+		AuditCheckpointInterval: getEnvDuration("AUDIT_CHECKPOINT_INTERVAL", time.Hour),
+		
+		AppURL:             getEnv("APP_URL", "http://localhost:3000"),
+		MailProvider:       getEnv("MAIL_PROVIDER", "log"),
+		MailFrom:           getEnv("MAIL_FROM", ""),
+		SMTPHost:           getEnv("SMTP_HOST", ""),
+		SMTPPort:           getEnvInt("SMTP_PORT", 587),
+		SMTPUsername:       getEnv("SMTP_USERNAME", ""),
+		SMTPPassword:       getEnv("SMTP_PASSWORD", ""),
+		MailTimeout:        getEnvDuration("MAIL_TIMEOUT", 30*time.Second),
+		MailOutboxInterval: getEnvDuration("MAIL_OUTBOX_INTERVAL", 30*time.Second),
+		
+		APIURL:          getEnv("API_URL", "http://localhost:8080"),
+		SSOCookieDomain: getEnv("SSO_COOKIE_DOMAIN", ""),
+		SSOTimeout:      getEnvDuration("SSO_TIMEOUT", 10*time.Second),
+		SSOMockIdP:      getEnvBool("SSO_MOCK_IDP", false),
+		
 		LogLevel: getEnv("LOG_LEVEL", "info"),
 	}
 	
@@ -73,4 +155,12 @@ func getEnvDuration(key string, defaultValue time.Duration) time.Duration {
 		}
 	}
 	return defaultValue
-} 
\ No newline at end of file
+} 
+func getEnvBool(key string, defaultValue bool) bool {
+	if value := os.Getenv(key); value != "" {
+		if boolValue, err := strconv.ParseBool(value); err == nil {
+			return boolValue
+		}
+	}
+	return defaultValue
+}
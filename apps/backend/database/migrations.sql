@@ -5,6 +5,7 @@ CREATE TABLE IF NOT EXISTS organizations (
     slug VARCHAR(255) UNIQUE NOT NULL,
     description TEXT,
     plan VARCHAR(50) DEFAULT 'free',
+    billing_anchor TIMESTAMP WITH TIME ZONE, -- start of the first billing cycle; NULL means created_at
     created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
     updated_at TIMESTAMP WITH TIME ZONE DEFAULT NOW()
 );
@@ -20,6 +21,9 @@ CREATE TABLE IF NOT EXISTS organization_members (
     joined_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
     invited_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
     invited_by VARCHAR(255) REFERENCES users(user_id),
+    project_access VARCHAR(50) NOT NULL DEFAULT 'all', -- all: viewer on every project; specific: only projects in project_members
+    scim_managed BOOLEAN NOT NULL DEFAULT FALSE, -- provisioned or adopted by SCIM; its groups decide the role and project access
+    scim_external_id VARCHAR(255), -- the IdP's externalId
     UNIQUE(organization_id, user_id),
     UNIQUE(organization_id, email)
 );
@@ -37,7 +41,20 @@ CREATE TABLE IF NOT EXISTS organization_invitations (
     token VARCHAR(255) UNIQUE NOT NULL,
     project_access_type VARCHAR(50), -- all, specific
     specific_projects TEXT, -- JSON array of project IDs
-    message TEXT
+    message TEXT,
+    project_role VARCHAR(50) -- viewer, editor, admin on each of specific_projects
+);
+
+-- Ownership transfers: an owner offers ownership to a member, who accepts it
+CREATE TABLE IF NOT EXISTS organization_ownership_transfers (
+    id VARCHAR(255) PRIMARY KEY,
+    organization_id VARCHAR(255) REFERENCES organizations(id) ON DELETE CASCADE,
+    from_user_id VARCHAR(255) REFERENCES users(user_id) ON DELETE CASCADE,
+    to_user_id VARCHAR(255) REFERENCES users(user_id) ON DELETE CASCADE,
+    status VARCHAR(50) NOT NULL DEFAULT 'pending', -- pending, accepted, declined, cancelled, expired
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+    expires_at TIMESTAMP WITH TIME ZONE NOT NULL,
+    responded_at TIMESTAMP WITH TIME ZONE
 );
 
 -- Projects table
@@ -51,7 +68,316 @@ CREATE TABLE IF NOT EXISTS projects (
     last_activity TIMESTAMP WITH TIME ZONE,
     database_connected BOOLEAN DEFAULT FALSE,
     database_type VARCHAR(50), -- postgresql, mysql, etc.
-    is_public BOOLEAN DEFAULT FALSE
+    is_public BOOLEAN DEFAULT FALSE,
+    read_only BOOLEAN NOT NULL DEFAULT FALSE, -- set when the organization's plan no longer covers the project
+    connection_user_id VARCHAR(255) REFERENCES users(user_id) ON DELETE SET NULL -- member whose database connection the project queries
+);
+
+-- Per-project roles for organization members
+CREATE TABLE IF NOT EXISTS project_members (
+    id VARCHAR(255) PRIMARY KEY,
+    project_id VARCHAR(255) NOT NULL REFERENCES projects(id) ON DELETE CASCADE,
+    user_id VARCHAR(255) NOT NULL REFERENCES users(user_id) ON DELETE CASCADE,
+    role VARCHAR(50) NOT NULL DEFAULT 'viewer', -- viewer, editor, admin
+    added_by VARCHAR(255) REFERENCES users(user_id) ON DELETE SET NULL,
+    scim_managed BOOLEAN NOT NULL DEFAULT FALSE, -- granted through a SCIM group; removed with the grant
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+    updated_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+    UNIQUE(project_id, user_id)
+);
+
+-- Schemas, tables or columns hidden (deny) or masked from some project roles
+CREATE TABLE IF NOT EXISTS project_access_policies (
+    id VARCHAR(255) PRIMARY KEY,
+    project_id VARCHAR(255) NOT NULL REFERENCES projects(id) ON DELETE CASCADE,
+    schema_name VARCHAR(255) NOT NULL,
+    table_name VARCHAR(255) NOT NULL DEFAULT '', -- empty for the whole schema
+    column_name VARCHAR(255) NOT NULL DEFAULT '', -- empty for the whole table
+    action VARCHAR(20) NOT NULL, -- deny, mask (columns only)
+    roles TEXT[] NOT NULL, -- project roles the policy applies to
+    created_by VARCHAR(255) REFERENCES users(user_id) ON DELETE SET NULL,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+    updated_at TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+);
+
+-- Rules masking values in project query results for some project roles
+CREATE TABLE IF NOT EXISTS project_masking_rules (
+    id VARCHAR(255) PRIMARY KEY,
+    project_id VARCHAR(255) NOT NULL REFERENCES projects(id) ON DELETE CASCADE,
+    schema_name VARCHAR(255) NOT NULL DEFAULT '',
+    table_name VARCHAR(255) NOT NULL DEFAULT '', -- set to match a table column wherever it is selected
+    column_name VARCHAR(255) NOT NULL DEFAULT '', -- result column name, or the table's column
+    pattern VARCHAR(255) NOT NULL DEFAULT '', -- regular expression on result column names
+    strategy VARCHAR(20) NOT NULL, -- redact, partial, hash, null
+    keep_chars INTEGER NOT NULL DEFAULT 4, -- characters left visible by partial
+    roles TEXT[] NOT NULL, -- project roles the rule applies to
+    created_by VARCHAR(255) REFERENCES users(user_id) ON DELETE SET NULL,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+    updated_at TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+);
+
+-- Queries saved on a project by its editors
+CREATE TABLE IF NOT EXISTS saved_queries (
+    id VARCHAR(255) PRIMARY KEY,
+    project_id VARCHAR(255) NOT NULL REFERENCES projects(id) ON DELETE CASCADE,
+    name VARCHAR(200) NOT NULL,
+    sql TEXT NOT NULL,
+    description TEXT,
+    created_by VARCHAR(255) REFERENCES users(user_id) ON DELETE SET NULL,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+    updated_at TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+);
+
+-- Team-maintained descriptions for tables and columns of a project's database
+CREATE TABLE IF NOT EXISTS schema_descriptions (
+    id SERIAL PRIMARY KEY,
+    project_id VARCHAR(255) NOT NULL REFERENCES projects(id) ON DELETE CASCADE,
+    schema_name VARCHAR(255) NOT NULL,
+    table_name VARCHAR(255) NOT NULL,
+    column_name VARCHAR(255) NOT NULL DEFAULT '', -- empty for table-level descriptions
+    description TEXT NOT NULL,
+    updated_by VARCHAR(255),
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+    updated_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+    UNIQUE(project_id, schema_name, table_name, column_name)
+);
+
+-- Plan definitions; limits and features are JSON objects keyed by resource or feature name
+CREATE TABLE IF NOT EXISTS plans (
+    id VARCHAR(50) PRIMARY KEY,
+    name VARCHAR(255) NOT NULL,
+    limits JSONB NOT NULL DEFAULT '{}',
+    features JSONB NOT NULL DEFAULT '{}',
+    is_default BOOLEAN NOT NULL DEFAULT FALSE,
+    price_id VARCHAR(255), -- billing provider price that sells the plan
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+    updated_at TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+);
+
+-- Per-organization adjustments to its plan (custom deals); listed keys replace the plan's
+CREATE TABLE IF NOT EXISTS organization_plan_overrides (
+    organization_id VARCHAR(255) PRIMARY KEY REFERENCES organizations(id) ON DELETE CASCADE,
+    limits JSONB NOT NULL DEFAULT '{}',
+    features JSONB NOT NULL DEFAULT '{}',
+    note TEXT,
+    expires_at TIMESTAMP WITH TIME ZONE, -- NULL for no expiry
+    updated_by VARCHAR(255),
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+    updated_at TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+);
+
+-- Billable events; usage per billing cycle is summed from here
+CREATE TABLE IF NOT EXISTS usage_ledger (
+    id BIGSERIAL PRIMARY KEY,
+    organization_id VARCHAR(255), -- NULL for users outside any organization; no FK so invoices survive deletion
+    user_id VARCHAR(255) NOT NULL,
+    meter VARCHAR(50) NOT NULL, -- ai_queries, query_executions, rows_exported, connection_seconds
+    quantity BIGINT NOT NULL,
+    metadata JSONB NOT NULL DEFAULT '{}',
+    occurred_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW()
+);
+
+-- Append-only record of security-relevant actions
+CREATE TABLE IF NOT EXISTS audit_log (
+    id BIGSERIAL PRIMARY KEY,
+    organization_id VARCHAR(255), -- NULL for actions outside any organization; no FK so entries survive deletion
+    actor_id VARCHAR(255), -- NULL for system actions
+    actor_email VARCHAR(255),
+    actor_role VARCHAR(50), -- platform role of the actor, or 'system'
+    action VARCHAR(100) NOT NULL, -- e.g. connection.created, query.executed, member.role_changed
+    target_type VARCHAR(50) NOT NULL DEFAULT '',
+    target_id VARCHAR(255) NOT NULL DEFAULT '',
+    metadata JSONB NOT NULL DEFAULT '{}',
+    ip_address VARCHAR(255),
+    user_agent VARCHAR(512),
+    request_id VARCHAR(128),
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    prev_hash VARCHAR(64), -- hash of the previous entry in the organization's chain; NULL at its start
+    hash VARCHAR(64) -- SHA-256 of this entry and prev_hash
+);
+
+-- Signed statements of each audit chain's last entry, for offline verification
+CREATE TABLE IF NOT EXISTS audit_checkpoints (
+    id BIGSERIAL PRIMARY KEY,
+    organization_id VARCHAR(255), -- NULL for the chain of actions outside any organization
+    last_entry_id BIGINT NOT NULL,
+    last_hash VARCHAR(64) NOT NULL,
+    key_id VARCHAR(64) NOT NULL, -- identifies the Ed25519 signing key
+    signature TEXT NOT NULL,
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW()
+);
+
+-- Billing provider customer and subscription per organization
+CREATE TABLE IF NOT EXISTS organization_billing (
+    organization_id VARCHAR(255) PRIMARY KEY REFERENCES organizations(id) ON DELETE CASCADE,
+    provider VARCHAR(50) NOT NULL,
+    customer_id VARCHAR(255) NOT NULL,
+    subscription_id VARCHAR(255),
+    subscription_status VARCHAR(50),
+    price_id VARCHAR(255),
+    current_period_end TIMESTAMP WITH TIME ZONE,
+    cancel_at_period_end BOOLEAN NOT NULL DEFAULT FALSE,
+    last_event_at TIMESTAMP WITH TIME ZONE, -- creation time of the newest applied event; older ones are skipped
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+    updated_at TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+);
+
+-- Webhook events already processed, so provider retries are not applied twice
+CREATE TABLE IF NOT EXISTS billing_events (
+    id VARCHAR(255) PRIMARY KEY,
+    event_type VARCHAR(100) NOT NULL,
+    organization_id VARCHAR(255),
+    received_at TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+);
+
+-- API keys for programmatic access; only a SHA-256 of each key is kept
+CREATE TABLE IF NOT EXISTS api_keys (
+    id VARCHAR(255) PRIMARY KEY,
+    user_id VARCHAR(255) NOT NULL REFERENCES users(user_id) ON DELETE CASCADE, -- the key acts as this user
+    organization_id VARCHAR(255) REFERENCES organizations(id) ON DELETE CASCADE, -- the only organization the key reaches, if set
+    name VARCHAR(100) NOT NULL,
+    prefix VARCHAR(16) NOT NULL, -- first characters of the key, shown to tell keys apart
+    key_hash VARCHAR(64) UNIQUE NOT NULL,
+    scopes TEXT[] NOT NULL, -- e.g. sql:read, schema:read, projects:write
+    created_by VARCHAR(255),
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+    expires_at TIMESTAMP WITH TIME ZONE,
+    last_used_at TIMESTAMP WITH TIME ZONE, -- updated at most once a minute
+    last_used_ip VARCHAR(255),
+    revoked_at TIMESTAMP WITH TIME ZONE,
+    revoked_by VARCHAR(255)
+);
+
+-- Non-human members of an organization. Each has a users row with a "sa_"
+-- ID and a synthetic email, acts only through API keys and cannot sign in.
+CREATE TABLE IF NOT EXISTS service_accounts (
+    user_id VARCHAR(255) PRIMARY KEY REFERENCES users(user_id) ON DELETE CASCADE,
+    organization_id VARCHAR(255) NOT NULL REFERENCES organizations(id) ON DELETE CASCADE, -- the organization owning the account
+    name VARCHAR(100) NOT NULL,
+    description TEXT,
+    created_by VARCHAR(255) REFERENCES users(user_id) ON DELETE SET NULL,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+    updated_at TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+);
+
+-- Outgoing email, written in the same request that triggers it and sent by a
+-- background worker so sends survive restarts
+CREATE TABLE IF NOT EXISTS email_outbox (
+    id VARCHAR(255) PRIMARY KEY,
+    organization_id VARCHAR(255) REFERENCES organizations(id) ON DELETE CASCADE,
+    invitation_id VARCHAR(255) REFERENCES organization_invitations(id) ON DELETE CASCADE, -- the invitation the email delivers, if any
+    kind VARCHAR(50) NOT NULL, -- e.g. invitation
+    recipient VARCHAR(255) NOT NULL,
+    subject TEXT NOT NULL,
+    text_body TEXT NOT NULL,
+    html_body TEXT NOT NULL,
+    status VARCHAR(50) NOT NULL DEFAULT 'pending', -- pending, sent, failed (retries exhausted) or bounced (rejected by the server)
+    attempts INTEGER NOT NULL DEFAULT 0,
+    next_attempt_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(), -- also pushed ahead while a worker holds the email
+    last_error TEXT,
+    sent_at TIMESTAMP WITH TIME ZONE,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+    updated_at TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+);
+
+-- Email domains an organization has proven it owns. Users with a verified
+-- email at a verified domain can join organizations that enable auto_join.
+CREATE TABLE IF NOT EXISTS organization_domains (
+    id VARCHAR(255) PRIMARY KEY,
+    organization_id VARCHAR(255) NOT NULL REFERENCES organizations(id) ON DELETE CASCADE,
+    domain VARCHAR(255) NOT NULL, -- lowercase, e.g. example.com
+    verification_token VARCHAR(255) NOT NULL, -- expected in a DNS TXT record at _org-verification.<domain>
+    verified_at TIMESTAMP WITH TIME ZONE, -- NULL until the TXT record is found
+    auto_join BOOLEAN NOT NULL DEFAULT FALSE,
+    default_role VARCHAR(50) NOT NULL DEFAULT 'member', -- role given to users who auto-join: admin or member
+    created_by VARCHAR(255) REFERENCES users(user_id) ON DELETE SET NULL,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+    updated_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+    UNIQUE(organization_id, domain)
+);
+
+-- An organization's single sign-on connection to its identity provider,
+-- over OIDC or SAML. Members signing in through it are created or updated
+-- from the IdP's assertion.
+CREATE TABLE IF NOT EXISTS sso_connections (
+    id VARCHAR(255) PRIMARY KEY,
+    organization_id VARCHAR(255) NOT NULL UNIQUE REFERENCES organizations(id) ON DELETE CASCADE,
+    protocol VARCHAR(10) NOT NULL CHECK (protocol IN ('oidc', 'saml')),
+    enabled BOOLEAN NOT NULL DEFAULT FALSE,
+    enforced BOOLEAN NOT NULL DEFAULT FALSE, -- members other than owners must sign in through the connection
+    entitled BOOLEAN NOT NULL DEFAULT TRUE, -- whether the organization's plan includes SSO; kept up to date on plan changes
+    jit_provisioning BOOLEAN NOT NULL DEFAULT TRUE, -- create memberships for users signing in for the first time
+    default_role VARCHAR(50), -- role for new members no mapping matches; NULL refuses them
+    groups_attribute VARCHAR(255) NOT NULL DEFAULT 'groups', -- OIDC claim or SAML attribute holding the user's groups
+    role_mappings JSONB NOT NULL DEFAULT '[]', -- [{"group": "...", "role": "admin|member"}]
+    oidc_issuer TEXT,
+    oidc_client_id TEXT,
+    oidc_client_secret TEXT, -- encrypted with ENCRYPTION_KEY
+    oidc_scopes TEXT, -- space separated, in addition to openid
+    saml_idp_entity_id TEXT,
+    saml_idp_sso_url TEXT, -- HTTP-Redirect binding endpoint
+    saml_idp_certificate TEXT, -- PEM certificate the IdP signs responses with
+    created_by VARCHAR(255) REFERENCES users(user_id) ON DELETE SET NULL,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+    updated_at TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+);
+
+-- Sign-ins started but not yet completed at the IdP. Each is used once.
+CREATE TABLE IF NOT EXISTS sso_login_states (
+    id VARCHAR(255) PRIMARY KEY, -- the OIDC state or SAML RelayState
+    connection_id VARCHAR(255) NOT NULL REFERENCES sso_connections(id) ON DELETE CASCADE,
+    nonce VARCHAR(255) NOT NULL, -- OIDC nonce
+    code_verifier VARCHAR(255) NOT NULL, -- OIDC PKCE verifier
+    request_id VARCHAR(255) NOT NULL, -- SAML AuthnRequest ID
+    redirect_to TEXT NOT NULL DEFAULT '/', -- app path to return to
+    expires_at TIMESTAMP WITH TIME ZONE NOT NULL,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+);
+
+-- Better Auth sessions created by SSO sign-ins, which satisfy the
+-- organization's enforced SSO.
+CREATE TABLE IF NOT EXISTS sso_sessions (
+    session_id VARCHAR(255) PRIMARY KEY, -- Better Auth "session".id
+    organization_id VARCHAR(255) NOT NULL REFERENCES organizations(id) ON DELETE CASCADE,
+    user_id VARCHAR(255) NOT NULL,
+    expires_at TIMESTAMP WITH TIME ZONE NOT NULL,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+);
+
+-- Tokens an organization's IdP provisions members with over SCIM. Only
+-- their hash is stored.
+CREATE TABLE IF NOT EXISTS scim_tokens (
+    id VARCHAR(255) PRIMARY KEY,
+    organization_id VARCHAR(255) NOT NULL REFERENCES organizations(id) ON DELETE CASCADE,
+    name VARCHAR(100) NOT NULL,
+    prefix VARCHAR(16) NOT NULL,
+    token_hash VARCHAR(64) UNIQUE NOT NULL, -- SHA-256 of the token
+    created_by VARCHAR(255) REFERENCES users(user_id) ON DELETE SET NULL,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+    last_used_at TIMESTAMP WITH TIME ZONE,
+    revoked_at TIMESTAMP WITH TIME ZONE,
+    revoked_by VARCHAR(255)
+);
+
+-- Groups pushed by the IdP over SCIM. Admins map a group to an organization
+-- role, a project role, or both.
+CREATE TABLE IF NOT EXISTS scim_groups (
+    id VARCHAR(255) PRIMARY KEY,
+    organization_id VARCHAR(255) NOT NULL REFERENCES organizations(id) ON DELETE CASCADE,
+    display_name VARCHAR(255) NOT NULL,
+    external_id VARCHAR(255),
+    role VARCHAR(50), -- admin or member for the group's members; NULL grants none
+    project_id VARCHAR(255) REFERENCES projects(id) ON DELETE SET NULL,
+    project_role VARCHAR(50), -- viewer, editor or admin on project_id
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+    updated_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+    UNIQUE(organization_id, display_name)
+);
+
+CREATE TABLE IF NOT EXISTS scim_group_members (
+    group_id VARCHAR(255) NOT NULL REFERENCES scim_groups(id) ON DELETE CASCADE,
+    user_id VARCHAR(255) NOT NULL REFERENCES users(user_id) ON DELETE CASCADE,
+    PRIMARY KEY (group_id, user_id)
 );
 
 -- Create indexes for better performance
@@ -62,8 +388,32 @@ CREATE INDEX IF NOT EXISTS idx_organization_invitations_org_id ON organization_i
 CREATE INDEX IF NOT EXISTS idx_organization_invitations_email ON organization_invitations(email);
 CREATE INDEX IF NOT EXISTS idx_organization_invitations_token ON organization_invitations(token);
 CREATE INDEX IF NOT EXISTS idx_organization_invitations_status ON organization_invitations(status);
+-- At most one pending transfer per organization
+CREATE UNIQUE INDEX IF NOT EXISTS idx_ownership_transfers_pending ON organization_ownership_transfers(organization_id) WHERE status = 'pending';
 CREATE INDEX IF NOT EXISTS idx_projects_org_id ON projects(organization_id);
 CREATE INDEX IF NOT EXISTS idx_projects_created_at ON projects(created_at);
+CREATE INDEX IF NOT EXISTS idx_project_members_user_id ON project_members(user_id);
+CREATE INDEX IF NOT EXISTS idx_saved_queries_project_id ON saved_queries(project_id);
+CREATE INDEX IF NOT EXISTS idx_project_access_policies_project_id ON project_access_policies(project_id);
+CREATE INDEX IF NOT EXISTS idx_project_masking_rules_project_id ON project_masking_rules(project_id);
+CREATE UNIQUE INDEX IF NOT EXISTS idx_plans_price_id ON plans(price_id) WHERE price_id IS NOT NULL;
+CREATE UNIQUE INDEX IF NOT EXISTS idx_organization_billing_customer ON organization_billing(provider, customer_id);
+CREATE INDEX IF NOT EXISTS idx_usage_ledger_org_time ON usage_ledger(organization_id, occurred_at);
+CREATE INDEX IF NOT EXISTS idx_usage_ledger_user_time ON usage_ledger(user_id, occurred_at) WHERE organization_id IS NULL;
+CREATE INDEX IF NOT EXISTS idx_audit_log_org_id ON audit_log(organization_id, id);
+CREATE INDEX IF NOT EXISTS idx_audit_log_actor_id ON audit_log(actor_id, id);
+CREATE INDEX IF NOT EXISTS idx_audit_log_action ON audit_log(action, id);
+CREATE INDEX IF NOT EXISTS idx_audit_checkpoints_org_id ON audit_checkpoints(organization_id, id);
+CREATE INDEX IF NOT EXISTS idx_api_keys_user_id ON api_keys(user_id);
+CREATE INDEX IF NOT EXISTS idx_api_keys_org_id ON api_keys(organization_id) WHERE organization_id IS NOT NULL;
+CREATE INDEX IF NOT EXISTS idx_service_accounts_org_id ON service_accounts(organization_id);
+CREATE INDEX IF NOT EXISTS idx_email_outbox_due ON email_outbox(next_attempt_at) WHERE status = 'pending';
+CREATE INDEX IF NOT EXISTS idx_email_outbox_invitation_id ON email_outbox(invitation_id, created_at);
+CREATE UNIQUE INDEX IF NOT EXISTS idx_organization_domains_verified ON organization_domains(domain) WHERE verified_at IS NOT NULL;
+CREATE INDEX IF NOT EXISTS idx_sso_login_states_expires_at ON sso_login_states(expires_at);
+CREATE INDEX IF NOT EXISTS idx_sso_sessions_expires_at ON sso_sessions(expires_at);
+CREATE INDEX IF NOT EXISTS idx_scim_tokens_org_id ON scim_tokens(organization_id);
+CREATE INDEX IF NOT EXISTS idx_scim_group_members_user_id ON scim_group_members(user_id);
 
 -- Add triggers for updated_at columns
 CREATE OR REPLACE FUNCTION update_updated_at_column()
@@ -76,3 +426,25 @@ $$ language 'plpgsql';
 
 CREATE TRIGGER update_organizations_updated_at BEFORE UPDATE ON organizations FOR EACH ROW EXECUTE FUNCTION update_updated_at_column();
 CREATE TRIGGER update_projects_updated_at BEFORE UPDATE ON projects FOR EACH ROW EXECUTE FUNCTION update_updated_at_column();
+CREATE TRIGGER update_project_members_updated_at BEFORE UPDATE ON project_members FOR EACH ROW EXECUTE FUNCTION update_updated_at_column();
+CREATE TRIGGER update_project_access_policies_updated_at BEFORE UPDATE ON project_access_policies FOR EACH ROW EXECUTE FUNCTION update_updated_at_column();
+CREATE TRIGGER update_project_masking_rules_updated_at BEFORE UPDATE ON project_masking_rules FOR EACH ROW EXECUTE FUNCTION update_updated_at_column();
+CREATE TRIGGER update_saved_queries_updated_at BEFORE UPDATE ON saved_queries FOR EACH ROW EXECUTE FUNCTION update_updated_at_column();
+CREATE TRIGGER update_service_accounts_updated_at BEFORE UPDATE ON service_accounts FOR EACH ROW EXECUTE FUNCTION update_updated_at_column();
+CREATE TRIGGER update_email_outbox_updated_at BEFORE UPDATE ON email_outbox FOR EACH ROW EXECUTE FUNCTION update_updated_at_column();
+CREATE TRIGGER update_organization_domains_updated_at BEFORE UPDATE ON organization_domains FOR EACH ROW EXECUTE FUNCTION update_updated_at_column();
+CREATE TRIGGER update_sso_connections_updated_at BEFORE UPDATE ON sso_connections FOR EACH ROW EXECUTE FUNCTION update_updated_at_column();
+CREATE TRIGGER update_scim_groups_updated_at BEFORE UPDATE ON scim_groups FOR EACH ROW EXECUTE FUNCTION update_updated_at_column();
+
+-- The audit log and its checkpoints are append-only
+CREATE OR REPLACE FUNCTION prevent_audit_log_changes()
+RETURNS TRIGGER AS $$
+BEGIN
+    RAISE EXCEPTION '% is append-only', TG_TABLE_NAME;
+END;
+$$ language 'plpgsql';
+
+CREATE TRIGGER audit_log_append_only BEFORE UPDATE OR DELETE ON audit_log FOR EACH ROW EXECUTE FUNCTION prevent_audit_log_changes();
+CREATE TRIGGER audit_log_no_truncate BEFORE TRUNCATE ON audit_log FOR EACH STATEMENT EXECUTE FUNCTION prevent_audit_log_changes();
+CREATE TRIGGER audit_checkpoints_append_only BEFORE UPDATE OR DELETE ON audit_checkpoints FOR EACH ROW EXECUTE FUNCTION prevent_audit_log_changes();
+CREATE TRIGGER audit_checkpoints_no_truncate BEFORE TRUNCATE ON audit_checkpoints FOR EACH STATEMENT EXECUTE FUNCTION prevent_audit_log_changes();
@@ -177,10 +177,13 @@ func (db *PostgresDB) InitTables() error {
 			slug VARCHAR(255) UNIQUE NOT NULL,
 			description TEXT,
 			plan VARCHAR(50) DEFAULT 'free',
+			billing_anchor TIMESTAMP WITH TIME ZONE,
 			created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
 			updated_at TIMESTAMP WITH TIME ZONE DEFAULT NOW()
 		)`,
 		
+		`ALTER TABLE organizations ADD COLUMN IF NOT EXISTS billing_anchor TIMESTAMP WITH TIME ZONE`,
+		
 		`CREATE TABLE IF NOT EXISTS organization_members (
 			id VARCHAR(255) PRIMARY KEY,
 			organization_id VARCHAR(255) REFERENCES organizations(id) ON DELETE CASCADE,
@@ -194,6 +197,10 @@ func (db *PostgresDB) InitTables() error {
 			UNIQUE(organization_id, user_id),
 			UNIQUE(organization_id, email)
 		)`,
+
+		`ALTER TABLE organization_members ADD COLUMN IF NOT EXISTS project_access VARCHAR(50) NOT NULL DEFAULT 'all'`,
+		`ALTER TABLE organization_members ADD COLUMN IF NOT EXISTS scim_managed BOOLEAN NOT NULL DEFAULT FALSE`,
+		`ALTER TABLE organization_members ADD COLUMN IF NOT EXISTS scim_external_id VARCHAR(255)`,
 		
 		`CREATE TABLE IF NOT EXISTS organization_invitations (
 			id VARCHAR(255) PRIMARY KEY,
@@ -209,6 +216,19 @@ func (db *PostgresDB) InitTables() error {
 			specific_projects TEXT,
 			message TEXT
 		)`,
+
+		`ALTER TABLE organization_invitations ADD COLUMN IF NOT EXISTS project_role VARCHAR(50)`,
+
+		`CREATE TABLE IF NOT EXISTS organization_ownership_transfers (
+			id VARCHAR(255) PRIMARY KEY,
+			organization_id VARCHAR(255) REFERENCES organizations(id) ON DELETE CASCADE,
+			from_user_id VARCHAR(255) REFERENCES users(user_id) ON DELETE CASCADE,
+			to_user_id VARCHAR(255) REFERENCES users(user_id) ON DELETE CASCADE,
+			status VARCHAR(50) NOT NULL DEFAULT 'pending',
+			created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+			expires_at TIMESTAMP WITH TIME ZONE NOT NULL,
+			responded_at TIMESTAMP WITH TIME ZONE
+		)`,
 		
 		`CREATE TABLE IF NOT EXISTS projects (
 			id VARCHAR(255) PRIMARY KEY,
@@ -220,7 +240,293 @@ func (db *PostgresDB) InitTables() error {
 			last_activity TIMESTAMP WITH TIME ZONE,
 			database_connected BOOLEAN DEFAULT FALSE,
 			database_type VARCHAR(50),
-			is_public BOOLEAN DEFAULT FALSE
+			is_public BOOLEAN DEFAULT FALSE,
+			read_only BOOLEAN NOT NULL DEFAULT FALSE
+		)`,
+		
+		`ALTER TABLE projects ADD COLUMN IF NOT EXISTS read_only BOOLEAN NOT NULL DEFAULT FALSE`,
+		`ALTER TABLE projects ADD COLUMN IF NOT EXISTS connection_user_id VARCHAR(255) REFERENCES users(user_id) ON DELETE SET NULL`,
+
+		`CREATE TABLE IF NOT EXISTS project_members (
+			id VARCHAR(255) PRIMARY KEY,
+			project_id VARCHAR(255) NOT NULL REFERENCES projects(id) ON DELETE CASCADE,
+			user_id VARCHAR(255) NOT NULL REFERENCES users(user_id) ON DELETE CASCADE,
+			role VARCHAR(50) NOT NULL DEFAULT 'viewer',
+			added_by VARCHAR(255) REFERENCES users(user_id) ON DELETE SET NULL,
+			created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+			updated_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+			UNIQUE(project_id, user_id)
+		)`,
+
+		`ALTER TABLE project_members ADD COLUMN IF NOT EXISTS scim_managed BOOLEAN NOT NULL DEFAULT FALSE`,
+
+		`CREATE TABLE IF NOT EXISTS project_access_policies (
+			id VARCHAR(255) PRIMARY KEY,
+			project_id VARCHAR(255) NOT NULL REFERENCES projects(id) ON DELETE CASCADE,
+			schema_name VARCHAR(255) NOT NULL,
+			table_name VARCHAR(255) NOT NULL DEFAULT '',
+			column_name VARCHAR(255) NOT NULL DEFAULT '',
+			action VARCHAR(20) NOT NULL,
+			roles TEXT[] NOT NULL,
+			created_by VARCHAR(255) REFERENCES users(user_id) ON DELETE SET NULL,
+			created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+			updated_at TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+		)`,
+
+		`CREATE TABLE IF NOT EXISTS project_masking_rules (
+			id VARCHAR(255) PRIMARY KEY,
+			project_id VARCHAR(255) NOT NULL REFERENCES projects(id) ON DELETE CASCADE,
+			schema_name VARCHAR(255) NOT NULL DEFAULT '',
+			table_name VARCHAR(255) NOT NULL DEFAULT '',
+			column_name VARCHAR(255) NOT NULL DEFAULT '',
+			pattern VARCHAR(255) NOT NULL DEFAULT '',
+			strategy VARCHAR(20) NOT NULL,
+			keep_chars INTEGER NOT NULL DEFAULT 4,
+			roles TEXT[] NOT NULL,
+			created_by VARCHAR(255) REFERENCES users(user_id) ON DELETE SET NULL,
+			created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+			updated_at TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+		)`,
+
+		`CREATE TABLE IF NOT EXISTS saved_queries (
+			id VARCHAR(255) PRIMARY KEY,
+			project_id VARCHAR(255) NOT NULL REFERENCES projects(id) ON DELETE CASCADE,
+			name VARCHAR(200) NOT NULL,
+			sql TEXT NOT NULL,
+			description TEXT,
+			created_by VARCHAR(255) REFERENCES users(user_id) ON DELETE SET NULL,
+			created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+			updated_at TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+		)`,
+		
+		`CREATE TABLE IF NOT EXISTS schema_descriptions (
+			id SERIAL PRIMARY KEY,
+			project_id VARCHAR(255) NOT NULL REFERENCES projects(id) ON DELETE CASCADE,
+			schema_name VARCHAR(255) NOT NULL,
+			table_name VARCHAR(255) NOT NULL,
+			column_name VARCHAR(255) NOT NULL DEFAULT '',
+			description TEXT NOT NULL,
+			updated_by VARCHAR(255),
+			created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+			updated_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+			UNIQUE(project_id, schema_name, table_name, column_name)
+		)`,
+		
+		`CREATE TABLE IF NOT EXISTS plans (
+			id VARCHAR(50) PRIMARY KEY,
+			name VARCHAR(255) NOT NULL,
+			limits JSONB NOT NULL DEFAULT '{}',
+			features JSONB NOT NULL DEFAULT '{}',
+			is_default BOOLEAN NOT NULL DEFAULT FALSE,
+			price_id VARCHAR(255),
+			created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+			updated_at TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+		)`,
+		
+		`ALTER TABLE plans ADD COLUMN IF NOT EXISTS price_id VARCHAR(255)`,
+		
+		`CREATE TABLE IF NOT EXISTS organization_plan_overrides (
+			organization_id VARCHAR(255) PRIMARY KEY REFERENCES organizations(id) ON DELETE CASCADE,
+			limits JSONB NOT NULL DEFAULT '{}',
+			features JSONB NOT NULL DEFAULT '{}',
+			note TEXT,
+			expires_at TIMESTAMP WITH TIME ZONE,
+			updated_by VARCHAR(255),
+			created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+			updated_at TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+		)`,
+		
+		`CREATE TABLE IF NOT EXISTS usage_ledger (
+			id BIGSERIAL PRIMARY KEY,
+			organization_id VARCHAR(255),
+			user_id VARCHAR(255) NOT NULL,
+			meter VARCHAR(50) NOT NULL,
+			quantity BIGINT NOT NULL,
+			metadata JSONB NOT NULL DEFAULT '{}',
+			occurred_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW()
+		)`,
+		
+		`CREATE TABLE IF NOT EXISTS audit_log (
+			id BIGSERIAL PRIMARY KEY,
+			organization_id VARCHAR(255),
+			actor_id VARCHAR(255),
+			actor_email VARCHAR(255),
+			actor_role VARCHAR(50),
+			action VARCHAR(100) NOT NULL,
+			target_type VARCHAR(50) NOT NULL DEFAULT '',
+			target_id VARCHAR(255) NOT NULL DEFAULT '',
+			metadata JSONB NOT NULL DEFAULT '{}',
+			ip_address VARCHAR(255),
+			user_agent VARCHAR(512),
+			request_id VARCHAR(128),
+			created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW()
+		)`,
+		
+		`ALTER TABLE audit_log ADD COLUMN IF NOT EXISTS prev_hash VARCHAR(64)`,
+		`ALTER TABLE audit_log ADD COLUMN IF NOT EXISTS hash VARCHAR(64)`,
+		
+		`CREATE TABLE IF NOT EXISTS audit_checkpoints (
+			id BIGSERIAL PRIMARY KEY,
+			organization_id VARCHAR(255),
+			last_entry_id BIGINT NOT NULL,
+			last_hash VARCHAR(64) NOT NULL,
+			key_id VARCHAR(64) NOT NULL,
+			signature TEXT NOT NULL,
+			created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW()
+		)`,
+		
+		`CREATE TABLE IF NOT EXISTS organization_billing (
+			organization_id VARCHAR(255) PRIMARY KEY REFERENCES organizations(id) ON DELETE CASCADE,
+			provider VARCHAR(50) NOT NULL,
+			customer_id VARCHAR(255) NOT NULL,
+			subscription_id VARCHAR(255),
+			subscription_status VARCHAR(50),
+			price_id VARCHAR(255),
+			current_period_end TIMESTAMP WITH TIME ZONE,
+			cancel_at_period_end BOOLEAN NOT NULL DEFAULT FALSE,
+			last_event_at TIMESTAMP WITH TIME ZONE,
+			created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+			updated_at TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+		)`,
+		
+		`CREATE TABLE IF NOT EXISTS billing_events (
+			id VARCHAR(255) PRIMARY KEY,
+			event_type VARCHAR(100) NOT NULL,
+			organization_id VARCHAR(255),
+			received_at TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+		)`,
+		
+		`CREATE TABLE IF NOT EXISTS api_keys (
+			id VARCHAR(255) PRIMARY KEY,
+			user_id VARCHAR(255) NOT NULL REFERENCES users(user_id) ON DELETE CASCADE,
+			organization_id VARCHAR(255) REFERENCES organizations(id) ON DELETE CASCADE,
+			name VARCHAR(100) NOT NULL,
+			prefix VARCHAR(16) NOT NULL,
+			key_hash VARCHAR(64) UNIQUE NOT NULL,
+			scopes TEXT[] NOT NULL,
+			created_by VARCHAR(255),
+			created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+			expires_at TIMESTAMP WITH TIME ZONE,
+			last_used_at TIMESTAMP WITH TIME ZONE,
+			last_used_ip VARCHAR(255),
+			revoked_at TIMESTAMP WITH TIME ZONE,
+			revoked_by VARCHAR(255)
+		)`,
+		
+		`CREATE TABLE IF NOT EXISTS service_accounts (
+			user_id VARCHAR(255) PRIMARY KEY REFERENCES users(user_id) ON DELETE CASCADE,
+			organization_id VARCHAR(255) NOT NULL REFERENCES organizations(id) ON DELETE CASCADE,
+			name VARCHAR(100) NOT NULL,
+			description TEXT,
+			created_by VARCHAR(255) REFERENCES users(user_id) ON DELETE SET NULL,
+			created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+			updated_at TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+		)`,
+		
+		`CREATE TABLE IF NOT EXISTS email_outbox (
+			id VARCHAR(255) PRIMARY KEY,
+			organization_id VARCHAR(255) REFERENCES organizations(id) ON DELETE CASCADE,
+			invitation_id VARCHAR(255) REFERENCES organization_invitations(id) ON DELETE CASCADE,
+			kind VARCHAR(50) NOT NULL,
+			recipient VARCHAR(255) NOT NULL,
+			subject TEXT NOT NULL,
+			text_body TEXT NOT NULL,
+			html_body TEXT NOT NULL,
+			status VARCHAR(50) NOT NULL DEFAULT 'pending',
+			attempts INTEGER NOT NULL DEFAULT 0,
+			next_attempt_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+			last_error TEXT,
+			sent_at TIMESTAMP WITH TIME ZONE,
+			created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+			updated_at TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+		)`,
+		
+		`CREATE TABLE IF NOT EXISTS organization_domains (
+			id VARCHAR(255) PRIMARY KEY,
+			organization_id VARCHAR(255) NOT NULL REFERENCES organizations(id) ON DELETE CASCADE,
+			domain VARCHAR(255) NOT NULL,
+			verification_token VARCHAR(255) NOT NULL,
+			verified_at TIMESTAMP WITH TIME ZONE,
+			auto_join BOOLEAN NOT NULL DEFAULT FALSE,
+			default_role VARCHAR(50) NOT NULL DEFAULT 'member',
+			created_by VARCHAR(255) REFERENCES users(user_id) ON DELETE SET NULL,
+			created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+			updated_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+			UNIQUE(organization_id, domain)
+		)`,
+		
+		`CREATE TABLE IF NOT EXISTS sso_connections (
+			id VARCHAR(255) PRIMARY KEY,
+			organization_id VARCHAR(255) NOT NULL UNIQUE REFERENCES organizations(id) ON DELETE CASCADE,
+			protocol VARCHAR(10) NOT NULL CHECK (protocol IN ('oidc', 'saml')),
+			enabled BOOLEAN NOT NULL DEFAULT FALSE,
+			enforced BOOLEAN NOT NULL DEFAULT FALSE,
+			entitled BOOLEAN NOT NULL DEFAULT TRUE,
+			jit_provisioning BOOLEAN NOT NULL DEFAULT TRUE,
+			default_role VARCHAR(50),
+			groups_attribute VARCHAR(255) NOT NULL DEFAULT 'groups',
+			role_mappings JSONB NOT NULL DEFAULT '[]',
+			oidc_issuer TEXT,
+			oidc_client_id TEXT,
+			oidc_client_secret TEXT,
+			oidc_scopes TEXT,
+			saml_idp_entity_id TEXT,
+			saml_idp_sso_url TEXT,
+			saml_idp_certificate TEXT,
+			created_by VARCHAR(255) REFERENCES users(user_id) ON DELETE SET NULL,
+			created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+			updated_at TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+		)`,
+		
+		`CREATE TABLE IF NOT EXISTS sso_login_states (
+			id VARCHAR(255) PRIMARY KEY,
+			connection_id VARCHAR(255) NOT NULL REFERENCES sso_connections(id) ON DELETE CASCADE,
+			nonce VARCHAR(255) NOT NULL,
+			code_verifier VARCHAR(255) NOT NULL,
+			request_id VARCHAR(255) NOT NULL,
+			redirect_to TEXT NOT NULL DEFAULT '/',
+			expires_at TIMESTAMP WITH TIME ZONE NOT NULL,
+			created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+		)`,
+		
+		`CREATE TABLE IF NOT EXISTS sso_sessions (
+			session_id VARCHAR(255) PRIMARY KEY,
+			organization_id VARCHAR(255) NOT NULL REFERENCES organizations(id) ON DELETE CASCADE,
+			user_id VARCHAR(255) NOT NULL,
+			expires_at TIMESTAMP WITH TIME ZONE NOT NULL,
+			created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+		)`,
+		
+		`CREATE TABLE IF NOT EXISTS scim_tokens (
+			id VARCHAR(255) PRIMARY KEY,
+			organization_id VARCHAR(255) NOT NULL REFERENCES organizations(id) ON DELETE CASCADE,
+			name VARCHAR(100) NOT NULL,
+			prefix VARCHAR(16) NOT NULL,
+			token_hash VARCHAR(64) UNIQUE NOT NULL,
+			created_by VARCHAR(255) REFERENCES users(user_id) ON DELETE SET NULL,
+			created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+			last_used_at TIMESTAMP WITH TIME ZONE,
+			revoked_at TIMESTAMP WITH TIME ZONE,
+			revoked_by VARCHAR(255)
+		)`,
+		
+		`CREATE TABLE IF NOT EXISTS scim_groups (
+			id VARCHAR(255) PRIMARY KEY,
+			organization_id VARCHAR(255) NOT NULL REFERENCES organizations(id) ON DELETE CASCADE,
+			display_name VARCHAR(255) NOT NULL,
+			external_id VARCHAR(255),
+			role VARCHAR(50),
+			project_id VARCHAR(255) REFERENCES projects(id) ON DELETE SET NULL,
+			project_role VARCHAR(50),
+			created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+			updated_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+			UNIQUE(organization_id, display_name)
+		)`,
+		
+		`CREATE TABLE IF NOT EXISTS scim_group_members (
+			group_id VARCHAR(255) NOT NULL REFERENCES scim_groups(id) ON DELETE CASCADE,
+			user_id VARCHAR(255) NOT NULL REFERENCES users(user_id) ON DELETE CASCADE,
+			PRIMARY KEY (group_id, user_id)
 		)`,
 		
 		// Indexes for performance
@@ -229,6 +535,7 @@ func (db *PostgresDB) InitTables() error {
 		`CREATE UNIQUE INDEX IF NOT EXISTS idx_user_resources_user_type ON user_resources(user_id, resource_type)`,
 		`CREATE INDEX IF NOT EXISTS idx_metrics_user_id ON metrics(user_id)`,
 		`CREATE INDEX IF NOT EXISTS idx_metrics_type ON metrics(metric_type)`,
+		`CREATE INDEX IF NOT EXISTS idx_metrics_sql_fingerprint ON metrics(user_id, (metadata->>'fingerprint')) WHERE metric_type = 'sql_query'`,
 		`CREATE INDEX IF NOT EXISTS idx_organization_members_org_id ON organization_members(organization_id)`,
 		`CREATE INDEX IF NOT EXISTS idx_organization_members_user_id ON organization_members(user_id)`,
 		`CREATE INDEX IF NOT EXISTS idx_organization_members_status ON organization_members(status)`,
@@ -236,8 +543,31 @@ func (db *PostgresDB) InitTables() error {
 		`CREATE INDEX IF NOT EXISTS idx_organization_invitations_email ON organization_invitations(email)`,
 		`CREATE INDEX IF NOT EXISTS idx_organization_invitations_token ON organization_invitations(token)`,
 		`CREATE INDEX IF NOT EXISTS idx_organization_invitations_status ON organization_invitations(status)`,
+		`CREATE INDEX IF NOT EXISTS idx_project_members_user_id ON project_members(user_id)`,
+		`CREATE INDEX IF NOT EXISTS idx_saved_queries_project_id ON saved_queries(project_id)`,
+		`CREATE INDEX IF NOT EXISTS idx_project_access_policies_project_id ON project_access_policies(project_id)`,
+		`CREATE INDEX IF NOT EXISTS idx_project_masking_rules_project_id ON project_masking_rules(project_id)`,
+		`CREATE UNIQUE INDEX IF NOT EXISTS idx_ownership_transfers_pending ON organization_ownership_transfers(organization_id) WHERE status = 'pending'`,
 		`CREATE INDEX IF NOT EXISTS idx_projects_org_id ON projects(organization_id)`,
 		`CREATE INDEX IF NOT EXISTS idx_projects_created_at ON projects(created_at)`,
+		`CREATE UNIQUE INDEX IF NOT EXISTS idx_plans_price_id ON plans(price_id) WHERE price_id IS NOT NULL`,
+		`CREATE UNIQUE INDEX IF NOT EXISTS idx_organization_billing_customer ON organization_billing(provider, customer_id)`,
+		`CREATE INDEX IF NOT EXISTS idx_usage_ledger_org_time ON usage_ledger(organization_id, occurred_at)`,
+		`CREATE INDEX IF NOT EXISTS idx_usage_ledger_user_time ON usage_ledger(user_id, occurred_at) WHERE organization_id IS NULL`,
+		`CREATE INDEX IF NOT EXISTS idx_audit_log_org_id ON audit_log(organization_id, id)`,
+		`CREATE INDEX IF NOT EXISTS idx_audit_log_actor_id ON audit_log(actor_id, id)`,
+		`CREATE INDEX IF NOT EXISTS idx_audit_log_action ON audit_log(action, id)`,
+		`CREATE INDEX IF NOT EXISTS idx_audit_checkpoints_org_id ON audit_checkpoints(organization_id, id)`,
+		`CREATE INDEX IF NOT EXISTS idx_api_keys_user_id ON api_keys(user_id)`,
+		`CREATE INDEX IF NOT EXISTS idx_api_keys_org_id ON api_keys(organization_id) WHERE organization_id IS NOT NULL`,
+		`CREATE INDEX IF NOT EXISTS idx_service_accounts_org_id ON service_accounts(organization_id)`,
+		`CREATE INDEX IF NOT EXISTS idx_email_outbox_due ON email_outbox(next_attempt_at) WHERE status = 'pending'`,
+		`CREATE INDEX IF NOT EXISTS idx_email_outbox_invitation_id ON email_outbox(invitation_id, created_at)`,
+		`CREATE UNIQUE INDEX IF NOT EXISTS idx_organization_domains_verified ON organization_domains(domain) WHERE verified_at IS NOT NULL`,
+		`CREATE INDEX IF NOT EXISTS idx_sso_login_states_expires_at ON sso_login_states(expires_at)`,
+		`CREATE INDEX IF NOT EXISTS idx_sso_sessions_expires_at ON sso_sessions(expires_at)`,
+		`CREATE INDEX IF NOT EXISTS idx_scim_tokens_org_id ON scim_tokens(organization_id)`,
+		`CREATE INDEX IF NOT EXISTS idx_scim_group_members_user_id ON scim_group_members(user_id)`,
 	}
 	
 	// Add triggers for updated_at columns
@@ -255,6 +585,50 @@ func (db *PostgresDB) InitTables() error {
 		
 		`DROP TRIGGER IF EXISTS update_projects_updated_at ON projects`,
 		`CREATE TRIGGER update_projects_updated_at BEFORE UPDATE ON projects FOR EACH ROW EXECUTE FUNCTION update_updated_at_column()`,
+		
+		`DROP TRIGGER IF EXISTS update_project_members_updated_at ON project_members`,
+		`CREATE TRIGGER update_project_members_updated_at BEFORE UPDATE ON project_members FOR EACH ROW EXECUTE FUNCTION update_updated_at_column()`,
+		
+		`DROP TRIGGER IF EXISTS update_project_access_policies_updated_at ON project_access_policies`,
+		`CREATE TRIGGER update_project_access_policies_updated_at BEFORE UPDATE ON project_access_policies FOR EACH ROW EXECUTE FUNCTION update_updated_at_column()`,
+
+		`DROP TRIGGER IF EXISTS update_project_masking_rules_updated_at ON project_masking_rules`,
+		`CREATE TRIGGER update_project_masking_rules_updated_at BEFORE UPDATE ON project_masking_rules FOR EACH ROW EXECUTE FUNCTION update_updated_at_column()`,
+		
+		`DROP TRIGGER IF EXISTS update_saved_queries_updated_at ON saved_queries`,
+		`CREATE TRIGGER update_saved_queries_updated_at BEFORE UPDATE ON saved_queries FOR EACH ROW EXECUTE FUNCTION update_updated_at_column()`,
+		
+		`DROP TRIGGER IF EXISTS update_service_accounts_updated_at ON service_accounts`,
+		`CREATE TRIGGER update_service_accounts_updated_at BEFORE UPDATE ON service_accounts FOR EACH ROW EXECUTE FUNCTION update_updated_at_column()`,
+		
+		`DROP TRIGGER IF EXISTS update_email_outbox_updated_at ON email_outbox`,
+		`CREATE TRIGGER update_email_outbox_updated_at BEFORE UPDATE ON email_outbox FOR EACH ROW EXECUTE FUNCTION update_updated_at_column()`,
+		
+		`DROP TRIGGER IF EXISTS update_organization_domains_updated_at ON organization_domains`,
+		`CREATE TRIGGER update_organization_domains_updated_at BEFORE UPDATE ON organization_domains FOR EACH ROW EXECUTE FUNCTION update_updated_at_column()`,
+		
+		`DROP TRIGGER IF EXISTS update_sso_connections_updated_at ON sso_connections`,
+		`CREATE TRIGGER update_sso_connections_updated_at BEFORE UPDATE ON sso_connections FOR EACH ROW EXECUTE FUNCTION update_updated_at_column()`,
+		
+		`DROP TRIGGER IF EXISTS update_scim_groups_updated_at ON scim_groups`,
+		`CREATE TRIGGER update_scim_groups_updated_at BEFORE UPDATE ON scim_groups FOR EACH ROW EXECUTE FUNCTION update_updated_at_column()`,
+
+		// The audit log and its checkpoints are append-only
+		`CREATE OR REPLACE FUNCTION prevent_audit_log_changes()
+		RETURNS TRIGGER AS $$
+		BEGIN
+			RAISE EXCEPTION '% is append-only', TG_TABLE_NAME;
+		END;
+		$$ language 'plpgsql'`,
+
+		`DROP TRIGGER IF EXISTS audit_log_append_only ON audit_log`,
+		`CREATE TRIGGER audit_log_append_only BEFORE UPDATE OR DELETE ON audit_log FOR EACH ROW EXECUTE FUNCTION prevent_audit_log_changes()`,
+		`DROP TRIGGER IF EXISTS audit_log_no_truncate ON audit_log`,
+		`CREATE TRIGGER audit_log_no_truncate BEFORE TRUNCATE ON audit_log FOR EACH STATEMENT EXECUTE FUNCTION prevent_audit_log_changes()`,
+		`DROP TRIGGER IF EXISTS audit_checkpoints_append_only ON audit_checkpoints`,
+		`CREATE TRIGGER audit_checkpoints_append_only BEFORE UPDATE OR DELETE ON audit_checkpoints FOR EACH ROW EXECUTE FUNCTION prevent_audit_log_changes()`,
+		`DROP TRIGGER IF EXISTS audit_checkpoints_no_truncate ON audit_checkpoints`,
+		`CREATE TRIGGER audit_checkpoints_no_truncate BEFORE TRUNCATE ON audit_checkpoints FOR EACH STATEMENT EXECUTE FUNCTION prevent_audit_log_changes()`,
 	}
 	
 	// Execute main queries
@@ -0,0 +1,410 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"go-backend/ai"
+	"go-backend/database"
+	"go-backend/middleware"
+	"go-backend/quota"
+	"go-backend/sqltools"
+
+	"github.com/gorilla/mux"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	maxQuestionLength = 2000
+
+	// aiRequestTimeout outlasts the server's default write timeout; the
+	// handler extends its own write deadline to match.
+	aiRequestTimeout = 60 * time.Second
+)
+
+type AIAssistantHandler struct {
+	db         *database.PostgresDB
+	playground *SQLPlaygroundHandler
+	provider   ai.Provider
+	quotas     *quota.Service
+}
+
+type GenerateSQLRequest struct {
+	Question       string `json:"question"`
+	OrganizationID string `json:"organization_id,omitempty"`
+}
+
+type GenerateSQLResponse struct {
+	SQL          string                `json:"sql"`
+	FormattedSQL string                `json:"formatted_sql"`
+	Explanation  string                `json:"explanation,omitempty"`
+	Diagnostics  []sqltools.Diagnostic `json:"diagnostics"`
+	Provider     string                `json:"provider"`
+	Model        string                `json:"model"`
+	Usage        quota.Usage           `json:"usage"`
+}
+
+type FixSQLRequest struct {
+	SQL            string `json:"sql"`
+	OrganizationID string `json:"organization_id,omitempty"`
+}
+
+type FixSQLResponse struct {
+	Failed       bool                  `json:"failed"`
+	Error        *ai.QueryError        `json:"error,omitempty"`
+	Explanation  string                `json:"explanation,omitempty"`
+	FixedSQL     string                `json:"fixed_sql,omitempty"`
+	FormattedSQL string                `json:"formatted_sql,omitempty"`
+	Diagnostics  []sqltools.Diagnostic `json:"diagnostics,omitempty"`
+	Warnings     []string              `json:"warnings,omitempty"`
+	Provider     string                `json:"provider,omitempty"`
+	Model        string                `json:"model,omitempty"`
+	Usage        *quota.Usage          `json:"usage,omitempty"`
+}
+
+// NewAIAssistantHandler wires the assistant to the playground, whose
+// connection handling, schema cache and safety checks it reuses. provider
+// may be nil, in which case the endpoints answer 503.
+func NewAIAssistantHandler(db *database.PostgresDB, playground *SQLPlaygroundHandler, provider ai.Provider, quotas *quota.Service) *AIAssistantHandler {
+	return &AIAssistantHandler{
+		db:         db,
+		playground: playground,
+		provider:   provider,
+		quotas:     quotas,
+	}
+}
+
+// GenerateSQL turns a natural-language question into a read-only query
+// against the user's database. The query is returned, not executed.
+func (h *AIAssistantHandler) GenerateSQL(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	userID := vars["user_id"]
+
+	if h.provider == nil {
+		middleware.WriteErrorResponse(w, http.StatusServiceUnavailable, ai.ErrNotConfigured, "The AI assistant is not enabled on this server")
+		return
+	}
+
+	var req GenerateSQLRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		middleware.WriteErrorResponse(w, http.StatusBadRequest, err, "Invalid request body")
+		return
+	}
+
+	req.Question = strings.TrimSpace(req.Question)
+	if req.Question == "" {
+		middleware.WriteErrorResponse(w, http.StatusBadRequest, fmt.Errorf("missing question"), "A question is required")
+		return
+	}
+	if utf8.RuneCountInString(req.Question) > maxQuestionLength {
+		middleware.WriteErrorResponse(w, http.StatusBadRequest, fmt.Errorf("question too long"), fmt.Sprintf("Questions are limited to %d characters", maxQuestionLength))
+		return
+	}
+
+	if err := http.NewResponseController(w).SetWriteDeadline(time.Now().Add(aiRequestTimeout + 5*time.Second)); err != nil {
+		log.Warn().Err(err).Msg("Failed to extend write deadline for AI request")
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), aiRequestTimeout)
+	defer cancel()
+
+	reservation, ok := h.reserveAIQuery(ctx, w, userID, req.OrganizationID, "generate")
+	if !ok {
+		return
+	}
+	settled := false
+	defer func() {
+		if !settled {
+			h.releaseAIQuery(userID, reservation)
+		}
+	}()
+
+	catalog, err := h.playground.loadCatalog(ctx, userID, false)
+	if err != nil {
+		middleware.WriteErrorResponse(w, http.StatusBadRequest, err, "Failed to load your database schema")
+		return
+	}
+
+	completion, err := h.provider.Complete(ctx, ai.BuildSQLPrompt(req.Question, catalog))
+	if err != nil {
+		log.Error().Err(err).Str("user_id", userID).Str("provider", h.provider.Name()).Msg("AI completion failed")
+		middleware.WriteErrorResponse(w, http.StatusBadGateway, err, "The AI provider could not answer")
+		return
+	}
+
+	sql := ai.ExtractSQL(completion.Content)
+
+	// The provider has been paid for at this point, whatever we do with the answer.
+	h.recordAIUsage(userID, reservation, "generate", req.Question, sql, completion)
+	settled = true
+
+	if sql == "" {
+		middleware.WriteErrorResponse(w, http.StatusUnprocessableEntity, fmt.Errorf("no SQL in response"), "The assistant did not produce a query; try rephrasing the question")
+		return
+	}
+	// Normalizing first stops "DELETE\nFROM" slipping past the keyword match.
+	if h.playground.isDangerousQuery(sql) || h.playground.isDangerousQuery(sqltools.Normalize(sql)) {
+		log.Warn().Str("user_id", userID).Str("sql", sql).Msg("AI generated a query rejected by safety checks")
+		middleware.WriteErrorResponse(w, http.StatusUnprocessableEntity, fmt.Errorf("dangerous query generated"), "The generated query was rejected by the playground safety checks")
+		return
+	}
+
+	formatted, err := sqltools.Format(sql, sqltools.FormatOptions{})
+	if err != nil {
+		formatted = sql
+	}
+
+	middleware.WriteJSONResponse(w, http.StatusOK, GenerateSQLResponse{
+		SQL:          sql,
+		FormattedSQL: formatted,
+		Explanation:  ai.ExtractExplanation(completion.Content),
+		Diagnostics:  sqltools.Lint(sql, catalog),
+		Provider:     h.provider.Name(),
+		Model:        completion.Model,
+		Usage:        *reservation.Usage,
+	})
+}
+
+// FixQuery explains why a playground query fails and proposes a corrected
+// version. The failure is reproduced server-side inside a read-only
+// transaction so the model sees PostgreSQL's own error details; queries that
+// turn out to succeed are answered without calling the model.
+func (h *AIAssistantHandler) FixQuery(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	userID := vars["user_id"]
+
+	if h.provider == nil {
+		middleware.WriteErrorResponse(w, http.StatusServiceUnavailable, ai.ErrNotConfigured, "The AI assistant is not enabled on this server")
+		return
+	}
+
+	var req FixSQLRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		middleware.WriteErrorResponse(w, http.StatusBadRequest, err, "Invalid request body")
+		return
+	}
+
+	req.SQL = strings.TrimSpace(req.SQL)
+	if req.SQL == "" {
+		middleware.WriteErrorResponse(w, http.StatusBadRequest, fmt.Errorf("missing SQL query"), "SQL query is required")
+		return
+	}
+	if h.playground.isDangerousQuery(req.SQL) {
+		middleware.WriteErrorResponse(w, http.StatusBadRequest, fmt.Errorf("dangerous query detected"), "DROP, DELETE, TRUNCATE, and other destructive operations are restricted")
+		return
+	}
+
+	if err := http.NewResponseController(w).SetWriteDeadline(time.Now().Add(aiRequestTimeout + 5*time.Second)); err != nil {
+		log.Warn().Err(err).Msg("Failed to extend write deadline for AI request")
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), aiRequestTimeout)
+	defer cancel()
+
+	reservation, ok := h.reserveAIQuery(ctx, w, userID, req.OrganizationID, "fix")
+	if !ok {
+		return
+	}
+	settled := false
+	defer func() {
+		if !settled {
+			h.releaseAIQuery(userID, reservation)
+		}
+	}()
+
+	userPool, err := h.playground.dbConfigHandler.GetUserDatabaseConnection(userID)
+	if err != nil {
+		middleware.WriteErrorResponse(w, http.StatusBadRequest, err, "Failed to connect to your database")
+		return
+	}
+
+	qerr, err := reproduceQueryError(ctx, userPool, req.SQL)
+	if err != nil {
+		middleware.WriteErrorResponse(w, http.StatusBadGateway, err, "Failed to run the query against your database")
+		return
+	}
+	if qerr == nil {
+		middleware.WriteJSONResponse(w, http.StatusOK, FixSQLResponse{Failed: false})
+		return
+	}
+
+	catalog, err := h.playground.loadCatalog(ctx, userID, false)
+	if err != nil {
+		middleware.WriteErrorResponse(w, http.StatusBadRequest, err, "Failed to load your database schema")
+		return
+	}
+
+	completion, err := h.provider.Complete(ctx, ai.BuildFixPrompt(req.SQL, *qerr, catalog))
+	if err != nil {
+		log.Error().Err(err).Str("user_id", userID).Str("provider", h.provider.Name()).Msg("AI completion failed")
+		middleware.WriteErrorResponse(w, http.StatusBadGateway, err, "The AI provider could not answer")
+		return
+	}
+
+	h.recordAIUsage(userID, reservation, "fix", qerr.Message, req.SQL, completion)
+	settled = true
+
+	response := FixSQLResponse{
+		Failed:      true,
+		Error:       qerr,
+		Explanation: ai.ExtractExplanation(completion.Content),
+		Provider:    h.provider.Name(),
+		Model:       completion.Model,
+		Usage:       reservation.Usage,
+	}
+
+	fixed := ""
+	if strings.Contains(completion.Content, "```") {
+		fixed = ai.ExtractSQL(completion.Content)
+	}
+	switch {
+	case fixed == "":
+		response.Warnings = append(response.Warnings, "The assistant did not propose a corrected query")
+	case h.playground.isDangerousQuery(fixed) || h.playground.isDangerousQuery(sqltools.Normalize(fixed)):
+		response.Warnings = append(response.Warnings, "The proposed query was withheld because it failed the playground safety checks")
+	default:
+		response.FixedSQL = fixed
+		response.FormattedSQL, err = sqltools.Format(fixed, sqltools.FormatOptions{})
+		if err != nil {
+			response.FormattedSQL = fixed
+		}
+		response.Diagnostics = sqltools.Lint(fixed, catalog)
+	}
+
+	middleware.WriteJSONResponse(w, http.StatusOK, response)
+}
+
+// reproduceQueryError runs sql in a read-only transaction that is always
+// rolled back and returns the PostgreSQL error it raises, or nil if it
+// succeeds. Errors that did not come from the server are returned as err.
+func reproduceQueryError(ctx context.Context, pool *pgxpool.Pool, sql string) (*ai.QueryError, error) {
+	tx, err := pool.BeginTx(ctx, pgx.TxOptions{AccessMode: pgx.ReadOnly})
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback(context.Background())
+
+	if _, err := tx.Exec(ctx, "SET LOCAL statement_timeout = '5s'"); err != nil {
+		return nil, err
+	}
+
+	rows, err := tx.Query(ctx, sql)
+	if err == nil {
+		// Errors such as division by zero only surface while reading rows.
+		for rows.Next() {
+		}
+		rows.Close()
+		err = rows.Err()
+	}
+	if err == nil {
+		return nil, nil
+	}
+
+	var pgErr *pgconn.PgError
+	if !errors.As(err, &pgErr) {
+		return nil, err
+	}
+	return &ai.QueryError{
+		Severity: pgErr.Severity,
+		Code:     pgErr.Code,
+		Message:  pgErr.Message,
+		Detail:   pgErr.Detail,
+		Hint:     pgErr.Hint,
+		Position: int(pgErr.Position),
+	}, nil
+}
+
+// reserveAIQuery resolves which organization the request is billed to and
+// reserves one AI query of its monthly quota. It refuses the request when the
+// plan lacks the AI assistant or once the quota is exhausted. The reservation
+// is settled by recordAIUsage, or given back with releaseAIQuery when the
+// provider is not called.
+func (h *AIAssistantHandler) reserveAIQuery(ctx context.Context, w http.ResponseWriter, userID, orgID, action string) (*quota.Reservation, bool) {
+	subject, err := h.quotas.Resolve(ctx, userID, orgID)
+	if err != nil {
+		middleware.WriteErrorResponse(w, http.StatusForbidden, err, "Organization not found or access denied")
+		return nil, false
+	}
+
+	if err := h.quotas.RequireFeature(ctx, subject, quota.FeatureAIAssistant); err != nil {
+		if !quota.WriteError(w, err) {
+			middleware.WriteErrorResponse(w, http.StatusInternalServerError, err, "Failed to check plan features")
+		}
+		return nil, false
+	}
+
+	reservation, err := h.quotas.Reserve(ctx, subject, quota.AIQueries, quota.MeterAIQueries, map[string]interface{}{
+		"action": action,
+	})
+	if err != nil {
+		if !quota.WriteError(w, err) {
+			middleware.WriteErrorResponse(w, http.StatusInternalServerError, err, "Failed to check AI query quota")
+		}
+		return nil, false
+	}
+	return reservation, true
+}
+
+// releaseAIQuery gives back a reservation the provider was not called for.
+func (h *AIAssistantHandler) releaseAIQuery(userID string, reservation *quota.Reservation) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if err := h.quotas.Release(ctx, reservation); err != nil {
+		log.Error().Err(err).Str("user_id", userID).Msg("Failed to release AI query reservation")
+	}
+}
+
+func (h *AIAssistantHandler) recordAIUsage(userID string, reservation *quota.Reservation, action, question, sql string, completion *ai.CompletionResponse) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	metadata := map[string]interface{}{
+		"organization_id":   reservation.Usage.OrganizationID,
+		"action":            action,
+		"provider":          h.provider.Name(),
+		"model":             completion.Model,
+		"question":          truncateRunes(question, 1000),
+		"sql":               truncateRunes(sql, 1000),
+		"fingerprint":       sqltools.Fingerprint(sql),
+		"prompt_tokens":     completion.PromptTokens,
+		"completion_tokens": completion.CompletionTokens,
+	}
+
+	metadataBytes, _ := json.Marshal(metadata)
+
+	query := `
+		INSERT INTO metrics (user_id, metric_type, metric_value, metadata, created_at)
+		VALUES ($1, 'ai_query_executed', $2, $3, CURRENT_TIMESTAMP)
+	`
+
+	if err := h.db.Exec(ctx, query, userID, completion.PromptTokens+completion.CompletionTokens, metadataBytes); err != nil {
+		log.Error().Err(err).Str("user_id", userID).Msg("Failed to record AI usage")
+	}
+
+	err := h.quotas.Settle(ctx, reservation, map[string]interface{}{
+		"model":             completion.Model,
+		"prompt_tokens":     completion.PromptTokens,
+		"completion_tokens": completion.CompletionTokens,
+	})
+	if err != nil {
+		log.Error().Err(err).Str("user_id", userID).Msg("Failed to meter AI usage")
+	}
+}
+
+// truncateRunes cuts s to at most n characters without splitting one.
+func truncateRunes(s string, n int) string {
+	if runes := []rune(s); len(runes) > n {
+		return string(runes[:n])
+	}
+	return s
+}
@@ -0,0 +1,278 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"go-backend/audit"
+	"go-backend/auth"
+	"go-backend/database"
+	"go-backend/middleware"
+	"go-backend/models"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+	"github.com/jackc/pgx/v5"
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	defaultAPIKeyLifetime = 90
+	maxAPIKeyLifetime     = 365
+)
+
+// APIKeyHandler mints and revokes API keys. Users manage their own keys;
+// organization owners and admins also see and revoke every key restricted to
+// their organization. Requests made with an API key cannot manage keys.
+type APIKeyHandler struct {
+	db    *database.PostgresDB
+	audit *audit.Logger
+}
+
+func NewAPIKeyHandler(db *database.PostgresDB, auditLog *audit.Logger) *APIKeyHandler {
+	return &APIKeyHandler{db: db, audit: auditLog}
+}
+
+const apiKeyColumns = `id, user_id, organization_id, name, prefix, scopes, created_by, created_at,
+	expires_at, last_used_at, last_used_ip, revoked_at, revoked_by`
+
+func scanAPIKey(row pgx.Row) (*models.APIKey, error) {
+	var k models.APIKey
+	err := row.Scan(&k.ID, &k.UserID, &k.OrganizationID, &k.Name, &k.Prefix, &k.Scopes, &k.CreatedBy,
+		&k.CreatedAt, &k.ExpiresAt, &k.LastUsedAt, &k.LastUsedIP, &k.RevokedAt, &k.RevokedBy)
+	if err != nil {
+		return nil, err
+	}
+	return &k, nil
+}
+
+// decodeAPIKeyRequest reads and validates a new key's name, scopes and
+// lifetime.
+func decodeAPIKeyRequest(w http.ResponseWriter, r *http.Request) (*models.CreateAPIKeyRequest, bool) {
+	var req models.CreateAPIKeyRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return nil, false
+	}
+
+	req.Name = strings.TrimSpace(req.Name)
+	if req.Name == "" || len(req.Name) > 100 {
+		http.Error(w, "name is required and must be at most 100 characters", http.StatusBadRequest)
+		return nil, false
+	}
+
+	if len(req.Scopes) == 0 {
+		http.Error(w, "scopes must list at least one scope", http.StatusBadRequest)
+		return nil, false
+	}
+	seen := map[string]bool{}
+	scopes := []string{}
+	for _, s := range req.Scopes {
+		if _, ok := auth.Scopes[s]; !ok {
+			http.Error(w, "Unknown scope: "+s, http.StatusBadRequest)
+			return nil, false
+		}
+		if !seen[s] {
+			seen[s] = true
+			scopes = append(scopes, s)
+		}
+	}
+	req.Scopes = scopes
+
+	if req.ExpiresInDays == 0 {
+		req.ExpiresInDays = defaultAPIKeyLifetime
+	}
+	if req.ExpiresInDays < 0 || req.ExpiresInDays > maxAPIKeyLifetime {
+		http.Error(w, "expires_in_days must be between 1 and 365", http.StatusBadRequest)
+		return nil, false
+	}
+	return &req, true
+}
+
+// create stores a new key for userID and writes it, with the key itself, to
+// the response.
+func (h *APIKeyHandler) create(w http.ResponseWriter, r *http.Request, userID string, orgID *string, req *models.CreateAPIKeyRequest) {
+	key, hash, prefix, err := auth.GenerateAPIKey()
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to generate API key")
+		http.Error(w, "Failed to create API key", http.StatusInternalServerError)
+		return
+	}
+
+	creator := middleware.GetUserClaims(r.Context()).UserID
+	expiresAt := time.Now().AddDate(0, 0, req.ExpiresInDays)
+
+	row := h.db.QueryRow(r.Context(), `
+		INSERT INTO api_keys (id, user_id, organization_id, name, prefix, key_hash, scopes, created_by, expires_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)
+		RETURNING `+apiKeyColumns,
+		uuid.New().String(), userID, orgID, req.Name, prefix, hash, req.Scopes, creator, expiresAt)
+	apiKey, err := scanAPIKey(row)
+	if err != nil {
+		log.Error().Err(err).Str("user_id", userID).Msg("Failed to create API key")
+		http.Error(w, "Failed to create API key", http.StatusInternalServerError)
+		return
+	}
+
+	h.audit.Record(r, audit.Event{
+		OrganizationID: deref(orgID),
+		Action:         audit.ActionAPIKeyCreated,
+		TargetType:     audit.TargetAPIKey,
+		TargetID:       apiKey.ID,
+		Metadata: map[string]interface{}{
+			"name":       apiKey.Name,
+			"owner_id":   apiKey.UserID,
+			"scopes":     apiKey.Scopes,
+			"expires_at": apiKey.ExpiresAt,
+		},
+	})
+
+	apiKey.Key = key
+	middleware.WriteJSONResponse(w, http.StatusCreated, apiKey)
+}
+
+// list writes the keys matching cond, newest first.
+func (h *APIKeyHandler) list(w http.ResponseWriter, r *http.Request, cond string, args ...interface{}) {
+	rows, err := h.db.Query(r.Context(), `
+		SELECT `+apiKeyColumns+` FROM api_keys WHERE `+cond+` ORDER BY created_at DESC
+	`, args...)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to query API keys")
+		http.Error(w, "Failed to fetch API keys", http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	keys := []models.APIKey{}
+	for rows.Next() {
+		k, err := scanAPIKey(rows)
+		if err != nil {
+			log.Error().Err(err).Msg("Failed to scan API key")
+			continue
+		}
+		keys = append(keys, *k)
+	}
+
+	middleware.WriteJSONResponse(w, http.StatusOK, map[string]interface{}{
+		"data": keys,
+	})
+}
+
+// revoke marks the key matching cond revoked. Revoking twice is harmless.
+func (h *APIKeyHandler) revoke(w http.ResponseWriter, r *http.Request, cond string, args ...interface{}) {
+	ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
+	defer cancel()
+
+	revoker := middleware.GetUserClaims(r.Context()).UserID
+	row := h.db.QueryRow(ctx, `
+		UPDATE api_keys SET revoked_at = COALESCE(revoked_at, NOW()), revoked_by = COALESCE(revoked_by, $1)
+		WHERE `+cond+`
+		RETURNING `+apiKeyColumns,
+		append([]interface{}{revoker}, args...)...)
+	apiKey, err := scanAPIKey(row)
+	if errors.Is(err, pgx.ErrNoRows) {
+		http.Error(w, "API key not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to revoke API key")
+		http.Error(w, "Failed to revoke API key", http.StatusInternalServerError)
+		return
+	}
+
+	h.audit.Record(r, audit.Event{
+		OrganizationID: deref(apiKey.OrganizationID),
+		Action:         audit.ActionAPIKeyRevoked,
+		TargetType:     audit.TargetAPIKey,
+		TargetID:       apiKey.ID,
+		Metadata: map[string]interface{}{
+			"name":     apiKey.Name,
+			"owner_id": apiKey.UserID,
+		},
+	})
+
+	middleware.WriteJSONResponse(w, http.StatusOK, apiKey)
+}
+
+// GET /api/v1/users/{user_id}/api-keys
+func (h *APIKeyHandler) ListUserAPIKeys(w http.ResponseWriter, r *http.Request) {
+	userID := mux.Vars(r)["user_id"]
+	h.list(w, r, "user_id = $1", userID)
+}
+
+// POST /api/v1/users/{user_id}/api-keys
+//
+// A key with organization_id only reaches that organization's routes, and
+// stops working if its owner leaves the organization or is suspended.
+func (h *APIKeyHandler) CreateUserAPIKey(w http.ResponseWriter, r *http.Request) {
+	userID := mux.Vars(r)["user_id"]
+	req, ok := decodeAPIKeyRequest(w, r)
+	if !ok {
+		return
+	}
+
+	if req.OrganizationID != nil && *req.OrganizationID != "" {
+		var count int
+		err := h.db.QueryRow(r.Context(), `
+			SELECT COUNT(*) FROM organization_members
+			WHERE organization_id = $1 AND user_id = $2 AND status = 'active'
+		`, *req.OrganizationID, userID).Scan(&count)
+		if err != nil || count == 0 {
+			http.Error(w, "Organization not found or access denied", http.StatusNotFound)
+			return
+		}
+	} else {
+		req.OrganizationID = nil
+	}
+
+	h.create(w, r, userID, req.OrganizationID, req)
+}
+
+// DELETE /api/v1/users/{user_id}/api-keys/{keyId}
+func (h *APIKeyHandler) RevokeUserAPIKey(w http.ResponseWriter, r *http.Request) {
+	userID := mux.Vars(r)["user_id"]
+	h.revoke(w, r, "id = $2 AND user_id = $3", mux.Vars(r)["keyId"], userID)
+}
+
+// GET /api/v1/users/{userId}/organizations/{orgId}/api-keys
+func (h *APIKeyHandler) ListOrganizationAPIKeys(w http.ResponseWriter, r *http.Request) {
+	orgID := mux.Vars(r)["orgId"]
+	h.list(w, r, "organization_id = $1", orgID)
+}
+
+// POST /api/v1/users/{userId}/organizations/{orgId}/api-keys
+//
+// Mints a key restricted to the organization for the calling admin, or with
+// service_account_id for one of the organization's service accounts.
+func (h *APIKeyHandler) CreateOrganizationAPIKey(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	userID, orgID := vars["userId"], vars["orgId"]
+	req, ok := decodeAPIKeyRequest(w, r)
+	if !ok {
+		return
+	}
+
+	if req.ServiceAccountID != nil && *req.ServiceAccountID != "" {
+		var count int
+		err := h.db.QueryRow(r.Context(), `
+			SELECT COUNT(*) FROM service_accounts WHERE organization_id = $1 AND user_id = $2
+		`, orgID, *req.ServiceAccountID).Scan(&count)
+		if err != nil || count == 0 {
+			http.Error(w, "Service account not found", http.StatusNotFound)
+			return
+		}
+		userID = *req.ServiceAccountID
+	}
+
+	h.create(w, r, userID, &orgID, req)
+}
+
+// DELETE /api/v1/users/{userId}/organizations/{orgId}/api-keys/{keyId}
+func (h *APIKeyHandler) RevokeOrganizationAPIKey(w http.ResponseWriter, r *http.Request) {
+	orgID := mux.Vars(r)["orgId"]
+	h.revoke(w, r, "id = $2 AND organization_id = $3", mux.Vars(r)["keyId"], orgID)
+}
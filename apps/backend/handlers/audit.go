@@ -0,0 +1,388 @@
+package handlers
+
+import (
+	"context"
+	"crypto/ed25519"
+	"encoding/base64"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"go-backend/audit"
+	"go-backend/database"
+	"go-backend/quota"
+
+	"github.com/gorilla/mux"
+	"github.com/rs/zerolog/log"
+)
+
+// maxAuditExportRows caps one export; narrow the time range for more.
+const maxAuditExportRows = 100000
+
+type AuditHandler struct {
+	db          *database.PostgresDB
+	quotas      *quota.Service
+	audit       *audit.Logger
+	checkpoints *audit.Checkpointer // nil when no signing key is configured
+}
+
+func NewAuditHandler(db *database.PostgresDB, quotas *quota.Service, auditLog *audit.Logger, checkpoints *audit.Checkpointer) *AuditHandler {
+	return &AuditHandler{db: db, quotas: quotas, audit: auditLog, checkpoints: checkpoints}
+}
+
+// authorizeOrganization checks that the organization's plan includes the
+// audit log. The policy has already checked the caller's role.
+func (h *AuditHandler) authorizeOrganization(w http.ResponseWriter, r *http.Request) (string, bool) {
+	vars := mux.Vars(r)
+	userID := vars["userId"]
+	orgID := vars["orgId"]
+
+	if userID == "" || orgID == "" {
+		http.Error(w, "User ID and Organization ID are required", http.StatusBadRequest)
+		return "", false
+	}
+
+	ctx := r.Context()
+
+	if err := h.quotas.RequireFeature(ctx, quota.OrganizationSubject(orgID), quota.FeatureAuditLog); err != nil {
+		if !quota.WriteError(w, err) {
+			log.Error().Err(err).Str("organization_id", orgID).Msg("Failed to check audit log entitlement")
+			http.Error(w, "Failed to check plan", http.StatusInternalServerError)
+		}
+		return "", false
+	}
+	return orgID, true
+}
+
+// parseAuditFilter reads actor_id, actor_role, action, target_type,
+// target_id, from, to, before and limit from the query string.
+func parseAuditFilter(r *http.Request) (audit.Filter, error) {
+	q := r.URL.Query()
+	f := audit.Filter{
+		OrganizationID: q.Get("organization_id"),
+		ActorID:        q.Get("actor_id"),
+		ActorRole:      q.Get("actor_role"),
+		Action:         q.Get("action"),
+		TargetType:     q.Get("target_type"),
+		TargetID:       q.Get("target_id"),
+	}
+
+	for name, dest := range map[string]**time.Time{"from": &f.From, "to": &f.To} {
+		if v := q.Get(name); v != "" {
+			t, err := time.Parse(time.RFC3339, v)
+			if err != nil {
+				return f, fmt.Errorf("%s must be an RFC 3339 timestamp", name)
+			}
+			*dest = &t
+		}
+	}
+	if v := q.Get("before"); v != "" {
+		before, err := strconv.ParseInt(v, 10, 64)
+		if err != nil || before <= 0 {
+			return f, fmt.Errorf("before must be an entry ID")
+		}
+		f.Before = before
+	}
+	if v := q.Get("limit"); v != "" {
+		limit, err := strconv.Atoi(v)
+		if err != nil || limit <= 0 {
+			return f, fmt.Errorf("limit must be a positive number")
+		}
+		f.Limit = limit
+	}
+	return f, nil
+}
+
+func (h *AuditHandler) list(w http.ResponseWriter, r *http.Request, f audit.Filter) {
+	if f.Limit == 0 {
+		f.Limit = 100
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 30*time.Second)
+	defer cancel()
+
+	entries, err := h.audit.Query(ctx, f)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to query audit log")
+		http.Error(w, "Failed to fetch audit log", http.StatusInternalServerError)
+		return
+	}
+
+	response := map[string]interface{}{
+		"data": entries,
+	}
+	if len(entries) > 0 && len(entries) == f.Limit {
+		response["next_before"] = entries[len(entries)-1].ID
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// export streams every entry matching f as CSV or JSON lines and records the
+// export itself.
+func (h *AuditHandler) export(w http.ResponseWriter, r *http.Request, f audit.Filter) {
+	format := r.URL.Query().Get("format")
+	if format == "" {
+		format = "csv"
+	}
+	if format != "csv" && format != "jsonl" {
+		http.Error(w, "format must be csv or jsonl", http.StatusBadRequest)
+		return
+	}
+	f.Before = 0
+	f.Limit = maxAuditExportRows
+
+	h.audit.Record(r, audit.Event{
+		OrganizationID: f.OrganizationID,
+		Action:         audit.ActionAuditLogExported,
+		TargetType:     audit.TargetOrganization,
+		TargetID:       f.OrganizationID,
+		Metadata:       map[string]interface{}{"format": format, "filter": r.URL.RawQuery},
+	})
+
+	if err := http.NewResponseController(w).SetWriteDeadline(time.Now().Add(5 * time.Minute)); err != nil {
+		log.Warn().Err(err).Msg("Failed to extend write deadline for audit export")
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Minute)
+	defer cancel()
+
+	filename := fmt.Sprintf("audit-log-%s.%s", time.Now().UTC().Format("20060102-150405"), format)
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", filename))
+
+	var err error
+	if format == "csv" {
+		w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+		cw := csv.NewWriter(w)
+		cw.Write([]string{"id", "created_at", "organization_id", "actor_id", "actor_email", "actor_role",
+			"action", "target_type", "target_id", "ip_address", "user_agent", "request_id", "metadata",
+			"prev_hash", "hash"})
+		err = h.audit.Each(ctx, f, func(e audit.Entry) error {
+			metadata, _ := json.Marshal(e.Metadata)
+			cw.Write([]string{strconv.FormatInt(e.ID, 10), e.CreatedAt.UTC().Format(time.RFC3339Nano),
+				deref(e.OrganizationID), deref(e.ActorID), deref(e.ActorEmail), deref(e.ActorRole),
+				e.Action, e.TargetType, e.TargetID, deref(e.IPAddress), deref(e.UserAgent), deref(e.RequestID),
+				string(metadata), deref(e.PrevHash), deref(e.Hash)})
+			return cw.Error()
+		})
+		cw.Flush()
+	} else {
+		w.Header().Set("Content-Type", "application/x-ndjson")
+		enc := json.NewEncoder(w)
+		err = h.audit.Each(ctx, f, func(e audit.Entry) error {
+			return enc.Encode(e)
+		})
+	}
+
+	// Headers are gone by now, so a failure can only cut the file short
+	if err != nil {
+		log.Error().Err(err).Str("organization_id", f.OrganizationID).Msg("Audit log export failed")
+	}
+}
+
+// publicKey is the key checkpoints are verified against, or nil.
+func (h *AuditHandler) publicKey() ed25519.PublicKey {
+	if h.checkpoints == nil {
+		return nil
+	}
+	return h.checkpoints.PublicKey()
+}
+
+// verify walks the chain of orgID, or of entries outside any organization
+// when it is empty, and writes the outcome.
+func (h *AuditHandler) verify(w http.ResponseWriter, r *http.Request, orgID string) {
+	if err := http.NewResponseController(w).SetWriteDeadline(time.Now().Add(5 * time.Minute)); err != nil {
+		log.Warn().Err(err).Msg("Failed to extend write deadline for audit verification")
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Minute)
+	defer cancel()
+
+	result, err := h.audit.Verify(ctx, orgID, h.publicKey())
+	if err != nil {
+		log.Error().Err(err).Str("organization_id", orgID).Msg("Failed to verify audit chain")
+		http.Error(w, "Failed to verify audit log", http.StatusInternalServerError)
+		return
+	}
+	if !result.Valid {
+		log.Warn().Str("organization_id", orgID).Int("breaks", len(result.Breaks)).Msg("Audit chain failed verification")
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"data": result,
+	})
+}
+
+// listCheckpoints writes the chain's checkpoints with the key that signs
+// them, or downloads them as JSON lines with format=jsonl.
+func (h *AuditHandler) listCheckpoints(w http.ResponseWriter, r *http.Request, orgID string) {
+	format := r.URL.Query().Get("format")
+	if format != "" && format != "json" && format != "jsonl" {
+		http.Error(w, "format must be json or jsonl", http.StatusBadRequest)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 30*time.Second)
+	defer cancel()
+
+	checkpoints, err := h.audit.Checkpoints(ctx, orgID, 0)
+	if err != nil {
+		log.Error().Err(err).Str("organization_id", orgID).Msg("Failed to fetch audit checkpoints")
+		http.Error(w, "Failed to fetch audit checkpoints", http.StatusInternalServerError)
+		return
+	}
+
+	if format == "jsonl" {
+		filename := fmt.Sprintf("audit-checkpoints-%s.jsonl", time.Now().UTC().Format("20060102-150405"))
+		w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", filename))
+		w.Header().Set("Content-Type", "application/x-ndjson")
+		enc := json.NewEncoder(w)
+		for _, c := range checkpoints {
+			enc.Encode(c)
+		}
+		return
+	}
+
+	response := map[string]interface{}{
+		"data": checkpoints,
+	}
+	if key := h.publicKey(); key != nil {
+		response["public_key"] = base64.StdEncoding.EncodeToString(key)
+		response["key_id"] = audit.KeyID(key)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+func deref(s *string) string {
+	if s == nil {
+		return ""
+	}
+	return *s
+}
+
+// GET /api/v1/users/{userId}/organizations/{orgId}/audit-log
+func (h *AuditHandler) GetOrganizationAuditLog(w http.ResponseWriter, r *http.Request) {
+	orgID, ok := h.authorizeOrganization(w, r)
+	if !ok {
+		return
+	}
+
+	f, err := parseAuditFilter(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	f.OrganizationID = orgID
+
+	h.list(w, r, f)
+}
+
+// GET /api/v1/users/{userId}/organizations/{orgId}/audit-log/export
+func (h *AuditHandler) ExportOrganizationAuditLog(w http.ResponseWriter, r *http.Request) {
+	orgID, ok := h.authorizeOrganization(w, r)
+	if !ok {
+		return
+	}
+
+	f, err := parseAuditFilter(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	f.OrganizationID = orgID
+
+	h.export(w, r, f)
+}
+
+// GET /api/v1/admin/audit-log
+//
+// Platform admins see every entry, including actions outside organizations;
+// filter with ?organization_id=.
+func (h *AuditHandler) GetAuditLog(w http.ResponseWriter, r *http.Request) {
+	f, err := parseAuditFilter(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	h.list(w, r, f)
+}
+
+// GET /api/v1/admin/audit-log/export
+func (h *AuditHandler) ExportAuditLog(w http.ResponseWriter, r *http.Request) {
+	f, err := parseAuditFilter(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	h.export(w, r, f)
+}
+
+// GET /api/v1/users/{userId}/organizations/{orgId}/audit-log/verify
+//
+// Walks the organization's hash chain and reports where it breaks.
+func (h *AuditHandler) VerifyOrganizationAuditLog(w http.ResponseWriter, r *http.Request) {
+	orgID, ok := h.authorizeOrganization(w, r)
+	if !ok {
+		return
+	}
+	h.verify(w, r, orgID)
+}
+
+// GET /api/v1/users/{userId}/organizations/{orgId}/audit-log/checkpoints
+func (h *AuditHandler) GetOrganizationCheckpoints(w http.ResponseWriter, r *http.Request) {
+	orgID, ok := h.authorizeOrganization(w, r)
+	if !ok {
+		return
+	}
+	h.listCheckpoints(w, r, orgID)
+}
+
+// GET /api/v1/admin/audit-log/verify
+//
+// Verifies one organization's chain with ?organization_id=, or the chain of
+// actions outside any organization without it.
+func (h *AuditHandler) VerifyAuditLog(w http.ResponseWriter, r *http.Request) {
+	h.verify(w, r, r.URL.Query().Get("organization_id"))
+}
+
+// GET /api/v1/admin/audit-log/checkpoints
+func (h *AuditHandler) GetCheckpoints(w http.ResponseWriter, r *http.Request) {
+	h.listCheckpoints(w, r, r.URL.Query().Get("organization_id"))
+}
+
+// POST /api/v1/admin/audit-log/checkpoints
+//
+// Writes checkpoints for chains with new entries now rather than waiting
+// for the next scheduled run.
+func (h *AuditHandler) CreateCheckpoints(w http.ResponseWriter, r *http.Request) {
+	if h.checkpoints == nil {
+		http.Error(w, "Audit checkpoints are not configured", http.StatusServiceUnavailable)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), time.Minute)
+	defer cancel()
+
+	checkpoints, err := h.checkpoints.Run(ctx)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to write audit checkpoints")
+		http.Error(w, "Failed to write audit checkpoints", http.StatusInternalServerError)
+		return
+	}
+	if checkpoints == nil {
+		checkpoints = []audit.Checkpoint{}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"data": checkpoints,
+	})
+}
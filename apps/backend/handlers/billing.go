@@ -0,0 +1,409 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"time"
+
+	"go-backend/audit"
+	"go-backend/billing"
+	"go-backend/database"
+	"go-backend/middleware"
+	"go-backend/quota"
+
+	"github.com/gorilla/mux"
+	"github.com/jackc/pgx/v5"
+	"github.com/rs/zerolog/log"
+)
+
+const maxWebhookBytes = 1 << 20
+
+// BillingHandler sells plans through the billing provider and keeps
+// organizations.plan in step with their subscriptions.
+type BillingHandler struct {
+	db       *database.PostgresDB
+	provider billing.Provider
+	quotas   *quota.Service
+	audit    *audit.Logger
+}
+
+func NewBillingHandler(db *database.PostgresDB, provider billing.Provider, quotas *quota.Service, auditLog *audit.Logger) *BillingHandler {
+	return &BillingHandler{db: db, provider: provider, quotas: quotas, audit: auditLog}
+}
+
+type CheckoutRequest struct {
+	Plan       string `json:"plan"`
+	SuccessURL string `json:"success_url"`
+	CancelURL  string `json:"cancel_url"`
+}
+
+type CheckoutResponse struct {
+	CheckoutURL string `json:"checkout_url"`
+	SessionID   string `json:"session_id"`
+	Plan        string `json:"plan"`
+}
+
+// BillingStatus is an organization's plan and, if it has one, its
+// subscription as last reported by the provider.
+type BillingStatus struct {
+	OrganizationID     string     `json:"organization_id"`
+	Plan               string     `json:"plan"`
+	Provider           string     `json:"provider,omitempty"`
+	SubscriptionID     string     `json:"subscription_id,omitempty"`
+	SubscriptionStatus string     `json:"subscription_status,omitempty"`
+	CurrentPeriodEnd   *time.Time `json:"current_period_end,omitempty"`
+	CancelAtPeriodEnd  bool       `json:"cancel_at_period_end"`
+}
+
+// GET /api/v1/users/{userId}/organizations/{orgId}/billing
+func (h *BillingHandler) GetBillingStatus(w http.ResponseWriter, r *http.Request) {
+	orgID := mux.Vars(r)["orgId"]
+
+	ctx := context.Background()
+	status := BillingStatus{OrganizationID: orgID}
+
+	var provider, subscriptionID, subscriptionStatus *string
+	err := h.db.QueryRow(ctx, `
+		SELECT COALESCE(o.plan, ''), b.provider, b.subscription_id, b.subscription_status,
+			b.current_period_end, COALESCE(b.cancel_at_period_end, FALSE)
+		FROM organizations o
+		LEFT JOIN organization_billing b ON b.organization_id = o.id
+		WHERE o.id = $1
+	`, orgID).Scan(&status.Plan, &provider, &subscriptionID, &subscriptionStatus, &status.CurrentPeriodEnd, &status.CancelAtPeriodEnd)
+	if err != nil {
+		log.Error().Err(err).Str("org_id", orgID).Msg("Failed to load billing status")
+		http.Error(w, "Failed to fetch billing status", http.StatusInternalServerError)
+		return
+	}
+	if provider != nil {
+		status.Provider = *provider
+	}
+	if subscriptionID != nil {
+		status.SubscriptionID = *subscriptionID
+	}
+	if subscriptionStatus != nil {
+		status.SubscriptionStatus = *subscriptionStatus
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"data": status,
+	})
+}
+
+// POST /api/v1/users/{userId}/organizations/{orgId}/billing/checkout
+func (h *BillingHandler) CreateCheckoutSession(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	userID := vars["userId"]
+	orgID := vars["orgId"]
+
+	if h.provider == nil {
+		http.Error(w, billing.ErrNotConfigured.Error(), http.StatusServiceUnavailable)
+		return
+	}
+
+	var req CheckoutRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if !isAbsoluteURL(req.SuccessURL) || !isAbsoluteURL(req.CancelURL) {
+		http.Error(w, "success_url and cancel_url must be absolute http(s) URLs", http.StatusBadRequest)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 30*time.Second)
+	defer cancel()
+
+	plan, err := h.quotas.GetPlan(ctx, req.Plan)
+	if errors.Is(err, quota.ErrPlanNotFound) || (err == nil && plan.PriceID == "") {
+		http.Error(w, "Plan is not available for purchase", http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to load plan")
+		http.Error(w, "Failed to start checkout", http.StatusInternalServerError)
+		return
+	}
+
+	customerID, err := h.ensureCustomer(ctx, userID, orgID)
+	if err != nil {
+		log.Error().Err(err).Str("org_id", orgID).Msg("Failed to create billing customer")
+		http.Error(w, "Failed to start checkout", http.StatusBadGateway)
+		return
+	}
+
+	session, err := h.provider.CreateCheckoutSession(ctx, billing.CheckoutParams{
+		CustomerID:     customerID,
+		PriceID:        plan.PriceID,
+		OrganizationID: orgID,
+		SuccessURL:     req.SuccessURL,
+		CancelURL:      req.CancelURL,
+	})
+	if err != nil {
+		log.Error().Err(err).Str("org_id", orgID).Msg("Failed to create checkout session")
+		http.Error(w, "Failed to start checkout", http.StatusBadGateway)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"data": CheckoutResponse{CheckoutURL: session.URL, SessionID: session.ID, Plan: plan.ID},
+	})
+}
+
+// POST /api/v1/billing/webhook
+//
+// Called by the billing provider, not by users: the request is authenticated
+// by its signature. Events are recorded by id so retries are acknowledged
+// without being applied twice; a failure to apply one returns 500 so the
+// provider retries it.
+func (h *BillingHandler) HandleWebhook(w http.ResponseWriter, r *http.Request) {
+	if h.provider == nil {
+		middleware.WriteErrorResponse(w, http.StatusServiceUnavailable, billing.ErrNotConfigured, "Billing is not configured")
+		return
+	}
+
+	payload, err := io.ReadAll(io.LimitReader(r.Body, maxWebhookBytes))
+	if err != nil {
+		middleware.WriteErrorResponse(w, http.StatusBadRequest, err, "Failed to read request body")
+		return
+	}
+
+	event, err := h.provider.ParseWebhook(payload, r.Header.Get("Stripe-Signature"))
+	if err != nil {
+		log.Warn().Err(err).Msg("Rejected billing webhook")
+		middleware.WriteErrorResponse(w, http.StatusBadRequest, err, "Invalid webhook")
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+	defer cancel()
+
+	var recorded string
+	err = h.db.QueryRow(ctx, `
+		INSERT INTO billing_events (id, event_type) VALUES ($1, $2)
+		ON CONFLICT (id) DO NOTHING
+		RETURNING id
+	`, event.ID, event.Type).Scan(&recorded)
+	if errors.Is(err, pgx.ErrNoRows) {
+		middleware.WriteJSONResponse(w, http.StatusOK, map[string]interface{}{"received": true, "duplicate": true})
+		return
+	}
+	if err != nil {
+		log.Error().Err(err).Str("event_id", event.ID).Msg("Failed to record billing event")
+		middleware.WriteErrorResponse(w, http.StatusInternalServerError, err, "Failed to process webhook")
+		return
+	}
+
+	orgID, enforcement, err := h.applyEvent(ctx, event)
+	if err != nil {
+		log.Error().Err(err).Str("event_id", event.ID).Str("event_type", event.Type).Msg("Failed to apply billing event")
+		if delErr := h.db.Exec(ctx, "DELETE FROM billing_events WHERE id = $1", event.ID); delErr != nil {
+			log.Error().Err(delErr).Str("event_id", event.ID).Msg("Failed to release billing event for retry")
+		}
+		middleware.WriteErrorResponse(w, http.StatusInternalServerError, err, "Failed to process webhook")
+		return
+	}
+
+	if orgID != "" {
+		if err := h.db.Exec(ctx, "UPDATE billing_events SET organization_id = $2 WHERE id = $1", event.ID, orgID); err != nil {
+			log.Warn().Err(err).Str("event_id", event.ID).Msg("Failed to tag billing event")
+		}
+	}
+	if enforcement != nil {
+		h.audit.RecordSystem(r, audit.Event{
+			OrganizationID: orgID,
+			Action:         audit.ActionPlanChanged,
+			TargetType:     audit.TargetOrganization,
+			TargetID:       orgID,
+			Metadata: map[string]interface{}{
+				"plan":                enforcement.Plan,
+				"source":              "billing",
+				"event_id":            event.ID,
+				"subscription_status": event.Subscription.Status,
+				"read_only_projects":  enforcement.ReadOnly,
+				"restored_projects":   enforcement.Restored,
+			},
+		})
+	}
+
+	middleware.WriteJSONResponse(w, http.StatusOK, map[string]interface{}{"received": true})
+}
+
+// applyEvent updates billing state for one event and returns the
+// organization it concerned, if any, and the plan change it caused. Events
+// for unknown organizations or customers are acknowledged and ignored.
+func (h *BillingHandler) applyEvent(ctx context.Context, event *billing.Event) (string, *quota.Enforcement, error) {
+	switch {
+	case event.Checkout != nil:
+		checkout := event.Checkout
+		if checkout.OrganizationID == "" || checkout.CustomerID == "" {
+			return "", nil, nil
+		}
+		err := h.db.Exec(ctx, `
+			INSERT INTO organization_billing (organization_id, provider, customer_id, subscription_id)
+			SELECT id, $2, $3, NULLIF($4, '') FROM organizations WHERE id = $1
+			ON CONFLICT (organization_id) DO UPDATE SET
+				provider = EXCLUDED.provider,
+				customer_id = EXCLUDED.customer_id,
+				subscription_id = COALESCE(EXCLUDED.subscription_id, organization_billing.subscription_id),
+				updated_at = NOW()
+		`, checkout.OrganizationID, h.provider.Name(), checkout.CustomerID, checkout.SubscriptionID)
+		return checkout.OrganizationID, nil, err
+
+	case event.Subscription != nil:
+		return h.applySubscription(ctx, event)
+	}
+	return "", nil, nil
+}
+
+func (h *BillingHandler) applySubscription(ctx context.Context, event *billing.Event) (string, *quota.Enforcement, error) {
+	sub := event.Subscription
+
+	// Find the organization and what we last heard about its subscription
+	var orgID string
+	var currentSubscription *string
+	var lastEventAt *time.Time
+	err := h.db.QueryRow(ctx, `
+		SELECT o.id, b.subscription_id, b.last_event_at
+		FROM organizations o
+		LEFT JOIN organization_billing b ON b.organization_id = o.id
+		WHERE o.id = $1
+		   OR (b.provider = $2 AND b.customer_id = $3)
+		ORDER BY (o.id = $1) DESC
+		LIMIT 1
+	`, sub.OrganizationID, h.provider.Name(), sub.CustomerID).Scan(&orgID, &currentSubscription, &lastEventAt)
+	if errors.Is(err, pgx.ErrNoRows) {
+		log.Warn().Str("subscription_id", sub.ID).Str("customer_id", sub.CustomerID).Msg("Billing event for unknown organization")
+		return "", nil, nil
+	}
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to find organization for subscription: %w", err)
+	}
+
+	var last time.Time
+	if lastEventAt != nil {
+		last = *lastEventAt
+	}
+	if event.Outdated(deref(currentSubscription), last) {
+		return orgID, nil, nil
+	}
+
+	var periodEnd *time.Time
+	if !sub.CurrentPeriodEnd.IsZero() {
+		periodEnd = &sub.CurrentPeriodEnd
+	}
+	err = h.db.Exec(ctx, `
+		INSERT INTO organization_billing (organization_id, provider, customer_id, subscription_id,
+			subscription_status, price_id, current_period_end, cancel_at_period_end, last_event_at)
+		VALUES ($1, $2, $3, $4, $5, NULLIF($6, ''), $7, $8, $9)
+		ON CONFLICT (organization_id) DO UPDATE SET
+			provider = EXCLUDED.provider,
+			customer_id = EXCLUDED.customer_id,
+			subscription_id = EXCLUDED.subscription_id,
+			subscription_status = EXCLUDED.subscription_status,
+			price_id = EXCLUDED.price_id,
+			current_period_end = EXCLUDED.current_period_end,
+			cancel_at_period_end = EXCLUDED.cancel_at_period_end,
+			last_event_at = EXCLUDED.last_event_at,
+			updated_at = NOW()
+	`, orgID, h.provider.Name(), sub.CustomerID, sub.ID, sub.Status, sub.PriceID, periodEnd, sub.CancelAtPeriodEnd, event.Created)
+	if err != nil {
+		return orgID, nil, fmt.Errorf("failed to save subscription: %w", err)
+	}
+
+	planID, err := h.planForSubscription(ctx, event)
+	if err != nil {
+		return orgID, nil, err
+	}
+	if planID == "" {
+		return orgID, nil, nil
+	}
+
+	enforcement, err := h.quotas.SetOrganizationPlan(ctx, orgID, planID)
+	if err != nil {
+		return orgID, nil, fmt.Errorf("failed to update organization plan: %w", err)
+	}
+	log.Info().
+		Str("org_id", orgID).
+		Str("plan", planID).
+		Str("subscription_status", sub.Status).
+		Int("projects_read_only", len(enforcement.ReadOnly)).
+		Int("projects_restored", len(enforcement.Restored)).
+		Msg("Organization plan updated from billing")
+	return orgID, enforcement, nil
+}
+
+// planForSubscription is the plan a subscription entitles its organization
+// to (see billing.Event.PlanSource). An empty result leaves the plan alone.
+func (h *BillingHandler) planForSubscription(ctx context.Context, event *billing.Event) (string, error) {
+	sub := event.Subscription
+	switch event.PlanSource() {
+	case billing.PlanFromPrice:
+		plan, err := h.quotas.PlanForPrice(ctx, sub.PriceID)
+		if errors.Is(err, quota.ErrPlanNotFound) {
+			log.Error().Str("price_id", sub.PriceID).Str("subscription_id", sub.ID).Msg("Subscription price is not linked to any plan")
+			return "", nil
+		}
+		if err != nil {
+			return "", err
+		}
+		return plan.ID, nil
+	case billing.PlanDefault:
+		return h.quotas.DefaultPlanID(ctx)
+	}
+	return "", nil
+}
+
+// ensureCustomer returns the organization's customer id with the current
+// provider, creating the customer on first checkout.
+func (h *BillingHandler) ensureCustomer(ctx context.Context, userID, orgID string) (string, error) {
+	var customerID string
+	err := h.db.QueryRow(ctx, `
+		SELECT customer_id FROM organization_billing WHERE organization_id = $1 AND provider = $2
+	`, orgID, h.provider.Name()).Scan(&customerID)
+	if err == nil {
+		return customerID, nil
+	}
+	if !errors.Is(err, pgx.ErrNoRows) {
+		return "", err
+	}
+
+	var email, orgName string
+	err = h.db.QueryRow(ctx, `
+		SELECT COALESCE((SELECT email FROM users WHERE user_id = $1), ''), name
+		FROM organizations WHERE id = $2
+	`, userID, orgID).Scan(&email, &orgName)
+	if err != nil {
+		return "", err
+	}
+
+	customer, err := h.provider.CreateCustomer(ctx, billing.CustomerParams{Email: email, Name: orgName, OrganizationID: orgID})
+	if err != nil {
+		return "", err
+	}
+
+	err = h.db.Exec(ctx, `
+		INSERT INTO organization_billing (organization_id, provider, customer_id)
+		VALUES ($1, $2, $3)
+		ON CONFLICT (organization_id) DO UPDATE SET
+			provider = EXCLUDED.provider,
+			customer_id = EXCLUDED.customer_id,
+			updated_at = NOW()
+	`, orgID, h.provider.Name(), customer.ID)
+	if err != nil {
+		return "", err
+	}
+	return customer.ID, nil
+}
+
+func isAbsoluteURL(raw string) bool {
+	u, err := url.Parse(raw)
+	return err == nil && (u.Scheme == "https" || u.Scheme == "http") && u.Host != ""
+}
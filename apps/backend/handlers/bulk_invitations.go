@@ -0,0 +1,259 @@
+package handlers
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"strings"
+
+	"go-backend/models"
+	"go-backend/quota"
+
+	"github.com/gorilla/mux"
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	maxBulkInvitations = 500
+	maxBulkInviteBytes = 1 << 20
+)
+
+// POST /api/v1/users/{userId}/organizations/{orgId}/invitations/bulk
+//
+// Takes either JSON ({"invitations": [...]} with defaults for rows that
+// leave fields out) or a CSV file, as the body with Content-Type text/csv or
+// as the "file" field of a multipart form. CSV files may start with a header
+// naming email, role, project_access_type, specific_projects (separated by
+// semicolons), project_role and message columns; without one the columns are
+// email and role. Defaults for CSV uploads come from the query string or
+// form fields of the same names. Every row is tried and reported on its own.
+func (h *OrganizationHandler) BulkInvite(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	userID := vars["userId"]
+	orgID := vars["orgId"]
+
+	r.Body = http.MaxBytesReader(w, r.Body, maxBulkInviteBytes)
+
+	req, firstRow, err := parseBulkInvite(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if len(req.Invitations) == 0 {
+		http.Error(w, "No invitations given", http.StatusBadRequest)
+		return
+	}
+	if len(req.Invitations) > maxBulkInvitations {
+		http.Error(w, fmt.Sprintf("At most %d invitations can be sent at once", maxBulkInvitations), http.StatusBadRequest)
+		return
+	}
+
+	ctx := context.Background()
+
+	results := make([]models.BulkInviteResult, 0, len(req.Invitations))
+	seen := make(map[string]bool)
+	var invited, skipped, failed int
+	for i := range req.Invitations {
+		row := &req.Invitations[i]
+		applyBulkDefaults(row, req)
+
+		result := models.BulkInviteResult{Row: firstRow + i, Email: strings.TrimSpace(row.Email)}
+		key := strings.ToLower(result.Email)
+		if seen[key] {
+			result.Status = "skipped"
+			result.Error = "Listed more than once"
+			skipped++
+			results = append(results, result)
+			continue
+		}
+		seen[key] = true
+
+		invitation, err := h.invite(ctx, r, userID, orgID, row)
+		var me *memberError
+		var exceeded *quota.ExceededError
+		switch {
+		case err == nil:
+			result.Status = "invited"
+			result.InvitationID = invitation.ID
+			invited++
+		case errors.Is(err, errAlreadyInvited):
+			result.Status = "skipped"
+			result.Error = errAlreadyInvited.message
+			skipped++
+		case errors.As(err, &me):
+			result.Status = "error"
+			result.Error = me.message
+			failed++
+		case errors.As(err, &exceeded):
+			result.Status = "error"
+			result.Error = "Member limit reached: " + exceeded.Error()
+			failed++
+		default:
+			log.Error().Err(err).Str("organization_id", orgID).Int("row", result.Row).Msg("Failed to create invitation")
+			result.Status = "error"
+			result.Error = "Failed to create invitation"
+			failed++
+		}
+		results = append(results, result)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"data": results,
+		"summary": map[string]int{
+			"invited": invited,
+			"skipped": skipped,
+			"failed":  failed,
+		},
+	})
+}
+
+// applyBulkDefaults fills the fields a row leaves out from the request.
+func applyBulkDefaults(row *models.InviteToOrganizationRequest, req *models.BulkInviteRequest) {
+	if row.Role == "" {
+		row.Role = req.Role
+	}
+	if row.Role == "" {
+		row.Role = "member"
+	}
+	if row.Message == nil {
+		row.Message = req.Message
+	}
+	if row.ProjectAccessType == nil {
+		row.ProjectAccessType = req.ProjectAccessType
+		if len(row.SpecificProjects) == 0 {
+			row.SpecificProjects = req.SpecificProjects
+		}
+	}
+	if row.ProjectRole == nil {
+		row.ProjectRole = req.ProjectRole
+	}
+}
+
+// parseBulkInvite reads the request's rows and defaults, and returns the row
+// number of the first invitation.
+func parseBulkInvite(r *http.Request) (*models.BulkInviteRequest, int, error) {
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	switch mediaType {
+	case "text/csv":
+		req := bulkDefaultsFrom(r.URL.Query().Get)
+		first, err := readInviteCSV(r.Body, req)
+		return req, first, err
+	case "multipart/form-data":
+		file, _, err := r.FormFile("file")
+		if err != nil {
+			return nil, 0, errors.New("A CSV file is required in the file field")
+		}
+		defer file.Close()
+		req := bulkDefaultsFrom(r.FormValue)
+		first, err := readInviteCSV(file, req)
+		return req, first, err
+	default:
+		var req models.BulkInviteRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			return nil, 0, errors.New("Invalid request body")
+		}
+		return &req, 1, nil
+	}
+}
+
+func bulkDefaultsFrom(get func(string) string) *models.BulkInviteRequest {
+	req := &models.BulkInviteRequest{Role: get("role")}
+	if v := get("message"); v != "" {
+		req.Message = &v
+	}
+	if v := get("project_access_type"); v != "" {
+		req.ProjectAccessType = &v
+	}
+	req.SpecificProjects = splitList(get("specific_projects"))
+	if v := get("project_role"); v != "" {
+		req.ProjectRole = &v
+	}
+	return req
+}
+
+var inviteCSVColumns = []string{"email", "role", "project_access_type", "specific_projects", "project_role", "message"}
+
+// readInviteCSV appends the file's rows to req.Invitations and returns the
+// row number of the first.
+func readInviteCSV(body io.Reader, req *models.BulkInviteRequest) (int, error) {
+	reader := csv.NewReader(body)
+	reader.FieldsPerRecord = -1
+	reader.TrimLeadingSpace = true
+
+	records, err := reader.ReadAll()
+	if err != nil {
+		return 0, fmt.Errorf("Invalid CSV: %v", err)
+	}
+	if len(records) == 0 {
+		return 0, nil
+	}
+
+	columns := map[string]int{"email": 0, "role": 1}
+	first := 1
+	if isInviteCSVHeader(records[0]) {
+		columns = make(map[string]int)
+		for i, name := range records[0] {
+			columns[strings.ToLower(strings.TrimSpace(name))] = i
+		}
+		if _, ok := columns["email"]; !ok {
+			return 0, errors.New("The CSV header has no email column")
+		}
+		records = records[1:]
+		first = 2
+	}
+
+	for _, record := range records {
+		field := func(name string) string {
+			i, ok := columns[name]
+			if !ok || i >= len(record) {
+				return ""
+			}
+			return strings.TrimSpace(record[i])
+		}
+		row := models.InviteToOrganizationRequest{
+			Email:            field("email"),
+			Role:             field("role"),
+			SpecificProjects: splitList(field("specific_projects")),
+		}
+		if v := field("project_access_type"); v != "" {
+			row.ProjectAccessType = &v
+		}
+		if v := field("project_role"); v != "" {
+			row.ProjectRole = &v
+		}
+		if v := field("message"); v != "" {
+			row.Message = &v
+		}
+		req.Invitations = append(req.Invitations, row)
+	}
+	return first, nil
+}
+
+func isInviteCSVHeader(record []string) bool {
+	for _, name := range record {
+		name = strings.ToLower(strings.TrimSpace(name))
+		for _, column := range inviteCSVColumns {
+			if name == column {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// splitList splits a list separated by semicolons or commas.
+func splitList(s string) []string {
+	var items []string
+	for _, item := range strings.FieldsFunc(s, func(r rune) bool { return r == ';' || r == ',' }) {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
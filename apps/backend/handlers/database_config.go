@@ -9,9 +9,11 @@ import (
 	"sync"
 	"time"
 
+	"go-backend/audit"
 	"go-backend/auth"
 	"go-backend/database"
 	"go-backend/middleware"
+	"go-backend/quota"
 	"github.com/gorilla/mux"
 	"github.com/jackc/pgx/v5"
 	"github.com/jackc/pgx/v5/pgxpool"
@@ -24,6 +26,9 @@ type DatabaseConfigHandler struct {
 	encryption    *auth.ConfigEncryption
 	userDBPools   map[string]*pgxpool.Pool
 	userSSHTunnels map[string]*database.SSHTunnel
+	userPoolMeteredAt map[string]time.Time
+	quotas        *quota.Service
+	audit         *audit.Logger
 	mu            sync.RWMutex
 }
 
@@ -46,7 +51,7 @@ type WireguardConfig struct {
 	InternalDBURL string `json:"internal_db_url"`
 }
 
-func NewDatabaseConfigHandler(db *database.PostgresDB, redis *database.RedisClient) *DatabaseConfigHandler {
+func NewDatabaseConfigHandler(db *database.PostgresDB, redis *database.RedisClient, quotas *quota.Service, auditLog *audit.Logger) *DatabaseConfigHandler {
 	// Initialize encryption service
 	encryption, err := auth.NewConfigEncryption()
 	if err != nil {
@@ -59,6 +64,9 @@ func NewDatabaseConfigHandler(db *database.PostgresDB, redis *database.RedisClie
 		encryption:    encryption,
 		userDBPools:   make(map[string]*pgxpool.Pool),
 		userSSHTunnels: make(map[string]*database.SSHTunnel),
+		userPoolMeteredAt: make(map[string]time.Time),
+		quotas:        quotas,
+		audit:         auditLog,
 	}
 }
 
@@ -66,12 +74,6 @@ func (h *DatabaseConfigHandler) CreateDatabaseConfig(w http.ResponseWriter, r *h
 	vars := mux.Vars(r)
 	userID := vars["user_id"]
 
-	claims := middleware.GetUserClaims(r.Context())
-	if claims == nil || (claims.UserID != userID && claims.Role != "admin") {
-		middleware.WriteErrorResponse(w, http.StatusForbidden, fmt.Errorf("access denied"), "You can only configure your own database")
-		return
-	}
-
 	var config DatabaseConfig
 	if err := json.NewDecoder(r.Body).Decode(&config); err != nil {
 		middleware.WriteErrorResponse(w, http.StatusBadRequest, err, "Invalid request body")
@@ -108,6 +110,26 @@ func (h *DatabaseConfigHandler) CreateDatabaseConfig(w http.ResponseWriter, r *h
 	ctx, cancel := context.WithTimeout(r.Context(), 30*time.Second)
 	defer cancel()
 
+	// Replacing an existing configuration of the same type doesn't add a connection
+	exists, err := h.hasActiveConfig(ctx, userID, config.ConnectionType)
+	if err != nil {
+		middleware.WriteErrorResponse(w, http.StatusInternalServerError, err, "Failed to check existing configuration")
+		return
+	}
+	if !exists {
+		subject, err := h.quotas.Resolve(ctx, userID, r.URL.Query().Get("organization_id"))
+		if err != nil {
+			middleware.WriteErrorResponse(w, http.StatusForbidden, err, "Organization not found or access denied")
+			return
+		}
+		if _, err := h.quotas.Check(ctx, subject, quota.DBConnections, 1); err != nil {
+			if !quota.WriteError(w, err) {
+				middleware.WriteErrorResponse(w, http.StatusInternalServerError, err, "Failed to check connection quota")
+			}
+			return
+		}
+	}
+
 	// Start transaction to update multiple tables atomically
 	pool := h.db.GetPool()
 	tx, err := pool.Begin(ctx)
@@ -118,6 +140,7 @@ func (h *DatabaseConfigHandler) CreateDatabaseConfig(w http.ResponseWriter, r *h
 	defer tx.Rollback(ctx)
 
 	// Ensure user exists in backend users table
+	claims := middleware.GetUserClaims(r.Context())
 	err = h.ensureUserExists(ctx, tx, userID, claims.Email)
 	if err != nil {
 		middleware.WriteErrorResponse(w, http.StatusInternalServerError, err, "Failed to create user record")
@@ -157,6 +180,11 @@ func (h *DatabaseConfigHandler) CreateDatabaseConfig(w http.ResponseWriter, r *h
 	// Close existing connection to force refresh
 	h.closeExistingUserConnection(userID)
 
+	h.recordConnectionEvent(r, userID, audit.ActionConnectionCreated, map[string]interface{}{
+		"connection_type": config.ConnectionType,
+		"replaced":        exists,
+	})
+
 	response := map[string]interface{}{
 		"message": "Database configuration saved successfully",
 		"config": map[string]interface{}{
@@ -196,6 +224,28 @@ func (h *DatabaseConfigHandler) ensureUserExists(ctx context.Context, tx pgx.Tx,
 	return nil
 }
 
+// hasActiveConfig reports whether the user already has an active
+// configuration of the given connection type
+func (h *DatabaseConfigHandler) hasActiveConfig(ctx context.Context, userID, connectionType string) (bool, error) {
+	var table string
+	switch connectionType {
+	case "postgresql":
+		table = "database_configs"
+	case "ssh":
+		table = "ssh_configs"
+	case "wireguard":
+		table = "wireguard_configs"
+	default:
+		return false, fmt.Errorf("unknown connection type: %s", connectionType)
+	}
+
+	var exists bool
+	err := h.db.QueryRow(ctx, fmt.Sprintf(`
+		SELECT EXISTS(SELECT 1 FROM %s WHERE user_id = $1 AND is_active = true)`, table),
+		userID).Scan(&exists)
+	return exists, err
+}
+
 // saveDatabaseConfig saves PostgreSQL direct connection configuration
 func (h *DatabaseConfigHandler) saveDatabaseConfig(ctx context.Context, tx pgx.Tx, userID string, config *DatabaseConfig) error {
 	// Encrypt sensitive database URL
@@ -335,12 +385,6 @@ func (h *DatabaseConfigHandler) TestDatabaseConnection(w http.ResponseWriter, r
 	vars := mux.Vars(r)
 	userID := vars["user_id"]
 
-	claims := middleware.GetUserClaims(r.Context())
-	if claims == nil || (claims.UserID != userID && claims.Role != "admin") {
-		middleware.WriteErrorResponse(w, http.StatusForbidden, fmt.Errorf("access denied"), "You can only test your own database connection")
-		return
-	}
-
 	ctx, cancel := context.WithTimeout(r.Context(), 30*time.Second)
 	defer cancel()
 
@@ -352,6 +396,7 @@ func (h *DatabaseConfigHandler) TestDatabaseConnection(w http.ResponseWriter, r
 
 	pool, sshTunnel, err := h.createUserConnection(ctx, userID, config)
 	if err != nil {
+		h.recordConnectionTest(r, userID, config.ConnectionType, "", err)
 		middleware.WriteErrorResponse(w, http.StatusBadRequest, err, "Failed to connect to database")
 		return
 	}
@@ -365,7 +410,9 @@ func (h *DatabaseConfigHandler) TestDatabaseConnection(w http.ResponseWriter, r
 		}
 	}()
 
-	if err := pool.Ping(ctx); err != nil {
+	err = pool.Ping(ctx)
+	h.recordConnectionTest(r, userID, config.ConnectionType, "", err)
+	if err != nil {
 		middleware.WriteErrorResponse(w, http.StatusBadRequest, err, "Database connection test failed")
 		return
 	}
@@ -384,12 +431,6 @@ func (h *DatabaseConfigHandler) TestDatabaseURL(w http.ResponseWriter, r *http.R
 	vars := mux.Vars(r)
 	userID := vars["user_id"]
 
-	claims := middleware.GetUserClaims(r.Context())
-	if claims == nil || (claims.UserID != userID && claims.Role != "admin") {
-		middleware.WriteErrorResponse(w, http.StatusForbidden, fmt.Errorf("access denied"), "You can only test your own database connection")
-		return
-	}
-
 	var config DatabaseConfig
 	if err := json.NewDecoder(r.Body).Decode(&config); err != nil {
 		middleware.WriteErrorResponse(w, http.StatusBadRequest, err, "Invalid request body")
@@ -439,6 +480,7 @@ func (h *DatabaseConfigHandler) TestDatabaseURL(w http.ResponseWriter, r *http.R
 			Str("user_id", userID).
 			Str("database_url", maskPassword(config.DatabaseURL)).
 			Msg("Database connection test failed")
+		h.recordConnectionTest(r, userID, config.ConnectionType, maskPassword(config.DatabaseURL), err)
 		middleware.WriteErrorResponse(w, http.StatusBadRequest, err, "Failed to connect to database")
 		return
 	}
@@ -452,7 +494,9 @@ func (h *DatabaseConfigHandler) TestDatabaseURL(w http.ResponseWriter, r *http.R
 		}
 	}()
 
-	if err := pool.Ping(ctx); err != nil {
+	err = pool.Ping(ctx)
+	h.recordConnectionTest(r, userID, config.ConnectionType, maskPassword(config.DatabaseURL), err)
+	if err != nil {
 		log.Warn().
 			Err(err).
 			Str("user_id", userID).
@@ -477,6 +521,33 @@ func (h *DatabaseConfigHandler) TestDatabaseURL(w http.ResponseWriter, r *http.R
 	middleware.WriteJSONResponse(w, http.StatusOK, response)
 }
 
+// recordConnectionEvent audits a change to userID's saved connection.
+// Connections belong to users, so the entries are outside any organization.
+func (h *DatabaseConfigHandler) recordConnectionEvent(r *http.Request, userID, action string, metadata map[string]interface{}) {
+	h.audit.Record(r, audit.Event{
+		Action:     action,
+		TargetType: audit.TargetConnection,
+		TargetID:   userID,
+		Metadata:   metadata,
+	})
+}
+
+// recordConnectionTest audits a connection test and its outcome. databaseURL
+// is set, with the password masked, when the tested URL was not saved.
+func (h *DatabaseConfigHandler) recordConnectionTest(r *http.Request, userID, connectionType, databaseURL string, err error) {
+	metadata := map[string]interface{}{
+		"connection_type": connectionType,
+		"success":         err == nil,
+	}
+	if databaseURL != "" {
+		metadata["database_url"] = databaseURL
+	}
+	if err != nil {
+		metadata["error"] = err.Error()
+	}
+	h.recordConnectionEvent(r, userID, audit.ActionConnectionTested, metadata)
+}
+
 // maskPassword masks the password in database URL for logging
 func maskPassword(url string) string {
 	if strings.Contains(url, "@") {
@@ -496,12 +567,6 @@ func (h *DatabaseConfigHandler) DeleteDatabaseConfig(w http.ResponseWriter, r *h
 	vars := mux.Vars(r)
 	userID := vars["user_id"]
 
-	claims := middleware.GetUserClaims(r.Context())
-	if claims == nil || (claims.UserID != userID && claims.Role != "admin") {
-		middleware.WriteErrorResponse(w, http.StatusForbidden, fmt.Errorf("access denied"), "You can only delete your own database configuration")
-		return
-	}
-
 	ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
 	defer cancel()
 
@@ -515,6 +580,8 @@ func (h *DatabaseConfigHandler) DeleteDatabaseConfig(w http.ResponseWriter, r *h
 
 	h.closeExistingUserConnection(userID)
 
+	h.recordConnectionEvent(r, userID, audit.ActionConnectionDeleted, nil)
+
 	response := map[string]interface{}{
 		"message": "Database configuration deleted successfully",
 	}
@@ -545,6 +612,7 @@ func (h *DatabaseConfigHandler) GetUserDatabaseConnection(userID string) (*pgxpo
 
 	h.mu.Lock()
 	h.userDBPools[userID] = pool
+	h.userPoolMeteredAt[userID] = time.Now()
 	if sshTunnel != nil {
 		h.userSSHTunnels[userID] = sshTunnel
 	}
@@ -801,7 +869,8 @@ func (h *DatabaseConfigHandler) createUserConnection(ctx context.Context, userID
 
 func (h *DatabaseConfigHandler) closeExistingUserConnection(userID string) {
 	h.mu.Lock()
-	defer h.mu.Unlock()
+	seconds := h.takeConnectionSeconds(userID, time.Now())
+	delete(h.userPoolMeteredAt, userID)
 
 	if pool, exists := h.userDBPools[userID]; exists {
 		pool.Close()
@@ -812,13 +881,19 @@ func (h *DatabaseConfigHandler) closeExistingUserConnection(userID string) {
 		tunnel.Close()
 		delete(h.userSSHTunnels, userID)
 	}
+	h.mu.Unlock()
+
+	h.meterConnectionSeconds(userID, seconds)
 }
 
 func (h *DatabaseConfigHandler) CleanupUserConnections() {
 	h.mu.Lock()
-	defer h.mu.Unlock()
+	now := time.Now()
+	open := make(map[string]int64, len(h.userDBPools))
 
 	for userID, pool := range h.userDBPools {
+		open[userID] = h.takeConnectionSeconds(userID, now)
+		delete(h.userPoolMeteredAt, userID)
 		pool.Close()
 		delete(h.userDBPools, userID)
 	}
@@ -827,4 +902,79 @@ func (h *DatabaseConfigHandler) CleanupUserConnections() {
 		tunnel.Close()
 		delete(h.userSSHTunnels, userID)
 	}
-} 
\ No newline at end of file
+	h.mu.Unlock()
+
+	for userID, seconds := range open {
+		h.meterConnectionSeconds(userID, seconds)
+	}
+}
+
+// MeterOpenConnections bills the time every open pool has been connected
+// since it was last metered, so long sessions are charged to the cycle they
+// run in rather than the one they end in.
+func (h *DatabaseConfigHandler) MeterOpenConnections() {
+	h.mu.Lock()
+	now := time.Now()
+	open := make(map[string]int64, len(h.userDBPools))
+	for userID := range h.userDBPools {
+		open[userID] = h.takeConnectionSeconds(userID, now)
+	}
+	h.mu.Unlock()
+
+	for userID, seconds := range open {
+		h.meterConnectionSeconds(userID, seconds)
+	}
+}
+
+// StartConnectionMetering calls MeterOpenConnections every interval until
+// the returned stop function is called.
+func (h *DatabaseConfigHandler) StartConnectionMetering(interval time.Duration) (stop func()) {
+	ticker := time.NewTicker(interval)
+	done := make(chan struct{})
+
+	go func() {
+		for {
+			select {
+			case <-ticker.C:
+				h.MeterOpenConnections()
+			case <-done:
+				ticker.Stop()
+				return
+			}
+		}
+	}()
+
+	var once sync.Once
+	return func() { once.Do(func() { close(done) }) }
+}
+
+// takeConnectionSeconds returns the whole seconds the user's pool has been
+// open since it was last metered and moves the mark forward by that much.
+// Callers hold h.mu.
+func (h *DatabaseConfigHandler) takeConnectionSeconds(userID string, now time.Time) int64 {
+	since, ok := h.userPoolMeteredAt[userID]
+	if !ok {
+		return 0
+	}
+	seconds := int64(now.Sub(since) / time.Second)
+	h.userPoolMeteredAt[userID] = since.Add(time.Duration(seconds) * time.Second)
+	return seconds
+}
+
+func (h *DatabaseConfigHandler) meterConnectionSeconds(userID string, seconds int64) {
+	if seconds <= 0 || h.quotas == nil {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	subject, err := h.quotas.Resolve(ctx, userID, "")
+	if err != nil {
+		log.Error().Err(err).Str("user_id", userID).Msg("Failed to resolve billing subject")
+		return
+	}
+	if err := h.quotas.Record(ctx, subject, quota.MeterConnectionSeconds, seconds, nil); err != nil {
+		log.Error().Err(err).Str("user_id", userID).Msg("Failed to meter connection time")
+	}
+}
@@ -0,0 +1,60 @@
+package handlers
+
+import (
+	"context"
+	"net/url"
+	"strings"
+	"time"
+
+	"go-backend/database"
+	"go-backend/mail"
+)
+
+// queueInvitationEmail renders the email for a pending invitation and puts it
+// in the outbox.
+func queueInvitationEmail(ctx context.Context, db *database.PostgresDB, outbox *mail.Outbox, appURL, invitationID string) error {
+	var orgID, email, role, token string
+	var orgName, inviterEmail, message *string
+	var expiresAt time.Time
+	err := db.QueryRow(ctx, `
+		SELECT oi.organization_id, oi.email, oi.role, oi.token, oi.expires_at, oi.message, o.name, u.email
+		FROM organization_invitations oi
+		JOIN organizations o ON o.id = oi.organization_id
+		LEFT JOIN users u ON u.user_id = oi.invited_by
+		WHERE oi.id = $1 AND oi.status = 'pending'
+	`, invitationID).Scan(&orgID, &email, &role, &token, &expiresAt, &message, &orgName, &inviterEmail)
+	if err != nil {
+		return err
+	}
+
+	data := mail.InvitationData{
+		Role:      role,
+		AcceptURL: strings.TrimRight(appURL, "/") + "/invite/" + url.PathEscape(token),
+		ExpiresAt: expiresAt,
+	}
+	if orgName != nil {
+		data.OrganizationName = *orgName
+	}
+	if inviterEmail != nil {
+		data.InviterEmail = *inviterEmail
+	}
+	if message != nil {
+		data.Message = *message
+	}
+
+	msg, err := mail.RenderInvitation(email, data)
+	if err != nil {
+		return err
+	}
+	// A resend replaces any earlier email still waiting to go out
+	if err := outbox.Withdraw(ctx, invitationID); err != nil {
+		return err
+	}
+	_, err = outbox.Enqueue(ctx, mail.Email{
+		Kind:           mail.KindInvitation,
+		OrganizationID: orgID,
+		InvitationID:   invitationID,
+		Message:        msg,
+	})
+	return err
+}
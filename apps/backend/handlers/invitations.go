@@ -3,23 +3,37 @@ package handlers
 import (
 	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
 	"net/http"
+	"strings"
 	"time"
 
+	"go-backend/audit"
+	"go-backend/authz"
 	"go-backend/database"
+	"go-backend/mail"
+	"go-backend/middleware"
 	"go-backend/models"
+	"go-backend/quota"
 
 	"github.com/google/uuid"
 	"github.com/gorilla/mux"
+	"github.com/jackc/pgx/v5"
 	"github.com/rs/zerolog/log"
 )
 
 type InvitationHandler struct {
-	db *database.PostgresDB
+	db     *database.PostgresDB
+	quotas *quota.Service
+	audit  *audit.Logger
+	outbox *mail.Outbox
+	appURL string
 }
 
-func NewInvitationHandler(db *database.PostgresDB) *InvitationHandler {
-	return &InvitationHandler{db: db}
+func NewInvitationHandler(db *database.PostgresDB, quotas *quota.Service, auditLog *audit.Logger, outbox *mail.Outbox, appURL string) *InvitationHandler {
+	return &InvitationHandler{db: db, quotas: quotas, audit: auditLog, outbox: outbox, appURL: appURL}
 }
 
 // GET /api/v1/users/{userId}/organizations/{orgId}/invitations
@@ -35,27 +49,22 @@ func (h *InvitationHandler) GetOrganizationInvitations(w http.ResponseWriter, r
 
 	ctx := context.Background()
 
-	// Check if user is admin/owner of organization
-	var role string
-	err := h.db.QueryRow(ctx, `
-		SELECT role FROM organization_members 
-		WHERE organization_id = $1 AND user_id = $2 AND status = 'active'
-	`, orgID, userID).Scan(&role)
-
-	if err != nil || (role != "owner" && role != "admin") {
-		http.Error(w, "Insufficient permissions", http.StatusForbidden)
-		return
-	}
-
 	query := `
 		SELECT oi.id, oi.organization_id, oi.email, oi.role, oi.status, oi.invited_by, 
 			oi.invited_at, oi.expires_at, oi.token, oi.project_access_type, 
-			oi.specific_projects, oi.message,
+			oi.specific_projects, oi.message, oi.project_role,
 			u.email as inviter_email, u.user_id as inviter_user_id,
-			o.name as org_name, o.slug as org_slug
+			o.name as org_name, o.slug as org_slug,
+			eo.status, eo.attempts, eo.last_error, eo.sent_at, eo.updated_at
 		FROM organization_invitations oi
 		LEFT JOIN users u ON oi.invited_by = u.user_id
 		LEFT JOIN organizations o ON oi.organization_id = o.id
+		LEFT JOIN LATERAL (
+			SELECT status, attempts, last_error, sent_at, updated_at FROM email_outbox
+			WHERE invitation_id = oi.id
+			ORDER BY created_at DESC
+			LIMIT 1
+		) eo ON TRUE
 		WHERE oi.organization_id = $1
 		ORDER BY oi.invited_at DESC
 	`
@@ -72,14 +81,19 @@ func (h *InvitationHandler) GetOrganizationInvitations(w http.ResponseWriter, r
 	for rows.Next() {
 		var inv models.OrganizationInvitationWithDetails
 		var inviterEmail, inviterUserID, orgName, orgSlug *string
+		var delivery models.InvitationDelivery
+		var deliveryStatus *string
+		var deliveryAttempts *int
+		var deliveryUpdatedAt *time.Time
 		
 		inv.OrganizationInvitation = &models.OrganizationInvitation{}
 		
 		err := rows.Scan(
 			&inv.ID, &inv.OrganizationID, &inv.Email, &inv.Role, &inv.Status,
 			&inv.InvitedBy, &inv.InvitedAt, &inv.ExpiresAt, &inv.Token,
-			&inv.ProjectAccessType, &inv.SpecificProjects, &inv.Message,
+			&inv.ProjectAccessType, &inv.SpecificProjects, &inv.Message, &inv.ProjectRole,
 			&inviterEmail, &inviterUserID, &orgName, &orgSlug,
+			&deliveryStatus, &deliveryAttempts, &delivery.LastError, &delivery.SentAt, &deliveryUpdatedAt,
 		)
 		if err != nil {
 			log.Error().Err(err).Msg("Failed to scan invitation")
@@ -103,6 +117,14 @@ func (h *InvitationHandler) GetOrganizationInvitations(w http.ResponseWriter, r
 			}
 		}
 
+		// Add the latest email's delivery state
+		if deliveryStatus != nil {
+			delivery.Status = *deliveryStatus
+			delivery.Attempts = *deliveryAttempts
+			delivery.UpdatedAt = *deliveryUpdatedAt
+			inv.Delivery = &delivery
+		}
+
 		invitations = append(invitations, inv)
 	}
 
@@ -112,7 +134,19 @@ func (h *InvitationHandler) GetOrganizationInvitations(w http.ResponseWriter, r
 	})
 }
 
+// errInvitationEmailMismatch is reported when the signed-in account is not
+// the one an invitation was sent to.
+var errInvitationEmailMismatch = errors.New("invitation_email_mismatch")
+
+// errInvitationEmailNotVerified is reported when the signed-in account has
+// not verified its email address.
+var errInvitationEmailNotVerified = errors.New("invitation_email_not_verified")
+
 // POST /api/v1/invitations/{token}/accept
+//
+// The signed-in user accepts an invitation sent to their email address.
+// Platform admins may accept one sent to another address with
+// {"override_email": true}.
 func (h *InvitationHandler) AcceptInvitation(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	token := vars["token"]
@@ -122,25 +156,33 @@ func (h *InvitationHandler) AcceptInvitation(w http.ResponseWriter, r *http.Requ
 		return
 	}
 
+	// The body is optional
 	var req models.AcceptInvitationRequest
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil && !errors.Is(err, io.EOF) {
 		http.Error(w, "Invalid request body", http.StatusBadRequest)
 		return
 	}
 
+	grant := authz.FromContext(r.Context())
+	userID := grant.Principal.UserID
+	if req.OverrideEmail && !grant.Principal.PlatformAdmin {
+		http.Error(w, "Only platform admins can override the invitation email", http.StatusForbidden)
+		return
+	}
+
 	ctx := context.Background()
 
 	// Find the invitation
 	var inv models.OrganizationInvitation
 	err := h.db.QueryRow(ctx, `
 		SELECT id, organization_id, email, role, status, invited_by, invited_at, 
-			expires_at, token, project_access_type, specific_projects, message
+			expires_at, token, project_access_type, specific_projects, message, project_role
 		FROM organization_invitations
 		WHERE token = $1 AND status = 'pending'
 	`, token).Scan(
 		&inv.ID, &inv.OrganizationID, &inv.Email, &inv.Role, &inv.Status,
 		&inv.InvitedBy, &inv.InvitedAt, &inv.ExpiresAt, &inv.Token,
-		&inv.ProjectAccessType, &inv.SpecificProjects, &inv.Message,
+		&inv.ProjectAccessType, &inv.SpecificProjects, &inv.Message, &inv.ProjectRole,
 	)
 
 	if err != nil {
@@ -154,23 +196,35 @@ func (h *InvitationHandler) AcceptInvitation(w http.ResponseWriter, r *http.Requ
 		return
 	}
 
-	// Get user ID from the request context (set by auth middleware)
-	userID := r.Header.Get("X-User-ID")
-	if userID == "" {
-		http.Error(w, "Authentication required", http.StatusUnauthorized)
-		return
+	// Only the session's or token's verified email proves the invitation
+	// reached the caller
+	var email string
+	verified := false
+	if claims := middleware.GetUserClaims(r.Context()); claims != nil {
+		email = strings.TrimSpace(claims.Email)
+		verified = claims.EmailVerified
 	}
-
-	// Check if user is already a member
-	var memberCount int
-	h.db.QueryRow(ctx, `
-		SELECT COUNT(*) FROM organization_members 
-		WHERE organization_id = $1 AND user_id = $2
-	`, inv.OrganizationID, userID).Scan(&memberCount)
-
-	if memberCount > 0 {
-		http.Error(w, "User is already a member of this organization", http.StatusConflict)
-		return
+	overridden := false
+	if !verified || !strings.EqualFold(email, strings.TrimSpace(inv.Email)) {
+		if !req.OverrideEmail {
+			if email != "" && !verified {
+				middleware.WriteErrorResponse(w, http.StatusForbidden, errInvitationEmailNotVerified,
+					fmt.Sprintf("Verify %s to accept invitations sent to it.", email))
+				return
+			}
+			signedInAs := email
+			if signedInAs == "" {
+				signedInAs = "an account without an email address"
+			}
+			middleware.WriteErrorResponse(w, http.StatusForbidden, errInvitationEmailMismatch,
+				fmt.Sprintf("This invitation was sent to %s, but you are signed in as %s. Sign in as %s to accept it.",
+					inv.Email, signedInAs, inv.Email))
+			return
+		}
+		overridden = true
+	}
+	if email == "" {
+		email = inv.Email
 	}
 
 	// Begin transaction
@@ -182,15 +236,71 @@ func (h *InvitationHandler) AcceptInvitation(w http.ResponseWriter, r *http.Requ
 	}
 	defer tx.Rollback(ctx)
 
+	// Serialize with other membership changes and with a second accept of
+	// the same invitation
+	var orgID string
+	err = tx.QueryRow(ctx, "SELECT id FROM organizations WHERE id = $1 FOR UPDATE", inv.OrganizationID).Scan(&orgID)
+	if err != nil {
+		http.Error(w, "Invalid or expired invitation", http.StatusNotFound)
+		return
+	}
+
+	var status string
+	err = tx.QueryRow(ctx, `
+		SELECT status FROM organization_members
+		WHERE organization_id = $1 AND (user_id = $2 OR LOWER(email) = LOWER($3))
+	`, inv.OrganizationID, userID, email).Scan(&status)
+	if err == nil {
+		if status == "suspended" {
+			http.Error(w, "Your membership of this organization is suspended", http.StatusConflict)
+		} else {
+			http.Error(w, "User is already a member of this organization", http.StatusConflict)
+		}
+		return
+	}
+	if !errors.Is(err, pgx.ErrNoRows) {
+		log.Error().Err(err).Msg("Failed to check existing membership")
+		http.Error(w, "Failed to accept invitation", http.StatusInternalServerError)
+		return
+	}
+
+	// The seat was held when the invitation was sent, but the plan may have
+	// been downgraded or members added by other routes since. Counted under
+	// the organization lock so concurrent accepts cannot both take the last
+	// seat.
+	if _, err := h.quotas.Check(ctx, quota.OrganizationSubject(inv.OrganizationID), quota.Members, 1); err != nil {
+		if !quota.WriteError(w, err) {
+			log.Error().Err(err).Msg("Failed to check member quota")
+			http.Error(w, "Failed to accept invitation", http.StatusInternalServerError)
+		}
+		return
+	}
+
+	// Sessions can belong to users the backend has not seen yet
+	_, err = tx.Exec(ctx, `
+		INSERT INTO users (user_id, email, role, created_at, updated_at)
+		VALUES ($1, $2, 'user', NOW(), NOW())
+		ON CONFLICT (user_id) DO NOTHING
+	`, userID, email)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to create user record")
+		http.Error(w, "Failed to accept invitation", http.StatusInternalServerError)
+		return
+	}
+
 	// Add user to organization
 	memberID := uuid.New().String()
 	now := time.Now()
+	projectAccess := "all"
+	if inv.ProjectAccessType != nil && *inv.ProjectAccessType == "specific" {
+		projectAccess = "specific"
+	}
 	_, err = tx.Exec(ctx, `
 		INSERT INTO organization_members 
-		(id, organization_id, user_id, email, role, status, joined_at, invited_at, invited_by)
-		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)
-	`, memberID, inv.OrganizationID, userID, inv.Email, inv.Role, "active", 
-		now, inv.InvitedAt, inv.InvitedBy)
+		(id, organization_id, user_id, email, role, status, joined_at, invited_at, invited_by, project_access)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)
+	`, memberID, inv.OrganizationID, userID, email, inv.Role, "active",
+		now, inv.InvitedAt, inv.InvitedBy, projectAccess)
 
 	if err != nil {
 		log.Error().Err(err).Msg("Failed to add user to organization")
@@ -198,11 +308,42 @@ func (h *InvitationHandler) AcceptInvitation(w http.ResponseWriter, r *http.Requ
 		return
 	}
 
+	// Grant the role on each project the invitation named
+	var granted []string
+	if projectAccess == "specific" && inv.SpecificProjects != nil {
+		var projectIDs []string
+		if err := json.Unmarshal([]byte(*inv.SpecificProjects), &projectIDs); err != nil {
+			log.Error().Err(err).Str("invitation_id", inv.ID).Msg("Invalid specific_projects on invitation")
+		}
+		projectRole := models.ProjectRoleViewer
+		if inv.ProjectRole != nil && *inv.ProjectRole != "" {
+			projectRole = *inv.ProjectRole
+		}
+		for _, projectID := range projectIDs {
+			// Projects deleted since the invitation was sent are skipped
+			tag, err := tx.Exec(ctx, `
+				INSERT INTO project_members (id, project_id, user_id, role, added_by, created_at, updated_at)
+				SELECT $1, id, $2, $3, $4, $5, $5 FROM projects
+				WHERE id = $6 AND organization_id = $7
+				ON CONFLICT (project_id, user_id) DO NOTHING
+			`, uuid.New().String(), userID, projectRole, inv.InvitedBy, now, projectID, inv.OrganizationID)
+
+			if err != nil {
+				log.Error().Err(err).Msg("Failed to grant project access")
+				http.Error(w, "Failed to accept invitation", http.StatusInternalServerError)
+				return
+			}
+			if tag.RowsAffected() > 0 {
+				granted = append(granted, projectID)
+			}
+		}
+	}
+
 	// Update invitation status
-	_, err = tx.Exec(ctx, `
+	tag, err := tx.Exec(ctx, `
 		UPDATE organization_invitations 
 		SET status = 'accepted' 
-		WHERE id = $1
+		WHERE id = $1 AND status = 'pending'
 	`, inv.ID)
 
 	if err != nil {
@@ -210,6 +351,10 @@ func (h *InvitationHandler) AcceptInvitation(w http.ResponseWriter, r *http.Requ
 		http.Error(w, "Failed to accept invitation", http.StatusInternalServerError)
 		return
 	}
+	if tag.RowsAffected() == 0 {
+		http.Error(w, "Invalid or expired invitation", http.StatusNotFound)
+		return
+	}
 
 	if err = tx.Commit(ctx); err != nil {
 		log.Error().Err(err).Msg("Failed to commit transaction")
@@ -217,10 +362,33 @@ func (h *InvitationHandler) AcceptInvitation(w http.ResponseWriter, r *http.Requ
 		return
 	}
 
+	metadata := map[string]interface{}{
+		"member_id":      memberID,
+		"role":           inv.Role,
+		"project_access": projectAccess,
+		"invited_by":     inv.InvitedBy,
+	}
+	if len(granted) > 0 {
+		metadata["projects"] = granted
+	}
+	if overridden {
+		metadata["email_override"] = true
+		metadata["invited_email"] = inv.Email
+	}
+	h.audit.Record(r, audit.Event{
+		OrganizationID: inv.OrganizationID,
+		Action:         audit.ActionInvitationAccepted,
+		TargetType:     audit.TargetInvitation,
+		TargetID:       inv.ID,
+		Metadata:       metadata,
+	})
+
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(map[string]interface{}{
-		"message": "Invitation accepted successfully",
+		"message":         "Invitation accepted successfully",
 		"organization_id": inv.OrganizationID,
+		"project_access":  projectAccess,
+		"projects":        granted,
 	})
 }
 
@@ -239,7 +407,7 @@ func (h *InvitationHandler) GetInvitationDetails(w http.ResponseWriter, r *http.
 	query := `
 		SELECT oi.id, oi.organization_id, oi.email, oi.role, oi.status, oi.invited_by, 
 			oi.invited_at, oi.expires_at, oi.token, oi.project_access_type, 
-			oi.specific_projects, oi.message,
+			oi.specific_projects, oi.message, oi.project_role,
 			u.email as inviter_email, u.user_id as inviter_user_id,
 			o.name as org_name, o.slug as org_slug
 		FROM organization_invitations oi
@@ -256,7 +424,7 @@ func (h *InvitationHandler) GetInvitationDetails(w http.ResponseWriter, r *http.
 	err := h.db.QueryRow(ctx, query, token).Scan(
 		&inv.ID, &inv.OrganizationID, &inv.Email, &inv.Role, &inv.Status,
 		&inv.InvitedBy, &inv.InvitedAt, &inv.ExpiresAt, &inv.Token,
-		&inv.ProjectAccessType, &inv.SpecificProjects, &inv.Message,
+		&inv.ProjectAccessType, &inv.SpecificProjects, &inv.Message, &inv.ProjectRole,
 		&inviterEmail, &inviterUserID, &orgName, &orgSlug,
 	)
 
@@ -325,31 +493,35 @@ func (h *InvitationHandler) CancelInvitation(w http.ResponseWriter, r *http.Requ
 
 	ctx := context.Background()
 
-	// Check if user is admin/owner of organization
-	var role string
-	err := h.db.QueryRow(ctx, `
-		SELECT role FROM organization_members 
-		WHERE organization_id = $1 AND user_id = $2 AND status = 'active'
-	`, orgID, userID).Scan(&role)
-
-	if err != nil || (role != "owner" && role != "admin") {
-		http.Error(w, "Insufficient permissions", http.StatusForbidden)
-		return
-	}
-
 	// Cancel the invitation
-	err = h.db.Exec(ctx, `
+	var email string
+	err := h.db.QueryRow(ctx, `
 		UPDATE organization_invitations 
 		SET status = 'cancelled' 
 		WHERE id = $1 AND organization_id = $2 AND status = 'pending'
-	`, invitationID, orgID)
+		RETURNING email
+	`, invitationID, orgID).Scan(&email)
 
-	if err != nil {
+	if err != nil && !errors.Is(err, pgx.ErrNoRows) {
 		log.Error().Err(err).Msg("Failed to cancel invitation")
 		http.Error(w, "Failed to cancel invitation", http.StatusInternalServerError)
 		return
 	}
 
+	if err == nil {
+		if err := h.outbox.Withdraw(ctx, invitationID); err != nil {
+			log.Error().Err(err).Str("invitation_id", invitationID).Msg("Failed to withdraw invitation email")
+		}
+
+		h.audit.Record(r, audit.Event{
+			OrganizationID: orgID,
+			Action:         audit.ActionInvitationCancelled,
+			TargetType:     audit.TargetInvitation,
+			TargetID:       invitationID,
+			Metadata:       map[string]interface{}{"email": email},
+		})
+	}
+
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(map[string]interface{}{
 		"message": "Invitation cancelled successfully",
@@ -370,34 +542,56 @@ func (h *InvitationHandler) ResendInvitation(w http.ResponseWriter, r *http.Requ
 
 	ctx := context.Background()
 
-	// Check if user is admin/owner of organization
-	var role string
+	// An expired invitation no longer holds a seat, so reviving it needs one.
+	var expired bool
 	err := h.db.QueryRow(ctx, `
-		SELECT role FROM organization_members 
-		WHERE organization_id = $1 AND user_id = $2 AND status = 'active'
-	`, orgID, userID).Scan(&role)
-
-	if err != nil || (role != "owner" && role != "admin") {
-		http.Error(w, "Insufficient permissions", http.StatusForbidden)
-		return
+		SELECT expires_at <= NOW() FROM organization_invitations
+		WHERE id = $1 AND organization_id = $2 AND status = 'pending'
+	`, invitationID, orgID).Scan(&expired)
+	if err == nil && expired {
+		if _, err := h.quotas.CheckInvitation(ctx, orgID); err != nil {
+			if !quota.WriteError(w, err) {
+				log.Error().Err(err).Msg("Failed to check member quota")
+				http.Error(w, "Failed to resend invitation", http.StatusInternalServerError)
+			}
+			return
+		}
 	}
 
 	// Update invitation timestamp and extend expiry
 	now := time.Now()
 	expiresAt := now.AddDate(0, 0, 7) // 7 days from now
 	
-	err = h.db.Exec(ctx, `
+	var email string
+	err = h.db.QueryRow(ctx, `
 		UPDATE organization_invitations 
 		SET invited_at = $1, expires_at = $2
 		WHERE id = $3 AND organization_id = $4 AND status = 'pending'
-	`, now, expiresAt, invitationID, orgID)
+		RETURNING email
+	`, now, expiresAt, invitationID, orgID).Scan(&email)
 
-	if err != nil {
+	if err != nil && !errors.Is(err, pgx.ErrNoRows) {
 		log.Error().Err(err).Msg("Failed to resend invitation")
 		http.Error(w, "Failed to resend invitation", http.StatusInternalServerError)
 		return
 	}
 
+	if err == nil {
+		if err := queueInvitationEmail(ctx, h.db, h.outbox, h.appURL, invitationID); err != nil {
+			log.Error().Err(err).Str("invitation_id", invitationID).Msg("Failed to queue invitation email")
+			http.Error(w, "Failed to resend invitation", http.StatusInternalServerError)
+			return
+		}
+
+		h.audit.Record(r, audit.Event{
+			OrganizationID: orgID,
+			Action:         audit.ActionInvitationResent,
+			TargetType:     audit.TargetInvitation,
+			TargetID:       invitationID,
+			Metadata:       map[string]interface{}{"email": email, "expires_at": expiresAt},
+		})
+	}
+
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(map[string]interface{}{
 		"message": "Invitation resent successfully",
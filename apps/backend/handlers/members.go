@@ -0,0 +1,692 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+
+	"go-backend/audit"
+	"go-backend/database"
+	"go-backend/models"
+	"go-backend/quota"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+	"github.com/jackc/pgx/v5"
+	"github.com/rs/zerolog/log"
+)
+
+// ownershipTransferTTL is how long a recipient has to accept an ownership
+// transfer before it lapses.
+const ownershipTransferTTL = 7 * 24 * time.Hour
+
+// MemberHandler manages the people in an organization. Every change runs in
+// a transaction that locks the organization row, so two concurrent requests
+// cannot both pass the "at least one owner" check and leave it ownerless.
+//
+// The rules:
+//   - owners and admins manage members; admins cannot touch owners
+//   - only owners grant the owner role
+//   - an organization always keeps at least one active owner
+//   - any member may leave, unless they are the last owner
+type MemberHandler struct {
+	db     *database.PostgresDB
+	quotas *quota.Service
+	audit  *audit.Logger
+}
+
+func NewMemberHandler(db *database.PostgresDB, quotas *quota.Service, auditLog *audit.Logger) *MemberHandler {
+	return &MemberHandler{db: db, quotas: quotas, audit: auditLog}
+}
+
+// memberError is a refused membership change and the status to report it
+// with.
+type memberError struct {
+	status  int
+	message string
+}
+
+func (e *memberError) Error() string {
+	return e.message
+}
+
+var (
+	errMemberNotFound   = &memberError{http.StatusNotFound, "Member not found"}
+	errNotPermitted     = &memberError{http.StatusForbidden, "Insufficient permissions"}
+	errOwnerOnly        = &memberError{http.StatusForbidden, "Only owners can manage owners"}
+	errLastOwner        = &memberError{http.StatusConflict, "An organization must keep at least one owner"}
+	errTransferNotFound = &memberError{http.StatusNotFound, "No pending ownership transfer"}
+
+	errServiceAccountOwner = &memberError{http.StatusBadRequest, "Service accounts cannot own an organization"}
+)
+
+// writeMemberError reports a memberError or quota refusal as-is and anything
+// else as a 500 with the given message.
+func writeMemberError(w http.ResponseWriter, err error, message string) {
+	if quota.WriteError(w, err) {
+		return
+	}
+	var me *memberError
+	if errors.As(err, &me) {
+		http.Error(w, me.message, me.status)
+		return
+	}
+	log.Error().Err(err).Msg(message)
+	http.Error(w, message, http.StatusInternalServerError)
+}
+
+// authorizeOrganizationUser returns the path's user and organization. The
+// policy has already checked the caller may act for that user.
+func authorizeOrganizationUser(w http.ResponseWriter, r *http.Request) (string, string, bool) {
+	vars := mux.Vars(r)
+	userID := vars["userId"]
+	orgID := vars["orgId"]
+
+	if userID == "" || orgID == "" {
+		http.Error(w, "User ID and Organization ID are required", http.StatusBadRequest)
+		return "", "", false
+	}
+
+	return userID, orgID, true
+}
+
+// lockOrganization serializes membership changes for one organization and
+// returns the caller's active role in it.
+func lockOrganization(ctx context.Context, tx pgx.Tx, orgID, userID string) (string, error) {
+	var id string
+	err := tx.QueryRow(ctx, "SELECT id FROM organizations WHERE id = $1 FOR UPDATE", orgID).Scan(&id)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return "", &memberError{http.StatusNotFound, "Organization not found or access denied"}
+	}
+	if err != nil {
+		return "", err
+	}
+
+	var role string
+	err = tx.QueryRow(ctx, `
+		SELECT role FROM organization_members
+		WHERE organization_id = $1 AND user_id = $2 AND status = 'active'
+	`, orgID, userID).Scan(&role)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return "", &memberError{http.StatusNotFound, "Organization not found or access denied"}
+	}
+	return role, err
+}
+
+const memberColumns = `id, organization_id, user_id, email, role, status, joined_at, invited_at, COALESCE(invited_by, ''), project_access,
+	EXISTS (SELECT 1 FROM service_accounts sa WHERE sa.user_id = organization_members.user_id)`
+
+func scanMember(row pgx.Row) (*models.OrganizationMember, error) {
+	var m models.OrganizationMember
+	err := row.Scan(&m.ID, &m.OrganizationID, &m.UserID, &m.Email, &m.Role, &m.Status,
+		&m.JoinedAt, &m.InvitedAt, &m.InvitedBy, &m.ProjectAccess, &m.ServiceAccount)
+	if err != nil {
+		return nil, err
+	}
+	return &m, nil
+}
+
+func getMember(ctx context.Context, tx pgx.Tx, orgID, memberID string) (*models.OrganizationMember, error) {
+	m, err := scanMember(tx.QueryRow(ctx, `
+		SELECT `+memberColumns+` FROM organization_members
+		WHERE organization_id = $1 AND id = $2
+	`, orgID, memberID))
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, errMemberNotFound
+	}
+	return m, err
+}
+
+// canManage reports whether a member with actorRole may change target.
+func canManage(actorRole string, target *models.OrganizationMember) error {
+	if actorRole != "owner" && actorRole != "admin" {
+		return errNotPermitted
+	}
+	if target.Role == "owner" && actorRole != "owner" {
+		return errOwnerOnly
+	}
+	return nil
+}
+
+// canChangeRole reports whether a member with actorRole may give target
+// role.
+func canChangeRole(actorRole string, target *models.OrganizationMember, role string) error {
+	if err := canManage(actorRole, target); err != nil {
+		return err
+	}
+	if role == "owner" && actorRole != "owner" {
+		return &memberError{http.StatusForbidden, "Only owners can grant the owner role"}
+	}
+	if role == "owner" && target.ServiceAccount {
+		return errServiceAccountOwner
+	}
+	return nil
+}
+
+// canReceiveOwnership reports whether userID may offer ownership to target.
+func canReceiveOwnership(userID string, target *models.OrganizationMember) error {
+	switch {
+	case target.UserID == userID:
+		return &memberError{http.StatusBadRequest, "You cannot transfer ownership to yourself"}
+	case target.Status != "active":
+		return &memberError{http.StatusBadRequest, "Ownership can only be transferred to an active member"}
+	case target.Role == "owner":
+		return &memberError{http.StatusBadRequest, "Member is already an owner"}
+	case target.ServiceAccount:
+		return errServiceAccountOwner
+	}
+	return nil
+}
+
+// keepsAnOwner refuses a change that takes target away from the owners when
+// it is the organization's last active one.
+func keepsAnOwner(ctx context.Context, tx pgx.Tx, target *models.OrganizationMember) error {
+	if target.Role != "owner" || target.Status != "active" {
+		return nil
+	}
+	var owners int
+	err := tx.QueryRow(ctx, `
+		SELECT COUNT(*) FROM organization_members
+		WHERE organization_id = $1 AND role = 'owner' AND status = 'active'
+	`, target.OrganizationID).Scan(&owners)
+	if err != nil {
+		return err
+	}
+	if owners <= 1 {
+		return errLastOwner
+	}
+	return nil
+}
+
+// cancelTransfers withdraws pending ownership transfers from or to a user
+// whose membership no longer allows them.
+func cancelTransfers(ctx context.Context, tx pgx.Tx, orgID, userID string) error {
+	return execTx(ctx, tx, `
+		UPDATE organization_ownership_transfers
+		SET status = 'cancelled', responded_at = NOW()
+		WHERE organization_id = $1 AND status = 'pending' AND (from_user_id = $2 OR to_user_id = $2)
+	`, orgID, userID)
+}
+
+// removeMembership deletes target's membership. Their project roles, SCIM
+// group memberships and the connections they linked go with it.
+func removeMembership(ctx context.Context, tx pgx.Tx, target *models.OrganizationMember) error {
+	if err := execTx(ctx, tx, "DELETE FROM organization_members WHERE id = $1", target.ID); err != nil {
+		return err
+	}
+	if err := execTx(ctx, tx, `
+		DELETE FROM project_members
+		WHERE user_id = $1 AND project_id IN (SELECT id FROM projects WHERE organization_id = $2)
+	`, target.UserID, target.OrganizationID); err != nil {
+		return err
+	}
+	if err := execTx(ctx, tx, `
+		DELETE FROM scim_group_members
+		WHERE user_id = $1 AND group_id IN (SELECT id FROM scim_groups WHERE organization_id = $2)
+	`, target.UserID, target.OrganizationID); err != nil {
+		return err
+	}
+	return execTx(ctx, tx, `
+		UPDATE projects SET connection_user_id = NULL, database_connected = FALSE, database_type = NULL
+		WHERE organization_id = $1 AND connection_user_id = $2
+	`, target.OrganizationID, target.UserID)
+}
+
+func execTx(ctx context.Context, tx pgx.Tx, sql string, args ...interface{}) error {
+	_, err := tx.Exec(ctx, sql, args...)
+	return err
+}
+
+// changeMember runs change on the member in the path inside a transaction
+// holding the organization lock and writes the updated member. When change
+// returns metadata, the change is recorded in the audit log as action.
+func (h *MemberHandler) changeMember(w http.ResponseWriter, r *http.Request, failure, action string,
+	change func(ctx context.Context, tx pgx.Tx, actorRole string, target *models.OrganizationMember) (map[string]interface{}, error)) {
+	userID, orgID, ok := authorizeOrganizationUser(w, r)
+	if !ok {
+		return
+	}
+	memberID := mux.Vars(r)["memberId"]
+
+	ctx := context.Background()
+	tx, err := h.db.GetPool().Begin(ctx)
+	if err != nil {
+		writeMemberError(w, err, failure)
+		return
+	}
+	defer tx.Rollback(ctx)
+
+	actorRole, err := lockOrganization(ctx, tx, orgID, userID)
+	if err != nil {
+		writeMemberError(w, err, failure)
+		return
+	}
+	target, err := getMember(ctx, tx, orgID, memberID)
+	if err != nil {
+		writeMemberError(w, err, failure)
+		return
+	}
+	metadata, err := change(ctx, tx, actorRole, target)
+	if err != nil {
+		writeMemberError(w, err, failure)
+		return
+	}
+	if err := tx.Commit(ctx); err != nil {
+		writeMemberError(w, err, failure)
+		return
+	}
+
+	if metadata != nil {
+		metadata["email"] = target.Email
+		h.audit.Record(r, audit.Event{
+			OrganizationID: orgID,
+			Action:         action,
+			TargetType:     audit.TargetMember,
+			TargetID:       target.ID,
+			Metadata:       metadata,
+		})
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"data": target,
+	})
+}
+
+// GET /api/v1/users/{userId}/organizations/{orgId}/members
+func (h *MemberHandler) ListMembers(w http.ResponseWriter, r *http.Request) {
+	_, orgID, ok := authorizeOrganizationUser(w, r)
+	if !ok {
+		return
+	}
+
+	status := r.URL.Query().Get("status")
+	if status != "" && status != "active" && status != "suspended" {
+		http.Error(w, "status must be active or suspended", http.StatusBadRequest)
+		return
+	}
+
+	ctx := context.Background()
+
+	rows, err := h.db.Query(ctx, `
+		SELECT `+memberColumns+` FROM organization_members
+		WHERE organization_id = $1 AND ($2 = '' OR status = $2)
+		ORDER BY CASE role WHEN 'owner' THEN 0 WHEN 'admin' THEN 1 ELSE 2 END, joined_at
+	`, orgID, status)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to query organization members")
+		http.Error(w, "Failed to fetch members", http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	members := []models.OrganizationMember{}
+	for rows.Next() {
+		m, err := scanMember(rows)
+		if err != nil {
+			log.Error().Err(err).Msg("Failed to scan member")
+			continue
+		}
+		members = append(members, *m)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"data": members,
+	})
+}
+
+// PUT /api/v1/users/{userId}/organizations/{orgId}/members/{memberId}/role
+func (h *MemberHandler) UpdateMemberRole(w http.ResponseWriter, r *http.Request) {
+	var req models.UpdateMemberRoleRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if req.Role != "owner" && req.Role != "admin" && req.Role != "member" {
+		http.Error(w, "role must be owner, admin or member", http.StatusBadRequest)
+		return
+	}
+
+	h.changeMember(w, r, "Failed to update member role", audit.ActionMemberRoleChanged, func(ctx context.Context, tx pgx.Tx, actorRole string, target *models.OrganizationMember) (map[string]interface{}, error) {
+		if err := canChangeRole(actorRole, target, req.Role); err != nil {
+			return nil, err
+		}
+		if req.Role == target.Role {
+			return nil, nil
+		}
+		if target.Role == "owner" {
+			if err := keepsAnOwner(ctx, tx, target); err != nil {
+				return nil, err
+			}
+			if err := cancelTransfers(ctx, tx, target.OrganizationID, target.UserID); err != nil {
+				return nil, err
+			}
+		}
+
+		if err := execTx(ctx, tx, "UPDATE organization_members SET role = $1 WHERE id = $2", req.Role, target.ID); err != nil {
+			return nil, err
+		}
+		metadata := map[string]interface{}{"from": target.Role, "to": req.Role}
+		target.Role = req.Role
+		return metadata, nil
+	})
+}
+
+// POST /api/v1/users/{userId}/organizations/{orgId}/members/{memberId}/suspend
+//
+// A suspended member keeps their membership row but loses access and no
+// longer takes a seat.
+func (h *MemberHandler) SuspendMember(w http.ResponseWriter, r *http.Request) {
+	h.changeMember(w, r, "Failed to suspend member", audit.ActionMemberSuspended, func(ctx context.Context, tx pgx.Tx, actorRole string, target *models.OrganizationMember) (map[string]interface{}, error) {
+		if err := canManage(actorRole, target); err != nil {
+			return nil, err
+		}
+		if target.UserID == mux.Vars(r)["userId"] {
+			return nil, &memberError{http.StatusBadRequest, "You cannot suspend yourself"}
+		}
+		if target.Status == "suspended" {
+			return nil, nil
+		}
+		if err := keepsAnOwner(ctx, tx, target); err != nil {
+			return nil, err
+		}
+		if err := cancelTransfers(ctx, tx, target.OrganizationID, target.UserID); err != nil {
+			return nil, err
+		}
+
+		if err := execTx(ctx, tx, "UPDATE organization_members SET status = 'suspended' WHERE id = $1", target.ID); err != nil {
+			return nil, err
+		}
+		target.Status = "suspended"
+		return map[string]interface{}{"role": target.Role}, nil
+	})
+}
+
+// POST /api/v1/users/{userId}/organizations/{orgId}/members/{memberId}/reactivate
+func (h *MemberHandler) ReactivateMember(w http.ResponseWriter, r *http.Request) {
+	h.changeMember(w, r, "Failed to reactivate member", audit.ActionMemberReactivated, func(ctx context.Context, tx pgx.Tx, actorRole string, target *models.OrganizationMember) (map[string]interface{}, error) {
+		if err := canManage(actorRole, target); err != nil {
+			return nil, err
+		}
+		if target.Status == "active" {
+			return nil, nil
+		}
+		// The member takes a seat again
+		if _, err := h.quotas.Check(ctx, quota.OrganizationSubject(target.OrganizationID), quota.Members, 1); err != nil {
+			return nil, err
+		}
+
+		if err := execTx(ctx, tx, "UPDATE organization_members SET status = 'active' WHERE id = $1", target.ID); err != nil {
+			return nil, err
+		}
+		target.Status = "active"
+		return map[string]interface{}{"role": target.Role}, nil
+	})
+}
+
+// DELETE /api/v1/users/{userId}/organizations/{orgId}/members/{memberId}
+//
+// Members may remove themselves to leave the organization.
+func (h *MemberHandler) RemoveMember(w http.ResponseWriter, r *http.Request) {
+	h.changeMember(w, r, "Failed to remove member", audit.ActionMemberRemoved, func(ctx context.Context, tx pgx.Tx, actorRole string, target *models.OrganizationMember) (map[string]interface{}, error) {
+		if target.UserID != mux.Vars(r)["userId"] {
+			if err := canManage(actorRole, target); err != nil {
+				return nil, err
+			}
+		}
+		if err := keepsAnOwner(ctx, tx, target); err != nil {
+			return nil, err
+		}
+		if err := cancelTransfers(ctx, tx, target.OrganizationID, target.UserID); err != nil {
+			return nil, err
+		}
+
+		if err := removeMembership(ctx, tx, target); err != nil {
+			return nil, err
+		}
+		target.Status = "removed"
+		return map[string]interface{}{"role": target.Role, "left": target.UserID == mux.Vars(r)["userId"]}, nil
+	})
+}
+
+const transferColumns = `id, organization_id, from_user_id, to_user_id, status, created_at, expires_at, responded_at`
+
+func scanTransfer(row pgx.Row) (*models.OwnershipTransfer, error) {
+	var t models.OwnershipTransfer
+	err := row.Scan(&t.ID, &t.OrganizationID, &t.FromUserID, &t.ToUserID, &t.Status,
+		&t.CreatedAt, &t.ExpiresAt, &t.RespondedAt)
+	if err != nil {
+		return nil, err
+	}
+	return &t, nil
+}
+
+// pendingTransfer returns the organization's unexpired pending transfer.
+// Expired ones are marked so a new transfer can be started.
+func pendingTransfer(ctx context.Context, tx pgx.Tx, orgID string) (*models.OwnershipTransfer, error) {
+	t, err := scanTransfer(tx.QueryRow(ctx, `
+		SELECT `+transferColumns+` FROM organization_ownership_transfers
+		WHERE organization_id = $1 AND status = 'pending'
+	`, orgID))
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, errTransferNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	if time.Now().After(t.ExpiresAt) {
+		if err := execTx(ctx, tx, "UPDATE organization_ownership_transfers SET status = 'expired' WHERE id = $1", t.ID); err != nil {
+			return nil, err
+		}
+		return nil, errTransferNotFound
+	}
+	return t, nil
+}
+
+// withTransfer runs fn inside a transaction holding the organization lock
+// and writes the transfer it returns, recording it in the audit log as
+// action unless action is empty.
+func (h *MemberHandler) withTransfer(w http.ResponseWriter, r *http.Request, failure, action string, status int,
+	fn func(ctx context.Context, tx pgx.Tx, userID, actorRole string) (*models.OwnershipTransfer, error)) {
+	userID, orgID, ok := authorizeOrganizationUser(w, r)
+	if !ok {
+		return
+	}
+
+	ctx := context.Background()
+	tx, err := h.db.GetPool().Begin(ctx)
+	if err != nil {
+		writeMemberError(w, err, failure)
+		return
+	}
+	defer tx.Rollback(ctx)
+
+	actorRole, err := lockOrganization(ctx, tx, orgID, userID)
+	if err != nil {
+		writeMemberError(w, err, failure)
+		return
+	}
+	transfer, err := fn(ctx, tx, userID, actorRole)
+	if err != nil {
+		// Marking an expired transfer is kept even though the request fails
+		if errors.Is(err, errTransferNotFound) {
+			tx.Commit(ctx)
+		}
+		writeMemberError(w, err, failure)
+		return
+	}
+	if err := tx.Commit(ctx); err != nil {
+		writeMemberError(w, err, failure)
+		return
+	}
+
+	if action != "" {
+		h.audit.Record(r, audit.Event{
+			OrganizationID: orgID,
+			Action:         action,
+			TargetType:     audit.TargetOrganization,
+			TargetID:       orgID,
+			Metadata: map[string]interface{}{
+				"transfer_id":  transfer.ID,
+				"from_user_id": transfer.FromUserID,
+				"to_user_id":   transfer.ToUserID,
+				"status":       transfer.Status,
+			},
+		})
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"data": transfer,
+	})
+}
+
+// GET /api/v1/users/{userId}/organizations/{orgId}/ownership-transfer
+func (h *MemberHandler) GetOwnershipTransfer(w http.ResponseWriter, r *http.Request) {
+	h.withTransfer(w, r, "Failed to fetch ownership transfer", "", http.StatusOK, func(ctx context.Context, tx pgx.Tx, userID, actorRole string) (*models.OwnershipTransfer, error) {
+		t, err := pendingTransfer(ctx, tx, mux.Vars(r)["orgId"])
+		if err != nil {
+			return nil, err
+		}
+		if actorRole != "owner" && actorRole != "admin" && t.ToUserID != userID {
+			return nil, errNotPermitted
+		}
+		return t, nil
+	})
+}
+
+// POST /api/v1/users/{userId}/organizations/{orgId}/ownership-transfer
+//
+// Offers ownership to another active member, replacing any pending offer.
+// Nothing changes until the recipient accepts; the sender then becomes an
+// admin.
+func (h *MemberHandler) CreateOwnershipTransfer(w http.ResponseWriter, r *http.Request) {
+	var req models.TransferOwnershipRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.MemberID == "" {
+		http.Error(w, "member_id is required", http.StatusBadRequest)
+		return
+	}
+
+	h.withTransfer(w, r, "Failed to start ownership transfer", audit.ActionOwnershipOffered, http.StatusCreated, func(ctx context.Context, tx pgx.Tx, userID, actorRole string) (*models.OwnershipTransfer, error) {
+		orgID := mux.Vars(r)["orgId"]
+		if actorRole != "owner" {
+			return nil, &memberError{http.StatusForbidden, "Only owners can transfer ownership"}
+		}
+		target, err := getMember(ctx, tx, orgID, req.MemberID)
+		if err != nil {
+			return nil, err
+		}
+		if err := canReceiveOwnership(userID, target); err != nil {
+			return nil, err
+		}
+
+		if err := execTx(ctx, tx, `
+			UPDATE organization_ownership_transfers
+			SET status = 'cancelled', responded_at = NOW()
+			WHERE organization_id = $1 AND status = 'pending'
+		`, orgID); err != nil {
+			return nil, err
+		}
+
+		now := time.Now()
+		t := &models.OwnershipTransfer{
+			ID:             uuid.New().String(),
+			OrganizationID: orgID,
+			FromUserID:     userID,
+			ToUserID:       target.UserID,
+			Status:         "pending",
+			CreatedAt:      now,
+			ExpiresAt:      now.Add(ownershipTransferTTL),
+		}
+		err = execTx(ctx, tx, `
+			INSERT INTO organization_ownership_transfers (id, organization_id, from_user_id, to_user_id, status, created_at, expires_at)
+			VALUES ($1, $2, $3, $4, $5, $6, $7)
+		`, t.ID, t.OrganizationID, t.FromUserID, t.ToUserID, t.Status, t.CreatedAt, t.ExpiresAt)
+		return t, err
+	})
+}
+
+// POST /api/v1/users/{userId}/organizations/{orgId}/ownership-transfer/accept
+func (h *MemberHandler) AcceptOwnershipTransfer(w http.ResponseWriter, r *http.Request) {
+	h.withTransfer(w, r, "Failed to accept ownership transfer", audit.ActionOwnershipAccepted, http.StatusOK, func(ctx context.Context, tx pgx.Tx, userID, actorRole string) (*models.OwnershipTransfer, error) {
+		t, err := pendingTransfer(ctx, tx, mux.Vars(r)["orgId"])
+		if err != nil {
+			return nil, err
+		}
+		if t.ToUserID != userID {
+			return nil, &memberError{http.StatusForbidden, "This ownership transfer is not addressed to you"}
+		}
+
+		// The sender must still be an owner to hand ownership over
+		var fromRole string
+		err = tx.QueryRow(ctx, `
+			SELECT role FROM organization_members
+			WHERE organization_id = $1 AND user_id = $2 AND status = 'active'
+		`, t.OrganizationID, t.FromUserID).Scan(&fromRole)
+		if err != nil && !errors.Is(err, pgx.ErrNoRows) {
+			return nil, err
+		}
+		if fromRole != "owner" {
+			return nil, &memberError{http.StatusConflict, "The sender is no longer an owner of this organization"}
+		}
+
+		if err := execTx(ctx, tx, `
+			UPDATE organization_members SET role = 'owner'
+			WHERE organization_id = $1 AND user_id = $2
+		`, t.OrganizationID, t.ToUserID); err != nil {
+			return nil, err
+		}
+		if err := execTx(ctx, tx, `
+			UPDATE organization_members SET role = 'admin'
+			WHERE organization_id = $1 AND user_id = $2
+		`, t.OrganizationID, t.FromUserID); err != nil {
+			return nil, err
+		}
+
+		now := time.Now()
+		if err := execTx(ctx, tx, `
+			UPDATE organization_ownership_transfers SET status = 'accepted', responded_at = $2 WHERE id = $1
+		`, t.ID, now); err != nil {
+			return nil, err
+		}
+		t.Status = "accepted"
+		t.RespondedAt = &now
+		return t, nil
+	})
+}
+
+// DELETE /api/v1/users/{userId}/organizations/{orgId}/ownership-transfer
+//
+// The recipient declines a pending transfer; the sender or another owner
+// cancels it.
+func (h *MemberHandler) CancelOwnershipTransfer(w http.ResponseWriter, r *http.Request) {
+	h.withTransfer(w, r, "Failed to cancel ownership transfer", audit.ActionOwnershipCancelled, http.StatusOK, func(ctx context.Context, tx pgx.Tx, userID, actorRole string) (*models.OwnershipTransfer, error) {
+		t, err := pendingTransfer(ctx, tx, mux.Vars(r)["orgId"])
+		if err != nil {
+			return nil, err
+		}
+
+		status := "cancelled"
+		switch {
+		case t.ToUserID == userID:
+			status = "declined"
+		case t.FromUserID == userID || actorRole == "owner":
+		default:
+			return nil, errNotPermitted
+		}
+
+		now := time.Now()
+		if err := execTx(ctx, tx, `
+			UPDATE organization_ownership_transfers SET status = $2, responded_at = $3 WHERE id = $1
+		`, t.ID, status, now); err != nil {
+			return nil, err
+		}
+		t.Status = status
+		t.RespondedAt = &now
+		return t, nil
+	})
+}
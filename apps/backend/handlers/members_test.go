@@ -0,0 +1,78 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"testing"
+
+	"go-backend/models"
+)
+
+// memberStatus is the status err is reported with, or 0 for nil.
+func memberStatus(t *testing.T, err error) int {
+	t.Helper()
+	if err == nil {
+		return 0
+	}
+	var me *memberError
+	if !errors.As(err, &me) {
+		t.Fatalf("error %v is not a memberError", err)
+	}
+	return me.status
+}
+
+func TestCanChangeRole(t *testing.T) {
+	member := &models.OrganizationMember{UserID: "u2", Role: "member", Status: "active"}
+	admin := &models.OrganizationMember{UserID: "u2", Role: "admin", Status: "active"}
+	owner := &models.OrganizationMember{UserID: "u2", Role: "owner", Status: "active"}
+	service := &models.OrganizationMember{UserID: "u2", Role: "member", Status: "active", ServiceAccount: true}
+
+	tests := []struct {
+		name   string
+		actor  string
+		target *models.OrganizationMember
+		role   string
+		status int // 0 when allowed
+	}{
+		{"member changes a member", "member", member, "admin", http.StatusForbidden},
+		{"admin promotes a member", "admin", member, "admin", 0},
+		{"admin demotes an admin", "admin", admin, "member", 0},
+		{"admin grants owner", "admin", member, "owner", http.StatusForbidden},
+		{"admin demotes an owner", "admin", owner, "admin", http.StatusForbidden},
+		{"owner grants owner", "owner", member, "owner", 0},
+		{"owner demotes an owner", "owner", owner, "member", 0},
+		{"owner makes a service account owner", "owner", service, "owner", http.StatusBadRequest},
+		{"owner makes a service account admin", "owner", service, "admin", 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := memberStatus(t, canChangeRole(tt.actor, tt.target, tt.role)); got != tt.status {
+				t.Errorf("status = %d, want %d", got, tt.status)
+			}
+		})
+	}
+}
+
+func TestCanReceiveOwnership(t *testing.T) {
+	tests := []struct {
+		name   string
+		target models.OrganizationMember
+		status int // 0 when allowed
+	}{
+		{"active admin", models.OrganizationMember{UserID: "u2", Role: "admin", Status: "active"}, 0},
+		{"active member", models.OrganizationMember{UserID: "u2", Role: "member", Status: "active"}, 0},
+		{"the sender", models.OrganizationMember{UserID: "u1", Role: "owner", Status: "active"}, http.StatusBadRequest},
+		{"suspended member", models.OrganizationMember{UserID: "u2", Role: "admin", Status: "suspended"}, http.StatusBadRequest},
+		{"another owner", models.OrganizationMember{UserID: "u2", Role: "owner", Status: "active"}, http.StatusBadRequest},
+		{"service account", models.OrganizationMember{UserID: "u2", Role: "admin", Status: "active", ServiceAccount: true}, http.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := memberStatus(t, canReceiveOwnership("u1", &tt.target)); got != tt.status {
+				t.Errorf("status = %d, want %d", got, tt.status)
+			}
+		})
+	}
+}
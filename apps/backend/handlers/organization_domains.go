@@ -0,0 +1,535 @@
+package handlers
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+
+	"go-backend/audit"
+	"go-backend/auth"
+	"go-backend/database"
+	"go-backend/middleware"
+	"go-backend/models"
+	"go-backend/quota"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/rs/zerolog/log"
+)
+
+// OrganizationDomainHandler manages the email domains organizations verify
+// and lets users with a verified email at one join by themselves. A domain
+// is verified by publishing its token in a DNS TXT record at
+// _org-verification.<domain>, and can belong to one organization only.
+type OrganizationDomainHandler struct {
+	db        *database.PostgresDB
+	quotas    *quota.Service
+	audit     *audit.Logger
+	lookupTXT func(ctx context.Context, name string) ([]string, error)
+}
+
+func NewOrganizationDomainHandler(db *database.PostgresDB, quotas *quota.Service, auditLog *audit.Logger) *OrganizationDomainHandler {
+	return &OrganizationDomainHandler{db: db, quotas: quotas, audit: auditLog, lookupTXT: net.DefaultResolver.LookupTXT}
+}
+
+const domainRecordPrefix = "_org-verification."
+
+var (
+	errDomainNotFound     = &memberError{http.StatusNotFound, "Domain not found"}
+	errDomainExists       = &memberError{http.StatusConflict, "The organization already has this domain"}
+	errDomainTaken        = &memberError{http.StatusConflict, "This domain is verified by another organization"}
+	errInvalidDomainRole  = &memberError{http.StatusBadRequest, "default_role must be admin or member"}
+	errEmailNotVerified   = &memberError{http.StatusForbidden, "Verify your email address to join organizations by domain"}
+	errNotJoinable        = &memberError{http.StatusNotFound, "No organization to join for your email domain"}
+	errAlreadyMember      = &memberError{http.StatusConflict, "You are already a member of this organization"}
+	domainLabelExpression = regexp.MustCompile(`^[a-z0-9]([a-z0-9-]{0,61}[a-z0-9])?$`)
+)
+
+// freeMailDomains cannot be claimed: their users share nothing but a mail
+// provider.
+var freeMailDomains = map[string]bool{
+	"gmail.com": true, "googlemail.com": true, "outlook.com": true, "hotmail.com": true,
+	"live.com": true, "msn.com": true, "yahoo.com": true, "ymail.com": true,
+	"icloud.com": true, "me.com": true, "mac.com": true, "aol.com": true,
+	"proton.me": true, "protonmail.com": true, "gmx.com": true, "gmx.net": true,
+	"mail.com": true, "yandex.com": true, "zoho.com": true, "fastmail.com": true,
+	auth.ServiceAccountEmailDomain: true,
+}
+
+// normalizeDomain lowercases domain and checks it is a plausible domain that
+// may be claimed.
+func normalizeDomain(domain string) (string, error) {
+	domain = strings.TrimSuffix(strings.TrimPrefix(strings.ToLower(strings.TrimSpace(domain)), "@"), ".")
+	labels := strings.Split(domain, ".")
+	if len(domain) > 253 || len(labels) < 2 {
+		return "", &memberError{http.StatusBadRequest, "domain must be a domain name such as example.com"}
+	}
+	for _, label := range labels {
+		if !domainLabelExpression.MatchString(label) {
+			return "", &memberError{http.StatusBadRequest, "domain must be a domain name such as example.com"}
+		}
+	}
+	if freeMailDomains[domain] {
+		return "", &memberError{http.StatusBadRequest, "Public email domains cannot be claimed"}
+	}
+	return domain, nil
+}
+
+// emailDomain returns the lowercase domain of an email address.
+func emailDomain(email string) string {
+	i := strings.LastIndex(email, "@")
+	if i < 0 {
+		return ""
+	}
+	return strings.ToLower(strings.TrimSuffix(strings.TrimSpace(email[i+1:]), "."))
+}
+
+func newVerificationToken() (string, error) {
+	buf := make([]byte, 16)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return "org-verification=" + hex.EncodeToString(buf), nil
+}
+
+const domainColumns = `id, organization_id, domain, verification_token, verified_at, auto_join, default_role,
+	created_by, created_at, updated_at`
+
+func scanDomain(row pgx.Row) (*models.OrganizationDomain, error) {
+	var d models.OrganizationDomain
+	err := row.Scan(&d.ID, &d.OrganizationID, &d.Domain, &d.VerificationToken, &d.VerifiedAt, &d.AutoJoin,
+		&d.DefaultRole, &d.CreatedBy, &d.CreatedAt, &d.UpdatedAt)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, errDomainNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	d.DNSRecordName = domainRecordPrefix + d.Domain
+	d.DNSRecordValue = d.VerificationToken
+	return &d, nil
+}
+
+func isUniqueViolation(err error) bool {
+	var pgErr *pgconn.PgError
+	return errors.As(err, &pgErr) && pgErr.Code == "23505"
+}
+
+// GET /api/v1/users/{userId}/organizations/{orgId}/domains
+func (h *OrganizationDomainHandler) ListDomains(w http.ResponseWriter, r *http.Request) {
+	orgID := mux.Vars(r)["orgId"]
+
+	rows, err := h.db.Query(context.Background(), `
+		SELECT `+domainColumns+` FROM organization_domains
+		WHERE organization_id = $1
+		ORDER BY domain
+	`, orgID)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to query organization domains")
+		http.Error(w, "Failed to fetch domains", http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	domains := []models.OrganizationDomain{}
+	for rows.Next() {
+		d, err := scanDomain(rows)
+		if err != nil {
+			log.Error().Err(err).Msg("Failed to scan organization domain")
+			continue
+		}
+		domains = append(domains, *d)
+	}
+
+	middleware.WriteJSONResponse(w, http.StatusOK, map[string]interface{}{
+		"data": domains,
+	})
+}
+
+// POST /api/v1/users/{userId}/organizations/{orgId}/domains
+//
+// Adds an unverified domain and returns the TXT record that verifies it.
+func (h *OrganizationDomainHandler) AddDomain(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	userID := vars["userId"]
+	orgID := vars["orgId"]
+
+	var req models.CreateOrganizationDomainRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	domain, err := normalizeDomain(req.Domain)
+	if err != nil {
+		writeMemberError(w, err, "Failed to add domain")
+		return
+	}
+	if req.DefaultRole == "" {
+		req.DefaultRole = "member"
+	}
+	if req.DefaultRole != "admin" && req.DefaultRole != "member" {
+		writeMemberError(w, errInvalidDomainRole, "Failed to add domain")
+		return
+	}
+
+	token, err := newVerificationToken()
+	if err != nil {
+		writeMemberError(w, err, "Failed to add domain")
+		return
+	}
+
+	ctx := context.Background()
+	d, err := scanDomain(h.db.QueryRow(ctx, `
+		INSERT INTO organization_domains (id, organization_id, domain, verification_token, auto_join, default_role, created_by)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)
+		RETURNING `+domainColumns+`
+	`, uuid.New().String(), orgID, domain, token, req.AutoJoin, req.DefaultRole, userID))
+	if isUniqueViolation(err) {
+		err = errDomainExists
+	}
+	if err != nil {
+		writeMemberError(w, err, "Failed to add domain")
+		return
+	}
+
+	h.audit.Record(r, audit.Event{
+		OrganizationID: orgID,
+		Action:         audit.ActionDomainAdded,
+		TargetType:     audit.TargetDomain,
+		TargetID:       d.ID,
+		Metadata:       map[string]interface{}{"domain": d.Domain, "auto_join": d.AutoJoin, "default_role": d.DefaultRole},
+	})
+
+	middleware.WriteJSONResponse(w, http.StatusCreated, map[string]interface{}{
+		"data": d,
+	})
+}
+
+// POST /api/v1/users/{userId}/organizations/{orgId}/domains/{domainId}/verify
+//
+// Looks up the domain's TXT record and marks it verified when the token is
+// there.
+func (h *OrganizationDomainHandler) VerifyDomain(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	orgID := vars["orgId"]
+	domainID := vars["domainId"]
+
+	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+	defer cancel()
+
+	d, err := scanDomain(h.db.QueryRow(ctx, `
+		SELECT `+domainColumns+` FROM organization_domains WHERE id = $1 AND organization_id = $2
+	`, domainID, orgID))
+	if err != nil {
+		writeMemberError(w, err, "Failed to verify domain")
+		return
+	}
+	if d.VerifiedAt != nil {
+		middleware.WriteJSONResponse(w, http.StatusOK, map[string]interface{}{"data": d})
+		return
+	}
+
+	records, err := h.lookupTXT(ctx, d.DNSRecordName)
+	found := false
+	for _, record := range records {
+		if strings.TrimSpace(record) == d.VerificationToken {
+			found = true
+			break
+		}
+	}
+	if !found {
+		message := "TXT record " + d.DNSRecordName + " does not contain " + d.VerificationToken + " yet. DNS changes can take a while to appear"
+		if err != nil {
+			log.Info().Err(err).Str("domain", d.Domain).Msg("Domain verification lookup failed")
+		}
+		http.Error(w, message, http.StatusUnprocessableEntity)
+		return
+	}
+
+	d, err = scanDomain(h.db.QueryRow(ctx, `
+		UPDATE organization_domains SET verified_at = NOW()
+		WHERE id = $1 AND organization_id = $2
+		RETURNING `+domainColumns+`
+	`, domainID, orgID))
+	if isUniqueViolation(err) {
+		err = errDomainTaken
+	}
+	if err != nil {
+		writeMemberError(w, err, "Failed to verify domain")
+		return
+	}
+
+	h.audit.Record(r, audit.Event{
+		OrganizationID: orgID,
+		Action:         audit.ActionDomainVerified,
+		TargetType:     audit.TargetDomain,
+		TargetID:       d.ID,
+		Metadata:       map[string]interface{}{"domain": d.Domain},
+	})
+
+	middleware.WriteJSONResponse(w, http.StatusOK, map[string]interface{}{
+		"data": d,
+	})
+}
+
+// PUT /api/v1/users/{userId}/organizations/{orgId}/domains/{domainId}
+func (h *OrganizationDomainHandler) UpdateDomain(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	orgID := vars["orgId"]
+	domainID := vars["domainId"]
+
+	var req models.UpdateOrganizationDomainRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if req.DefaultRole != nil && *req.DefaultRole != "admin" && *req.DefaultRole != "member" {
+		writeMemberError(w, errInvalidDomainRole, "Failed to update domain")
+		return
+	}
+
+	d, err := scanDomain(h.db.QueryRow(context.Background(), `
+		UPDATE organization_domains
+		SET auto_join = COALESCE($3, auto_join), default_role = COALESCE($4, default_role)
+		WHERE id = $1 AND organization_id = $2
+		RETURNING `+domainColumns+`
+	`, domainID, orgID, req.AutoJoin, req.DefaultRole))
+	if err != nil {
+		writeMemberError(w, err, "Failed to update domain")
+		return
+	}
+
+	h.audit.Record(r, audit.Event{
+		OrganizationID: orgID,
+		Action:         audit.ActionDomainUpdated,
+		TargetType:     audit.TargetDomain,
+		TargetID:       d.ID,
+		Metadata:       map[string]interface{}{"domain": d.Domain, "auto_join": d.AutoJoin, "default_role": d.DefaultRole},
+	})
+
+	middleware.WriteJSONResponse(w, http.StatusOK, map[string]interface{}{
+		"data": d,
+	})
+}
+
+// DELETE /api/v1/users/{userId}/organizations/{orgId}/domains/{domainId}
+//
+// Members who joined through the domain stay.
+func (h *OrganizationDomainHandler) DeleteDomain(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	orgID := vars["orgId"]
+	domainID := vars["domainId"]
+
+	var domain string
+	err := h.db.QueryRow(context.Background(), `
+		DELETE FROM organization_domains WHERE id = $1 AND organization_id = $2
+		RETURNING domain
+	`, domainID, orgID).Scan(&domain)
+	if errors.Is(err, pgx.ErrNoRows) {
+		err = errDomainNotFound
+	}
+	if err != nil {
+		writeMemberError(w, err, "Failed to remove domain")
+		return
+	}
+
+	h.audit.Record(r, audit.Event{
+		OrganizationID: orgID,
+		Action:         audit.ActionDomainRemoved,
+		TargetType:     audit.TargetDomain,
+		TargetID:       domainID,
+		Metadata:       map[string]interface{}{"domain": domain},
+	})
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// joinable lists the organizations the caller can join through their email
+// domain, optionally only orgID.
+func (h *OrganizationDomainHandler) joinable(ctx context.Context, claims *auth.UserClaims, orgID string) ([]models.JoinableOrganization, error) {
+	if !claims.EmailVerified {
+		return nil, errEmailNotVerified
+	}
+	domain := emailDomain(claims.Email)
+	if domain == "" {
+		return nil, nil
+	}
+
+	rows, err := h.db.Query(ctx, `
+		SELECT o.id, o.name, o.slug, d.domain, d.default_role
+		FROM organization_domains d
+		JOIN organizations o ON o.id = d.organization_id
+		WHERE d.domain = $1 AND d.verified_at IS NOT NULL AND d.auto_join
+			AND ($3 = '' OR o.id = $3)
+			AND NOT EXISTS (
+				SELECT 1 FROM organization_members om WHERE om.organization_id = o.id AND om.user_id = $2
+			)
+		ORDER BY o.name
+	`, domain, claims.UserID, orgID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var orgs []models.JoinableOrganization
+	for rows.Next() {
+		var o models.JoinableOrganization
+		if err := rows.Scan(&o.OrganizationID, &o.Name, &o.Slug, &o.Domain, &o.Role); err != nil {
+			return nil, err
+		}
+		orgs = append(orgs, o)
+	}
+	return orgs, rows.Err()
+}
+
+// GET /api/v1/users/{userId}/organizations/joinable
+func (h *OrganizationDomainHandler) ListJoinableOrganizations(w http.ResponseWriter, r *http.Request) {
+	claims := middleware.GetUserClaims(r.Context())
+
+	orgs, err := h.joinable(context.Background(), claims, "")
+	if err != nil {
+		writeMemberError(w, err, "Failed to fetch joinable organizations")
+		return
+	}
+	if orgs == nil {
+		orgs = []models.JoinableOrganization{}
+	}
+
+	middleware.WriteJSONResponse(w, http.StatusOK, map[string]interface{}{
+		"data": orgs,
+	})
+}
+
+// POST /api/v1/users/{userId}/organizations/auto-join
+//
+// Joins every organization with a verified auto-join domain matching the
+// caller's verified email, or only organization_id. Meant to be called after
+// sign-up. Each join takes a seat and is refused when the plan has none.
+func (h *OrganizationDomainHandler) AutoJoin(w http.ResponseWriter, r *http.Request) {
+	claims := middleware.GetUserClaims(r.Context())
+
+	// The body is optional
+	var req models.AutoJoinRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil && !errors.Is(err, io.EOF) {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	ctx := context.Background()
+	orgs, err := h.joinable(ctx, claims, req.OrganizationID)
+	if err != nil {
+		writeMemberError(w, err, "Failed to join organizations")
+		return
+	}
+	if req.OrganizationID != "" && len(orgs) == 0 {
+		writeMemberError(w, errNotJoinable, "Failed to join organization")
+		return
+	}
+
+	results := []models.AutoJoinResult{}
+	for _, org := range orgs {
+		result := models.AutoJoinResult{OrganizationID: org.OrganizationID}
+		member, err := h.join(ctx, claims, org)
+		var me *memberError
+		var exceeded *quota.ExceededError
+		switch {
+		case err == nil:
+			result.Status = "joined"
+			result.Role = member.Role
+			result.MemberID = member.ID
+			h.audit.Record(r, audit.Event{
+				OrganizationID: org.OrganizationID,
+				Action:         audit.ActionMemberJoined,
+				TargetType:     audit.TargetMember,
+				TargetID:       member.ID,
+				Metadata:       map[string]interface{}{"domain": org.Domain, "role": member.Role},
+			})
+		case errors.As(err, &me):
+			result.Status = "error"
+			result.Error = me.message
+		case errors.As(err, &exceeded):
+			result.Status = "error"
+			result.Error = "The organization has no seats left"
+		default:
+			log.Error().Err(err).Str("organization_id", org.OrganizationID).Msg("Failed to join organization by domain")
+			result.Status = "error"
+			result.Error = "Failed to join organization"
+		}
+		results = append(results, result)
+	}
+
+	middleware.WriteJSONResponse(w, http.StatusOK, map[string]interface{}{
+		"data": results,
+	})
+}
+
+// join adds the caller to org with the domain's default role, withdrawing
+// any pending invitations of theirs the membership makes moot.
+func (h *OrganizationDomainHandler) join(ctx context.Context, claims *auth.UserClaims, org models.JoinableOrganization) (*models.OrganizationMember, error) {
+	tx, err := h.db.GetPool().Begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback(ctx)
+
+	var id string
+	if err := tx.QueryRow(ctx, "SELECT id FROM organizations WHERE id = $1 FOR UPDATE", org.OrganizationID).Scan(&id); err != nil {
+		return nil, err
+	}
+
+	var existing int
+	err = tx.QueryRow(ctx, `
+		SELECT COUNT(*) FROM organization_members
+		WHERE organization_id = $1 AND (user_id = $2 OR LOWER(email) = LOWER($3))
+	`, org.OrganizationID, claims.UserID, claims.Email).Scan(&existing)
+	if err != nil {
+		return nil, err
+	}
+	if existing > 0 {
+		return nil, errAlreadyMember
+	}
+
+	// Pending invitations hold seats, so this counts them too
+	if _, err := h.quotas.CheckInvitation(ctx, org.OrganizationID); err != nil {
+		return nil, err
+	}
+
+	if err := execTx(ctx, tx, `
+		INSERT INTO users (user_id, email, role, created_at, updated_at)
+		VALUES ($1, $2, 'user', NOW(), NOW())
+		ON CONFLICT (user_id) DO NOTHING
+	`, claims.UserID, claims.Email); err != nil {
+		return nil, err
+	}
+
+	member, err := scanMember(tx.QueryRow(ctx, `
+		INSERT INTO organization_members (id, organization_id, user_id, email, role, status, joined_at, invited_at, project_access)
+		VALUES ($1, $2, $3, $4, $5, 'active', NOW(), NOW(), 'all')
+		RETURNING `+memberColumns,
+		uuid.New().String(), org.OrganizationID, claims.UserID, claims.Email, org.Role))
+	if err != nil {
+		return nil, err
+	}
+
+	if err := execTx(ctx, tx, `
+		UPDATE organization_invitations SET status = 'cancelled'
+		WHERE organization_id = $1 AND LOWER(email) = LOWER($2) AND status = 'pending'
+	`, org.OrganizationID, claims.Email); err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return nil, err
+	}
+	return member, nil
+}
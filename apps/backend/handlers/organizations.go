@@ -3,11 +3,19 @@ package handlers
 import (
 	"context"
 	"encoding/json"
+	"fmt"
 	"net/http"
+	netmail "net/mail"
+	"strconv"
+	"strings"
 	"time"
 
+	"go-backend/audit"
+	"go-backend/authz"
 	"go-backend/database"
+	"go-backend/mail"
 	"go-backend/models"
+	"go-backend/quota"
 
 	"github.com/google/uuid"
 	"github.com/gorilla/mux"
@@ -15,11 +23,15 @@ import (
 )
 
 type OrganizationHandler struct {
-	db *database.PostgresDB
+	db     *database.PostgresDB
+	quotas *quota.Service
+	audit  *audit.Logger
+	outbox *mail.Outbox
+	appURL string
 }
 
-func NewOrganizationHandler(db *database.PostgresDB) *OrganizationHandler {
-	return &OrganizationHandler{db: db}
+func NewOrganizationHandler(db *database.PostgresDB, quotas *quota.Service, auditLog *audit.Logger, outbox *mail.Outbox, appURL string) *OrganizationHandler {
+	return &OrganizationHandler{db: db, quotas: quotas, audit: auditLog, outbox: outbox, appURL: appURL}
 }
 
 // GET /api/v1/users/{userId}/organizations
@@ -93,6 +105,13 @@ func (h *OrganizationHandler) CreateOrganization(w http.ResponseWriter, r *http.
 	memberID := uuid.New().String()
 
 	ctx := context.Background()
+	plan, err := h.quotas.DefaultPlanID(ctx)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to load default plan")
+		http.Error(w, "Failed to create organization", http.StatusInternalServerError)
+		return
+	}
+
 	tx, err := h.db.GetPool().Begin(ctx)
 	if err != nil {
 		log.Error().Err(err).Msg("Failed to begin transaction")
@@ -106,7 +125,7 @@ func (h *OrganizationHandler) CreateOrganization(w http.ResponseWriter, r *http.
 	_, err = tx.Exec(ctx, `
 		INSERT INTO organizations (id, name, slug, description, created_at, updated_at, plan)
 		VALUES ($1, $2, $3, $4, $5, $6, $7)
-	`, orgID, req.Name, req.Slug, req.Description, now, now, "free")
+	`, orgID, req.Name, req.Slug, req.Description, now, now, plan)
 
 	if err != nil {
 		log.Error().Err(err).Msg("Failed to create organization")
@@ -165,18 +184,6 @@ func (h *OrganizationHandler) GetOrganization(w http.ResponseWriter, r *http.Req
 
 	ctx := context.Background()
 	
-	// Check if user is member of organization
-	var memberCount int
-	err := h.db.QueryRow(ctx, `
-		SELECT COUNT(*) FROM organization_members 
-		WHERE organization_id = $1 AND user_id = $2 AND status = 'active'
-	`, orgID, userID).Scan(&memberCount)
-
-	if err != nil || memberCount == 0 {
-		http.Error(w, "Organization not found or access denied", http.StatusNotFound)
-		return
-	}
-
 	query := `
 		SELECT o.id, o.name, o.slug, o.description, o.created_at, o.updated_at, o.plan,
 			(SELECT COUNT(*) FROM organization_members om WHERE om.organization_id = o.id AND om.status = 'active') as member_count,
@@ -186,7 +193,7 @@ func (h *OrganizationHandler) GetOrganization(w http.ResponseWriter, r *http.Req
 	`
 
 	var org models.Organization
-	err = h.db.QueryRow(ctx, query, orgID).Scan(
+	err := h.db.QueryRow(ctx, query, orgID).Scan(
 		&org.ID, &org.Name, &org.Slug, &org.Description,
 		&org.CreatedAt, &org.UpdatedAt, &org.Plan,
 		&org.MemberCount, &org.ProjectCount,
@@ -217,98 +224,31 @@ func (h *OrganizationHandler) GetOrganizationUsage(w http.ResponseWriter, r *htt
 
 	ctx := context.Background()
 
-	// Check if user is member of organization
-	var memberCount int
-	err := h.db.QueryRow(ctx, `
-		SELECT COUNT(*) FROM organization_members 
-		WHERE organization_id = $1 AND user_id = $2 AND status = 'active'
-	`, orgID, userID).Scan(&memberCount)
-
-	if err != nil || memberCount == 0 {
-		http.Error(w, "Organization not found or access denied", http.StatusNotFound)
-		return
-	}
-
-	// Get organization plan
-	var plan string
-	err = h.db.QueryRow(ctx, "SELECT plan FROM organizations WHERE id = $1", orgID).Scan(&plan)
+	report, err := h.quotas.Report(ctx, quota.OrganizationSubject(orgID))
 	if err != nil {
-		log.Error().Err(err).Msg("Failed to get organization plan")
+		log.Error().Err(err).Msg("Failed to compute organization usage")
 		http.Error(w, "Failed to fetch usage data", http.StatusInternalServerError)
 		return
 	}
 
-	// Define limits based on plan
-	limits := map[string]map[string]int{
-		"free": {
-			"ai_queries": 40,
-			"projects": 2,
-			"members": 3,
-			"db_connections": 2,
-			"query_history_days": 7,
-		},
-		"pro": {
-			"ai_queries": 1000,
-			"projects": 25,
-			"members": 25,
-			"db_connections": 25,
-			"query_history_days": 90,
-		},
-		"enterprise": {
-			"ai_queries": 10000,
-			"projects": 100,
-			"members": 100,
-			"db_connections": 100,
-			"query_history_days": 365,
-		},
-	}
-
-	planLimits := limits[plan]
-	if planLimits == nil {
-		planLimits = limits["free"] // default to free
-		plan = "free"
-	}
-
-	// Get actual usage counts
-	var aiQueriesUsed, projectsCount, membersCount, dbConnections int
-
-	// AI queries used (from metrics table)
-	h.db.QueryRow(ctx, `
-		SELECT COUNT(*) FROM metrics 
-		WHERE user_id IN (
-			SELECT user_id FROM organization_members 
-			WHERE organization_id = $1 AND status = 'active'
-		) AND metric_type = 'ai_query_executed'
-		AND created_at >= date_trunc('month', CURRENT_DATE)
-	`, orgID).Scan(&aiQueriesUsed)
-
-	// Projects count
-	h.db.QueryRow(ctx, "SELECT COUNT(*) FROM projects WHERE organization_id = $1", orgID).Scan(&projectsCount)
-
-	// Members count
-	h.db.QueryRow(ctx, "SELECT COUNT(*) FROM organization_members WHERE organization_id = $1 AND status = 'active'", orgID).Scan(&membersCount)
-
-	// Database connections count
-	h.db.QueryRow(ctx, `
-		SELECT COUNT(*) FROM user_resources ur
-		INNER JOIN organization_members om ON ur.user_id = om.user_id
-		WHERE om.organization_id = $1 AND om.status = 'active' 
-		AND ur.resource_type = 'database_config'
-	`, orgID).Scan(&dbConnections)
-
 	usage := models.OrganizationUsage{
-		OrganizationID:              orgID,
-		Plan:                        plan,
-		BillingCycleEnd:            time.Now().AddDate(0, 1, 0), // Next month
-		AIQueriesUsed:              aiQueriesUsed,
-		AIQueriesLimit:             planLimits["ai_queries"],
-		ProjectsCount:              projectsCount,
-		ProjectsLimit:              planLimits["projects"],
-		MembersCount:               membersCount,
-		MembersLimit:               planLimits["members"],
-		DatabaseConnections:        dbConnections,
-		DatabaseConnectionsLimit:   planLimits["db_connections"],
-		QueryHistoryLimitDays:      planLimits["query_history_days"],
+		OrganizationID:           orgID,
+		Plan:                     report.Plan,
+		BillingCycleStart:        report.Cycle.Start,
+		BillingCycleEnd:          report.Cycle.End,
+		AIQueriesUsed:            report.Used[quota.AIQueries],
+		AIQueriesLimit:           report.Limits[quota.AIQueries],
+		ProjectsCount:            report.Used[quota.Projects],
+		ProjectsLimit:            report.Limits[quota.Projects],
+		MembersCount:             report.Used[quota.Members],
+		MembersLimit:             report.Limits[quota.Members],
+		DatabaseConnections:      report.Used[quota.DBConnections],
+		DatabaseConnectionsLimit: report.Limits[quota.DBConnections],
+		QueryHistoryLimitDays:    report.Limits[quota.QueryHistoryDays],
+		QueryExecutions:          report.Metered[quota.MeterQueryExecutions],
+		RowsExported:             report.Metered[quota.MeterRowsExported],
+		ConnectionHours:          float64(report.Metered[quota.MeterConnectionSeconds]) / 3600,
+		Features:                 report.Features,
 	}
 
 	w.Header().Set("Content-Type", "application/json")
@@ -317,6 +257,49 @@ func (h *OrganizationHandler) GetOrganizationUsage(w http.ResponseWriter, r *htt
 	})
 }
 
+// GET /api/v1/users/{userId}/organizations/{orgId}/usage/cycles
+func (h *OrganizationHandler) GetUsageCycles(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	userID := vars["userId"]
+	orgID := vars["orgId"]
+
+	if userID == "" || orgID == "" {
+		http.Error(w, "User ID and Organization ID are required", http.StatusBadRequest)
+		return
+	}
+
+	cycles := 6
+	if c := r.URL.Query().Get("cycles"); c != "" {
+		parsed, err := strconv.Atoi(c)
+		if err != nil || parsed < 1 || parsed > 36 {
+			http.Error(w, "cycles must be between 1 and 36", http.StatusBadRequest)
+			return
+		}
+		cycles = parsed
+	}
+	byUser := r.URL.Query().Get("by_user") == "true"
+
+	ctx := context.Background()
+
+	// Any member can see totals; the per-member split is for owners and admins
+	if byUser && !authz.OrgRoleAtLeast(authz.FromContext(r.Context()).Target.OrgRole, authz.RoleAdmin) {
+		http.Error(w, "Insufficient permissions", http.StatusForbidden)
+		return
+	}
+
+	reports, err := h.quotas.CycleReports(ctx, quota.OrganizationSubject(orgID), cycles, byUser)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to compute usage cycles")
+		http.Error(w, "Failed to fetch usage data", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"data": reports,
+	})
+}
+
 // POST /api/v1/users/{userId}/organizations/{orgId}/invitations
 func (h *OrganizationHandler) InviteToOrganization(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
@@ -336,31 +319,66 @@ func (h *OrganizationHandler) InviteToOrganization(w http.ResponseWriter, r *htt
 
 	ctx := context.Background()
 
-	// Check if user is admin/owner of organization
-	var role string
-	err := h.db.QueryRow(ctx, `
-		SELECT role FROM organization_members 
-		WHERE organization_id = $1 AND user_id = $2 AND status = 'active'
-	`, orgID, userID).Scan(&role)
+	h.createInvitation(ctx, w, r, userID, orgID, &req)
+}
 
-	if err != nil || (role != "owner" && role != "admin") {
-		http.Error(w, "Insufficient permissions", http.StatusForbidden)
+// createInvitation stores an invitation from userID to orgID and writes it
+// to the response. The policy has checked that userID may invite.
+func (h *OrganizationHandler) createInvitation(ctx context.Context, w http.ResponseWriter, r *http.Request, userID, orgID string, req *models.InviteToOrganizationRequest) {
+	invitation, err := h.invite(ctx, r, userID, orgID, req)
+	if err != nil {
+		writeMemberError(w, err, "Failed to create invitation")
 		return
 	}
 
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"data": invitation,
+	})
+}
+
+var (
+	errInvalidEmail       = &memberError{http.StatusBadRequest, "A valid email is required"}
+	errInvalidInviteRole  = &memberError{http.StatusBadRequest, "role must be admin or member"}
+	errInvalidProjectRole = &memberError{http.StatusBadRequest, "project_role must be viewer, editor or admin"}
+	errAlreadyInvited     = &memberError{http.StatusConflict, "User is already a member or has a pending invitation"}
+)
+
+// invite stores an invitation and queues its email. Refusals are
+// memberErrors or quota errors.
+func (h *OrganizationHandler) invite(ctx context.Context, r *http.Request, userID, orgID string, req *models.InviteToOrganizationRequest) (*models.OrganizationInvitation, error) {
+	req.Email = strings.TrimSpace(req.Email)
+	if addr, err := netmail.ParseAddress(req.Email); err != nil || addr.Address != req.Email {
+		return nil, errInvalidEmail
+	}
+	if req.Role != "admin" && req.Role != "member" {
+		return nil, errInvalidInviteRole
+	}
+	if req.ProjectRole != nil {
+		if _, ok := authz.ProjectRoleRank[*req.ProjectRole]; !ok {
+			return nil, errInvalidProjectRole
+		}
+	}
+
 	// Check if user is already a member or has pending invitation
 	var existingCount int
-	h.db.QueryRow(ctx, `
+	err := h.db.QueryRow(ctx, `
 		SELECT COUNT(*) FROM (
-			SELECT 1 FROM organization_members WHERE organization_id = $1 AND email = $2
+			SELECT 1 FROM organization_members WHERE organization_id = $1 AND LOWER(email) = LOWER($2)
 			UNION
-			SELECT 1 FROM organization_invitations WHERE organization_id = $1 AND email = $2 AND status = 'pending'
+			SELECT 1 FROM organization_invitations WHERE organization_id = $1 AND LOWER(email) = LOWER($2) AND status = 'pending'
 		) as existing
 	`, orgID, req.Email).Scan(&existingCount)
-
+	if err != nil {
+		return nil, err
+	}
 	if existingCount > 0 {
-		http.Error(w, "User is already a member or has a pending invitation", http.StatusConflict)
-		return
+		return nil, errAlreadyInvited
+	}
+
+	if _, err := h.quotas.CheckInvitation(ctx, orgID); err != nil {
+		return nil, err
 	}
 
 	// Create invitation
@@ -378,17 +396,19 @@ func (h *OrganizationHandler) InviteToOrganization(w http.ResponseWriter, r *htt
 
 	err = h.db.Exec(ctx, `
 		INSERT INTO organization_invitations 
-		(id, organization_id, email, role, status, invited_by, invited_at, expires_at, token, project_access_type, specific_projects, message)
-		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12)
-	`, invitationID, orgID, req.Email, req.Role, "pending", userID, now, expiresAt, token, req.ProjectAccessType, specificProjectsJSON, req.Message)
-
+		(id, organization_id, email, role, status, invited_by, invited_at, expires_at, token, project_access_type, specific_projects, message, project_role)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13)
+	`, invitationID, orgID, req.Email, req.Role, "pending", userID, now, expiresAt, token, req.ProjectAccessType, specificProjectsJSON, req.Message, req.ProjectRole)
 	if err != nil {
-		log.Error().Err(err).Msg("Failed to create invitation")
-		http.Error(w, "Failed to create invitation", http.StatusInternalServerError)
-		return
+		return nil, fmt.Errorf("failed to create invitation: %w", err)
+	}
+
+	// The invitation stands without its email; resending queues another
+	if err := queueInvitationEmail(ctx, h.db, h.outbox, h.appURL, invitationID); err != nil {
+		log.Error().Err(err).Str("invitation_id", invitationID).Msg("Failed to queue invitation email")
 	}
 
-	invitation := models.OrganizationInvitation{
+	invitation := &models.OrganizationInvitation{
 		ID:                invitationID,
 		OrganizationID:    orgID,
 		Email:             req.Email,
@@ -401,26 +421,38 @@ func (h *OrganizationHandler) InviteToOrganization(w http.ResponseWriter, r *htt
 		ProjectAccessType: req.ProjectAccessType,
 		SpecificProjects:  specificProjectsJSON,
 		Message:           req.Message,
+		ProjectRole:       req.ProjectRole,
 	}
 
-	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(http.StatusCreated)
-	json.NewEncoder(w).Encode(map[string]interface{}{
-		"data": invitation,
+	metadata := map[string]interface{}{
+		"email": req.Email,
+		"role":  req.Role,
+	}
+	if req.ProjectAccessType != nil {
+		metadata["project_access_type"] = *req.ProjectAccessType
+		metadata["specific_projects"] = req.SpecificProjects
+	}
+	if req.ProjectRole != nil {
+		metadata["project_role"] = *req.ProjectRole
+	}
+	h.audit.Record(r, audit.Event{
+		OrganizationID: orgID,
+		Action:         audit.ActionInvitationSent,
+		TargetType:     audit.TargetInvitation,
+		TargetID:       invitationID,
+		Metadata:       metadata,
 	})
+
+	return invitation, nil
 }
 
 // POST /api/v1/users/{userId}/organizations/{orgId}/projects/{projectId}/invitations
+//
+// Invites someone to the organization with access to this project only, as
+// project_role (viewer by default). Project admins who are not organization
+// owners or admins can only invite plain members.
 func (h *OrganizationHandler) InviteToProject(w http.ResponseWriter, r *http.Request) {
-	vars := mux.Vars(r)
-	userID := vars["userId"]
-	orgID := vars["orgId"]
-	projectID := vars["projectId"]
-
-	if userID == "" || orgID == "" || projectID == "" {
-		http.Error(w, "User ID, Organization ID, and Project ID are required", http.StatusBadRequest)
-		return
-	}
+	access := projectAccessFrom(r)
 
 	var req models.InviteToOrganizationRequest
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
@@ -428,28 +460,19 @@ func (h *OrganizationHandler) InviteToProject(w http.ResponseWriter, r *http.Req
 		return
 	}
 
-	ctx := context.Background()
-
-	// Check if user has access to the project
-	var accessCount int
-	err := h.db.QueryRow(ctx, `
-		SELECT COUNT(*) FROM organization_members om
-		INNER JOIN projects p ON p.organization_id = om.organization_id
-		WHERE om.user_id = $1 AND om.status = 'active' AND p.id = $2
-		AND (om.role IN ('owner', 'admin') OR p.organization_id = om.organization_id)
-	`, userID, projectID).Scan(&accessCount)
-
-	if err != nil || accessCount == 0 {
+	if req.Role != "member" && access.OrgRole != "owner" && access.OrgRole != "admin" {
 		http.Error(w, "Insufficient permissions", http.StatusForbidden)
 		return
 	}
 
 	// Set project-specific invitation data
 	req.ProjectAccessType = stringPtr("specific")
-	req.SpecificProjects = []string{projectID}
+	req.SpecificProjects = []string{access.ProjectID}
+	if req.ProjectRole == nil {
+		req.ProjectRole = stringPtr(models.ProjectRoleViewer)
+	}
 
-	// Reuse the organization invitation logic
-	h.InviteToOrganization(w, r)
+	h.createInvitation(context.Background(), w, r, access.UserID, access.OrganizationID, &req)
 }
 
 func stringPtr(s string) *string {
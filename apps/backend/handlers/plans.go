@@ -0,0 +1,336 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"go-backend/audit"
+	"go-backend/middleware"
+	"go-backend/quota"
+
+	"github.com/gorilla/mux"
+	"github.com/rs/zerolog/log"
+)
+
+// PlanHandler serves the admin endpoints that manage plans, organization
+// overrides, plan assignment and billing anchors. Changes take effect
+// without a redeploy.
+type PlanHandler struct {
+	quotas *quota.Service
+	audit  *audit.Logger
+}
+
+func NewPlanHandler(quotas *quota.Service, auditLog *audit.Logger) *PlanHandler {
+	return &PlanHandler{quotas: quotas, audit: auditLog}
+}
+
+type SavePlanRequest struct {
+	Name      string         `json:"name"`
+	Limits    quota.Limits   `json:"limits"`
+	Features  quota.Features `json:"features"`
+	IsDefault bool           `json:"is_default"`
+	PriceID   string         `json:"price_id"`
+}
+
+type PlanOverrideRequest struct {
+	Limits    quota.Limits   `json:"limits"`
+	Features  quota.Features `json:"features"`
+	Note      string         `json:"note"`
+	ExpiresAt *time.Time     `json:"expires_at"`
+}
+
+type SetOrganizationPlanRequest struct {
+	Plan string `json:"plan"`
+}
+
+type SetBillingAnchorRequest struct {
+	BillingAnchor time.Time `json:"billing_anchor"`
+}
+
+// EntitlementsResponse shows how an organization's entitlements are built.
+type EntitlementsResponse struct {
+	OrganizationID string              `json:"organization_id"`
+	Entitlements   *quota.Entitlements `json:"entitlements"`
+	Override       *quota.Override     `json:"override,omitempty"`
+}
+
+// GET /api/v1/admin/plans
+func (h *PlanHandler) ListPlans(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
+	defer cancel()
+
+	plans, err := h.quotas.ListPlans(ctx)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to list plans")
+		middleware.WriteErrorResponse(w, http.StatusInternalServerError, err, "Failed to list plans")
+		return
+	}
+
+	middleware.WriteJSONResponse(w, http.StatusOK, map[string]interface{}{
+		"plans": plans,
+	})
+}
+
+// PUT /api/v1/admin/plans/{planId}
+func (h *PlanHandler) SavePlan(w http.ResponseWriter, r *http.Request) {
+	var req SavePlanRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		middleware.WriteErrorResponse(w, http.StatusBadRequest, err, "Invalid request body")
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
+	defer cancel()
+
+	plan, err := h.quotas.SavePlan(ctx, &quota.Plan{
+		ID:        mux.Vars(r)["planId"],
+		Name:      req.Name,
+		Limits:    req.Limits,
+		Features:  req.Features,
+		IsDefault: req.IsDefault,
+		PriceID:   req.PriceID,
+	})
+	if err != nil {
+		var invalid *quota.ValidationError
+		if errors.As(err, &invalid) {
+			middleware.WriteErrorResponse(w, http.StatusBadRequest, err, invalid.Error())
+			return
+		}
+		log.Error().Err(err).Msg("Failed to save plan")
+		middleware.WriteErrorResponse(w, http.StatusInternalServerError, err, "Failed to save plan")
+		return
+	}
+
+	h.audit.Record(r, audit.Event{
+		Action:     audit.ActionPlanSaved,
+		TargetType: audit.TargetPlan,
+		TargetID:   plan.ID,
+		Metadata:   map[string]interface{}{"plan": plan},
+	})
+
+	middleware.WriteJSONResponse(w, http.StatusOK, plan)
+}
+
+// DELETE /api/v1/admin/plans/{planId}
+func (h *PlanHandler) DeletePlan(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
+	defer cancel()
+
+	err := h.quotas.DeletePlan(ctx, mux.Vars(r)["planId"])
+	switch {
+	case err == nil:
+		h.audit.Record(r, audit.Event{
+			Action:     audit.ActionPlanDeleted,
+			TargetType: audit.TargetPlan,
+			TargetID:   mux.Vars(r)["planId"],
+		})
+		middleware.WriteJSONResponse(w, http.StatusOK, map[string]string{"message": "Plan deleted"})
+	case errors.Is(err, quota.ErrPlanNotFound):
+		middleware.WriteErrorResponse(w, http.StatusNotFound, err, "Plan not found")
+	case errors.Is(err, quota.ErrPlanInUse), errors.Is(err, quota.ErrDefaultPlan):
+		middleware.WriteErrorResponse(w, http.StatusConflict, err, err.Error())
+	default:
+		log.Error().Err(err).Msg("Failed to delete plan")
+		middleware.WriteErrorResponse(w, http.StatusInternalServerError, err, "Failed to delete plan")
+	}
+}
+
+// GET /api/v1/admin/organizations/{orgId}/entitlements
+func (h *PlanHandler) GetEntitlements(w http.ResponseWriter, r *http.Request) {
+	orgID := mux.Vars(r)["orgId"]
+
+	ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
+	defer cancel()
+
+	entitlements, err := h.quotas.Entitlements(ctx, quota.OrganizationSubject(orgID))
+	if errors.Is(err, quota.ErrOrganizationNotFound) {
+		middleware.WriteErrorResponse(w, http.StatusNotFound, err, "Organization not found")
+		return
+	}
+	if err != nil {
+		log.Error().Err(err).Str("org_id", orgID).Msg("Failed to load entitlements")
+		middleware.WriteErrorResponse(w, http.StatusInternalServerError, err, "Failed to load entitlements")
+		return
+	}
+
+	override, err := h.quotas.GetOverride(ctx, orgID)
+	if err != nil {
+		log.Error().Err(err).Str("org_id", orgID).Msg("Failed to load plan override")
+		middleware.WriteErrorResponse(w, http.StatusInternalServerError, err, "Failed to load entitlements")
+		return
+	}
+
+	middleware.WriteJSONResponse(w, http.StatusOK, EntitlementsResponse{
+		OrganizationID: orgID,
+		Entitlements:   entitlements,
+		Override:       override,
+	})
+}
+
+// PUT /api/v1/admin/organizations/{orgId}/plan
+func (h *PlanHandler) SetOrganizationPlan(w http.ResponseWriter, r *http.Request) {
+	var req SetOrganizationPlanRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		middleware.WriteErrorResponse(w, http.StatusBadRequest, err, "Invalid request body")
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
+	defer cancel()
+
+	orgID := mux.Vars(r)["orgId"]
+	enforcement, err := h.quotas.SetOrganizationPlan(ctx, orgID, req.Plan)
+	switch {
+	case err == nil:
+		h.audit.Record(r, audit.Event{
+			OrganizationID: orgID,
+			Action:         audit.ActionPlanChanged,
+			TargetType:     audit.TargetOrganization,
+			TargetID:       orgID,
+			Metadata: map[string]interface{}{
+				"plan":               enforcement.Plan,
+				"source":             "admin",
+				"read_only_projects": enforcement.ReadOnly,
+				"restored_projects":  enforcement.Restored,
+			},
+		})
+		middleware.WriteJSONResponse(w, http.StatusOK, enforcement)
+	case errors.Is(err, quota.ErrPlanNotFound):
+		middleware.WriteErrorResponse(w, http.StatusBadRequest, err, "Unknown plan")
+	case errors.Is(err, quota.ErrOrganizationNotFound):
+		middleware.WriteErrorResponse(w, http.StatusNotFound, err, "Organization not found")
+	default:
+		log.Error().Err(err).Msg("Failed to update organization plan")
+		middleware.WriteErrorResponse(w, http.StatusInternalServerError, err, "Failed to update organization plan")
+	}
+}
+
+// PUT /api/v1/admin/organizations/{orgId}/billing-anchor
+func (h *PlanHandler) SetBillingAnchor(w http.ResponseWriter, r *http.Request) {
+	orgID := mux.Vars(r)["orgId"]
+
+	var req SetBillingAnchorRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		middleware.WriteErrorResponse(w, http.StatusBadRequest, err, "Invalid request body")
+		return
+	}
+	if req.BillingAnchor.IsZero() || req.BillingAnchor.After(time.Now()) {
+		middleware.WriteErrorResponse(w, http.StatusBadRequest, fmt.Errorf("invalid billing anchor"), "billing_anchor must be a past RFC 3339 timestamp")
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
+	defer cancel()
+
+	err := h.quotas.SetBillingAnchor(ctx, orgID, req.BillingAnchor)
+	if errors.Is(err, quota.ErrOrganizationNotFound) {
+		middleware.WriteErrorResponse(w, http.StatusNotFound, err, "Organization not found")
+		return
+	}
+	if err != nil {
+		log.Error().Err(err).Str("org_id", orgID).Msg("Failed to update billing anchor")
+		middleware.WriteErrorResponse(w, http.StatusInternalServerError, err, "Failed to update billing anchor")
+		return
+	}
+
+	h.audit.Record(r, audit.Event{
+		OrganizationID: orgID,
+		Action:         audit.ActionBillingAnchorChanged,
+		TargetType:     audit.TargetOrganization,
+		TargetID:       orgID,
+		Metadata:       map[string]interface{}{"billing_anchor": req.BillingAnchor.UTC()},
+	})
+
+	middleware.WriteJSONResponse(w, http.StatusOK, map[string]interface{}{
+		"billing_anchor": req.BillingAnchor.UTC(),
+		"current_cycle":  quota.CycleAt(req.BillingAnchor, time.Now()),
+	})
+}
+
+// PUT /api/v1/admin/organizations/{orgId}/plan-override
+func (h *PlanHandler) SavePlanOverride(w http.ResponseWriter, r *http.Request) {
+	orgID := mux.Vars(r)["orgId"]
+
+	var req PlanOverrideRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		middleware.WriteErrorResponse(w, http.StatusBadRequest, err, "Invalid request body")
+		return
+	}
+
+	override := &quota.Override{
+		OrganizationID: orgID,
+		Limits:         req.Limits,
+		Features:       req.Features,
+		Note:           req.Note,
+		ExpiresAt:      req.ExpiresAt,
+	}
+	if claims := middleware.GetUserClaims(r.Context()); claims != nil {
+		override.UpdatedBy = claims.UserID
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
+	defer cancel()
+
+	if _, err := h.quotas.Entitlements(ctx, quota.OrganizationSubject(orgID)); err != nil {
+		if errors.Is(err, quota.ErrOrganizationNotFound) {
+			middleware.WriteErrorResponse(w, http.StatusNotFound, err, "Organization not found")
+			return
+		}
+		log.Error().Err(err).Str("org_id", orgID).Msg("Failed to load entitlements")
+		middleware.WriteErrorResponse(w, http.StatusInternalServerError, err, "Failed to save plan override")
+		return
+	}
+
+	if err := h.quotas.SaveOverride(ctx, override); err != nil {
+		var invalid *quota.ValidationError
+		if errors.As(err, &invalid) {
+			middleware.WriteErrorResponse(w, http.StatusBadRequest, err, invalid.Error())
+			return
+		}
+		log.Error().Err(err).Str("org_id", orgID).Msg("Failed to save plan override")
+		middleware.WriteErrorResponse(w, http.StatusInternalServerError, err, "Failed to save plan override")
+		return
+	}
+
+	h.audit.Record(r, audit.Event{
+		OrganizationID: orgID,
+		Action:         audit.ActionPlanOverrideSaved,
+		TargetType:     audit.TargetOrganization,
+		TargetID:       orgID,
+		Metadata: map[string]interface{}{
+			"limits":     override.Limits,
+			"features":   override.Features,
+			"note":       override.Note,
+			"expires_at": override.ExpiresAt,
+		},
+	})
+
+	h.GetEntitlements(w, r)
+}
+
+// DELETE /api/v1/admin/organizations/{orgId}/plan-override
+func (h *PlanHandler) DeletePlanOverride(w http.ResponseWriter, r *http.Request) {
+	orgID := mux.Vars(r)["orgId"]
+
+	ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
+	defer cancel()
+
+	if err := h.quotas.DeleteOverride(ctx, orgID); err != nil {
+		log.Error().Err(err).Str("org_id", orgID).Msg("Failed to delete plan override")
+		middleware.WriteErrorResponse(w, http.StatusInternalServerError, err, "Failed to delete plan override")
+		return
+	}
+
+	h.audit.Record(r, audit.Event{
+		OrganizationID: orgID,
+		Action:         audit.ActionPlanOverrideDeleted,
+		TargetType:     audit.TargetOrganization,
+		TargetID:       orgID,
+	})
+
+	middleware.WriteJSONResponse(w, http.StatusOK, map[string]string{"message": "Plan override removed"})
+}
@@ -0,0 +1,26 @@
+package handlers
+
+import (
+	"net/http"
+
+	"go-backend/authz"
+)
+
+// projectAccess is what a user may do on one project.
+type projectAccess = authz.ProjectAccess
+
+// projectAccessFrom returns the caller's access to the project in the path,
+// resolved by the authorizer for routes that require a project role.
+func projectAccessFrom(r *http.Request) *projectAccess {
+	return authz.FromContext(r.Context()).Project
+}
+
+// requireWritable refuses changes to a project the organization's plan has
+// made read-only.
+func requireWritable(w http.ResponseWriter, access *projectAccess) bool {
+	if access.ReadOnly {
+		http.Error(w, "Project is read-only on the organization's current plan", http.StatusForbidden)
+		return false
+	}
+	return true
+}
@@ -0,0 +1,362 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+
+	"go-backend/audit"
+	"go-backend/middleware"
+	"go-backend/models"
+	"go-backend/sqltools"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+	"github.com/jackc/pgx/v5"
+	"github.com/rs/zerolog/log"
+)
+
+const maskingRuleColumns = `id, project_id, schema_name, table_name, column_name, pattern, strategy, keep_chars, roles, created_by, created_at, updated_at`
+
+func scanMaskingRule(row pgx.Row) (*models.MaskingRule, error) {
+	var m models.MaskingRule
+	err := row.Scan(&m.ID, &m.ProjectID, &m.Schema, &m.Table, &m.Column, &m.Pattern, &m.Strategy,
+		&m.KeepChars, &m.Roles, &m.CreatedBy, &m.CreatedAt, &m.UpdatedAt)
+	if err != nil {
+		return nil, err
+	}
+	return &m, nil
+}
+
+// loadMasker builds a masker from the project's masking rules for a project
+// role. Rules for a table column are resolved in tx, the transaction the
+// query runs in, so they match the column under any alias; rules for tables
+// that do not exist fall back to matching the column name.
+func (h *SQLPlaygroundHandler) loadMasker(ctx context.Context, tx pgx.Tx, projectID, role string) (*sqltools.Masker, error) {
+	rows, err := h.db.Query(ctx, `
+		SELECT schema_name, table_name, column_name, pattern, strategy, keep_chars
+		FROM project_masking_rules
+		WHERE project_id = $1 AND $2 = ANY(roles)
+		ORDER BY created_at
+	`, projectID, role)
+	if err != nil {
+		return nil, err
+	}
+
+	type tableRule struct {
+		schema, table string
+		rule          sqltools.MaskRule
+	}
+	var tableRules []tableRule
+	masker := sqltools.NewMasker(projectID)
+	for rows.Next() {
+		var schema, table string
+		var rule sqltools.MaskRule
+		if err := rows.Scan(&schema, &table, &rule.Column, &rule.Pattern, &rule.Strategy, &rule.KeepChars); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		if table != "" {
+			tableRules = append(tableRules, tableRule{schema: schema, table: table, rule: rule})
+			continue
+		}
+		if err := masker.AddRule(rule); err != nil {
+			rows.Close()
+			return nil, err
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	for _, tr := range tableRules {
+		var src sqltools.SourceColumn
+		var attnum int16
+		err := tx.QueryRow(ctx, `
+			SELECT attrelid, attnum FROM pg_attribute
+			WHERE attrelid = to_regclass(format('%I.%I', $1::text, $2::text))
+				AND attname = $3 AND attnum > 0 AND NOT attisdropped
+		`, tr.schema, tr.table, tr.rule.Column).Scan(&src.TableOID, &attnum)
+		if errors.Is(err, pgx.ErrNoRows) {
+			if err := masker.AddRule(tr.rule); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		src.Attnum = uint16(attnum)
+		masker.AddSourceRule(src, tr.rule)
+	}
+	return masker, nil
+}
+
+// decodeMaskingRule reads and validates a masking rule from the request body.
+func decodeMaskingRule(w http.ResponseWriter, r *http.Request) (*models.MaskingRuleRequest, bool) {
+	var req models.MaskingRuleRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return nil, false
+	}
+
+	req.Schema = strings.TrimSpace(req.Schema)
+	req.Table = strings.TrimSpace(req.Table)
+	req.Column = strings.TrimSpace(req.Column)
+
+	switch {
+	case (req.Column == "") == (req.Pattern == ""):
+		http.Error(w, "Set either column or pattern", http.StatusBadRequest)
+		return nil, false
+	case req.Table != "" && req.Column == "":
+		http.Error(w, "Rules for a table need a column", http.StatusBadRequest)
+		return nil, false
+	case req.Schema != "" && req.Table == "":
+		http.Error(w, "table is required when schema is set", http.StatusBadRequest)
+		return nil, false
+	case !sqltools.ValidMaskStrategy(req.Strategy):
+		http.Error(w, "strategy must be redact, partial, hash or null", http.StatusBadRequest)
+		return nil, false
+	}
+
+	if req.Pattern != "" {
+		if _, err := sqltools.CompileMaskPattern(req.Pattern); err != nil {
+			http.Error(w, "pattern is not a valid regular expression", http.StatusBadRequest)
+			return nil, false
+		}
+	}
+	if req.Table != "" && req.Schema == "" {
+		req.Schema = sqltools.DefaultSchema
+	}
+
+	if req.KeepChars == nil {
+		keep := 4
+		req.KeepChars = &keep
+	}
+	if *req.KeepChars < 0 || *req.KeepChars > 16 {
+		http.Error(w, "keep_chars must be between 0 and 16", http.StatusBadRequest)
+		return nil, false
+	}
+
+	roles, ok := policyRoles(w, req.Roles)
+	if !ok {
+		return nil, false
+	}
+	req.Roles = roles
+	return &req, true
+}
+
+// maskingRuleMetadata describes m for the audit log.
+func maskingRuleMetadata(m *models.MaskingRule) map[string]interface{} {
+	return map[string]interface{}{
+		"schema": m.Schema, "table": m.Table, "column": m.Column, "pattern": m.Pattern,
+		"strategy": m.Strategy, "keep_chars": m.KeepChars, "roles": m.Roles,
+	}
+}
+
+// Like access policies, masking rules can be changed on read-only projects.
+
+// GET /api/v1/users/{userId}/organizations/{orgId}/projects/{projectId}/masking-rules
+func (h *ProjectHandler) GetMaskingRules(w http.ResponseWriter, r *http.Request) {
+	access := projectAccessFrom(r)
+
+	rows, err := h.db.Query(context.Background(), `
+		SELECT `+maskingRuleColumns+` FROM project_masking_rules
+		WHERE project_id = $1
+		ORDER BY created_at
+	`, access.ProjectID)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to query masking rules")
+		http.Error(w, "Failed to fetch masking rules", http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	rules := []models.MaskingRule{}
+	for rows.Next() {
+		m, err := scanMaskingRule(rows)
+		if err != nil {
+			log.Error().Err(err).Msg("Failed to scan masking rule")
+			continue
+		}
+		rules = append(rules, *m)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"data": rules,
+	})
+}
+
+// POST /api/v1/users/{userId}/organizations/{orgId}/projects/{projectId}/masking-rules
+func (h *ProjectHandler) CreateMaskingRule(w http.ResponseWriter, r *http.Request) {
+	access := projectAccessFrom(r)
+
+	req, ok := decodeMaskingRule(w, r)
+	if !ok {
+		return
+	}
+
+	now := time.Now()
+	rule, err := scanMaskingRule(h.db.QueryRow(context.Background(), `
+		INSERT INTO project_masking_rules
+		(id, project_id, schema_name, table_name, column_name, pattern, strategy, keep_chars, roles, created_by, created_at, updated_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $11)
+		RETURNING `+maskingRuleColumns,
+		uuid.New().String(), access.ProjectID, req.Schema, req.Table, req.Column, req.Pattern, req.Strategy,
+		*req.KeepChars, req.Roles, access.UserID, now))
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to create masking rule")
+		http.Error(w, "Failed to create masking rule", http.StatusInternalServerError)
+		return
+	}
+
+	h.recordProjectEvent(r, access, audit.ActionMaskingRuleChanged, audit.TargetMaskingRule, rule.ID, maskingRuleMetadata(rule))
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"data": rule,
+	})
+}
+
+// PUT /api/v1/users/{userId}/organizations/{orgId}/projects/{projectId}/masking-rules/{ruleId}
+func (h *ProjectHandler) UpdateMaskingRule(w http.ResponseWriter, r *http.Request) {
+	access := projectAccessFrom(r)
+
+	req, ok := decodeMaskingRule(w, r)
+	if !ok {
+		return
+	}
+
+	rule, err := scanMaskingRule(h.db.QueryRow(context.Background(), `
+		UPDATE project_masking_rules
+		SET schema_name = $1, table_name = $2, column_name = $3, pattern = $4, strategy = $5, keep_chars = $6, roles = $7
+		WHERE id = $8 AND project_id = $9
+		RETURNING `+maskingRuleColumns,
+		req.Schema, req.Table, req.Column, req.Pattern, req.Strategy, *req.KeepChars, req.Roles,
+		mux.Vars(r)["ruleId"], access.ProjectID))
+	if errors.Is(err, pgx.ErrNoRows) {
+		http.Error(w, "Masking rule not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to update masking rule")
+		http.Error(w, "Failed to update masking rule", http.StatusInternalServerError)
+		return
+	}
+
+	h.recordProjectEvent(r, access, audit.ActionMaskingRuleChanged, audit.TargetMaskingRule, rule.ID, maskingRuleMetadata(rule))
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"data": rule,
+	})
+}
+
+// DELETE /api/v1/users/{userId}/organizations/{orgId}/projects/{projectId}/masking-rules/{ruleId}
+func (h *ProjectHandler) DeleteMaskingRule(w http.ResponseWriter, r *http.Request) {
+	access := projectAccessFrom(r)
+
+	var id string
+	err := h.db.QueryRow(context.Background(), `
+		DELETE FROM project_masking_rules WHERE id = $1 AND project_id = $2 RETURNING id
+	`, mux.Vars(r)["ruleId"], access.ProjectID).Scan(&id)
+	if err != nil {
+		http.Error(w, "Masking rule not found", http.StatusNotFound)
+		return
+	}
+
+	h.recordProjectEvent(r, access, audit.ActionMaskingRuleDeleted, audit.TargetMaskingRule, id, nil)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"message": "Masking rule deleted successfully",
+	})
+}
+
+// GET /api/v1/users/{userId}/organizations/{orgId}/projects/{projectId}/masking-rules/suggestions
+//
+// Suggests masking rules for columns of the project's database that look
+// like personal data and no rule covers yet. Add ?refresh=true to reload the
+// schema.
+func (h *SQLPlaygroundHandler) GetMaskingSuggestions(w http.ResponseWriter, r *http.Request) {
+	access := projectAccessFrom(r)
+
+	ctx := r.Context()
+
+	if _, ok := h.projectPool(ctx, w, access); !ok {
+		return
+	}
+
+	cat, err := h.loadCatalog(ctx, *access.ConnectionUserID, r.URL.Query().Get("refresh") == "true")
+	if err != nil {
+		middleware.WriteErrorResponse(w, http.StatusInternalServerError, err, "Failed to load database catalog")
+		return
+	}
+
+	rows, err := h.db.Query(ctx, `
+		SELECT schema_name, table_name, column_name, pattern FROM project_masking_rules WHERE project_id = $1
+	`, access.ProjectID)
+	if err != nil {
+		middleware.WriteErrorResponse(w, http.StatusInternalServerError, err, "Failed to load masking rules")
+		return
+	}
+	defer rows.Close()
+
+	type existingRule struct {
+		schema, table, column string
+		pattern               *regexp.Regexp
+	}
+	var existing []existingRule
+	for rows.Next() {
+		var e existingRule
+		var pattern string
+		if err := rows.Scan(&e.schema, &e.table, &e.column, &pattern); err != nil {
+			middleware.WriteErrorResponse(w, http.StatusInternalServerError, err, "Failed to load masking rules")
+			return
+		}
+		if pattern != "" {
+			re, err := sqltools.CompileMaskPattern(pattern)
+			if err != nil {
+				continue
+			}
+			e.pattern = re
+		}
+		existing = append(existing, e)
+	}
+
+	covered := func(s sqltools.MaskSuggestion) bool {
+		for _, e := range existing {
+			switch {
+			case e.pattern != nil:
+				if e.pattern.MatchString(s.Column) {
+					return true
+				}
+			case e.table != "":
+				if e.schema == s.Schema && e.table == s.Table && e.column == s.Column {
+					return true
+				}
+			case strings.EqualFold(e.column, s.Column):
+				return true
+			}
+		}
+		return false
+	}
+
+	suggestions := []sqltools.MaskSuggestion{}
+	for _, s := range sqltools.SuggestMasking(cat) {
+		if !covered(s) {
+			suggestions = append(suggestions, s)
+		}
+	}
+
+	middleware.WriteJSONResponse(w, http.StatusOK, map[string]interface{}{
+		"suggestions": suggestions,
+	})
+}
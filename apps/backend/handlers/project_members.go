@@ -0,0 +1,248 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+
+	"go-backend/audit"
+	"go-backend/authz"
+	"go-backend/models"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+	"github.com/jackc/pgx/v5"
+	"github.com/rs/zerolog/log"
+)
+
+// GET /api/v1/users/{userId}/organizations/{orgId}/projects/{projectId}/members
+//
+// Lists everyone who can see the project with their effective role.
+func (h *ProjectHandler) GetProjectMembers(w http.ResponseWriter, r *http.Request) {
+	access := projectAccessFrom(r)
+
+	ctx := context.Background()
+
+	rows, err := h.db.Query(ctx, `
+		SELECT om.id, om.user_id, om.email, om.role, om.project_access, p.is_public,
+			COALESCE(pm.role, ''), pm.added_by, pm.created_at, pm.updated_at
+		FROM organization_members om
+		INNER JOIN projects p ON p.organization_id = om.organization_id
+		LEFT JOIN project_members pm ON pm.project_id = p.id AND pm.user_id = om.user_id
+		WHERE p.id = $1 AND om.status = 'active'
+		ORDER BY om.joined_at
+	`, access.ProjectID)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to query project members")
+		http.Error(w, "Failed to fetch project members", http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	members := []models.ProjectMember{}
+	for rows.Next() {
+		m := models.ProjectMember{ProjectID: access.ProjectID}
+		var orgRole, projectAccess, granted string
+		var isPublic bool
+		err := rows.Scan(&m.MemberID, &m.UserID, &m.Email, &orgRole, &projectAccess, &isPublic,
+			&granted, &m.AddedBy, &m.CreatedAt, &m.UpdatedAt)
+		if err != nil {
+			log.Error().Err(err).Msg("Failed to scan project member")
+			continue
+		}
+		m.Role = authz.EffectiveProjectRole(orgRole, projectAccess, granted, isPublic)
+		if m.Role == "" {
+			continue
+		}
+		m.Inherited = m.Role != granted
+		members = append(members, m)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"data": members,
+	})
+}
+
+// PUT /api/v1/users/{userId}/organizations/{orgId}/projects/{projectId}/members/{memberId}
+//
+// Grants an organization member a role on the project, replacing any
+// earlier grant.
+func (h *ProjectHandler) SetProjectMember(w http.ResponseWriter, r *http.Request) {
+	access := projectAccessFrom(r)
+	if !requireWritable(w, access) {
+		return
+	}
+
+	var req models.SetProjectMemberRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if _, ok := authz.ProjectRoleRank[req.Role]; !ok {
+		http.Error(w, "role must be viewer, editor or admin", http.StatusBadRequest)
+		return
+	}
+
+	ctx := context.Background()
+
+	member := models.ProjectMember{ProjectID: access.ProjectID, MemberID: mux.Vars(r)["memberId"], Role: req.Role}
+	var orgRole string
+	err := h.db.QueryRow(ctx, `
+		SELECT user_id, email, role FROM organization_members
+		WHERE id = $1 AND organization_id = $2 AND status = 'active'
+	`, member.MemberID, access.OrganizationID).Scan(&member.UserID, &member.Email, &orgRole)
+	if errors.Is(err, pgx.ErrNoRows) {
+		http.Error(w, "Member not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to fetch organization member")
+		http.Error(w, "Failed to update project member", http.StatusInternalServerError)
+		return
+	}
+	if orgRole == "owner" || orgRole == "admin" {
+		http.Error(w, "Organization owners and admins administer every project", http.StatusBadRequest)
+		return
+	}
+
+	now := time.Now()
+	err = h.db.QueryRow(ctx, `
+		INSERT INTO project_members (id, project_id, user_id, role, added_by, created_at, updated_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $6)
+		ON CONFLICT (project_id, user_id) DO UPDATE SET role = EXCLUDED.role, added_by = EXCLUDED.added_by
+		RETURNING added_by, created_at, updated_at
+	`, uuid.New().String(), access.ProjectID, member.UserID, req.Role, access.UserID, now).Scan(
+		&member.AddedBy, &member.CreatedAt, &member.UpdatedAt)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to save project member")
+		http.Error(w, "Failed to update project member", http.StatusInternalServerError)
+		return
+	}
+
+	h.recordProjectEvent(r, access, audit.ActionProjectMemberChanged, audit.TargetMember, member.MemberID,
+		map[string]interface{}{"user_id": member.UserID, "email": member.Email, "role": member.Role})
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"data": member,
+	})
+}
+
+// DELETE /api/v1/users/{userId}/organizations/{orgId}/projects/{projectId}/members/{memberId}
+//
+// Removes a member's project grant. Project admins can remove anyone; members
+// can remove their own grant. Members with access to all projects keep
+// viewing the project.
+func (h *ProjectHandler) RemoveProjectMember(w http.ResponseWriter, r *http.Request) {
+	access := projectAccessFrom(r)
+
+	ctx := context.Background()
+
+	var memberUserID string
+	err := h.db.QueryRow(ctx, `
+		SELECT user_id FROM organization_members WHERE id = $1 AND organization_id = $2
+	`, mux.Vars(r)["memberId"], access.OrganizationID).Scan(&memberUserID)
+	if errors.Is(err, pgx.ErrNoRows) {
+		http.Error(w, "Member not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to fetch organization member")
+		http.Error(w, "Failed to remove project member", http.StatusInternalServerError)
+		return
+	}
+
+	if memberUserID != access.UserID && !access.Allows(models.ProjectRoleAdmin) {
+		http.Error(w, "Insufficient permissions", http.StatusForbidden)
+		return
+	}
+
+	err = h.db.Exec(ctx, `
+		DELETE FROM project_members WHERE project_id = $1 AND user_id = $2
+	`, access.ProjectID, memberUserID)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to remove project member")
+		http.Error(w, "Failed to remove project member", http.StatusInternalServerError)
+		return
+	}
+
+	h.recordProjectEvent(r, access, audit.ActionProjectMemberRemoved, audit.TargetMember, mux.Vars(r)["memberId"],
+		map[string]interface{}{"user_id": memberUserID})
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"message": "Project member removed successfully",
+	})
+}
+
+// PUT /api/v1/users/{userId}/organizations/{orgId}/projects/{projectId}/connection
+//
+// Links the caller's saved database connection to the project. Everyone on
+// the project then queries that database through it, within their role.
+func (h *ProjectHandler) SetProjectConnection(w http.ResponseWriter, r *http.Request) {
+	access := projectAccessFrom(r)
+	if !requireWritable(w, access) {
+		return
+	}
+
+	ctx := context.Background()
+
+	var connectionType *string
+	err := h.db.QueryRow(ctx, `
+		SELECT active_connection_type FROM users WHERE user_id = $1
+	`, access.UserID).Scan(&connectionType)
+	if err != nil && !errors.Is(err, pgx.ErrNoRows) {
+		log.Error().Err(err).Msg("Failed to fetch connection type")
+		http.Error(w, "Failed to link database connection", http.StatusInternalServerError)
+		return
+	}
+	if connectionType == nil || *connectionType == "" {
+		http.Error(w, "Save a database connection before linking it to a project", http.StatusBadRequest)
+		return
+	}
+
+	err = h.db.Exec(ctx, `
+		UPDATE projects SET connection_user_id = $1, database_connected = TRUE, database_type = $2
+		WHERE id = $3
+	`, access.UserID, *connectionType, access.ProjectID)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to link database connection")
+		http.Error(w, "Failed to link database connection", http.StatusInternalServerError)
+		return
+	}
+
+	h.recordProjectEvent(r, access, audit.ActionProjectConnectionSet, audit.TargetProject, access.ProjectID,
+		map[string]interface{}{"connection_user_id": access.UserID, "database_type": *connectionType})
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"message":            "Database connection linked successfully",
+		"connection_user_id": access.UserID,
+		"database_type":      *connectionType,
+	})
+}
+
+// DELETE /api/v1/users/{userId}/organizations/{orgId}/projects/{projectId}/connection
+func (h *ProjectHandler) DeleteProjectConnection(w http.ResponseWriter, r *http.Request) {
+	access := projectAccessFrom(r)
+
+	err := h.db.Exec(context.Background(), `
+		UPDATE projects SET connection_user_id = NULL, database_connected = FALSE, database_type = NULL
+		WHERE id = $1
+	`, access.ProjectID)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to unlink database connection")
+		http.Error(w, "Failed to unlink database connection", http.StatusInternalServerError)
+		return
+	}
+
+	h.recordProjectEvent(r, access, audit.ActionProjectConnectionUnset, audit.TargetProject, access.ProjectID, nil)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"message": "Database connection unlinked successfully",
+	})
+}
@@ -0,0 +1,272 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"go-backend/audit"
+	"go-backend/authz"
+	"go-backend/database"
+	"go-backend/models"
+	"go-backend/sqltools"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+	"github.com/jackc/pgx/v5"
+	"github.com/rs/zerolog/log"
+)
+
+const policyColumns = `id, project_id, schema_name, table_name, column_name, action, roles, created_by, created_at, updated_at`
+
+func scanPolicy(row pgx.Row) (*models.AccessPolicy, error) {
+	var p models.AccessPolicy
+	err := row.Scan(&p.ID, &p.ProjectID, &p.Schema, &p.Table, &p.Column, &p.Action, &p.Roles,
+		&p.CreatedBy, &p.CreatedAt, &p.UpdatedAt)
+	if err != nil {
+		return nil, err
+	}
+	return &p, nil
+}
+
+// loadPolicies returns the project's policies that apply to a project role.
+func loadPolicies(ctx context.Context, db *database.PostgresDB, projectID, role string) (sqltools.PolicySet, error) {
+	rows, err := db.Query(ctx, `
+		SELECT schema_name, table_name, column_name, action
+		FROM project_access_policies
+		WHERE project_id = $1 AND $2 = ANY(roles)
+	`, projectID, role)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var policies sqltools.PolicySet
+	for rows.Next() {
+		var p sqltools.Policy
+		if err := rows.Scan(&p.Schema, &p.Table, &p.Column, &p.Action); err != nil {
+			return nil, err
+		}
+		policies = append(policies, p)
+	}
+	return policies, rows.Err()
+}
+
+// filterSchema drops the tables, views and columns the policies deny and
+// flags masked columns.
+func filterSchema(schema *SchemaInfo, ps sqltools.PolicySet) *SchemaInfo {
+	if len(ps) == 0 {
+		return schema
+	}
+
+	filterColumns := func(schemaName, table string, columns []ColumnInfo) []ColumnInfo {
+		visible := []ColumnInfo{}
+		for _, c := range columns {
+			switch ps.ColumnAction(schemaName, table, c.Name) {
+			case sqltools.PolicyDeny:
+				continue
+			case sqltools.PolicyMask:
+				c.Masked = true
+			}
+			visible = append(visible, c)
+		}
+		return visible
+	}
+
+	out := &SchemaInfo{}
+	for _, t := range schema.Tables {
+		if ps.RelationDenied(t.Schema, t.Name) {
+			continue
+		}
+		t.Columns = filterColumns(t.Schema, t.Name, t.Columns)
+		out.Tables = append(out.Tables, t)
+	}
+	for _, v := range schema.Views {
+		if ps.RelationDenied(v.Schema, v.Name) {
+			continue
+		}
+		v.Columns = filterColumns(v.Schema, v.Name, v.Columns)
+		out.Views = append(out.Views, v)
+	}
+	return out
+}
+
+// decodePolicy reads and validates a policy from the request body.
+func decodePolicy(w http.ResponseWriter, r *http.Request) (*models.AccessPolicyRequest, bool) {
+	var req models.AccessPolicyRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return nil, false
+	}
+
+	req.Schema = strings.TrimSpace(req.Schema)
+	req.Table = strings.TrimSpace(req.Table)
+	req.Column = strings.TrimSpace(req.Column)
+
+	switch {
+	case req.Schema == "":
+		http.Error(w, "schema is required", http.StatusBadRequest)
+		return nil, false
+	case req.Column != "" && req.Table == "":
+		http.Error(w, "table is required for a column policy", http.StatusBadRequest)
+		return nil, false
+	case req.Action != sqltools.PolicyDeny && req.Action != sqltools.PolicyMask:
+		http.Error(w, "action must be deny or mask", http.StatusBadRequest)
+		return nil, false
+	case req.Action == sqltools.PolicyMask && req.Column == "":
+		http.Error(w, "only columns can be masked", http.StatusBadRequest)
+		return nil, false
+	}
+
+	roles, ok := policyRoles(w, req.Roles)
+	if !ok {
+		return nil, false
+	}
+	req.Roles = roles
+	return &req, true
+}
+
+// policyRoles validates the project roles a policy or masking rule applies
+// to, defaulting to viewers and editors.
+func policyRoles(w http.ResponseWriter, roles []string) ([]string, bool) {
+	if len(roles) == 0 {
+		return []string{models.ProjectRoleViewer, models.ProjectRoleEditor}, true
+	}
+	for _, role := range roles {
+		if _, ok := authz.ProjectRoleRank[role]; !ok {
+			http.Error(w, "roles must be viewer, editor or admin", http.StatusBadRequest)
+			return nil, false
+		}
+	}
+	return roles, true
+}
+
+// Policies only ever narrow access, so unlike other project settings they
+// can be changed on read-only projects.
+
+// policyMetadata describes p for the audit log.
+func policyMetadata(p *models.AccessPolicy) map[string]interface{} {
+	return map[string]interface{}{
+		"schema": p.Schema, "table": p.Table, "column": p.Column, "action": p.Action, "roles": p.Roles,
+	}
+}
+
+// GET /api/v1/users/{userId}/organizations/{orgId}/projects/{projectId}/policies
+func (h *ProjectHandler) GetAccessPolicies(w http.ResponseWriter, r *http.Request) {
+	access := projectAccessFrom(r)
+
+	rows, err := h.db.Query(context.Background(), `
+		SELECT `+policyColumns+` FROM project_access_policies
+		WHERE project_id = $1
+		ORDER BY schema_name, table_name, column_name
+	`, access.ProjectID)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to query access policies")
+		http.Error(w, "Failed to fetch access policies", http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	policies := []models.AccessPolicy{}
+	for rows.Next() {
+		p, err := scanPolicy(rows)
+		if err != nil {
+			log.Error().Err(err).Msg("Failed to scan access policy")
+			continue
+		}
+		policies = append(policies, *p)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"data": policies,
+	})
+}
+
+// POST /api/v1/users/{userId}/organizations/{orgId}/projects/{projectId}/policies
+func (h *ProjectHandler) CreateAccessPolicy(w http.ResponseWriter, r *http.Request) {
+	access := projectAccessFrom(r)
+
+	req, ok := decodePolicy(w, r)
+	if !ok {
+		return
+	}
+
+	now := time.Now()
+	policy, err := scanPolicy(h.db.QueryRow(context.Background(), `
+		INSERT INTO project_access_policies
+		(id, project_id, schema_name, table_name, column_name, action, roles, created_by, created_at, updated_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $9)
+		RETURNING `+policyColumns,
+		uuid.New().String(), access.ProjectID, req.Schema, req.Table, req.Column, req.Action, req.Roles, access.UserID, now))
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to create access policy")
+		http.Error(w, "Failed to create access policy", http.StatusInternalServerError)
+		return
+	}
+
+	h.recordProjectEvent(r, access, audit.ActionAccessPolicyChanged, audit.TargetPolicy, policy.ID, policyMetadata(policy))
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"data": policy,
+	})
+}
+
+// PUT /api/v1/users/{userId}/organizations/{orgId}/projects/{projectId}/policies/{policyId}
+func (h *ProjectHandler) UpdateAccessPolicy(w http.ResponseWriter, r *http.Request) {
+	access := projectAccessFrom(r)
+
+	req, ok := decodePolicy(w, r)
+	if !ok {
+		return
+	}
+
+	policy, err := scanPolicy(h.db.QueryRow(context.Background(), `
+		UPDATE project_access_policies
+		SET schema_name = $1, table_name = $2, column_name = $3, action = $4, roles = $5
+		WHERE id = $6 AND project_id = $7
+		RETURNING `+policyColumns,
+		req.Schema, req.Table, req.Column, req.Action, req.Roles, mux.Vars(r)["policyId"], access.ProjectID))
+	if errors.Is(err, pgx.ErrNoRows) {
+		http.Error(w, "Access policy not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to update access policy")
+		http.Error(w, "Failed to update access policy", http.StatusInternalServerError)
+		return
+	}
+
+	h.recordProjectEvent(r, access, audit.ActionAccessPolicyChanged, audit.TargetPolicy, policy.ID, policyMetadata(policy))
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"data": policy,
+	})
+}
+
+// DELETE /api/v1/users/{userId}/organizations/{orgId}/projects/{projectId}/policies/{policyId}
+func (h *ProjectHandler) DeleteAccessPolicy(w http.ResponseWriter, r *http.Request) {
+	access := projectAccessFrom(r)
+
+	var id string
+	err := h.db.QueryRow(context.Background(), `
+		DELETE FROM project_access_policies WHERE id = $1 AND project_id = $2 RETURNING id
+	`, mux.Vars(r)["policyId"], access.ProjectID).Scan(&id)
+	if err != nil {
+		http.Error(w, "Access policy not found", http.StatusNotFound)
+		return
+	}
+
+	h.recordProjectEvent(r, access, audit.ActionAccessPolicyDeleted, audit.TargetPolicy, id, nil)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"message": "Access policy deleted successfully",
+	})
+}
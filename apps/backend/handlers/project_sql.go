@@ -0,0 +1,205 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"go-backend/middleware"
+	"go-backend/sqltools"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/rs/zerolog/log"
+)
+
+// projectPool returns the pool of the connection linked to the project. The
+// member who linked it must still be active in the organization.
+func (h *SQLPlaygroundHandler) projectPool(ctx context.Context, w http.ResponseWriter, access *projectAccess) (*pgxpool.Pool, bool) {
+	if access.ConnectionUserID == nil {
+		middleware.WriteErrorResponse(w, http.StatusConflict, fmt.Errorf("no connection"), "Project has no database connection")
+		return nil, false
+	}
+
+	var active bool
+	err := h.db.QueryRow(ctx, `
+		SELECT EXISTS(SELECT 1 FROM organization_members
+			WHERE organization_id = $1 AND user_id = $2 AND status = 'active')
+	`, access.OrganizationID, *access.ConnectionUserID).Scan(&active)
+	if err != nil {
+		middleware.WriteErrorResponse(w, http.StatusInternalServerError, err, "Failed to check project connection")
+		return nil, false
+	}
+	if !active {
+		middleware.WriteErrorResponse(w, http.StatusConflict, fmt.Errorf("connection owner left"), "The member who linked the project's database connection is no longer active")
+		return nil, false
+	}
+
+	pool, err := h.dbConfigHandler.GetUserDatabaseConnection(*access.ConnectionUserID)
+	if err != nil {
+		middleware.WriteErrorResponse(w, http.StatusBadRequest, err, "Failed to connect to the project's database")
+		return nil, false
+	}
+	return pool, true
+}
+
+// POST /api/v1/users/{userId}/organizations/{orgId}/projects/{projectId}/sql/execute
+//
+// Runs a query against the project's database in a read-only transaction,
+// whatever the caller's project role. Queries are rewritten to honour the
+// project's access policies for the caller's role, and their plan is checked
+// before they run. Result values are masked by the project's masking rules.
+func (h *SQLPlaygroundHandler) ExecuteProjectQuery(w http.ResponseWriter, r *http.Request) {
+	access := projectAccessFrom(r)
+
+	var req QueryRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		middleware.WriteErrorResponse(w, http.StatusBadRequest, err, "Invalid request body")
+		return
+	}
+
+	if req.SQL == "" {
+		middleware.WriteErrorResponse(w, http.StatusBadRequest, fmt.Errorf("missing SQL query"), "SQL query is required")
+		return
+	}
+
+	if h.isDangerousQuery(req.SQL) {
+		middleware.WriteErrorResponse(w, http.StatusBadRequest, fmt.Errorf("dangerous query detected"), "DROP, DELETE, TRUNCATE, and other destructive operations are restricted")
+		return
+	}
+
+	if req.Options.Limit == 0 {
+		req.Options.Limit = 1000
+	}
+	if req.Options.Timeout == 0 {
+		req.Options.Timeout = 30
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), time.Duration(req.Options.Timeout)*time.Second)
+	defer cancel()
+
+	pool, ok := h.projectPool(ctx, w, access)
+	if !ok {
+		return
+	}
+
+	tx, err := pool.BeginTx(ctx, pgx.TxOptions{AccessMode: pgx.ReadOnly})
+	if err != nil {
+		middleware.WriteErrorResponse(w, http.StatusBadRequest, err, "Failed to connect to the project's database")
+		return
+	}
+	defer tx.Rollback(context.Background())
+
+	query, ok := h.applyProjectPolicies(ctx, w, tx, access, req)
+	if !ok {
+		return
+	}
+
+	masker, err := h.loadMasker(ctx, tx, access.ProjectID, access.Role)
+	if err != nil {
+		middleware.WriteErrorResponse(w, http.StatusInternalServerError, err, "Failed to load masking rules")
+		return
+	}
+
+	startTime := time.Now()
+
+	result, err := h.executeSQL(ctx, tx, query, masker)
+	h.recordQuery(r, access.OrganizationID, access.ProjectID, *access.ConnectionUserID, req.SQL, result, err)
+	if err != nil {
+		log.Error().Err(err).Str("user_id", access.UserID).Str("project_id", access.ProjectID).Str("sql", req.SQL).Msg("Query execution failed")
+		middleware.WriteErrorResponse(w, http.StatusBadRequest, err, "Query execution failed")
+		return
+	}
+
+	result.ExecutionTime = float64(time.Since(startTime).Nanoseconds()) / 1e6
+
+	go h.logQueryExecution(access.UserID, req.SQL, result.RowCount, result.ExecutionTime)
+	go h.meterQueryExecution(access.UserID, access.OrganizationID, req.SQL, result.RowCount)
+
+	middleware.WriteJSONResponse(w, http.StatusOK, result)
+}
+
+// applyProjectPolicies rewrites req for the access policies that apply to the
+// caller and checks the rewritten query's plan in tx.
+func (h *SQLPlaygroundHandler) applyProjectPolicies(ctx context.Context, w http.ResponseWriter, tx pgx.Tx, access *projectAccess, req QueryRequest) (QueryRequest, bool) {
+	policies, err := loadPolicies(ctx, h.db, access.ProjectID, access.Role)
+	if err != nil {
+		middleware.WriteErrorResponse(w, http.StatusInternalServerError, err, "Failed to load access policies")
+		return req, false
+	}
+	if len(policies) == 0 {
+		return req, true
+	}
+
+	cat, err := h.loadCatalog(ctx, *access.ConnectionUserID, false)
+	if err != nil {
+		middleware.WriteErrorResponse(w, http.StatusInternalServerError, err, "Failed to load database catalog")
+		return req, false
+	}
+
+	// A per-query prefix keeps the query from naming its own scans like the
+	// rewrite's subqueries
+	aliasPrefix := "_policy_" + strings.ReplaceAll(uuid.New().String(), "-", "") + "_"
+
+	rewritten, err := sqltools.ApplyPolicies(req.SQL, cat, policies, aliasPrefix)
+	if err != nil {
+		var policyErr *sqltools.PolicyError
+		if errors.As(err, &policyErr) {
+			middleware.WriteErrorResponse(w, http.StatusForbidden, err, policyErr.Message)
+			return req, false
+		}
+		middleware.WriteErrorResponse(w, http.StatusBadRequest, err, "Query execution failed")
+		return req, false
+	}
+
+	var plan []byte
+	if err := tx.QueryRow(ctx, "EXPLAIN (VERBOSE, FORMAT JSON) "+rewritten, req.Params...).Scan(&plan); err != nil {
+		middleware.WriteErrorResponse(w, http.StatusBadRequest, err, "Query execution failed")
+		return req, false
+	}
+	if err := sqltools.VerifyPlan(plan, policies, aliasPrefix); err != nil {
+		var policyErr *sqltools.PolicyError
+		if errors.As(err, &policyErr) {
+			middleware.WriteErrorResponse(w, http.StatusForbidden, err, policyErr.Message)
+			return req, false
+		}
+		middleware.WriteErrorResponse(w, http.StatusInternalServerError, err, "Failed to check query plan")
+		return req, false
+	}
+
+	req.SQL = rewritten
+	return req, true
+}
+
+// GET /api/v1/users/{userId}/organizations/{orgId}/projects/{projectId}/sql/schema
+func (h *SQLPlaygroundHandler) GetProjectSchema(w http.ResponseWriter, r *http.Request) {
+	access := projectAccessFrom(r)
+
+	ctx, cancel := context.WithTimeout(r.Context(), 30*time.Second)
+	defer cancel()
+
+	pool, ok := h.projectPool(ctx, w, access)
+	if !ok {
+		return
+	}
+
+	schema, err := h.getDatabaseSchema(ctx, pool)
+	if err != nil {
+		log.Error().Err(err).Str("project_id", access.ProjectID).Msg("Failed to get database schema")
+		middleware.WriteErrorResponse(w, http.StatusInternalServerError, err, "Failed to retrieve database schema")
+		return
+	}
+
+	policies, err := loadPolicies(ctx, h.db, access.ProjectID, access.Role)
+	if err != nil {
+		middleware.WriteErrorResponse(w, http.StatusInternalServerError, err, "Failed to load access policies")
+		return
+	}
+
+	middleware.WriteJSONResponse(w, http.StatusOK, filterSchema(schema, policies))
+}
@@ -7,8 +7,11 @@ import (
 	"net/http"
 	"time"
 
+	"go-backend/audit"
+	"go-backend/authz"
 	"go-backend/database"
 	"go-backend/models"
+	"go-backend/quota"
 
 	"github.com/google/uuid"
 	"github.com/gorilla/mux"
@@ -16,11 +19,29 @@ import (
 )
 
 type ProjectHandler struct {
-	db *database.PostgresDB
+	db     *database.PostgresDB
+	quotas *quota.Service
+	audit  *audit.Logger
 }
 
-func NewProjectHandler(db *database.PostgresDB) *ProjectHandler {
-	return &ProjectHandler{db: db}
+func NewProjectHandler(db *database.PostgresDB, quotas *quota.Service, auditLog *audit.Logger) *ProjectHandler {
+	return &ProjectHandler{db: db, quotas: quotas, audit: auditLog}
+}
+
+// recordProjectEvent records a change to the project in access in the
+// organization's audit log.
+func (h *ProjectHandler) recordProjectEvent(r *http.Request, access *projectAccess, action, targetType, targetID string, metadata map[string]interface{}) {
+	if metadata == nil {
+		metadata = map[string]interface{}{}
+	}
+	metadata["project_id"] = access.ProjectID
+	h.audit.Record(r, audit.Event{
+		OrganizationID: access.OrganizationID,
+		Action:         action,
+		TargetType:     targetType,
+		TargetID:       targetID,
+		Metadata:       metadata,
+	})
 }
 
 // GET /api/v1/users/{userId}/organizations/{orgId}/projects
@@ -36,27 +57,29 @@ func (h *ProjectHandler) GetOrganizationProjects(w http.ResponseWriter, r *http.
 
 	ctx := context.Background()
 
-	// Check if user is member of organization
-	var memberCount int
+	// The policy has checked membership; project_access narrows the list
+	var orgRole, projectAccess string
 	err := h.db.QueryRow(ctx, `
-		SELECT COUNT(*) FROM organization_members 
+		SELECT role, project_access FROM organization_members 
 		WHERE organization_id = $1 AND user_id = $2 AND status = 'active'
-	`, orgID, userID).Scan(&memberCount)
+	`, orgID, userID).Scan(&orgRole, &projectAccess)
 
-	if err != nil || memberCount == 0 {
+	if err != nil {
 		http.Error(w, "Organization not found or access denied", http.StatusNotFound)
 		return
 	}
 
 	query := `
 		SELECT p.id, p.name, p.description, p.organization_id, p.created_at, p.updated_at, 
-			p.last_activity, p.database_connected, p.database_type, p.is_public
+			p.last_activity, p.database_connected, p.database_type, p.is_public, p.read_only,
+			p.connection_user_id, COALESCE(pm.role, '')
 		FROM projects p
+		LEFT JOIN project_members pm ON pm.project_id = p.id AND pm.user_id = $2
 		WHERE p.organization_id = $1
 		ORDER BY p.created_at DESC
 	`
 
-	rows, err := h.db.Query(ctx, query, orgID)
+	rows, err := h.db.Query(ctx, query, orgID, userID)
 	if err != nil {
 		log.Error().Err(err).Msg("Failed to query organization projects")
 		http.Error(w, "Failed to fetch projects", http.StatusInternalServerError)
@@ -67,15 +90,22 @@ func (h *ProjectHandler) GetOrganizationProjects(w http.ResponseWriter, r *http.
 	var projects []models.Project
 	for rows.Next() {
 		var project models.Project
+		var granted string
 		err := rows.Scan(
 			&project.ID, &project.Name, &project.Description, &project.OrganizationID,
 			&project.CreatedAt, &project.UpdatedAt, &project.LastActivity,
-			&project.DatabaseConnected, &project.DatabaseType, &project.IsPublic,
+			&project.DatabaseConnected, &project.DatabaseType, &project.IsPublic, &project.ReadOnly,
+			&project.ConnectionUserID, &granted,
 		)
 		if err != nil {
 			log.Error().Err(err).Msg("Failed to scan project")
 			continue
 		}
+		// Members with access to specific projects only see those
+		project.Role = authz.EffectiveProjectRole(orgRole, projectAccess, granted, project.IsPublic)
+		if project.Role == "" {
+			continue
+		}
 		projects = append(projects, project)
 	}
 
@@ -104,36 +134,14 @@ func (h *ProjectHandler) CreateProject(w http.ResponseWriter, r *http.Request) {
 
 	ctx := context.Background()
 
-	// Check if user is member of organization
-	var memberCount int
-	err := h.db.QueryRow(ctx, `
-		SELECT COUNT(*) FROM organization_members 
-		WHERE organization_id = $1 AND user_id = $2 AND status = 'active'
-	`, orgID, userID).Scan(&memberCount)
-
-	if err != nil || memberCount == 0 {
-		http.Error(w, "Organization not found or access denied", http.StatusForbidden)
-		return
-	}
+	orgRole := authz.FromContext(r.Context()).Target.OrgRole
 
 	// Check organization project limit
-	var projectCount, projectLimit int
-	h.db.QueryRow(ctx, "SELECT COUNT(*) FROM projects WHERE organization_id = $1", orgID).Scan(&projectCount)
-	
-	var plan string
-	h.db.QueryRow(ctx, "SELECT plan FROM organizations WHERE id = $1", orgID).Scan(&plan)
-	
-	switch plan {
-	case "pro":
-		projectLimit = 25
-	case "enterprise":
-		projectLimit = 100
-	default:
-		projectLimit = 2
-	}
-
-	if projectCount >= projectLimit {
-		http.Error(w, "Project limit reached for your plan", http.StatusForbidden)
+	if _, err := h.quotas.Check(ctx, quota.OrganizationSubject(orgID), quota.Projects, 1); err != nil {
+		if !quota.WriteError(w, err) {
+			log.Error().Err(err).Msg("Failed to check project quota")
+			http.Error(w, "Failed to create project", http.StatusInternalServerError)
+		}
 		return
 	}
 
@@ -145,7 +153,15 @@ func (h *ProjectHandler) CreateProject(w http.ResponseWriter, r *http.Request) {
 		isPublic = *req.IsPublic
 	}
 
-	err = h.db.Exec(ctx, `
+	tx, err := h.db.GetPool().Begin(ctx)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to begin transaction")
+		http.Error(w, "Failed to create project", http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback(ctx)
+
+	_, err = tx.Exec(ctx, `
 		INSERT INTO projects (id, name, description, organization_id, created_at, updated_at, 
 			database_connected, is_public)
 		VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
@@ -157,6 +173,27 @@ func (h *ProjectHandler) CreateProject(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// Organization owners and admins already administer every project; other
+	// members administer the projects they create
+	if orgRole != "owner" && orgRole != "admin" {
+		_, err = tx.Exec(ctx, `
+			INSERT INTO project_members (id, project_id, user_id, role, added_by, created_at, updated_at)
+			VALUES ($1, $2, $3, $4, $3, $5, $5)
+		`, uuid.New().String(), projectID, userID, models.ProjectRoleAdmin, now)
+
+		if err != nil {
+			log.Error().Err(err).Msg("Failed to add project creator")
+			http.Error(w, "Failed to create project", http.StatusInternalServerError)
+			return
+		}
+	}
+
+	if err = tx.Commit(ctx); err != nil {
+		log.Error().Err(err).Msg("Failed to commit transaction")
+		http.Error(w, "Failed to create project", http.StatusInternalServerError)
+		return
+	}
+
 	project := models.Project{
 		ID:                projectID,
 		Name:              req.Name,
@@ -166,6 +203,7 @@ func (h *ProjectHandler) CreateProject(w http.ResponseWriter, r *http.Request) {
 		UpdatedAt:         now,
 		DatabaseConnected: false,
 		IsPublic:          isPublic,
+		Role:              models.ProjectRoleAdmin,
 	}
 
 	w.Header().Set("Content-Type", "application/json")
@@ -177,43 +215,24 @@ func (h *ProjectHandler) CreateProject(w http.ResponseWriter, r *http.Request) {
 
 // GET /api/v1/users/{userId}/organizations/{orgId}/projects/{projectId}
 func (h *ProjectHandler) GetProject(w http.ResponseWriter, r *http.Request) {
-	vars := mux.Vars(r)
-	userID := vars["userId"]
-	orgID := vars["orgId"]
-	projectID := vars["projectId"]
-
-	if userID == "" || orgID == "" || projectID == "" {
-		http.Error(w, "User ID, Organization ID, and Project ID are required", http.StatusBadRequest)
-		return
-	}
+	access := projectAccessFrom(r)
 
 	ctx := context.Background()
 
-	// Check if user has access to the project
-	var accessCount int
-	err := h.db.QueryRow(ctx, `
-		SELECT COUNT(*) FROM organization_members om
-		INNER JOIN projects p ON p.organization_id = om.organization_id
-		WHERE om.user_id = $1 AND om.status = 'active' AND p.id = $2
-	`, userID, projectID).Scan(&accessCount)
-
-	if err != nil || accessCount == 0 {
-		http.Error(w, "Project not found or access denied", http.StatusNotFound)
-		return
-	}
-
 	query := `
 		SELECT p.id, p.name, p.description, p.organization_id, p.created_at, p.updated_at, 
-			p.last_activity, p.database_connected, p.database_type, p.is_public
+			p.last_activity, p.database_connected, p.database_type, p.is_public, p.read_only,
+			p.connection_user_id
 		FROM projects p
 		WHERE p.id = $1 AND p.organization_id = $2
 	`
 
 	var project models.Project
-	err = h.db.QueryRow(ctx, query, projectID, orgID).Scan(
+	err := h.db.QueryRow(ctx, query, access.ProjectID, access.OrganizationID).Scan(
 		&project.ID, &project.Name, &project.Description, &project.OrganizationID,
 		&project.CreatedAt, &project.UpdatedAt, &project.LastActivity,
-		&project.DatabaseConnected, &project.DatabaseType, &project.IsPublic,
+		&project.DatabaseConnected, &project.DatabaseType, &project.IsPublic, &project.ReadOnly,
+		&project.ConnectionUserID,
 	)
 
 	if err != nil {
@@ -221,6 +240,7 @@ func (h *ProjectHandler) GetProject(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "Project not found", http.StatusNotFound)
 		return
 	}
+	project.Role = access.Role
 
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(map[string]interface{}{
@@ -230,15 +250,7 @@ func (h *ProjectHandler) GetProject(w http.ResponseWriter, r *http.Request) {
 
 // PUT /api/v1/users/{userId}/organizations/{orgId}/projects/{projectId}
 func (h *ProjectHandler) UpdateProject(w http.ResponseWriter, r *http.Request) {
-	vars := mux.Vars(r)
-	userID := vars["userId"]
-	orgID := vars["orgId"]
-	projectID := vars["projectId"]
-
-	if userID == "" || orgID == "" || projectID == "" {
-		http.Error(w, "User ID, Organization ID, and Project ID are required", http.StatusBadRequest)
-		return
-	}
+	access := projectAccessFrom(r)
 
 	var req models.UpdateProjectRequest
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
@@ -248,16 +260,8 @@ func (h *ProjectHandler) UpdateProject(w http.ResponseWriter, r *http.Request) {
 
 	ctx := context.Background()
 
-	// Check if user has access to the project
-	var role string
-	err := h.db.QueryRow(ctx, `
-		SELECT om.role FROM organization_members om
-		INNER JOIN projects p ON p.organization_id = om.organization_id
-		WHERE om.user_id = $1 AND om.status = 'active' AND p.id = $2
-	`, userID, projectID).Scan(&role)
-
-	if err != nil || (role != "owner" && role != "admin") {
-		http.Error(w, "Insufficient permissions", http.StatusForbidden)
+	// Projects over the plan's limit after a downgrade can be viewed but not changed
+	if !requireWritable(w, access) {
 		return
 	}
 
@@ -295,14 +299,14 @@ func (h *ProjectHandler) UpdateProject(w http.ResponseWriter, r *http.Request) {
 	argIndex++
 
 	// Add WHERE clause parameters
-	args = append(args, projectID, orgID)
+	args = append(args, access.ProjectID, access.OrganizationID)
 
 	query := fmt.Sprintf(`
 		UPDATE projects SET %s
 		WHERE id = $%d AND organization_id = $%d
 	`, joinStrings(setParts, ", "), argIndex, argIndex+1)
 
-	err = h.db.Exec(ctx, query, args...)
+	err := h.db.Exec(ctx, query, args...)
 	if err != nil {
 		log.Error().Err(err).Msg("Failed to update project")
 		http.Error(w, "Failed to update project", http.StatusInternalServerError)
@@ -0,0 +1,163 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"time"
+
+	"go-backend/models"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+	"github.com/rs/zerolog/log"
+)
+
+// decodeSavedQuery reads and validates a saved query from the request body.
+func decodeSavedQuery(w http.ResponseWriter, r *http.Request) (*models.SavedQueryRequest, bool) {
+	var req models.SavedQueryRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return nil, false
+	}
+	req.Name = strings.TrimSpace(req.Name)
+	if req.Name == "" || len(req.Name) > 200 {
+		http.Error(w, "name is required and must be at most 200 characters", http.StatusBadRequest)
+		return nil, false
+	}
+	if strings.TrimSpace(req.SQL) == "" {
+		http.Error(w, "sql is required", http.StatusBadRequest)
+		return nil, false
+	}
+	return &req, true
+}
+
+// GET /api/v1/users/{userId}/organizations/{orgId}/projects/{projectId}/queries
+func (h *ProjectHandler) GetSavedQueries(w http.ResponseWriter, r *http.Request) {
+	access := projectAccessFrom(r)
+
+	rows, err := h.db.Query(context.Background(), `
+		SELECT id, project_id, name, sql, description, created_by, created_at, updated_at
+		FROM saved_queries
+		WHERE project_id = $1
+		ORDER BY name
+	`, access.ProjectID)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to query saved queries")
+		http.Error(w, "Failed to fetch saved queries", http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	queries := []models.SavedQuery{}
+	for rows.Next() {
+		var q models.SavedQuery
+		err := rows.Scan(&q.ID, &q.ProjectID, &q.Name, &q.SQL, &q.Description,
+			&q.CreatedBy, &q.CreatedAt, &q.UpdatedAt)
+		if err != nil {
+			log.Error().Err(err).Msg("Failed to scan saved query")
+			continue
+		}
+		queries = append(queries, q)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"data": queries,
+	})
+}
+
+// POST /api/v1/users/{userId}/organizations/{orgId}/projects/{projectId}/queries
+func (h *ProjectHandler) CreateSavedQuery(w http.ResponseWriter, r *http.Request) {
+	access := projectAccessFrom(r)
+	if !requireWritable(w, access) {
+		return
+	}
+
+	req, ok := decodeSavedQuery(w, r)
+	if !ok {
+		return
+	}
+
+	now := time.Now()
+	query := models.SavedQuery{
+		ID:          uuid.New().String(),
+		ProjectID:   access.ProjectID,
+		Name:        req.Name,
+		SQL:         req.SQL,
+		Description: req.Description,
+		CreatedBy:   &access.UserID,
+		CreatedAt:   now,
+		UpdatedAt:   now,
+	}
+
+	err := h.db.Exec(context.Background(), `
+		INSERT INTO saved_queries (id, project_id, name, sql, description, created_by, created_at, updated_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+	`, query.ID, query.ProjectID, query.Name, query.SQL, query.Description, query.CreatedBy, query.CreatedAt, query.UpdatedAt)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to create saved query")
+		http.Error(w, "Failed to create saved query", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"data": query,
+	})
+}
+
+// PUT /api/v1/users/{userId}/organizations/{orgId}/projects/{projectId}/queries/{queryId}
+func (h *ProjectHandler) UpdateSavedQuery(w http.ResponseWriter, r *http.Request) {
+	access := projectAccessFrom(r)
+	if !requireWritable(w, access) {
+		return
+	}
+
+	req, ok := decodeSavedQuery(w, r)
+	if !ok {
+		return
+	}
+
+	var query models.SavedQuery
+	err := h.db.QueryRow(context.Background(), `
+		UPDATE saved_queries SET name = $1, sql = $2, description = $3
+		WHERE id = $4 AND project_id = $5
+		RETURNING id, project_id, name, sql, description, created_by, created_at, updated_at
+	`, req.Name, req.SQL, req.Description, mux.Vars(r)["queryId"], access.ProjectID).Scan(
+		&query.ID, &query.ProjectID, &query.Name, &query.SQL, &query.Description,
+		&query.CreatedBy, &query.CreatedAt, &query.UpdatedAt)
+	if err != nil {
+		http.Error(w, "Saved query not found", http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"data": query,
+	})
+}
+
+// DELETE /api/v1/users/{userId}/organizations/{orgId}/projects/{projectId}/queries/{queryId}
+func (h *ProjectHandler) DeleteSavedQuery(w http.ResponseWriter, r *http.Request) {
+	access := projectAccessFrom(r)
+	if !requireWritable(w, access) {
+		return
+	}
+
+	var id string
+	err := h.db.QueryRow(context.Background(), `
+		DELETE FROM saved_queries WHERE id = $1 AND project_id = $2 RETURNING id
+	`, mux.Vars(r)["queryId"], access.ProjectID).Scan(&id)
+	if err != nil {
+		http.Error(w, "Saved query not found", http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"message": "Saved query deleted successfully",
+	})
+}
@@ -0,0 +1,729 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"go-backend/database"
+	"go-backend/middleware"
+	"go-backend/models"
+	"go-backend/sqltools"
+
+	"github.com/gorilla/mux"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	defaultSampleValues = 3
+	maxSampleValues     = 10
+	maxSampleLength     = 80
+)
+
+type SchemaDocsHandler struct {
+	db              *database.PostgresDB
+	redis           *database.RedisClient
+	dbConfigHandler *DatabaseConfigHandler
+	playground      *SQLPlaygroundHandler
+}
+
+// DataDictionary is the documented view of a connected database: every table,
+// view and column with constraints, catalog comments, the project's
+// descriptions and sample values.
+type DataDictionary struct {
+	GeneratedAt time.Time  `json:"generated_at"`
+	Database    string     `json:"database"`
+	Tables      []DocTable `json:"tables"`
+}
+
+type DocTable struct {
+	Schema      string          `json:"schema"`
+	Name        string          `json:"name"`
+	Kind        string          `json:"kind"`
+	Comment     string          `json:"comment,omitempty"`
+	Description string          `json:"description,omitempty"`
+	RowEstimate int64           `json:"row_estimate"`
+	Columns     []DocColumn     `json:"columns"`
+	Constraints []DocConstraint `json:"constraints,omitempty"`
+}
+
+type DocColumn struct {
+	Name         string   `json:"name"`
+	Type         string   `json:"type"`
+	Nullable     bool     `json:"nullable"`
+	DefaultValue string   `json:"default_value,omitempty"`
+	IsPrimaryKey bool     `json:"is_primary_key"`
+	IsUnique     bool     `json:"is_unique"`
+	References   string   `json:"references,omitempty"`
+	Comment      string   `json:"comment,omitempty"`
+	Description  string   `json:"description,omitempty"`
+	SampleValues []string `json:"sample_values,omitempty"`
+	Masked       bool     `json:"masked,omitempty"`
+}
+
+type DocConstraint struct {
+	Name       string   `json:"name"`
+	Type       string   `json:"type"`
+	Columns    []string `json:"columns"`
+	Definition string   `json:"definition"`
+}
+
+// Summary returns the best available description of the table: the
+// team-maintained one if present, otherwise the catalog comment.
+func (t DocTable) Summary() string {
+	if t.Description != "" {
+		return t.Description
+	}
+	return t.Comment
+}
+
+// Summary returns the best available description of the column.
+func (c DocColumn) Summary() string {
+	if c.Description != "" {
+		return c.Description
+	}
+	return c.Comment
+}
+
+func NewSchemaDocsHandler(db *database.PostgresDB, redis *database.RedisClient, dbConfigHandler *DatabaseConfigHandler, playground *SQLPlaygroundHandler) *SchemaDocsHandler {
+	return &SchemaDocsHandler{
+		db:              db,
+		redis:           redis,
+		dbConfigHandler: dbConfigHandler,
+		playground:      playground,
+	}
+}
+
+// GetDataDictionary renders the data dictionary of the user's database as
+// JSON (default), Markdown or static HTML depending on the format parameter.
+// Descriptions belong to projects, so only catalog comments are shown.
+func (h *SchemaDocsHandler) GetDataDictionary(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	userID := vars["user_id"]
+
+	format, ok := dictionaryFormat(w, r)
+	if !ok {
+		return
+	}
+
+	samples := defaultSampleValues
+	if s := r.URL.Query().Get("samples"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n < 0 {
+			middleware.WriteErrorResponse(w, http.StatusBadRequest, fmt.Errorf("invalid samples"), "samples must be a non-negative integer")
+			return
+		}
+		samples = min(n, maxSampleValues)
+	}
+
+	userPool, err := h.dbConfigHandler.GetUserDatabaseConnection(userID)
+	if err != nil {
+		middleware.WriteErrorResponse(w, http.StatusBadRequest, err, "Failed to connect to your database")
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 60*time.Second)
+	defer cancel()
+
+	dictionary, err := h.buildDataDictionary(ctx, userPool, "", samples)
+	if err != nil {
+		log.Error().Err(err).Str("user_id", userID).Msg("Failed to build data dictionary")
+		middleware.WriteErrorResponse(w, http.StatusInternalServerError, err, "Failed to generate schema documentation")
+		return
+	}
+
+	writeDictionary(w, r, format, dictionary)
+}
+
+// GET /api/v1/users/{userId}/organizations/{orgId}/projects/{projectId}/sql/docs
+//
+// Renders the data dictionary of the project's database with the project's
+// descriptions. Tables and columns the caller's access policies deny are left
+// out. There are no sample values: they would be read past the policies and
+// masking rules.
+func (h *SchemaDocsHandler) GetProjectDataDictionary(w http.ResponseWriter, r *http.Request) {
+	access := projectAccessFrom(r)
+
+	format, ok := dictionaryFormat(w, r)
+	if !ok {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 60*time.Second)
+	defer cancel()
+
+	pool, ok := h.playground.projectPool(ctx, w, access)
+	if !ok {
+		return
+	}
+
+	policies, err := loadPolicies(ctx, h.db, access.ProjectID, access.Role)
+	if err != nil {
+		middleware.WriteErrorResponse(w, http.StatusInternalServerError, err, "Failed to load access policies")
+		return
+	}
+
+	dictionary, err := h.buildDataDictionary(ctx, pool, access.ProjectID, 0)
+	if err != nil {
+		log.Error().Err(err).Str("project_id", access.ProjectID).Msg("Failed to build data dictionary")
+		middleware.WriteErrorResponse(w, http.StatusInternalServerError, err, "Failed to generate schema documentation")
+		return
+	}
+
+	writeDictionary(w, r, format, filterDictionary(dictionary, policies))
+}
+
+// dictionaryFormat reads the format parameter: json (default), markdown or
+// html.
+func dictionaryFormat(w http.ResponseWriter, r *http.Request) (string, bool) {
+	format := strings.ToLower(r.URL.Query().Get("format"))
+	if format == "" {
+		format = "json"
+	}
+	if format != "json" && format != "markdown" && format != "html" {
+		middleware.WriteErrorResponse(w, http.StatusBadRequest, fmt.Errorf("invalid format"), "supported formats: json, markdown, html")
+		return "", false
+	}
+	return format, true
+}
+
+func writeDictionary(w http.ResponseWriter, r *http.Request, format string, dictionary *DataDictionary) {
+	download := r.URL.Query().Get("download") == "true"
+
+	switch format {
+	case "markdown":
+		writeDocument(w, "text/markdown; charset=utf-8", "data-dictionary.md", download, renderMarkdown(dictionary))
+	case "html":
+		body, err := renderHTML(dictionary)
+		if err != nil {
+			log.Error().Err(err).Msg("Failed to render data dictionary")
+			middleware.WriteErrorResponse(w, http.StatusInternalServerError, err, "Failed to render schema documentation")
+			return
+		}
+		writeDocument(w, "text/html; charset=utf-8", "data-dictionary.html", download, body)
+	default:
+		middleware.WriteJSONResponse(w, http.StatusOK, dictionary)
+	}
+}
+
+// filterDictionary drops the tables, columns and constraints the policies
+// deny and flags masked columns, as filterSchema does for the schema.
+func filterDictionary(d *DataDictionary, ps sqltools.PolicySet) *DataDictionary {
+	if len(ps) == 0 {
+		return d
+	}
+
+	referenceDenied := func(ref string) bool {
+		schema, table, ok := strings.Cut(ref, ".")
+		return ok && ps.RelationDenied(schema, table)
+	}
+
+	out := &DataDictionary{GeneratedAt: d.GeneratedAt, Database: d.Database, Tables: []DocTable{}}
+	for _, t := range d.Tables {
+		if ps.RelationDenied(t.Schema, t.Name) {
+			continue
+		}
+
+		columns := []DocColumn{}
+		denied := map[string]bool{}
+		for _, c := range t.Columns {
+			switch ps.ColumnAction(t.Schema, t.Name, c.Name) {
+			case sqltools.PolicyDeny:
+				denied[c.Name] = true
+				continue
+			case sqltools.PolicyMask:
+				c.Masked = true
+			}
+			if referenceDenied(c.References) {
+				c.References = ""
+			}
+			columns = append(columns, c)
+		}
+		t.Columns = columns
+
+		var constraints []DocConstraint
+		for _, con := range t.Constraints {
+			if constraintDenied(con, denied, t.Columns, referenceDenied) {
+				continue
+			}
+			constraints = append(constraints, con)
+		}
+		t.Constraints = constraints
+
+		out.Tables = append(out.Tables, t)
+	}
+	return out
+}
+
+// constraintDenied reports whether a constraint names a denied column, or is
+// a foreign key to a denied table, so its definition would reveal it.
+func constraintDenied(con DocConstraint, denied map[string]bool, columns []DocColumn, referenceDenied func(string) bool) bool {
+	for _, name := range con.Columns {
+		if denied[name] {
+			return true
+		}
+	}
+	if con.Type != "FOREIGN KEY" {
+		return false
+	}
+	for _, c := range columns {
+		// References was cleared above when the referenced table is denied
+		if containsString(con.Columns, c.Name) && c.References == "" {
+			return true
+		}
+	}
+	return false
+}
+
+// GET /api/v1/users/{userId}/organizations/{orgId}/projects/{projectId}/sql/docs/descriptions
+//
+// Lists the project's descriptions, leaving out those of tables and columns
+// the caller's access policies deny.
+func (h *SchemaDocsHandler) GetSchemaDescriptions(w http.ResponseWriter, r *http.Request) {
+	access := projectAccessFrom(r)
+
+	ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
+	defer cancel()
+
+	policies, err := loadPolicies(ctx, h.db, access.ProjectID, access.Role)
+	if err != nil {
+		middleware.WriteErrorResponse(w, http.StatusInternalServerError, err, "Failed to load access policies")
+		return
+	}
+
+	descriptions, err := h.loadDescriptions(ctx, access.ProjectID)
+	if err != nil {
+		log.Error().Err(err).Str("project_id", access.ProjectID).Msg("Failed to get schema descriptions")
+		middleware.WriteErrorResponse(w, http.StatusInternalServerError, err, "Failed to retrieve schema descriptions")
+		return
+	}
+
+	visible := []models.SchemaDescription{}
+	for _, desc := range descriptions {
+		if desc.ColumnName == "" && policies.RelationDenied(desc.SchemaName, desc.TableName) {
+			continue
+		}
+		if desc.ColumnName != "" && policies.ColumnAction(desc.SchemaName, desc.TableName, desc.ColumnName) == sqltools.PolicyDeny {
+			continue
+		}
+		visible = append(visible, desc)
+	}
+
+	middleware.WriteJSONResponse(w, http.StatusOK, map[string]interface{}{
+		"data": visible,
+	})
+}
+
+// PUT /api/v1/users/{userId}/organizations/{orgId}/projects/{projectId}/sql/docs/descriptions
+//
+// Stores a description for a table or column of the project's database. An
+// empty description removes the stored entry so the catalog comment shows
+// again.
+func (h *SchemaDocsHandler) UpsertSchemaDescription(w http.ResponseWriter, r *http.Request) {
+	access := projectAccessFrom(r)
+	if !requireWritable(w, access) {
+		return
+	}
+
+	var req models.UpsertSchemaDescriptionRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		middleware.WriteErrorResponse(w, http.StatusBadRequest, err, "Invalid request body")
+		return
+	}
+
+	if req.Schema == "" || req.Table == "" {
+		middleware.WriteErrorResponse(w, http.StatusBadRequest, fmt.Errorf("missing required fields"), "schema and table are required")
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
+	defer cancel()
+
+	if strings.TrimSpace(req.Description) == "" {
+		err := h.db.Exec(ctx, `
+			DELETE FROM schema_descriptions
+			WHERE project_id = $1 AND schema_name = $2 AND table_name = $3 AND column_name = $4
+		`, access.ProjectID, req.Schema, req.Table, req.Column)
+		if err != nil {
+			log.Error().Err(err).Str("project_id", access.ProjectID).Msg("Failed to delete schema description")
+			middleware.WriteErrorResponse(w, http.StatusInternalServerError, err, "Failed to delete schema description")
+			return
+		}
+
+		middleware.WriteJSONResponse(w, http.StatusOK, map[string]interface{}{
+			"message": "Schema description removed",
+		})
+		return
+	}
+
+	var desc models.SchemaDescription
+	err := h.db.QueryRow(ctx, `
+		INSERT INTO schema_descriptions (project_id, schema_name, table_name, column_name, description, updated_by, created_at, updated_at)
+		VALUES ($1, $2, $3, $4, $5, $6, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP)
+		ON CONFLICT (project_id, schema_name, table_name, column_name)
+		DO UPDATE SET
+			description = EXCLUDED.description,
+			updated_by = EXCLUDED.updated_by,
+			updated_at = CURRENT_TIMESTAMP
+		RETURNING id, project_id, schema_name, table_name, column_name, description, updated_by, created_at, updated_at
+	`, access.ProjectID, req.Schema, req.Table, req.Column, req.Description, access.UserID).Scan(
+		&desc.ID, &desc.ProjectID, &desc.SchemaName, &desc.TableName, &desc.ColumnName,
+		&desc.Description, &desc.UpdatedBy, &desc.CreatedAt, &desc.UpdatedAt,
+	)
+
+	if err != nil {
+		log.Error().Err(err).Str("project_id", access.ProjectID).Msg("Failed to save schema description")
+		middleware.WriteErrorResponse(w, http.StatusInternalServerError, err, "Failed to save schema description")
+		return
+	}
+
+	middleware.WriteJSONResponse(w, http.StatusOK, map[string]interface{}{
+		"data": desc,
+	})
+}
+
+func (h *SchemaDocsHandler) loadDescriptions(ctx context.Context, projectID string) ([]models.SchemaDescription, error) {
+	rows, err := h.db.Query(ctx, `
+		SELECT id, project_id, schema_name, table_name, column_name, description, updated_by, created_at, updated_at
+		FROM schema_descriptions
+		WHERE project_id = $1
+		ORDER BY schema_name, table_name, column_name
+	`, projectID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query schema descriptions: %w", err)
+	}
+	defer rows.Close()
+
+	var descriptions []models.SchemaDescription
+	for rows.Next() {
+		var desc models.SchemaDescription
+		if err := rows.Scan(
+			&desc.ID, &desc.ProjectID, &desc.SchemaName, &desc.TableName, &desc.ColumnName,
+			&desc.Description, &desc.UpdatedBy, &desc.CreatedAt, &desc.UpdatedAt,
+		); err != nil {
+			log.Error().Err(err).Msg("Failed to scan schema description")
+			continue
+		}
+		descriptions = append(descriptions, desc)
+	}
+
+	return descriptions, rows.Err()
+}
+
+// buildDataDictionary documents the database behind pool, with the
+// descriptions of projectID when it is set.
+func (h *SchemaDocsHandler) buildDataDictionary(ctx context.Context, pool *pgxpool.Pool, projectID string, samples int) (*DataDictionary, error) {
+	dictionary := &DataDictionary{
+		GeneratedAt: time.Now().UTC(),
+	}
+
+	if err := pool.QueryRow(ctx, "SELECT current_database()").Scan(&dictionary.Database); err != nil {
+		return nil, fmt.Errorf("failed to get database name: %w", err)
+	}
+
+	tables, err := h.getDocTables(ctx, pool)
+	if err != nil {
+		return nil, err
+	}
+
+	index := make(map[string]*DocTable, len(tables))
+	for i := range tables {
+		index[tables[i].Schema+"."+tables[i].Name] = &tables[i]
+	}
+
+	if err := h.attachDocColumns(ctx, pool, index); err != nil {
+		return nil, err
+	}
+
+	if err := h.attachDocConstraints(ctx, pool, index); err != nil {
+		return nil, err
+	}
+
+	var descriptions []models.SchemaDescription
+	if projectID != "" {
+		if descriptions, err = h.loadDescriptions(ctx, projectID); err != nil {
+			return nil, err
+		}
+	}
+	for _, desc := range descriptions {
+		table, ok := index[desc.SchemaName+"."+desc.TableName]
+		if !ok {
+			continue
+		}
+		if desc.ColumnName == "" {
+			table.Description = desc.Description
+			continue
+		}
+		for i := range table.Columns {
+			if table.Columns[i].Name == desc.ColumnName {
+				table.Columns[i].Description = desc.Description
+				break
+			}
+		}
+	}
+
+	if samples > 0 {
+		for i := range tables {
+			h.attachSampleValues(ctx, pool, &tables[i], samples)
+		}
+	}
+
+	dictionary.Tables = tables
+	return dictionary, nil
+}
+
+func (h *SchemaDocsHandler) getDocTables(ctx context.Context, pool *pgxpool.Pool) ([]DocTable, error) {
+	query := `
+		SELECT
+			n.nspname,
+			c.relname,
+			CASE c.relkind
+				WHEN 'v' THEN 'view'
+				WHEN 'm' THEN 'materialized view'
+				WHEN 'p' THEN 'partitioned table'
+				ELSE 'table'
+			END,
+			COALESCE(obj_description(c.oid, 'pg_class'), ''),
+			GREATEST(c.reltuples, 0)::bigint
+		FROM pg_class c
+		JOIN pg_namespace n ON n.oid = c.relnamespace
+		WHERE c.relkind IN ('r', 'p', 'v', 'm')
+		AND n.nspname NOT IN ('information_schema', 'pg_catalog')
+		AND n.nspname NOT LIKE 'pg_toast%'
+		AND NOT c.relispartition
+		ORDER BY n.nspname, c.relname
+	`
+
+	rows, err := pool.Query(ctx, query)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get tables: %w", err)
+	}
+	defer rows.Close()
+
+	var tables []DocTable
+	for rows.Next() {
+		var table DocTable
+		if err := rows.Scan(&table.Schema, &table.Name, &table.Kind, &table.Comment, &table.RowEstimate); err != nil {
+			continue
+		}
+		tables = append(tables, table)
+	}
+
+	return tables, rows.Err()
+}
+
+func (h *SchemaDocsHandler) attachDocColumns(ctx context.Context, pool *pgxpool.Pool, index map[string]*DocTable) error {
+	query := `
+		SELECT
+			n.nspname,
+			c.relname,
+			a.attname,
+			format_type(a.atttypid, a.atttypmod),
+			NOT a.attnotnull,
+			COALESCE(pg_get_expr(d.adbin, d.adrelid), ''),
+			COALESCE(col_description(c.oid, a.attnum), '')
+		FROM pg_attribute a
+		JOIN pg_class c ON c.oid = a.attrelid
+		JOIN pg_namespace n ON n.oid = c.relnamespace
+		LEFT JOIN pg_attrdef d ON d.adrelid = a.attrelid AND d.adnum = a.attnum
+		WHERE a.attnum > 0 AND NOT a.attisdropped
+		AND c.relkind IN ('r', 'p', 'v', 'm')
+		AND n.nspname NOT IN ('information_schema', 'pg_catalog')
+		AND n.nspname NOT LIKE 'pg_toast%'
+		ORDER BY n.nspname, c.relname, a.attnum
+	`
+
+	rows, err := pool.Query(ctx, query)
+	if err != nil {
+		return fmt.Errorf("failed to get columns: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var schemaName, tableName string
+		var col DocColumn
+		if err := rows.Scan(&schemaName, &tableName, &col.Name, &col.Type, &col.Nullable, &col.DefaultValue, &col.Comment); err != nil {
+			continue
+		}
+		if table, ok := index[schemaName+"."+tableName]; ok {
+			table.Columns = append(table.Columns, col)
+		}
+	}
+
+	return rows.Err()
+}
+
+func (h *SchemaDocsHandler) attachDocConstraints(ctx context.Context, pool *pgxpool.Pool, index map[string]*DocTable) error {
+	query := `
+		SELECT
+			n.nspname,
+			c.relname,
+			con.conname,
+			con.contype::text,
+			pg_get_constraintdef(con.oid),
+			ARRAY(
+				SELECT a.attname FROM unnest(con.conkey) WITH ORDINALITY AS k(attnum, ord)
+				JOIN pg_attribute a ON a.attrelid = con.conrelid AND a.attnum = k.attnum
+				ORDER BY k.ord
+			),
+			COALESCE(fn.nspname || '.' || fc.relname, '')
+		FROM pg_constraint con
+		JOIN pg_class c ON c.oid = con.conrelid
+		JOIN pg_namespace n ON n.oid = c.relnamespace
+		LEFT JOIN pg_class fc ON fc.oid = con.confrelid
+		LEFT JOIN pg_namespace fn ON fn.oid = fc.relnamespace
+		WHERE n.nspname NOT IN ('information_schema', 'pg_catalog')
+		AND con.contype IN ('p', 'u', 'f', 'c', 'x')
+		ORDER BY n.nspname, c.relname, con.conname
+	`
+
+	rows, err := pool.Query(ctx, query)
+	if err != nil {
+		return fmt.Errorf("failed to get constraints: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var schemaName, tableName, contype, referenced string
+		var con DocConstraint
+		if err := rows.Scan(&schemaName, &tableName, &con.Name, &contype, &con.Definition, &con.Columns, &referenced); err != nil {
+			continue
+		}
+
+		table, ok := index[schemaName+"."+tableName]
+		if !ok {
+			continue
+		}
+
+		switch contype {
+		case "p":
+			con.Type = "PRIMARY KEY"
+		case "u":
+			con.Type = "UNIQUE"
+		case "f":
+			con.Type = "FOREIGN KEY"
+		case "c":
+			con.Type = "CHECK"
+		case "x":
+			con.Type = "EXCLUDE"
+		}
+		table.Constraints = append(table.Constraints, con)
+
+		for i := range table.Columns {
+			col := &table.Columns[i]
+			if !containsString(con.Columns, col.Name) {
+				continue
+			}
+			switch contype {
+			case "p":
+				col.IsPrimaryKey = true
+			case "u":
+				// Only single-column unique constraints make the column itself unique.
+				col.IsUnique = col.IsUnique || len(con.Columns) == 1
+			case "f":
+				col.References = referenced
+			}
+		}
+	}
+
+	return rows.Err()
+}
+
+// attachSampleValues fills each column with up to n distinct non-null values
+// taken from the first rows of the table. Failures are logged and ignored so
+// that one unreadable table does not break the whole document.
+func (h *SchemaDocsHandler) attachSampleValues(ctx context.Context, pool *pgxpool.Pool, table *DocTable, n int) {
+	if len(table.Columns) == 0 {
+		return
+	}
+
+	sampleCtx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	query := fmt.Sprintf("SELECT * FROM %s LIMIT %d", pgx.Identifier{table.Schema, table.Name}.Sanitize(), n*5)
+	rows, err := pool.Query(sampleCtx, query)
+	if err != nil {
+		log.Debug().Err(err).Str("table", table.Schema+"."+table.Name).Msg("Skipping sample values")
+		return
+	}
+	defer rows.Close()
+
+	fields := rows.FieldDescriptions()
+	seen := make([]map[string]bool, len(fields))
+	for i := range seen {
+		seen[i] = make(map[string]bool)
+	}
+
+	positions := make(map[string]int, len(table.Columns))
+	for i, col := range table.Columns {
+		positions[col.Name] = i
+	}
+
+	for rows.Next() {
+		values, err := rows.Values()
+		if err != nil {
+			return
+		}
+		for i, value := range values {
+			if value == nil || i >= len(fields) {
+				continue
+			}
+			pos, ok := positions[string(fields[i].Name)]
+			if !ok || len(table.Columns[pos].SampleValues) >= n {
+				continue
+			}
+			text := formatSampleValue(value)
+			if seen[i][text] {
+				continue
+			}
+			seen[i][text] = true
+			table.Columns[pos].SampleValues = append(table.Columns[pos].SampleValues, text)
+		}
+	}
+}
+
+func formatSampleValue(value interface{}) string {
+	var text string
+	switch v := value.(type) {
+	case []byte:
+		text = fmt.Sprintf("\\x%x", v)
+	case time.Time:
+		text = v.Format(time.RFC3339)
+	case map[string]interface{}, []interface{}:
+		b, _ := json.Marshal(v)
+		text = string(b)
+	default:
+		text = fmt.Sprint(v)
+	}
+
+	if runes := []rune(text); len(runes) > maxSampleLength {
+		text = string(runes[:maxSampleLength]) + "…"
+	}
+	return text
+}
+
+func writeDocument(w http.ResponseWriter, contentType, filename string, download bool, body string) {
+	w.Header().Set("Content-Type", contentType)
+	if download {
+		w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", filename))
+	}
+	w.WriteHeader(http.StatusOK)
+	if _, err := w.Write([]byte(body)); err != nil {
+		log.Error().Err(err).Msg("Failed to write document response")
+	}
+}
+
+func containsString(values []string, target string) bool {
+	for _, v := range values {
+		if v == target {
+			return true
+		}
+	}
+	return false
+}
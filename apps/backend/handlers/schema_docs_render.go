@@ -0,0 +1,178 @@
+package handlers
+
+import (
+	"bytes"
+	"fmt"
+	"html/template"
+	"strings"
+)
+
+// renderMarkdown renders the data dictionary as a single Markdown document
+// with a table of contents and one section per table.
+func renderMarkdown(d *DataDictionary) string {
+	var b strings.Builder
+
+	fmt.Fprintf(&b, "# Data dictionary: %s\n\n", d.Database)
+	fmt.Fprintf(&b, "_Generated %s_\n\n", d.GeneratedAt.Format("2006-01-02 15:04 MST"))
+
+	if len(d.Tables) == 0 {
+		b.WriteString("No tables found.\n")
+		return b.String()
+	}
+
+	b.WriteString("## Tables\n\n")
+	for _, t := range d.Tables {
+		fmt.Fprintf(&b, "- [%s.%s](#%s)", t.Schema, t.Name, docAnchor(t))
+		if summary := t.Summary(); summary != "" {
+			fmt.Fprintf(&b, " — %s", markdownInline(summary))
+		}
+		b.WriteString("\n")
+	}
+	b.WriteString("\n")
+
+	for _, t := range d.Tables {
+		fmt.Fprintf(&b, "<a id=\"%s\"></a>\n\n", docAnchor(t))
+		fmt.Fprintf(&b, "## %s.%s\n\n", t.Schema, t.Name)
+		fmt.Fprintf(&b, "%s · ~%d rows\n\n", t.Kind, t.RowEstimate)
+		if summary := t.Summary(); summary != "" {
+			fmt.Fprintf(&b, "%s\n\n", summary)
+		}
+
+		b.WriteString("| Column | Type | Nullable | Default | Keys | Description | Samples |\n")
+		b.WriteString("|---|---|---|---|---|---|---|\n")
+		for _, c := range t.Columns {
+			nullable := "NO"
+			if c.Nullable {
+				nullable = "YES"
+			}
+			fmt.Fprintf(&b, "| `%s` | `%s` | %s | %s | %s | %s | %s |\n",
+				c.Name, c.Type, nullable,
+				markdownCode(c.DefaultValue),
+				markdownInline(columnKeys(c)),
+				markdownInline(c.Summary()),
+				markdownInline(strings.Join(c.SampleValues, ", ")),
+			)
+		}
+		b.WriteString("\n")
+
+		if len(t.Constraints) > 0 {
+			b.WriteString("**Constraints**\n\n")
+			for _, con := range t.Constraints {
+				fmt.Fprintf(&b, "- `%s` %s: `%s`\n", con.Name, con.Type, con.Definition)
+			}
+			b.WriteString("\n")
+		}
+	}
+
+	return b.String()
+}
+
+var htmlDocTemplate = template.Must(template.New("data-dictionary").Funcs(template.FuncMap{
+	"anchor": docAnchor,
+	"keys":   columnKeys,
+	"join":   strings.Join,
+}).Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<title>Data dictionary: {{.Database}}</title>
+<style>
+body { font-family: -apple-system, BlinkMacSystemFont, "Segoe UI", sans-serif; margin: 0; display: flex; color: #1f2328; }
+nav { width: 260px; height: 100vh; overflow-y: auto; position: sticky; top: 0; border-right: 1px solid #d0d7de; padding: 16px; box-sizing: border-box; font-size: 14px; }
+nav input { width: 100%; margin-bottom: 12px; padding: 4px 6px; box-sizing: border-box; }
+nav a { display: block; padding: 2px 0; color: #0969da; text-decoration: none; }
+main { flex: 1; padding: 24px 32px; max-width: 1200px; }
+table { border-collapse: collapse; width: 100%; margin-bottom: 16px; font-size: 14px; }
+th, td { border: 1px solid #d0d7de; padding: 6px 8px; text-align: left; vertical-align: top; }
+th { background: #f6f8fa; }
+code { font-family: ui-monospace, SFMono-Regular, Menlo, monospace; font-size: 13px; }
+.meta { color: #656d76; font-size: 13px; }
+.samples { color: #656d76; }
+section { margin-bottom: 40px; }
+</style>
+</head>
+<body>
+<nav>
+<strong>{{.Database}}</strong>
+<input type="search" placeholder="Filter tables" oninput="filterTables(this.value)">
+{{range .Tables}}<a href="#{{anchor .}}" data-name="{{.Schema}}.{{.Name}}">{{.Schema}}.{{.Name}}</a>
+{{end}}</nav>
+<main>
+<h1>Data dictionary: {{.Database}}</h1>
+<p class="meta">Generated {{.GeneratedAt.Format "2006-01-02 15:04 MST"}}</p>
+{{range .Tables}}<section id="{{anchor .}}" data-name="{{.Schema}}.{{.Name}}">
+<h2>{{.Schema}}.{{.Name}}</h2>
+<p class="meta">{{.Kind}} · ~{{.RowEstimate}} rows</p>
+{{with .Summary}}<p>{{.}}</p>{{end}}
+<table>
+<thead><tr><th>Column</th><th>Type</th><th>Nullable</th><th>Default</th><th>Keys</th><th>Description</th><th>Samples</th></tr></thead>
+<tbody>
+{{range .Columns}}<tr>
+<td><code>{{.Name}}</code></td>
+<td><code>{{.Type}}</code></td>
+<td>{{if .Nullable}}YES{{else}}NO{{end}}</td>
+<td>{{with .DefaultValue}}<code>{{.}}</code>{{end}}</td>
+<td>{{keys .}}</td>
+<td>{{.Summary}}</td>
+<td class="samples">{{join .SampleValues ", "}}</td>
+</tr>
+{{end}}</tbody>
+</table>
+{{if .Constraints}}<h3>Constraints</h3>
+<ul>
+{{range .Constraints}}<li><code>{{.Name}}</code> {{.Type}}: <code>{{.Definition}}</code></li>
+{{end}}</ul>
+{{end}}</section>
+{{end}}</main>
+<script>
+function filterTables(q) {
+  q = q.toLowerCase();
+  document.querySelectorAll("[data-name]").forEach(function (el) {
+    el.style.display = el.dataset.name.toLowerCase().indexOf(q) === -1 ? "none" : "";
+  });
+}
+</script>
+</body>
+</html>
+`))
+
+// renderHTML renders the data dictionary as a self-contained static HTML page.
+func renderHTML(d *DataDictionary) (string, error) {
+	var buf bytes.Buffer
+	if err := htmlDocTemplate.Execute(&buf, d); err != nil {
+		return "", fmt.Errorf("failed to render HTML: %w", err)
+	}
+	return buf.String(), nil
+}
+
+func docAnchor(t DocTable) string {
+	return strings.ToLower(strings.NewReplacer(" ", "-", ".", "-", "\"", "").Replace(t.Schema + "-" + t.Name))
+}
+
+func columnKeys(c DocColumn) string {
+	var keys []string
+	if c.IsPrimaryKey {
+		keys = append(keys, "PK")
+	}
+	if c.IsUnique {
+		keys = append(keys, "UNIQUE")
+	}
+	if c.References != "" {
+		keys = append(keys, "FK → "+c.References)
+	}
+	return strings.Join(keys, ", ")
+}
+
+// markdownInline makes a value safe to place inside a Markdown table cell.
+func markdownInline(s string) string {
+	s = strings.ReplaceAll(s, "|", "\\|")
+	s = strings.ReplaceAll(s, "\r\n", " ")
+	return strings.ReplaceAll(s, "\n", " ")
+}
+
+func markdownCode(s string) string {
+	if s == "" {
+		return ""
+	}
+	return "`" + markdownInline(s) + "`"
+}
@@ -0,0 +1,55 @@
+package handlers
+
+import (
+	"testing"
+
+	"go-backend/sqltools"
+)
+
+func TestFilterDictionary(t *testing.T) {
+	d := &DataDictionary{Database: "app", Tables: []DocTable{
+		{Schema: "public", Name: "users", Columns: []DocColumn{
+			{Name: "id", IsPrimaryKey: true},
+			{Name: "email"},
+			{Name: "password_hash"},
+			{Name: "team_id", References: "secret.teams"},
+		}, Constraints: []DocConstraint{
+			{Name: "users_pkey", Type: "PRIMARY KEY", Columns: []string{"id"}},
+			{Name: "users_password_check", Type: "CHECK", Columns: []string{"password_hash"}},
+			{Name: "users_team_fkey", Type: "FOREIGN KEY", Columns: []string{"team_id"}},
+		}},
+		{Schema: "secret", Name: "teams", Columns: []DocColumn{{Name: "id"}}},
+	}}
+	policies := sqltools.PolicySet{
+		{Schema: "public", Table: "users", Column: "password_hash", Action: sqltools.PolicyDeny},
+		{Schema: "public", Table: "users", Column: "email", Action: sqltools.PolicyMask},
+		{Schema: "secret", Action: sqltools.PolicyDeny},
+	}
+
+	out := filterDictionary(d, policies)
+	if len(out.Tables) != 1 || out.Tables[0].Name != "users" {
+		t.Fatalf("tables = %+v, want only users", out.Tables)
+	}
+	users := out.Tables[0]
+
+	var names []string
+	for _, c := range users.Columns {
+		names = append(names, c.Name)
+		if c.Name == "email" && !c.Masked {
+			t.Error("email is not flagged as masked")
+		}
+		if c.References != "" {
+			t.Errorf("%s still references %s", c.Name, c.References)
+		}
+	}
+	if len(names) != 3 || containsString(names, "password_hash") {
+		t.Errorf("columns = %v", names)
+	}
+	if len(users.Constraints) != 1 || users.Constraints[0].Name != "users_pkey" {
+		t.Errorf("constraints = %+v, want only the primary key", users.Constraints)
+	}
+
+	if filterDictionary(d, nil) != d {
+		t.Error("filtered a dictionary without policies")
+	}
+}
@@ -0,0 +1,1474 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"go-backend/audit"
+	"go-backend/auth"
+	"go-backend/authz"
+	"go-backend/database"
+	"go-backend/models"
+	"go-backend/quota"
+	"go-backend/scim"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+	"github.com/jackc/pgx/v5"
+	"github.com/rs/zerolog/log"
+)
+
+// scimBodyLimit caps SCIM request bodies; a group with thousands of
+// members still fits.
+const scimBodyLimit = 1 << 20
+
+// SCIMHandler serves an organization's SCIM 2.0 endpoints, through which
+// its identity provider creates, updates and deprovisions members, and the
+// admin routes that issue SCIM tokens and map SCIM groups.
+//
+// SCIM users are the organization's members other than service accounts,
+// identified by their user ID. New users get a Better Auth account, like
+// users signing in through SSO, and only emails at the organization's
+// verified domains are accepted. Deactivating a user suspends their
+// membership and deleting one removes it; owners are managed in the app
+// only. SCIM groups grant their members an organization role and a project
+// role as admins map them. Every change takes the organization lock like
+// member changes made in the app.
+type SCIMHandler struct {
+	db     *database.PostgresDB
+	quotas *quota.Service
+	audit  *audit.Logger
+	apiURL string
+}
+
+func NewSCIMHandler(db *database.PostgresDB, quotas *quota.Service, auditLog *audit.Logger, apiURL string) *SCIMHandler {
+	return &SCIMHandler{db: db, quotas: quotas, audit: auditLog, apiURL: strings.TrimSuffix(apiURL, "/")}
+}
+
+// baseURL is the SCIM base URL of an organization, which its IdP is
+// configured with.
+func (h *SCIMHandler) baseURL(orgID string) string {
+	return h.apiURL + "/api/v1/scim/v2/organizations/" + orgID
+}
+
+// scimLastUsedResolution is how stale a token's last_used_at may get, as
+// for API keys.
+const scimLastUsedResolution = time.Minute
+
+type scimTokenKey struct{}
+
+// Authenticate accepts requests bearing an unrevoked SCIM token of the
+// organization in the path, while its plan includes SCIM.
+func (h *SCIMHandler) Authenticate(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		orgID := mux.Vars(r)["orgId"]
+		ctx := r.Context()
+
+		header := r.Header.Get("Authorization")
+		token := ""
+		if len(header) > len("Bearer ") && strings.EqualFold(header[:len("Bearer ")], "Bearer ") {
+			token = strings.TrimSpace(header[len("Bearer "):])
+		}
+		if !auth.IsSCIMToken(token) {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="scim"`)
+			scim.WriteError(w, scim.Errorf(http.StatusUnauthorized, "", "A SCIM token is required"))
+			return
+		}
+
+		var tokenID string
+		var stale bool
+		err := h.db.QueryRow(ctx, `
+			SELECT id, last_used_at IS NULL OR last_used_at < NOW() - $3::interval
+			FROM scim_tokens
+			WHERE token_hash = $1 AND organization_id = $2 AND revoked_at IS NULL
+		`, auth.HashAPIKey(token), orgID, scimLastUsedResolution.String()).Scan(&tokenID, &stale)
+		if errors.Is(err, pgx.ErrNoRows) {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="scim", error="invalid_token"`)
+			scim.WriteError(w, scim.Errorf(http.StatusUnauthorized, "", "Invalid or revoked SCIM token"))
+			return
+		}
+		if err == nil {
+			err = h.quotas.RequireFeature(ctx, quota.OrganizationSubject(orgID), quota.FeatureSCIM)
+		}
+		if err != nil {
+			writeSCIMError(w, err, "Failed to check SCIM token")
+			return
+		}
+
+		if stale {
+			if err := h.db.Exec(ctx, "UPDATE scim_tokens SET last_used_at = NOW() WHERE id = $1", tokenID); err != nil {
+				log.Warn().Err(err).Str("scim_token_id", tokenID).Msg("Failed to record SCIM token use")
+			}
+		}
+		next.ServeHTTP(w, r.WithContext(context.WithValue(ctx, scimTokenKey{}, tokenID)))
+	})
+}
+
+// writeSCIMError writes err as a SCIM error response: SCIM, membership and
+// quota refusals as-is, anything else as a 500 with the given message.
+func writeSCIMError(w http.ResponseWriter, err error, message string) {
+	var se *scim.Error
+	var me *memberError
+	var missing *quota.FeatureError
+	var exceeded *quota.ExceededError
+	switch {
+	case errors.As(err, &se):
+		scim.WriteError(w, se)
+	case errors.As(err, &me):
+		scim.WriteError(w, scim.Errorf(me.status, "", "%s", me.message))
+	case errors.As(err, &missing):
+		scim.WriteError(w, scim.Errorf(http.StatusForbidden, "", "SCIM is not available on the organization's %s plan", missing.Plan))
+	case errors.As(err, &exceeded):
+		scim.WriteError(w, scim.Errorf(http.StatusForbidden, "", "The organization has no seat left: %s", exceeded.Error()))
+	default:
+		log.Error().Err(err).Msg(message)
+		scim.WriteError(w, scim.Errorf(http.StatusInternalServerError, "", "%s", message))
+	}
+}
+
+// record writes an audit event done by the IdP, naming the token it used.
+func (h *SCIMHandler) record(r *http.Request, e audit.Event) {
+	metadata := map[string]interface{}{"source": "scim"}
+	if tokenID, ok := r.Context().Value(scimTokenKey{}).(string); ok {
+		metadata["scim_token_id"] = tokenID
+	}
+	for k, v := range e.Metadata {
+		metadata[k] = v
+	}
+	e.Metadata = metadata
+	h.audit.RecordSystem(r, e)
+}
+
+// inTx runs fn in a transaction holding the organization lock.
+func (h *SCIMHandler) inTx(ctx context.Context, orgID string, fn func(tx pgx.Tx) error) error {
+	tx, err := h.db.GetPool().Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	var id string
+	err = tx.QueryRow(ctx, "SELECT id FROM organizations WHERE id = $1 FOR UPDATE", orgID).Scan(&id)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return scim.NotFound("Organization not found")
+	}
+	if err != nil {
+		return err
+	}
+	if err := fn(tx); err != nil {
+		return err
+	}
+	return tx.Commit(ctx)
+}
+
+// decodeSCIM reads a request body into v.
+func decodeSCIM(w http.ResponseWriter, r *http.Request, v interface{}) error {
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, scimBodyLimit)).Decode(v); err != nil {
+		return scim.BadRequest(scim.TypeInvalidSyntax, "Invalid request body")
+	}
+	return nil
+}
+
+// GET /api/v1/scim/v2/organizations/{orgId}/ServiceProviderConfig
+func (h *SCIMHandler) ServiceProviderConfig(w http.ResponseWriter, r *http.Request) {
+	scim.WriteJSON(w, http.StatusOK, scim.ServiceProviderConfig(h.baseURL(mux.Vars(r)["orgId"])))
+}
+
+// GET /api/v1/scim/v2/organizations/{orgId}/ResourceTypes
+func (h *SCIMHandler) ResourceTypes(w http.ResponseWriter, r *http.Request) {
+	types := scim.ResourceTypes(h.baseURL(mux.Vars(r)["orgId"]))
+	scim.WriteJSON(w, http.StatusOK, scim.NewListResponse(types, len(types), len(types), 1))
+}
+
+// GET /api/v1/scim/v2/organizations/{orgId}/Schemas
+func (h *SCIMHandler) Schemas(w http.ResponseWriter, r *http.Request) {
+	schemas := scim.Schemas(h.baseURL(mux.Vars(r)["orgId"]))
+	scim.WriteJSON(w, http.StatusOK, scim.NewListResponse(schemas, len(schemas), len(schemas), 1))
+}
+
+// scimMember is a member as a SCIM user.
+type scimMember struct {
+	models.OrganizationMember
+	externalID string
+	name       string
+	managed    bool
+}
+
+const scimMemberColumns = memberColumns + `, COALESCE(scim_external_id, ''),
+	COALESCE((SELECT u.name FROM "user" u WHERE u.id = organization_members.user_id), ''), scim_managed`
+
+// scimMemberWhere selects the organization's members SCIM can see: everyone
+// but service accounts, which the app manages.
+const scimMemberWhere = `organization_id = $1
+	AND NOT EXISTS (SELECT 1 FROM service_accounts sa WHERE sa.user_id = organization_members.user_id)`
+
+func scanSCIMMember(row pgx.Row) (*scimMember, error) {
+	var m scimMember
+	err := row.Scan(&m.ID, &m.OrganizationID, &m.UserID, &m.Email, &m.Role, &m.Status,
+		&m.JoinedAt, &m.InvitedAt, &m.InvitedBy, &m.ProjectAccess, &m.ServiceAccount,
+		&m.externalID, &m.name, &m.managed)
+	if err != nil {
+		return nil, err
+	}
+	return &m, nil
+}
+
+func getSCIMMember(ctx context.Context, tx pgx.Tx, orgID, userID string) (*scimMember, error) {
+	m, err := scanSCIMMember(tx.QueryRow(ctx, `
+		SELECT `+scimMemberColumns+` FROM organization_members
+		WHERE `+scimMemberWhere+` AND user_id = $2
+	`, orgID, userID))
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, scim.NotFound("User %s not found", userID)
+	}
+	return m, err
+}
+
+func (h *SCIMHandler) userResource(m *scimMember, groups []scim.Value) *scim.User {
+	active := m.Status == "active"
+	u := &scim.User{
+		Schemas:     []string{scim.SchemaUser},
+		ID:          m.UserID,
+		ExternalID:  m.externalID,
+		UserName:    m.Email,
+		DisplayName: m.name,
+		Emails:      []scim.Value{{Value: m.Email, Type: "work", Primary: true}},
+		Active:      &active,
+		Groups:      groups,
+		Meta: &scim.Meta{
+			ResourceType: "User",
+			Created:      m.JoinedAt,
+			Location:     h.baseURL(m.OrganizationID) + "/Users/" + m.UserID,
+		},
+	}
+	if m.name != "" {
+		u.Name = &scim.Name{Formatted: m.name}
+	}
+	return u
+}
+
+// userGroups returns the groups of each of userIDs, as listed on users.
+func (h *SCIMHandler) userGroups(ctx context.Context, orgID string, userIDs []string) (map[string][]scim.Value, error) {
+	rows, err := h.db.Query(ctx, `
+		SELECT gm.user_id, g.id, g.display_name
+		FROM scim_group_members gm
+		JOIN scim_groups g ON g.id = gm.group_id
+		WHERE g.organization_id = $1 AND gm.user_id = ANY($2)
+		ORDER BY g.display_name
+	`, orgID, userIDs)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	groups := make(map[string][]scim.Value)
+	for rows.Next() {
+		var userID, groupID, name string
+		if err := rows.Scan(&userID, &groupID, &name); err != nil {
+			return nil, err
+		}
+		groups[userID] = append(groups[userID], scim.Value{
+			Value:   groupID,
+			Display: name,
+			Ref:     h.baseURL(orgID) + "/Groups/" + groupID,
+		})
+	}
+	return groups, rows.Err()
+}
+
+// GET /api/v1/scim/v2/organizations/{orgId}/Users
+//
+// Supports equality filters on userName, emails, externalId and id.
+func (h *SCIMHandler) ListUsers(w http.ResponseWriter, r *http.Request) {
+	orgID := mux.Vars(r)["orgId"]
+	ctx := r.Context()
+
+	filter, err := scim.ParseFilter(r.URL.Query().Get("filter"))
+	if err != nil {
+		writeSCIMError(w, err, "Failed to list users")
+		return
+	}
+	where := scimMemberWhere
+	args := []interface{}{orgID}
+	if filter != nil {
+		switch filter.Attribute {
+		case "username", "emails", "emails.value":
+			where += " AND LOWER(email) = LOWER($2)"
+		case "externalid":
+			where += " AND scim_external_id = $2"
+		case "id":
+			where += " AND user_id = $2"
+		default:
+			writeSCIMError(w, scim.BadRequest(scim.TypeInvalidFilter, "Filtering users on %s is not supported", filter.Attribute), "")
+			return
+		}
+		args = append(args, filter.Value)
+	}
+
+	var total int
+	if err := h.db.QueryRow(ctx, "SELECT COUNT(*) FROM organization_members WHERE "+where, args...).Scan(&total); err != nil {
+		writeSCIMError(w, err, "Failed to list users")
+		return
+	}
+
+	startIndex, count := scim.Page(r.URL.Query())
+	rows, err := h.db.Query(ctx, fmt.Sprintf(`
+		SELECT `+scimMemberColumns+` FROM organization_members
+		WHERE `+where+`
+		ORDER BY joined_at, id
+		LIMIT $%d OFFSET $%d
+	`, len(args)+1, len(args)+2), append(args, count, startIndex-1)...)
+	if err != nil {
+		writeSCIMError(w, err, "Failed to list users")
+		return
+	}
+	var members []*scimMember
+	for rows.Next() {
+		m, err := scanSCIMMember(rows)
+		if err != nil {
+			rows.Close()
+			writeSCIMError(w, err, "Failed to list users")
+			return
+		}
+		members = append(members, m)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		writeSCIMError(w, err, "Failed to list users")
+		return
+	}
+
+	userIDs := make([]string, len(members))
+	for i, m := range members {
+		userIDs[i] = m.UserID
+	}
+	groups, err := h.userGroups(ctx, orgID, userIDs)
+	if err != nil {
+		writeSCIMError(w, err, "Failed to list users")
+		return
+	}
+	users := make([]*scim.User, len(members))
+	for i, m := range members {
+		users[i] = h.userResource(m, groups[m.UserID])
+	}
+
+	scim.WriteJSON(w, http.StatusOK, scim.NewListResponse(users, len(users), total, startIndex))
+}
+
+// GET /api/v1/scim/v2/organizations/{orgId}/Users/{userId}
+func (h *SCIMHandler) GetUser(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	h.writeUser(w, r, vars["orgId"], vars["userId"], http.StatusOK)
+}
+
+func (h *SCIMHandler) writeUser(w http.ResponseWriter, r *http.Request, orgID, userID string, status int) {
+	ctx := r.Context()
+	m, err := scanSCIMMember(h.db.QueryRow(ctx, `
+		SELECT `+scimMemberColumns+` FROM organization_members
+		WHERE `+scimMemberWhere+` AND user_id = $2
+	`, orgID, userID))
+	if errors.Is(err, pgx.ErrNoRows) {
+		err = scim.NotFound("User %s not found", userID)
+	}
+	if err != nil {
+		writeSCIMError(w, err, "Failed to fetch user")
+		return
+	}
+	groups, err := h.userGroups(ctx, orgID, []string{userID})
+	if err != nil {
+		writeSCIMError(w, err, "Failed to fetch user")
+		return
+	}
+
+	user := h.userResource(m, groups[userID])
+	if status == http.StatusCreated {
+		w.Header().Set("Location", user.Meta.Location)
+	}
+	scim.WriteJSON(w, status, user)
+}
+
+// emailAtVerifiedDomain reports whether email is at one of the
+// organization's verified domains.
+func emailAtVerifiedDomain(ctx context.Context, tx pgx.Tx, orgID, email string) (bool, error) {
+	var verified bool
+	err := tx.QueryRow(ctx, `
+		SELECT EXISTS (
+			SELECT 1 FROM organization_domains
+			WHERE organization_id = $1 AND domain = $2 AND verified_at IS NOT NULL
+		)
+	`, orgID, emailDomain(email)).Scan(&verified)
+	return verified, err
+}
+
+// POST /api/v1/scim/v2/organizations/{orgId}/Users
+//
+// Adds the user as a member with access to all projects, creating their
+// account if they have none. Active users take a seat.
+func (h *SCIMHandler) CreateUser(w http.ResponseWriter, r *http.Request) {
+	orgID := mux.Vars(r)["orgId"]
+	ctx := context.Background()
+
+	var req scim.User
+	if err := decodeSCIM(w, r, &req); err != nil {
+		writeSCIMError(w, err, "")
+		return
+	}
+	email := strings.ToLower(strings.TrimSpace(req.Email()))
+	if !strings.Contains(email, "@") {
+		writeSCIMError(w, scim.BadRequest(scim.TypeInvalidValue, "userName must be an email address"), "")
+		return
+	}
+	active := req.Active == nil || *req.Active
+
+	var userID, memberID string
+	var roleChanges []scimRoleChange
+	err := h.inTx(ctx, orgID, func(tx pgx.Tx) error {
+		verified, err := emailAtVerifiedDomain(ctx, tx, orgID, email)
+		if err != nil {
+			return err
+		}
+		if !verified {
+			return scim.BadRequest(scim.TypeInvalidValue, "%s is not at one of the organization's verified domains", email)
+		}
+
+		userID, err = betterAuthUser(ctx, tx, email, req.FullName())
+		if err != nil {
+			return err
+		}
+		var exists bool
+		if err := tx.QueryRow(ctx, `
+			SELECT EXISTS (
+				SELECT 1 FROM organization_members
+				WHERE organization_id = $1 AND (user_id = $2 OR LOWER(email) = $3)
+			)
+		`, orgID, userID, email).Scan(&exists); err != nil {
+			return err
+		}
+		if exists {
+			return scim.Errorf(http.StatusConflict, scim.TypeUniqueness, "%s is already a member", email)
+		}
+
+		status := "suspended"
+		if active {
+			// Pending invitations hold seats, so this counts them too
+			if _, err := h.quotas.CheckInvitation(ctx, orgID); err != nil {
+				return err
+			}
+			status = "active"
+		}
+		memberID = uuid.New().String()
+		if err := execTx(ctx, tx, `
+			INSERT INTO organization_members (id, organization_id, user_id, email, role, status, joined_at, invited_at,
+				project_access, scim_managed, scim_external_id)
+			VALUES ($1, $2, $3, $4, 'member', $5, NOW(), NOW(), 'all', TRUE, NULLIF($6, ''))
+		`, memberID, orgID, userID, email, status, req.ExternalID); err != nil {
+			return err
+		}
+		if err := execTx(ctx, tx, `
+			UPDATE organization_invitations SET status = 'cancelled'
+			WHERE organization_id = $1 AND LOWER(email) = $2 AND status = 'pending'
+		`, orgID, email); err != nil {
+			return err
+		}
+		roleChanges, err = syncSCIMMembers(ctx, tx, orgID, []string{userID})
+		return err
+	})
+	if err != nil {
+		writeSCIMError(w, err, "Failed to create user")
+		return
+	}
+
+	h.record(r, audit.Event{
+		OrganizationID: orgID,
+		Action:         audit.ActionMemberProvisionedSCIM,
+		TargetType:     audit.TargetMember,
+		TargetID:       memberID,
+		Metadata:       map[string]interface{}{"email": email, "active": active, "external_id": req.ExternalID},
+	})
+	h.recordRoleChanges(r, orgID, roleChanges)
+
+	h.writeUser(w, r, orgID, userID, http.StatusCreated)
+}
+
+// scimUserChanges are the attributes a PUT or PATCH sets; nil leaves one
+// as it is.
+type scimUserChanges struct {
+	userName   *string
+	externalID *string
+	name       *string
+	active     *bool
+}
+
+// PUT /api/v1/scim/v2/organizations/{orgId}/Users/{userId}
+func (h *SCIMHandler) ReplaceUser(w http.ResponseWriter, r *http.Request) {
+	var req scim.User
+	if err := decodeSCIM(w, r, &req); err != nil {
+		writeSCIMError(w, err, "")
+		return
+	}
+	email := req.Email()
+	name := req.FullName()
+	changes := scimUserChanges{userName: &email, externalID: &req.ExternalID, active: req.Active}
+	if name != "" {
+		changes.name = &name
+	}
+	h.updateUser(w, r, changes)
+}
+
+// PATCH /api/v1/scim/v2/organizations/{orgId}/Users/{userId}
+//
+// Understands the active, userName, emails, externalId, displayName and
+// name attributes; others are ignored.
+func (h *SCIMHandler) PatchUser(w http.ResponseWriter, r *http.Request) {
+	var req scim.PatchRequest
+	err := decodeSCIM(w, r, &req)
+	if err == nil {
+		err = req.Validate()
+	}
+	if err != nil {
+		writeSCIMError(w, err, "")
+		return
+	}
+
+	var changes scimUserChanges
+	var given, family *string
+	for i := range req.Operations {
+		op := &req.Operations[i]
+		if op.Op == scim.OpRemove {
+			if op.Attribute() == "externalid" {
+				empty := ""
+				changes.externalID = &empty
+			}
+			continue
+		}
+		values, err := op.Values()
+		if err != nil {
+			writeSCIMError(w, err, "")
+			return
+		}
+		for attr, raw := range values {
+			var perr error
+			switch attr {
+			case "active":
+				var active bool
+				active, perr = scim.ParseBool(raw)
+				changes.active = &active
+			case "username", "emails.value":
+				var s string
+				s, perr = scim.ParseString(raw)
+				changes.userName = &s
+			case "externalid":
+				var s string
+				s, perr = scim.ParseString(raw)
+				changes.externalID = &s
+			case "displayname", "name.formatted":
+				var s string
+				s, perr = scim.ParseString(raw)
+				changes.name = &s
+			case "name.givenname":
+				var s string
+				s, perr = scim.ParseString(raw)
+				given = &s
+			case "name.familyname":
+				var s string
+				s, perr = scim.ParseString(raw)
+				family = &s
+			}
+			if perr != nil {
+				writeSCIMError(w, perr, "")
+				return
+			}
+		}
+	}
+	if changes.name == nil && (given != nil || family != nil) {
+		n := &scim.Name{}
+		if given != nil {
+			n.GivenName = *given
+		}
+		if family != nil {
+			n.FamilyName = *family
+		}
+		name := n.String()
+		changes.name = &name
+	}
+	h.updateUser(w, r, changes)
+}
+
+func (h *SCIMHandler) updateUser(w http.ResponseWriter, r *http.Request, changes scimUserChanges) {
+	vars := mux.Vars(r)
+	orgID, userID := vars["orgId"], vars["userId"]
+	ctx := context.Background()
+
+	var events []audit.Event
+	err := h.inTx(ctx, orgID, func(tx pgx.Tx) error {
+		m, err := getSCIMMember(ctx, tx, orgID, userID)
+		if err != nil {
+			return err
+		}
+		events, err = h.applyUserChanges(ctx, tx, m, changes)
+		return err
+	})
+	if err != nil {
+		writeSCIMError(w, err, "Failed to update user")
+		return
+	}
+
+	for _, e := range events {
+		h.record(r, e)
+	}
+	h.writeUser(w, r, orgID, userID, http.StatusOK)
+}
+
+// applyUserChanges updates m and returns the audit events to record. The
+// member becomes managed by SCIM from then on.
+func (h *SCIMHandler) applyUserChanges(ctx context.Context, tx pgx.Tx, m *scimMember, c scimUserChanges) ([]audit.Event, error) {
+	if c.userName != nil && *c.userName != "" && !strings.EqualFold(strings.TrimSpace(*c.userName), m.Email) {
+		return nil, scim.BadRequest(scim.TypeMutability, "userName cannot be changed; delete the user and create it again")
+	}
+
+	var events []audit.Event
+	profile := map[string]interface{}{}
+	if c.externalID != nil && *c.externalID != m.externalID {
+		profile["external_id"] = *c.externalID
+	}
+	if err := execTx(ctx, tx, `
+		UPDATE organization_members SET scim_managed = TRUE, scim_external_id = NULLIF($2, '')
+		WHERE id = $1
+	`, m.ID, stringOr(c.externalID, m.externalID)); err != nil {
+		return nil, err
+	}
+
+	// Renaming the account is left to its owner unless the organization
+	// owns their address
+	if c.name != nil && strings.TrimSpace(*c.name) != "" && strings.TrimSpace(*c.name) != m.name {
+		verified, err := emailAtVerifiedDomain(ctx, tx, m.OrganizationID, m.Email)
+		if err != nil {
+			return nil, err
+		}
+		if verified {
+			if err := execTx(ctx, tx, `UPDATE "user" SET name = $1, "updatedAt" = NOW() WHERE id = $2`, strings.TrimSpace(*c.name), m.UserID); err != nil {
+				return nil, err
+			}
+			profile["name"] = strings.TrimSpace(*c.name)
+		}
+	}
+	if len(profile) > 0 {
+		profile["email"] = m.Email
+		events = append(events, audit.Event{
+			OrganizationID: m.OrganizationID,
+			Action:         audit.ActionMemberUpdatedSCIM,
+			TargetType:     audit.TargetMember,
+			TargetID:       m.ID,
+			Metadata:       profile,
+		})
+	}
+
+	if c.active != nil && *c.active != (m.Status == "active") {
+		action := audit.ActionMemberReactivated
+		if *c.active {
+			// The member takes a seat again
+			if _, err := h.quotas.Check(ctx, quota.OrganizationSubject(m.OrganizationID), quota.Members, 1); err != nil {
+				return nil, err
+			}
+			if err := execTx(ctx, tx, "UPDATE organization_members SET status = 'active' WHERE id = $1", m.ID); err != nil {
+				return nil, err
+			}
+		} else {
+			if m.Role == "owner" {
+				return nil, scim.BadRequest(scim.TypeMutability, "Owners cannot be deactivated through SCIM; transfer ownership in the app first")
+			}
+			if err := cancelTransfers(ctx, tx, m.OrganizationID, m.UserID); err != nil {
+				return nil, err
+			}
+			if err := execTx(ctx, tx, "UPDATE organization_members SET status = 'suspended' WHERE id = $1", m.ID); err != nil {
+				return nil, err
+			}
+			action = audit.ActionMemberSuspended
+		}
+		events = append(events, audit.Event{
+			OrganizationID: m.OrganizationID,
+			Action:         action,
+			TargetType:     audit.TargetMember,
+			TargetID:       m.ID,
+			Metadata:       map[string]interface{}{"email": m.Email, "role": m.Role},
+		})
+	}
+	return events, nil
+}
+
+func stringOr(s *string, fallback string) string {
+	if s == nil {
+		return fallback
+	}
+	return *s
+}
+
+// DELETE /api/v1/scim/v2/organizations/{orgId}/Users/{userId}
+//
+// Removes the membership; the account stays.
+func (h *SCIMHandler) DeleteUser(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	orgID, userID := vars["orgId"], vars["userId"]
+	ctx := context.Background()
+
+	var m *scimMember
+	err := h.inTx(ctx, orgID, func(tx pgx.Tx) error {
+		var err error
+		m, err = getSCIMMember(ctx, tx, orgID, userID)
+		if err != nil {
+			return err
+		}
+		if m.Role == "owner" {
+			return scim.BadRequest(scim.TypeMutability, "Owners cannot be removed through SCIM; transfer ownership in the app first")
+		}
+		if err := cancelTransfers(ctx, tx, orgID, userID); err != nil {
+			return err
+		}
+		return removeMembership(ctx, tx, &m.OrganizationMember)
+	})
+	if err != nil {
+		writeSCIMError(w, err, "Failed to delete user")
+		return
+	}
+
+	h.record(r, audit.Event{
+		OrganizationID: orgID,
+		Action:         audit.ActionMemberRemoved,
+		TargetType:     audit.TargetMember,
+		TargetID:       m.ID,
+		Metadata:       map[string]interface{}{"email": m.Email, "role": m.Role},
+	})
+	w.WriteHeader(http.StatusNoContent)
+}
+
+const scimGroupColumns = `id, organization_id, display_name, external_id, role, project_id, project_role,
+	(SELECT COUNT(*) FROM scim_group_members gm WHERE gm.group_id = scim_groups.id), created_at, updated_at`
+
+func scanSCIMGroup(row pgx.Row) (*models.SCIMGroup, error) {
+	var g models.SCIMGroup
+	err := row.Scan(&g.ID, &g.OrganizationID, &g.DisplayName, &g.ExternalID, &g.Role, &g.ProjectID, &g.ProjectRole,
+		&g.MemberCount, &g.CreatedAt, &g.UpdatedAt)
+	if err != nil {
+		return nil, err
+	}
+	return &g, nil
+}
+
+func getSCIMGroup(ctx context.Context, tx pgx.Tx, orgID, groupID string) (*models.SCIMGroup, error) {
+	g, err := scanSCIMGroup(tx.QueryRow(ctx, `
+		SELECT `+scimGroupColumns+` FROM scim_groups WHERE organization_id = $1 AND id = $2
+	`, orgID, groupID))
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, scim.NotFound("Group %s not found", groupID)
+	}
+	return g, err
+}
+
+// groupMembers returns the members of each of groupIDs, as listed on
+// groups.
+func (h *SCIMHandler) groupMembers(ctx context.Context, orgID string, groupIDs []string) (map[string][]scim.Value, error) {
+	rows, err := h.db.Query(ctx, `
+		SELECT gm.group_id, om.user_id, om.email
+		FROM scim_group_members gm
+		JOIN scim_groups g ON g.id = gm.group_id
+		JOIN organization_members om ON om.organization_id = g.organization_id AND om.user_id = gm.user_id
+		WHERE g.organization_id = $1 AND gm.group_id = ANY($2)
+		ORDER BY om.email
+	`, orgID, groupIDs)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	members := make(map[string][]scim.Value)
+	for rows.Next() {
+		var groupID, userID, email string
+		if err := rows.Scan(&groupID, &userID, &email); err != nil {
+			return nil, err
+		}
+		members[groupID] = append(members[groupID], scim.Value{
+			Value:   userID,
+			Display: email,
+			Ref:     h.baseURL(orgID) + "/Users/" + userID,
+		})
+	}
+	return members, rows.Err()
+}
+
+func (h *SCIMHandler) groupResource(g *models.SCIMGroup, members []scim.Value) *scim.Group {
+	lastModified := g.UpdatedAt
+	return &scim.Group{
+		Schemas:     []string{scim.SchemaGroup},
+		ID:          g.ID,
+		ExternalID:  deref(g.ExternalID),
+		DisplayName: g.DisplayName,
+		Members:     members,
+		Meta: &scim.Meta{
+			ResourceType: "Group",
+			Created:      g.CreatedAt,
+			LastModified: &lastModified,
+			Location:     h.baseURL(g.OrganizationID) + "/Groups/" + g.ID,
+		},
+	}
+}
+
+// GET /api/v1/scim/v2/organizations/{orgId}/Groups
+//
+// Supports equality filters on displayName, externalId and id, and
+// excludedAttributes=members.
+func (h *SCIMHandler) ListGroups(w http.ResponseWriter, r *http.Request) {
+	orgID := mux.Vars(r)["orgId"]
+	ctx := r.Context()
+	query := r.URL.Query()
+
+	filter, err := scim.ParseFilter(query.Get("filter"))
+	if err != nil {
+		writeSCIMError(w, err, "Failed to list groups")
+		return
+	}
+	where := "organization_id = $1"
+	args := []interface{}{orgID}
+	if filter != nil {
+		switch filter.Attribute {
+		case "displayname":
+			where += " AND LOWER(display_name) = LOWER($2)"
+		case "externalid":
+			where += " AND external_id = $2"
+		case "id":
+			where += " AND id = $2"
+		default:
+			writeSCIMError(w, scim.BadRequest(scim.TypeInvalidFilter, "Filtering groups on %s is not supported", filter.Attribute), "")
+			return
+		}
+		args = append(args, filter.Value)
+	}
+
+	var total int
+	if err := h.db.QueryRow(ctx, "SELECT COUNT(*) FROM scim_groups WHERE "+where, args...).Scan(&total); err != nil {
+		writeSCIMError(w, err, "Failed to list groups")
+		return
+	}
+
+	startIndex, count := scim.Page(query)
+	rows, err := h.db.Query(ctx, fmt.Sprintf(`
+		SELECT `+scimGroupColumns+` FROM scim_groups
+		WHERE `+where+`
+		ORDER BY display_name
+		LIMIT $%d OFFSET $%d
+	`, len(args)+1, len(args)+2), append(args, count, startIndex-1)...)
+	if err != nil {
+		writeSCIMError(w, err, "Failed to list groups")
+		return
+	}
+	var groups []*models.SCIMGroup
+	for rows.Next() {
+		g, err := scanSCIMGroup(rows)
+		if err != nil {
+			rows.Close()
+			writeSCIMError(w, err, "Failed to list groups")
+			return
+		}
+		groups = append(groups, g)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		writeSCIMError(w, err, "Failed to list groups")
+		return
+	}
+
+	members := map[string][]scim.Value{}
+	if !scim.Excludes(query, "members") {
+		groupIDs := make([]string, len(groups))
+		for i, g := range groups {
+			groupIDs[i] = g.ID
+		}
+		if members, err = h.groupMembers(ctx, orgID, groupIDs); err != nil {
+			writeSCIMError(w, err, "Failed to list groups")
+			return
+		}
+	}
+	resources := make([]*scim.Group, len(groups))
+	for i, g := range groups {
+		resources[i] = h.groupResource(g, members[g.ID])
+	}
+
+	scim.WriteJSON(w, http.StatusOK, scim.NewListResponse(resources, len(resources), total, startIndex))
+}
+
+// GET /api/v1/scim/v2/organizations/{orgId}/Groups/{groupId}
+func (h *SCIMHandler) GetGroup(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	h.writeGroup(w, r, vars["orgId"], vars["groupId"], http.StatusOK)
+}
+
+func (h *SCIMHandler) writeGroup(w http.ResponseWriter, r *http.Request, orgID, groupID string, status int) {
+	ctx := r.Context()
+	g, err := scanSCIMGroup(h.db.QueryRow(ctx, `
+		SELECT `+scimGroupColumns+` FROM scim_groups WHERE organization_id = $1 AND id = $2
+	`, orgID, groupID))
+	if errors.Is(err, pgx.ErrNoRows) {
+		err = scim.NotFound("Group %s not found", groupID)
+	}
+	if err != nil {
+		writeSCIMError(w, err, "Failed to fetch group")
+		return
+	}
+	members, err := h.groupMembers(ctx, orgID, []string{groupID})
+	if err != nil {
+		writeSCIMError(w, err, "Failed to fetch group")
+		return
+	}
+
+	group := h.groupResource(g, members[groupID])
+	if status == http.StatusCreated {
+		w.Header().Set("Location", group.Meta.Location)
+	}
+	scim.WriteJSON(w, status, group)
+}
+
+// POST /api/v1/scim/v2/organizations/{orgId}/Groups
+//
+// New groups grant nothing until an admin maps them.
+func (h *SCIMHandler) CreateGroup(w http.ResponseWriter, r *http.Request) {
+	orgID := mux.Vars(r)["orgId"]
+	ctx := context.Background()
+
+	var req scim.Group
+	if err := decodeSCIM(w, r, &req); err != nil {
+		writeSCIMError(w, err, "")
+		return
+	}
+	req.DisplayName = strings.TrimSpace(req.DisplayName)
+	if req.DisplayName == "" {
+		writeSCIMError(w, scim.BadRequest(scim.TypeInvalidValue, "displayName is required"), "")
+		return
+	}
+	memberIDs := make([]string, len(req.Members))
+	for i, m := range req.Members {
+		memberIDs[i] = m.Value
+	}
+
+	groupID := uuid.New().String()
+	var roleChanges []scimRoleChange
+	err := h.inTx(ctx, orgID, func(tx pgx.Tx) error {
+		err := execTx(ctx, tx, `
+			INSERT INTO scim_groups (id, organization_id, display_name, external_id)
+			VALUES ($1, $2, $3, NULLIF($4, ''))
+		`, groupID, orgID, req.DisplayName, req.ExternalID)
+		if isUniqueViolation(err) {
+			return scim.Errorf(http.StatusConflict, scim.TypeUniqueness, "A group named %s already exists", req.DisplayName)
+		}
+		if err != nil {
+			return err
+		}
+		changed, err := setSCIMGroupMembers(ctx, tx, orgID, groupID, memberIDs)
+		if err != nil {
+			return err
+		}
+		roleChanges, err = syncSCIMMembers(ctx, tx, orgID, changed)
+		return err
+	})
+	if err != nil {
+		writeSCIMError(w, err, "Failed to create group")
+		return
+	}
+
+	h.record(r, audit.Event{
+		OrganizationID: orgID,
+		Action:         audit.ActionSCIMGroupCreated,
+		TargetType:     audit.TargetSCIMGroup,
+		TargetID:       groupID,
+		Metadata:       map[string]interface{}{"display_name": req.DisplayName, "members": len(memberIDs)},
+	})
+	h.recordRoleChanges(r, orgID, roleChanges)
+
+	h.writeGroup(w, r, orgID, groupID, http.StatusCreated)
+}
+
+// PUT /api/v1/scim/v2/organizations/{orgId}/Groups/{groupId}
+func (h *SCIMHandler) ReplaceGroup(w http.ResponseWriter, r *http.Request) {
+	var req scim.Group
+	if err := decodeSCIM(w, r, &req); err != nil {
+		writeSCIMError(w, err, "")
+		return
+	}
+	h.updateGroup(w, r, func(g *models.SCIMGroup, members map[string]bool) error {
+		if name := strings.TrimSpace(req.DisplayName); name != "" {
+			g.DisplayName = name
+		}
+		g.ExternalID = emptyToNull(req.ExternalID)
+		for id := range members {
+			delete(members, id)
+		}
+		for _, m := range req.Members {
+			members[m.Value] = true
+		}
+		return nil
+	})
+}
+
+// PATCH /api/v1/scim/v2/organizations/{orgId}/Groups/{groupId}
+//
+// Adds, removes and replaces members, and renames the group.
+func (h *SCIMHandler) PatchGroup(w http.ResponseWriter, r *http.Request) {
+	var req scim.PatchRequest
+	err := decodeSCIM(w, r, &req)
+	if err == nil {
+		err = req.Validate()
+	}
+	if err != nil {
+		writeSCIMError(w, err, "")
+		return
+	}
+
+	h.updateGroup(w, r, func(g *models.SCIMGroup, members map[string]bool) error {
+		for i := range req.Operations {
+			op := &req.Operations[i]
+			if op.Op == scim.OpRemove {
+				if id, ok := op.MemberFilter(); ok {
+					delete(members, id)
+					continue
+				}
+				switch op.Attribute() {
+				case "members":
+					ids, err := scim.ParseMembers(op.Value)
+					if err != nil {
+						return err
+					}
+					if ids == nil {
+						for id := range members {
+							delete(members, id)
+						}
+					}
+					for _, id := range ids {
+						delete(members, id)
+					}
+				case "externalid":
+					g.ExternalID = nil
+				default:
+					return scim.BadRequest(scim.TypeInvalidPath, "Cannot remove %s", op.Path)
+				}
+				continue
+			}
+
+			values, err := op.Values()
+			if err != nil {
+				return err
+			}
+			for attr, raw := range values {
+				switch attr {
+				case "members":
+					ids, err := scim.ParseMembers(raw)
+					if err != nil {
+						return err
+					}
+					if op.Op == scim.OpReplace {
+						for id := range members {
+							delete(members, id)
+						}
+					}
+					for _, id := range ids {
+						members[id] = true
+					}
+				case "displayname":
+					name, err := scim.ParseString(raw)
+					if err != nil {
+						return err
+					}
+					if name = strings.TrimSpace(name); name == "" {
+						return scim.BadRequest(scim.TypeInvalidValue, "displayName cannot be empty")
+					}
+					g.DisplayName = name
+				case "externalid":
+					id, err := scim.ParseString(raw)
+					if err != nil {
+						return err
+					}
+					g.ExternalID = emptyToNull(id)
+				}
+			}
+		}
+		return nil
+	})
+}
+
+// updateGroup loads the group in the path and its member IDs, lets change
+// edit them, and writes the result.
+func (h *SCIMHandler) updateGroup(w http.ResponseWriter, r *http.Request, change func(g *models.SCIMGroup, members map[string]bool) error) {
+	vars := mux.Vars(r)
+	orgID, groupID := vars["orgId"], vars["groupId"]
+	ctx := context.Background()
+
+	var before, after *models.SCIMGroup
+	var added, removed int
+	var roleChanges []scimRoleChange
+	err := h.inTx(ctx, orgID, func(tx pgx.Tx) error {
+		g, err := getSCIMGroup(ctx, tx, orgID, groupID)
+		if err != nil {
+			return err
+		}
+		before = g
+		copied := *g
+		after = &copied
+
+		current, err := scimGroupMemberIDs(ctx, tx, groupID)
+		if err != nil {
+			return err
+		}
+		members := make(map[string]bool, len(current))
+		for _, id := range current {
+			members[id] = true
+		}
+		if err := change(after, members); err != nil {
+			return err
+		}
+
+		err = execTx(ctx, tx, `
+			UPDATE scim_groups SET display_name = $2, external_id = $3 WHERE id = $1
+		`, groupID, after.DisplayName, after.ExternalID)
+		if isUniqueViolation(err) {
+			return scim.Errorf(http.StatusConflict, scim.TypeUniqueness, "A group named %s already exists", after.DisplayName)
+		}
+		if err != nil {
+			return err
+		}
+
+		desired := make([]string, 0, len(members))
+		for id := range members {
+			desired = append(desired, id)
+		}
+		changed, err := setSCIMGroupMembers(ctx, tx, orgID, groupID, desired)
+		if err != nil {
+			return err
+		}
+		for _, id := range changed {
+			if members[id] {
+				added++
+			} else {
+				removed++
+			}
+		}
+		roleChanges, err = syncSCIMMembers(ctx, tx, orgID, changed)
+		return err
+	})
+	if err != nil {
+		writeSCIMError(w, err, "Failed to update group")
+		return
+	}
+
+	if before.DisplayName != after.DisplayName || deref(before.ExternalID) != deref(after.ExternalID) || added > 0 || removed > 0 {
+		h.record(r, audit.Event{
+			OrganizationID: orgID,
+			Action:         audit.ActionSCIMGroupUpdated,
+			TargetType:     audit.TargetSCIMGroup,
+			TargetID:       groupID,
+			Metadata: map[string]interface{}{
+				"display_name":    after.DisplayName,
+				"previous_name":   before.DisplayName,
+				"members_added":   added,
+				"members_removed": removed,
+			},
+		})
+	}
+	h.recordRoleChanges(r, orgID, roleChanges)
+
+	h.writeGroup(w, r, orgID, groupID, http.StatusOK)
+}
+
+// DELETE /api/v1/scim/v2/organizations/{orgId}/Groups/{groupId}
+//
+// Its members lose what the group granted them.
+func (h *SCIMHandler) DeleteGroup(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	orgID, groupID := vars["orgId"], vars["groupId"]
+	ctx := context.Background()
+
+	var g *models.SCIMGroup
+	var roleChanges []scimRoleChange
+	err := h.inTx(ctx, orgID, func(tx pgx.Tx) error {
+		var err error
+		g, err = getSCIMGroup(ctx, tx, orgID, groupID)
+		if err != nil {
+			return err
+		}
+		members, err := scimGroupMemberIDs(ctx, tx, groupID)
+		if err != nil {
+			return err
+		}
+		if err := execTx(ctx, tx, "DELETE FROM scim_groups WHERE id = $1", groupID); err != nil {
+			return err
+		}
+		roleChanges, err = syncSCIMMembers(ctx, tx, orgID, members)
+		return err
+	})
+	if err != nil {
+		writeSCIMError(w, err, "Failed to delete group")
+		return
+	}
+
+	h.record(r, audit.Event{
+		OrganizationID: orgID,
+		Action:         audit.ActionSCIMGroupDeleted,
+		TargetType:     audit.TargetSCIMGroup,
+		TargetID:       groupID,
+		Metadata:       map[string]interface{}{"display_name": g.DisplayName, "members": g.MemberCount},
+	})
+	h.recordRoleChanges(r, orgID, roleChanges)
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func scimGroupMemberIDs(ctx context.Context, tx pgx.Tx, groupID string) ([]string, error) {
+	rows, err := tx.Query(ctx, "SELECT user_id FROM scim_group_members WHERE group_id = $1", groupID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var ids []string
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
+
+// setSCIMGroupMembers makes userIDs the group's members and returns the
+// users added or removed. Members added become managed by SCIM; users who
+// are not members of the organization, or are service accounts, are
+// refused.
+func setSCIMGroupMembers(ctx context.Context, tx pgx.Tx, orgID, groupID string, userIDs []string) ([]string, error) {
+	if userIDs == nil {
+		userIDs = []string{}
+	}
+	var unknown []string
+	err := tx.QueryRow(ctx, `
+		SELECT COALESCE(array_agg(requested.uid), '{}') FROM unnest($2::text[]) AS requested(uid)
+		WHERE NOT EXISTS (
+			SELECT 1 FROM organization_members om
+			WHERE om.organization_id = $1 AND om.user_id = requested.uid
+				AND NOT EXISTS (SELECT 1 FROM service_accounts sa WHERE sa.user_id = om.user_id)
+		)
+	`, orgID, userIDs).Scan(&unknown)
+	if err != nil {
+		return nil, err
+	}
+	if len(unknown) > 0 {
+		return nil, scim.BadRequest(scim.TypeInvalidValue, "Not users of this organization: %s", strings.Join(unknown, ", "))
+	}
+
+	rows, err := tx.Query(ctx, `
+		DELETE FROM scim_group_members WHERE group_id = $1 AND NOT (user_id = ANY($2))
+		RETURNING user_id
+	`, groupID, userIDs)
+	if err != nil {
+		return nil, err
+	}
+	var changed []string
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		changed = append(changed, id)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	rows, err = tx.Query(ctx, `
+		INSERT INTO scim_group_members (group_id, user_id)
+		SELECT $1, requested.uid FROM unnest($2::text[]) AS requested(uid)
+		ON CONFLICT DO NOTHING
+		RETURNING user_id
+	`, groupID, userIDs)
+	if err != nil {
+		return nil, err
+	}
+	var added []string
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		added = append(added, id)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	if err := execTx(ctx, tx, `
+		UPDATE organization_members SET scim_managed = TRUE
+		WHERE organization_id = $1 AND user_id = ANY($2) AND NOT scim_managed
+	`, orgID, added); err != nil {
+		return nil, err
+	}
+	return append(changed, added...), nil
+}
+
+// scimRoleChange is an organization role changed by a member's SCIM
+// groups.
+type scimRoleChange struct {
+	memberID string
+	email    string
+	from     string
+	to       string
+}
+
+// syncSCIMMembers brings the role and project access of the SCIM-managed
+// members among userIDs in line with their groups. Groups decide roles
+// once any of the organization's groups maps to a role: the highest role
+// mapped, or member. They decide project access once any group maps to a
+// project: the member then sees only the projects granted to them, with
+// the highest role their groups map, besides roles granted in the app.
+// Owners keep their role.
+func syncSCIMMembers(ctx context.Context, tx pgx.Tx, orgID string, userIDs []string) ([]scimRoleChange, error) {
+	if len(userIDs) == 0 {
+		return nil, nil
+	}
+
+	var rolesMapped, projectsMapped bool
+	err := tx.QueryRow(ctx, `
+		SELECT
+			EXISTS (SELECT 1 FROM scim_groups WHERE organization_id = $1 AND role IS NOT NULL),
+			EXISTS (SELECT 1 FROM scim_groups WHERE organization_id = $1 AND project_id IS NOT NULL AND project_role IS NOT NULL)
+	`, orgID).Scan(&rolesMapped, &projectsMapped)
+	if err != nil {
+		return nil, err
+	}
+
+	var changes []scimRoleChange
+	for _, userID := range userIDs {
+		var memberID, email, role string
+		var managed bool
+		err := tx.QueryRow(ctx, `
+			SELECT id, email, role, scim_managed FROM organization_members
+			WHERE organization_id = $1 AND user_id = $2
+		`, orgID, userID).Scan(&memberID, &email, &role, &managed)
+		if errors.Is(err, pgx.ErrNoRows) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		if !managed {
+			continue
+		}
+
+		grants, err := scimGrants(ctx, tx, orgID, userID)
+		if err != nil {
+			return nil, err
+		}
+
+		if rolesMapped && role != "owner" {
+			want := "member"
+			for _, g := range grants {
+				if g.role == "admin" {
+					want = "admin"
+				}
+			}
+			if want != role {
+				if err := execTx(ctx, tx, "UPDATE organization_members SET role = $1 WHERE id = $2", want, memberID); err != nil {
+					return nil, err
+				}
+				changes = append(changes, scimRoleChange{memberID: memberID, email: email, from: role, to: want})
+			}
+		}
+
+		projects := map[string]string{}
+		for _, g := range grants {
+			if g.projectID != "" && authz.ProjectRoleRank[g.projectRole] > authz.ProjectRoleRank[projects[g.projectID]] {
+				projects[g.projectID] = g.projectRole
+			}
+		}
+		projectIDs := make([]string, 0, len(projects))
+		for projectID, projectRole := range projects {
+			projectIDs = append(projectIDs, projectID)
+			// Roles granted in the app are left alone
+			if err := execTx(ctx, tx, `
+				INSERT INTO project_members (id, project_id, user_id, role, scim_managed)
+				VALUES ($1, $2, $3, $4, TRUE)
+				ON CONFLICT (project_id, user_id) DO UPDATE SET role = EXCLUDED.role
+				WHERE project_members.scim_managed AND project_members.role <> EXCLUDED.role
+			`, uuid.New().String(), projectID, userID, projectRole); err != nil {
+				return nil, err
+			}
+		}
+		if err := execTx(ctx, tx, `
+			DELETE FROM project_members
+			WHERE user_id = $1 AND scim_managed AND NOT (project_id = ANY($3))
+				AND project_id IN (SELECT id FROM projects WHERE organization_id = $2)
+		`, userID, orgID, projectIDs); err != nil {
+			return nil, err
+		}
+		access := "all"
+		if projectsMapped {
+			access = "specific"
+		}
+		if err := execTx(ctx, tx, `
+			UPDATE organization_members SET project_access = $2 WHERE id = $1 AND project_access <> $2
+		`, memberID, access); err != nil {
+			return nil, err
+		}
+	}
+	return changes, nil
+}
+
+// scimGrant is what one of a member's groups maps to.
+type scimGrant struct {
+	role        string
+	projectID   string
+	projectRole string
+}
+
+func scimGrants(ctx context.Context, tx pgx.Tx, orgID, userID string) ([]scimGrant, error) {
+	rows, err := tx.Query(ctx, `
+		SELECT COALESCE(g.role, ''), COALESCE(g.project_id, ''), COALESCE(g.project_role, '')
+		FROM scim_groups g
+		JOIN scim_group_members gm ON gm.group_id = g.id
+		WHERE g.organization_id = $1 AND gm.user_id = $2
+	`, orgID, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var grants []scimGrant
+	for rows.Next() {
+		var g scimGrant
+		if err := rows.Scan(&g.role, &g.projectID, &g.projectRole); err != nil {
+			return nil, err
+		}
+		if g.projectID == "" || g.projectRole == "" {
+			g.projectID, g.projectRole = "", ""
+		}
+		grants = append(grants, g)
+	}
+	return grants, rows.Err()
+}
+
+func (h *SCIMHandler) recordRoleChanges(r *http.Request, orgID string, changes []scimRoleChange) {
+	sort.Slice(changes, func(i, j int) bool { return changes[i].email < changes[j].email })
+	for _, c := range changes {
+		h.record(r, audit.Event{
+			OrganizationID: orgID,
+			Action:         audit.ActionMemberRoleChanged,
+			TargetType:     audit.TargetMember,
+			TargetID:       c.memberID,
+			Metadata:       map[string]interface{}{"email": c.email, "from": c.from, "to": c.to},
+		})
+	}
+}
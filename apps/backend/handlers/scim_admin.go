@@ -0,0 +1,298 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"go-backend/audit"
+	"go-backend/auth"
+	"go-backend/authz"
+	"go-backend/middleware"
+	"go-backend/models"
+	"go-backend/quota"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+	"github.com/jackc/pgx/v5"
+	"github.com/rs/zerolog/log"
+)
+
+const scimTokenColumns = `id, organization_id, name, prefix, created_by, created_at, last_used_at, revoked_at, revoked_by`
+
+func scanSCIMToken(row pgx.Row) (*models.SCIMToken, error) {
+	var t models.SCIMToken
+	err := row.Scan(&t.ID, &t.OrganizationID, &t.Name, &t.Prefix, &t.CreatedBy, &t.CreatedAt,
+		&t.LastUsedAt, &t.RevokedAt, &t.RevokedBy)
+	if err != nil {
+		return nil, err
+	}
+	return &t, nil
+}
+
+// GET /api/v1/users/{userId}/organizations/{orgId}/scim/tokens
+func (h *SCIMHandler) ListTokens(w http.ResponseWriter, r *http.Request) {
+	orgID := mux.Vars(r)["orgId"]
+
+	rows, err := h.db.Query(r.Context(), `
+		SELECT `+scimTokenColumns+` FROM scim_tokens WHERE organization_id = $1 ORDER BY created_at DESC
+	`, orgID)
+	if err != nil {
+		writeMemberError(w, err, "Failed to fetch SCIM tokens")
+		return
+	}
+	defer rows.Close()
+
+	tokens := []models.SCIMToken{}
+	for rows.Next() {
+		t, err := scanSCIMToken(rows)
+		if err != nil {
+			log.Error().Err(err).Msg("Failed to scan SCIM token")
+			continue
+		}
+		t.BaseURL = h.baseURL(orgID)
+		tokens = append(tokens, *t)
+	}
+
+	middleware.WriteJSONResponse(w, http.StatusOK, map[string]interface{}{
+		"data": tokens,
+	})
+}
+
+// POST /api/v1/users/{userId}/organizations/{orgId}/scim/tokens
+//
+// Requires a plan with SCIM. The token is in this response only.
+func (h *SCIMHandler) CreateToken(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	userID := vars["userId"]
+	orgID := vars["orgId"]
+
+	var req models.CreateSCIMTokenRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	req.Name = strings.TrimSpace(req.Name)
+	if req.Name == "" || len(req.Name) > 100 {
+		http.Error(w, "name is required and must be at most 100 characters", http.StatusBadRequest)
+		return
+	}
+
+	ctx := context.Background()
+	if err := h.quotas.RequireFeature(ctx, quota.OrganizationSubject(orgID), quota.FeatureSCIM); err != nil {
+		writeMemberError(w, err, "Failed to check plan features")
+		return
+	}
+
+	token, hash, prefix, err := auth.GenerateSCIMToken()
+	if err != nil {
+		writeMemberError(w, err, "Failed to create SCIM token")
+		return
+	}
+	t, err := scanSCIMToken(h.db.QueryRow(ctx, `
+		INSERT INTO scim_tokens (id, organization_id, name, prefix, token_hash, created_by)
+		VALUES ($1, $2, $3, $4, $5, $6)
+		RETURNING `+scimTokenColumns,
+		uuid.New().String(), orgID, req.Name, prefix, hash, userID))
+	if err != nil {
+		writeMemberError(w, err, "Failed to create SCIM token")
+		return
+	}
+
+	h.audit.Record(r, audit.Event{
+		OrganizationID: orgID,
+		Action:         audit.ActionSCIMTokenCreated,
+		TargetType:     audit.TargetSCIMToken,
+		TargetID:       t.ID,
+		Metadata:       map[string]interface{}{"name": t.Name, "prefix": t.Prefix},
+	})
+
+	t.Token = token
+	t.BaseURL = h.baseURL(orgID)
+	middleware.WriteJSONResponse(w, http.StatusCreated, t)
+}
+
+// DELETE /api/v1/users/{userId}/organizations/{orgId}/scim/tokens/{tokenId}
+//
+// Revokes the token. Revoking twice is harmless.
+func (h *SCIMHandler) RevokeToken(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	userID := vars["userId"]
+	orgID := vars["orgId"]
+
+	ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
+	defer cancel()
+
+	t, err := scanSCIMToken(h.db.QueryRow(ctx, `
+		UPDATE scim_tokens SET revoked_at = COALESCE(revoked_at, NOW()), revoked_by = COALESCE(revoked_by, $1)
+		WHERE id = $2 AND organization_id = $3
+		RETURNING `+scimTokenColumns,
+		userID, vars["tokenId"], orgID))
+	if errors.Is(err, pgx.ErrNoRows) {
+		http.Error(w, "SCIM token not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		writeMemberError(w, err, "Failed to revoke SCIM token")
+		return
+	}
+
+	h.audit.Record(r, audit.Event{
+		OrganizationID: orgID,
+		Action:         audit.ActionSCIMTokenRevoked,
+		TargetType:     audit.TargetSCIMToken,
+		TargetID:       t.ID,
+		Metadata:       map[string]interface{}{"name": t.Name, "prefix": t.Prefix},
+	})
+
+	t.BaseURL = h.baseURL(orgID)
+	middleware.WriteJSONResponse(w, http.StatusOK, t)
+}
+
+// GET /api/v1/users/{userId}/organizations/{orgId}/scim/groups
+func (h *SCIMHandler) ListGroupMappings(w http.ResponseWriter, r *http.Request) {
+	orgID := mux.Vars(r)["orgId"]
+
+	rows, err := h.db.Query(r.Context(), `
+		SELECT `+scimGroupColumns+` FROM scim_groups WHERE organization_id = $1 ORDER BY display_name
+	`, orgID)
+	if err != nil {
+		writeMemberError(w, err, "Failed to fetch SCIM groups")
+		return
+	}
+	defer rows.Close()
+
+	groups := []models.SCIMGroup{}
+	for rows.Next() {
+		g, err := scanSCIMGroup(rows)
+		if err != nil {
+			log.Error().Err(err).Msg("Failed to scan SCIM group")
+			continue
+		}
+		groups = append(groups, *g)
+	}
+
+	middleware.WriteJSONResponse(w, http.StatusOK, map[string]interface{}{
+		"data": groups,
+	})
+}
+
+// PUT /api/v1/users/{userId}/organizations/{orgId}/scim/groups/{groupId}
+//
+// Sets what the group grants its members, and brings every SCIM-managed
+// member in line: mapping a first group to a role or a project hands
+// roles or project access of all of them over to their groups.
+func (h *SCIMHandler) MapGroup(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	orgID := vars["orgId"]
+	groupID := vars["groupId"]
+
+	var req models.MapSCIMGroupRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	role := emptyToNull(strings.TrimSpace(stringOr(req.Role, "")))
+	projectID := emptyToNull(strings.TrimSpace(stringOr(req.ProjectID, "")))
+	projectRole := emptyToNull(strings.TrimSpace(stringOr(req.ProjectRole, "")))
+	if role != nil && *role != "admin" && *role != "member" {
+		http.Error(w, "role must be admin or member", http.StatusBadRequest)
+		return
+	}
+	if (projectID == nil) != (projectRole == nil) {
+		http.Error(w, "project_id and project_role must be set together", http.StatusBadRequest)
+		return
+	}
+	if projectRole != nil {
+		if _, ok := authz.ProjectRoleRank[*projectRole]; !ok {
+			http.Error(w, "project_role must be viewer, editor or admin", http.StatusBadRequest)
+			return
+		}
+	}
+
+	ctx := context.Background()
+	var g *models.SCIMGroup
+	var roleChanges []scimRoleChange
+	err := h.inTx(ctx, orgID, func(tx pgx.Tx) error {
+		if projectID != nil {
+			var exists bool
+			if err := tx.QueryRow(ctx, `
+				SELECT EXISTS (SELECT 1 FROM projects WHERE id = $1 AND organization_id = $2)
+			`, *projectID, orgID).Scan(&exists); err != nil {
+				return err
+			}
+			if !exists {
+				return &memberError{http.StatusBadRequest, "project_id is not a project of this organization"}
+			}
+		}
+
+		var err error
+		g, err = scanSCIMGroup(tx.QueryRow(ctx, `
+			UPDATE scim_groups SET role = $3, project_id = $4, project_role = $5
+			WHERE organization_id = $1 AND id = $2
+			RETURNING `+scimGroupColumns,
+			orgID, groupID, role, projectID, projectRole))
+		if errors.Is(err, pgx.ErrNoRows) {
+			return &memberError{http.StatusNotFound, "SCIM group not found"}
+		}
+		if err != nil {
+			return err
+		}
+
+		// Mapping one group can change what every other group decides
+		rows, err := tx.Query(ctx, `
+			SELECT user_id FROM organization_members WHERE organization_id = $1 AND scim_managed
+		`, orgID)
+		if err != nil {
+			return err
+		}
+		var userIDs []string
+		for rows.Next() {
+			var id string
+			if err := rows.Scan(&id); err != nil {
+				rows.Close()
+				return err
+			}
+			userIDs = append(userIDs, id)
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return err
+		}
+		roleChanges, err = syncSCIMMembers(ctx, tx, orgID, userIDs)
+		return err
+	})
+	if err != nil {
+		writeMemberError(w, err, "Failed to map SCIM group")
+		return
+	}
+
+	h.audit.Record(r, audit.Event{
+		OrganizationID: orgID,
+		Action:         audit.ActionSCIMGroupMapped,
+		TargetType:     audit.TargetSCIMGroup,
+		TargetID:       g.ID,
+		Metadata: map[string]interface{}{
+			"display_name": g.DisplayName,
+			"role":         deref(g.Role),
+			"project_id":   deref(g.ProjectID),
+			"project_role": deref(g.ProjectRole),
+		},
+	})
+	for _, c := range roleChanges {
+		h.audit.Record(r, audit.Event{
+			OrganizationID: orgID,
+			Action:         audit.ActionMemberRoleChanged,
+			TargetType:     audit.TargetMember,
+			TargetID:       c.memberID,
+			Metadata:       map[string]interface{}{"email": c.email, "from": c.from, "to": c.to, "source": "scim_group"},
+		})
+	}
+
+	middleware.WriteJSONResponse(w, http.StatusOK, map[string]interface{}{
+		"data": g,
+	})
+}
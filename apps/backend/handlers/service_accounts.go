@@ -0,0 +1,341 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"go-backend/audit"
+	"go-backend/auth"
+	"go-backend/authz"
+	"go-backend/database"
+	"go-backend/middleware"
+	"go-backend/models"
+	"go-backend/quota"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+	"github.com/jackc/pgx/v5"
+	"github.com/rs/zerolog/log"
+)
+
+// ServiceAccountHandler manages an organization's service accounts:
+// non-human members that act through API keys. Each is a users row with an
+// "sa_" ID and a membership created with it, so roles, suspension and
+// project access are managed through the member and project member routes
+// like anyone else's. Service accounts take a seat and cannot become owners.
+type ServiceAccountHandler struct {
+	db     *database.PostgresDB
+	quotas *quota.Service
+	audit  *audit.Logger
+}
+
+func NewServiceAccountHandler(db *database.PostgresDB, quotas *quota.Service, auditLog *audit.Logger) *ServiceAccountHandler {
+	return &ServiceAccountHandler{db: db, quotas: quotas, audit: auditLog}
+}
+
+var errServiceAccountNotFound = &memberError{http.StatusNotFound, "Service account not found"}
+
+const serviceAccountColumns = `sa.user_id, sa.organization_id, sa.name, sa.description, om.email, om.id, om.role,
+	om.status, om.project_access, sa.created_by, sa.created_at, sa.updated_at`
+
+const serviceAccountFrom = `service_accounts sa
+	JOIN organization_members om ON om.organization_id = sa.organization_id AND om.user_id = sa.user_id`
+
+func scanServiceAccount(row pgx.Row) (*models.ServiceAccount, error) {
+	var a models.ServiceAccount
+	err := row.Scan(&a.ID, &a.OrganizationID, &a.Name, &a.Description, &a.Email, &a.MemberID, &a.Role,
+		&a.Status, &a.ProjectAccess, &a.CreatedBy, &a.CreatedAt, &a.UpdatedAt)
+	if err != nil {
+		return nil, err
+	}
+	return &a, nil
+}
+
+func getServiceAccount(ctx context.Context, tx pgx.Tx, orgID, accountID string) (*models.ServiceAccount, error) {
+	a, err := scanServiceAccount(tx.QueryRow(ctx, `
+		SELECT `+serviceAccountColumns+` FROM `+serviceAccountFrom+`
+		WHERE sa.organization_id = $1 AND sa.user_id = $2
+	`, orgID, accountID))
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, errServiceAccountNotFound
+	}
+	return a, err
+}
+
+// withServiceAccounts runs fn inside a transaction holding the organization
+// lock, after checking the caller is one of its active owners or admins.
+func (h *ServiceAccountHandler) withServiceAccounts(w http.ResponseWriter, r *http.Request, failure string,
+	fn func(ctx context.Context, tx pgx.Tx, userID, orgID string) (*models.ServiceAccount, error)) (*models.ServiceAccount, bool) {
+	userID, orgID, ok := authorizeOrganizationUser(w, r)
+	if !ok {
+		return nil, false
+	}
+
+	ctx := context.Background()
+	tx, err := h.db.GetPool().Begin(ctx)
+	if err != nil {
+		writeMemberError(w, err, failure)
+		return nil, false
+	}
+	defer tx.Rollback(ctx)
+
+	actorRole, err := lockOrganization(ctx, tx, orgID, userID)
+	if err != nil {
+		writeMemberError(w, err, failure)
+		return nil, false
+	}
+	if actorRole != "owner" && actorRole != "admin" {
+		writeMemberError(w, &memberError{http.StatusForbidden, "Only organization owners and admins can manage service accounts"}, failure)
+		return nil, false
+	}
+
+	account, err := fn(ctx, tx, userID, orgID)
+	if err != nil {
+		writeMemberError(w, err, failure)
+		return nil, false
+	}
+	if err := tx.Commit(ctx); err != nil {
+		writeMemberError(w, err, failure)
+		return nil, false
+	}
+	return account, true
+}
+
+// GET /api/v1/users/{userId}/organizations/{orgId}/service-accounts
+func (h *ServiceAccountHandler) ListServiceAccounts(w http.ResponseWriter, r *http.Request) {
+	_, orgID, ok := authorizeOrganizationUser(w, r)
+	if !ok {
+		return
+	}
+
+	ctx := context.Background()
+
+	rows, err := h.db.Query(ctx, `
+		SELECT `+serviceAccountColumns+` FROM `+serviceAccountFrom+`
+		WHERE sa.organization_id = $1
+		ORDER BY sa.created_at
+	`, orgID)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to query service accounts")
+		http.Error(w, "Failed to fetch service accounts", http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	accounts := []models.ServiceAccount{}
+	for rows.Next() {
+		a, err := scanServiceAccount(rows)
+		if err != nil {
+			log.Error().Err(err).Msg("Failed to scan service account")
+			continue
+		}
+		accounts = append(accounts, *a)
+	}
+
+	middleware.WriteJSONResponse(w, http.StatusOK, map[string]interface{}{
+		"data": accounts,
+	})
+}
+
+// POST /api/v1/users/{userId}/organizations/{orgId}/service-accounts
+//
+// Creates the account and its membership. With project_access_type
+// "specific" the account is granted project_role on each named project and
+// sees no others.
+func (h *ServiceAccountHandler) CreateServiceAccount(w http.ResponseWriter, r *http.Request) {
+	var req models.CreateServiceAccountRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	req.Name = strings.TrimSpace(req.Name)
+	if req.Name == "" || len(req.Name) > 100 {
+		http.Error(w, "name is required and must be at most 100 characters", http.StatusBadRequest)
+		return
+	}
+	if req.Role == "" {
+		req.Role = "member"
+	}
+	if req.Role != "admin" && req.Role != "member" {
+		http.Error(w, "role must be admin or member", http.StatusBadRequest)
+		return
+	}
+	projectAccess := "all"
+	if req.ProjectAccessType != nil && *req.ProjectAccessType == "specific" {
+		projectAccess = "specific"
+	}
+	projectRole := models.ProjectRoleViewer
+	if req.ProjectRole != nil {
+		if _, ok := authz.ProjectRoleRank[*req.ProjectRole]; !ok {
+			http.Error(w, "project_role must be viewer, editor or admin", http.StatusBadRequest)
+			return
+		}
+		projectRole = *req.ProjectRole
+	}
+
+	account, ok := h.withServiceAccounts(w, r, "Failed to create service account", func(ctx context.Context, tx pgx.Tx, userID, orgID string) (*models.ServiceAccount, error) {
+		// Pending invitations hold seats too
+		if _, err := h.quotas.CheckInvitation(ctx, orgID); err != nil {
+			return nil, err
+		}
+
+		accountID := auth.ServiceAccountPrefix + strings.ReplaceAll(uuid.New().String(), "-", "")
+		email := accountID + "@" + auth.ServiceAccountEmailDomain
+		now := time.Now()
+
+		if err := execTx(ctx, tx, `
+			INSERT INTO users (user_id, email, role, created_at, updated_at)
+			VALUES ($1, $2, 'user', CURRENT_TIMESTAMP, CURRENT_TIMESTAMP)
+		`, accountID, email); err != nil {
+			return nil, err
+		}
+		if err := execTx(ctx, tx, `
+			INSERT INTO service_accounts (user_id, organization_id, name, description, created_by)
+			VALUES ($1, $2, $3, $4, $5)
+		`, accountID, orgID, req.Name, req.Description, userID); err != nil {
+			return nil, err
+		}
+		if err := execTx(ctx, tx, `
+			INSERT INTO organization_members
+			(id, organization_id, user_id, email, role, status, joined_at, invited_at, invited_by, project_access)
+			VALUES ($1, $2, $3, $4, $5, 'active', $6, $6, $7, $8)
+		`, uuid.New().String(), orgID, accountID, email, req.Role, now, userID, projectAccess); err != nil {
+			return nil, err
+		}
+
+		if projectAccess == "specific" {
+			for _, projectID := range req.SpecificProjects {
+				tag, err := tx.Exec(ctx, `
+					INSERT INTO project_members (id, project_id, user_id, role, added_by, created_at, updated_at)
+					SELECT $1, id, $2, $3, $4, $5, $5 FROM projects
+					WHERE id = $6 AND organization_id = $7
+					ON CONFLICT (project_id, user_id) DO NOTHING
+				`, uuid.New().String(), accountID, projectRole, userID, now, projectID, orgID)
+				if err != nil {
+					return nil, err
+				}
+				if tag.RowsAffected() == 0 {
+					return nil, &memberError{http.StatusBadRequest, "Project not found in organization: " + projectID}
+				}
+			}
+		}
+
+		return getServiceAccount(ctx, tx, orgID, accountID)
+	})
+	if !ok {
+		return
+	}
+
+	metadata := map[string]interface{}{
+		"name":           account.Name,
+		"role":           account.Role,
+		"project_access": account.ProjectAccess,
+	}
+	if projectAccess == "specific" {
+		metadata["specific_projects"] = req.SpecificProjects
+		metadata["project_role"] = projectRole
+	}
+	h.audit.Record(r, audit.Event{
+		OrganizationID: account.OrganizationID,
+		Action:         audit.ActionServiceAccountCreated,
+		TargetType:     audit.TargetServiceAccount,
+		TargetID:       account.ID,
+		Metadata:       metadata,
+	})
+
+	middleware.WriteJSONResponse(w, http.StatusCreated, map[string]interface{}{
+		"data": account,
+	})
+}
+
+// PUT /api/v1/users/{userId}/organizations/{orgId}/service-accounts/{accountId}
+//
+// Renames the account or changes its description. Its role and access are
+// changed through the member routes.
+func (h *ServiceAccountHandler) UpdateServiceAccount(w http.ResponseWriter, r *http.Request) {
+	var req models.UpdateServiceAccountRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if req.Name != nil {
+		name := strings.TrimSpace(*req.Name)
+		if name == "" || len(name) > 100 {
+			http.Error(w, "name must be 1 to 100 characters", http.StatusBadRequest)
+			return
+		}
+		req.Name = &name
+	}
+
+	account, ok := h.withServiceAccounts(w, r, "Failed to update service account", func(ctx context.Context, tx pgx.Tx, userID, orgID string) (*models.ServiceAccount, error) {
+		accountID := mux.Vars(r)["accountId"]
+		if _, err := getServiceAccount(ctx, tx, orgID, accountID); err != nil {
+			return nil, err
+		}
+		if err := execTx(ctx, tx, `
+			UPDATE service_accounts
+			SET name = COALESCE($3, name), description = COALESCE($4, description)
+			WHERE organization_id = $1 AND user_id = $2
+		`, orgID, accountID, req.Name, req.Description); err != nil {
+			return nil, err
+		}
+		return getServiceAccount(ctx, tx, orgID, accountID)
+	})
+	if !ok {
+		return
+	}
+
+	h.audit.Record(r, audit.Event{
+		OrganizationID: account.OrganizationID,
+		Action:         audit.ActionServiceAccountUpdated,
+		TargetType:     audit.TargetServiceAccount,
+		TargetID:       account.ID,
+		Metadata: map[string]interface{}{
+			"name":        account.Name,
+			"description": deref(account.Description),
+		},
+	})
+
+	middleware.WriteJSONResponse(w, http.StatusOK, map[string]interface{}{
+		"data": account,
+	})
+}
+
+// DELETE /api/v1/users/{userId}/organizations/{orgId}/service-accounts/{accountId}
+//
+// Deletes the account with its membership, project grants and API keys. Its
+// audit entries are kept.
+func (h *ServiceAccountHandler) DeleteServiceAccount(w http.ResponseWriter, r *http.Request) {
+	account, ok := h.withServiceAccounts(w, r, "Failed to delete service account", func(ctx context.Context, tx pgx.Tx, userID, orgID string) (*models.ServiceAccount, error) {
+		account, err := getServiceAccount(ctx, tx, orgID, mux.Vars(r)["accountId"])
+		if err != nil {
+			return nil, err
+		}
+		if err := execTx(ctx, tx, "DELETE FROM users WHERE user_id = $1", account.ID); err != nil {
+			return nil, err
+		}
+		return account, nil
+	})
+	if !ok {
+		return
+	}
+
+	h.audit.Record(r, audit.Event{
+		OrganizationID: account.OrganizationID,
+		Action:         audit.ActionServiceAccountDeleted,
+		TargetType:     audit.TargetServiceAccount,
+		TargetID:       account.ID,
+		Metadata: map[string]interface{}{
+			"name": account.Name,
+			"role": account.Role,
+		},
+	})
+
+	middleware.WriteJSONResponse(w, http.StatusOK, map[string]interface{}{
+		"data": account,
+	})
+}
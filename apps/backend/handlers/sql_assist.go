@@ -0,0 +1,218 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"go-backend/middleware"
+	"go-backend/sqltools"
+
+	"github.com/gorilla/mux"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/rs/zerolog/log"
+)
+
+// catalogCacheTTL bounds how stale completions can be after a schema change.
+const catalogCacheTTL = time.Minute
+
+type AssistRequest struct {
+	SQL     string `json:"sql"`
+	Offset  int    `json:"offset"`
+	Refresh bool   `json:"refresh,omitempty"`
+}
+
+type AssistResponse struct {
+	sqltools.CompletionResult
+	Diagnostics []sqltools.Diagnostic `json:"diagnostics"`
+}
+
+type FormatRequest struct {
+	SQL     string                 `json:"sql"`
+	Options sqltools.FormatOptions `json:"options,omitempty"`
+}
+
+type FormatResponse struct {
+	Formatted   string `json:"formatted"`
+	Normalized  string `json:"normalized"`
+	Fingerprint string `json:"fingerprint"`
+}
+
+// CompleteQuery returns completions for the cursor position plus lint
+// diagnostics for the whole text, both resolved against the live schema.
+func (h *SQLPlaygroundHandler) CompleteQuery(w http.ResponseWriter, r *http.Request) {
+	userID, req, ok := h.decodeAssistRequest(w, r)
+	if !ok {
+		return
+	}
+
+	catalog, err := h.loadCatalog(r.Context(), userID, req.Refresh)
+	if err != nil {
+		middleware.WriteErrorResponse(w, http.StatusBadRequest, err, "Failed to load your database schema")
+		return
+	}
+
+	if req.Offset < 0 || req.Offset > len([]rune(req.SQL)) {
+		middleware.WriteErrorResponse(w, http.StatusBadRequest, fmt.Errorf("invalid offset"), "offset must be within the SQL text")
+		return
+	}
+
+	response := AssistResponse{
+		CompletionResult: sqltools.Complete(req.SQL, req.Offset, catalog),
+		Diagnostics:      sqltools.Lint(req.SQL, catalog),
+	}
+
+	middleware.WriteJSONResponse(w, http.StatusOK, response)
+}
+
+// LintQuery returns lint diagnostics for the SQL text.
+func (h *SQLPlaygroundHandler) LintQuery(w http.ResponseWriter, r *http.Request) {
+	userID, req, ok := h.decodeAssistRequest(w, r)
+	if !ok {
+		return
+	}
+
+	catalog, err := h.loadCatalog(r.Context(), userID, req.Refresh)
+	if err != nil {
+		middleware.WriteErrorResponse(w, http.StatusBadRequest, err, "Failed to load your database schema")
+		return
+	}
+
+	middleware.WriteJSONResponse(w, http.StatusOK, map[string]interface{}{
+		"diagnostics": sqltools.Lint(req.SQL, catalog),
+	})
+}
+
+// FormatQuery pretty-prints SQL and returns its normalized form and
+// fingerprint. It does not need a database connection.
+func (h *SQLPlaygroundHandler) FormatQuery(w http.ResponseWriter, r *http.Request) {
+	var req FormatRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		middleware.WriteErrorResponse(w, http.StatusBadRequest, err, "Invalid request body")
+		return
+	}
+
+	formatted, err := sqltools.Format(req.SQL, req.Options)
+	if err != nil {
+		middleware.WriteErrorResponse(w, http.StatusBadRequest, err, "Invalid format options")
+		return
+	}
+
+	middleware.WriteJSONResponse(w, http.StatusOK, FormatResponse{
+		Formatted:   formatted,
+		Normalized:  sqltools.Normalize(req.SQL),
+		Fingerprint: sqltools.Fingerprint(req.SQL),
+	})
+}
+
+func (h *SQLPlaygroundHandler) decodeAssistRequest(w http.ResponseWriter, r *http.Request) (string, *AssistRequest, bool) {
+	vars := mux.Vars(r)
+	userID := vars["user_id"]
+
+	var req AssistRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		middleware.WriteErrorResponse(w, http.StatusBadRequest, err, "Invalid request body")
+		return "", nil, false
+	}
+
+	return userID, &req, true
+}
+
+// loadCatalog returns the completion catalog for the user's database, cached
+// in Redis so that per-keystroke requests don't re-introspect the schema.
+func (h *SQLPlaygroundHandler) loadCatalog(ctx context.Context, userID string, refresh bool) (*sqltools.Catalog, error) {
+	cacheKey := fmt.Sprintf("sql_catalog:%s", userID)
+
+	ctx, cancel := context.WithTimeout(ctx, 30*time.Second)
+	defer cancel()
+
+	if h.redis != nil && !refresh {
+		var cached sqltools.Catalog
+		if err := h.redis.Get(ctx, cacheKey, &cached); err == nil {
+			return &cached, nil
+		}
+	}
+
+	userPool, err := h.dbConfigHandler.GetUserDatabaseConnection(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	catalog, err := h.buildCatalog(ctx, userPool)
+	if err != nil {
+		log.Error().Err(err).Str("user_id", userID).Msg("Failed to build SQL catalog")
+		return nil, err
+	}
+
+	if h.redis != nil {
+		if err := h.redis.Set(ctx, cacheKey, catalog, catalogCacheTTL); err != nil {
+			log.Warn().Err(err).Str("cache_key", cacheKey).Msg("Failed to cache SQL catalog")
+		}
+	}
+
+	return catalog, nil
+}
+
+func (h *SQLPlaygroundHandler) buildCatalog(ctx context.Context, pool *pgxpool.Pool) (*sqltools.Catalog, error) {
+	schema, err := h.getDatabaseSchema(ctx, pool)
+	if err != nil {
+		return nil, err
+	}
+
+	catalog := &sqltools.Catalog{}
+	for _, t := range schema.Tables {
+		catalog.Tables = append(catalog.Tables, sqltools.CatalogTable{
+			Schema:  t.Schema,
+			Name:    t.Name,
+			Columns: catalogColumns(t.Columns),
+		})
+	}
+	for _, v := range schema.Views {
+		catalog.Tables = append(catalog.Tables, sqltools.CatalogTable{
+			Schema:  v.Schema,
+			Name:    v.Name,
+			IsView:  true,
+			Columns: catalogColumns(v.Columns),
+		})
+	}
+
+	rows, err := pool.Query(ctx, `
+		SELECT DISTINCT n.nspname, p.proname
+		FROM pg_proc p
+		JOIN pg_namespace n ON n.oid = p.pronamespace
+		WHERE n.nspname NOT IN ('pg_catalog', 'information_schema')
+		AND n.nspname NOT LIKE 'pg_toast%'
+		ORDER BY n.nspname, p.proname
+	`)
+	if err != nil {
+		log.Warn().Err(err).Msg("Failed to list user-defined functions")
+		return catalog, nil
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var fn sqltools.CatalogFunction
+		if err := rows.Scan(&fn.Schema, &fn.Name); err != nil {
+			continue
+		}
+		catalog.Functions = append(catalog.Functions, fn)
+	}
+
+	return catalog, nil
+}
+
+func catalogColumns(columns []ColumnInfo) []sqltools.CatalogColumn {
+	out := make([]sqltools.CatalogColumn, 0, len(columns))
+	seen := make(map[string]bool, len(columns))
+	for _, c := range columns {
+		// getTableColumns can repeat a column once per matching constraint.
+		if seen[c.Name] {
+			continue
+		}
+		seen[c.Name] = true
+		out = append(out, sqltools.CatalogColumn{Name: c.Name, Type: c.Type})
+	}
+	return out
+}
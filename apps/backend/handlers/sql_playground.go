@@ -9,9 +9,12 @@ import (
 	"strings"
 	"time"
 
+	"go-backend/audit"
 	"go-backend/database"
 	"go-backend/middleware"
 	"go-backend/models"
+	"go-backend/quota"
+	"go-backend/sqltools"
 	"github.com/gorilla/mux"
 	"github.com/jackc/pgx/v5"
 	"github.com/jackc/pgx/v5/pgxpool"
@@ -22,6 +25,8 @@ type SQLPlaygroundHandler struct {
 	db              *database.PostgresDB
 	redis           *database.RedisClient
 	dbConfigHandler *DatabaseConfigHandler
+	quotas          *quota.Service
+	audit           *audit.Logger
 }
 
 type QueryRequest struct {
@@ -44,6 +49,7 @@ type QueryResult struct {
 	ExecutionTime float64        `json:"execution_time_ms"`
 	ExplainPlan  []map[string]interface{} `json:"explain_plan,omitempty"`
 	Warnings     []string        `json:"warnings,omitempty"`
+	MaskedColumns []string       `json:"masked_columns,omitempty"`
 }
 
 type SchemaInfo struct {
@@ -73,13 +79,16 @@ type ColumnInfo struct {
 	DefaultValue string `json:"default_value,omitempty"`
 	IsPrimaryKey bool   `json:"is_primary_key"`
 	IsForeignKey bool   `json:"is_foreign_key"`
+	Masked       bool   `json:"masked,omitempty"`
 }
 
-func NewSQLPlaygroundHandler(db *database.PostgresDB, redis *database.RedisClient, dbConfigHandler *DatabaseConfigHandler) *SQLPlaygroundHandler {
+func NewSQLPlaygroundHandler(db *database.PostgresDB, redis *database.RedisClient, dbConfigHandler *DatabaseConfigHandler, quotas *quota.Service, auditLog *audit.Logger) *SQLPlaygroundHandler {
 	return &SQLPlaygroundHandler{
 		db:              db,
 		redis:           redis,
 		dbConfigHandler: dbConfigHandler,
+		quotas:          quotas,
+		audit:           auditLog,
 	}
 }
 
@@ -87,12 +96,6 @@ func (h *SQLPlaygroundHandler) ExecuteQuery(w http.ResponseWriter, r *http.Reque
 	vars := mux.Vars(r)
 	userID := vars["user_id"]
 
-	claims := middleware.GetUserClaims(r.Context())
-	if claims == nil || (claims.UserID != userID && claims.Role != "admin") {
-		middleware.WriteErrorResponse(w, http.StatusForbidden, fmt.Errorf("access denied"), "You can only execute queries on your own database")
-		return
-	}
-
 	var req QueryRequest
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
 		middleware.WriteErrorResponse(w, http.StatusBadRequest, err, "Invalid request body")
@@ -131,7 +134,8 @@ func (h *SQLPlaygroundHandler) ExecuteQuery(w http.ResponseWriter, r *http.Reque
 	startTime := time.Now()
 
 	// Execute query
-	result, err := h.executeSQL(ctx, userPool, req)
+	result, err := h.executeSQL(ctx, userPool, req, nil)
+	h.recordQuery(r, "", "", userID, req.SQL, result, err)
 	if err != nil {
 		log.Error().Err(err).Str("user_id", userID).Str("sql", req.SQL).Msg("Query execution failed")
 		middleware.WriteErrorResponse(w, http.StatusBadRequest, err, "Query execution failed")
@@ -142,6 +146,7 @@ func (h *SQLPlaygroundHandler) ExecuteQuery(w http.ResponseWriter, r *http.Reque
 
 	// Log the query execution
 	go h.logQueryExecution(userID, req.SQL, result.RowCount, result.ExecutionTime)
+	go h.meterQueryExecution(userID, "", req.SQL, result.RowCount)
 
 	middleware.WriteJSONResponse(w, http.StatusOK, result)
 }
@@ -150,12 +155,6 @@ func (h *SQLPlaygroundHandler) GetDatabaseSchema(w http.ResponseWriter, r *http.
 	vars := mux.Vars(r)
 	userID := vars["user_id"]
 
-	claims := middleware.GetUserClaims(r.Context())
-	if claims == nil || (claims.UserID != userID && claims.Role != "admin") {
-		middleware.WriteErrorResponse(w, http.StatusForbidden, fmt.Errorf("access denied"), "You can only access your own database schema")
-		return
-	}
-
 	userPool, err := h.dbConfigHandler.GetUserDatabaseConnection(userID)
 	if err != nil {
 		middleware.WriteErrorResponse(w, http.StatusBadRequest, err, "Failed to connect to your database")
@@ -179,12 +178,6 @@ func (h *SQLPlaygroundHandler) GetQueryHistory(w http.ResponseWriter, r *http.Re
 	vars := mux.Vars(r)
 	userID := vars["user_id"]
 
-	claims := middleware.GetUserClaims(r.Context())
-	if claims == nil || (claims.UserID != userID && claims.Role != "admin") {
-		middleware.WriteErrorResponse(w, http.StatusForbidden, fmt.Errorf("access denied"), "You can only access your own query history")
-		return
-	}
-
 	ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
 	defer cancel()
 
@@ -202,17 +195,28 @@ func (h *SQLPlaygroundHandler) GetQueryHistory(w http.ResponseWriter, r *http.Re
 		}
 	}
 	pagination.Normalize()
+	fingerprint := r.Form.Get("fingerprint")
+
+	// Only show history within the plan's retention window
+	cutoff, err := h.quotas.HistoryCutoff(ctx, userID)
+	if err != nil {
+		log.Error().Err(err).Str("user_id", userID).Msg("Failed to get history retention")
+		middleware.WriteErrorResponse(w, http.StatusInternalServerError, err, "Failed to retrieve query history")
+		return
+	}
 
 	// Get query history from metrics table
 	query := `
 		SELECT metadata, created_at 
 		FROM metrics 
 		WHERE user_id = $1 AND metric_type = 'sql_query'
+		AND ($4 = '' OR metadata->>'fingerprint' = $4)
+		AND created_at >= $5
 		ORDER BY created_at DESC
 		LIMIT $2 OFFSET $3
 	`
 
-	rows, err := h.db.Query(ctx, query, userID, pagination.Limit, pagination.Offset())
+	rows, err := h.db.Query(ctx, query, userID, pagination.Limit, pagination.Offset(), fingerprint, cutoff)
 	if err != nil {
 		log.Error().Err(err).Str("user_id", userID).Msg("Failed to get query history")
 		middleware.WriteErrorResponse(w, http.StatusInternalServerError, err, "Failed to retrieve query history")
@@ -237,13 +241,95 @@ func (h *SQLPlaygroundHandler) GetQueryHistory(w http.ResponseWriter, r *http.Re
 	}
 
 	middleware.WriteJSONResponse(w, http.StatusOK, map[string]interface{}{
-		"history": history,
-		"page":    pagination.Page,
-		"limit":   pagination.Limit,
+		"history":        history,
+		"page":           pagination.Page,
+		"limit":          pagination.Limit,
+		"retained_since": cutoff,
+	})
+}
+
+// GetQueryShapes groups the user's query history by fingerprint so repeated
+// queries that differ only in literal values show up as one entry.
+func (h *SQLPlaygroundHandler) GetQueryShapes(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	userID := vars["user_id"]
+
+	ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
+	defer cancel()
+
+	limit := 50
+	if l, err := strconv.Atoi(r.URL.Query().Get("limit")); err == nil && l > 0 && l <= 200 {
+		limit = l
+	}
+
+	cutoff, err := h.quotas.HistoryCutoff(ctx, userID)
+	if err != nil {
+		log.Error().Err(err).Str("user_id", userID).Msg("Failed to get history retention")
+		middleware.WriteErrorResponse(w, http.StatusInternalServerError, err, "Failed to retrieve query history")
+		return
+	}
+
+	query := `
+		SELECT
+			metadata->>'fingerprint',
+			(array_agg(metadata->>'normalized_sql' ORDER BY created_at DESC))[1],
+			COUNT(*),
+			COALESCE(AVG(metric_value), 0),
+			COALESCE(MAX(metric_value), 0),
+			COALESCE(SUM((metadata->>'row_count')::bigint), 0),
+			MAX(created_at)
+		FROM metrics
+		WHERE user_id = $1 AND metric_type = 'sql_query'
+		AND metadata ? 'fingerprint'
+		AND created_at >= $3
+		GROUP BY metadata->>'fingerprint'
+		ORDER BY COUNT(*) DESC, MAX(created_at) DESC
+		LIMIT $2
+	`
+
+	rows, err := h.db.Query(ctx, query, userID, limit, cutoff)
+	if err != nil {
+		log.Error().Err(err).Str("user_id", userID).Msg("Failed to get query shapes")
+		middleware.WriteErrorResponse(w, http.StatusInternalServerError, err, "Failed to retrieve query history")
+		return
+	}
+	defer rows.Close()
+
+	shapes := []map[string]interface{}{}
+	for rows.Next() {
+		var fingerprint string
+		var normalized *string
+		var executions, totalRows int64
+		var avgTime, maxTime float64
+		var lastExecuted time.Time
+
+		if err := rows.Scan(&fingerprint, &normalized, &executions, &avgTime, &maxTime, &totalRows, &lastExecuted); err != nil {
+			continue
+		}
+
+		shapes = append(shapes, map[string]interface{}{
+			"fingerprint":        fingerprint,
+			"normalized_sql":     normalized,
+			"executions":         executions,
+			"avg_execution_time": avgTime,
+			"max_execution_time": maxTime,
+			"total_rows":         totalRows,
+			"last_executed_at":   lastExecuted,
+		})
+	}
+
+	middleware.WriteJSONResponse(w, http.StatusOK, map[string]interface{}{
+		"shapes": shapes,
 	})
 }
 
-func (h *SQLPlaygroundHandler) executeSQL(ctx context.Context, pool *pgxpool.Pool, req QueryRequest) (*QueryResult, error) {
+// querier runs a query on a pool or inside a transaction.
+type querier interface {
+	Query(ctx context.Context, sql string, args ...any) (pgx.Rows, error)
+}
+
+// executeSQL runs req and masks the result with masker, which may be nil.
+func (h *SQLPlaygroundHandler) executeSQL(ctx context.Context, pool querier, req QueryRequest, masker *sqltools.Masker) (*QueryResult, error) {
 	sql := strings.TrimSpace(req.SQL)
 	
 	// Add EXPLAIN if requested
@@ -262,16 +348,29 @@ func (h *SQLPlaygroundHandler) executeSQL(ctx context.Context, pool *pgxpool.Poo
 	}
 	defer rows.Close()
 
-	return h.parseQueryResult(rows, req.Options.ExplainPlan)
+	return h.parseQueryResult(rows, req.Options.ExplainPlan, masker)
 }
 
-func (h *SQLPlaygroundHandler) parseQueryResult(rows pgx.Rows, isExplain bool) (*QueryResult, error) {
+func (h *SQLPlaygroundHandler) parseQueryResult(rows pgx.Rows, isExplain bool, masker *sqltools.Masker) (*QueryResult, error) {
 	fieldDescriptions := rows.FieldDescriptions()
 	columns := make([]string, len(fieldDescriptions))
 	for i, fd := range fieldDescriptions {
 		columns[i] = string(fd.Name)
 	}
 
+	// Plans carry no column values, so only query results are masked
+	var maskedColumns []string
+	maskRules := make([]*sqltools.MaskRule, len(fieldDescriptions))
+	if !isExplain && !masker.Empty() {
+		for i, fd := range fieldDescriptions {
+			src := sqltools.SourceColumn{TableOID: fd.TableOID, Attnum: fd.TableAttributeNumber}
+			if rule, ok := masker.RuleFor(fd.Name, src); ok {
+				maskRules[i] = &rule
+				maskedColumns = append(maskedColumns, fd.Name)
+			}
+		}
+	}
+
 	var data [][]interface{}
 	var explainPlan []map[string]interface{}
 
@@ -291,6 +390,12 @@ func (h *SQLPlaygroundHandler) parseQueryResult(rows pgx.Rows, isExplain bool) (
 			}
 		}
 
+		for i, rule := range maskRules {
+			if rule != nil {
+				values[i] = masker.Mask(*rule, values[i])
+			}
+		}
+
 		data = append(data, values)
 	}
 
@@ -303,6 +408,7 @@ func (h *SQLPlaygroundHandler) parseQueryResult(rows pgx.Rows, isExplain bool) (
 		Rows:        data,
 		RowCount:    int64(len(data)),
 		ExplainPlan: explainPlan,
+		MaskedColumns: maskedColumns,
 	}
 
 	return result, nil
@@ -443,8 +549,11 @@ func (h *SQLPlaygroundHandler) logQueryExecution(userID, sql string, rowCount in
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
 
+	normalized := sqltools.Normalize(sql)
 	metadata := map[string]interface{}{
 		"sql":            sql[:min(1000, len(sql))], // Truncate long queries
+		"normalized_sql": normalized[:min(1000, len(normalized))],
+		"fingerprint":    sqltools.Fingerprint(sql),
 		"row_count":      rowCount,
 		"execution_time": executionTime,
 	}
@@ -459,6 +568,54 @@ func (h *SQLPlaygroundHandler) logQueryExecution(userID, sql string, rowCount in
 	h.db.Exec(ctx, query, userID, executionTime, metadataBytes)
 }
 
+// meterQueryExecution records a billable execution and the rows it
+// returned against orgID, or the organization the user is billed to when
+// orgID is empty.
+func (h *SQLPlaygroundHandler) meterQueryExecution(userID, orgID, sql string, rowCount int64) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	subject, err := h.quotas.Resolve(ctx, userID, orgID)
+	if err != nil {
+		log.Error().Err(err).Str("user_id", userID).Msg("Failed to resolve billing subject")
+		return
+	}
+
+	metadata := map[string]interface{}{"fingerprint": sqltools.Fingerprint(sql)}
+	if err := h.quotas.Record(ctx, subject, quota.MeterQueryExecutions, 1, metadata); err != nil {
+		log.Error().Err(err).Str("user_id", userID).Msg("Failed to meter query execution")
+	}
+	if err := h.quotas.Record(ctx, subject, quota.MeterRowsExported, rowCount, metadata); err != nil {
+		log.Error().Err(err).Str("user_id", userID).Msg("Failed to meter exported rows")
+	}
+}
+
+// recordQuery audits a query run on the database connection saved by
+// connectionUserID, with its outcome. Project queries carry the project's
+// organization and ID.
+func (h *SQLPlaygroundHandler) recordQuery(r *http.Request, orgID, projectID, connectionUserID, sql string, result *QueryResult, err error) {
+	metadata := map[string]interface{}{
+		"sql":     sql,
+		"success": err == nil,
+	}
+	if projectID != "" {
+		metadata["project_id"] = projectID
+	}
+	if result != nil {
+		metadata["row_count"] = result.RowCount
+	}
+	if err != nil {
+		metadata["error"] = err.Error()
+	}
+	h.audit.Record(r, audit.Event{
+		OrganizationID: orgID,
+		Action:         audit.ActionQueryExecuted,
+		TargetType:     audit.TargetConnection,
+		TargetID:       connectionUserID,
+		Metadata:       metadata,
+	})
+}
+
 func min(a, b int) int {
 	if a < b {
 		return a
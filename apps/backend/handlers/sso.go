@@ -0,0 +1,1012 @@
+package handlers
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"go-backend/audit"
+	"go-backend/auth"
+	"go-backend/database"
+	"go-backend/middleware"
+	"go-backend/models"
+	"go-backend/quota"
+	"go-backend/sso"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+	"github.com/jackc/pgx/v5"
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	// ssoLoginTTL is how long a user has to finish signing in at the IdP.
+	ssoLoginTTL = 10 * time.Minute
+	// ssoSessionTTL matches the web app's Better Auth session lifetime.
+	ssoSessionTTL = 7 * 24 * time.Hour
+	// ssoStateCookie ties an OIDC callback to the browser that started it.
+	ssoStateCookie = "sso_login"
+	// ssoDefaultRedirect is where signed-in users land without redirect_to.
+	ssoDefaultRedirect = "/dashboard"
+)
+
+// sessionDataCookieNames are Better Auth's session cache cookies, cleared on
+// SSO sign-in so the app does not keep showing a previous session.
+var sessionDataCookieNames = []string{"better-auth.session_data", "__Secure-better-auth.session_data"}
+
+// SSOHandler configures organizations' single sign-on connections and signs
+// members in through them. A sign-in ends with a Better Auth session the
+// backend writes to Better Auth's tables itself, so it needs the database
+// Better Auth uses. Only emails at the organization's verified domains are
+// accepted from the IdP, so one organization's IdP cannot sign in users of
+// another.
+type SSOHandler struct {
+	db           *database.PostgresDB
+	quotas       *quota.Service
+	audit        *audit.Logger
+	client       *sso.Client
+	sessions     *auth.SessionValidator
+	appURL       string
+	apiURL       string
+	cookieDomain string
+}
+
+func NewSSOHandler(db *database.PostgresDB, quotas *quota.Service, auditLog *audit.Logger, client *sso.Client, sessions *auth.SessionValidator, appURL, apiURL, cookieDomain string) *SSOHandler {
+	return &SSOHandler{
+		db:           db,
+		quotas:       quotas,
+		audit:        auditLog,
+		client:       client,
+		sessions:     sessions,
+		appURL:       strings.TrimSuffix(appURL, "/"),
+		apiURL:       strings.TrimSuffix(apiURL, "/"),
+		cookieDomain: cookieDomain,
+	}
+}
+
+var (
+	errSSONotConfigured = &memberError{http.StatusNotFound, "SSO is not configured for this organization"}
+	errSSONoDomain      = &memberError{http.StatusBadRequest, "Verify an email domain before enabling SSO; only emails at verified domains can sign in"}
+)
+
+// ssoFailure is a refused sign-in. The user is sent back to the app's
+// sign-in page with code; reason is only logged.
+type ssoFailure struct {
+	code   string
+	reason string
+}
+
+func (e *ssoFailure) Error() string {
+	return e.code + ": " + e.reason
+}
+
+func refuseSSO(code, reason string) error {
+	return &ssoFailure{code: code, reason: reason}
+}
+
+// ssoConnection is a stored connection and its encrypted client secret.
+type ssoConnection struct {
+	models.SSOConnection
+	clientSecret string
+}
+
+const ssoConnectionColumns = `id, organization_id, protocol, enabled, enforced, entitled, jit_provisioning, default_role,
+	groups_attribute, role_mappings, COALESCE(oidc_issuer, ''), COALESCE(oidc_client_id, ''), COALESCE(oidc_client_secret, ''),
+	COALESCE(oidc_scopes, ''), COALESCE(saml_idp_entity_id, ''), COALESCE(saml_idp_sso_url, ''), COALESCE(saml_idp_certificate, ''),
+	created_by, created_at, updated_at`
+
+func scanSSOConnection(row pgx.Row) (*ssoConnection, error) {
+	var c ssoConnection
+	var mappings []byte
+	err := row.Scan(&c.ID, &c.OrganizationID, &c.Protocol, &c.Enabled, &c.Enforced, &c.Entitled, &c.JITProvisioning, &c.DefaultRole,
+		&c.GroupsAttribute, &mappings, &c.OIDCIssuer, &c.OIDCClientID, &c.clientSecret,
+		&c.OIDCScopes, &c.SAMLIdPEntityID, &c.SAMLIdPSSOURL, &c.SAMLIdPCertificate,
+		&c.CreatedBy, &c.CreatedAt, &c.UpdatedAt)
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(mappings, &c.RoleMappings); err != nil {
+		return nil, fmt.Errorf("invalid role mappings: %w", err)
+	}
+	if c.RoleMappings == nil {
+		c.RoleMappings = []models.SSORoleMapping{}
+	}
+	return &c, nil
+}
+
+func (h *SSOHandler) loadConnection(ctx context.Context, where string, arg interface{}) (*ssoConnection, error) {
+	c, err := scanSSOConnection(h.db.QueryRow(ctx, `SELECT `+ssoConnectionColumns+` FROM sso_connections WHERE `+where, arg))
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, errSSONotConfigured
+	}
+	return c, err
+}
+
+// The URLs the IdP is configured with. The SAML entity ID is the metadata
+// URL, as IdPs commonly expect.
+
+func (h *SSOHandler) oidcRedirectURL() string {
+	return h.apiURL + "/api/v1/sso/oidc/callback"
+}
+
+func (h *SSOHandler) samlMetadataURL(orgID string) string {
+	return h.apiURL + "/api/v1/sso/saml/" + url.PathEscape(orgID) + "/metadata"
+}
+
+func (h *SSOHandler) samlACSURL(orgID string) string {
+	return h.apiURL + "/api/v1/sso/saml/" + url.PathEscape(orgID) + "/acs"
+}
+
+// present is the connection as returned to admins: without its secret and
+// with what the IdP needs to know.
+func (h *SSOHandler) present(c *ssoConnection) *models.SSOConnection {
+	out := c.SSOConnection
+	out.LoginURL = h.apiURL + "/api/v1/sso/login?organization=" + url.QueryEscape(c.OrganizationID)
+	switch c.Protocol {
+	case sso.ProtocolOIDC:
+		out.OIDCClientSecretSet = c.clientSecret != ""
+		out.OIDCRedirectURL = h.oidcRedirectURL()
+	case sso.ProtocolSAML:
+		out.SAMLEntityID = h.samlMetadataURL(c.OrganizationID)
+		out.SAMLMetadataURL = out.SAMLEntityID
+		out.SAMLACSURL = h.samlACSURL(c.OrganizationID)
+	}
+	return &out
+}
+
+func (h *SSOHandler) oidcConfig(c *ssoConnection) (sso.OIDCConfig, error) {
+	secret, err := auth.Decrypt(c.clientSecret)
+	if err != nil {
+		return sso.OIDCConfig{}, fmt.Errorf("failed to decrypt OIDC client secret: %w", err)
+	}
+	return sso.OIDCConfig{
+		Issuer:       c.OIDCIssuer,
+		ClientID:     c.OIDCClientID,
+		ClientSecret: secret,
+		Scopes:       strings.Fields(c.OIDCScopes),
+		RedirectURL:  h.oidcRedirectURL(),
+		GroupsClaim:  c.GroupsAttribute,
+	}, nil
+}
+
+func (h *SSOHandler) samlConfig(c *ssoConnection) sso.SAMLConfig {
+	return sso.SAMLConfig{
+		IdPEntityID:     c.SAMLIdPEntityID,
+		IdPSSOURL:       c.SAMLIdPSSOURL,
+		IdPCertificate:  c.SAMLIdPCertificate,
+		SPEntityID:      h.samlMetadataURL(c.OrganizationID),
+		ACSURL:          h.samlACSURL(c.OrganizationID),
+		GroupsAttribute: c.GroupsAttribute,
+	}
+}
+
+// GET /api/v1/users/{userId}/organizations/{orgId}/sso
+func (h *SSOHandler) GetConnection(w http.ResponseWriter, r *http.Request) {
+	orgID := mux.Vars(r)["orgId"]
+
+	c, err := h.loadConnection(context.Background(), "organization_id = $1", orgID)
+	if err != nil {
+		writeMemberError(w, err, "Failed to fetch SSO connection")
+		return
+	}
+
+	middleware.WriteJSONResponse(w, http.StatusOK, map[string]interface{}{
+		"data": h.present(c),
+	})
+}
+
+// PUT /api/v1/users/{userId}/organizations/{orgId}/sso
+//
+// Creates or replaces the organization's connection. Requires a plan with
+// SSO. OIDC issuers are checked by fetching their discovery document, and
+// enabling needs a verified email domain.
+func (h *SSOHandler) SaveConnection(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	userID := vars["userId"]
+	orgID := vars["orgId"]
+
+	var req models.SaveSSOConnectionRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	ctx := context.Background()
+	if err := h.quotas.RequireFeature(ctx, quota.OrganizationSubject(orgID), quota.FeatureSSO); err != nil {
+		writeMemberError(w, err, "Failed to check plan features")
+		return
+	}
+
+	existing, err := h.loadConnection(ctx, "organization_id = $1", orgID)
+	if errors.Is(err, errSSONotConfigured) {
+		existing, err = nil, nil
+	}
+	if err != nil {
+		writeMemberError(w, err, "Failed to save SSO connection")
+		return
+	}
+
+	if err := h.normalizeSSORequest(&req); err != nil {
+		writeMemberError(w, err, "Failed to save SSO connection")
+		return
+	}
+
+	var secret *string
+	if req.Protocol == sso.ProtocolOIDC {
+		switch {
+		case req.OIDCClientSecret != "":
+			encrypted, err := auth.Encrypt(req.OIDCClientSecret)
+			if err != nil {
+				writeMemberError(w, err, "Failed to save SSO connection")
+				return
+			}
+			secret = &encrypted
+		case existing != nil && existing.Protocol == sso.ProtocolOIDC && existing.clientSecret != "":
+			secret = &existing.clientSecret
+		default:
+			http.Error(w, "oidc_client_secret is required", http.StatusBadRequest)
+			return
+		}
+
+		checkCtx, cancel := context.WithTimeout(ctx, 15*time.Second)
+		err := h.client.Check(checkCtx, sso.OIDCConfig{Issuer: req.OIDCIssuer})
+		cancel()
+		if err != nil {
+			// The server fetched the URL; what it found is not the admin's to see
+			log.Warn().Err(err).Str("organization_id", orgID).Str("issuer", req.OIDCIssuer).Msg("Failed to check OIDC issuer")
+			http.Error(w, "Could not fetch a valid OIDC discovery document and signing keys from oidc_issuer", http.StatusBadRequest)
+			return
+		}
+	}
+
+	if req.Enabled {
+		var verified bool
+		err := h.db.QueryRow(ctx, `
+			SELECT EXISTS (SELECT 1 FROM organization_domains WHERE organization_id = $1 AND verified_at IS NOT NULL)
+		`, orgID).Scan(&verified)
+		if err == nil && !verified {
+			err = errSSONoDomain
+		}
+		if err != nil {
+			writeMemberError(w, err, "Failed to save SSO connection")
+			return
+		}
+	}
+
+	mappings, err := json.Marshal(req.RoleMappings)
+	if err != nil {
+		writeMemberError(w, err, "Failed to save SSO connection")
+		return
+	}
+
+	c, err := scanSSOConnection(h.db.QueryRow(ctx, `
+		INSERT INTO sso_connections (id, organization_id, protocol, enabled, enforced, entitled, jit_provisioning, default_role,
+			groups_attribute, role_mappings, oidc_issuer, oidc_client_id, oidc_client_secret, oidc_scopes,
+			saml_idp_entity_id, saml_idp_sso_url, saml_idp_certificate, created_by)
+		VALUES ($1, $2, $3, $4, $5, TRUE, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16, $17)
+		ON CONFLICT (organization_id) DO UPDATE SET
+			protocol = EXCLUDED.protocol,
+			enabled = EXCLUDED.enabled,
+			enforced = EXCLUDED.enforced,
+			entitled = TRUE,
+			jit_provisioning = EXCLUDED.jit_provisioning,
+			default_role = EXCLUDED.default_role,
+			groups_attribute = EXCLUDED.groups_attribute,
+			role_mappings = EXCLUDED.role_mappings,
+			oidc_issuer = EXCLUDED.oidc_issuer,
+			oidc_client_id = EXCLUDED.oidc_client_id,
+			oidc_client_secret = EXCLUDED.oidc_client_secret,
+			oidc_scopes = EXCLUDED.oidc_scopes,
+			saml_idp_entity_id = EXCLUDED.saml_idp_entity_id,
+			saml_idp_sso_url = EXCLUDED.saml_idp_sso_url,
+			saml_idp_certificate = EXCLUDED.saml_idp_certificate
+		RETURNING `+ssoConnectionColumns,
+		uuid.New().String(), orgID, req.Protocol, req.Enabled, req.Enforced, *req.JITProvisioning, req.DefaultRole,
+		req.GroupsAttribute, mappings, emptyToNull(req.OIDCIssuer), emptyToNull(req.OIDCClientID), secret, emptyToNull(req.OIDCScopes),
+		emptyToNull(req.SAMLIdPEntityID), emptyToNull(req.SAMLIdPSSOURL), emptyToNull(req.SAMLIdPCertificate), userID))
+	if err != nil {
+		writeMemberError(w, err, "Failed to save SSO connection")
+		return
+	}
+
+	h.audit.Record(r, audit.Event{
+		OrganizationID: orgID,
+		Action:         audit.ActionSSOConfigured,
+		TargetType:     audit.TargetSSOConnection,
+		TargetID:       c.ID,
+		Metadata: map[string]interface{}{
+			"protocol":         c.Protocol,
+			"enabled":          c.Enabled,
+			"enforced":         c.Enforced,
+			"jit_provisioning": c.JITProvisioning,
+			"role_mappings":    c.RoleMappings,
+			"secret_changed":   req.OIDCClientSecret != "",
+		},
+	})
+
+	middleware.WriteJSONResponse(w, http.StatusOK, map[string]interface{}{
+		"data": h.present(c),
+	})
+}
+
+// normalizeSSORequest fills defaults and checks the settings of the chosen
+// protocol; those of the other protocol are dropped.
+func (h *SSOHandler) normalizeSSORequest(req *models.SaveSSOConnectionRequest) error {
+	bad := func(message string) error {
+		return &memberError{http.StatusBadRequest, message}
+	}
+
+	if req.Enforced && !req.Enabled {
+		return bad("A connection must be enabled to be enforced")
+	}
+	if req.JITProvisioning == nil {
+		jit := true
+		req.JITProvisioning = &jit
+	}
+	if req.DefaultRole != nil && *req.DefaultRole == "" {
+		req.DefaultRole = nil
+	}
+	if req.DefaultRole != nil && *req.DefaultRole != "admin" && *req.DefaultRole != "member" {
+		return bad("default_role must be admin or member")
+	}
+	req.GroupsAttribute = strings.TrimSpace(req.GroupsAttribute)
+	if req.GroupsAttribute == "" {
+		req.GroupsAttribute = "groups"
+	}
+	if req.RoleMappings == nil {
+		req.RoleMappings = []models.SSORoleMapping{}
+	}
+	for i := range req.RoleMappings {
+		m := &req.RoleMappings[i]
+		m.Group = strings.TrimSpace(m.Group)
+		if m.Group == "" {
+			return bad("Every role mapping needs a group")
+		}
+		if m.Role != "admin" && m.Role != "member" {
+			return bad("Role mappings can grant admin or member only")
+		}
+	}
+
+	switch req.Protocol {
+	case sso.ProtocolOIDC:
+		req.OIDCIssuer = strings.TrimSuffix(strings.TrimSpace(req.OIDCIssuer), "/")
+		req.OIDCClientID = strings.TrimSpace(req.OIDCClientID)
+		req.OIDCScopes = strings.Join(strings.Fields(req.OIDCScopes), " ")
+		if err := h.client.CheckURL(req.OIDCIssuer); err != nil {
+			return bad("oidc_issuer must be an https URL at a public address")
+		}
+		if req.OIDCClientID == "" {
+			return bad("oidc_client_id is required")
+		}
+		req.SAMLIdPEntityID, req.SAMLIdPSSOURL, req.SAMLIdPCertificate = "", "", ""
+	case sso.ProtocolSAML:
+		req.SAMLIdPEntityID = strings.TrimSpace(req.SAMLIdPEntityID)
+		req.SAMLIdPSSOURL = strings.TrimSpace(req.SAMLIdPSSOURL)
+		req.SAMLIdPCertificate = strings.TrimSpace(req.SAMLIdPCertificate)
+		if req.SAMLIdPEntityID == "" {
+			return bad("saml_idp_entity_id is required")
+		}
+		if err := h.client.CheckURL(req.SAMLIdPSSOURL); err != nil {
+			return bad("saml_idp_sso_url must be an https URL at a public address")
+		}
+		if _, err := sso.ParseCertificate(req.SAMLIdPCertificate); err != nil {
+			return bad("saml_idp_certificate is not a valid certificate: " + err.Error())
+		}
+		req.OIDCIssuer, req.OIDCClientID, req.OIDCClientSecret, req.OIDCScopes = "", "", "", ""
+	default:
+		return bad("protocol must be oidc or saml")
+	}
+	return nil
+}
+
+func emptyToNull(s string) *string {
+	if s == "" {
+		return nil
+	}
+	return &s
+}
+
+// DELETE /api/v1/users/{userId}/organizations/{orgId}/sso
+//
+// Removes the connection. Members keep their accounts and memberships but
+// sign in some other way from now on.
+func (h *SSOHandler) DeleteConnection(w http.ResponseWriter, r *http.Request) {
+	orgID := mux.Vars(r)["orgId"]
+
+	ctx := context.Background()
+	var id string
+	err := h.db.QueryRow(ctx, "DELETE FROM sso_connections WHERE organization_id = $1 RETURNING id", orgID).Scan(&id)
+	if errors.Is(err, pgx.ErrNoRows) {
+		err = errSSONotConfigured
+	}
+	if err != nil {
+		writeMemberError(w, err, "Failed to delete SSO connection")
+		return
+	}
+	if err := h.db.Exec(ctx, "DELETE FROM sso_sessions WHERE organization_id = $1", orgID); err != nil {
+		log.Warn().Err(err).Str("organization_id", orgID).Msg("Failed to forget SSO sessions")
+	}
+
+	h.audit.Record(r, audit.Event{
+		OrganizationID: orgID,
+		Action:         audit.ActionSSODeleted,
+		TargetType:     audit.TargetSSOConnection,
+		TargetID:       id,
+	})
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// GET /api/v1/sso/login?organization=&email=&redirect_to=
+//
+// Starts signing in: finds the connection of the organization given by ID or
+// slug, or else of the verified domain of email, and sends the browser to
+// the IdP. redirect_to is a path in the app to land on afterwards.
+func (h *SSOHandler) Login(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	query := r.URL.Query()
+
+	c, err := h.findLoginConnection(ctx, strings.TrimSpace(query.Get("organization")), strings.TrimSpace(query.Get("email")))
+	if err != nil {
+		h.fail(w, r, err)
+		return
+	}
+	if err := h.quotas.RequireFeature(ctx, quota.OrganizationSubject(c.OrganizationID), quota.FeatureSSO); err != nil {
+		var missing *quota.FeatureError
+		if errors.As(err, &missing) {
+			err = refuseSSO("not_entitled", "plan does not include SSO")
+		}
+		h.fail(w, r, err)
+		return
+	}
+
+	login, err := sso.NewLogin()
+	if err != nil {
+		h.fail(w, r, err)
+		return
+	}
+
+	var target string
+	switch c.Protocol {
+	case sso.ProtocolOIDC:
+		cfg, cfgErr := h.oidcConfig(c)
+		if cfgErr != nil {
+			h.fail(w, r, cfgErr)
+			return
+		}
+		target, err = h.client.OIDCAuthURL(ctx, cfg, login)
+	case sso.ProtocolSAML:
+		target, err = sso.SAMLAuthURL(h.samlConfig(c), login, time.Now())
+	}
+	if err != nil {
+		h.fail(w, r, refuseSSO("idp_unavailable", err.Error()))
+		return
+	}
+
+	// Abandoned sign-ins are cleared as new ones start
+	if err := h.db.Exec(ctx, "DELETE FROM sso_login_states WHERE expires_at < NOW()"); err != nil {
+		log.Warn().Err(err).Msg("Failed to clear expired SSO sign-ins")
+	}
+	err = h.db.Exec(ctx, `
+		INSERT INTO sso_login_states (id, connection_id, nonce, code_verifier, request_id, redirect_to, expires_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)
+	`, login.State, c.ID, login.Nonce, login.CodeVerifier, login.RequestID, safeRedirectPath(query.Get("redirect_to")), time.Now().Add(ssoLoginTTL))
+	if err != nil {
+		h.fail(w, r, err)
+		return
+	}
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     ssoStateCookie,
+		Value:    login.State,
+		Path:     "/api/v1/sso",
+		MaxAge:   int(ssoLoginTTL.Seconds()),
+		HttpOnly: true,
+		Secure:   strings.HasPrefix(h.apiURL, "https://"),
+		SameSite: http.SameSiteLaxMode,
+	})
+	http.Redirect(w, r, target, http.StatusFound)
+}
+
+func (h *SSOHandler) findLoginConnection(ctx context.Context, organization, email string) (*ssoConnection, error) {
+	var c *ssoConnection
+	var err error
+	switch {
+	case organization != "":
+		c, err = scanSSOConnection(h.db.QueryRow(ctx, `
+			SELECT `+ssoConnectionColumns+` FROM sso_connections
+			WHERE organization_id = (SELECT id FROM organizations WHERE id = $1 OR slug = $1 LIMIT 1)
+		`, organization))
+	case email != "":
+		c, err = scanSSOConnection(h.db.QueryRow(ctx, `
+			SELECT `+ssoConnectionColumns+` FROM sso_connections
+			WHERE organization_id = (
+				SELECT organization_id FROM organization_domains
+				WHERE domain = $1 AND verified_at IS NOT NULL
+			)
+		`, emailDomain(email)))
+	default:
+		return nil, refuseSSO("invalid_request", "no organization or email given")
+	}
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, refuseSSO("not_configured", "no SSO connection")
+	}
+	if err != nil {
+		return nil, err
+	}
+	if !c.Enabled || !c.Entitled {
+		return nil, refuseSSO("not_configured", "SSO connection is disabled")
+	}
+	return c, nil
+}
+
+// GET /api/v1/sso/oidc/callback
+func (h *SSOHandler) OIDCCallback(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	query := r.URL.Query()
+	state := query.Get("state")
+
+	// Only the browser that started the sign-in may finish it
+	cookie, err := r.Cookie(ssoStateCookie)
+	if err != nil || state == "" || cookie.Value != state {
+		h.fail(w, r, refuseSSO("invalid_request", "state does not match the sign-in cookie"))
+		return
+	}
+	h.clearCookie(w, ssoStateCookie, "/api/v1/sso", "")
+
+	c, login, redirectTo, err := h.consumeLogin(ctx, state, sso.ProtocolOIDC)
+	if err != nil {
+		h.fail(w, r, err)
+		return
+	}
+	if idpError := query.Get("error"); idpError != "" {
+		h.fail(w, r, refuseSSO("idp_error", idpError+": "+query.Get("error_description")))
+		return
+	}
+
+	cfg, err := h.oidcConfig(c)
+	if err != nil {
+		h.fail(w, r, err)
+		return
+	}
+	identity, err := h.client.OIDCExchange(ctx, cfg, query.Get("code"), login)
+	if errors.Is(err, sso.ErrInvalidResponse) {
+		err = refuseSSO("invalid_response", err.Error())
+	} else if err != nil {
+		err = refuseSSO("idp_unavailable", err.Error())
+	}
+	if err != nil {
+		h.fail(w, r, err)
+		return
+	}
+
+	h.finish(w, r, c, identity, redirectTo)
+}
+
+// POST /api/v1/sso/saml/{orgId}/acs
+//
+// Takes the IdP's response over the HTTP-POST binding. The cross-site POST
+// carries no cookies, so the response is tied to its sign-in by RelayState
+// and by the AuthnRequest ID it must answer.
+func (h *SSOHandler) SAMLAssertionConsumer(w http.ResponseWriter, r *http.Request) {
+	orgID := mux.Vars(r)["orgId"]
+	ctx := r.Context()
+
+	r.Body = http.MaxBytesReader(w, r.Body, 1<<20)
+	if err := r.ParseForm(); err != nil {
+		h.fail(w, r, refuseSSO("invalid_request", "unreadable form"))
+		return
+	}
+
+	c, login, redirectTo, err := h.consumeLogin(ctx, r.PostForm.Get("RelayState"), sso.ProtocolSAML)
+	if err == nil && c.OrganizationID != orgID {
+		err = refuseSSO("invalid_request", "response posted to another organization's ACS")
+	}
+	if err != nil {
+		h.fail(w, r, err)
+		return
+	}
+
+	identity, err := sso.ParseSAMLResponse(h.samlConfig(c), r.PostForm.Get("SAMLResponse"), login, time.Now())
+	if err != nil {
+		h.fail(w, r, refuseSSO("invalid_response", err.Error()))
+		return
+	}
+
+	h.finish(w, r, c, identity, redirectTo)
+}
+
+// GET /api/v1/sso/saml/{orgId}/metadata
+//
+// The service provider metadata to set up the IdP with. It is served before
+// a connection is saved, so the IdP can be set up first.
+func (h *SSOHandler) SAMLMetadata(w http.ResponseWriter, r *http.Request) {
+	orgID := mux.Vars(r)["orgId"]
+
+	var exists bool
+	if err := h.db.QueryRow(r.Context(), "SELECT EXISTS (SELECT 1 FROM organizations WHERE id = $1)", orgID).Scan(&exists); err != nil {
+		log.Error().Err(err).Msg("Failed to look up organization")
+		http.Error(w, "Failed to build metadata", http.StatusInternalServerError)
+		return
+	}
+	if !exists {
+		http.Error(w, "Organization not found", http.StatusNotFound)
+		return
+	}
+
+	metadata, err := sso.SAMLMetadata(sso.SAMLConfig{SPEntityID: h.samlMetadataURL(orgID), ACSURL: h.samlACSURL(orgID)})
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to build SAML metadata")
+		http.Error(w, "Failed to build metadata", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/samlmetadata+xml")
+	w.Write(metadata)
+}
+
+// consumeLogin looks up and deletes the sign-in state, so each IdP answer
+// is used once.
+func (h *SSOHandler) consumeLogin(ctx context.Context, state, protocol string) (*ssoConnection, *sso.Login, string, error) {
+	if state == "" {
+		return nil, nil, "", refuseSSO("invalid_request", "no state")
+	}
+
+	login := &sso.Login{State: state}
+	var connectionID, redirectTo string
+	var live bool
+	err := h.db.QueryRow(ctx, `
+		DELETE FROM sso_login_states WHERE id = $1
+		RETURNING connection_id, nonce, code_verifier, request_id, redirect_to, expires_at > NOW()
+	`, state).Scan(&connectionID, &login.Nonce, &login.CodeVerifier, &login.RequestID, &redirectTo, &live)
+	if errors.Is(err, pgx.ErrNoRows) || (err == nil && !live) {
+		return nil, nil, "", refuseSSO("expired", "unknown, used or expired state")
+	}
+	if err != nil {
+		return nil, nil, "", err
+	}
+
+	c, err := h.loadConnection(ctx, "id = $1", connectionID)
+	if errors.Is(err, errSSONotConfigured) {
+		return nil, nil, "", refuseSSO("not_configured", "connection was removed")
+	}
+	if err != nil {
+		return nil, nil, "", err
+	}
+	if !c.Enabled || !c.Entitled {
+		return nil, nil, "", refuseSSO("not_configured", "SSO connection is disabled")
+	}
+	if c.Protocol != protocol {
+		return nil, nil, "", refuseSSO("invalid_request", "connection no longer uses "+protocol)
+	}
+	return c, login, redirectTo, nil
+}
+
+// ssoSignIn is the outcome of a successful sign-in.
+type ssoSignIn struct {
+	userID       string
+	email        string
+	token        string
+	memberID     string
+	role         string
+	previousRole string
+	provisioned  bool
+}
+
+// finish signs the user the IdP vouched for into the app and the
+// organization, and sends them on to the app.
+func (h *SSOHandler) finish(w http.ResponseWriter, r *http.Request, c *ssoConnection, identity *sso.Identity, redirectTo string) {
+	ctx := context.Background()
+
+	result, err := h.signIn(ctx, r, c, identity)
+	var exceeded *quota.ExceededError
+	if errors.As(err, &exceeded) {
+		err = refuseSSO("no_seats", exceeded.Error())
+	}
+	if err != nil {
+		h.fail(w, r, err)
+		return
+	}
+
+	if result.provisioned {
+		h.audit.RecordAs(r, result.userID, result.email, audit.Event{
+			OrganizationID: c.OrganizationID,
+			Action:         audit.ActionMemberProvisioned,
+			TargetType:     audit.TargetMember,
+			TargetID:       result.memberID,
+			Metadata:       map[string]interface{}{"role": result.role, "groups": identity.Groups},
+		})
+	}
+	if result.previousRole != "" {
+		h.audit.RecordAs(r, result.userID, result.email, audit.Event{
+			OrganizationID: c.OrganizationID,
+			Action:         audit.ActionMemberRoleChanged,
+			TargetType:     audit.TargetMember,
+			TargetID:       result.memberID,
+			Metadata:       map[string]interface{}{"from": result.previousRole, "to": result.role, "source": "sso", "groups": identity.Groups},
+		})
+	}
+	h.audit.RecordAs(r, result.userID, result.email, audit.Event{
+		OrganizationID: c.OrganizationID,
+		Action:         audit.ActionSSOLogin,
+		TargetType:     audit.TargetSSOConnection,
+		TargetID:       c.ID,
+		Metadata:       map[string]interface{}{"protocol": c.Protocol, "subject": identity.Subject},
+	})
+
+	secure := strings.HasPrefix(h.appURL, "https://")
+	name := auth.SessionCookieNames[0]
+	if secure {
+		name = auth.SessionCookieNames[1]
+	}
+	http.SetCookie(w, &http.Cookie{
+		Name:     name,
+		Value:    h.sessions.SignToken(result.token),
+		Path:     "/",
+		Domain:   h.cookieDomain,
+		MaxAge:   int(ssoSessionTTL.Seconds()),
+		HttpOnly: true,
+		Secure:   secure,
+		SameSite: http.SameSiteLaxMode,
+	})
+	for _, cookie := range sessionDataCookieNames {
+		h.clearCookie(w, cookie, "/", h.cookieDomain)
+	}
+
+	http.Redirect(w, r, h.appURL+redirectTo, http.StatusFound)
+}
+
+// signIn finds or creates the Better Auth user for identity, brings their
+// membership in line with the IdP and opens a session, all in one
+// transaction that locks the organization like other membership changes.
+func (h *SSOHandler) signIn(ctx context.Context, r *http.Request, c *ssoConnection, identity *sso.Identity) (*ssoSignIn, error) {
+	email := strings.ToLower(strings.TrimSpace(identity.Email))
+	if email == "" {
+		return nil, refuseSSO("email_not_allowed", "IdP sent no email")
+	}
+	mappedRole := mapSSORole(c.RoleMappings, identity.Groups)
+
+	tx, err := h.db.GetPool().Begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback(ctx)
+
+	var orgID string
+	if err := tx.QueryRow(ctx, "SELECT id FROM organizations WHERE id = $1 FOR UPDATE", c.OrganizationID).Scan(&orgID); err != nil {
+		return nil, err
+	}
+
+	var domainVerified bool
+	err = tx.QueryRow(ctx, `
+		SELECT EXISTS (
+			SELECT 1 FROM organization_domains
+			WHERE organization_id = $1 AND domain = $2 AND verified_at IS NOT NULL
+		)
+	`, orgID, emailDomain(email)).Scan(&domainVerified)
+	if err != nil {
+		return nil, err
+	}
+	if !domainVerified {
+		return nil, refuseSSO("email_not_allowed", "email "+email+" is not at a verified domain")
+	}
+
+	userID, err := h.findOrCreateUser(ctx, tx, orgID, email, identity)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &ssoSignIn{userID: userID, email: email}
+	var status string
+	var scimManaged bool
+	err = tx.QueryRow(ctx, `
+		SELECT id, role, status, scim_managed FROM organization_members
+		WHERE organization_id = $1 AND user_id = $2
+	`, orgID, userID).Scan(&result.memberID, &result.role, &status, &scimManaged)
+	switch {
+	case errors.Is(err, pgx.ErrNoRows):
+		if err := h.provision(ctx, tx, c, result, mappedRole); err != nil {
+			return nil, err
+		}
+	case err != nil:
+		return nil, err
+	case status != "active":
+		return nil, refuseSSO("membership_suspended", "membership is "+status)
+	case mappedRole != "" && mappedRole != result.role && result.role != "owner" && !scimManaged:
+		// The IdP's groups decide the role of everyone but owners, and of
+		// members provisioned through SCIM, whose groups are pushed there
+		if err := execTx(ctx, tx, "UPDATE organization_members SET role = $1 WHERE id = $2", mappedRole, result.memberID); err != nil {
+			return nil, err
+		}
+		result.previousRole, result.role = result.role, mappedRole
+	}
+
+	result.token, err = newSessionToken()
+	if err != nil {
+		return nil, err
+	}
+	sessionID := uuid.New().String()
+	ttl := fmt.Sprintf("%d seconds", int(ssoSessionTTL.Seconds()))
+	if err := execTx(ctx, tx, `
+		INSERT INTO "session" (id, "expiresAt", token, "createdAt", "updatedAt", "ipAddress", "userAgent", "userId")
+		VALUES ($1, NOW() + $2::interval, $3, NOW(), NOW(), $4, $5, $6)
+	`, sessionID, ttl, result.token, middleware.ClientIP(r), r.UserAgent(), userID); err != nil {
+		return nil, err
+	}
+	if err := execTx(ctx, tx, `
+		INSERT INTO sso_sessions (session_id, organization_id, user_id, expires_at)
+		VALUES ($1, $2, $3, NOW() + $4::interval)
+	`, sessionID, orgID, userID, ttl); err != nil {
+		return nil, err
+	}
+	// Rows for sessions that have ended are cleared as new ones start;
+	// Better Auth may have extended a session past the expiry recorded here
+	if err := execTx(ctx, tx, `
+		DELETE FROM sso_sessions ss
+		WHERE ss.expires_at < NOW()
+			AND NOT EXISTS (SELECT 1 FROM "session" s WHERE s.id = ss.session_id AND s."expiresAt" > NOW())
+	`); err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// findOrCreateUser returns the Better Auth user the IdP's subject is linked
+// to, linking the user with the email the first time or creating one.
+func (h *SSOHandler) findOrCreateUser(ctx context.Context, tx pgx.Tx, orgID, email string, identity *sso.Identity) (string, error) {
+	providerID := "sso:" + orgID
+
+	var userID string
+	err := tx.QueryRow(ctx, `
+		SELECT "userId" FROM account WHERE "providerId" = $1 AND "accountId" = $2
+	`, providerID, identity.Subject).Scan(&userID)
+	if err == nil {
+		return userID, execTx(ctx, tx, `UPDATE "user" SET "emailVerified" = TRUE, "updatedAt" = NOW() WHERE id = $1 AND NOT "emailVerified"`, userID)
+	}
+	if !errors.Is(err, pgx.ErrNoRows) {
+		return "", err
+	}
+
+	userID, err = betterAuthUser(ctx, tx, email, identity.Name)
+	if err != nil {
+		return "", err
+	}
+	return userID, execTx(ctx, tx, `
+		INSERT INTO account (id, "accountId", "providerId", "userId", "createdAt", "updatedAt")
+		VALUES ($1, $2, $3, $4, NOW(), NOW())
+	`, uuid.New().String(), identity.Subject, providerID, userID)
+}
+
+// betterAuthUser returns the Better Auth user with email, creating one
+// named name when there is none, and makes sure the backend has a users row
+// for it. Callers only pass emails at a domain the organization has
+// verified, so the address is marked verified.
+func betterAuthUser(ctx context.Context, tx pgx.Tx, email, name string) (string, error) {
+	var userID string
+	err := tx.QueryRow(ctx, `SELECT id FROM "user" WHERE LOWER(email) = $1`, email).Scan(&userID)
+	switch {
+	case errors.Is(err, pgx.ErrNoRows):
+		name = strings.TrimSpace(name)
+		if name == "" {
+			name = email[:strings.IndexByte(email, '@')]
+		}
+		userID = uuid.New().String()
+		if err := execTx(ctx, tx, `
+			INSERT INTO "user" (id, name, email, "emailVerified", "createdAt", "updatedAt")
+			VALUES ($1, $2, $3, TRUE, NOW(), NOW())
+		`, userID, name, email); err != nil {
+			return "", err
+		}
+	case err != nil:
+		return "", err
+	default:
+		if err := execTx(ctx, tx, `UPDATE "user" SET "emailVerified" = TRUE, "updatedAt" = NOW() WHERE id = $1 AND NOT "emailVerified"`, userID); err != nil {
+			return "", err
+		}
+	}
+
+	return userID, execTx(ctx, tx, `
+		INSERT INTO users (user_id, email, role, created_at, updated_at)
+		VALUES ($1, $2, 'user', NOW(), NOW())
+		ON CONFLICT (user_id) DO NOTHING
+	`, userID, email)
+}
+
+// provision adds a user signing in for the first time to the organization,
+// with the role their groups map to or else the connection's default role.
+func (h *SSOHandler) provision(ctx context.Context, tx pgx.Tx, c *ssoConnection, result *ssoSignIn, mappedRole string) error {
+	if !c.JITProvisioning {
+		return refuseSSO("not_a_member", "just-in-time provisioning is off")
+	}
+	role := mappedRole
+	if role == "" && c.DefaultRole != nil {
+		role = *c.DefaultRole
+	}
+	if role == "" {
+		return refuseSSO("no_role", "no group maps to a role and there is no default role")
+	}
+
+	// Pending invitations hold seats, so this counts them too
+	if _, err := h.quotas.CheckInvitation(ctx, c.OrganizationID); err != nil {
+		return err
+	}
+
+	result.memberID = uuid.New().String()
+	result.role = role
+	result.provisioned = true
+	if err := execTx(ctx, tx, `
+		INSERT INTO organization_members (id, organization_id, user_id, email, role, status, joined_at, invited_at, project_access)
+		VALUES ($1, $2, $3, $4, $5, 'active', NOW(), NOW(), 'all')
+	`, result.memberID, c.OrganizationID, result.userID, result.email, role); err != nil {
+		return err
+	}
+	return execTx(ctx, tx, `
+		UPDATE organization_invitations SET status = 'cancelled'
+		WHERE organization_id = $1 AND LOWER(email) = $2 AND status = 'pending'
+	`, c.OrganizationID, result.email)
+}
+
+// mapSSORole is the highest role the groups map to, or "" when none does.
+func mapSSORole(mappings []models.SSORoleMapping, groups []string) string {
+	member := make(map[string]bool, len(groups))
+	for _, g := range groups {
+		member[g] = true
+	}
+	role := ""
+	for _, m := range mappings {
+		if member[m.Group] && (role == "" || m.Role == "admin") {
+			role = m.Role
+		}
+	}
+	return role
+}
+
+// newSessionToken returns a Better Auth session token. It must not contain
+// a dot, which separates the token from its signature in the cookie.
+func newSessionToken() (string, error) {
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(buf), nil
+}
+
+// safeRedirectPath keeps redirect_to to a path in the app, so the sign-in
+// cannot be used to send users elsewhere.
+func safeRedirectPath(p string) string {
+	if !strings.HasPrefix(p, "/") || strings.HasPrefix(p, "//") || strings.HasPrefix(p, "/\\") {
+		return ssoDefaultRedirect
+	}
+	u, err := url.Parse(p)
+	if err != nil || u.Scheme != "" || u.Host != "" {
+		return ssoDefaultRedirect
+	}
+	return p
+}
+
+func (h *SSOHandler) clearCookie(w http.ResponseWriter, name, path, domain string) {
+	http.SetCookie(w, &http.Cookie{Name: name, Value: "", Path: path, Domain: domain, MaxAge: -1, HttpOnly: true})
+}
+
+// fail sends the user back to the app's sign-in page with the reason's code.
+func (h *SSOHandler) fail(w http.ResponseWriter, r *http.Request, err error) {
+	code := "server_error"
+	var refused *ssoFailure
+	if errors.As(err, &refused) {
+		code = refused.code
+		log.Warn().Err(err).Str("path", r.URL.Path).Msg("SSO sign-in refused")
+	} else {
+		log.Error().Err(err).Str("path", r.URL.Path).Msg("SSO sign-in failed")
+	}
+	http.Redirect(w, r, h.appURL+"/auth/signin?sso_error="+url.QueryEscape(code), http.StatusFound)
+}
@@ -6,8 +6,11 @@ import (
 	"fmt"
 	"net/http"
 	"strconv"
+	"strings"
 	"time"
 
+	"go-backend/auth"
+	"go-backend/authz"
 	"go-backend/database"
 	"go-backend/middleware"
 	"go-backend/models"
@@ -39,7 +42,20 @@ func (h *UserHandler) CreateUser(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if req.Role == "" {
+	if auth.IsServiceAccount(req.UserID) {
+		middleware.WriteErrorResponse(w, http.StatusBadRequest, fmt.Errorf("reserved user_id prefix"), "user_id prefix "+auth.ServiceAccountPrefix+" is reserved for service accounts")
+		return
+	}
+
+	// The policy has already matched user_id to the caller. Only platform
+	// admins pick another email or grant roles; everyone else starts as a
+	// user with the email they signed in with.
+	admin := authz.FromContext(r.Context()).Principal.PlatformAdmin
+	if claims := middleware.GetUserClaims(r.Context()); !admin && (claims == nil || !strings.EqualFold(claims.Email, req.Email)) {
+		middleware.WriteErrorResponse(w, http.StatusForbidden, fmt.Errorf("email mismatch"), "email must be the one you signed in with")
+		return
+	}
+	if req.Role == "" || !admin {
 		req.Role = "user"
 	}
 
@@ -145,12 +161,6 @@ func (h *UserHandler) UpdateUser(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	userID := vars["user_id"]
 
-	claims := middleware.GetUserClaims(r.Context())
-	if claims == nil || (claims.UserID != userID && claims.Role != "admin") {
-		middleware.WriteErrorResponse(w, http.StatusForbidden, fmt.Errorf("access denied"), "You can only update your own profile")
-		return
-	}
-
 	var req models.UpdateUserRequest
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
 		middleware.WriteErrorResponse(w, http.StatusBadRequest, err, "Invalid request body")
@@ -170,7 +180,8 @@ func (h *UserHandler) UpdateUser(w http.ResponseWriter, r *http.Request) {
 		argIndex++
 	}
 
-	if req.Role != "" && claims.Role == "admin" {
+	// Only platform admins change roles
+	if req.Role != "" && authz.FromContext(r.Context()).Principal.PlatformAdmin {
 		setParts = append(setParts, fmt.Sprintf("role = $%d", argIndex))
 		args = append(args, req.Role)
 		argIndex++
@@ -232,12 +243,6 @@ func (h *UserHandler) CreateUserResource(w http.ResponseWriter, r *http.Request)
 	vars := mux.Vars(r)
 	userID := vars["user_id"]
 
-	claims := middleware.GetUserClaims(r.Context())
-	if claims == nil || (claims.UserID != userID && claims.Role != "admin") {
-		middleware.WriteErrorResponse(w, http.StatusForbidden, fmt.Errorf("access denied"), "You can only create resources for yourself")
-		return
-	}
-
 	var req models.CreateResourceRequest
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
 		middleware.WriteErrorResponse(w, http.StatusBadRequest, err, "Invalid request body")
@@ -297,12 +302,6 @@ func (h *UserHandler) GetUserResources(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	userID := vars["user_id"]
 
-	claims := middleware.GetUserClaims(r.Context())
-	if claims == nil || (claims.UserID != userID && claims.Role != "admin") {
-		middleware.WriteErrorResponse(w, http.StatusForbidden, fmt.Errorf("access denied"), "You can only access your own resources")
-		return
-	}
-
 	ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
 	defer cancel()
 
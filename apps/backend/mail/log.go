@@ -0,0 +1,26 @@
+package mail
+
+import (
+	"context"
+
+	"github.com/rs/zerolog/log"
+)
+
+// LogMailer writes messages to the log instead of sending them, for local
+// development.
+type LogMailer struct{}
+
+func NewLogMailer() *LogMailer {
+	return &LogMailer{}
+}
+
+func (m *LogMailer) Name() string { return "log" }
+
+func (m *LogMailer) Send(ctx context.Context, msg *Message) error {
+	log.Info().
+		Str("to", msg.To).
+		Str("subject", msg.Subject).
+		Str("body", msg.Text).
+		Msg("Email not sent (log mailer)")
+	return nil
+}
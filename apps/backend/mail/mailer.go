@@ -0,0 +1,70 @@
+// Package mail sends the server's email. Handlers never talk to a mail
+// server directly: they render a Message and put it in the Outbox, and the
+// Outbox hands it to the Mailer picked once at startup from configuration,
+// retrying until the mail server accepts or rejects it.
+package mail
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// Message is one email, with plain text and HTML versions of its body.
+type Message struct {
+	To      string
+	Subject string
+	Text    string
+	HTML    string
+}
+
+// Mailer delivers messages.
+type Mailer interface {
+	Name() string
+	Send(ctx context.Context, msg *Message) error
+}
+
+// RejectedError is returned when the mail server refused a message for good,
+// such as for an unknown recipient. Sending it again would not help.
+type RejectedError struct {
+	Err error
+}
+
+func (e *RejectedError) Error() string { return "rejected: " + e.Err.Error() }
+func (e *RejectedError) Unwrap() error { return e.Err }
+
+// IsRejected reports whether err is a permanent rejection.
+func IsRejected(err error) bool {
+	var rejected *RejectedError
+	return errors.As(err, &rejected)
+}
+
+type Config struct {
+	Provider string // "smtp", "log" or empty for log
+	Host     string
+	Port     int
+	Username string
+	Password string
+	From     string
+	Timeout  time.Duration
+}
+
+// NewMailer builds the mailer named in cfg. Without a provider, messages
+// are only logged.
+func NewMailer(cfg Config) (Mailer, error) {
+	switch cfg.Provider {
+	case "", "log":
+		return NewLogMailer(), nil
+	case "smtp":
+		if cfg.Host == "" {
+			return nil, fmt.Errorf("SMTP_HOST is required for the smtp provider")
+		}
+		if cfg.From == "" {
+			return nil, fmt.Errorf("MAIL_FROM is required for the smtp provider")
+		}
+		return NewSMTPMailer(cfg.Host, cfg.Port, cfg.Username, cfg.Password, cfg.From, cfg.Timeout), nil
+	default:
+		return nil, fmt.Errorf("unknown mail provider %q", cfg.Provider)
+	}
+}
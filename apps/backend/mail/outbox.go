@@ -0,0 +1,229 @@
+package mail
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"go-backend/database"
+
+	"github.com/google/uuid"
+	"github.com/rs/zerolog/log"
+)
+
+// Delivery statuses of an outbox email.
+const (
+	StatusPending = "pending"
+	StatusSent    = "sent"
+	StatusFailed  = "failed"
+	StatusBounced = "bounced"
+)
+
+// Kinds of email.
+const (
+	KindInvitation = "invitation"
+)
+
+const (
+	// maxAttempts is how many times an email is tried before it is failed.
+	maxAttempts = 8
+	// firstRetry doubles after every failed attempt, up to maxRetry.
+	firstRetry = 30 * time.Second
+	maxRetry   = 6 * time.Hour
+	// lease is how long a worker holds an email it is sending. If the
+	// server stops mid-send, the email is tried again once it runs out.
+	lease     = 5 * time.Minute
+	batchSize = 20
+)
+
+// Email is a message to put in the outbox, with what it is about.
+type Email struct {
+	Kind           string
+	OrganizationID string
+	InvitationID   string
+	Message        *Message
+}
+
+// Outbox stores email in the email_outbox table and delivers it in the
+// background, so a request never waits on the mail server and emails
+// survive restarts.
+type Outbox struct {
+	db     *database.PostgresDB
+	mailer Mailer
+	wake   chan struct{}
+	mu     sync.Mutex
+}
+
+func NewOutbox(db *database.PostgresDB, mailer Mailer) *Outbox {
+	return &Outbox{db: db, mailer: mailer, wake: make(chan struct{}, 1)}
+}
+
+// Enqueue stores e for delivery and wakes the worker.
+func (o *Outbox) Enqueue(ctx context.Context, e Email) (string, error) {
+	id := uuid.New().String()
+	err := o.db.Exec(ctx, `
+		INSERT INTO email_outbox (id, organization_id, invitation_id, kind, recipient, subject, text_body, html_body)
+		VALUES ($1, NULLIF($2, ''), NULLIF($3, ''), $4, $5, $6, $7, $8)
+	`, id, e.OrganizationID, e.InvitationID, e.Kind, e.Message.To, e.Message.Subject, e.Message.Text, e.Message.HTML)
+	if err != nil {
+		return "", fmt.Errorf("failed to queue email: %w", err)
+	}
+
+	select {
+	case o.wake <- struct{}{}:
+	default:
+	}
+	return id, nil
+}
+
+// Withdraw drops the invitation's emails that have not been sent yet, for
+// when it is resent or cancelled.
+func (o *Outbox) Withdraw(ctx context.Context, invitationID string) error {
+	err := o.db.Exec(ctx, `
+		DELETE FROM email_outbox WHERE invitation_id = $1 AND status = 'pending'
+	`, invitationID)
+	if err != nil {
+		return fmt.Errorf("failed to withdraw emails: %w", err)
+	}
+	return nil
+}
+
+type outboxEmail struct {
+	id       string
+	attempts int
+	msg      Message
+}
+
+// Run sends the emails that are due and returns how many it tried.
+func (o *Outbox) Run(ctx context.Context) (int, error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	// Claiming pushes next_attempt_at past the lease, so other servers skip
+	// these emails while this one sends them
+	rows, err := o.db.Query(ctx, `
+		UPDATE email_outbox
+		SET attempts = attempts + 1, next_attempt_at = NOW() + make_interval(secs => $1)
+		WHERE id IN (
+			SELECT id FROM email_outbox
+			WHERE status = 'pending' AND next_attempt_at <= NOW()
+			ORDER BY next_attempt_at
+			LIMIT $2
+			FOR UPDATE SKIP LOCKED
+		)
+		RETURNING id, attempts, recipient, subject, text_body, html_body
+	`, int(lease.Seconds()), batchSize)
+	if err != nil {
+		return 0, fmt.Errorf("failed to claim emails: %w", err)
+	}
+	var due []outboxEmail
+	for rows.Next() {
+		var e outboxEmail
+		if err := rows.Scan(&e.id, &e.attempts, &e.msg.To, &e.msg.Subject, &e.msg.Text, &e.msg.HTML); err != nil {
+			rows.Close()
+			return 0, fmt.Errorf("failed to claim emails: %w", err)
+		}
+		due = append(due, e)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, fmt.Errorf("failed to claim emails: %w", err)
+	}
+
+	for i := range due {
+		o.deliver(ctx, &due[i])
+	}
+	return len(due), nil
+}
+
+func (o *Outbox) deliver(ctx context.Context, e *outboxEmail) {
+	err := o.mailer.Send(ctx, &e.msg)
+
+	var status string
+	var next time.Time
+	switch {
+	case err == nil:
+		err = o.db.Exec(ctx, `
+			UPDATE email_outbox SET status = 'sent', sent_at = NOW(), last_error = NULL WHERE id = $1
+		`, e.id)
+		if err != nil {
+			log.Error().Err(err).Str("email_id", e.id).Msg("Failed to mark email sent")
+		}
+		return
+	case IsRejected(err):
+		status = StatusBounced
+	case e.attempts >= maxAttempts:
+		status = StatusFailed
+	default:
+		status = StatusPending
+		next = time.Now().Add(retryDelay(e.attempts))
+	}
+
+	log.Warn().Err(err).
+		Str("email_id", e.id).
+		Str("mailer", o.mailer.Name()).
+		Int("attempts", e.attempts).
+		Str("status", status).
+		Msg("Failed to send email")
+
+	var nextAt *time.Time
+	if status == StatusPending {
+		nextAt = &next
+	}
+	updateErr := o.db.Exec(ctx, `
+		UPDATE email_outbox
+		SET status = $2, last_error = $3, next_attempt_at = COALESCE($4, next_attempt_at)
+		WHERE id = $1
+	`, e.id, status, err.Error(), nextAt)
+	if updateErr != nil {
+		log.Error().Err(updateErr).Str("email_id", e.id).Msg("Failed to record email failure")
+	}
+}
+
+// retryDelay is the wait after the given number of failed attempts.
+func retryDelay(attempts int) time.Duration {
+	d := firstRetry
+	for i := 1; i < attempts && d < maxRetry; i++ {
+		d *= 2
+	}
+	if d > maxRetry {
+		d = maxRetry
+	}
+	return d
+}
+
+// Start runs the outbox every interval, and as soon as an email is queued,
+// until the returned stop function is called.
+func (o *Outbox) Start(interval time.Duration) (stop func()) {
+	ticker := time.NewTicker(interval)
+	done := make(chan struct{})
+
+	go func() {
+		for {
+			select {
+			case <-ticker.C:
+			case <-o.wake:
+			case <-done:
+				ticker.Stop()
+				return
+			}
+
+			// Keep going while full batches come back
+			for {
+				ctx, cancel := context.WithTimeout(context.Background(), lease)
+				n, err := o.Run(ctx)
+				cancel()
+				if err != nil {
+					log.Error().Err(err).Msg("Failed to run email outbox")
+				}
+				if err != nil || n < batchSize {
+					break
+				}
+			}
+		}
+	}()
+
+	var once sync.Once
+	return func() { once.Do(func() { close(done) }) }
+}
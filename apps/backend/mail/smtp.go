@@ -0,0 +1,185 @@
+package mail
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/tls"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"mime"
+	"mime/quotedprintable"
+	"net"
+	"net/mail"
+	"net/smtp"
+	"net/textproto"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// SMTPMailer sends through an SMTP relay. Port 465 uses implicit TLS; other
+// ports upgrade with STARTTLS when the server offers it. Credentials are
+// only sent over TLS.
+type SMTPMailer struct {
+	host     string
+	port     int
+	username string
+	password string
+	from     string
+	timeout  time.Duration
+}
+
+func NewSMTPMailer(host string, port int, username, password, from string, timeout time.Duration) *SMTPMailer {
+	if port == 0 {
+		port = 587
+	}
+	if timeout == 0 {
+		timeout = 30 * time.Second
+	}
+	return &SMTPMailer{host: host, port: port, username: username, password: password, from: from, timeout: timeout}
+}
+
+func (m *SMTPMailer) Name() string { return "smtp" }
+
+func (m *SMTPMailer) Send(ctx context.Context, msg *Message) error {
+	from, err := mail.ParseAddress(m.from)
+	if err != nil {
+		return fmt.Errorf("invalid MAIL_FROM: %w", err)
+	}
+	to, err := mail.ParseAddress(msg.To)
+	if err != nil {
+		return &RejectedError{Err: fmt.Errorf("invalid recipient: %w", err)}
+	}
+	body, err := buildMessage(from, to, msg)
+	if err != nil {
+		return err
+	}
+
+	deadline := time.Now().Add(m.timeout)
+	if d, ok := ctx.Deadline(); ok && d.Before(deadline) {
+		deadline = d
+	}
+	addr := net.JoinHostPort(m.host, strconv.Itoa(m.port))
+	dialer := &net.Dialer{Deadline: deadline}
+	tlsConfig := &tls.Config{ServerName: m.host}
+
+	var conn net.Conn
+	if m.port == 465 {
+		conn, err = tls.DialWithDialer(dialer, "tcp", addr, tlsConfig)
+	} else {
+		conn, err = dialer.DialContext(ctx, "tcp", addr)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to connect to SMTP server: %w", err)
+	}
+	conn.SetDeadline(deadline)
+
+	c, err := smtp.NewClient(conn, m.host)
+	if err != nil {
+		conn.Close()
+		return classify(err)
+	}
+	defer c.Close()
+
+	if m.port != 465 {
+		if ok, _ := c.Extension("STARTTLS"); ok {
+			if err := c.StartTLS(tlsConfig); err != nil {
+				return fmt.Errorf("STARTTLS failed: %w", err)
+			}
+		}
+	}
+	if m.username != "" {
+		if _, ok := c.TLSConnectionState(); !ok {
+			return errors.New("SMTP server does not support TLS; refusing to send credentials")
+		}
+		if err := c.Auth(smtp.PlainAuth("", m.username, m.password, m.host)); err != nil {
+			return classify(err)
+		}
+	}
+
+	if err := c.Mail(from.Address); err != nil {
+		return classify(err)
+	}
+	if err := c.Rcpt(to.Address); err != nil {
+		return classify(err)
+	}
+	w, err := c.Data()
+	if err != nil {
+		return classify(err)
+	}
+	if _, err := w.Write(body); err != nil {
+		w.Close()
+		return classify(err)
+	}
+	if err := w.Close(); err != nil {
+		return classify(err)
+	}
+	return c.Quit()
+}
+
+// classify marks 5xx replies as rejections; 4xx replies and network errors
+// are worth retrying.
+func classify(err error) error {
+	var reply *textproto.Error
+	if errors.As(err, &reply) && reply.Code >= 500 {
+		return &RejectedError{Err: err}
+	}
+	return err
+}
+
+// buildMessage encodes msg as a multipart/alternative message with quoted
+// printable text and HTML parts.
+func buildMessage(from, to *mail.Address, msg *Message) ([]byte, error) {
+	var b bytes.Buffer
+	boundary, err := randomBoundary()
+	if err != nil {
+		return nil, err
+	}
+
+	header := func(k, v string) { fmt.Fprintf(&b, "%s: %s\r\n", k, v) }
+	header("From", from.String())
+	header("To", to.String())
+	header("Subject", mime.QEncoding.Encode("utf-8", msg.Subject))
+	header("Date", time.Now().Format(time.RFC1123Z))
+	header("Message-ID", fmt.Sprintf("<%s@%s>", boundary, domainOf(from.Address)))
+	header("MIME-Version", "1.0")
+	header("Content-Type", `multipart/alternative; boundary="`+boundary+`"`)
+	b.WriteString("\r\n")
+
+	for _, part := range []struct{ contentType, body string }{
+		{"text/plain; charset=utf-8", msg.Text},
+		{"text/html; charset=utf-8", msg.HTML},
+	} {
+		fmt.Fprintf(&b, "--%s\r\n", boundary)
+		header("Content-Type", part.contentType)
+		header("Content-Transfer-Encoding", "quoted-printable")
+		b.WriteString("\r\n")
+		qp := quotedprintable.NewWriter(&b)
+		if _, err := qp.Write([]byte(part.body)); err != nil {
+			return nil, err
+		}
+		if err := qp.Close(); err != nil {
+			return nil, err
+		}
+		b.WriteString("\r\n")
+	}
+	fmt.Fprintf(&b, "--%s--\r\n", boundary)
+	return b.Bytes(), nil
+}
+
+func randomBoundary() (string, error) {
+	buf := make([]byte, 16)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(buf), nil
+}
+
+func domainOf(address string) string {
+	if i := strings.LastIndex(address, "@"); i >= 0 {
+		return address[i+1:]
+	}
+	return "localhost"
+}
@@ -0,0 +1,76 @@
+package mail
+
+import (
+	"bytes"
+	htmltemplate "html/template"
+	texttemplate "text/template"
+	"time"
+)
+
+// InvitationData fills the invitation email.
+type InvitationData struct {
+	OrganizationName string
+	InviterEmail     string
+	Role             string
+	Message          string
+	AcceptURL        string
+	ExpiresAt        time.Time
+}
+
+var invitationSubject = texttemplate.Must(texttemplate.New("subject").Parse(
+	`{{if .InviterEmail}}{{.InviterEmail}} invited you{{else}}You're invited{{end}} to join {{.OrganizationName}}`))
+
+var invitationText = texttemplate.Must(texttemplate.New("text").Parse(`Hello,
+
+{{if .InviterEmail}}{{.InviterEmail}} has invited you{{else}}You have been invited{{end}} to join {{.OrganizationName}} as {{.Role}}.
+{{if .Message}}
+"{{.Message}}"
+{{end}}
+Accept the invitation here:
+{{.AcceptURL}}
+
+The invitation expires on {{.ExpiresAt.UTC.Format "January 2, 2006 at 15:04 MST"}}. Sign in with this email address to accept it.
+
+If you weren't expecting this invitation, you can ignore this email.
+`))
+
+var invitationHTML = htmltemplate.Must(htmltemplate.New("html").Parse(`<!DOCTYPE html>
+<html>
+<body style="font-family: -apple-system, BlinkMacSystemFont, 'Segoe UI', sans-serif; color: #111827; line-height: 1.5;">
+  <div style="max-width: 560px; margin: 0 auto; padding: 24px;">
+    <p>Hello,</p>
+    <p>{{if .InviterEmail}}<strong>{{.InviterEmail}}</strong> has invited you{{else}}You have been invited{{end}} to join <strong>{{.OrganizationName}}</strong> as {{.Role}}.</p>
+    {{if .Message}}<blockquote style="margin: 16px 0; padding-left: 12px; border-left: 3px solid #d1d5db; color: #4b5563;">{{.Message}}</blockquote>{{end}}
+    <p style="margin: 24px 0;">
+      <a href="{{.AcceptURL}}" style="background: #111827; color: #ffffff; padding: 10px 18px; border-radius: 6px; text-decoration: none;">Accept invitation</a>
+    </p>
+    <p style="font-size: 13px; color: #6b7280;">Or open this link: <a href="{{.AcceptURL}}">{{.AcceptURL}}</a></p>
+    <p style="font-size: 13px; color: #6b7280;">The invitation expires on {{.ExpiresAt.UTC.Format "January 2, 2006 at 15:04 MST"}}. Sign in with this email address to accept it. If you weren't expecting this invitation, you can ignore this email.</p>
+  </div>
+</body>
+</html>
+`))
+
+// RenderInvitation builds the invitation email for to.
+func RenderInvitation(to string, data InvitationData) (*Message, error) {
+	msg := &Message{To: to}
+	var b bytes.Buffer
+
+	if err := invitationSubject.Execute(&b, data); err != nil {
+		return nil, err
+	}
+	msg.Subject = b.String()
+
+	b.Reset()
+	if err := invitationText.Execute(&b, data); err != nil {
+		return nil, err
+	}
+	msg.Text = b.String()
+
+	b.Reset()
+	if err := invitationHTML.Execute(&b, data); err != nil {
+		return nil, err
+	}
+	msg.HTML = b.String()
+	return msg, nil
+}
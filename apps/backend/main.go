@@ -2,6 +2,7 @@ package main
 
 import (
 	"context"
+	"encoding/base64"
 	"fmt"
 	"net/http"
 	"os"
@@ -9,11 +10,18 @@ import (
 	"syscall"
 	"time"
 
+	"go-backend/ai"
+	"go-backend/audit"
 	"go-backend/auth"
+	"go-backend/authz"
+	"go-backend/billing"
 	"go-backend/config"
 	"go-backend/database"
 	"go-backend/handlers"
+	"go-backend/mail"
 	"go-backend/middleware"
+	"go-backend/quota"
+	"go-backend/sso"
 
 	gorillaHandlers "github.com/gorilla/handlers"
 	"github.com/gorilla/mux"
@@ -26,10 +34,20 @@ type Server struct {
 	db          *database.PostgresDB
 	redis       *database.RedisClient
 	jwtValidator *auth.JWTValidator
+	sessions    *auth.SessionValidator
 	sshTunnel   *database.SSHTunnel
 	httpServer  *http.Server
 	dbConfigHandler *handlers.DatabaseConfigHandler
 	sqlPlaygroundHandler *handlers.SQLPlaygroundHandler
+	aiProvider  ai.Provider
+	billingProvider billing.Provider
+	quotas      *quota.Service
+	checkpointer *audit.Checkpointer
+	outbox      *mail.Outbox
+	stopMetering func()
+	stopCheckpoints func()
+	stopKeyRefresh func()
+	stopOutbox func()
 }
 
 func main() {
@@ -60,10 +78,6 @@ func run() error {
 func (s *Server) initialize() error {
 	log.Info().Msg("Initializing server...")
 
-	if err := s.initializeAuth(); err != nil {
-		return fmt.Errorf("failed to initialize auth: %w", err)
-	}
-
 	if err := s.initializeSSHTunnel(); err != nil {
 		return fmt.Errorf("failed to initialize SSH tunnel: %w", err)
 	}
@@ -72,10 +86,39 @@ func (s *Server) initialize() error {
 		return fmt.Errorf("failed to initialize database: %w", err)
 	}
 
+	// Auth reads roles from the database
+	if err := s.initializeAuth(); err != nil {
+		return fmt.Errorf("failed to initialize auth: %w", err)
+	}
+
 	if err := s.initializeRedis(); err != nil {
 		log.Warn().Err(err).Msg("Failed to initialize Redis - continuing without cache")
 	}
 
+	if err := s.initializeSessions(); err != nil {
+		return fmt.Errorf("failed to initialize session validation: %w", err)
+	}
+
+	if err := s.initializeQuotas(); err != nil {
+		return fmt.Errorf("failed to initialize plans: %w", err)
+	}
+
+	if err := s.initializeAI(); err != nil {
+		return fmt.Errorf("failed to initialize AI provider: %w", err)
+	}
+
+	if err := s.initializeBilling(); err != nil {
+		return fmt.Errorf("failed to initialize billing provider: %w", err)
+	}
+
+	if err := s.initializeAuditCheckpoints(); err != nil {
+		return fmt.Errorf("failed to initialize audit checkpoints: %w", err)
+	}
+
+	if err := s.initializeMail(); err != nil {
+		return fmt.Errorf("failed to initialize mail: %w", err)
+	}
+
 	log.Info().Msg("Server initialized successfully")
 	return nil
 }
@@ -85,7 +128,21 @@ func (s *Server) initializeAuth() error {
 		return fmt.Errorf("BETTER_AUTH_SECRET is required")
 	}
 
-	jwtValidator, err := auth.NewJWTValidator(s.config.BetterAuthSecret)
+	keys, err := s.loadSigningKeys()
+	if err != nil {
+		return err
+	}
+
+	jwtValidator, err := auth.NewJWTValidator(auth.JWTConfig{
+		Secret:    s.config.BetterAuthSecret,
+		Keys:      keys,
+		Issuer:    s.config.JWTIssuer,
+		Audience:  s.config.JWTAudience,
+		ClockSkew: s.config.JWTClockSkew,
+		Roles:     auth.DBRoleLookup(s.db),
+
+		TrustEmailVerified: s.config.JWTTrustEmailVerified,
+	})
 	if err != nil {
 		return fmt.Errorf("failed to create JWT validator: %w", err)
 	}
@@ -95,6 +152,59 @@ func (s *Server) initializeAuth() error {
 	return nil
 }
 
+// loadSigningKeys loads the public keys for asymmetric JWTs, if a JWKS URL or
+// key file is configured, and keeps them refreshed. An unreachable JWKS URL
+// is not fatal: refreshes keep retrying and HMAC tokens still work.
+func (s *Server) loadSigningKeys() (*auth.KeySet, error) {
+	var keys *auth.KeySet
+	switch {
+	case s.config.JWTJWKSURL != "":
+		keys = auth.NewJWKSKeySet(s.config.JWTJWKSURL, 10*time.Second)
+	case s.config.JWTPublicKeyFile != "":
+		keys = auth.NewFileKeySet(s.config.JWTPublicKeyFile)
+	default:
+		return nil, nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+	defer cancel()
+
+	if err := keys.Refresh(ctx); err != nil {
+		if s.config.JWTJWKSURL == "" {
+			return nil, fmt.Errorf("failed to load JWT public keys: %w", err)
+		}
+		log.Warn().Err(err).Msg("Failed to fetch JWKS - retrying in the background")
+	}
+
+	s.stopKeyRefresh = keys.Start(s.config.JWTKeyRefreshInterval)
+	log.Info().
+		Int("keys", keys.Size()).
+		Dur("refresh_interval", s.config.JWTKeyRefreshInterval).
+		Msg("JWT signing keys loaded")
+	return keys, nil
+}
+
+func (s *Server) initializeSessions() error {
+	var store auth.SessionStore
+	if s.config.BetterAuthSessionURL != "" {
+		store = auth.NewHTTPSessionStore(s.db, s.config.BetterAuthSessionURL, s.config.BetterAuthSecret, 10*time.Second)
+	} else {
+		store = auth.NewDBSessionStore(s.db)
+	}
+
+	sessions, err := auth.NewSessionValidator(s.config.BetterAuthSecret, store, s.redis, s.config.BetterAuthSessionCacheTTL)
+	if err != nil {
+		return err
+	}
+
+	s.sessions = sessions
+	log.Info().
+		Bool("remote", s.config.BetterAuthSessionURL != "").
+		Dur("cache_ttl", s.config.BetterAuthSessionCacheTTL).
+		Msg("Session validator initialized")
+	return nil
+}
+
 func (s *Server) initializeSSHTunnel() error {
 	if s.config.SSHHost == "" {
 		log.Info().Msg("SSH tunnel not configured - skipping")
@@ -155,6 +265,104 @@ func (s *Server) initializeRedis() error {
 	return nil
 }
 
+func (s *Server) initializeQuotas() error {
+	quotas := quota.NewService(s.db, s.redis)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	if err := quotas.EnsureDefaultPlans(ctx); err != nil {
+		return err
+	}
+
+	s.quotas = quotas
+	log.Info().Msg("Plans initialized")
+	return nil
+}
+
+func (s *Server) initializeAI() error {
+	provider, err := ai.NewProvider(ai.Config{
+		Provider: s.config.AIProvider,
+		BaseURL:  s.config.AIBaseURL,
+		APIKey:   s.config.AIAPIKey,
+		Model:    s.config.AIModel,
+		Timeout:  s.config.AITimeout,
+	})
+	if err != nil {
+		return err
+	}
+	if provider == nil {
+		log.Info().Msg("AI provider not configured - AI assistant disabled")
+		return nil
+	}
+
+	s.aiProvider = provider
+	log.Info().Str("provider", provider.Name()).Msg("AI provider initialized")
+	return nil
+}
+
+func (s *Server) initializeBilling() error {
+	provider, err := billing.NewProvider(billing.Config{
+		Provider:      s.config.BillingProvider,
+		BaseURL:       s.config.BillingAPIURL,
+		APIKey:        s.config.BillingAPIKey,
+		WebhookSecret: s.config.BillingWebhookSecret,
+		Timeout:       s.config.BillingTimeout,
+	})
+	if err != nil {
+		return err
+	}
+	if provider == nil {
+		log.Info().Msg("Billing provider not configured - checkout and webhooks disabled")
+		return nil
+	}
+
+	s.billingProvider = provider
+	log.Info().Str("provider", provider.Name()).Msg("Billing provider initialized")
+	return nil
+}
+
+func (s *Server) initializeAuditCheckpoints() error {
+	if s.config.AuditSigningKey == "" {
+		log.Warn().Msg("AUDIT_SIGNING_KEY not set - audit checkpoints disabled")
+		return nil
+	}
+
+	key, err := audit.ParseSigningKey(s.config.AuditSigningKey)
+	if err != nil {
+		return err
+	}
+
+	s.checkpointer = audit.NewCheckpointer(s.db, key, s.config.AuditCheckpointFile)
+	s.stopCheckpoints = s.checkpointer.Start(s.config.AuditCheckpointInterval)
+	log.Info().
+		Str("key_id", audit.KeyID(s.checkpointer.PublicKey())).
+		Str("public_key", base64.StdEncoding.EncodeToString(s.checkpointer.PublicKey())).
+		Dur("interval", s.config.AuditCheckpointInterval).
+		Msg("Audit checkpoints initialized")
+	return nil
+}
+
+func (s *Server) initializeMail() error {
+	mailer, err := mail.NewMailer(mail.Config{
+		Provider: s.config.MailProvider,
+		Host:     s.config.SMTPHost,
+		Port:     s.config.SMTPPort,
+		Username: s.config.SMTPUsername,
+		Password: s.config.SMTPPassword,
+		From:     s.config.MailFrom,
+		Timeout:  s.config.MailTimeout,
+	})
+	if err != nil {
+		return err
+	}
+
+	s.outbox = mail.NewOutbox(s.db, mailer)
+	s.stopOutbox = s.outbox.Start(s.config.MailOutboxInterval)
+	log.Info().Str("mailer", mailer.Name()).Msg("Mail outbox initialized")
+	return nil
+}
+
 func (s *Server) start() error {
 	router := s.setupRoutes()
 	
@@ -182,6 +390,7 @@ func (s *Server) start() error {
 func (s *Server) setupRoutes() http.Handler {
         r := mux.NewRouter()
 
+        r.Use(middleware.RequestIDMiddleware())
         r.Use(middleware.RecoveryMiddleware())
         r.Use(middleware.HealthCheckLoggingMiddleware())
         r.Use(middleware.RateLimitMiddleware(s.config.RateLimitRPS, s.config.RateLimitBurst))
@@ -190,17 +399,32 @@ func (s *Server) setupRoutes() http.Handler {
         r.HandleFunc("/", s.rootHandler).Methods("GET")
 
         api := r.PathPrefix("/api/v1").Subrouter()
-        api.Use(middleware.AuthMiddleware(s.jwtValidator))
+        api.Use(middleware.AuthMiddleware(s.jwtValidator, s.sessions, auth.NewAPIKeyValidator(s.db)))
         api.Use(middleware.UserRateLimitMiddleware(s.config.RateLimitRPS*2, s.config.RateLimitBurst*2))
+        api.Use(authz.NewAuthorizer(s.db).Middleware())
 
         // Initialize handlers
+        quotas := s.quotas
+        auditLog := audit.NewLogger(s.db)
         userHandler := handlers.NewUserHandler(s.db, s.redis)
         metricsHandler := handlers.NewMetricsHandler(s.db, s.redis)
-        organizationHandler := handlers.NewOrganizationHandler(s.db)
-        projectHandler := handlers.NewProjectHandler(s.db)
-        invitationHandler := handlers.NewInvitationHandler(s.db)
-        s.dbConfigHandler = handlers.NewDatabaseConfigHandler(s.db, s.redis)
-        s.sqlPlaygroundHandler = handlers.NewSQLPlaygroundHandler(s.db, s.redis, s.dbConfigHandler)
+        organizationHandler := handlers.NewOrganizationHandler(s.db, quotas, auditLog, s.outbox, s.config.AppURL)
+        projectHandler := handlers.NewProjectHandler(s.db, quotas, auditLog)
+        invitationHandler := handlers.NewInvitationHandler(s.db, quotas, auditLog, s.outbox, s.config.AppURL)
+        s.dbConfigHandler = handlers.NewDatabaseConfigHandler(s.db, s.redis, quotas, auditLog)
+        s.stopMetering = s.dbConfigHandler.StartConnectionMetering(5 * time.Minute)
+        s.sqlPlaygroundHandler = handlers.NewSQLPlaygroundHandler(s.db, s.redis, s.dbConfigHandler, quotas, auditLog)
+        schemaDocsHandler := handlers.NewSchemaDocsHandler(s.db, s.redis, s.dbConfigHandler, s.sqlPlaygroundHandler)
+        aiAssistantHandler := handlers.NewAIAssistantHandler(s.db, s.sqlPlaygroundHandler, s.aiProvider, quotas)
+        planHandler := handlers.NewPlanHandler(quotas, auditLog)
+        billingHandler := handlers.NewBillingHandler(s.db, s.billingProvider, quotas, auditLog)
+        memberHandler := handlers.NewMemberHandler(s.db, quotas, auditLog)
+        auditHandler := handlers.NewAuditHandler(s.db, quotas, auditLog, s.checkpointer)
+        apiKeyHandler := handlers.NewAPIKeyHandler(s.db, auditLog)
+        serviceAccountHandler := handlers.NewServiceAccountHandler(s.db, quotas, auditLog)
+        domainHandler := handlers.NewOrganizationDomainHandler(s.db, quotas, auditLog)
+        scimHandler := handlers.NewSCIMHandler(s.db, quotas, auditLog, s.config.APIURL)
+        ssoHandler := handlers.NewSSOHandler(s.db, quotas, auditLog, sso.NewClient(s.config.SSOTimeout, s.config.SSOMockIdP), s.sessions, s.config.AppURL, s.config.APIURL, s.config.SSOCookieDomain)
 
         // User routes
         users := api.PathPrefix("/users").Subrouter()
@@ -216,15 +440,66 @@ func (s *Server) setupRoutes() http.Handler {
         users.HandleFunc("/{user_id}/sql/execute", s.sqlPlaygroundHandler.ExecuteQuery).Methods("POST")
         users.HandleFunc("/{user_id}/sql/schema", s.sqlPlaygroundHandler.GetDatabaseSchema).Methods("GET")
         users.HandleFunc("/{user_id}/sql/history", s.sqlPlaygroundHandler.GetQueryHistory).Methods("GET")
+        users.HandleFunc("/{user_id}/sql/history/shapes", s.sqlPlaygroundHandler.GetQueryShapes).Methods("GET")
+        users.HandleFunc("/{user_id}/sql/complete", s.sqlPlaygroundHandler.CompleteQuery).Methods("POST")
+        users.HandleFunc("/{user_id}/sql/lint", s.sqlPlaygroundHandler.LintQuery).Methods("POST")
+        users.HandleFunc("/{user_id}/sql/format", s.sqlPlaygroundHandler.FormatQuery).Methods("POST")
+        users.HandleFunc("/{user_id}/sql/ai/generate", aiAssistantHandler.GenerateSQL).Methods("POST")
+        users.HandleFunc("/{user_id}/sql/ai/fix", aiAssistantHandler.FixQuery).Methods("POST")
+        users.HandleFunc("/{user_id}/sql/docs", schemaDocsHandler.GetDataDictionary).Methods("GET")
+        users.HandleFunc("/{user_id}/api-keys", apiKeyHandler.ListUserAPIKeys).Methods("GET")
+        users.HandleFunc("/{user_id}/api-keys", apiKeyHandler.CreateUserAPIKey).Methods("POST")
+        users.HandleFunc("/{user_id}/api-keys/{keyId}", apiKeyHandler.RevokeUserAPIKey).Methods("DELETE")
 
         // Organization routes
         users.HandleFunc("/{userId}/organizations", organizationHandler.GetUserOrganizations).Methods("GET")
         users.HandleFunc("/{userId}/organizations", organizationHandler.CreateOrganization).Methods("POST")
+        users.HandleFunc("/{userId}/organizations/joinable", domainHandler.ListJoinableOrganizations).Methods("GET")
+        users.HandleFunc("/{userId}/organizations/auto-join", domainHandler.AutoJoin).Methods("POST")
         users.HandleFunc("/{userId}/organizations/{orgId}", organizationHandler.GetOrganization).Methods("GET")
         users.HandleFunc("/{userId}/organizations/{orgId}/usage", organizationHandler.GetOrganizationUsage).Methods("GET")
+        users.HandleFunc("/{userId}/organizations/{orgId}/usage/cycles", organizationHandler.GetUsageCycles).Methods("GET")
+        users.HandleFunc("/{userId}/organizations/{orgId}/billing", billingHandler.GetBillingStatus).Methods("GET")
+        users.HandleFunc("/{userId}/organizations/{orgId}/billing/checkout", billingHandler.CreateCheckoutSession).Methods("POST")
+        users.HandleFunc("/{userId}/organizations/{orgId}/audit-log", auditHandler.GetOrganizationAuditLog).Methods("GET")
+        users.HandleFunc("/{userId}/organizations/{orgId}/audit-log/export", auditHandler.ExportOrganizationAuditLog).Methods("GET")
+        users.HandleFunc("/{userId}/organizations/{orgId}/audit-log/verify", auditHandler.VerifyOrganizationAuditLog).Methods("GET")
+        users.HandleFunc("/{userId}/organizations/{orgId}/audit-log/checkpoints", auditHandler.GetOrganizationCheckpoints).Methods("GET")
+        users.HandleFunc("/{userId}/organizations/{orgId}/api-keys", apiKeyHandler.ListOrganizationAPIKeys).Methods("GET")
+        users.HandleFunc("/{userId}/organizations/{orgId}/api-keys", apiKeyHandler.CreateOrganizationAPIKey).Methods("POST")
+        users.HandleFunc("/{userId}/organizations/{orgId}/api-keys/{keyId}", apiKeyHandler.RevokeOrganizationAPIKey).Methods("DELETE")
+        users.HandleFunc("/{userId}/organizations/{orgId}/service-accounts", serviceAccountHandler.ListServiceAccounts).Methods("GET")
+        users.HandleFunc("/{userId}/organizations/{orgId}/service-accounts", serviceAccountHandler.CreateServiceAccount).Methods("POST")
+        users.HandleFunc("/{userId}/organizations/{orgId}/service-accounts/{accountId}", serviceAccountHandler.UpdateServiceAccount).Methods("PUT")
+        users.HandleFunc("/{userId}/organizations/{orgId}/service-accounts/{accountId}", serviceAccountHandler.DeleteServiceAccount).Methods("DELETE")
+        users.HandleFunc("/{userId}/organizations/{orgId}/domains", domainHandler.ListDomains).Methods("GET")
+        users.HandleFunc("/{userId}/organizations/{orgId}/domains", domainHandler.AddDomain).Methods("POST")
+        users.HandleFunc("/{userId}/organizations/{orgId}/domains/{domainId}", domainHandler.UpdateDomain).Methods("PUT")
+        users.HandleFunc("/{userId}/organizations/{orgId}/domains/{domainId}", domainHandler.DeleteDomain).Methods("DELETE")
+        users.HandleFunc("/{userId}/organizations/{orgId}/domains/{domainId}/verify", domainHandler.VerifyDomain).Methods("POST")
+        users.HandleFunc("/{userId}/organizations/{orgId}/sso", ssoHandler.GetConnection).Methods("GET")
+        users.HandleFunc("/{userId}/organizations/{orgId}/sso", ssoHandler.SaveConnection).Methods("PUT")
+        users.HandleFunc("/{userId}/organizations/{orgId}/sso", ssoHandler.DeleteConnection).Methods("DELETE")
+        users.HandleFunc("/{userId}/organizations/{orgId}/scim/tokens", scimHandler.ListTokens).Methods("GET")
+        users.HandleFunc("/{userId}/organizations/{orgId}/scim/tokens", scimHandler.CreateToken).Methods("POST")
+        users.HandleFunc("/{userId}/organizations/{orgId}/scim/tokens/{tokenId}", scimHandler.RevokeToken).Methods("DELETE")
+        users.HandleFunc("/{userId}/organizations/{orgId}/scim/groups", scimHandler.ListGroupMappings).Methods("GET")
+        users.HandleFunc("/{userId}/organizations/{orgId}/scim/groups/{groupId}", scimHandler.MapGroup).Methods("PUT")
+
+        // Organization member routes
+        users.HandleFunc("/{userId}/organizations/{orgId}/members", memberHandler.ListMembers).Methods("GET")
+        users.HandleFunc("/{userId}/organizations/{orgId}/members/{memberId}", memberHandler.RemoveMember).Methods("DELETE")
+        users.HandleFunc("/{userId}/organizations/{orgId}/members/{memberId}/role", memberHandler.UpdateMemberRole).Methods("PUT")
+        users.HandleFunc("/{userId}/organizations/{orgId}/members/{memberId}/suspend", memberHandler.SuspendMember).Methods("POST")
+        users.HandleFunc("/{userId}/organizations/{orgId}/members/{memberId}/reactivate", memberHandler.ReactivateMember).Methods("POST")
+        users.HandleFunc("/{userId}/organizations/{orgId}/ownership-transfer", memberHandler.GetOwnershipTransfer).Methods("GET")
+        users.HandleFunc("/{userId}/organizations/{orgId}/ownership-transfer", memberHandler.CreateOwnershipTransfer).Methods("POST")
+        users.HandleFunc("/{userId}/organizations/{orgId}/ownership-transfer", memberHandler.CancelOwnershipTransfer).Methods("DELETE")
+        users.HandleFunc("/{userId}/organizations/{orgId}/ownership-transfer/accept", memberHandler.AcceptOwnershipTransfer).Methods("POST")
         
         // Organization invitation routes
         users.HandleFunc("/{userId}/organizations/{orgId}/invitations", organizationHandler.InviteToOrganization).Methods("POST")
+        users.HandleFunc("/{userId}/organizations/{orgId}/invitations/bulk", organizationHandler.BulkInvite).Methods("POST")
         users.HandleFunc("/{userId}/organizations/{orgId}/invitations", invitationHandler.GetOrganizationInvitations).Methods("GET")
         users.HandleFunc("/{userId}/organizations/{orgId}/invitations/{invitationId}", invitationHandler.CancelInvitation).Methods("DELETE")
         users.HandleFunc("/{userId}/organizations/{orgId}/invitations/{invitationId}/resend", invitationHandler.ResendInvitation).Methods("POST")
@@ -234,7 +509,33 @@ func (s *Server) setupRoutes() http.Handler {
         users.HandleFunc("/{userId}/organizations/{orgId}/projects", projectHandler.CreateProject).Methods("POST")
         users.HandleFunc("/{userId}/organizations/{orgId}/projects/{projectId}", projectHandler.GetProject).Methods("GET")
         users.HandleFunc("/{userId}/organizations/{orgId}/projects/{projectId}", projectHandler.UpdateProject).Methods("PUT")
+        users.HandleFunc("/{userId}/organizations/{orgId}/projects/{projectId}/members", projectHandler.GetProjectMembers).Methods("GET")
+        users.HandleFunc("/{userId}/organizations/{orgId}/projects/{projectId}/members/{memberId}", projectHandler.SetProjectMember).Methods("PUT")
+        users.HandleFunc("/{userId}/organizations/{orgId}/projects/{projectId}/members/{memberId}", projectHandler.RemoveProjectMember).Methods("DELETE")
+        users.HandleFunc("/{userId}/organizations/{orgId}/projects/{projectId}/connection", projectHandler.SetProjectConnection).Methods("PUT")
+        users.HandleFunc("/{userId}/organizations/{orgId}/projects/{projectId}/connection", projectHandler.DeleteProjectConnection).Methods("DELETE")
+        users.HandleFunc("/{userId}/organizations/{orgId}/projects/{projectId}/queries", projectHandler.GetSavedQueries).Methods("GET")
+        users.HandleFunc("/{userId}/organizations/{orgId}/projects/{projectId}/queries", projectHandler.CreateSavedQuery).Methods("POST")
+        users.HandleFunc("/{userId}/organizations/{orgId}/projects/{projectId}/queries/{queryId}", projectHandler.UpdateSavedQuery).Methods("PUT")
+        users.HandleFunc("/{userId}/organizations/{orgId}/projects/{projectId}/queries/{queryId}", projectHandler.DeleteSavedQuery).Methods("DELETE")
+        users.HandleFunc("/{userId}/organizations/{orgId}/projects/{projectId}/policies", projectHandler.GetAccessPolicies).Methods("GET")
+        users.HandleFunc("/{userId}/organizations/{orgId}/projects/{projectId}/policies", projectHandler.CreateAccessPolicy).Methods("POST")
+        users.HandleFunc("/{userId}/organizations/{orgId}/projects/{projectId}/policies/{policyId}", projectHandler.UpdateAccessPolicy).Methods("PUT")
+        users.HandleFunc("/{userId}/organizations/{orgId}/projects/{projectId}/policies/{policyId}", projectHandler.DeleteAccessPolicy).Methods("DELETE")
+        users.HandleFunc("/{userId}/organizations/{orgId}/projects/{projectId}/masking-rules", projectHandler.GetMaskingRules).Methods("GET")
+        users.HandleFunc("/{userId}/organizations/{orgId}/projects/{projectId}/masking-rules", projectHandler.CreateMaskingRule).Methods("POST")
+        users.HandleFunc("/{userId}/organizations/{orgId}/projects/{projectId}/masking-rules/suggestions", s.sqlPlaygroundHandler.GetMaskingSuggestions).Methods("GET")
+        users.HandleFunc("/{userId}/organizations/{orgId}/projects/{projectId}/masking-rules/{ruleId}", projectHandler.UpdateMaskingRule).Methods("PUT")
+        users.HandleFunc("/{userId}/organizations/{orgId}/projects/{projectId}/masking-rules/{ruleId}", projectHandler.DeleteMaskingRule).Methods("DELETE")
+        users.HandleFunc("/{userId}/organizations/{orgId}/projects/{projectId}/sql/execute", s.sqlPlaygroundHandler.ExecuteProjectQuery).Methods("POST")
+        users.HandleFunc("/{userId}/organizations/{orgId}/projects/{projectId}/sql/schema", s.sqlPlaygroundHandler.GetProjectSchema).Methods("GET")
+        users.HandleFunc("/{userId}/organizations/{orgId}/projects/{projectId}/sql/docs", schemaDocsHandler.GetProjectDataDictionary).Methods("GET")
+        users.HandleFunc("/{userId}/organizations/{orgId}/projects/{projectId}/sql/docs/descriptions", schemaDocsHandler.GetSchemaDescriptions).Methods("GET")
+        users.HandleFunc("/{userId}/organizations/{orgId}/projects/{projectId}/sql/docs/descriptions", schemaDocsHandler.UpsertSchemaDescription).Methods("PUT")
         
+        // Accepting an invitation needs a session for the invited account
+        api.HandleFunc("/invitations/{token}/accept", invitationHandler.AcceptInvitation).Methods("POST")
+
         // Project-specific invitation routes
         users.HandleFunc("/{userId}/organizations/{orgId}/projects/{projectId}/invitations", organizationHandler.InviteToProject).Methods("POST")
 
@@ -244,22 +545,72 @@ func (s *Server) setupRoutes() http.Handler {
         metrics.HandleFunc("", metricsHandler.GetMetrics).Methods("GET")
         metrics.HandleFunc("/summary", metricsHandler.GetMetricsSummary).Methods("GET")
 
-        // Public invitation routes (no auth required)
+        // Public invitation lookup (no auth required)
         publicAPI := r.PathPrefix("/api/v1").Subrouter()
         publicAPI.Use(middleware.RateLimitMiddleware(s.config.RateLimitRPS/2, s.config.RateLimitBurst/2))
         publicAPI.HandleFunc("/invitations/{token}", invitationHandler.GetInvitationDetails).Methods("GET")
-        publicAPI.HandleFunc("/invitations/{token}/accept", invitationHandler.AcceptInvitation).Methods("POST")
+
+        // Billing provider webhooks (authenticated by signature)
+        publicAPI.HandleFunc("/billing/webhook", billingHandler.HandleWebhook).Methods("POST")
+
+        // SSO sign-in (the browser is sent here before it has a session)
+        publicAPI.HandleFunc("/sso/login", ssoHandler.Login).Methods("GET")
+        publicAPI.HandleFunc("/sso/oidc/callback", ssoHandler.OIDCCallback).Methods("GET")
+        publicAPI.HandleFunc("/sso/saml/{orgId}/acs", ssoHandler.SAMLAssertionConsumer).Methods("POST")
+        publicAPI.HandleFunc("/sso/saml/{orgId}/metadata", ssoHandler.SAMLMetadata).Methods("GET")
+
+        // SCIM provisioning (authenticated by an organization SCIM token)
+        scimAPI := r.PathPrefix("/api/v1/scim/v2/organizations/{orgId}").Subrouter()
+        scimAPI.Use(middleware.RateLimitMiddleware(s.config.RateLimitRPS/2, s.config.RateLimitBurst/2))
+        scimAPI.Use(scimHandler.Authenticate)
+        scimAPI.HandleFunc("/ServiceProviderConfig", scimHandler.ServiceProviderConfig).Methods("GET")
+        scimAPI.HandleFunc("/ResourceTypes", scimHandler.ResourceTypes).Methods("GET")
+        scimAPI.HandleFunc("/Schemas", scimHandler.Schemas).Methods("GET")
+        scimAPI.HandleFunc("/Users", scimHandler.ListUsers).Methods("GET")
+        scimAPI.HandleFunc("/Users", scimHandler.CreateUser).Methods("POST")
+        scimAPI.HandleFunc("/Users/{userId}", scimHandler.GetUser).Methods("GET")
+        scimAPI.HandleFunc("/Users/{userId}", scimHandler.ReplaceUser).Methods("PUT")
+        scimAPI.HandleFunc("/Users/{userId}", scimHandler.PatchUser).Methods("PATCH")
+        scimAPI.HandleFunc("/Users/{userId}", scimHandler.DeleteUser).Methods("DELETE")
+        scimAPI.HandleFunc("/Groups", scimHandler.ListGroups).Methods("GET")
+        scimAPI.HandleFunc("/Groups", scimHandler.CreateGroup).Methods("POST")
+        scimAPI.HandleFunc("/Groups/{groupId}", scimHandler.GetGroup).Methods("GET")
+        scimAPI.HandleFunc("/Groups/{groupId}", scimHandler.ReplaceGroup).Methods("PUT")
+        scimAPI.HandleFunc("/Groups/{groupId}", scimHandler.PatchGroup).Methods("PATCH")
+        scimAPI.HandleFunc("/Groups/{groupId}", scimHandler.DeleteGroup).Methods("DELETE")
+
+        // Local identity provider for trying SSO without a real one
+        if s.config.SSOMockIdP {
+                mock, err := sso.NewMockIdP(s.config.APIURL + "/mock-idp")
+                if err != nil {
+                        log.Fatal().Err(err).Msg("Failed to start mock identity provider")
+                }
+                r.PathPrefix("/mock-idp").Handler(http.StripPrefix("/mock-idp", mock.Handler()))
+                log.Warn().Str("issuer", s.config.APIURL+"/mock-idp").Msg("Mock SSO identity provider enabled; it signs anyone in, never use it in production")
+        }
 
         // Public metrics (optional auth)
         publicMetrics := r.PathPrefix("/api/v1/public").Subrouter()
-        publicMetrics.Use(middleware.OptionalAuthMiddleware(s.jwtValidator))
+        publicMetrics.Use(middleware.OptionalAuthMiddleware(s.jwtValidator, s.sessions))
         publicMetrics.Use(middleware.RateLimitMiddleware(s.config.RateLimitRPS/2, s.config.RateLimitBurst/2))
         publicMetrics.HandleFunc("/metrics", metricsHandler.CreateMetric).Methods("POST")
 
         // Admin routes
         adminAPI := api.PathPrefix("/admin").Subrouter()
-        adminAPI.Use(middleware.RequireRole("admin"))
         adminAPI.HandleFunc("/users", userHandler.CreateUser).Methods("POST")
+        adminAPI.HandleFunc("/plans", planHandler.ListPlans).Methods("GET")
+        adminAPI.HandleFunc("/plans/{planId}", planHandler.SavePlan).Methods("PUT")
+        adminAPI.HandleFunc("/plans/{planId}", planHandler.DeletePlan).Methods("DELETE")
+        adminAPI.HandleFunc("/organizations/{orgId}/entitlements", planHandler.GetEntitlements).Methods("GET")
+        adminAPI.HandleFunc("/organizations/{orgId}/plan", planHandler.SetOrganizationPlan).Methods("PUT")
+        adminAPI.HandleFunc("/organizations/{orgId}/billing-anchor", planHandler.SetBillingAnchor).Methods("PUT")
+        adminAPI.HandleFunc("/organizations/{orgId}/plan-override", planHandler.SavePlanOverride).Methods("PUT")
+        adminAPI.HandleFunc("/organizations/{orgId}/plan-override", planHandler.DeletePlanOverride).Methods("DELETE")
+        adminAPI.HandleFunc("/audit-log", auditHandler.GetAuditLog).Methods("GET")
+        adminAPI.HandleFunc("/audit-log/export", auditHandler.ExportAuditLog).Methods("GET")
+        adminAPI.HandleFunc("/audit-log/verify", auditHandler.VerifyAuditLog).Methods("GET")
+        adminAPI.HandleFunc("/audit-log/checkpoints", auditHandler.GetCheckpoints).Methods("GET")
+        adminAPI.HandleFunc("/audit-log/checkpoints", auditHandler.CreateCheckpoints).Methods("POST")
 
         // Updated CORS configuration for Better Auth compatibility
         allowedOrigins := []string{"http://localhost:3000"}
@@ -270,8 +621,8 @@ func (s *Server) setupRoutes() http.Handler {
         corsHandler := gorillaHandlers.CORS(
                 gorillaHandlers.AllowedOrigins(allowedOrigins),
                 gorillaHandlers.AllowedMethods([]string{"GET", "POST", "PUT", "DELETE", "OPTIONS"}),
-                gorillaHandlers.AllowedHeaders([]string{"Content-Type", "Authorization", "Cookie"}),
-                gorillaHandlers.ExposedHeaders([]string{"X-Total-Count", "Set-Cookie"}),
+                gorillaHandlers.AllowedHeaders([]string{"Content-Type", "Authorization", "Cookie", "X-Request-ID"}),
+                gorillaHandlers.ExposedHeaders([]string{"X-Total-Count", "Set-Cookie", "X-Request-ID"}),
                 gorillaHandlers.AllowCredentials(), // This is crucial for Better Auth cookies
         )(r)
 
@@ -341,6 +692,22 @@ func (s *Server) waitForShutdown() error {
 }
 
 func (s *Server) cleanup() {
+	if s.stopMetering != nil {
+		s.stopMetering()
+	}
+
+	if s.stopCheckpoints != nil {
+		s.stopCheckpoints()
+	}
+
+	if s.stopOutbox != nil {
+		s.stopOutbox()
+	}
+
+	if s.stopKeyRefresh != nil {
+		s.stopKeyRefresh()
+	}
+
 	if s.dbConfigHandler != nil {
 		s.dbConfigHandler.CleanupUserConnections()
 	}
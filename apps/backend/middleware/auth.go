@@ -2,6 +2,7 @@ package middleware
 
 import (
 	"context"
+	"errors"
 	"net/http"
 	"strings"
 	"time"
@@ -14,24 +15,12 @@ type contextKey string
 
 const UserClaimsKey contextKey = "userClaims"
 
-type BetterAuthSession struct {
-	User struct {
-		ID    string `json:"id"`
-		Email string `json:"email"`
-		Name  string `json:"name"`
-		Role  string `json:"role"`
-	} `json:"user"`
-	Session struct {
-		ID        string    `json:"id"`
-		ExpiresAt time.Time `json:"expiresAt"`
-	} `json:"session"`
-}
-
-func AuthMiddleware(jwtValidator *auth.JWTValidator) func(http.Handler) http.Handler {
+func AuthMiddleware(jwtValidator *auth.JWTValidator, sessions *auth.SessionValidator, apiKeys *auth.APIKeyValidator) func(http.Handler) http.Handler {
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 			// First try to get session from Better Auth cookie
-			if claims := getBetterAuthSession(r); claims != nil {
+			claims, sessionErr := getBetterAuthSession(r, sessions)
+			if claims != nil {
 				ctx := context.WithValue(r.Context(), UserClaimsKey, claims)
 				log.Debug().
 					Str("user_id", claims.UserID).
@@ -45,6 +34,27 @@ func AuthMiddleware(jwtValidator *auth.JWTValidator) func(http.Handler) http.Han
 
 			// Fallback to JWT Bearer token for API compatibility
 			authHeader := r.Header.Get("Authorization")
+			if authHeader == "" && sessionErr != nil && !errors.Is(sessionErr, auth.ErrInvalidSession) {
+				log.Error().
+					Err(sessionErr).
+					Str("path", r.URL.Path).
+					Str("method", r.Method).
+					Msg("Failed to validate session")
+
+				http.Error(w, "Failed to validate session", http.StatusServiceUnavailable)
+				return
+			}
+			if authHeader == "" && sessionErr != nil {
+				log.Warn().
+					Err(sessionErr).
+					Str("path", r.URL.Path).
+					Str("method", r.Method).
+					Str("remote_addr", r.RemoteAddr).
+					Msg("Session validation failed")
+
+				http.Error(w, "Invalid or expired session", http.StatusUnauthorized)
+				return
+			}
 			if authHeader == "" {
 				log.Warn().
 					Str("path", r.URL.Path).
@@ -69,7 +79,12 @@ func AuthMiddleware(jwtValidator *auth.JWTValidator) func(http.Handler) http.Han
 			}
 			
 			token := parts[1]
-			claims, err := jwtValidator.ValidateToken(token)
+			if auth.IsAPIKey(token) {
+				authenticateAPIKey(w, r, next, apiKeys, token)
+				return
+			}
+
+			claims, err := jwtValidator.ValidateToken(r.Context(), token)
 			if err != nil {
 				log.Warn().
 					Err(err).
@@ -96,56 +111,69 @@ func AuthMiddleware(jwtValidator *auth.JWTValidator) func(http.Handler) http.Han
 	}
 }
 
-func getBetterAuthSession(r *http.Request) *auth.UserClaims {
-	// Try to get session from Better Auth cookies
-	sessionCookie, err := r.Cookie("better-auth.session_token")
+func authenticateAPIKey(w http.ResponseWriter, r *http.Request, next http.Handler, apiKeys *auth.APIKeyValidator, key string) {
+	if apiKeys == nil {
+		http.Error(w, "API keys are not accepted here", http.StatusUnauthorized)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
+	defer cancel()
+
+	claims, err := apiKeys.Validate(ctx, key, ClientIP(r))
+	if errors.Is(err, auth.ErrInvalidAPIKey) {
+		log.Warn().
+			Str("path", r.URL.Path).
+			Str("method", r.Method).
+			Str("remote_addr", r.RemoteAddr).
+			Msg("API key validation failed")
+
+		http.Error(w, "Invalid, revoked or expired API key", http.StatusUnauthorized)
+		return
+	}
 	if err != nil {
-		// Try alternative cookie names
-		for _, cookieName := range []string{"session_token", "better-auth.session", "authjs.session-token"} {
-			if cookie, err := r.Cookie(cookieName); err == nil {
-				sessionCookie = cookie
-				break
-			}
-		}
-		if sessionCookie == nil {
-			return nil
-		}
+		log.Error().Err(err).Str("path", r.URL.Path).Msg("Failed to validate API key")
+		http.Error(w, "Failed to validate API key", http.StatusServiceUnavailable)
+		return
 	}
 
-	sessionData := sessionCookie.Value
-	
-	// For Better Auth, the session cookie is typically a session ID
-	// We'll make a simple validation here and extract user info from the URL
-	// In a production environment, you'd validate this session ID with Better Auth
-	
-	// Extract user ID from the request URL path
-	// URL format: /api/v1/users/{user_id}/...
-	path := r.URL.Path
-	if parts := strings.Split(path, "/"); len(parts) >= 5 && parts[1] == "api" && parts[2] == "v1" && parts[3] == "users" {
-		userID := parts[4]
-		
-		// Basic session validation - check if session cookie exists and is not empty
-		if sessionData != "" && len(sessionData) > 10 {
-			log.Debug().
-				Str("user_id", userID).
-				Str("session_cookie", "present").
-				Msg("Better Auth session found")
-			
-			return &auth.UserClaims{
-				UserID: userID,
-				Role:   "user", // Default role, could be enhanced to fetch from Better Auth
-			}
-		}
+	log.Debug().
+		Str("user_id", claims.UserID).
+		Str("api_key_id", claims.APIKeyID).
+		Str("path", r.URL.Path).
+		Str("method", r.Method).
+		Msg("API key authentication successful")
+
+	next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), UserClaimsKey, claims)))
+}
+
+// getBetterAuthSession validates the request's Better Auth session cookie.
+// It returns nil claims and a nil error when there is no cookie.
+func getBetterAuthSession(r *http.Request, sessions *auth.SessionValidator) (*auth.UserClaims, error) {
+	cookie := auth.SessionCookie(r)
+	if cookie == nil || sessions == nil {
+		return nil, nil
 	}
 
-	return nil
+	ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
+	defer cancel()
+
+	session, err := sessions.Validate(ctx, cookie.Value)
+	if err != nil {
+		return nil, err
+	}
+	// Service accounts act only through API keys
+	if auth.IsServiceAccount(session.UserID) {
+		return nil, auth.ErrInvalidSession
+	}
+	return session.Claims(), nil
 }
 
-func OptionalAuthMiddleware(jwtValidator *auth.JWTValidator) func(http.Handler) http.Handler {
+func OptionalAuthMiddleware(jwtValidator *auth.JWTValidator, sessions *auth.SessionValidator) func(http.Handler) http.Handler {
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 			// Try Better Auth session first
-			if claims := getBetterAuthSession(r); claims != nil {
+			if claims, _ := getBetterAuthSession(r, sessions); claims != nil {
 				ctx := context.WithValue(r.Context(), UserClaimsKey, claims)
 				r = r.WithContext(ctx)
 			} else {
@@ -155,7 +183,7 @@ func OptionalAuthMiddleware(jwtValidator *auth.JWTValidator) func(http.Handler)
 					parts := strings.SplitN(authHeader, " ", 2)
 					if len(parts) == 2 && strings.ToLower(parts[0]) == "bearer" {
 						token := parts[1]
-						if claims, err := jwtValidator.ValidateToken(token); err == nil {
+						if claims, err := jwtValidator.ValidateToken(r.Context(), token); err == nil {
 							ctx := context.WithValue(r.Context(), UserClaimsKey, claims)
 							r = r.WithContext(ctx)
 						}
@@ -24,6 +24,11 @@ func (rw *responseWriter) Write(b []byte) (int, error) {
 	return n, err
 }
 
+// Unwrap lets http.ResponseController reach the underlying writer.
+func (rw *responseWriter) Unwrap() http.ResponseWriter {
+	return rw.ResponseWriter
+}
+
 func LoggingMiddleware() func(http.Handler) http.Handler {
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -43,6 +48,7 @@ func LoggingMiddleware() func(http.Handler) http.Handler {
 				Str("path", r.URL.Path).
 				Str("query", r.URL.RawQuery).
 				Str("remote_addr", r.RemoteAddr).
+				Str("request_id", GetRequestID(r.Context())).
 				Str("user_agent", r.UserAgent()).
 				Int("status_code", rw.statusCode).
 				Int("bytes_written", rw.bytesWritten).
@@ -59,6 +65,7 @@ func LoggingMiddleware() func(http.Handler) http.Handler {
 					Str("path", r.URL.Path).
 					Str("query", r.URL.RawQuery).
 					Str("remote_addr", r.RemoteAddr).
+					Str("request_id", GetRequestID(r.Context())).
 					Int("status_code", rw.statusCode).
 					Dur("duration", duration)
 			}
@@ -1,7 +1,9 @@
 package middleware
 
 import (
+	"net"
 	"net/http"
+	"strings"
 	"sync"
 	"time"
 
@@ -77,7 +79,7 @@ func RateLimitMiddleware(rps int, burst int) func(http.Handler) http.Handler {
 	
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-			ip := getClientIP(r)
+			ip := ClientIP(r)
 			limiter := limiter.GetLimiterForIP(ip)
 			
 			if !limiter.Allow() {
@@ -156,10 +158,12 @@ func UserRateLimitMiddleware(rps int, burst int) func(http.Handler) http.Handler
 	}
 }
 
-func getClientIP(r *http.Request) string {
+// ClientIP returns the address the request came from, preferring the first
+// hop of X-Forwarded-For when a proxy set it.
+func ClientIP(r *http.Request) string {
 	xForwardedFor := r.Header.Get("X-Forwarded-For")
 	if xForwardedFor != "" {
-		return xForwardedFor
+		return strings.TrimSpace(strings.Split(xForwardedFor, ",")[0])
 	}
 	
 	xRealIP := r.Header.Get("X-Real-IP")
@@ -167,5 +171,8 @@ func getClientIP(r *http.Request) string {
 		return xRealIP
 	}
 	
+	if host, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+		return host
+	}
 	return r.RemoteAddr
 } 
\ No newline at end of file
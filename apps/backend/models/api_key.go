@@ -0,0 +1,34 @@
+package models
+
+import (
+	"time"
+)
+
+// APIKey is a token for calling the API without a browser session. Only its
+// hash is stored; Key is set once, in the response that creates it.
+type APIKey struct {
+	ID             string     `json:"id" db:"id"`
+	UserID         string     `json:"user_id" db:"user_id"`
+	OrganizationID *string    `json:"organization_id" db:"organization_id"` // the only organization the key can reach, if set
+	Name           string     `json:"name" db:"name"`
+	Prefix         string     `json:"prefix" db:"prefix"` // first characters of the key, to tell keys apart
+	Scopes         []string   `json:"scopes" db:"scopes"`
+	CreatedBy      *string    `json:"created_by" db:"created_by"`
+	CreatedAt      time.Time  `json:"created_at" db:"created_at"`
+	ExpiresAt      *time.Time `json:"expires_at" db:"expires_at"`
+	LastUsedAt     *time.Time `json:"last_used_at" db:"last_used_at"`
+	LastUsedIP     *string    `json:"last_used_ip" db:"last_used_ip"`
+	RevokedAt      *time.Time `json:"revoked_at" db:"revoked_at"`
+	RevokedBy      *string    `json:"revoked_by" db:"revoked_by"`
+	Key            string     `json:"key,omitempty" db:"-"`
+}
+
+type CreateAPIKeyRequest struct {
+	Name           string   `json:"name" validate:"required,max=100"`
+	Scopes         []string `json:"scopes" validate:"required"`
+	ExpiresInDays  int      `json:"expires_in_days,omitempty"` // 90 when omitted, at most 365
+	OrganizationID *string  `json:"organization_id,omitempty"`
+	// ServiceAccountID mints an organization key for one of its service
+	// accounts rather than the calling admin.
+	ServiceAccountID *string `json:"service_account_id,omitempty"`
+}
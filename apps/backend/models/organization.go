@@ -26,6 +26,21 @@ type OrganizationMember struct {
 	JoinedAt       time.Time  `json:"joined_at" db:"joined_at"`
 	InvitedAt      time.Time  `json:"invited_at" db:"invited_at"`
 	InvitedBy      string     `json:"invited_by" db:"invited_by"`
+	ProjectAccess  string     `json:"project_access" db:"project_access"` // all, specific
+	ServiceAccount bool       `json:"service_account" db:"-"`             // a non-human member acting through API keys
+}
+
+// OwnershipTransfer is an owner's offer to hand an organization to another
+// member. It takes effect when the recipient accepts it.
+type OwnershipTransfer struct {
+	ID             string     `json:"id" db:"id"`
+	OrganizationID string     `json:"organization_id" db:"organization_id"`
+	FromUserID     string     `json:"from_user_id" db:"from_user_id"`
+	ToUserID       string     `json:"to_user_id" db:"to_user_id"`
+	Status         string     `json:"status" db:"status"`
+	CreatedAt      time.Time  `json:"created_at" db:"created_at"`
+	ExpiresAt      time.Time  `json:"expires_at" db:"expires_at"`
+	RespondedAt    *time.Time `json:"responded_at" db:"responded_at"`
 }
 
 type OrganizationInvitation struct {
@@ -41,6 +56,7 @@ type OrganizationInvitation struct {
 	ProjectAccessType   *string    `json:"project_access_type" db:"project_access_type"`
 	SpecificProjects    *string    `json:"specific_projects" db:"specific_projects"` // JSON array of project IDs
 	Message             *string    `json:"message" db:"message"`
+	ProjectRole         *string    `json:"project_role" db:"project_role"` // role on each of SpecificProjects
 }
 
 type Project struct {
@@ -54,11 +70,15 @@ type Project struct {
 	DatabaseConnected bool       `json:"database_connected" db:"database_connected"`
 	DatabaseType      *string    `json:"database_type" db:"database_type"`
 	IsPublic          bool       `json:"is_public" db:"is_public"`
+	ReadOnly          bool       `json:"read_only" db:"read_only"`
+	ConnectionUserID  *string    `json:"connection_user_id" db:"connection_user_id"` // member whose database the project queries
+	Role              string     `json:"role,omitempty" db:"-"`                       // the requesting user's project role
 }
 
 type OrganizationUsage struct {
 	OrganizationID              string    `json:"organization_id"`
 	Plan                        string    `json:"plan"`
+	BillingCycleStart          time.Time `json:"billing_cycle_start"`
 	BillingCycleEnd            time.Time `json:"billing_cycle_end"`
 	AIQueriesUsed              int       `json:"ai_queries_used"`
 	AIQueriesLimit             int       `json:"ai_queries_limit"`
@@ -69,6 +89,10 @@ type OrganizationUsage struct {
 	DatabaseConnections        int       `json:"database_connections"`
 	DatabaseConnectionsLimit   int       `json:"database_connections_limit"`
 	QueryHistoryLimitDays      int       `json:"query_history_limit_days"`
+	QueryExecutions            int64     `json:"query_executions"`
+	RowsExported               int64     `json:"rows_exported"`
+	ConnectionHours            float64   `json:"connection_hours"`
+	Features                   map[string]bool `json:"features"`
 }
 
 // Request/Response types
@@ -89,6 +113,7 @@ type InviteToOrganizationRequest struct {
 	Message             *string  `json:"message,omitempty"`
 	ProjectAccessType   *string  `json:"project_access_type,omitempty" validate:"omitempty,oneof=all specific"`
 	SpecificProjects    []string `json:"specific_projects,omitempty"`
+	ProjectRole         *string  `json:"project_role,omitempty" validate:"omitempty,oneof=viewer editor admin"`
 }
 
 type CreateProjectRequest struct {
@@ -104,7 +129,17 @@ type UpdateProjectRequest struct {
 }
 
 type AcceptInvitationRequest struct {
-	Token string `json:"token" validate:"required"`
+	// OverrideEmail lets a platform admin accept an invitation sent to a
+	// different address than their account's.
+	OverrideEmail bool `json:"override_email"`
+}
+
+type UpdateMemberRoleRequest struct {
+	Role string `json:"role" validate:"required,oneof=owner admin member"`
+}
+
+type TransferOwnershipRequest struct {
+	MemberID string `json:"member_id" validate:"required"`
 }
 
 // Response types with nested data
@@ -123,6 +158,17 @@ type OrganizationInvitationWithDetails struct {
 	Inviter      *InviterDetails `json:"inviter,omitempty"`
 	Organization *Organization `json:"organization,omitempty"`
 	Projects     []Project     `json:"projects,omitempty"`
+	Delivery     *InvitationDelivery `json:"delivery,omitempty"`
+}
+
+// InvitationDelivery is the state of the latest email sent for an
+// invitation.
+type InvitationDelivery struct {
+	Status    string     `json:"status"` // pending, sent, failed or bounced
+	Attempts  int        `json:"attempts"`
+	LastError *string    `json:"last_error,omitempty"`
+	SentAt    *time.Time `json:"sent_at,omitempty"`
+	UpdatedAt time.Time  `json:"updated_at"`
 }
 
 type ProjectWithOrganization struct {
@@ -0,0 +1,79 @@
+package models
+
+import (
+	"time"
+)
+
+// OrganizationDomain is an email domain an organization claims. Once
+// verified through DNS, users with a verified email at the domain can join
+// the organization by themselves when AutoJoin is on.
+type OrganizationDomain struct {
+	ID                string     `json:"id" db:"id"`
+	OrganizationID    string     `json:"organization_id" db:"organization_id"`
+	Domain            string     `json:"domain" db:"domain"`
+	VerificationToken string     `json:"verification_token" db:"verification_token"`
+	VerifiedAt        *time.Time `json:"verified_at" db:"verified_at"`
+	AutoJoin          bool       `json:"auto_join" db:"auto_join"`
+	DefaultRole       string     `json:"default_role" db:"default_role"`
+	CreatedBy         *string    `json:"created_by" db:"created_by"`
+	CreatedAt         time.Time  `json:"created_at" db:"created_at"`
+	UpdatedAt         time.Time  `json:"updated_at" db:"updated_at"`
+	// DNSRecordName and DNSRecordValue are the TXT record that verifies the
+	// domain.
+	DNSRecordName  string `json:"dns_record_name" db:"-"`
+	DNSRecordValue string `json:"dns_record_value" db:"-"`
+}
+
+type CreateOrganizationDomainRequest struct {
+	Domain      string `json:"domain" validate:"required"`
+	AutoJoin    bool   `json:"auto_join"`
+	DefaultRole string `json:"default_role,omitempty" validate:"omitempty,oneof=admin member"` // member when omitted
+}
+
+type UpdateOrganizationDomainRequest struct {
+	AutoJoin    *bool   `json:"auto_join,omitempty"`
+	DefaultRole *string `json:"default_role,omitempty" validate:"omitempty,oneof=admin member"`
+}
+
+// JoinableOrganization is an organization a user can join through a verified
+// domain of their email.
+type JoinableOrganization struct {
+	OrganizationID string `json:"organization_id"`
+	Name           string `json:"name"`
+	Slug           string `json:"slug"`
+	Domain         string `json:"domain"`
+	Role           string `json:"role"`
+}
+
+type AutoJoinRequest struct {
+	OrganizationID string `json:"organization_id,omitempty"` // every joinable organization when empty
+}
+
+// AutoJoinResult is the outcome of joining one organization.
+type AutoJoinResult struct {
+	OrganizationID string `json:"organization_id"`
+	Status         string `json:"status"` // joined or error
+	Role           string `json:"role,omitempty"`
+	MemberID       string `json:"member_id,omitempty"`
+	Error          string `json:"error,omitempty"`
+}
+
+// BulkInviteRequest invites several people at once. Rows without a role or
+// project settings take the request's.
+type BulkInviteRequest struct {
+	Invitations       []InviteToOrganizationRequest `json:"invitations"`
+	Role              string                        `json:"role,omitempty" validate:"omitempty,oneof=admin member"`
+	Message           *string                       `json:"message,omitempty"`
+	ProjectAccessType *string                       `json:"project_access_type,omitempty" validate:"omitempty,oneof=all specific"`
+	SpecificProjects  []string                      `json:"specific_projects,omitempty"`
+	ProjectRole       *string                       `json:"project_role,omitempty" validate:"omitempty,oneof=viewer editor admin"`
+}
+
+// BulkInviteResult is the outcome of one row of a bulk invitation.
+type BulkInviteResult struct {
+	Row          int    `json:"row"` // 1-based; CSV rows count the header
+	Email        string `json:"email"`
+	Status       string `json:"status"` // invited, skipped or error
+	InvitationID string `json:"invitation_id,omitempty"`
+	Error        string `json:"error,omitempty"`
+}
@@ -0,0 +1,99 @@
+package models
+
+import (
+	"time"
+)
+
+// Project roles, weakest first. Each includes everything the ones before it
+// allow.
+const (
+	ProjectRoleViewer = "viewer" // read the schema and run read-only queries
+	ProjectRoleEditor = "editor" // manage the project's saved queries
+	ProjectRoleAdmin  = "admin"  // manage members, settings and the connection
+)
+
+// ProjectMember is an organization member's role on one project. Inherited
+// roles come from the member's organization role or all-projects access
+// rather than a project_members grant, and have no grant fields.
+type ProjectMember struct {
+	ProjectID string     `json:"project_id" db:"project_id"`
+	MemberID  string     `json:"member_id" db:"member_id"` // organization_members.id
+	UserID    string     `json:"user_id" db:"user_id"`
+	Email     string     `json:"email" db:"email"`
+	Role      string     `json:"role" db:"role"`
+	Inherited bool       `json:"inherited" db:"-"`
+	AddedBy   *string    `json:"added_by,omitempty" db:"added_by"`
+	CreatedAt *time.Time `json:"created_at,omitempty" db:"created_at"`
+	UpdatedAt *time.Time `json:"updated_at,omitempty" db:"updated_at"`
+}
+
+// SavedQuery is a named query shared with everyone on a project.
+type SavedQuery struct {
+	ID          string    `json:"id" db:"id"`
+	ProjectID   string    `json:"project_id" db:"project_id"`
+	Name        string    `json:"name" db:"name"`
+	SQL         string    `json:"sql" db:"sql"`
+	Description *string   `json:"description" db:"description"`
+	CreatedBy   *string   `json:"created_by" db:"created_by"`
+	CreatedAt   time.Time `json:"created_at" db:"created_at"`
+	UpdatedAt   time.Time `json:"updated_at" db:"updated_at"`
+}
+
+type SetProjectMemberRequest struct {
+	Role string `json:"role" validate:"required,oneof=viewer editor admin"`
+}
+
+type SavedQueryRequest struct {
+	Name        string  `json:"name" validate:"required,min=1,max=200"`
+	SQL         string  `json:"sql" validate:"required"`
+	Description *string `json:"description,omitempty"`
+}
+
+// AccessPolicy denies or masks part of a project's database for some
+// project roles. An empty Table covers the whole schema and an empty Column
+// the whole table.
+type AccessPolicy struct {
+	ID        string    `json:"id" db:"id"`
+	ProjectID string    `json:"project_id" db:"project_id"`
+	Schema    string    `json:"schema" db:"schema_name"`
+	Table     string    `json:"table" db:"table_name"`
+	Column    string    `json:"column" db:"column_name"`
+	Action    string    `json:"action" db:"action"` // deny, mask
+	Roles     []string  `json:"roles" db:"roles"`
+	CreatedBy *string   `json:"created_by" db:"created_by"`
+	CreatedAt time.Time `json:"created_at" db:"created_at"`
+	UpdatedAt time.Time `json:"updated_at" db:"updated_at"`
+}
+
+type AccessPolicyRequest struct {
+	Schema string   `json:"schema" validate:"required"`
+	Table  string   `json:"table,omitempty"`
+	Column string   `json:"column,omitempty"`
+	Action string   `json:"action" validate:"required,oneof=deny mask"`
+	Roles  []string `json:"roles,omitempty"`
+}
+
+type MaskingRule struct {
+	ID        string    `json:"id" db:"id"`
+	ProjectID string    `json:"project_id" db:"project_id"`
+	Schema    string    `json:"schema,omitempty" db:"schema_name"`
+	Table     string    `json:"table,omitempty" db:"table_name"`
+	Column    string    `json:"column,omitempty" db:"column_name"`
+	Pattern   string    `json:"pattern,omitempty" db:"pattern"`
+	Strategy  string    `json:"strategy" db:"strategy"` // redact, partial, hash, null
+	KeepChars int       `json:"keep_chars" db:"keep_chars"`
+	Roles     []string  `json:"roles" db:"roles"`
+	CreatedBy *string   `json:"created_by" db:"created_by"`
+	CreatedAt time.Time `json:"created_at" db:"created_at"`
+	UpdatedAt time.Time `json:"updated_at" db:"updated_at"`
+}
+
+type MaskingRuleRequest struct {
+	Schema    string   `json:"schema,omitempty"`
+	Table     string   `json:"table,omitempty"`
+	Column    string   `json:"column,omitempty"`
+	Pattern   string   `json:"pattern,omitempty"`
+	Strategy  string   `json:"strategy" validate:"required,oneof=redact partial hash null"`
+	KeepChars *int     `json:"keep_chars,omitempty"`
+	Roles     []string `json:"roles,omitempty"`
+}
@@ -0,0 +1,26 @@
+package models
+
+import (
+	"time"
+)
+
+// SchemaDescription is a team-maintained description of a table or column in a
+// project's database. An empty ColumnName describes the table itself.
+type SchemaDescription struct {
+	ID          int       `json:"id" db:"id"`
+	ProjectID   string    `json:"project_id" db:"project_id"`
+	SchemaName  string    `json:"schema" db:"schema_name"`
+	TableName   string    `json:"table" db:"table_name"`
+	ColumnName  string    `json:"column,omitempty" db:"column_name"`
+	Description string    `json:"description" db:"description"`
+	UpdatedBy   *string   `json:"updated_by" db:"updated_by"`
+	CreatedAt   time.Time `json:"created_at" db:"created_at"`
+	UpdatedAt   time.Time `json:"updated_at" db:"updated_at"`
+}
+
+type UpsertSchemaDescriptionRequest struct {
+	Schema      string `json:"schema" validate:"required"`
+	Table       string `json:"table" validate:"required"`
+	Column      string `json:"column,omitempty"`
+	Description string `json:"description"`
+}
@@ -0,0 +1,49 @@
+package models
+
+import (
+	"time"
+)
+
+// SCIMToken authenticates an organization's identity provider on its SCIM
+// endpoints. Only its hash is stored; Token is set once, in the response
+// that creates it.
+type SCIMToken struct {
+	ID             string     `json:"id" db:"id"`
+	OrganizationID string     `json:"organization_id" db:"organization_id"`
+	Name           string     `json:"name" db:"name"`
+	Prefix         string     `json:"prefix" db:"prefix"` // first characters of the token, to tell tokens apart
+	CreatedBy      *string    `json:"created_by" db:"created_by"`
+	CreatedAt      time.Time  `json:"created_at" db:"created_at"`
+	LastUsedAt     *time.Time `json:"last_used_at" db:"last_used_at"`
+	RevokedAt      *time.Time `json:"revoked_at" db:"revoked_at"`
+	RevokedBy      *string    `json:"revoked_by" db:"revoked_by"`
+	Token          string     `json:"token,omitempty" db:"-"`
+	BaseURL        string     `json:"base_url" db:"-"` // the SCIM base URL to configure at the IdP
+}
+
+type CreateSCIMTokenRequest struct {
+	Name string `json:"name" validate:"required,max=100"`
+}
+
+// SCIMGroup is a group the IdP pushed over SCIM and what it grants its
+// members: an organization role, a role on one project, or both.
+type SCIMGroup struct {
+	ID             string    `json:"id" db:"id"`
+	OrganizationID string    `json:"organization_id" db:"organization_id"`
+	DisplayName    string    `json:"display_name" db:"display_name"`
+	ExternalID     *string   `json:"external_id" db:"external_id"`
+	Role           *string   `json:"role" db:"role"`
+	ProjectID      *string   `json:"project_id" db:"project_id"`
+	ProjectRole    *string   `json:"project_role" db:"project_role"`
+	MemberCount    int       `json:"member_count" db:"member_count"`
+	CreatedAt      time.Time `json:"created_at" db:"created_at"`
+	UpdatedAt      time.Time `json:"updated_at" db:"updated_at"`
+}
+
+// MapSCIMGroupRequest sets what a SCIM group grants. Empty or omitted
+// fields grant nothing.
+type MapSCIMGroupRequest struct {
+	Role        *string `json:"role,omitempty" validate:"omitempty,oneof=admin member"`
+	ProjectID   *string `json:"project_id,omitempty"`
+	ProjectRole *string `json:"project_role,omitempty" validate:"omitempty,oneof=viewer editor admin"`
+}
@@ -0,0 +1,37 @@
+package models
+
+import (
+	"time"
+)
+
+// ServiceAccount is a non-human member of an organization, such as a CI job
+// or an integration. It holds a role and project access like a person, acts
+// only through API keys and cannot sign in.
+type ServiceAccount struct {
+	ID             string    `json:"id" db:"user_id"` // its user ID, starting with "sa_"
+	OrganizationID string    `json:"organization_id" db:"organization_id"`
+	Name           string    `json:"name" db:"name"`
+	Description    *string   `json:"description" db:"description"`
+	Email          string    `json:"email" db:"email"` // synthetic, never mailed
+	MemberID       string    `json:"member_id" db:"member_id"`
+	Role           string    `json:"role" db:"role"`
+	Status         string    `json:"status" db:"status"`
+	ProjectAccess  string    `json:"project_access" db:"project_access"`
+	CreatedBy      *string   `json:"created_by" db:"created_by"`
+	CreatedAt      time.Time `json:"created_at" db:"created_at"`
+	UpdatedAt      time.Time `json:"updated_at" db:"updated_at"`
+}
+
+type CreateServiceAccountRequest struct {
+	Name              string   `json:"name" validate:"required,max=100"`
+	Description       *string  `json:"description,omitempty"`
+	Role              string   `json:"role,omitempty" validate:"omitempty,oneof=admin member"` // member when omitted
+	ProjectAccessType *string  `json:"project_access_type,omitempty" validate:"omitempty,oneof=all specific"`
+	SpecificProjects  []string `json:"specific_projects,omitempty"`
+	ProjectRole       *string  `json:"project_role,omitempty" validate:"omitempty,oneof=viewer editor admin"`
+}
+
+type UpdateServiceAccountRequest struct {
+	Name        *string `json:"name,omitempty" validate:"omitempty,max=100"`
+	Description *string `json:"description,omitempty"`
+}
@@ -0,0 +1,73 @@
+package models
+
+import (
+	"time"
+)
+
+// SSOConnection is an organization's single sign-on connection to its
+// identity provider. Only the settings of its protocol are set, and the OIDC
+// client secret is never returned.
+type SSOConnection struct {
+	ID             string `json:"id" db:"id"`
+	OrganizationID string `json:"organization_id" db:"organization_id"`
+	Protocol       string `json:"protocol" db:"protocol"`
+	Enabled        bool   `json:"enabled" db:"enabled"`
+	// Enforced requires members other than owners to sign in through the
+	// connection.
+	Enforced bool `json:"enforced" db:"enforced"`
+	// Entitled is false while the organization's plan does not include SSO;
+	// the connection then neither signs anyone in nor is enforced.
+	Entitled        bool             `json:"entitled" db:"entitled"`
+	JITProvisioning bool             `json:"jit_provisioning" db:"jit_provisioning"`
+	DefaultRole     *string          `json:"default_role" db:"default_role"`
+	GroupsAttribute string           `json:"groups_attribute" db:"groups_attribute"`
+	RoleMappings    []SSORoleMapping `json:"role_mappings" db:"role_mappings"`
+
+	OIDCIssuer          string `json:"oidc_issuer,omitempty" db:"oidc_issuer"`
+	OIDCClientID        string `json:"oidc_client_id,omitempty" db:"oidc_client_id"`
+	OIDCClientSecretSet bool   `json:"oidc_client_secret_set,omitempty" db:"-"`
+	OIDCScopes          string `json:"oidc_scopes,omitempty" db:"oidc_scopes"`
+
+	SAMLIdPEntityID    string `json:"saml_idp_entity_id,omitempty" db:"saml_idp_entity_id"`
+	SAMLIdPSSOURL      string `json:"saml_idp_sso_url,omitempty" db:"saml_idp_sso_url"`
+	SAMLIdPCertificate string `json:"saml_idp_certificate,omitempty" db:"saml_idp_certificate"`
+
+	CreatedBy *string   `json:"created_by" db:"created_by"`
+	CreatedAt time.Time `json:"created_at" db:"created_at"`
+	UpdatedAt time.Time `json:"updated_at" db:"updated_at"`
+
+	// What to configure at the IdP, and where members start signing in.
+	LoginURL        string `json:"login_url" db:"-"`
+	OIDCRedirectURL string `json:"oidc_redirect_url,omitempty" db:"-"`
+	SAMLEntityID    string `json:"saml_entity_id,omitempty" db:"-"`
+	SAMLACSURL      string `json:"saml_acs_url,omitempty" db:"-"`
+	SAMLMetadataURL string `json:"saml_metadata_url,omitempty" db:"-"`
+}
+
+// SSORoleMapping gives members of an IdP group an organization role. When
+// several groups match, the highest role wins.
+type SSORoleMapping struct {
+	Group string `json:"group" validate:"required"`
+	Role  string `json:"role" validate:"required,oneof=admin member"`
+}
+
+// SaveSSOConnectionRequest replaces an organization's SSO connection. The
+// OIDC client secret is kept when left out.
+type SaveSSOConnectionRequest struct {
+	Protocol        string           `json:"protocol" validate:"required,oneof=oidc saml"`
+	Enabled         bool             `json:"enabled"`
+	Enforced        bool             `json:"enforced"`
+	JITProvisioning *bool            `json:"jit_provisioning,omitempty"` // true when omitted
+	DefaultRole     *string          `json:"default_role,omitempty" validate:"omitempty,oneof=admin member"`
+	GroupsAttribute string           `json:"groups_attribute,omitempty"` // groups when omitted
+	RoleMappings    []SSORoleMapping `json:"role_mappings,omitempty"`
+
+	OIDCIssuer       string `json:"oidc_issuer,omitempty"`
+	OIDCClientID     string `json:"oidc_client_id,omitempty"`
+	OIDCClientSecret string `json:"oidc_client_secret,omitempty"`
+	OIDCScopes       string `json:"oidc_scopes,omitempty"`
+
+	SAMLIdPEntityID    string `json:"saml_idp_entity_id,omitempty"`
+	SAMLIdPSSOURL      string `json:"saml_idp_sso_url,omitempty"`
+	SAMLIdPCertificate string `json:"saml_idp_certificate,omitempty"`
+}
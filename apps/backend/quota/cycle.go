@@ -0,0 +1,58 @@
+package quota
+
+import "time"
+
+// Cycle is one billing period, [Start, End).
+type Cycle struct {
+	Start time.Time `json:"start"`
+	End   time.Time `json:"end"`
+}
+
+// calendarAnchor gives users without an organization calendar-month cycles.
+var calendarAnchor = time.Date(2000, time.January, 1, 0, 0, 0, 0, time.UTC)
+
+// CycleAt returns the monthly cycle anchored at anchor that contains t.
+// Anchors late in the month fall on the last day of shorter months, so an
+// anchor of January 31 gives cycles starting February 28 (or 29), March 31
+// and so on.
+func CycleAt(anchor, t time.Time) Cycle {
+	return cycleAt(anchor, cycleIndex(anchor, t))
+}
+
+// RecentCycles returns up to count cycles ending with the one containing
+// now, most recent first. Cycles before the anchor are not included.
+func RecentCycles(anchor, now time.Time, count int) []Cycle {
+	current := cycleIndex(anchor, now)
+	cycles := make([]Cycle, 0, count)
+	for n := current; n >= 0 && len(cycles) < count; n-- {
+		cycles = append(cycles, cycleAt(anchor, n))
+	}
+	return cycles
+}
+
+// cycleIndex is the number of whole cycles between anchor and t.
+func cycleIndex(anchor, t time.Time) int {
+	anchor, t = anchor.UTC(), t.UTC()
+	n := (t.Year()-anchor.Year())*12 + int(t.Month()-anchor.Month())
+	if addMonths(anchor, n).After(t) {
+		n--
+	}
+	return n
+}
+
+func cycleAt(anchor time.Time, n int) Cycle {
+	return Cycle{Start: addMonths(anchor, n), End: addMonths(anchor, n+1)}
+}
+
+// addMonths moves anchor n months, clamping the day to the target month.
+func addMonths(anchor time.Time, n int) time.Time {
+	anchor = anchor.UTC()
+	months := anchor.Year()*12 + int(anchor.Month()) - 1 + n
+	year, month := months/12, time.Month(months%12+1)
+
+	day := anchor.Day()
+	if last := time.Date(year, month+1, 0, 0, 0, 0, 0, time.UTC).Day(); day > last {
+		day = last
+	}
+	return time.Date(year, month, day, anchor.Hour(), anchor.Minute(), anchor.Second(), 0, time.UTC)
+}
@@ -0,0 +1,102 @@
+package quota
+
+import (
+	"testing"
+	"time"
+)
+
+func date(year int, month time.Month, day int) time.Time {
+	return time.Date(year, month, day, 0, 0, 0, 0, time.UTC)
+}
+
+func TestAddMonths(t *testing.T) {
+	tests := []struct {
+		name   string
+		anchor time.Time
+		n      int
+		want   time.Time
+	}{
+		{"same month", date(2026, 1, 15), 0, date(2026, 1, 15)},
+		{"next month", date(2026, 1, 15), 1, date(2026, 2, 15)},
+		{"across the year", date(2025, 11, 15), 3, date(2026, 2, 15)},
+		{"backwards across the year", date(2026, 2, 15), -3, date(2025, 11, 15)},
+		{"31st into February", date(2026, 1, 31), 1, date(2026, 2, 28)},
+		{"31st into a leap February", date(2028, 1, 31), 1, date(2028, 2, 29)},
+		{"31st into a 30-day month", date(2026, 1, 31), 3, date(2026, 4, 30)},
+		{"31st keeps its day where it can", date(2026, 1, 31), 2, date(2026, 3, 31)},
+		{"29 February in a common year", date(2028, 2, 29), 12, date(2029, 2, 28)},
+		{"time of day kept", time.Date(2026, 1, 31, 13, 45, 10, 500, time.UTC), 1, time.Date(2026, 2, 28, 13, 45, 10, 0, time.UTC)},
+		{"converted to UTC", time.Date(2026, 2, 1, 0, 30, 0, 0, time.FixedZone("CET", 3600)), 1, time.Date(2026, 2, 28, 23, 30, 0, 0, time.UTC)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := addMonths(tt.anchor, tt.n); !got.Equal(tt.want) {
+				t.Errorf("addMonths(%s, %d) = %s, want %s", tt.anchor, tt.n, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCycleAt(t *testing.T) {
+	anchor := date(2026, 1, 31)
+
+	tests := []struct {
+		name       string
+		anchor     time.Time
+		at         time.Time
+		index      int
+		start, end time.Time
+	}{
+		{"at the anchor", anchor, anchor, 0, date(2026, 1, 31), date(2026, 2, 28)},
+		{"first cycle", anchor, date(2026, 2, 27), 0, date(2026, 1, 31), date(2026, 2, 28)},
+		{"clamped start", anchor, date(2026, 2, 28), 1, date(2026, 2, 28), date(2026, 3, 31)},
+		{"day before a clamped start", anchor, date(2026, 4, 29), 2, date(2026, 3, 31), date(2026, 4, 30)},
+		{"back to the 31st", anchor, date(2026, 3, 31), 2, date(2026, 3, 31), date(2026, 4, 30)},
+		{"last instant of a cycle", anchor, date(2026, 3, 31).Add(-time.Nanosecond), 1, date(2026, 2, 28), date(2026, 3, 31)},
+		{"a year on", anchor, date(2027, 1, 31), 12, date(2027, 1, 31), date(2027, 2, 28)},
+		{"mid-month anchor", date(2026, 1, 15), date(2026, 3, 14), 1, date(2026, 2, 15), date(2026, 3, 15)},
+		{"calendar months", calendarAnchor, date(2026, 3, 18), 314, date(2026, 3, 1), date(2026, 4, 1)},
+		{"before the anchor", anchor, date(2026, 1, 1), -1, date(2025, 12, 31), date(2026, 1, 31)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := cycleIndex(tt.anchor, tt.at); got != tt.index {
+				t.Errorf("cycleIndex = %d, want %d", got, tt.index)
+			}
+			c := CycleAt(tt.anchor, tt.at)
+			if !c.Start.Equal(tt.start) || !c.End.Equal(tt.end) {
+				t.Errorf("CycleAt = [%s, %s), want [%s, %s)", c.Start, c.End, tt.start, tt.end)
+			}
+			if tt.at.Before(c.Start) || !tt.at.Before(c.End) {
+				t.Errorf("cycle [%s, %s) does not contain %s", c.Start, c.End, tt.at)
+			}
+		})
+	}
+}
+
+func TestRecentCycles(t *testing.T) {
+	anchor := date(2026, 1, 31)
+
+	cycles := RecentCycles(anchor, date(2026, 4, 1), 3)
+	want := []time.Time{date(2026, 3, 31), date(2026, 2, 28), date(2026, 1, 31)}
+	if len(cycles) != len(want) {
+		t.Fatalf("got %d cycles, want %d", len(cycles), len(want))
+	}
+	for i, c := range cycles {
+		if !c.Start.Equal(want[i]) {
+			t.Errorf("cycle %d starts %s, want %s", i, c.Start, want[i])
+		}
+		if i > 0 && !c.End.Equal(cycles[i-1].Start) {
+			t.Errorf("cycle %d ends %s, not where cycle %d starts", i, c.End, i-1)
+		}
+	}
+
+	if cycles := RecentCycles(anchor, date(2026, 4, 1), 10); len(cycles) != 3 {
+		t.Errorf("got %d cycles, want the 3 since the anchor", len(cycles))
+	}
+	if cycles := RecentCycles(anchor, date(2026, 1, 1), 3); len(cycles) != 0 {
+		t.Errorf("got %d cycles before the anchor", len(cycles))
+	}
+}
@@ -0,0 +1,101 @@
+package quota
+
+import (
+	"context"
+	"fmt"
+)
+
+// Enforcement reports what EnforceLimits changed.
+type Enforcement struct {
+	OrganizationID string   `json:"organization_id"`
+	Plan           string   `json:"plan"`
+	ReadOnly       []string `json:"read_only_projects"`
+	Restored       []string `json:"restored_projects"`
+	SSOEntitled    bool     `json:"sso_entitled"`
+}
+
+// EnforceLimits brings an organization's existing resources in line with its
+// current entitlements. After a downgrade the projects beyond the project
+// limit become read-only, newest first, so the ones the organization has
+// had longest stay editable; after an upgrade they are made writable again.
+// Members and connections over a lower limit are kept, but no new ones can
+// be added until usage drops below it. An SSO connection is kept too, but
+// stops signing members in and being enforced while the plan lacks SSO.
+func (s *Service) EnforceLimits(ctx context.Context, orgID string) (*Enforcement, error) {
+	e, err := s.Entitlements(ctx, OrganizationSubject(orgID))
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := s.db.Query(ctx, `
+		WITH ranked AS (
+			SELECT id, ROW_NUMBER() OVER (ORDER BY created_at, id) AS position
+			FROM projects
+			WHERE organization_id = $1
+		)
+		UPDATE projects p
+		SET read_only = ranked.position > $2
+		FROM ranked
+		WHERE p.id = ranked.id AND p.read_only IS DISTINCT FROM (ranked.position > $2)
+		RETURNING p.id, p.read_only
+	`, orgID, e.Limits[Projects])
+	if err != nil {
+		return nil, fmt.Errorf("failed to apply project limit: %w", err)
+	}
+	defer rows.Close()
+
+	result := &Enforcement{OrganizationID: orgID, Plan: e.Plan, ReadOnly: []string{}, Restored: []string{}}
+	for rows.Next() {
+		var projectID string
+		var readOnly bool
+		if err := rows.Scan(&projectID, &readOnly); err != nil {
+			return nil, fmt.Errorf("failed to apply project limit: %w", err)
+		}
+		if readOnly {
+			result.ReadOnly = append(result.ReadOnly, projectID)
+		} else {
+			result.Restored = append(result.Restored, projectID)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to apply project limit: %w", err)
+	}
+
+	result.SSOEntitled = e.Features[FeatureSSO]
+	if err := s.db.Exec(ctx, `
+		UPDATE sso_connections SET entitled = $2
+		WHERE organization_id = $1 AND entitled <> $2
+	`, orgID, result.SSOEntitled); err != nil {
+		return nil, fmt.Errorf("failed to apply SSO entitlement: %w", err)
+	}
+	return result, nil
+}
+
+// enforcePlanLimits re-applies limits for every organization on a plan
+// after the plan itself changed.
+func (s *Service) enforcePlanLimits(ctx context.Context, planID string) error {
+	rows, err := s.db.Query(ctx, "SELECT id FROM organizations WHERE plan = $1", planID)
+	if err != nil {
+		return fmt.Errorf("failed to list organizations on plan %s: %w", planID, err)
+	}
+	var orgIDs []string
+	for rows.Next() {
+		var orgID string
+		if err := rows.Scan(&orgID); err != nil {
+			rows.Close()
+			return fmt.Errorf("failed to list organizations on plan %s: %w", planID, err)
+		}
+		orgIDs = append(orgIDs, orgID)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("failed to list organizations on plan %s: %w", planID, err)
+	}
+
+	for _, orgID := range orgIDs {
+		if _, err := s.EnforceLimits(ctx, orgID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,109 @@
+package quota
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"go-backend/middleware"
+)
+
+// ErrNotMember is returned when a user asks to bill an organization they do
+// not belong to.
+var ErrNotMember = errors.New("not an active member of the organization")
+
+var ErrOrganizationNotFound = errors.New("organization not found")
+
+// ExceededError reports a refused request and the numbers behind it.
+type ExceededError struct {
+	OrganizationID string
+	Plan           string
+	Resource       Resource
+	Limit          int
+	Used           int
+}
+
+func (e *ExceededError) Error() string {
+	return fmt.Sprintf("%s limit reached (%d of %d on the %s plan)", e.Resource, e.Used, e.Limit, e.Plan)
+}
+
+// StatusCode is 403 when the plan does not include the resource at all and
+// 402 when it does but the allowance is used up, so an upgrade would help.
+func (e *ExceededError) StatusCode() int {
+	if e.Limit <= 0 {
+		return http.StatusForbidden
+	}
+	return http.StatusPaymentRequired
+}
+
+// ValidationError reports an invalid plan or override from an admin request.
+type ValidationError struct {
+	Message string
+}
+
+func (e *ValidationError) Error() string {
+	return e.Message
+}
+
+func invalidf(format string, args ...interface{}) error {
+	return &ValidationError{Message: fmt.Sprintf(format, args...)}
+}
+
+// FeatureError reports a request for a feature the subject's plan does not
+// include.
+type FeatureError struct {
+	OrganizationID string
+	Plan           string
+	Feature        string
+}
+
+func (e *FeatureError) Error() string {
+	return fmt.Sprintf("%s is not available on the %s plan", e.Feature, e.Plan)
+}
+
+var exceededMessages = map[Resource]string{
+	AIQueries:     "Your plan's monthly AI query quota has been used up",
+	Projects:      "Project limit reached for your plan",
+	Members:       "Member limit reached for your plan",
+	DBConnections: "Database connection limit reached for your plan",
+}
+
+// WriteError writes the standard refusal if err is an *ExceededError or a
+// *FeatureError and reports whether it did; other errors are left to the
+// caller.
+func WriteError(w http.ResponseWriter, err error) bool {
+	var missing *FeatureError
+	if errors.As(err, &missing) {
+		middleware.WriteJSONResponse(w, http.StatusForbidden, map[string]interface{}{
+			"error":           "feature_not_available",
+			"message":         "Your plan does not include this feature",
+			"code":            http.StatusForbidden,
+			"feature":         missing.Feature,
+			"plan":            missing.Plan,
+			"organization_id": missing.OrganizationID,
+		})
+		return true
+	}
+
+	var exceeded *ExceededError
+	if !errors.As(err, &exceeded) {
+		return false
+	}
+
+	message := exceededMessages[exceeded.Resource]
+	if message == "" {
+		message = "Plan limit reached"
+	}
+
+	middleware.WriteJSONResponse(w, exceeded.StatusCode(), map[string]interface{}{
+		"error":           "quota_exceeded",
+		"message":         message,
+		"code":            exceeded.StatusCode(),
+		"resource":        exceeded.Resource,
+		"plan":            exceeded.Plan,
+		"organization_id": exceeded.OrganizationID,
+		"limit":           exceeded.Limit,
+		"used":            exceeded.Used,
+	})
+	return true
+}
@@ -0,0 +1,250 @@
+package quota
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// Meter is a billable quantity recorded in the usage ledger.
+type Meter string
+
+const (
+	MeterAIQueries         Meter = "ai_queries"
+	MeterQueryExecutions   Meter = "query_executions"
+	MeterRowsExported      Meter = "rows_exported"
+	MeterConnectionSeconds Meter = "connection_seconds"
+)
+
+// Meters lists every meter, in display order.
+var Meters = []Meter{MeterAIQueries, MeterQueryExecutions, MeterRowsExported, MeterConnectionSeconds}
+
+// CycleReport totals a subject's ledger for one billing cycle.
+type CycleReport struct {
+	Cycle
+	Current bool            `json:"current"`
+	Totals  map[Meter]int64 `json:"totals"`
+	// ConnectionHours restates the connection_seconds total for invoices.
+	ConnectionHours float64                    `json:"connection_hours"`
+	ByUser          map[string]map[Meter]int64 `json:"by_user,omitempty"`
+}
+
+// Record appends a billable event to the ledger.
+func (s *Service) Record(ctx context.Context, sub Subject, meter Meter, quantity int64, metadata map[string]interface{}) error {
+	if quantity <= 0 {
+		return nil
+	}
+	if metadata == nil {
+		metadata = map[string]interface{}{}
+	}
+	metadataBytes, _ := json.Marshal(metadata)
+
+	err := s.db.Exec(ctx, `
+		INSERT INTO usage_ledger (organization_id, user_id, meter, quantity, metadata)
+		VALUES (NULLIF($1, ''), $2, $3, $4, $5)
+	`, sub.OrganizationID, sub.UserID, meter, quantity, metadataBytes)
+	if err != nil {
+		return fmt.Errorf("failed to record %s usage: %w", meter, err)
+	}
+	return nil
+}
+
+// Reservation is one event recorded in the ledger before the work it bills
+// for, so that concurrent requests cannot together go over a limit.
+type Reservation struct {
+	ID    int64
+	Usage *Usage
+}
+
+// Reserve records one event of meter if the subject's usage of resource
+// leaves room for it, or returns an *ExceededError. Reservations of a
+// subject are serialized, so the check and the event are atomic. Callers
+// Settle the reservation once the work is done or Release it if it fails.
+func (s *Service) Reserve(ctx context.Context, sub Subject, resource Resource, meter Meter, metadata map[string]interface{}) (*Reservation, error) {
+	if metadata == nil {
+		metadata = map[string]interface{}{}
+	}
+	metadataBytes, _ := json.Marshal(metadata)
+
+	tx, err := s.db.GetPool().Begin(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to reserve %s usage: %w", meter, err)
+	}
+	defer tx.Rollback(ctx)
+
+	_, arg := ledgerScope(sub)
+	if _, err := tx.Exec(ctx, `SELECT pg_advisory_xact_lock(hashtext('usage_ledger'), hashtext($1))`, arg); err != nil {
+		return nil, fmt.Errorf("failed to reserve %s usage: %w", meter, err)
+	}
+
+	// Reservations committed before the lock was granted are counted
+	usage, err := s.Check(ctx, sub, resource, 1)
+	if err != nil {
+		return nil, err
+	}
+
+	r := &Reservation{Usage: usage}
+	err = tx.QueryRow(ctx, `
+		INSERT INTO usage_ledger (organization_id, user_id, meter, quantity, metadata)
+		VALUES (NULLIF($1, ''), $2, $3, 1, $4)
+		RETURNING id
+	`, sub.OrganizationID, sub.UserID, meter, metadataBytes).Scan(&r.ID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to reserve %s usage: %w", meter, err)
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return nil, fmt.Errorf("failed to reserve %s usage: %w", meter, err)
+	}
+
+	usage.Used++
+	return r, nil
+}
+
+// Settle adds metadata known only once the work is done to a reservation.
+func (s *Service) Settle(ctx context.Context, r *Reservation, metadata map[string]interface{}) error {
+	metadataBytes, _ := json.Marshal(metadata)
+	if err := s.db.Exec(ctx, `UPDATE usage_ledger SET metadata = metadata || $2 WHERE id = $1`, r.ID, metadataBytes); err != nil {
+		return fmt.Errorf("failed to settle usage: %w", err)
+	}
+	return nil
+}
+
+// Release removes a reservation whose work was not done.
+func (s *Service) Release(ctx context.Context, r *Reservation) error {
+	if err := s.db.Exec(ctx, `DELETE FROM usage_ledger WHERE id = $1`, r.ID); err != nil {
+		return fmt.Errorf("failed to release usage: %w", err)
+	}
+	r.Usage.Used--
+	return nil
+}
+
+// BillingAnchor returns the instant the subject's cycles are counted from.
+// Organizations have their own; users without one use calendar months.
+func (s *Service) BillingAnchor(ctx context.Context, sub Subject) (time.Time, error) {
+	if sub.OrganizationID == "" {
+		return calendarAnchor, nil
+	}
+
+	var anchor time.Time
+	err := s.db.QueryRow(ctx, `
+		SELECT COALESCE(billing_anchor, created_at) FROM organizations WHERE id = $1
+	`, sub.OrganizationID).Scan(&anchor)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return time.Time{}, ErrOrganizationNotFound
+	}
+	if err != nil {
+		return time.Time{}, fmt.Errorf("failed to load billing anchor: %w", err)
+	}
+	return anchor, nil
+}
+
+// SetBillingAnchor moves an organization's billing anchor. Past cycles are
+// recomputed from the new anchor, so this is meant for corrections and for
+// aligning with an external billing system.
+func (s *Service) SetBillingAnchor(ctx context.Context, orgID string, anchor time.Time) error {
+	var updated string
+	err := s.db.QueryRow(ctx, "UPDATE organizations SET billing_anchor = $2 WHERE id = $1 RETURNING id", orgID, anchor.UTC()).Scan(&updated)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return ErrOrganizationNotFound
+	}
+	if err != nil {
+		return fmt.Errorf("failed to update billing anchor: %w", err)
+	}
+	return nil
+}
+
+// CurrentCycle returns the subject's billing cycle containing now.
+func (s *Service) CurrentCycle(ctx context.Context, sub Subject) (Cycle, error) {
+	anchor, err := s.BillingAnchor(ctx, sub)
+	if err != nil {
+		return Cycle{}, err
+	}
+	return CycleAt(anchor, time.Now()), nil
+}
+
+// ledgerScope restricts ledger queries to the subject: an organization's
+// events, or a lone user's events made outside any organization.
+func ledgerScope(sub Subject) (string, string) {
+	if sub.OrganizationID != "" {
+		return "organization_id = $1", sub.OrganizationID
+	}
+	return "organization_id IS NULL AND user_id = $1", sub.UserID
+}
+
+// Metered sums the subject's ledger for one cycle.
+func (s *Service) Metered(ctx context.Context, sub Subject, cycle Cycle) (map[Meter]int64, error) {
+	report, err := s.cycleReport(ctx, sub, cycle, false)
+	if err != nil {
+		return nil, err
+	}
+	return report.Totals, nil
+}
+
+// CycleReports returns the subject's totals for up to count cycles, most
+// recent first. With byUser set each report also splits totals by user.
+func (s *Service) CycleReports(ctx context.Context, sub Subject, count int, byUser bool) ([]CycleReport, error) {
+	anchor, err := s.BillingAnchor(ctx, sub)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	reports := []CycleReport{}
+	for _, cycle := range RecentCycles(anchor, now, count) {
+		report, err := s.cycleReport(ctx, sub, cycle, byUser)
+		if err != nil {
+			return nil, err
+		}
+		report.Current = !now.Before(cycle.Start) && now.Before(cycle.End)
+		reports = append(reports, *report)
+	}
+	return reports, nil
+}
+
+func (s *Service) cycleReport(ctx context.Context, sub Subject, cycle Cycle, byUser bool) (*CycleReport, error) {
+	scope, arg := ledgerScope(sub)
+	rows, err := s.db.Query(ctx, `
+		SELECT user_id, meter, SUM(quantity)::bigint
+		FROM usage_ledger
+		WHERE `+scope+` AND occurred_at >= $2 AND occurred_at < $3
+		GROUP BY user_id, meter
+	`, arg, cycle.Start, cycle.End)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read usage ledger: %w", err)
+	}
+	defer rows.Close()
+
+	report := &CycleReport{Cycle: cycle, Totals: make(map[Meter]int64)}
+	for _, meter := range Meters {
+		report.Totals[meter] = 0
+	}
+	if byUser {
+		report.ByUser = make(map[string]map[Meter]int64)
+	}
+
+	for rows.Next() {
+		var userID string
+		var meter Meter
+		var quantity int64
+		if err := rows.Scan(&userID, &meter, &quantity); err != nil {
+			return nil, fmt.Errorf("failed to read usage ledger: %w", err)
+		}
+		report.Totals[meter] += quantity
+		if byUser {
+			if report.ByUser[userID] == nil {
+				report.ByUser[userID] = make(map[Meter]int64)
+			}
+			report.ByUser[userID][meter] += quantity
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read usage ledger: %w", err)
+	}
+
+	report.ConnectionHours = float64(report.Totals[MeterConnectionSeconds]) / 3600
+	return report, nil
+}
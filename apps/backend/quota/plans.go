@@ -0,0 +1,153 @@
+// Package quota is the single place plan limits are defined, usage is
+// counted and limits are enforced. Handlers ask the Service before creating
+// anything a plan restricts and report refusals with WriteError so every
+// endpoint returns the same payload.
+//
+// Plans live in the plans table so limits can change without a redeploy;
+// the definitions below seed that table and are the fallback when it has no
+// default plan.
+package quota
+
+import "time"
+
+type Resource string
+
+const (
+	AIQueries        Resource = "ai_queries"
+	Projects         Resource = "projects"
+	Members          Resource = "members"
+	DBConnections    Resource = "db_connections"
+	QueryHistoryDays Resource = "query_history_days"
+)
+
+// Resources lists every limited resource, in display order.
+var Resources = []Resource{AIQueries, Projects, Members, DBConnections, QueryHistoryDays}
+
+// Feature flags a plan can switch on or off. Plans may carry other flags;
+// these are the ones the server checks.
+const (
+	FeatureAIAssistant = "ai_assistant"
+	FeatureAuditLog    = "audit_log"
+	FeatureSSO         = "sso"
+	FeatureSCIM        = "scim"
+)
+
+// DefaultPlan applies to organizations with an unknown plan and to users who
+// do not belong to any organization, unless another plan is marked default.
+const DefaultPlan = "free"
+
+type Limits map[Resource]int
+
+type Features map[string]bool
+
+// Plan is one row of the plans table.
+type Plan struct {
+	ID        string   `json:"id"`
+	Name      string   `json:"name"`
+	Limits    Limits   `json:"limits"`
+	Features  Features `json:"features"`
+	IsDefault bool     `json:"is_default"`
+	// PriceID is the billing provider's price that sells this plan; empty
+	// for plans that are not sold through checkout.
+	PriceID   string    `json:"price_id,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// Override adjusts an organization's plan for a custom deal. Limits and
+// features it lists replace the plan's; the rest are inherited. An expired
+// override is ignored.
+type Override struct {
+	OrganizationID string     `json:"organization_id"`
+	Limits         Limits     `json:"limits"`
+	Features       Features   `json:"features"`
+	Note           string     `json:"note,omitempty"`
+	ExpiresAt      *time.Time `json:"expires_at,omitempty"`
+	UpdatedBy      string     `json:"updated_by,omitempty"`
+	CreatedAt      time.Time  `json:"created_at"`
+	UpdatedAt      time.Time  `json:"updated_at"`
+}
+
+func (o *Override) active(now time.Time) bool {
+	return o != nil && (o.ExpiresAt == nil || o.ExpiresAt.After(now))
+}
+
+// Entitlements are what a subject may actually use: its plan with any active
+// override applied.
+type Entitlements struct {
+	Plan       string   `json:"plan"`
+	Limits     Limits   `json:"limits"`
+	Features   Features `json:"features"`
+	Overridden bool     `json:"overridden"`
+}
+
+func newEntitlements(plan *Plan, override *Override, now time.Time) *Entitlements {
+	e := &Entitlements{Plan: plan.ID, Limits: make(Limits), Features: make(Features)}
+	for resource, limit := range plan.Limits {
+		e.Limits[resource] = limit
+	}
+	for feature, enabled := range plan.Features {
+		e.Features[feature] = enabled
+	}
+	if override.active(now) {
+		e.Overridden = true
+		for resource, limit := range override.Limits {
+			e.Limits[resource] = limit
+		}
+		for feature, enabled := range override.Features {
+			e.Features[feature] = enabled
+		}
+	}
+	return e
+}
+
+// builtinPlans seed the plans table on first start.
+var builtinPlans = []Plan{
+	{
+		ID:   "free",
+		Name: "Free",
+		Limits: Limits{
+			AIQueries:        40,
+			Projects:         2,
+			Members:          3,
+			DBConnections:    2,
+			QueryHistoryDays: 7,
+		},
+		Features:  Features{FeatureAIAssistant: true, FeatureAuditLog: false, FeatureSSO: false, FeatureSCIM: false},
+		IsDefault: true,
+	},
+	{
+		ID:   "pro",
+		Name: "Pro",
+		Limits: Limits{
+			AIQueries:        1000,
+			Projects:         25,
+			Members:          25,
+			DBConnections:    25,
+			QueryHistoryDays: 90,
+		},
+		Features: Features{FeatureAIAssistant: true, FeatureAuditLog: true, FeatureSSO: false, FeatureSCIM: false},
+	},
+	{
+		ID:   "enterprise",
+		Name: "Enterprise",
+		Limits: Limits{
+			AIQueries:        10000,
+			Projects:         100,
+			Members:          100,
+			DBConnections:    100,
+			QueryHistoryDays: 365,
+		},
+		Features: Features{FeatureAIAssistant: true, FeatureAuditLog: true, FeatureSSO: true, FeatureSCIM: true},
+	},
+}
+
+func builtinPlan(id string) *Plan {
+	for i := range builtinPlans {
+		if builtinPlans[i].ID == id {
+			plan := builtinPlans[i]
+			return &plan
+		}
+	}
+	return nil
+}
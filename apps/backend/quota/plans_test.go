@@ -0,0 +1,158 @@
+package quota
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func fullLimits(edit func(Limits)) Limits {
+	limits := Limits{AIQueries: 40, Projects: 2, Members: 3, DBConnections: 2, QueryHistoryDays: 7}
+	if edit != nil {
+		edit(limits)
+	}
+	return limits
+}
+
+func TestPlanValidate(t *testing.T) {
+	tests := []struct {
+		name string
+		plan Plan
+		err  string // empty when valid
+	}{
+		{"valid", Plan{ID: "team", Name: "Team", Limits: fullLimits(nil)}, ""},
+		{"zero limits", Plan{ID: "locked", Limits: fullLimits(func(l Limits) {
+			for r := range l {
+				l[r] = 0
+			}
+		})}, ""},
+		{"id with dash and underscore", Plan{ID: "pro-2026_eu", Limits: fullLimits(nil)}, ""},
+		{"empty id", Plan{Limits: fullLimits(nil)}, "plan id"},
+		{"upper-case id", Plan{ID: "Team", Limits: fullLimits(nil)}, "plan id"},
+		{"id starting with a dash", Plan{ID: "-team", Limits: fullLimits(nil)}, "plan id"},
+		{"id too long", Plan{ID: strings.Repeat("a", 51), Limits: fullLimits(nil)}, "plan id"},
+		{"missing limit", Plan{ID: "team", Limits: fullLimits(func(l Limits) { delete(l, Members) })}, "limit for members is required"},
+		{"no limits", Plan{ID: "team"}, "is required"},
+		{"negative limit", Plan{ID: "team", Limits: fullLimits(func(l Limits) { l[Projects] = -1 })}, "cannot be negative"},
+		{"unknown resource", Plan{ID: "team", Limits: fullLimits(func(l Limits) { l["seats"] = 5 })}, `unknown resource "seats"`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			plan := tt.plan
+			err := plan.Validate()
+			if tt.err == "" {
+				if err != nil {
+					t.Fatal(err)
+				}
+				if plan.Name == "" || plan.Features == nil {
+					t.Errorf("defaults not filled in: %+v", plan)
+				}
+				return
+			}
+			var invalid *ValidationError
+			if !errors.As(err, &invalid) || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("Validate = %v, want a validation error about %q", err, tt.err)
+			}
+		})
+	}
+}
+
+func TestBuiltinPlansAreValid(t *testing.T) {
+	defaults := 0
+	for _, plan := range builtinPlans {
+		p := plan
+		if err := p.Validate(); err != nil {
+			t.Errorf("%s: %v", plan.ID, err)
+		}
+		if plan.IsDefault {
+			defaults++
+		}
+	}
+	if defaults != 1 || builtinPlan(DefaultPlan) == nil || !builtinPlan(DefaultPlan).IsDefault {
+		t.Errorf("built-in plans have %d defaults; %s must be the one", defaults, DefaultPlan)
+	}
+}
+
+func TestOverrideValidate(t *testing.T) {
+	past, future := time.Now().Add(-time.Hour), time.Now().Add(time.Hour)
+
+	tests := []struct {
+		name     string
+		override Override
+		err      string // empty when valid
+	}{
+		{"empty", Override{}, ""},
+		{"some limits", Override{Limits: Limits{Members: 50}, ExpiresAt: &future}, ""},
+		{"unknown resource", Override{Limits: Limits{"seats": 5}}, "unknown resource"},
+		{"negative limit", Override{Limits: Limits{Members: -1}}, "cannot be negative"},
+		{"already expired", Override{ExpiresAt: &past}, "expires_at"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			o := tt.override
+			err := o.Validate()
+			if tt.err == "" {
+				if err != nil {
+					t.Fatal(err)
+				}
+				if o.Limits == nil || o.Features == nil {
+					t.Errorf("defaults not filled in: %+v", o)
+				}
+				return
+			}
+			var invalid *ValidationError
+			if !errors.As(err, &invalid) || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("Validate = %v, want a validation error about %q", err, tt.err)
+			}
+		})
+	}
+}
+
+func TestNewEntitlements(t *testing.T) {
+	now := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	past, future := now.Add(-time.Second), now.Add(time.Hour)
+	plan := builtinPlan("pro")
+	override := func(expiresAt *time.Time) *Override {
+		return &Override{
+			OrganizationID: "o1",
+			Limits:         Limits{Members: 500},
+			Features:       Features{FeatureSSO: true, FeatureAuditLog: false},
+			ExpiresAt:      expiresAt,
+		}
+	}
+
+	tests := []struct {
+		name       string
+		override   *Override
+		members    int
+		sso, audit bool
+	}{
+		{"plan only", nil, 25, false, true},
+		{"override without expiry", override(nil), 500, true, false},
+		{"override until later", override(&future), 500, true, false},
+		{"expired override", override(&past), 25, false, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := newEntitlements(plan, tt.override, now)
+			if e.Limits[Members] != tt.members || e.Features[FeatureSSO] != tt.sso || e.Features[FeatureAuditLog] != tt.audit {
+				t.Errorf("entitlements = %+v", e)
+			}
+			if e.Overridden != (tt.members != 25) {
+				t.Errorf("overridden = %v", e.Overridden)
+			}
+			if e.Limits[Projects] != plan.Limits[Projects] {
+				t.Errorf("projects = %d, want the plan's %d", e.Limits[Projects], plan.Limits[Projects])
+			}
+		})
+	}
+
+	newEntitlements(plan, override(nil), now)
+	if plan.Limits[Members] != 25 || plan.Features[FeatureSSO] {
+		t.Error("an override changed the plan itself")
+	}
+}
@@ -0,0 +1,301 @@
+package quota
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"go-backend/database"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// Subject is who a request is billed to: an organization, or a user who does
+// not belong to one (who gets the default plan).
+type Subject struct {
+	OrganizationID string `json:"organization_id,omitempty"`
+	UserID         string `json:"user_id,omitempty"`
+}
+
+// Usage is the state of one resource for a subject.
+type Usage struct {
+	Subject
+	Plan     string   `json:"plan"`
+	Resource Resource `json:"resource"`
+	Used     int      `json:"used"`
+	Limit    int      `json:"limit"`
+}
+
+// Report is the state of every resource for a subject.
+type Report struct {
+	Subject
+	Plan       string           `json:"plan"`
+	Limits     Limits           `json:"limits"`
+	Features   Features         `json:"features"`
+	Overridden bool             `json:"overridden"`
+	Used       map[Resource]int `json:"used"`
+	Cycle      Cycle            `json:"cycle"`
+	Metered    map[Meter]int64  `json:"metered"`
+}
+
+type Service struct {
+	db    *database.PostgresDB
+	redis *database.RedisClient
+}
+
+// NewService returns a service that caches plan lookups in redis when it is
+// not nil.
+func NewService(db *database.PostgresDB, redis *database.RedisClient) *Service {
+	return &Service{db: db, redis: redis}
+}
+
+// OrganizationSubject bills an organization directly; callers have already
+// checked membership.
+func OrganizationSubject(orgID string) Subject {
+	return Subject{OrganizationID: orgID}
+}
+
+// Resolve picks the subject for a user's request. An explicit organization
+// must be one the user is an active member of; otherwise the user's earliest
+// membership is used, and users without one are billed on their own.
+func (s *Service) Resolve(ctx context.Context, userID, orgID string) (Subject, error) {
+	if orgID != "" {
+		var count int
+		err := s.db.QueryRow(ctx, `
+			SELECT COUNT(*) FROM organization_members
+			WHERE organization_id = $1 AND user_id = $2 AND status = 'active'
+		`, orgID, userID).Scan(&count)
+		if err != nil {
+			return Subject{}, err
+		}
+		if count == 0 {
+			return Subject{}, ErrNotMember
+		}
+		return Subject{OrganizationID: orgID, UserID: userID}, nil
+	}
+
+	err := s.db.QueryRow(ctx, `
+		SELECT organization_id FROM organization_members
+		WHERE user_id = $1 AND status = 'active'
+		ORDER BY joined_at ASC
+		LIMIT 1
+	`, userID).Scan(&orgID)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return Subject{UserID: userID}, nil
+	}
+	if err != nil {
+		return Subject{}, err
+	}
+	return Subject{OrganizationID: orgID, UserID: userID}, nil
+}
+
+// Entitlements returns the subject's plan with any active override for its
+// organization applied. Users without an organization get the default plan.
+func (s *Service) Entitlements(ctx context.Context, sub Subject) (*Entitlements, error) {
+	if sub.OrganizationID == "" {
+		plan, err := s.defaultPlan(ctx)
+		if err != nil {
+			return nil, err
+		}
+		return newEntitlements(plan, nil, time.Now()), nil
+	}
+
+	var planID string
+	err := s.db.QueryRow(ctx, "SELECT COALESCE(plan, '') FROM organizations WHERE id = $1", sub.OrganizationID).Scan(&planID)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, ErrOrganizationNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to load organization plan: %w", err)
+	}
+
+	plan, err := s.resolvePlan(ctx, planID)
+	if err != nil {
+		return nil, err
+	}
+	override, err := s.GetOverride(ctx, sub.OrganizationID)
+	if err != nil {
+		return nil, err
+	}
+	return newEntitlements(plan, override, time.Now()), nil
+}
+
+// Plan returns the subject's plan name and effective limits.
+func (s *Service) Plan(ctx context.Context, sub Subject) (string, Limits, error) {
+	e, err := s.Entitlements(ctx, sub)
+	if err != nil {
+		return "", nil, err
+	}
+	return e.Plan, e.Limits, nil
+}
+
+// RequireFeature returns a *FeatureError if the subject's entitlements do not
+// include feature.
+func (s *Service) RequireFeature(ctx context.Context, sub Subject, feature string) error {
+	e, err := s.Entitlements(ctx, sub)
+	if err != nil {
+		return err
+	}
+	if !e.Features[feature] {
+		return &FeatureError{OrganizationID: sub.OrganizationID, Plan: e.Plan, Feature: feature}
+	}
+	return nil
+}
+
+// Check returns the subject's usage of resource, or an *ExceededError if
+// adding n more would go over the plan's limit.
+func (s *Service) Check(ctx context.Context, sub Subject, resource Resource, n int) (*Usage, error) {
+	plan, limits, err := s.Plan(ctx, sub)
+	if err != nil {
+		return nil, err
+	}
+
+	used, err := s.Used(ctx, sub, resource)
+	if err != nil {
+		return nil, err
+	}
+
+	usage := &Usage{Subject: sub, Plan: plan, Resource: resource, Used: used, Limit: limits[resource]}
+	return usage, usage.exceeded(n)
+}
+
+// exceeded returns an *ExceededError if n more would go over the limit.
+func (u *Usage) exceeded(n int) error {
+	if u.Used+n <= u.Limit {
+		return nil
+	}
+	return &ExceededError{
+		OrganizationID: u.OrganizationID,
+		Plan:           u.Plan,
+		Resource:       u.Resource,
+		Limit:          u.Limit,
+		Used:           u.Used,
+	}
+}
+
+// CheckInvitation checks that the organization has a seat for one more
+// invitation. Pending invitations hold seats so a batch of invites cannot
+// overshoot the member limit once they are accepted.
+func (s *Service) CheckInvitation(ctx context.Context, orgID string) (*Usage, error) {
+	var pending int
+	err := s.db.QueryRow(ctx, `
+		SELECT COUNT(*) FROM organization_invitations
+		WHERE organization_id = $1 AND status = 'pending' AND expires_at > NOW()
+	`, orgID).Scan(&pending)
+	if err != nil {
+		return nil, err
+	}
+
+	usage, err := s.Check(ctx, OrganizationSubject(orgID), Members, pending+1)
+	var exceeded *ExceededError
+	if errors.As(err, &exceeded) {
+		exceeded.Used += pending
+	}
+	return usage, err
+}
+
+// Report returns usage and limits for every resource.
+func (s *Service) Report(ctx context.Context, sub Subject) (*Report, error) {
+	e, err := s.Entitlements(ctx, sub)
+	if err != nil {
+		return nil, err
+	}
+
+	report := &Report{
+		Subject:    sub,
+		Plan:       e.Plan,
+		Limits:     e.Limits,
+		Features:   e.Features,
+		Overridden: e.Overridden,
+		Used:       make(map[Resource]int),
+	}
+
+	if report.Cycle, err = s.CurrentCycle(ctx, sub); err != nil {
+		return nil, err
+	}
+	if report.Metered, err = s.Metered(ctx, sub, report.Cycle); err != nil {
+		return nil, err
+	}
+
+	for _, resource := range Resources {
+		if resource == QueryHistoryDays {
+			continue
+		}
+		if resource == AIQueries {
+			report.Used[resource] = int(report.Metered[MeterAIQueries])
+			continue
+		}
+		used, err := s.Used(ctx, sub, resource)
+		if err != nil {
+			return nil, err
+		}
+		report.Used[resource] = used
+	}
+	return report, nil
+}
+
+// HistoryCutoff returns the oldest query history timestamp the user's plan
+// lets them see.
+func (s *Service) HistoryCutoff(ctx context.Context, userID string) (time.Time, error) {
+	sub, err := s.Resolve(ctx, userID, "")
+	if err != nil {
+		return time.Time{}, err
+	}
+	_, limits, err := s.Plan(ctx, sub)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return time.Now().AddDate(0, 0, -limits[QueryHistoryDays]), nil
+}
+
+// Used counts the subject's current usage of a resource. AI queries come
+// from the usage ledger for the current billing cycle.
+func (s *Service) Used(ctx context.Context, sub Subject, resource Resource) (int, error) {
+	// Organizations are measured across their active members; a lone user
+	// only against themselves.
+	members := `SELECT user_id FROM organization_members WHERE organization_id = $1 AND status = 'active'`
+	arg := sub.OrganizationID
+	if sub.OrganizationID == "" {
+		members = `SELECT $1::varchar`
+		arg = sub.UserID
+	}
+
+	var query string
+	switch resource {
+	case AIQueries:
+		cycle, err := s.CurrentCycle(ctx, sub)
+		if err != nil {
+			return 0, err
+		}
+		metered, err := s.Metered(ctx, sub, cycle)
+		if err != nil {
+			return 0, err
+		}
+		return int(metered[MeterAIQueries]), nil
+	case Projects:
+		if sub.OrganizationID == "" {
+			return 0, nil
+		}
+		query = `SELECT COUNT(*) FROM projects WHERE organization_id = $1`
+	case Members:
+		if sub.OrganizationID == "" {
+			return 1, nil
+		}
+		query = `SELECT COUNT(*) FROM organization_members WHERE organization_id = $1 AND status = 'active'`
+	case DBConnections:
+		query = `
+			SELECT
+				(SELECT COUNT(*) FROM database_configs WHERE is_active AND user_id IN (` + members + `)) +
+				(SELECT COUNT(*) FROM ssh_configs WHERE is_active AND user_id IN (` + members + `)) +
+				(SELECT COUNT(*) FROM wireguard_configs WHERE is_active AND user_id IN (` + members + `))`
+	default:
+		return 0, fmt.Errorf("resource %q is not counted", resource)
+	}
+
+	var used int
+	if err := s.db.QueryRow(ctx, query, arg).Scan(&used); err != nil {
+		return 0, fmt.Errorf("failed to count %s usage: %w", resource, err)
+	}
+	return used, nil
+}
@@ -0,0 +1,110 @@
+package quota
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestAIQuotaRefusal(t *testing.T) {
+	usage := func(used, limit int) *Usage {
+		return &Usage{Subject: OrganizationSubject("o1"), Plan: "free", Resource: AIQueries, Used: used, Limit: limit}
+	}
+
+	if err := usage(39, 40).exceeded(1); err != nil {
+		t.Fatalf("refused the last query of the allowance: %v", err)
+	}
+
+	tests := []struct {
+		name   string
+		usage  *Usage
+		status int
+	}{
+		{"allowance used up", usage(40, 40), http.StatusPaymentRequired},
+		{"over the allowance after a downgrade", usage(55, 40), http.StatusPaymentRequired},
+		{"plan without AI queries", usage(0, 0), http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.usage.exceeded(1)
+			var exceeded *ExceededError
+			if !errors.As(err, &exceeded) {
+				t.Fatalf("error = %v, want a quota refusal", err)
+			}
+
+			rec := httptest.NewRecorder()
+			if !WriteError(rec, err) {
+				t.Fatal("refusal was not written")
+			}
+			if rec.Code != tt.status {
+				t.Errorf("status = %d, want %d", rec.Code, tt.status)
+			}
+			var body map[string]interface{}
+			if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+				t.Fatal(err)
+			}
+			if body["error"] != "quota_exceeded" || body["resource"] != string(AIQueries) ||
+				body["message"] != exceededMessages[AIQueries] || body["organization_id"] != "o1" ||
+				body["used"] != float64(tt.usage.Used) || body["limit"] != float64(tt.usage.Limit) {
+				t.Errorf("body = %v", body)
+			}
+		})
+	}
+}
+
+func TestWriteErrorFeature(t *testing.T) {
+	rec := httptest.NewRecorder()
+	if !WriteError(rec, &FeatureError{OrganizationID: "o1", Plan: "free", Feature: FeatureAIAssistant}) {
+		t.Fatal("refusal was not written")
+	}
+	if rec.Code != http.StatusForbidden {
+		t.Errorf("status = %d, want 403", rec.Code)
+	}
+
+	if WriteError(httptest.NewRecorder(), errors.New("connection refused")) {
+		t.Error("wrote a refusal for an unrelated error")
+	}
+}
+
+func TestUsageExceeded(t *testing.T) {
+	tests := []struct {
+		name   string
+		used   int
+		limit  int
+		n      int
+		status int // 0 when allowed
+	}{
+		{"room left", 1, 3, 1, 0},
+		{"last seat", 2, 3, 1, 0},
+		{"full", 3, 3, 1, http.StatusPaymentRequired},
+		{"batch that fits", 0, 3, 3, 0},
+		{"batch one too many", 1, 3, 3, http.StatusPaymentRequired},
+		{"checking without adding", 5, 3, 0, http.StatusPaymentRequired},
+		{"resource not in the plan", 0, 0, 1, http.StatusForbidden},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			u := &Usage{Subject: OrganizationSubject("o1"), Plan: "free", Resource: Projects, Used: tt.used, Limit: tt.limit}
+			err := u.exceeded(tt.n)
+			if tt.status == 0 {
+				if err != nil {
+					t.Fatalf("refused: %v", err)
+				}
+				return
+			}
+			var exceeded *ExceededError
+			if !errors.As(err, &exceeded) {
+				t.Fatalf("error = %v, want a quota refusal", err)
+			}
+			if exceeded.StatusCode() != tt.status {
+				t.Errorf("status = %d, want %d", exceeded.StatusCode(), tt.status)
+			}
+			if exceeded.Used != tt.used || exceeded.Limit != tt.limit || exceeded.OrganizationID != "o1" {
+				t.Errorf("refusal = %+v", exceeded)
+			}
+		})
+	}
+}
@@ -0,0 +1,448 @@
+package quota
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/rs/zerolog/log"
+)
+
+var (
+	ErrPlanNotFound = errors.New("plan not found")
+	ErrPlanInUse    = errors.New("plan is assigned to organizations")
+	ErrDefaultPlan  = errors.New("the default plan cannot be deleted")
+)
+
+// cacheTTL bounds how long another instance may serve a plan after an admin
+// changes it; the instance that made the change drops its keys at once.
+const cacheTTL = time.Minute
+
+const defaultPlanKey = "plan:default"
+
+var planIDPattern = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]{0,49}$`)
+
+// Validate checks an admin-supplied plan. Every resource needs a limit so a
+// plan never silently blocks a resource it forgot to mention.
+func (p *Plan) Validate() error {
+	if !planIDPattern.MatchString(p.ID) {
+		return invalidf("plan id must be 1-50 lowercase letters, digits, '-' or '_'")
+	}
+	if p.Name == "" {
+		p.Name = p.ID
+	}
+	for _, resource := range Resources {
+		limit, ok := p.Limits[resource]
+		if !ok {
+			return invalidf("limit for %s is required", resource)
+		}
+		if limit < 0 {
+			return invalidf("limit for %s cannot be negative", resource)
+		}
+	}
+	if err := validateLimitKeys(p.Limits); err != nil {
+		return err
+	}
+	if p.Features == nil {
+		p.Features = Features{}
+	}
+	return nil
+}
+
+// Validate checks an admin-supplied override; unlike a plan it may list only
+// the limits it changes.
+func (o *Override) Validate() error {
+	if err := validateLimitKeys(o.Limits); err != nil {
+		return err
+	}
+	for resource, limit := range o.Limits {
+		if limit < 0 {
+			return invalidf("limit for %s cannot be negative", resource)
+		}
+	}
+	if o.ExpiresAt != nil && !o.ExpiresAt.After(time.Now()) {
+		return invalidf("expires_at must be in the future")
+	}
+	if o.Limits == nil {
+		o.Limits = Limits{}
+	}
+	if o.Features == nil {
+		o.Features = Features{}
+	}
+	return nil
+}
+
+func validateLimitKeys(limits Limits) error {
+	for resource := range limits {
+		known := false
+		for _, r := range Resources {
+			if r == resource {
+				known = true
+				break
+			}
+		}
+		if !known {
+			return invalidf("unknown resource %q", resource)
+		}
+	}
+	return nil
+}
+
+// EnsureDefaultPlans inserts the built-in plans that are missing. Existing
+// rows are left alone so admin edits survive restarts.
+func (s *Service) EnsureDefaultPlans(ctx context.Context) error {
+	var defaults int
+	if err := s.db.QueryRow(ctx, "SELECT COUNT(*) FROM plans WHERE is_default").Scan(&defaults); err != nil {
+		return fmt.Errorf("failed to count default plans: %w", err)
+	}
+
+	for _, plan := range builtinPlans {
+		limits, _ := json.Marshal(plan.Limits)
+		features, _ := json.Marshal(plan.Features)
+		err := s.db.Exec(ctx, `
+			INSERT INTO plans (id, name, limits, features, is_default)
+			VALUES ($1, $2, $3, $4, $5)
+			ON CONFLICT (id) DO NOTHING
+		`, plan.ID, plan.Name, limits, features, plan.IsDefault && defaults == 0)
+		if err != nil {
+			return fmt.Errorf("failed to seed plan %s: %w", plan.ID, err)
+		}
+	}
+	return nil
+}
+
+const planColumns = `id, name, limits, features, is_default, COALESCE(price_id, ''), created_at, updated_at`
+
+func scanPlan(row pgx.Row) (*Plan, error) {
+	var plan Plan
+	var limits, features []byte
+	if err := row.Scan(&plan.ID, &plan.Name, &limits, &features, &plan.IsDefault, &plan.PriceID, &plan.CreatedAt, &plan.UpdatedAt); err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(limits, &plan.Limits); err != nil {
+		return nil, fmt.Errorf("invalid limits for plan %s: %w", plan.ID, err)
+	}
+	if err := json.Unmarshal(features, &plan.Features); err != nil {
+		return nil, fmt.Errorf("invalid features for plan %s: %w", plan.ID, err)
+	}
+	return &plan, nil
+}
+
+// ListPlans returns every plan, default first.
+func (s *Service) ListPlans(ctx context.Context) ([]Plan, error) {
+	rows, err := s.db.Query(ctx, "SELECT "+planColumns+" FROM plans ORDER BY is_default DESC, id")
+	if err != nil {
+		return nil, fmt.Errorf("failed to list plans: %w", err)
+	}
+	defer rows.Close()
+
+	plans := []Plan{}
+	for rows.Next() {
+		plan, err := scanPlan(rows)
+		if err != nil {
+			return nil, err
+		}
+		plans = append(plans, *plan)
+	}
+	return plans, rows.Err()
+}
+
+// GetPlan returns a plan by id, or ErrPlanNotFound.
+func (s *Service) GetPlan(ctx context.Context, id string) (*Plan, error) {
+	key := "plan:" + id
+	var plan Plan
+	if s.cacheGet(ctx, key, &plan) {
+		return &plan, nil
+	}
+
+	found, err := scanPlan(s.db.QueryRow(ctx, "SELECT "+planColumns+" FROM plans WHERE id = $1", id))
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, ErrPlanNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to load plan %s: %w", id, err)
+	}
+	s.cacheSet(ctx, key, found)
+	return found, nil
+}
+
+// defaultPlan returns the plan marked default, or the built-in one if the
+// table has none.
+func (s *Service) defaultPlan(ctx context.Context) (*Plan, error) {
+	var plan Plan
+	if s.cacheGet(ctx, defaultPlanKey, &plan) {
+		return &plan, nil
+	}
+
+	found, err := scanPlan(s.db.QueryRow(ctx, "SELECT "+planColumns+" FROM plans WHERE is_default ORDER BY id LIMIT 1"))
+	if errors.Is(err, pgx.ErrNoRows) {
+		return builtinPlan(DefaultPlan), nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to load default plan: %w", err)
+	}
+	s.cacheSet(ctx, defaultPlanKey, found)
+	return found, nil
+}
+
+// DefaultPlanID is the plan new organizations start on.
+func (s *Service) DefaultPlanID(ctx context.Context) (string, error) {
+	plan, err := s.defaultPlan(ctx)
+	if err != nil {
+		return "", err
+	}
+	return plan.ID, nil
+}
+
+// resolvePlan returns the named plan, falling back to the default plan for
+// empty or unknown names.
+func (s *Service) resolvePlan(ctx context.Context, id string) (*Plan, error) {
+	if id != "" {
+		plan, err := s.GetPlan(ctx, id)
+		if err == nil {
+			return plan, nil
+		}
+		if !errors.Is(err, ErrPlanNotFound) {
+			return nil, err
+		}
+	}
+	return s.defaultPlan(ctx)
+}
+
+// SavePlan creates or replaces a plan. Marking it default unmarks the
+// previous default; a plan stays default until another one takes over.
+func (s *Service) SavePlan(ctx context.Context, plan *Plan) (*Plan, error) {
+	if err := plan.Validate(); err != nil {
+		return nil, err
+	}
+	limits, _ := json.Marshal(plan.Limits)
+	features, _ := json.Marshal(plan.Features)
+
+	saved, err := scanPlan(s.db.QueryRow(ctx, `
+		INSERT INTO plans (id, name, limits, features, is_default, price_id)
+		VALUES ($1, $2, $3, $4, $5, NULLIF($6, ''))
+		ON CONFLICT (id) DO UPDATE SET
+			name = EXCLUDED.name,
+			limits = EXCLUDED.limits,
+			features = EXCLUDED.features,
+			is_default = plans.is_default OR EXCLUDED.is_default,
+			price_id = EXCLUDED.price_id,
+			updated_at = NOW()
+		RETURNING `+planColumns,
+		plan.ID, plan.Name, limits, features, plan.IsDefault, plan.PriceID))
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) && pgErr.Code == "23505" {
+		return nil, invalidf("price_id %s is already used by another plan", plan.PriceID)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to save plan %s: %w", plan.ID, err)
+	}
+
+	stale := []string{"plan:" + plan.ID, defaultPlanKey}
+	if plan.IsDefault {
+		rows, err := s.db.Query(ctx, "UPDATE plans SET is_default = FALSE WHERE is_default AND id <> $1 RETURNING id", plan.ID)
+		if err != nil {
+			return nil, fmt.Errorf("failed to update default plan: %w", err)
+		}
+		for rows.Next() {
+			var previous string
+			if err := rows.Scan(&previous); err == nil {
+				stale = append(stale, "plan:"+previous)
+			}
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return nil, fmt.Errorf("failed to update default plan: %w", err)
+		}
+	}
+
+	s.cacheDelete(ctx, stale...)
+
+	if err := s.enforcePlanLimits(ctx, plan.ID); err != nil {
+		return nil, err
+	}
+	return saved, nil
+}
+
+// DeletePlan removes a plan no organization is on. The default plan cannot
+// be deleted; mark another plan default first.
+func (s *Service) DeletePlan(ctx context.Context, id string) error {
+	plan, err := s.GetPlan(ctx, id)
+	if err != nil {
+		return err
+	}
+	if plan.IsDefault {
+		return ErrDefaultPlan
+	}
+
+	var inUse int
+	if err := s.db.QueryRow(ctx, "SELECT COUNT(*) FROM organizations WHERE plan = $1", id).Scan(&inUse); err != nil {
+		return fmt.Errorf("failed to check plan usage: %w", err)
+	}
+	if inUse > 0 {
+		return ErrPlanInUse
+	}
+
+	if err := s.db.Exec(ctx, "DELETE FROM plans WHERE id = $1", id); err != nil {
+		return fmt.Errorf("failed to delete plan %s: %w", id, err)
+	}
+	s.cacheDelete(ctx, "plan:"+id)
+	return nil
+}
+
+// PlanForPrice returns the plan sold under a billing provider price, or
+// ErrPlanNotFound.
+func (s *Service) PlanForPrice(ctx context.Context, priceID string) (*Plan, error) {
+	if priceID == "" {
+		return nil, ErrPlanNotFound
+	}
+	plan, err := scanPlan(s.db.QueryRow(ctx, "SELECT "+planColumns+" FROM plans WHERE price_id = $1", priceID))
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, ErrPlanNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to load plan for price %s: %w", priceID, err)
+	}
+	return plan, nil
+}
+
+// SetOrganizationPlan moves an organization to an existing plan and applies
+// the new plan's limits to what the organization already has.
+func (s *Service) SetOrganizationPlan(ctx context.Context, orgID, planID string) (*Enforcement, error) {
+	if _, err := s.GetPlan(ctx, planID); err != nil {
+		return nil, err
+	}
+
+	var updated string
+	err := s.db.QueryRow(ctx, "UPDATE organizations SET plan = $2 WHERE id = $1 RETURNING id", orgID, planID).Scan(&updated)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, ErrOrganizationNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to update organization plan: %w", err)
+	}
+	return s.EnforceLimits(ctx, orgID)
+}
+
+// cachedOverride lets the cache remember that an organization has no
+// override, which is the common case.
+type cachedOverride struct {
+	Found    bool      `json:"found"`
+	Override *Override `json:"override,omitempty"`
+}
+
+// GetOverride returns the organization's override, expired or not, or nil
+// if it has none.
+func (s *Service) GetOverride(ctx context.Context, orgID string) (*Override, error) {
+	key := "plan_override:" + orgID
+	var cached cachedOverride
+	if s.cacheGet(ctx, key, &cached) {
+		return cached.Override, nil
+	}
+
+	var o Override
+	var limits, features []byte
+	var note, updatedBy *string
+	err := s.db.QueryRow(ctx, `
+		SELECT organization_id, limits, features, note, expires_at, updated_by, created_at, updated_at
+		FROM organization_plan_overrides
+		WHERE organization_id = $1
+	`, orgID).Scan(&o.OrganizationID, &limits, &features, &note, &o.ExpiresAt, &updatedBy, &o.CreatedAt, &o.UpdatedAt)
+	if errors.Is(err, pgx.ErrNoRows) {
+		s.cacheSet(ctx, key, cachedOverride{})
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to load plan override: %w", err)
+	}
+	if err := json.Unmarshal(limits, &o.Limits); err != nil {
+		return nil, fmt.Errorf("invalid override limits: %w", err)
+	}
+	if err := json.Unmarshal(features, &o.Features); err != nil {
+		return nil, fmt.Errorf("invalid override features: %w", err)
+	}
+	if note != nil {
+		o.Note = *note
+	}
+	if updatedBy != nil {
+		o.UpdatedBy = *updatedBy
+	}
+
+	s.cacheSet(ctx, key, cachedOverride{Found: true, Override: &o})
+	return &o, nil
+}
+
+// SaveOverride creates or replaces an organization's override.
+func (s *Service) SaveOverride(ctx context.Context, o *Override) error {
+	if err := o.Validate(); err != nil {
+		return err
+	}
+	limits, _ := json.Marshal(o.Limits)
+	features, _ := json.Marshal(o.Features)
+
+	err := s.db.Exec(ctx, `
+		INSERT INTO organization_plan_overrides (organization_id, limits, features, note, expires_at, updated_by)
+		VALUES ($1, $2, $3, NULLIF($4, ''), $5, NULLIF($6, ''))
+		ON CONFLICT (organization_id) DO UPDATE SET
+			limits = EXCLUDED.limits,
+			features = EXCLUDED.features,
+			note = EXCLUDED.note,
+			expires_at = EXCLUDED.expires_at,
+			updated_by = EXCLUDED.updated_by,
+			updated_at = NOW()
+	`, o.OrganizationID, limits, features, o.Note, o.ExpiresAt, o.UpdatedBy)
+	if err != nil {
+		return fmt.Errorf("failed to save plan override: %w", err)
+	}
+	s.cacheDelete(ctx, "plan_override:"+o.OrganizationID)
+
+	_, err = s.EnforceLimits(ctx, o.OrganizationID)
+	return err
+}
+
+// DeleteOverride puts an organization back on its plan's terms.
+func (s *Service) DeleteOverride(ctx context.Context, orgID string) error {
+	if err := s.db.Exec(ctx, "DELETE FROM organization_plan_overrides WHERE organization_id = $1", orgID); err != nil {
+		return fmt.Errorf("failed to delete plan override: %w", err)
+	}
+	s.cacheDelete(ctx, "plan_override:"+orgID)
+
+	_, err := s.EnforceLimits(ctx, orgID)
+	if errors.Is(err, ErrOrganizationNotFound) {
+		return nil
+	}
+	return err
+}
+
+// The cache is best effort: without Redis, or when it fails, lookups go to
+// the database.
+
+func (s *Service) cacheGet(ctx context.Context, key string, dest interface{}) bool {
+	if s.redis == nil {
+		return false
+	}
+	return s.redis.Get(ctx, key, dest) == nil
+}
+
+func (s *Service) cacheSet(ctx context.Context, key string, value interface{}) {
+	if s.redis == nil {
+		return
+	}
+	if err := s.redis.Set(ctx, key, value, cacheTTL); err != nil {
+		log.Warn().Err(err).Str("cache_key", key).Msg("Failed to cache plan data")
+	}
+}
+
+func (s *Service) cacheDelete(ctx context.Context, keys ...string) {
+	if s.redis == nil {
+		return
+	}
+	if err := s.redis.Delete(ctx, keys...); err != nil {
+		log.Warn().Err(err).Strs("cache_keys", keys).Msg("Failed to invalidate plan cache")
+	}
+}
@@ -0,0 +1,114 @@
+package scim
+
+// The discovery documents of RFC 7644 section 4, describing what this
+// server supports to identity providers that ask.
+
+type object = map[string]interface{}
+
+// ServiceProviderConfig describes the supported features.
+func ServiceProviderConfig(baseURL string) interface{} {
+	return object{
+		"schemas":          []string{SchemaServiceProviderConfig},
+		"documentationUri": "https://datatracker.ietf.org/doc/html/rfc7644",
+		"patch":            object{"supported": true},
+		"bulk":             object{"supported": false, "maxOperations": 0, "maxPayloadSize": 0},
+		"filter":           object{"supported": true, "maxResults": MaxResults},
+		"changePassword":   object{"supported": false},
+		"sort":             object{"supported": false},
+		"etag":             object{"supported": false},
+		"authenticationSchemes": []object{{
+			"type":        "oauthbearertoken",
+			"name":        "Bearer token",
+			"description": "An organization SCIM token in the Authorization header",
+			"primary":     true,
+		}},
+		"meta": object{"resourceType": "ServiceProviderConfig", "location": baseURL + "/ServiceProviderConfig"},
+	}
+}
+
+// ResourceTypes lists the User and Group resource types.
+func ResourceTypes(baseURL string) []interface{} {
+	return []interface{}{
+		resourceType(baseURL, "User", "/Users", SchemaUser),
+		resourceType(baseURL, "Group", "/Groups", SchemaGroup),
+	}
+}
+
+func resourceType(baseURL, name, endpoint, schema string) object {
+	return object{
+		"schemas":  []string{SchemaResourceType},
+		"id":       name,
+		"name":     name,
+		"endpoint": endpoint,
+		"schema":   schema,
+		"meta":     object{"resourceType": "ResourceType", "location": baseURL + "/ResourceTypes/" + name},
+	}
+}
+
+// Schemas describes the attributes of users and groups that are stored;
+// others are accepted and ignored.
+func Schemas(baseURL string) []interface{} {
+	return []interface{}{
+		object{
+			"schemas":     []string{SchemaSchema},
+			"id":          SchemaUser,
+			"name":        "User",
+			"description": "Organization member",
+			"attributes": []object{
+				attribute("userName", "string", true, "readWrite", "server"),
+				attribute("externalId", "string", false, "readWrite", "none"),
+				complexAttribute("name", false, []object{
+					attribute("formatted", "string", false, "readWrite", "none"),
+					attribute("givenName", "string", false, "readWrite", "none"),
+					attribute("familyName", "string", false, "readWrite", "none"),
+				}),
+				attribute("displayName", "string", false, "readWrite", "none"),
+				multiValuedAttribute("emails", "readWrite"),
+				attribute("active", "boolean", false, "readWrite", "none"),
+				multiValuedAttribute("groups", "readOnly"),
+			},
+			"meta": object{"resourceType": "Schema", "location": baseURL + "/Schemas/" + SchemaUser},
+		},
+		object{
+			"schemas":     []string{SchemaSchema},
+			"id":          SchemaGroup,
+			"name":        "Group",
+			"description": "Group of organization members",
+			"attributes": []object{
+				attribute("displayName", "string", true, "readWrite", "server"),
+				attribute("externalId", "string", false, "readWrite", "none"),
+				multiValuedAttribute("members", "readWrite"),
+			},
+			"meta": object{"resourceType": "Schema", "location": baseURL + "/Schemas/" + SchemaGroup},
+		},
+	}
+}
+
+func attribute(name, kind string, required bool, mutability, uniqueness string) object {
+	return object{
+		"name":        name,
+		"type":        kind,
+		"multiValued": false,
+		"required":    required,
+		"caseExact":   false,
+		"mutability":  mutability,
+		"returned":    "default",
+		"uniqueness":  uniqueness,
+	}
+}
+
+func complexAttribute(name string, required bool, subAttributes []object) object {
+	a := attribute(name, "complex", required, "readWrite", "none")
+	a["subAttributes"] = subAttributes
+	return a
+}
+
+func multiValuedAttribute(name, mutability string) object {
+	a := complexAttribute(name, false, []object{
+		attribute("value", "string", false, mutability, "none"),
+		attribute("display", "string", false, "readOnly", "none"),
+	})
+	a["multiValued"] = true
+	a["mutability"] = mutability
+	return a
+}
@@ -0,0 +1,115 @@
+package scim
+
+import (
+	"encoding/json"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// MaxResults is the most resources a list returns in one page.
+const MaxResults = 200
+
+// Filter is an equality filter, the only kind identity providers send to
+// look up a resource before creating it: attribute eq "value".
+type Filter struct {
+	// Attribute is the lower-cased attribute path, without a schema URN
+	// and with value filters dropped: emails[type eq "work"].value becomes
+	// emails.value.
+	Attribute string
+	Value     string
+}
+
+// ParseFilter parses a filter query parameter. An empty filter returns nil.
+func ParseFilter(s string) (*Filter, error) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return nil, nil
+	}
+
+	unsupported := BadRequest(TypeInvalidFilter, "Only filters of the form 'attribute eq \"value\"' are supported")
+	attr, rest, ok := cutSpace(s)
+	if !ok {
+		return nil, unsupported
+	}
+	op, value, ok := cutSpace(rest)
+	if !ok || !strings.EqualFold(op, "eq") {
+		return nil, unsupported
+	}
+
+	var v interface{}
+	if err := json.Unmarshal([]byte(value), &v); err != nil {
+		return nil, unsupported
+	}
+	f := &Filter{Attribute: normalizeAttribute(attr)}
+	switch v := v.(type) {
+	case string:
+		f.Value = v
+	case bool:
+		f.Value = strconv.FormatBool(v)
+	default:
+		return nil, unsupported
+	}
+	return f, nil
+}
+
+// cutSpace splits s at its first space outside a value filter's brackets.
+func cutSpace(s string) (string, string, bool) {
+	depth := 0
+	for i, c := range s {
+		switch c {
+		case '[':
+			depth++
+		case ']':
+			depth--
+		case ' ':
+			if depth == 0 {
+				return s[:i], strings.TrimSpace(s[i+1:]), true
+			}
+		}
+	}
+	return s, "", false
+}
+
+func normalizeAttribute(attr string) string {
+	attr = strings.ToLower(attr)
+	for _, urn := range []string{SchemaUser, SchemaGroup} {
+		attr = strings.TrimPrefix(attr, strings.ToLower(urn)+":")
+	}
+	if open := strings.IndexByte(attr, '['); open >= 0 {
+		if end := strings.IndexByte(attr[open:], ']'); end >= 0 {
+			attr = attr[:open] + attr[open+end+1:]
+		}
+	}
+	return attr
+}
+
+// Page reads the startIndex and count query parameters. startIndex is
+// 1-based; count is capped at MaxResults and defaults to it.
+func Page(query url.Values) (startIndex, count int) {
+	startIndex, count = 1, MaxResults
+	if n, err := strconv.Atoi(query.Get("startIndex")); err == nil && n > 1 {
+		startIndex = n
+	}
+	if n, err := strconv.Atoi(query.Get("count")); err == nil {
+		count = n
+	}
+	if count < 0 {
+		count = 0
+	}
+	if count > MaxResults {
+		count = MaxResults
+	}
+	return startIndex, count
+}
+
+// Excludes reports whether the excludedAttributes query parameter lists
+// attr, as identity providers do to list groups without their members.
+func Excludes(query url.Values, attr string) bool {
+	for _, a := range strings.Split(query.Get("excludedAttributes"), ",") {
+		if normalizeAttribute(strings.TrimSpace(a)) == strings.ToLower(attr) {
+			return true
+		}
+	}
+	return false
+}
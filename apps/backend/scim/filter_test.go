@@ -0,0 +1,85 @@
+package scim
+
+import (
+	"errors"
+	"net/url"
+	"testing"
+)
+
+func TestParseFilter(t *testing.T) {
+	tests := []struct {
+		name   string
+		filter string
+		want   *Filter // nil with ok when there is no filter
+		ok     bool
+	}{
+		{"empty", "  ", nil, true},
+		{"userName", `userName eq "ada@example.com"`, &Filter{"username", "ada@example.com"}, true},
+		{"upper case", `USERNAME EQ "Ada"`, &Filter{"username", "Ada"}, true},
+		{"surrounding space", `  externalId eq "e1"  `, &Filter{"externalid", "e1"}, true},
+		{"schema URN", `urn:ietf:params:scim:schemas:core:2.0:User:userName eq "ada"`, &Filter{"username", "ada"}, true},
+		{"group schema URN", `urn:ietf:params:scim:schemas:core:2.0:Group:displayName eq "Eng"`, &Filter{"displayname", "Eng"}, true},
+		{"value filter", `emails[type eq "work"].value eq "ada@example.com"`, &Filter{"emails.value", "ada@example.com"}, true},
+		{"value with spaces", `displayName eq "Platform Engineering"`, &Filter{"displayname", "Platform Engineering"}, true},
+		{"escaped quotes", `displayName eq "the \"core\" team"`, &Filter{"displayname", `the "core" team`}, true},
+		{"boolean", `active eq true`, &Filter{"active", "true"}, true},
+
+		{"other operator", `userName co "ada"`, nil, false},
+		{"unquoted value", `userName eq ada`, nil, false},
+		{"number", `userName eq 5`, nil, false},
+		{"null", `userName eq null`, nil, false},
+		{"attribute only", `userName`, nil, false},
+		{"no value", `userName eq`, nil, false},
+		{"and", `userName eq "ada" and active eq true`, nil, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseFilter(tt.filter)
+			if !tt.ok {
+				var scimErr *Error
+				if !errors.As(err, &scimErr) || scimErr.Type != TypeInvalidFilter {
+					t.Fatalf("ParseFilter = %+v, %v; want an %s error", got, err, TypeInvalidFilter)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			switch {
+			case tt.want == nil && got != nil:
+				t.Errorf("ParseFilter = %+v, want nil", got)
+			case tt.want != nil && (got == nil || *got != *tt.want):
+				t.Errorf("ParseFilter = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPage(t *testing.T) {
+	tests := []struct {
+		query       string
+		start, want int
+	}{
+		{"", 1, MaxResults},
+		{"startIndex=0&count=10", 1, 10},
+		{"startIndex=21&count=-1", 21, 0},
+		{"startIndex=x&count=100000", 1, MaxResults},
+	}
+	for _, tt := range tests {
+		query, _ := url.ParseQuery(tt.query)
+		if start, count := Page(query); start != tt.start || count != tt.want {
+			t.Errorf("Page(%q) = %d, %d; want %d, %d", tt.query, start, count, tt.start, tt.want)
+		}
+	}
+}
+
+func TestExcludes(t *testing.T) {
+	query := url.Values{"excludedAttributes": {"urn:ietf:params:scim:schemas:core:2.0:Group:members, meta"}}
+	if !Excludes(query, "members") || !Excludes(query, "meta") {
+		t.Error("listed attributes are not excluded")
+	}
+	if Excludes(query, "displayName") {
+		t.Error("displayName is excluded")
+	}
+}
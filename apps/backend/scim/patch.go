@@ -0,0 +1,147 @@
+package scim
+
+import (
+	"encoding/json"
+	"strings"
+)
+
+// PATCH operations.
+const (
+	OpAdd     = "add"
+	OpReplace = "replace"
+	OpRemove  = "remove"
+)
+
+// PatchRequest is the body of a PATCH request.
+type PatchRequest struct {
+	Schemas    []string         `json:"schemas"`
+	Operations []PatchOperation `json:"Operations"`
+}
+
+// PatchOperation is one change of a PATCH request.
+type PatchOperation struct {
+	Op    string          `json:"op"`
+	Path  string          `json:"path,omitempty"`
+	Value json.RawMessage `json:"value,omitempty"`
+}
+
+// Validate lower-cases the operations' names, which some identity
+// providers capitalize, and checks they are known.
+func (p *PatchRequest) Validate() error {
+	if len(p.Operations) == 0 {
+		return BadRequest(TypeInvalidSyntax, "PATCH request has no Operations")
+	}
+	for i := range p.Operations {
+		op := &p.Operations[i]
+		op.Op = strings.ToLower(op.Op)
+		if op.Op != OpAdd && op.Op != OpReplace && op.Op != OpRemove {
+			return BadRequest(TypeInvalidSyntax, "Unknown PATCH operation %q", op.Op)
+		}
+		if op.Op == OpRemove && op.Path == "" {
+			return BadRequest(TypeNoTarget, "A remove operation needs a path")
+		}
+	}
+	return nil
+}
+
+// Attribute is the operation's lower-cased path without value filters, or
+// "" when it has none.
+func (o *PatchOperation) Attribute() string {
+	return normalizeAttribute(o.Path)
+}
+
+// Values returns the attributes the operation sets, keyed by lower-cased
+// path: its path and value, or each attribute of its value when it has no
+// path. Sub-attributes of a complex value are keyed "name.givenname" and
+// so on.
+func (o *PatchOperation) Values() (map[string]json.RawMessage, error) {
+	if o.Path != "" {
+		return map[string]json.RawMessage{o.Attribute(): o.Value}, nil
+	}
+	var object map[string]json.RawMessage
+	if err := json.Unmarshal(o.Value, &object); err != nil {
+		return nil, BadRequest(TypeInvalidValue, "An operation without a path needs an object value")
+	}
+	values := make(map[string]json.RawMessage, len(object))
+	for key, value := range object {
+		key = normalizeAttribute(key)
+		var sub map[string]json.RawMessage
+		if key == "name" && json.Unmarshal(value, &sub) == nil {
+			for subKey, subValue := range sub {
+				values["name."+strings.ToLower(subKey)] = subValue
+			}
+			continue
+		}
+		values[key] = value
+	}
+	return values, nil
+}
+
+// MemberFilter returns the member ID of a path such as
+// members[value eq "id"], which removes one member.
+func (o *PatchOperation) MemberFilter() (string, bool) {
+	open, end := strings.IndexByte(o.Path, '['), strings.LastIndexByte(o.Path, ']')
+	if open < 0 || end < open || !strings.EqualFold(strings.TrimSpace(o.Path[:open]), "members") {
+		return "", false
+	}
+	f, err := ParseFilter(o.Path[open+1 : end])
+	if err != nil || f == nil || f.Attribute != "value" {
+		return "", false
+	}
+	return f.Value, true
+}
+
+// ParseBool reads a boolean value. Some identity providers send booleans
+// as the strings "True" and "False".
+func ParseBool(raw json.RawMessage) (bool, error) {
+	var b bool
+	if err := json.Unmarshal(raw, &b); err == nil {
+		return b, nil
+	}
+	var s string
+	if err := json.Unmarshal(raw, &s); err == nil {
+		switch strings.ToLower(s) {
+		case "true":
+			return true, nil
+		case "false":
+			return false, nil
+		}
+	}
+	return false, BadRequest(TypeInvalidValue, "Expected a boolean, got %s", string(raw))
+}
+
+// ParseString reads a string value; null reads as "".
+func ParseString(raw json.RawMessage) (string, error) {
+	if len(raw) == 0 || string(raw) == "null" {
+		return "", nil
+	}
+	var s string
+	if err := json.Unmarshal(raw, &s); err != nil {
+		return "", BadRequest(TypeInvalidValue, "Expected a string, got %s", string(raw))
+	}
+	return s, nil
+}
+
+// ParseMembers reads the IDs of a members value: a list of {"value": id}
+// objects, or one such object.
+func ParseMembers(raw json.RawMessage) ([]string, error) {
+	if len(raw) == 0 || string(raw) == "null" {
+		return nil, nil
+	}
+	var list []Value
+	if err := json.Unmarshal(raw, &list); err != nil {
+		var one Value
+		if err := json.Unmarshal(raw, &one); err != nil {
+			return nil, BadRequest(TypeInvalidValue, "Expected a list of members")
+		}
+		list = []Value{one}
+	}
+	ids := make([]string, 0, len(list))
+	for _, m := range list {
+		if m.Value == "" {
+			return nil, BadRequest(TypeInvalidValue, "Every member needs a value")
+		}
+		ids = append(ids, m.Value)
+	}
+	return ids, nil
+}
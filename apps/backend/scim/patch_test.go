@@ -0,0 +1,150 @@
+package scim
+
+import (
+	"encoding/json"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestPatchRequestValidate(t *testing.T) {
+	tests := []struct {
+		name string
+		ops  []PatchOperation
+		typ  string // empty when valid
+	}{
+		{"replace", []PatchOperation{{Op: "replace", Path: "active", Value: json.RawMessage(`false`)}}, ""},
+		{"capitalized", []PatchOperation{{Op: "Replace", Path: "active"}, {Op: "ADD", Path: "members"}}, ""},
+		{"remove with path", []PatchOperation{{Op: "remove", Path: `members[value eq "u1"]`}}, ""},
+		{"no operations", nil, TypeInvalidSyntax},
+		{"unknown operation", []PatchOperation{{Op: "move", Path: "active"}}, TypeInvalidSyntax},
+		{"remove without path", []PatchOperation{{Op: "remove"}}, TypeNoTarget},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := PatchRequest{Operations: tt.ops}
+			err := p.Validate()
+			if tt.typ == "" {
+				if err != nil {
+					t.Fatal(err)
+				}
+				for _, op := range p.Operations {
+					if op.Op != OpAdd && op.Op != OpReplace && op.Op != OpRemove {
+						t.Errorf("op %q was not lower-cased", op.Op)
+					}
+				}
+				return
+			}
+			var scimErr *Error
+			if !errors.As(err, &scimErr) || scimErr.Type != tt.typ {
+				t.Errorf("Validate = %v, want a %s error", err, tt.typ)
+			}
+		})
+	}
+}
+
+func TestPatchOperationPaths(t *testing.T) {
+	tests := []struct {
+		path      string
+		attribute string
+		member    string // empty when the path names no single member
+	}{
+		{"", "", ""},
+		{"active", "active", ""},
+		{"name.givenName", "name.givenname", ""},
+		{"urn:ietf:params:scim:schemas:core:2.0:User:userName", "username", ""},
+		{`emails[type eq "work"].value`, "emails.value", ""},
+		{"members", "members", ""},
+		{`members[value eq "u1"]`, "members", "u1"},
+		{`Members[ value EQ "u 2" ]`, "members", "u 2"},
+		{`members[display eq "Ada"]`, "members", ""},
+		{`members[value co "u"]`, "members", ""},
+		{`emails[value eq "u1"]`, "emails", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			op := PatchOperation{Op: OpRemove, Path: tt.path}
+			if got := op.Attribute(); got != tt.attribute {
+				t.Errorf("Attribute = %q, want %q", got, tt.attribute)
+			}
+			member, ok := op.MemberFilter()
+			if member != tt.member || ok != (tt.member != "") {
+				t.Errorf("MemberFilter = %q, %v; want %q", member, ok, tt.member)
+			}
+		})
+	}
+}
+
+func TestPatchOperationValues(t *testing.T) {
+	tests := []struct {
+		name string
+		op   PatchOperation
+		want map[string]string // nil when the operation is rejected
+	}{
+		{"path", PatchOperation{Path: "name.familyName", Value: json.RawMessage(`"Lovelace"`)},
+			map[string]string{"name.familyname": `"Lovelace"`}},
+		{"no path", PatchOperation{Value: json.RawMessage(`{"active": false, "displayName": "Ada"}`)},
+			map[string]string{"active": `false`, "displayname": `"Ada"`}},
+		{"no path with name", PatchOperation{Value: json.RawMessage(`{"name": {"givenName": "Ada", "familyName": "Lovelace"}}`)},
+			map[string]string{"name.givenname": `"Ada"`, "name.familyname": `"Lovelace"`}},
+		{"no path with schema URN", PatchOperation{Value: json.RawMessage(`{"urn:ietf:params:scim:schemas:core:2.0:User:userName": "ada"}`)},
+			map[string]string{"username": `"ada"`}},
+		{"no path, not an object", PatchOperation{Value: json.RawMessage(`false`)}, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			values, err := tt.op.Values()
+			if tt.want == nil {
+				if err == nil {
+					t.Fatalf("accepted as %v", values)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			got := map[string]string{}
+			for k, v := range values {
+				got[k] = string(v)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Values = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseValues(t *testing.T) {
+	for raw, want := range map[string]bool{`true`: true, `"True"`: true, `"false"`: false, `false`: false} {
+		if got, err := ParseBool(json.RawMessage(raw)); err != nil || got != want {
+			t.Errorf("ParseBool(%s) = %v, %v", raw, got, err)
+		}
+	}
+	if _, err := ParseBool(json.RawMessage(`"yes"`)); err == nil {
+		t.Error(`ParseBool accepted "yes"`)
+	}
+
+	if s, err := ParseString(json.RawMessage(`null`)); err != nil || s != "" {
+		t.Errorf("ParseString(null) = %q, %v", s, err)
+	}
+	if _, err := ParseString(json.RawMessage(`1`)); err == nil {
+		t.Error("ParseString accepted a number")
+	}
+
+	members := map[string][]string{
+		`[{"value": "u1"}, {"value": "u2"}]`: {"u1", "u2"},
+		`{"value": "u1"}`:                    {"u1"},
+		`null`:                               nil,
+	}
+	for raw, want := range members {
+		if got, err := ParseMembers(json.RawMessage(raw)); err != nil || !reflect.DeepEqual(got, want) {
+			t.Errorf("ParseMembers(%s) = %v, %v", raw, got, err)
+		}
+	}
+	if _, err := ParseMembers(json.RawMessage(`[{"display": "Ada"}]`)); err == nil {
+		t.Error("ParseMembers accepted a member without a value")
+	}
+}
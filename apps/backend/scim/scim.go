@@ -0,0 +1,192 @@
+// Package scim speaks the SCIM 2.0 protocol (RFC 7643 and RFC 7644): the
+// User and Group resources, list responses, errors, filters and PATCH
+// operations. What users and groups map to is left to the handlers.
+package scim
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// Schema URNs.
+const (
+	SchemaUser                  = "urn:ietf:params:scim:schemas:core:2.0:User"
+	SchemaGroup                 = "urn:ietf:params:scim:schemas:core:2.0:Group"
+	SchemaServiceProviderConfig = "urn:ietf:params:scim:schemas:core:2.0:ServiceProviderConfig"
+	SchemaResourceType          = "urn:ietf:params:scim:schemas:core:2.0:ResourceType"
+	SchemaSchema                = "urn:ietf:params:scim:schemas:core:2.0:Schema"
+	SchemaListResponse          = "urn:ietf:params:scim:api:messages:2.0:ListResponse"
+	SchemaPatchOp               = "urn:ietf:params:scim:api:messages:2.0:PatchOp"
+	SchemaError                 = "urn:ietf:params:scim:api:messages:2.0:Error"
+)
+
+// ContentType is the media type of SCIM requests and responses.
+const ContentType = "application/scim+json"
+
+// Error types (scimType) for 400 and 409 responses.
+const (
+	TypeInvalidFilter = "invalidFilter"
+	TypeInvalidSyntax = "invalidSyntax"
+	TypeInvalidPath   = "invalidPath"
+	TypeInvalidValue  = "invalidValue"
+	TypeMutability    = "mutability"
+	TypeUniqueness    = "uniqueness"
+	TypeNoTarget      = "noTarget"
+)
+
+// Error is a refused SCIM request, written as a SCIM error response.
+type Error struct {
+	Status int
+	Type   string
+	Detail string
+}
+
+func (e *Error) Error() string {
+	return e.Detail
+}
+
+// Errorf returns an Error with a formatted detail. scimType may be empty.
+func Errorf(status int, scimType, format string, args ...interface{}) *Error {
+	return &Error{Status: status, Type: scimType, Detail: fmt.Sprintf(format, args...)}
+}
+
+// BadRequest returns a 400 Error of the given type.
+func BadRequest(scimType, format string, args ...interface{}) *Error {
+	return Errorf(http.StatusBadRequest, scimType, format, args...)
+}
+
+// NotFound returns a 404 Error.
+func NotFound(format string, args ...interface{}) *Error {
+	return Errorf(http.StatusNotFound, "", format, args...)
+}
+
+// WriteError writes e as a SCIM error response.
+func WriteError(w http.ResponseWriter, e *Error) {
+	body := map[string]interface{}{
+		"schemas": []string{SchemaError},
+		"status":  strconv.Itoa(e.Status),
+		"detail":  e.Detail,
+	}
+	if e.Type != "" {
+		body["scimType"] = e.Type
+	}
+	WriteJSON(w, e.Status, body)
+}
+
+// WriteJSON writes v as a SCIM response.
+func WriteJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", ContentType)
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+// Meta is the metadata every resource carries.
+type Meta struct {
+	ResourceType string     `json:"resourceType"`
+	Created      time.Time  `json:"created"`
+	LastModified *time.Time `json:"lastModified,omitempty"`
+	Location     string     `json:"location"`
+}
+
+// Name is a user's name.
+type Name struct {
+	Formatted  string `json:"formatted,omitempty"`
+	GivenName  string `json:"givenName,omitempty"`
+	FamilyName string `json:"familyName,omitempty"`
+}
+
+// String is the full name: Formatted, or else the given and family names.
+func (n *Name) String() string {
+	if n == nil {
+		return ""
+	}
+	if n.Formatted != "" {
+		return n.Formatted
+	}
+	if n.GivenName != "" && n.FamilyName != "" {
+		return n.GivenName + " " + n.FamilyName
+	}
+	return n.GivenName + n.FamilyName
+}
+
+// Value is an entry of a multi-valued attribute such as emails, groups or
+// members.
+type Value struct {
+	Value   string `json:"value"`
+	Display string `json:"display,omitempty"`
+	Type    string `json:"type,omitempty"`
+	Primary bool   `json:"primary,omitempty"`
+	Ref     string `json:"$ref,omitempty"`
+}
+
+// User is the core User resource.
+type User struct {
+	Schemas     []string `json:"schemas"`
+	ID          string   `json:"id,omitempty"`
+	ExternalID  string   `json:"externalId,omitempty"`
+	UserName    string   `json:"userName"`
+	Name        *Name    `json:"name,omitempty"`
+	DisplayName string   `json:"displayName,omitempty"`
+	Emails      []Value  `json:"emails,omitempty"`
+	// Active is a pointer so a request leaving it out can be told apart
+	// from one setting it false.
+	Active *bool   `json:"active,omitempty"`
+	Groups []Value `json:"groups,omitempty"`
+	Meta   *Meta   `json:"meta,omitempty"`
+}
+
+// Email is the address a user is known by: the primary email, or else the
+// first one, or else the userName.
+func (u *User) Email() string {
+	for _, e := range u.Emails {
+		if e.Primary && e.Value != "" {
+			return e.Value
+		}
+	}
+	if len(u.Emails) > 0 && u.Emails[0].Value != "" {
+		return u.Emails[0].Value
+	}
+	return u.UserName
+}
+
+// FullName is the user's display name, or else their formatted name.
+func (u *User) FullName() string {
+	if u.DisplayName != "" {
+		return u.DisplayName
+	}
+	return u.Name.String()
+}
+
+// Group is the core Group resource.
+type Group struct {
+	Schemas     []string `json:"schemas"`
+	ID          string   `json:"id,omitempty"`
+	ExternalID  string   `json:"externalId,omitempty"`
+	DisplayName string   `json:"displayName"`
+	Members     []Value  `json:"members,omitempty"`
+	Meta        *Meta    `json:"meta,omitempty"`
+}
+
+// ListResponse is a page of resources.
+type ListResponse struct {
+	Schemas      []string    `json:"schemas"`
+	TotalResults int         `json:"totalResults"`
+	StartIndex   int         `json:"startIndex"`
+	ItemsPerPage int         `json:"itemsPerPage"`
+	Resources    interface{} `json:"Resources"`
+}
+
+// NewListResponse returns the page of total resources starting at the
+// 1-based startIndex.
+func NewListResponse(resources interface{}, count, total, startIndex int) *ListResponse {
+	return &ListResponse{
+		Schemas:      []string{SchemaListResponse},
+		TotalResults: total,
+		StartIndex:   startIndex,
+		ItemsPerPage: count,
+		Resources:    resources,
+	}
+}
@@ -0,0 +1,145 @@
+package sqltools
+
+import (
+	"strings"
+)
+
+// Catalog describes the objects visible in a database, as far as completion
+// and linting need to know.
+type Catalog struct {
+	Tables    []CatalogTable    `json:"tables"`
+	Functions []CatalogFunction `json:"functions,omitempty"`
+}
+
+type CatalogTable struct {
+	Schema  string          `json:"schema"`
+	Name    string          `json:"name"`
+	IsView  bool            `json:"is_view,omitempty"`
+	Columns []CatalogColumn `json:"columns"`
+}
+
+type CatalogColumn struct {
+	Name string `json:"name"`
+	Type string `json:"type"`
+}
+
+type CatalogFunction struct {
+	Schema string `json:"schema"`
+	Name   string `json:"name"`
+}
+
+// DefaultSchema is the schema unqualified names resolve to first.
+const DefaultSchema = "public"
+
+// Schemas returns the distinct schema names in the catalog.
+func (c *Catalog) Schemas() []string {
+	seen := make(map[string]bool)
+	var schemas []string
+	for _, t := range c.Tables {
+		if !seen[t.Schema] {
+			seen[t.Schema] = true
+			schemas = append(schemas, t.Schema)
+		}
+	}
+	return schemas
+}
+
+// HasSchema reports whether any table lives in the named schema.
+func (c *Catalog) HasSchema(name string) bool {
+	for _, t := range c.Tables {
+		if t.Schema == name {
+			return true
+		}
+	}
+	return false
+}
+
+// FindTable resolves a possibly schema-qualified table name. Unqualified names
+// prefer the default schema, then any schema with a unique match.
+func (c *Catalog) FindTable(schema, name string) *CatalogTable {
+	if schema != "" {
+		for i := range c.Tables {
+			if c.Tables[i].Schema == schema && c.Tables[i].Name == name {
+				return &c.Tables[i]
+			}
+		}
+		return nil
+	}
+
+	var found *CatalogTable
+	for i := range c.Tables {
+		if c.Tables[i].Name != name {
+			continue
+		}
+		if c.Tables[i].Schema == DefaultSchema {
+			return &c.Tables[i]
+		}
+		if found == nil {
+			found = &c.Tables[i]
+		}
+	}
+	return found
+}
+
+// TablesInSchema returns the tables of one schema.
+func (c *Catalog) TablesInSchema(schema string) []CatalogTable {
+	var tables []CatalogTable
+	for _, t := range c.Tables {
+		if t.Schema == schema {
+			tables = append(tables, t)
+		}
+	}
+	return tables
+}
+
+// HasFunction reports whether name is a known built-in or user-defined function.
+func (c *Catalog) HasFunction(name string) bool {
+	for _, f := range builtinFunctions {
+		if f == name {
+			return true
+		}
+	}
+	for _, f := range c.Functions {
+		if f.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// Column returns the named column of the table, or nil.
+func (t *CatalogTable) Column(name string) *CatalogColumn {
+	for i := range t.Columns {
+		if t.Columns[i].Name == name {
+			return &t.Columns[i]
+		}
+	}
+	return nil
+}
+
+// QualifiedName returns schema.name, omitting the default schema.
+func (t *CatalogTable) QualifiedName() string {
+	if t.Schema == DefaultSchema || t.Schema == "" {
+		return t.Name
+	}
+	return t.Schema + "." + t.Name
+}
+
+// quoteIdentIfNeeded returns name as it must be written in SQL.
+func quoteIdentIfNeeded(name string) string {
+	if name == "" {
+		return `""`
+	}
+	needsQuote := IsKeyword(name)
+	for i, r := range name {
+		lowerLetter := r >= 'a' && r <= 'z'
+		if !(lowerLetter || r == '_' || (i > 0 && (r >= '0' && r <= '9' || r == '$'))) {
+			needsQuote = true
+			break
+		}
+	}
+	if !needsQuote {
+		return name
+	}
+	return `"` + strings.ReplaceAll(name, `"`, `""`) + `"`
+}
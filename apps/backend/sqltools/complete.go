@@ -0,0 +1,357 @@
+package sqltools
+
+import (
+	"sort"
+	"strings"
+	"unicode/utf8"
+)
+
+const maxCompletions = 100
+
+// Completion is a single suggestion for the editor.
+type Completion struct {
+	Label      string `json:"label"`
+	Kind       string `json:"kind"`
+	Detail     string `json:"detail,omitempty"`
+	InsertText string `json:"insert_text"`
+}
+
+// CompletionResult lists suggestions and the character range they replace.
+type CompletionResult struct {
+	Completions  []Completion `json:"completions"`
+	ReplaceStart int          `json:"replace_start"`
+	ReplaceEnd   int          `json:"replace_end"`
+}
+
+var kindOrder = map[string]int{
+	"column": 0, "alias": 1, "table": 2, "view": 3, "schema": 4, "function": 5, "keyword": 6,
+}
+
+// Complete returns context-aware suggestions for the cursor at charOffset
+// (counted in characters, as editors report it).
+func Complete(sql string, charOffset int, cat *Catalog) CompletionResult {
+	if cat == nil {
+		cat = &Catalog{}
+	}
+	offset := ByteOffset(sql, charOffset)
+	result := CompletionResult{Completions: []Completion{}, ReplaceStart: charOffset, ReplaceEnd: charOffset}
+
+	if insideLiteralOrComment(sql, offset) {
+		return result
+	}
+
+	a := Analyze(sql, cat)
+	toks := a.Tokens
+
+	// Find the word being typed and the token before it.
+	prev := -1
+	prefix := ""
+	for i, t := range toks {
+		if t.Start >= offset {
+			break
+		}
+		if t.End >= offset && (t.IsName() || t.Kind == TokenKeyword) {
+			prefix = sql[t.Start:offset]
+			result.ReplaceStart = CharOffset(sql, t.Start)
+			break
+		}
+		prev = i
+	}
+	prefix = strings.ToLower(strings.TrimPrefix(prefix, `"`))
+
+	blk := a.BlockAt(offset)
+	var items []Completion
+
+	switch {
+	case prev >= 1 && toks[prev].IsPunct(".") && toks[prev-1].IsName():
+		items = a.qualifiedCompletions(blk, prev)
+
+	case prev < 0:
+		items = keywordCompletions()
+
+	case expectsTable(toks[prev], a.clauseOf[prev]):
+		items = append(tableCompletions(cat, blk), schemaCompletions(cat)...)
+
+	case expectsExpression(a.clauseOf[prev]) && !a.ignore[prev]:
+		items = append(items, columnCompletions(blk)...)
+		items = append(items, bindingCompletions(blk)...)
+		if len(blk.Refs) == 0 {
+			items = append(items, tableCompletions(cat, blk)...)
+		}
+		items = append(items, functionCompletions(cat)...)
+		items = append(items, keywordCompletions()...)
+
+	default:
+		items = keywordCompletions()
+	}
+
+	for _, item := range items {
+		if prefix == "" || strings.HasPrefix(strings.ToLower(item.Label), prefix) {
+			result.Completions = append(result.Completions, item)
+		}
+	}
+
+	sort.SliceStable(result.Completions, func(i, j int) bool {
+		ci, cj := result.Completions[i], result.Completions[j]
+		if kindOrder[ci.Kind] != kindOrder[cj.Kind] {
+			return kindOrder[ci.Kind] < kindOrder[cj.Kind]
+		}
+		return ci.Label < cj.Label
+	})
+	result.Completions = dedupeCompletions(result.Completions)
+	if len(result.Completions) > maxCompletions {
+		result.Completions = result.Completions[:maxCompletions]
+	}
+	return result
+}
+
+// qualifiedCompletions handles "qualifier.|": columns of an alias or table,
+// or tables of a schema.
+func (a *Analysis) qualifiedCompletions(blk *Block, dot int) []Completion {
+	toks := a.Tokens
+	qualifier := toks[dot-1].Name()
+
+	// schema.table.|
+	if dot >= 3 && toks[dot-2].IsPunct(".") && toks[dot-3].IsName() {
+		if table := a.catalog.FindTable(toks[dot-3].Name(), qualifier); table != nil {
+			return tableColumnCompletions(table, "")
+		}
+		return nil
+	}
+
+	// While typing "FROM schema." the analysis reads the schema as a table name.
+	if ref := blk.Lookup(qualifier); ref != nil && ref.Start != toks[dot-1].Start {
+		if ref.Known() {
+			return tableColumnCompletions(ref.Table, "")
+		}
+		return nil
+	}
+
+	var items []Completion
+	if table := a.catalog.FindTable("", qualifier); table != nil {
+		items = append(items, tableColumnCompletions(table, "")...)
+	}
+	if a.catalog.HasSchema(qualifier) {
+		for _, t := range a.catalog.TablesInSchema(qualifier) {
+			items = append(items, tableCompletion(t, quoteIdentIfNeeded(t.Name)))
+		}
+		for _, f := range a.catalog.Functions {
+			if f.Schema == qualifier {
+				items = append(items, Completion{Label: f.Name, Kind: "function", Detail: f.Schema, InsertText: quoteIdentIfNeeded(f.Name) + "("})
+			}
+		}
+	}
+	return items
+}
+
+func expectsTable(prev Token, clause string) bool {
+	switch {
+	case prev.Is("FROM"), prev.Is("JOIN"), prev.Is("UPDATE"), prev.Is("INTO"),
+		prev.Is("TABLE"), prev.Is("ONLY"), prev.Is("LATERAL"):
+		return true
+	case prev.IsPunct(","):
+		return clause == "FROM"
+	}
+	return false
+}
+
+func expectsExpression(clause string) bool {
+	switch clause {
+	case "SELECT", "WHERE", "ON", "GROUP", "ORDER", "HAVING", "SET", "RETURNING", "VALUES":
+		return true
+	}
+	return false
+}
+
+func columnCompletions(blk *Block) []Completion {
+	counts := make(map[string]int)
+	var refs []TableRef
+	for b := blk; b != nil; b = b.Parent {
+		for _, ref := range b.Refs {
+			if !ref.Known() {
+				continue
+			}
+			refs = append(refs, ref)
+			for _, col := range ref.Table.Columns {
+				counts[col.Name]++
+			}
+		}
+	}
+
+	var items []Completion
+	for _, ref := range refs {
+		for _, col := range ref.Table.Columns {
+			qualifier := ""
+			if counts[col.Name] > 1 {
+				qualifier = quoteIdentIfNeeded(ref.Binding())
+			}
+			items = append(items, columnCompletion(ref.Binding(), col, qualifier))
+		}
+	}
+	return items
+}
+
+func tableColumnCompletions(table *CatalogTable, qualifier string) []Completion {
+	items := make([]Completion, 0, len(table.Columns))
+	for _, col := range table.Columns {
+		items = append(items, columnCompletion(table.Name, col, qualifier))
+	}
+	return items
+}
+
+func columnCompletion(owner string, col CatalogColumn, qualifier string) Completion {
+	insert := quoteIdentIfNeeded(col.Name)
+	if qualifier != "" {
+		insert = qualifier + "." + insert
+	}
+	return Completion{
+		Label:      col.Name,
+		Kind:       "column",
+		Detail:     owner + "." + col.Name + " " + col.Type,
+		InsertText: insert,
+	}
+}
+
+func bindingCompletions(blk *Block) []Completion {
+	var items []Completion
+	for b := blk; b != nil; b = b.Parent {
+		for _, ref := range b.Refs {
+			if ref.Alias == "" {
+				continue
+			}
+			detail := ref.Name
+			if ref.Kind == RefDerived {
+				detail = "subquery"
+			}
+			items = append(items, Completion{Label: ref.Alias, Kind: "alias", Detail: detail, InsertText: quoteIdentIfNeeded(ref.Alias)})
+		}
+	}
+	return items
+}
+
+func tableCompletions(cat *Catalog, blk *Block) []Completion {
+	var items []Completion
+	for b := blk; b != nil; b = b.Parent {
+		for name := range b.CTEs {
+			items = append(items, Completion{Label: name, Kind: "table", Detail: "CTE", InsertText: quoteIdentIfNeeded(name)})
+		}
+	}
+	for _, t := range cat.Tables {
+		insert := quoteIdentIfNeeded(t.Name)
+		if t.Schema != DefaultSchema {
+			insert = quoteIdentIfNeeded(t.Schema) + "." + insert
+		}
+		items = append(items, tableCompletion(t, insert))
+	}
+	return items
+}
+
+func tableCompletion(t CatalogTable, insert string) Completion {
+	kind := "table"
+	if t.IsView {
+		kind = "view"
+	}
+	return Completion{Label: t.Name, Kind: kind, Detail: t.Schema + "." + t.Name, InsertText: insert}
+}
+
+func schemaCompletions(cat *Catalog) []Completion {
+	var items []Completion
+	for _, s := range cat.Schemas() {
+		items = append(items, Completion{Label: s, Kind: "schema", InsertText: quoteIdentIfNeeded(s) + "."})
+	}
+	return items
+}
+
+func functionCompletions(cat *Catalog) []Completion {
+	items := make([]Completion, 0, len(builtinFunctions)+len(cat.Functions))
+	for _, f := range builtinFunctions {
+		items = append(items, Completion{Label: f, Kind: "function", Detail: "built-in", InsertText: f + "("})
+	}
+	for _, f := range cat.Functions {
+		insert := quoteIdentIfNeeded(f.Name) + "("
+		if f.Schema != DefaultSchema {
+			insert = quoteIdentIfNeeded(f.Schema) + "." + insert
+		}
+		items = append(items, Completion{Label: f.Name, Kind: "function", Detail: f.Schema, InsertText: insert})
+	}
+	return items
+}
+
+func keywordCompletions() []Completion {
+	items := make([]Completion, 0, len(keywordList))
+	for _, k := range keywordList {
+		items = append(items, Completion{Label: k, Kind: "keyword", InsertText: k})
+	}
+	return items
+}
+
+func dedupeCompletions(items []Completion) []Completion {
+	seen := make(map[string]bool, len(items))
+	out := items[:0]
+	for _, item := range items {
+		key := item.Kind + "\x00" + item.InsertText + "\x00" + item.Detail
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+		out = append(out, item)
+	}
+	return out
+}
+
+// insideLiteralOrComment reports whether the byte offset falls inside a
+// string literal or comment, where completions make no sense.
+func insideLiteralOrComment(sql string, offset int) bool {
+	for _, t := range Tokenize(sql) {
+		if t.Start >= offset {
+			return false
+		}
+		if t.Kind != TokenString && t.Kind != TokenComment {
+			continue
+		}
+		if offset < t.End {
+			return true
+		}
+		if offset == t.End && !literalClosed(t) {
+			return true
+		}
+	}
+	return false
+}
+
+func literalClosed(t Token) bool {
+	switch {
+	case strings.HasPrefix(t.Text, "--"):
+		return false
+	case strings.HasPrefix(t.Text, "/*"):
+		return strings.HasSuffix(t.Text, "*/") && len(t.Text) >= 4
+	case strings.HasPrefix(t.Text, "$"):
+		tag := t.Text[:strings.IndexByte(t.Text[1:], '$')+2]
+		return len(t.Text) >= 2*len(tag) && strings.HasSuffix(t.Text, tag)
+	default:
+		return len(t.Text) >= 2 && strings.HasSuffix(t.Text, "'") && !strings.HasSuffix(t.Text, `\'`)
+	}
+}
+
+// ByteOffset converts a character offset into a byte offset within s.
+func ByteOffset(s string, charOffset int) int {
+	if charOffset <= 0 {
+		return 0
+	}
+	n := 0
+	for i := range s {
+		if n == charOffset {
+			return i
+		}
+		n++
+	}
+	return len(s)
+}
+
+// CharOffset converts a byte offset within s into a character offset.
+func CharOffset(s string, byteOffset int) int {
+	if byteOffset > len(s) {
+		byteOffset = len(s)
+	}
+	return utf8.RuneCountInString(s[:byteOffset])
+}
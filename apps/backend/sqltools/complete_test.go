@@ -0,0 +1,67 @@
+package sqltools
+
+import (
+	"strings"
+	"testing"
+)
+
+func labels(result CompletionResult, kind string) []string {
+	var out []string
+	for _, c := range result.Completions {
+		if c.Kind == kind {
+			out = append(out, c.Label)
+		}
+	}
+	return out
+}
+
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+func TestComplete(t *testing.T) {
+	tests := []struct {
+		name    string
+		sql     string // | marks the cursor
+		kind    string
+		want    []string
+		notWant []string
+	}{
+		{"tables after FROM", `select * from |`, "table", []string{"users", "orders"}, nil},
+		{"tables by prefix", `select * from or|`, "table", []string{"orders"}, []string{"users"}},
+		{"columns of an alias", `select o.| from orders o`, "column", []string{"id", "user_id", "total"}, []string{"email"}},
+		{"columns in scope", `select | from users`, "column", []string{"email", "password_hash"}, []string{"total"}},
+		{"quoted prefix", `select * from "or|`, "table", []string{"orders"}, []string{"users"}},
+		{"lone quote", `select * from "|`, "table", []string{"orders", "users"}, nil},
+		{"unterminated quoted table", `select "| from "users`, "column", nil, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cursor := strings.Index(tt.sql, "|")
+			sql := tt.sql[:cursor] + tt.sql[cursor+1:]
+			got := labels(Complete(sql, CharOffset(sql, cursor), testPolicyCatalog()), tt.kind)
+			for _, w := range tt.want {
+				if !contains(got, w) {
+					t.Errorf("%s completions %v lack %q", tt.kind, got, w)
+				}
+			}
+			for _, w := range tt.notWant {
+				if contains(got, w) {
+					t.Errorf("%s completions %v include %q", tt.kind, got, w)
+				}
+			}
+		})
+	}
+}
+
+func TestCompleteInsideLiteral(t *testing.T) {
+	sql := `select 'fro`
+	if got := Complete(sql, len(sql), testPolicyCatalog()); len(got.Completions) != 0 {
+		t.Errorf("completed inside a string: %+v", got.Completions)
+	}
+}
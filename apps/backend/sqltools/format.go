@@ -0,0 +1,380 @@
+package sqltools
+
+import (
+	"fmt"
+	"strings"
+)
+
+const (
+	KeywordCaseUpper    = "upper"
+	KeywordCaseLower    = "lower"
+	KeywordCasePreserve = "preserve"
+
+	CommaTrailing = "trailing"
+	CommaLeading  = "leading"
+
+	maxIndent = 8
+)
+
+// FormatOptions controls the pretty-printer. Zero values pick the defaults:
+// upper-case keywords, two-space indentation and trailing commas.
+type FormatOptions struct {
+	KeywordCase string `json:"keyword_case,omitempty"`
+	Indent      int    `json:"indent,omitempty"`
+	UseTabs     bool   `json:"use_tabs,omitempty"`
+	CommaStyle  string `json:"comma_style,omitempty"`
+}
+
+// Validate fills in defaults and rejects unknown settings.
+func (o *FormatOptions) Validate() error {
+	switch o.KeywordCase {
+	case "":
+		o.KeywordCase = KeywordCaseUpper
+	case KeywordCaseUpper, KeywordCaseLower, KeywordCasePreserve:
+	default:
+		return fmt.Errorf("keyword_case must be one of upper, lower, preserve")
+	}
+
+	switch o.CommaStyle {
+	case "":
+		o.CommaStyle = CommaTrailing
+	case CommaTrailing, CommaLeading:
+	default:
+		return fmt.Errorf("comma_style must be one of trailing, leading")
+	}
+
+	if o.Indent == 0 {
+		o.Indent = 2
+	}
+	if o.Indent < 0 || o.Indent > maxIndent {
+		return fmt.Errorf("indent must be between 1 and %d", maxIndent)
+	}
+	return nil
+}
+
+// blockClauses put their contents on the following lines, one level deeper.
+var blockClauses = map[string]bool{
+	"SELECT": true, "FROM": true, "WHERE": true, "GROUP": true, "ORDER": true,
+	"HAVING": true, "RETURNING": true, "SET": true, "VALUES": true, "WINDOW": true,
+}
+
+// inlineClauses start a new line but keep their contents on it.
+var inlineClauses = map[string]bool{
+	"LIMIT": true, "OFFSET": true, "FETCH": true, "FOR": true, "UNION": true,
+	"INTERSECT": true, "EXCEPT": true,
+}
+
+// listClauses break the line after each top-level comma.
+var listClauses = map[string]bool{
+	"SELECT": true, "FROM": true, "GROUP": true, "ORDER": true, "RETURNING": true,
+	"SET": true, "VALUES": true, "WINDOW": true, "WITH": true,
+}
+
+type formatFrame struct {
+	subquery  bool
+	base      int // indentation level of the frame's clause keywords
+	openLevel int // indentation level of the line holding "("
+	clause    string
+	between   bool // saw BETWEEN, so the next AND is part of it
+}
+
+type formatter struct {
+	opts    FormatOptions
+	out     strings.Builder
+	frames  []*formatFrame
+	level   int // indentation level of the current line
+	newline bool
+	pending int // level for the next line break, or -1
+	prev    []Token
+}
+
+// Format pretty-prints sql. Comments are kept; original whitespace is not.
+// Statements the lexer cannot make sense of still come out intact, just
+// with less helpful line breaks.
+func Format(sql string, opts FormatOptions) (string, error) {
+	if err := opts.Validate(); err != nil {
+		return "", err
+	}
+
+	f := &formatter{opts: opts, pending: -1, newline: true}
+	f.frames = []*formatFrame{{subquery: true}}
+
+	var toks []Token
+	for _, t := range Tokenize(sql) {
+		if t.Kind != TokenWhitespace {
+			toks = append(toks, t)
+		}
+	}
+
+	for i, t := range toks {
+		f.token(t, nextSignificant(toks, i))
+	}
+
+	lines := strings.Split(f.out.String(), "\n")
+	for i := range lines {
+		lines[i] = strings.TrimRight(lines[i], " \t")
+	}
+	return strings.TrimSpace(strings.Join(lines, "\n")), nil
+}
+
+func nextSignificant(toks []Token, i int) *Token {
+	for j := i + 1; j < len(toks); j++ {
+		if toks[j].Significant() {
+			return &toks[j]
+		}
+	}
+	return nil
+}
+
+func (f *formatter) frame() *formatFrame {
+	return f.frames[len(f.frames)-1]
+}
+
+func (f *formatter) last() *Token {
+	if len(f.prev) == 0 {
+		return nil
+	}
+	return &f.prev[len(f.prev)-1]
+}
+
+func (f *formatter) atStatementStart() bool {
+	p := f.last()
+	return p == nil || p.IsPunct(";") || p.IsPunct("(") || p.IsPunct(")")
+}
+
+func (f *formatter) breakLine(level int) {
+	if !f.newline {
+		f.out.WriteByte('\n')
+	}
+	f.newline = true
+	f.level = level
+	f.pending = -1
+}
+
+func (f *formatter) write(t Token, text string) {
+	if f.pending >= 0 {
+		f.breakLine(f.pending)
+	}
+	if f.newline {
+		if f.opts.UseTabs {
+			f.out.WriteString(strings.Repeat("\t", f.level))
+		} else {
+			f.out.WriteString(strings.Repeat(" ", f.level*f.opts.Indent))
+		}
+	} else if needsSpace(f.prev, t) {
+		f.out.WriteByte(' ')
+	}
+	f.out.WriteString(text)
+	f.newline = false
+	if t.Significant() {
+		f.prev = append(f.prev, t)
+	}
+}
+
+func (f *formatter) token(t Token, next *Token) {
+	fr := f.frame()
+
+	switch {
+	case t.Kind == TokenComment:
+		f.write(t, strings.TrimRight(t.Text, " \t\r\n"))
+		if strings.HasPrefix(t.Text, "--") {
+			f.breakLine(f.level)
+		}
+		return
+
+	case t.IsPunct(";"):
+		f.pending = -1
+		f.write(t, ";")
+		f.out.WriteString("\n\n")
+		f.newline = true
+		f.level = 0
+		f.frames = []*formatFrame{{subquery: true}}
+		return
+
+	case t.IsPunct("("):
+		subquery := next != nil && (next.Is("SELECT") || next.Is("WITH") || next.Is("VALUES"))
+		f.write(t, "(")
+		nf := &formatFrame{subquery: subquery, openLevel: f.level, base: f.level + 1}
+		f.frames = append(f.frames, nf)
+		if subquery {
+			f.pending = nf.base
+		}
+		return
+
+	case t.IsPunct(")"):
+		if len(f.frames) > 1 {
+			f.frames = f.frames[:len(f.frames)-1]
+			if fr.subquery {
+				f.breakLine(fr.openLevel)
+			}
+		}
+		f.pending = -1
+		f.write(t, ")")
+		return
+
+	case t.IsPunct(","):
+		if !fr.subquery || !listClauses[fr.clause] {
+			f.write(t, ",")
+			return
+		}
+		level := fr.base + 1
+		if fr.clause == "WITH" {
+			level = fr.base
+		}
+		if f.opts.CommaStyle == CommaLeading {
+			f.breakLine(level)
+			f.write(t, ",")
+		} else {
+			f.write(t, ",")
+			f.pending = level
+		}
+		return
+
+	case t.Kind == TokenKeyword:
+		f.keyword(t, fr, next)
+		return
+	}
+
+	f.write(t, t.Text)
+}
+
+func (f *formatter) keyword(t Token, fr *formatFrame, next *Token) {
+	upper := t.Upper()
+	text := f.keywordText(t)
+	prev := f.last()
+
+	if !fr.subquery {
+		f.write(t, text)
+		return
+	}
+
+	// Modifiers that stay on the clause line.
+	if pending := f.pending; pending >= 0 && prev != nil && prev.Kind == TokenKeyword &&
+		(upper == "BY" || upper == "DISTINCT" || upper == "ALL") {
+		f.pending = -1
+		f.write(t, text)
+		f.pending = pending
+		return
+	}
+
+	switch {
+	case upper == "BETWEEN":
+		fr.between = true
+
+	case upper == "WITH" && f.atStatementStart():
+		fr.clause = "WITH"
+		f.breakLine(fr.base)
+		f.write(t, text)
+		return
+
+	case (upper == "INSERT" || upper == "UPDATE" || upper == "DELETE") && f.atStatementStart():
+		fr.clause = upper
+		f.breakLine(fr.base)
+		f.write(t, text)
+		return
+
+	case upper == "ON" && next != nil && next.Is("CONFLICT"):
+		fr.clause = "INSERT"
+		f.breakLine(fr.base)
+		f.write(t, text)
+		return
+
+	case upper == "FROM" && prev != nil && (prev.Is("DELETE") || prev.Is("DISTINCT")):
+		// DELETE FROM t, IS DISTINCT FROM x
+
+	case upper == "SET" && prev != nil && !prev.Is("UPDATE") && fr.clause != "UPDATE" && fr.clause != "INSERT":
+		// ON DELETE SET NULL and similar DDL
+
+	case upper == "UPDATE" && prev != nil && (prev.Is("FOR") || prev.Is("DO")):
+		// FOR UPDATE, ON CONFLICT DO UPDATE
+
+	case blockClauses[upper]:
+		fr.clause = upper
+		f.breakLine(fr.base)
+		f.write(t, text)
+		f.pending = fr.base + 1
+		return
+
+	case inlineClauses[upper]:
+		fr.clause = upper
+		f.breakLine(fr.base)
+		f.write(t, text)
+		if upper != "LIMIT" && upper != "OFFSET" && upper != "FETCH" && upper != "FOR" {
+			// UNION [ALL] stands on its own line.
+			f.pending = fr.base
+		}
+		return
+
+	case upper == "JOIN" || joinModifiers[upper]:
+		if fr.clause == "FROM" && (prev == nil || !(joinModifiers[prev.Upper()] && prev.Kind == TokenKeyword)) {
+			f.breakLine(fr.base + 1)
+		}
+
+	case upper == "AND" && fr.between:
+		fr.between = false
+
+	case upper == "AND" || upper == "OR":
+		switch fr.clause {
+		case "WHERE", "HAVING":
+			f.breakLine(fr.base + 1)
+		case "FROM":
+			f.breakLine(fr.base + 2) // join condition
+		}
+	}
+
+	f.write(t, text)
+}
+
+func (f *formatter) keywordText(t Token) string {
+	switch f.opts.KeywordCase {
+	case KeywordCaseLower:
+		return strings.ToLower(t.Text)
+	case KeywordCasePreserve:
+		return t.Text
+	}
+	return t.Upper()
+}
+
+// needsSpace decides whether t is separated from the previous significant
+// token by a space when both sit on the same line.
+func needsSpace(prev []Token, t Token) bool {
+	if len(prev) == 0 {
+		return false
+	}
+	p := prev[len(prev)-1]
+
+	switch {
+	case t.Kind == TokenComment:
+		return true
+	case t.IsPunct(",") || t.IsPunct(")") || t.IsPunct("]") || t.IsPunct(";") || t.IsPunct(".") || t.IsPunct(":"):
+		return false
+	case t.Kind == TokenOperator && t.Text == "::":
+		return false
+	case p.IsPunct("(") || p.IsPunct("[") || p.IsPunct(".") || p.IsPunct(":"):
+		return false
+	case p.Kind == TokenOperator && p.Text == "::":
+		return false
+	case t.IsPunct("(") || t.IsPunct("["):
+		if p.IsName() {
+			// INSERT INTO t (a, b) keeps its space; f(x) does not.
+			return len(prev) > 1 && prev[len(prev)-2].Is("INTO")
+		}
+		if p.Is("CAST") || p.Is("ANY") || p.Is("SOME") || p.Is("ARRAY") {
+			return false
+		}
+		return true
+	}
+
+	// Unary minus/plus: no space between the sign and its operand.
+	if p.Kind == TokenOperator && (p.Text == "-" || p.Text == "+") {
+		if len(prev) == 1 {
+			return false
+		}
+		pp := prev[len(prev)-2]
+		if pp.Kind == TokenOperator || pp.IsPunct("(") || pp.IsPunct(",") ||
+			(pp.Kind == TokenKeyword && !pp.Is("NULL") && !pp.Is("TRUE") && !pp.Is("FALSE") && !pp.Is("END")) {
+			return false
+		}
+	}
+	return true
+}
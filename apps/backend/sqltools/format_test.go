@@ -0,0 +1,199 @@
+package sqltools
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestFormat(t *testing.T) {
+	tests := []struct {
+		name string
+		sql  string
+		opts FormatOptions
+		want string
+	}{
+		{
+			"clauses",
+			"select id, name from users where age > 18 and active = true order by name limit 10",
+			FormatOptions{},
+			`SELECT
+  id,
+  name
+FROM
+  users
+WHERE
+  age > 18
+  AND active = TRUE
+ORDER BY
+  name
+LIMIT 10`,
+		},
+		{
+			"joins",
+			"select u.id, count(*) from users u left join orders o on o.user_id = u.id and o.total > 0 group by u.id having count(*) > 1",
+			FormatOptions{},
+			`SELECT
+  u.id,
+  count(*)
+FROM
+  users u
+  LEFT JOIN orders o ON o.user_id = u.id
+    AND o.total > 0
+GROUP BY
+  u.id
+HAVING
+  count(*) > 1`,
+		},
+		{
+			"subquery",
+			"select * from t where id in (select user_id from orders)",
+			FormatOptions{},
+			`SELECT
+  *
+FROM
+  t
+WHERE
+  id IN (
+    SELECT
+      user_id
+    FROM
+      orders
+  )`,
+		},
+		{
+			"insert",
+			"insert into t (a, b) values (1, 'x'), (2, 'y') returning id",
+			FormatOptions{},
+			`INSERT INTO t (a, b)
+VALUES
+  (1, 'x'),
+  (2, 'y')
+RETURNING
+  id`,
+		},
+		{
+			"common table expressions",
+			"with x as (select 1), y as (select 2) select * from x union all select * from y",
+			FormatOptions{},
+			`WITH x AS (
+  SELECT
+    1
+),
+y AS (
+  SELECT
+    2
+)
+SELECT
+  *
+FROM
+  x
+UNION ALL
+SELECT
+  *
+FROM
+  y`,
+		},
+		{
+			"BETWEEN keeps its AND",
+			"select a from t where b between 1 and 2 and c is distinct from d",
+			FormatOptions{},
+			`SELECT
+  a
+FROM
+  t
+WHERE
+  b BETWEEN 1 AND 2
+  AND c IS DISTINCT FROM d`,
+		},
+		{
+			"comments and statements",
+			"select a -- note\nfrom t; select 2",
+			FormatOptions{},
+			`SELECT
+  a -- note
+FROM
+  t;
+
+SELECT
+  2`,
+		},
+		{
+			"lower case, leading commas, wide indent",
+			"UPDATE t SET a = 1, b = 2 WHERE id = $1",
+			FormatOptions{KeywordCase: KeywordCaseLower, CommaStyle: CommaLeading, Indent: 4},
+			`update t
+set
+    a = 1
+    , b = 2
+where
+    id = $1`,
+		},
+		{
+			"preserved case and tabs",
+			"Select a From t",
+			FormatOptions{KeywordCase: KeywordCasePreserve, UseTabs: true},
+			"Select\n\ta\nFrom\n\tt",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Format(tt.sql, tt.opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("Format =\n%s\nwant\n%s", got, tt.want)
+			}
+			// Formatting changes layout only
+			if Normalize(got) != Normalize(tt.sql) {
+				t.Errorf("formatted query normalizes to %q, want %q", Normalize(got), Normalize(tt.sql))
+			}
+		})
+	}
+}
+
+func TestFormatIsIdempotent(t *testing.T) {
+	sql := "with x as (select a, b from t where c in (select d from u)) select * from x order by a"
+	once, err := Format(sql, FormatOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	twice, err := Format(once, FormatOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if once != twice {
+		t.Errorf("formatting again changed\n%s\nto\n%s", once, twice)
+	}
+}
+
+func TestFormatOptionsValidate(t *testing.T) {
+	tests := []struct {
+		opts FormatOptions
+		err  string // empty when valid
+	}{
+		{FormatOptions{}, ""},
+		{FormatOptions{KeywordCase: KeywordCaseLower, CommaStyle: CommaLeading, Indent: maxIndent}, ""},
+		{FormatOptions{KeywordCase: "title"}, "keyword_case"},
+		{FormatOptions{CommaStyle: "before"}, "comma_style"},
+		{FormatOptions{Indent: -1}, "indent"},
+		{FormatOptions{Indent: maxIndent + 1}, "indent"},
+	}
+
+	for _, tt := range tests {
+		opts := tt.opts
+		err := opts.Validate()
+		switch {
+		case tt.err == "" && err != nil:
+			t.Errorf("Validate(%+v) = %v", tt.opts, err)
+		case tt.err != "" && (err == nil || !strings.Contains(err.Error(), tt.err)):
+			t.Errorf("Validate(%+v) = %v, want an error about %s", tt.opts, err, tt.err)
+		}
+	}
+
+	opts := FormatOptions{}
+	if err := opts.Validate(); err != nil || opts.KeywordCase != KeywordCaseUpper || opts.CommaStyle != CommaTrailing || opts.Indent != 2 {
+		t.Errorf("defaults = %+v, %v", opts, err)
+	}
+}
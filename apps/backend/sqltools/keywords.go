@@ -0,0 +1,65 @@
+package sqltools
+
+import (
+	"strings"
+)
+
+// keywords are the words the lexer classifies as keywords. The list sticks to
+// words PostgreSQL reserves or that only make sense as syntax, so common column
+// names such as "name" or "year" stay identifiers.
+var keywords = map[string]bool{}
+
+var keywordList = []string{
+	"ALL", "ALTER", "ANALYZE", "AND", "ANY", "ARRAY", "AS", "ASC", "BETWEEN", "BY",
+	"CASE", "CAST", "CHECK", "COLLATE", "CONFLICT", "CONSTRAINT", "COPY", "CREATE", "CROSS",
+	"CURRENT_DATE", "CURRENT_TIME", "CURRENT_TIMESTAMP", "CURRENT_USER", "DEFAULT",
+	"DELETE", "DESC", "DISTINCT", "DO", "DROP", "ELSE", "END", "EXCEPT", "EXISTS",
+	"EXPLAIN", "FALSE", "FETCH", "FILTER", "FIRST", "FOR", "FOREIGN", "FROM", "FULL",
+	"GRANT", "GROUP", "HAVING", "ILIKE", "IN", "INDEX", "INNER", "INSERT", "INTERSECT",
+	"INTERVAL", "INTO", "IS", "ISNULL", "JOIN", "KEY", "LAST", "LATERAL", "LEFT",
+	"LIKE", "LIMIT", "MATERIALIZED", "NATURAL", "NEXT", "NOT", "NOTHING", "NOTNULL", "NULL",
+	"NULLS", "OFFSET", "ON", "ONLY", "OR", "ORDER", "OUTER", "OVER", "PARTITION",
+	"PRIMARY", "RECURSIVE", "REFERENCES", "RETURNING", "REVOKE", "RIGHT", "ROWS",
+	"SELECT", "SET", "SIMILAR", "SOME", "TABLE", "THEN", "TO", "TRUE", "TRUNCATE",
+	"UNION", "UNIQUE", "UPDATE", "USING", "VALUES", "VIEW", "WHEN", "WHERE", "WINDOW",
+	"WITH",
+}
+
+// builtinFunctions are offered as completions alongside user-defined functions.
+var builtinFunctions = []string{
+	"abs", "age", "array_agg", "array_length", "avg", "bool_and", "bool_or", "ceil",
+	"char_length", "coalesce", "concat", "concat_ws", "count", "date_part",
+	"date_trunc", "extract", "floor", "generate_series", "greatest", "json_agg",
+	"json_build_object", "jsonb_agg", "jsonb_build_object", "jsonb_array_elements",
+	"jsonb_each", "jsonb_object_keys", "lag", "lead", "least", "left", "length",
+	"lower", "ltrim", "max", "md5", "min", "now", "nullif", "percentile_cont",
+	"position", "random", "rank", "regexp_replace", "replace", "right", "round",
+	"row_number", "rtrim", "split_part", "string_agg", "substring", "sum",
+	"to_char", "to_date", "to_timestamp", "trim", "unnest", "upper",
+}
+
+// clauseKeywords end a FROM list or a join condition when seen at the same
+// nesting level.
+var clauseKeywords = map[string]bool{
+	"WHERE": true, "GROUP": true, "ORDER": true, "HAVING": true, "LIMIT": true,
+	"OFFSET": true, "WINDOW": true, "UNION": true, "INTERSECT": true, "EXCEPT": true,
+	"RETURNING": true, "SET": true, "VALUES": true, "SELECT": true, "FETCH": true,
+	"FOR": true,
+}
+
+// joinModifiers may precede JOIN.
+var joinModifiers = map[string]bool{
+	"INNER": true, "LEFT": true, "RIGHT": true, "FULL": true, "OUTER": true,
+	"CROSS": true, "NATURAL": true,
+}
+
+func init() {
+	for _, k := range keywordList {
+		keywords[k] = true
+	}
+}
+
+// IsKeyword reports whether word is classified as a keyword.
+func IsKeyword(word string) bool {
+	return keywords[strings.ToUpper(word)]
+}
@@ -0,0 +1,308 @@
+// Package sqltools contains schema-aware helpers for the SQL playground:
+// a PostgreSQL lexer, completion, lint diagnostics, formatting and query
+// fingerprinting. Nothing here talks to a database; callers pass in a Catalog
+// built from live introspection.
+package sqltools
+
+import (
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+type TokenKind int
+
+const (
+	TokenWhitespace TokenKind = iota
+	TokenComment
+	TokenKeyword
+	TokenIdentifier
+	TokenQuotedIdentifier
+	TokenString
+	TokenNumber
+	TokenParam
+	TokenOperator
+	TokenPunct
+)
+
+// Token is a lexical unit of SQL text. Start and End are byte offsets into the
+// original input so callers can map tokens back to editor positions.
+type Token struct {
+	Kind  TokenKind
+	Text  string
+	Start int
+	End   int
+}
+
+// Upper returns the upper-cased token text, used for keyword comparison.
+func (t Token) Upper() string {
+	return strings.ToUpper(t.Text)
+}
+
+// Is reports whether the token is the given keyword (case-insensitive).
+func (t Token) Is(keyword string) bool {
+	return t.Kind == TokenKeyword && strings.EqualFold(t.Text, keyword)
+}
+
+// IsPunct reports whether the token is the given punctuation character.
+func (t Token) IsPunct(p string) bool {
+	return t.Kind == TokenPunct && t.Text == p
+}
+
+// IsName reports whether the token can name a database object.
+func (t Token) IsName() bool {
+	return t.Kind == TokenIdentifier || t.Kind == TokenQuotedIdentifier
+}
+
+// Name returns the object name the token refers to: quoted identifiers are
+// unquoted verbatim, bare identifiers are folded to lower case as PostgreSQL does.
+func (t Token) Name() string {
+	switch t.Kind {
+	case TokenQuotedIdentifier:
+		inner := strings.TrimPrefix(t.Text, `"`)
+		// An unterminated identifier, still being typed, runs to the end of
+		// the input; a closing quote is one not paired with another
+		if closed := strings.TrimSuffix(inner, `"`); len(closed) < len(inner) && strings.Count(closed, `"`)%2 == 0 {
+			inner = closed
+		}
+		return strings.ReplaceAll(inner, `""`, `"`)
+	case TokenIdentifier, TokenKeyword:
+		return strings.ToLower(t.Text)
+	}
+	return t.Text
+}
+
+// Significant reports whether the token carries meaning (not whitespace or a comment).
+func (t Token) Significant() bool {
+	return t.Kind != TokenWhitespace && t.Kind != TokenComment
+}
+
+// Tokenize splits SQL text into tokens. It never fails: unterminated strings
+// and comments simply run to the end of the input, which is what an editor
+// needs while the user is still typing.
+func Tokenize(sql string) []Token {
+	var tokens []Token
+	i := 0
+	for i < len(sql) {
+		start := i
+		c := sql[i]
+		kind := TokenPunct
+
+		switch {
+		case isSpace(c):
+			for i < len(sql) && isSpace(sql[i]) {
+				i++
+			}
+			kind = TokenWhitespace
+
+		case c == '-' && i+1 < len(sql) && sql[i+1] == '-':
+			for i < len(sql) && sql[i] != '\n' {
+				i++
+			}
+			kind = TokenComment
+
+		case c == '/' && i+1 < len(sql) && sql[i+1] == '*':
+			i = scanBlockComment(sql, i)
+			kind = TokenComment
+
+		case c == '\'':
+			i = scanString(sql, i+1, false)
+			kind = TokenString
+
+		case (c == 'E' || c == 'e') && i+1 < len(sql) && sql[i+1] == '\'':
+			i = scanString(sql, i+2, true)
+			kind = TokenString
+
+		case c == '"':
+			i = scanQuotedIdentifier(sql, i+1)
+			kind = TokenQuotedIdentifier
+
+		case c == '$' && i+1 < len(sql) && isDigit(sql[i+1]):
+			i++
+			for i < len(sql) && isDigit(sql[i]) {
+				i++
+			}
+			kind = TokenParam
+
+		case c == '$':
+			if end, ok := scanDollarString(sql, i); ok {
+				i = end
+				kind = TokenString
+			} else {
+				i++
+				kind = TokenOperator
+			}
+
+		case isDigit(c) || (c == '.' && i+1 < len(sql) && isDigit(sql[i+1])):
+			i = scanNumber(sql, i)
+			kind = TokenNumber
+
+		case isIdentStart(sql, i):
+			for i < len(sql) && isIdentPart(sql, i) {
+				_, size := utf8.DecodeRuneInString(sql[i:])
+				i += size
+			}
+			kind = TokenIdentifier
+			if IsKeyword(sql[start:i]) {
+				kind = TokenKeyword
+			}
+
+		case strings.IndexByte("(),;.[]", c) >= 0:
+			i++
+			kind = TokenPunct
+
+		case c == ':' && i+1 < len(sql) && sql[i+1] == ':':
+			i += 2
+			kind = TokenOperator
+
+		case c == ':':
+			i++
+			kind = TokenPunct
+
+		case isOperatorChar(c):
+			for i < len(sql) && isOperatorChar(sql[i]) {
+				// Stop before a comment start inside an operator run.
+				if i > start && (strings.HasPrefix(sql[i:], "--") || strings.HasPrefix(sql[i:], "/*")) {
+					break
+				}
+				i++
+			}
+			kind = TokenOperator
+
+		default:
+			_, size := utf8.DecodeRuneInString(sql[i:])
+			i += size
+			kind = TokenOperator
+		}
+
+		tokens = append(tokens, Token{Kind: kind, Text: sql[start:i], Start: start, End: i})
+	}
+	return tokens
+}
+
+// SignificantTokens returns only the tokens that carry meaning.
+func SignificantTokens(tokens []Token) []Token {
+	out := make([]Token, 0, len(tokens))
+	for _, t := range tokens {
+		if t.Significant() {
+			out = append(out, t)
+		}
+	}
+	return out
+}
+
+func scanBlockComment(sql string, i int) int {
+	depth := 0
+	for i < len(sql) {
+		switch {
+		case strings.HasPrefix(sql[i:], "/*"):
+			depth++
+			i += 2
+		case strings.HasPrefix(sql[i:], "*/"):
+			depth--
+			i += 2
+			if depth == 0 {
+				return i
+			}
+		default:
+			i++
+		}
+	}
+	return i
+}
+
+func scanString(sql string, i int, backslashEscapes bool) int {
+	for i < len(sql) {
+		switch {
+		case backslashEscapes && sql[i] == '\\' && i+1 < len(sql):
+			i += 2
+		case sql[i] == '\'' && i+1 < len(sql) && sql[i+1] == '\'':
+			i += 2
+		case sql[i] == '\'':
+			return i + 1
+		default:
+			i++
+		}
+	}
+	return i
+}
+
+func scanQuotedIdentifier(sql string, i int) int {
+	for i < len(sql) {
+		if sql[i] == '"' {
+			if i+1 < len(sql) && sql[i+1] == '"' {
+				i += 2
+				continue
+			}
+			return i + 1
+		}
+		i++
+	}
+	return i
+}
+
+// scanDollarString scans a $tag$...$tag$ string starting at i.
+func scanDollarString(sql string, i int) (int, bool) {
+	j := i + 1
+	for j < len(sql) && sql[j] != '$' {
+		if !isIdentPart(sql, j) {
+			return 0, false
+		}
+		j++
+	}
+	if j >= len(sql) {
+		return 0, false
+	}
+	tag := sql[i : j+1]
+	if end := strings.Index(sql[j+1:], tag); end >= 0 {
+		return j + 1 + end + len(tag), true
+	}
+	return len(sql), true
+}
+
+func scanNumber(sql string, i int) int {
+	for i < len(sql) && isDigit(sql[i]) {
+		i++
+	}
+	if i < len(sql) && sql[i] == '.' && !(i+1 < len(sql) && sql[i+1] == '.') {
+		i++
+		for i < len(sql) && isDigit(sql[i]) {
+			i++
+		}
+	}
+	if i < len(sql) && (sql[i] == 'e' || sql[i] == 'E') {
+		j := i + 1
+		if j < len(sql) && (sql[j] == '+' || sql[j] == '-') {
+			j++
+		}
+		if j < len(sql) && isDigit(sql[j]) {
+			i = j
+			for i < len(sql) && isDigit(sql[i]) {
+				i++
+			}
+		}
+	}
+	return i
+}
+
+func isSpace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\n' || c == '\r' || c == '\f'
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
+func isOperatorChar(c byte) bool {
+	return strings.IndexByte("+-*/<>=~!@#%^&|`?", c) >= 0
+}
+
+func isIdentStart(sql string, i int) bool {
+	r, _ := utf8.DecodeRuneInString(sql[i:])
+	return r == '_' || unicode.IsLetter(r)
+}
+
+func isIdentPart(sql string, i int) bool {
+	r, _ := utf8.DecodeRuneInString(sql[i:])
+	return r == '_' || r == '$' || unicode.IsLetter(r) || unicode.IsDigit(r)
+}
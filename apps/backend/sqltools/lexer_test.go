@@ -0,0 +1,68 @@
+package sqltools
+
+import "testing"
+
+func TestTokenize(t *testing.T) {
+	tests := []struct {
+		sql   string
+		kinds []TokenKind
+	}{
+		{`select 1`, []TokenKind{TokenKeyword, TokenWhitespace, TokenNumber}},
+		{`"Users".id`, []TokenKind{TokenQuotedIdentifier, TokenPunct, TokenIdentifier}},
+		{`'it''s' -- done`, []TokenKind{TokenString, TokenWhitespace, TokenComment}},
+		{`$1 || $$body$$`, []TokenKind{TokenParam, TokenWhitespace, TokenOperator, TokenWhitespace, TokenString}},
+		{`E'\'' /* a /* nested */ comment */`, []TokenKind{TokenString, TokenWhitespace, TokenComment}},
+		// Unterminated input runs to the end
+		{`select "`, []TokenKind{TokenKeyword, TokenWhitespace, TokenQuotedIdentifier}},
+		{`select 'abc`, []TokenKind{TokenKeyword, TokenWhitespace, TokenString}},
+		{`select /* abc`, []TokenKind{TokenKeyword, TokenWhitespace, TokenComment}},
+		{`select $$abc`, []TokenKind{TokenKeyword, TokenWhitespace, TokenString}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.sql, func(t *testing.T) {
+			tokens := Tokenize(tt.sql)
+			if len(tokens) != len(tt.kinds) {
+				t.Fatalf("got %d tokens %+v, want %d", len(tokens), tokens, len(tt.kinds))
+			}
+			end := 0
+			for i, tok := range tokens {
+				if tok.Kind != tt.kinds[i] {
+					t.Errorf("token %d %q kind = %d, want %d", i, tok.Text, tok.Kind, tt.kinds[i])
+				}
+				if tok.Start != end || tt.sql[tok.Start:tok.End] != tok.Text {
+					t.Errorf("token %d %q at %d-%d does not follow the previous one", i, tok.Text, tok.Start, tok.End)
+				}
+				end = tok.End
+			}
+			if end != len(tt.sql) {
+				t.Errorf("tokens end at %d of %d", end, len(tt.sql))
+			}
+		})
+	}
+}
+
+func TestTokenName(t *testing.T) {
+	tests := []struct {
+		text string
+		want string
+	}{
+		{`Users`, "users"},
+		{`"Users"`, "Users"},
+		{`"say ""hi"""`, `say "hi"`},
+		{`""""`, `"`},
+		{`""`, ""},
+		// Unterminated, as while typing
+		{`"`, ""},
+		{`"Us`, "Us"},
+		{`"a""`, `a"`},
+	}
+	for _, tt := range tests {
+		tokens := Tokenize(tt.text)
+		if len(tokens) != 1 {
+			t.Fatalf("Tokenize(%q) = %+v, want one token", tt.text, tokens)
+		}
+		if got := tokens[0].Name(); got != tt.want {
+			t.Errorf("Name(%q) = %q, want %q", tt.text, got, tt.want)
+		}
+	}
+}
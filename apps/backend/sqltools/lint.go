@@ -0,0 +1,205 @@
+package sqltools
+
+import (
+	"fmt"
+	"strings"
+)
+
+const (
+	SeverityError   = "error"
+	SeverityWarning = "warning"
+)
+
+// Diagnostic is a lint finding. Start and End are character offsets.
+type Diagnostic struct {
+	Severity string `json:"severity"`
+	Code     string `json:"code"`
+	Message  string `json:"message"`
+	Start    int    `json:"start"`
+	End      int    `json:"end"`
+}
+
+// Lint checks sql against the catalog for unknown tables and columns,
+// ambiguous column references and joins without a join condition.
+func Lint(sql string, cat *Catalog) []Diagnostic {
+	if cat == nil {
+		cat = &Catalog{}
+	}
+	a := Analyze(sql, cat)
+	l := &linter{sql: sql, a: a, diagnostics: []Diagnostic{}}
+
+	for _, blk := range a.Blocks {
+		l.checkRefs(blk)
+		l.checkJoins(blk)
+	}
+	l.checkColumns()
+
+	return l.diagnostics
+}
+
+type linter struct {
+	sql         string
+	a           *Analysis
+	diagnostics []Diagnostic
+}
+
+func (l *linter) report(severity, code string, start, end int, format string, args ...interface{}) {
+	l.diagnostics = append(l.diagnostics, Diagnostic{
+		Severity: severity,
+		Code:     code,
+		Message:  fmt.Sprintf(format, args...),
+		Start:    CharOffset(l.sql, start),
+		End:      CharOffset(l.sql, end),
+	})
+}
+
+func (l *linter) checkRefs(blk *Block) {
+	for _, ref := range blk.Refs {
+		if ref.Kind != RefTable || ref.Table != nil || isSystemRelation(ref.Schema, ref.Name) {
+			continue
+		}
+		name := ref.Name
+		if ref.Schema != "" {
+			name = ref.Schema + "." + ref.Name
+		}
+		l.report(SeverityError, "unknown_table", ref.Start, ref.End, "relation %q does not exist", name)
+	}
+}
+
+func (l *linter) checkJoins(blk *Block) {
+	commaJoins := 0
+	for _, j := range blk.joins {
+		if j.comma {
+			commaJoins++
+			continue
+		}
+		if !j.cross && !j.hasCondition {
+			l.report(SeverityWarning, "missing_join_condition", j.start, j.end, "JOIN without ON or USING produces a cartesian product")
+		}
+	}
+	if commaJoins > 0 && !blk.hasWhere {
+		j := blk.joins[0]
+		for _, candidate := range blk.joins {
+			if candidate.comma {
+				j = candidate
+				break
+			}
+		}
+		l.report(SeverityWarning, "missing_join_condition", j.start, j.end, "comma-separated tables without a WHERE clause produce a cartesian product")
+	}
+}
+
+func (l *linter) checkColumns() {
+	toks := l.a.Tokens
+	for i := 0; i < len(toks); i++ {
+		t := toks[i]
+		if !t.IsName() || l.a.ignore[i] {
+			continue
+		}
+		if i > 0 && (toks[i-1].IsPunct(".") || toks[i-1].Is("WINDOW") || toks[i-1].Is("OVER")) {
+			continue
+		}
+		if i+1 < len(toks) && toks[i+1].IsPunct("(") {
+			continue // function call
+		}
+		blk := l.a.blockOf[i]
+
+		if i+2 < len(toks) && toks[i+1].IsPunct(".") {
+			i = l.checkQualified(blk, i)
+			continue
+		}
+
+		// EXTRACT(year FROM ts) and similar
+		if i > 0 && toks[i-1].IsPunct("(") && i+1 < len(toks) && toks[i+1].Is("FROM") {
+			continue
+		}
+		l.checkBare(blk, t)
+	}
+}
+
+// checkQualified validates alias.column (or schema.table.column) starting at
+// token i and returns the index of the last token it consumed.
+func (l *linter) checkQualified(blk *Block, i int) int {
+	toks := l.a.Tokens
+	qualifier := toks[i]
+	col := toks[i+2]
+	last := i + 2
+
+	if i+4 < len(toks) && toks[i+3].IsPunct(".") {
+		// schema.table.column or schema.function(...)
+		if i+5 < len(toks) && toks[i+5].IsPunct("(") {
+			return i + 4
+		}
+		table := l.a.catalog.FindTable(qualifier.Name(), col.Name())
+		if table != nil && toks[i+4].IsName() && table.Column(toks[i+4].Name()) == nil {
+			l.report(SeverityError, "unknown_column", toks[i+4].Start, toks[i+4].End,
+				"column %q does not exist in %s", toks[i+4].Name(), table.QualifiedName())
+		}
+		return i + 4
+	}
+	if i+3 < len(toks) && toks[i+3].IsPunct("(") {
+		return last // schema.function(...)
+	}
+
+	ref := blk.Lookup(qualifier.Name())
+	if ref == nil {
+		if !blk.isCTE(qualifier.Name()) && !isSystemRelation(qualifier.Name(), "") && !pseudoRelations[qualifier.Name()] {
+			l.report(SeverityError, "unknown_table", qualifier.Start, qualifier.End,
+				"missing FROM-clause entry for table %q", qualifier.Name())
+		}
+		return last
+	}
+	if ref.Known() && col.IsName() && ref.Table.Column(col.Name()) == nil {
+		l.report(SeverityError, "unknown_column", col.Start, col.End,
+			"column %q does not exist in %s", col.Name(), ref.Table.QualifiedName())
+	}
+	return last
+}
+
+func (l *linter) checkBare(blk *Block, t Token) {
+	name := t.Name()
+	if blk.isAlias(name) || blk.Lookup(name) != nil {
+		return
+	}
+
+	certain := true
+	anyRefs := false
+	for b := blk; b != nil; b = b.Parent {
+		var owners []string
+		for _, ref := range b.Refs {
+			anyRefs = true
+			if !ref.Known() {
+				certain = false
+				continue
+			}
+			if ref.Table.Column(name) != nil {
+				owners = append(owners, ref.Binding())
+			}
+		}
+		if len(owners) > 1 && !b.natural && !b.usingCols[name] {
+			l.report(SeverityError, "ambiguous_column", t.Start, t.End,
+				"column reference %q is ambiguous (%s)", name, strings.Join(owners, ", "))
+			return
+		}
+		if len(owners) > 0 {
+			return
+		}
+	}
+
+	if anyRefs && certain {
+		l.report(SeverityError, "unknown_column", t.Start, t.End, "column %q does not exist", name)
+	}
+}
+
+// pseudoRelations are row references PostgreSQL provides without a FROM entry.
+var pseudoRelations = map[string]bool{"excluded": true, "new": true, "old": true}
+
+func isSystemRelation(schema, name string) bool {
+	switch {
+	case schema == "pg_catalog" || schema == "information_schema":
+		return true
+	case schema == "" && strings.HasPrefix(name, "pg_"):
+		return true
+	}
+	return false
+}
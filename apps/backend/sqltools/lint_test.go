@@ -0,0 +1,57 @@
+package sqltools
+
+import "testing"
+
+func TestLint(t *testing.T) {
+	tests := []struct {
+		sql   string
+		codes []string
+	}{
+		{`select id, email from users`, nil},
+		{`select * from missing`, []string{"unknown_table"}},
+		{`select nope from users`, []string{"unknown_column"}},
+		{`select u.nope from users u`, []string{"unknown_column"}},
+		{`select id from users, orders where users.id = orders.user_id`, []string{"ambiguous_column"}},
+		{`select * from users join orders`, []string{"missing_join_condition"}},
+		{`select * from users, orders`, []string{"missing_join_condition"}},
+		{`select * from users u join orders o on o.user_id = u.id`, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.sql, func(t *testing.T) {
+			got := Lint(tt.sql, testPolicyCatalog())
+			if len(got) != len(tt.codes) {
+				t.Fatalf("diagnostics = %+v, want %v", got, tt.codes)
+			}
+			for i, d := range got {
+				if d.Code != tt.codes[i] {
+					t.Errorf("diagnostic %d = %+v, want %s", i, d, tt.codes[i])
+				}
+			}
+		})
+	}
+}
+
+// Unterminated input is what the editor sends while the user types; it must
+// never take the server down.
+func TestUnterminatedInput(t *testing.T) {
+	queries := []string{
+		`select "`,
+		`select * from "`,
+		`select * from "us`,
+		`select * from public."`,
+		`select "a"" from users`,
+		`select 'abc`,
+		`select * from users where email = $$abc`,
+		`select /* abc`,
+		`select u." from users u`,
+	}
+	for _, q := range queries {
+		t.Run(q, func(t *testing.T) {
+			Lint(q, testPolicyCatalog())
+			Complete(q, len([]rune(q)), testPolicyCatalog())
+			Format(q, FormatOptions{})
+			Fingerprint(q)
+			ApplyPolicies(q, testPolicyCatalog(), testPolicies, testAliasPrefix)
+		})
+	}
+}
@@ -0,0 +1,257 @@
+package sqltools
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+	"unicode"
+)
+
+const (
+	MaskRedact  = "redact"
+	MaskPartial = "partial"
+	MaskHash    = "hash"
+	MaskNull    = "null"
+)
+
+// RedactedValue replaces every value of a redacted column.
+const RedactedValue = "[REDACTED]"
+
+// ValidMaskStrategy reports whether s is a known masking strategy.
+func ValidMaskStrategy(s string) bool {
+	switch s {
+	case MaskRedact, MaskPartial, MaskHash, MaskNull:
+		return true
+	}
+	return false
+}
+
+// MaskRule masks the values of result columns. A rule matches result columns
+// named Column, or whose name matches Pattern, ignoring case. Rules bound to a
+// table column with Masker.AddSourceRule match wherever that column is
+// selected, under any alias.
+type MaskRule struct {
+	Column   string
+	Pattern  string
+	Strategy string
+	// KeepChars is how many trailing characters MaskPartial leaves visible.
+	KeepChars int
+}
+
+// SourceColumn identifies a table column by the table's OID and the
+// column's attribute number, as reported for result columns by Postgres.
+type SourceColumn struct {
+	TableOID uint32
+	Attnum   uint16
+}
+
+type nameRule struct {
+	rule    MaskRule
+	pattern *regexp.Regexp
+}
+
+// Masker applies masking rules to query results.
+type Masker struct {
+	salt     string
+	byName   []nameRule
+	bySource map[SourceColumn]MaskRule
+}
+
+// NewMasker returns an empty masker. Hashed values are salted with salt, so
+// they can be compared within one project but not looked up elsewhere.
+func NewMasker(salt string) *Masker {
+	return &Masker{salt: salt, bySource: make(map[SourceColumn]MaskRule)}
+}
+
+// CompileMaskPattern compiles a rule pattern, which matches column names
+// case-insensitively.
+func CompileMaskPattern(pattern string) (*regexp.Regexp, error) {
+	return regexp.Compile("(?i)" + pattern)
+}
+
+// AddRule adds a rule matched by result column name.
+func (m *Masker) AddRule(rule MaskRule) error {
+	nr := nameRule{rule: rule}
+	if rule.Pattern != "" {
+		re, err := CompileMaskPattern(rule.Pattern)
+		if err != nil {
+			return fmt.Errorf("invalid pattern %q: %w", rule.Pattern, err)
+		}
+		nr.pattern = re
+	}
+	m.byName = append(m.byName, nr)
+	return nil
+}
+
+// AddSourceRule adds a rule matched by the table column a result column
+// reads. An earlier rule for the same column wins.
+func (m *Masker) AddSourceRule(src SourceColumn, rule MaskRule) {
+	if _, ok := m.bySource[src]; !ok {
+		m.bySource[src] = rule
+	}
+}
+
+// Empty reports whether the masker has no rules.
+func (m *Masker) Empty() bool {
+	return m == nil || (len(m.byName) == 0 && len(m.bySource) == 0)
+}
+
+// RuleFor returns the rule for a result column. Rules bound to the source
+// column come first, then rules in the order they were added.
+func (m *Masker) RuleFor(name string, src SourceColumn) (MaskRule, bool) {
+	if m.Empty() {
+		return MaskRule{}, false
+	}
+	if src.TableOID != 0 {
+		if rule, ok := m.bySource[src]; ok {
+			return rule, true
+		}
+	}
+	for _, nr := range m.byName {
+		if nr.pattern != nil && nr.pattern.MatchString(name) {
+			return nr.rule, true
+		}
+		if nr.rule.Column != "" && strings.EqualFold(nr.rule.Column, name) {
+			return nr.rule, true
+		}
+	}
+	return MaskRule{}, false
+}
+
+// Mask returns value masked by rule. NULLs stay NULL.
+func (m *Masker) Mask(rule MaskRule, value interface{}) interface{} {
+	if value == nil {
+		return nil
+	}
+	switch rule.Strategy {
+	case MaskNull:
+		return nil
+	case MaskPartial:
+		return maskPartial(maskText(value), rule.KeepChars)
+	case MaskHash:
+		sum := sha256.Sum256([]byte(m.salt + "\x00" + maskText(value)))
+		return "sha256:" + hex.EncodeToString(sum[:8])
+	default:
+		return RedactedValue
+	}
+}
+
+func maskText(value interface{}) string {
+	switch v := value.(type) {
+	case string:
+		return v
+	case []byte:
+		return string(v)
+	case time.Time:
+		return v.Format(time.RFC3339Nano)
+	default:
+		return fmt.Sprint(v)
+	}
+}
+
+// maskPartial keeps the last keep characters of s, or fewer for short
+// values so that at least half of s is hidden.
+func maskPartial(s string, keep int) string {
+	runes := []rune(s)
+	if keep > len(runes)/2 {
+		keep = len(runes) / 2
+	}
+	if keep < 0 {
+		keep = 0
+	}
+	return strings.Repeat("*", len(runes)-keep) + string(runes[len(runes)-keep:])
+}
+
+// MaskSuggestion is a column that looks like it holds personal data.
+type MaskSuggestion struct {
+	Schema   string `json:"schema"`
+	Table    string `json:"table"`
+	Column   string `json:"column"`
+	Category string `json:"category"`
+	Strategy string `json:"strategy"`
+}
+
+// piiHeuristics are checked in order against column names split into
+// lower-case words; the first with a matching word sequence wins.
+var piiHeuristics = []struct {
+	category string
+	strategy string
+	words    [][]string
+}{
+	{"credential", MaskRedact, [][]string{{"password"}, {"passwd"}, {"secret"}, {"token"}, {"api", "key"}, {"apikey"}, {"private", "key"}}},
+	{"government_id", MaskRedact, [][]string{{"ssn"}, {"social", "security"}, {"passport"}, {"national", "id"}, {"tax", "id"}, {"driver", "license"}}},
+	{"payment_card", MaskPartial, [][]string{{"card", "number"}, {"cc", "number"}, {"credit", "card"}, {"pan"}, {"cvv"}, {"cvc"}}},
+	{"bank_account", MaskPartial, [][]string{{"iban"}, {"account", "number"}, {"routing", "number"}, {"swift"}, {"bic"}}},
+	{"email", MaskPartial, [][]string{{"email"}, {"e", "mail"}}},
+	{"phone", MaskPartial, [][]string{{"phone"}, {"mobile"}, {"msisdn"}, {"fax"}}},
+	{"birth_date", MaskRedact, [][]string{{"dob"}, {"birth"}, {"birthday"}, {"birthdate"}}},
+	{"address", MaskRedact, [][]string{{"address"}, {"street"}, {"postcode"}, {"postal", "code"}, {"zip"}, {"zipcode"}}},
+	{"ip_address", MaskHash, [][]string{{"ip"}, {"ip", "address"}, {"ipv4"}, {"ipv6"}}},
+	{"person_name", MaskHash, [][]string{{"first", "name"}, {"last", "name"}, {"full", "name"}, {"surname"}, {"firstname"}, {"lastname"}}},
+	{"location", MaskRedact, [][]string{{"latitude"}, {"longitude"}, {"geo"}}},
+}
+
+// columnWords splits snake_case and camelCase names into lower-case words.
+func columnWords(name string) []string {
+	var b strings.Builder
+	prev := rune(0)
+	for _, r := range name {
+		if unicode.IsUpper(r) && (unicode.IsLower(prev) || unicode.IsDigit(prev)) {
+			b.WriteRune(' ')
+		}
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			b.WriteRune(unicode.ToLower(r))
+		} else {
+			b.WriteRune(' ')
+		}
+		prev = r
+	}
+	return strings.Fields(b.String())
+}
+
+func hasWordSequence(words, seq []string) bool {
+	for i := 0; i+len(seq) <= len(words); i++ {
+		match := true
+		for j, w := range seq {
+			if words[i+j] != w {
+				match = false
+				break
+			}
+		}
+		if match {
+			return true
+		}
+	}
+	return false
+}
+
+// SuggestMasking lists catalog columns whose names suggest personal data,
+// with a masking strategy for each. It only looks at names, so it misses
+// columns with unhelpful names and flags some that hold no personal data.
+func SuggestMasking(cat *Catalog) []MaskSuggestion {
+	suggestions := []MaskSuggestion{}
+	for _, t := range cat.Tables {
+		for _, c := range t.Columns {
+			words := columnWords(c.Name)
+		heuristics:
+			for _, h := range piiHeuristics {
+				for _, seq := range h.words {
+					if hasWordSequence(words, seq) {
+						suggestions = append(suggestions, MaskSuggestion{
+							Schema:   t.Schema,
+							Table:    t.Name,
+							Column:   c.Name,
+							Category: h.category,
+							Strategy: h.strategy,
+						})
+						break heuristics
+					}
+				}
+			}
+		}
+	}
+	return suggestions
+}
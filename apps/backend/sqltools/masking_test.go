@@ -0,0 +1,119 @@
+package sqltools
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMaskPartial(t *testing.T) {
+	tests := []struct {
+		name  string
+		value string
+		keep  int
+		want  string
+	}{
+		{"empty", "", 4, ""},
+		{"one character", "a", 4, "*"},
+		{"short", "abc", 4, "**c"},
+		{"half", "abcdefgh", 4, "****efgh"},
+		{"long", "4111111111111111", 4, "************1111"},
+		{"keep none", "secret", 0, "******"},
+		{"negative keep", "secret", -2, "******"},
+		{"multi-byte", "日本語テキスト", 3, "****キスト"},
+		{"short multi-byte", "ñoño", 4, "**ño"},
+		{"emoji", "😀😁😂", 2, "**😂"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := maskPartial(tt.value, tt.keep); got != tt.want {
+				t.Errorf("maskPartial(%q, %d) = %q, want %q", tt.value, tt.keep, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMaskerRuleFor(t *testing.T) {
+	m := NewMasker("salt")
+	for _, rule := range []MaskRule{
+		{Column: "email", Strategy: MaskPartial, KeepChars: 4},
+		{Pattern: "^phone", Strategy: MaskHash},
+	} {
+		if err := m.AddRule(rule); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := m.AddRule(MaskRule{Pattern: "(", Strategy: MaskRedact}); err == nil {
+		t.Fatal("added a rule with an invalid pattern")
+	}
+	users := SourceColumn{TableOID: 16384, Attnum: 3}
+	m.AddSourceRule(users, MaskRule{Column: "email", Strategy: MaskNull})
+	m.AddSourceRule(users, MaskRule{Column: "email", Strategy: MaskRedact})
+
+	tests := []struct {
+		name     string
+		column   string
+		src      SourceColumn
+		strategy string // empty when no rule matches
+	}{
+		{"by name", "email", SourceColumn{}, MaskPartial},
+		{"name ignores case", "EMAIL", SourceColumn{}, MaskPartial},
+		{"by pattern", "Phone_Number", SourceColumn{}, MaskHash},
+		{"no match", "name", SourceColumn{}, ""},
+		{"source wins over name", "email", users, MaskNull},
+		{"source under an alias", "contact", users, MaskNull},
+		{"other source falls back to name", "email", SourceColumn{TableOID: 16384, Attnum: 4}, MaskPartial},
+		{"attnum without table", "contact", SourceColumn{Attnum: 3}, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rule, ok := m.RuleFor(tt.column, tt.src)
+			if ok != (tt.strategy != "") || rule.Strategy != tt.strategy {
+				t.Errorf("RuleFor(%q) = %q, %v; want %q", tt.column, rule.Strategy, ok, tt.strategy)
+			}
+		})
+	}
+
+	var empty *Masker
+	if _, ok := empty.RuleFor("email", users); ok {
+		t.Error("nil masker matched a rule")
+	}
+}
+
+func TestMask(t *testing.T) {
+	m := NewMasker("salt")
+	at := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name  string
+		rule  MaskRule
+		value interface{}
+		want  interface{}
+	}{
+		{"redact", MaskRule{Strategy: MaskRedact}, "ada@example.com", RedactedValue},
+		{"unknown strategy redacts", MaskRule{Strategy: "scramble"}, "ada", RedactedValue},
+		{"null", MaskRule{Strategy: MaskNull}, "ada", nil},
+		{"NULL stays NULL", MaskRule{Strategy: MaskRedact}, nil, nil},
+		{"partial number", MaskRule{Strategy: MaskPartial, KeepChars: 2}, 123456, "****56"},
+		{"partial bytes", MaskRule{Strategy: MaskPartial, KeepChars: 2}, []byte("abcd"), "**cd"},
+		{"partial time", MaskRule{Strategy: MaskPartial, KeepChars: 1}, at, strings.Repeat("*", 19) + "Z"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := m.Mask(tt.rule, tt.value); got != tt.want {
+				t.Errorf("Mask = %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	hash := MaskRule{Strategy: MaskHash}
+	if m.Mask(hash, "ada") != m.Mask(hash, []byte("ada")) {
+		t.Error("the same value hashed differently")
+	}
+	if m.Mask(hash, "ada") == NewMasker("other").Mask(hash, "ada") {
+		t.Error("hashes do not depend on the salt")
+	}
+}
@@ -0,0 +1,154 @@
+package sqltools
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"strings"
+)
+
+const placeholder = "?"
+
+// Normalize reduces sql to its shape: comments and formatting are dropped,
+// keywords are upper-cased, bare identifiers are folded to lower case and
+// literals and bind parameters become "?". Lists made only of placeholders
+// collapse to a single one, so "IN (1, 2, 3)" and "IN (4)" normalize alike.
+func Normalize(sql string) string {
+	var toks []Token
+	for _, t := range SignificantTokens(Tokenize(sql)) {
+		if isConstant(toks, t) {
+			// A sign in front of a number is part of the constant.
+			if n := len(toks); n > 0 && isUnarySign(toks) {
+				toks = toks[:n-1]
+			}
+			t = Token{Kind: TokenParam, Text: placeholder}
+		}
+		switch t.Kind {
+		case TokenKeyword:
+			t.Text = t.Upper()
+		case TokenIdentifier:
+			t.Text = strings.ToLower(t.Text)
+		}
+		toks = append(toks, t)
+	}
+
+	toks = collapsePlaceholderLists(toks)
+	for len(toks) > 0 && toks[len(toks)-1].IsPunct(";") {
+		toks = toks[:len(toks)-1]
+	}
+
+	var b strings.Builder
+	for i, t := range toks {
+		if i > 0 && needsSpace(toks[:i], t) {
+			b.WriteByte(' ')
+		}
+		b.WriteString(t.Text)
+	}
+	return b.String()
+}
+
+// Fingerprint identifies the shape of sql: queries that differ only in
+// literal values, formatting, comments or keyword case share a fingerprint.
+func Fingerprint(sql string) string {
+	normalized := Normalize(sql)
+	if normalized == "" {
+		return ""
+	}
+	sum := sha256.Sum256([]byte(normalized))
+	return hex.EncodeToString(sum[:8])
+}
+
+func isConstant(prev []Token, t Token) bool {
+	switch t.Kind {
+	case TokenString, TokenNumber, TokenParam:
+		return true
+	case TokenKeyword:
+		if !t.Is("TRUE") && !t.Is("FALSE") {
+			return false
+		}
+		// IS TRUE / IS NOT FALSE are predicates, not values.
+		n := len(prev)
+		return n == 0 || !(prev[n-1].Is("IS") || prev[n-1].Is("NOT"))
+	}
+	return false
+}
+
+// isUnarySign reports whether the last token is a sign rather than a binary
+// operator, judging by what precedes it.
+func isUnarySign(toks []Token) bool {
+	n := len(toks)
+	last := toks[n-1]
+	if last.Kind != TokenOperator || (last.Text != "-" && last.Text != "+") {
+		return false
+	}
+	if n == 1 {
+		return true
+	}
+	p := toks[n-2]
+	return p.Kind == TokenOperator || p.IsPunct("(") || p.IsPunct(",") ||
+		(p.Kind == TokenKeyword && !p.Is("END") && !p.Is("NULL"))
+}
+
+// collapsePlaceholderLists rewrites "IN (?, ?, ?)" and "VALUES (?, ?)" as
+// "(?)" and then repeated "(?), (?)" rows as a single "(?)". Function
+// arguments are left alone so calls of different arity stay distinct.
+func collapsePlaceholderLists(toks []Token) []Token {
+	out := make([]Token, 0, len(toks))
+	for i := 0; i < len(toks); i++ {
+		if toks[i].IsPunct("(") && i > 0 && startsValueList(toks[:i]) {
+			if end := placeholderListEnd(toks, i); end > 0 {
+				out = append(out, toks[i], Token{Kind: TokenParam, Text: placeholder}, toks[end])
+				i = end
+				continue
+			}
+		}
+		out = append(out, toks[i])
+	}
+
+	rows := make([]Token, 0, len(out))
+	for i := 0; i < len(out); i++ {
+		n := len(rows)
+		if out[i].IsPunct(",") && n >= 4 && isPlaceholderRow(rows[n-3:]) && !rows[n-4].IsName() &&
+			i+3 < len(out) && isPlaceholderRow(out[i+1:i+4]) {
+			i += 3
+			continue
+		}
+		rows = append(rows, out[i])
+	}
+	return rows
+}
+
+func startsValueList(prev []Token) bool {
+	p := prev[len(prev)-1]
+	switch {
+	case p.Is("IN") || p.Is("VALUES"):
+		return true
+	case p.IsPunct(","):
+		// the next row of a VALUES list
+		return len(prev) >= 2 && prev[len(prev)-2].IsPunct(")")
+	}
+	return false
+}
+
+// placeholderListEnd returns the index of the ")" closing a list that starts
+// at open and holds only placeholders and commas, or 0.
+func placeholderListEnd(toks []Token, open int) int {
+	expectValue := true
+	for j := open + 1; j < len(toks); j++ {
+		t := toks[j]
+		switch {
+		case expectValue && t.Kind == TokenParam:
+			expectValue = false
+		case !expectValue && t.IsPunct(","):
+			expectValue = true
+		case !expectValue && t.IsPunct(")"):
+			return j
+		default:
+			return 0
+		}
+	}
+	return 0
+}
+
+func isPlaceholderRow(toks []Token) bool {
+	return len(toks) == 3 && toks[0].IsPunct("(") && toks[1].Kind == TokenParam && toks[2].IsPunct(")")
+}
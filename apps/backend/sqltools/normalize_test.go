@@ -0,0 +1,84 @@
+package sqltools
+
+import "testing"
+
+func TestNormalize(t *testing.T) {
+	tests := []struct {
+		name string
+		sql  string
+		want string
+	}{
+		{"literal", "SELECT * FROM users WHERE id = 42", "SELECT * FROM users WHERE id = ?"},
+		{"layout, comments and case", "select *\n  from Users -- c\n where ID = 7;", "SELECT * FROM users WHERE id = ?"},
+		{"negative number", "select * from users where id = -5", "SELECT * FROM users WHERE id = ?"},
+		{"binary minus", "select a - 1 from t", "SELECT a - ? FROM t"},
+		{"IN list", "select * from t where x in (1,2,3)", "SELECT * FROM t WHERE x IN (?)"},
+		{"VALUES rows", "insert into t values (1,'a'),(2,'b')", "INSERT INTO t VALUES (?)"},
+		{"function arguments", "select f(1, 2), f(3)", "SELECT f(?, ?), f(?)"},
+		{"boolean predicate", "select * from t where a is true and b = true", "SELECT * FROM t WHERE a IS TRUE AND b = ?"},
+		{"strings and parameters", "select * from t where name = 'O''Brien' and tag = $tag$x$tag$ and p = $1",
+			"SELECT * FROM t WHERE name = ? AND tag = ? AND p = ?"},
+		{"quoted identifier", `select "Mixed" from T`, `SELECT "Mixed" FROM t`},
+		{"comment only", "  -- only a comment", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Normalize(tt.sql); got != tt.want {
+				t.Errorf("Normalize = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFingerprint(t *testing.T) {
+	same := [][]string{
+		{
+			"SELECT * FROM users WHERE id = 42",
+			"select * from users where id = 7",
+			"select *\nfrom users -- by id\nwhere id = $1;",
+			"SELECT * FROM users WHERE id = -1",
+		},
+		{
+			"select * from t where name = 'ada' and x in (1, 2, 3)",
+			"select * from t where name = 'grace' and x in (4)",
+		},
+		{
+			"insert into t (a, b) values (1, 'a')",
+			"insert into t (a, b) values (2, 'b'), (3, 'c')",
+		},
+	}
+	seen := map[string]int{}
+	for group, queries := range same {
+		want := Fingerprint(queries[0])
+		if len(want) != 16 {
+			t.Fatalf("fingerprint %q is not 16 hex digits", want)
+		}
+		for _, q := range queries[1:] {
+			if got := Fingerprint(q); got != want {
+				t.Errorf("Fingerprint(%q) = %s, want %s like %q", q, got, want, queries[0])
+			}
+		}
+		if other, ok := seen[want]; ok {
+			t.Errorf("groups %d and %d share a fingerprint", other, group)
+		}
+		seen[want] = group
+	}
+
+	different := [][2]string{
+		{"select a from t", "select b from t"},
+		{"select * from t where a = 1", "select * from t where a > 1"},
+		{"select f(1)", "select f(1, 2)"},
+		{"select * from t where a is true", "select * from t where a = true"},
+		{`select "A" from t`, `select "a" from t`},
+	}
+	for _, d := range different {
+		if Fingerprint(d[0]) == Fingerprint(d[1]) {
+			t.Errorf("%q and %q share a fingerprint", d[0], d[1])
+		}
+	}
+
+	if got := Fingerprint(" -- nothing\n"); got != "" {
+		t.Errorf("Fingerprint of an empty query = %q", got)
+	}
+}
@@ -0,0 +1,429 @@
+package sqltools
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+const (
+	PolicyDeny = "deny"
+	PolicyMask = "mask"
+)
+
+// MaskedValue replaces every value of a masked column.
+const MaskedValue = "****"
+
+// Policy hides part of a database. An empty Table covers the whole schema
+// and an empty Column the whole table; masking only applies to columns.
+type Policy struct {
+	Schema string `json:"schema"`
+	Table  string `json:"table,omitempty"`
+	Column string `json:"column,omitempty"`
+	Action string `json:"action"`
+}
+
+func (p Policy) covers(schema, table string) bool {
+	return p.Schema == schema && (p.Table == "" || p.Table == table)
+}
+
+// PolicySet is the policies that apply to one user of a database.
+type PolicySet []Policy
+
+// RelationDenied reports whether a whole table or its schema is denied.
+func (ps PolicySet) RelationDenied(schema, table string) bool {
+	for _, p := range ps {
+		if p.Action == PolicyDeny && p.Column == "" && p.covers(schema, table) {
+			return true
+		}
+	}
+	return false
+}
+
+// ColumnAction returns PolicyDeny, PolicyMask or "" for a column. Deny wins
+// when both apply.
+func (ps PolicySet) ColumnAction(schema, table, column string) string {
+	if ps.RelationDenied(schema, table) {
+		return PolicyDeny
+	}
+	action := ""
+	for _, p := range ps {
+		if p.Column != column || !p.covers(schema, table) {
+			continue
+		}
+		if p.Action == PolicyDeny {
+			return PolicyDeny
+		}
+		action = p.Action
+	}
+	return action
+}
+
+// restricts reports whether any column of the table is denied or masked.
+func (ps PolicySet) restricts(schema, table string) bool {
+	for _, p := range ps {
+		if p.Column != "" && p.covers(schema, table) {
+			return true
+		}
+	}
+	return false
+}
+
+// PolicyError reports a query refused by a policy.
+type PolicyError struct {
+	Message string
+}
+
+func (e *PolicyError) Error() string {
+	return e.Message
+}
+
+// FilterCatalog returns the part of cat the policies leave visible: denied
+// tables and columns are dropped, masked columns are kept.
+func (ps PolicySet) FilterCatalog(cat *Catalog) *Catalog {
+	out := &Catalog{Functions: cat.Functions}
+	for _, t := range cat.Tables {
+		if ps.RelationDenied(t.Schema, t.Name) {
+			continue
+		}
+		table := CatalogTable{Schema: t.Schema, Name: t.Name, IsView: t.IsView, Columns: []CatalogColumn{}}
+		for _, c := range t.Columns {
+			if ps.ColumnAction(t.Schema, t.Name, c.Name) != PolicyDeny {
+				table.Columns = append(table.Columns, c)
+			}
+		}
+		out.Tables = append(out.Tables, table)
+	}
+	return out
+}
+
+// safeFunctions are the functions a query may call on a database with
+// access policies: built-ins that compute on their arguments and read
+// nothing else. Other functions can read tables the query never names, as
+// table_to_xml and query_to_xml do, so calls to them are refused, and so
+// are calls qualified with a schema other than pg_catalog. Type modifiers
+// such as numeric(10, 2) are listed because they look like calls.
+var safeFunctions = map[string]bool{}
+
+var safeFunctionList = []string{
+	// Aggregates and window functions
+	"count", "sum", "avg", "min", "max", "array_agg", "string_agg", "bool_and", "bool_or",
+	"every", "bit_and", "bit_or", "stddev", "stddev_pop", "stddev_samp", "variance",
+	"var_pop", "var_samp", "corr", "covar_pop", "covar_samp", "regr_slope", "regr_intercept",
+	"percentile_cont", "percentile_disc", "mode", "json_agg", "jsonb_agg", "json_object_agg",
+	"jsonb_object_agg", "row_number", "rank", "dense_rank", "percent_rank", "cume_dist",
+	"ntile", "lag", "lead", "first_value", "last_value", "nth_value", "grouping",
+	// Conditionals and SQL syntax that reads like a call
+	"coalesce", "nullif", "greatest", "least", "row", "extract", "overlay", "position",
+	"substring", "trim", "normalize",
+	// Strings
+	"lower", "upper", "initcap", "length", "char_length", "character_length", "octet_length",
+	"bit_length", "substr", "left", "right", "btrim", "ltrim", "rtrim", "lpad", "rpad",
+	"concat", "concat_ws", "replace", "split_part", "strpos", "reverse", "repeat",
+	"translate", "starts_with", "md5", "sha256", "encode", "decode", "ascii", "chr",
+	"quote_ident", "quote_literal", "quote_nullable", "regexp_replace", "regexp_match",
+	"regexp_matches", "regexp_like", "regexp_count", "regexp_substr", "regexp_split_to_array",
+	"regexp_split_to_table", "string_to_array", "array_to_string", "to_char", "to_number",
+	"to_hex",
+	// Numbers
+	"abs", "ceil", "ceiling", "floor", "round", "trunc", "mod", "div", "power", "sqrt",
+	"cbrt", "exp", "ln", "log", "log10", "sign", "pi", "degrees", "radians", "random",
+	"width_bucket", "gcd", "lcm",
+	// Dates and times
+	"now", "clock_timestamp", "statement_timestamp", "transaction_timestamp", "timeofday",
+	"age", "date_part", "date_trunc", "date_bin", "make_date", "make_time", "make_timestamp",
+	"make_timestamptz", "make_interval", "to_date", "to_timestamp", "justify_days",
+	"justify_hours", "justify_interval", "isfinite", "timezone",
+	// JSON
+	"to_json", "to_jsonb", "row_to_json", "array_to_json", "json_build_object",
+	"jsonb_build_object", "json_build_array", "jsonb_build_array", "json_object", "jsonb_object",
+	"json_extract_path", "jsonb_extract_path", "json_extract_path_text",
+	"jsonb_extract_path_text", "json_array_elements", "jsonb_array_elements",
+	"json_array_elements_text", "jsonb_array_elements_text", "json_each", "jsonb_each",
+	"json_each_text", "jsonb_each_text", "json_object_keys", "jsonb_object_keys",
+	"json_array_length", "jsonb_array_length", "json_typeof", "jsonb_typeof", "jsonb_set",
+	"jsonb_insert", "jsonb_strip_nulls", "json_strip_nulls", "jsonb_pretty",
+	"jsonb_path_query", "jsonb_path_query_array", "jsonb_path_query_first", "jsonb_path_exists",
+	// Arrays and sets
+	"array_length", "array_upper", "array_lower", "array_ndims", "array_dims", "cardinality",
+	"array_append", "array_prepend", "array_cat", "array_remove", "array_replace",
+	"array_position", "array_positions", "unnest", "generate_series", "generate_subscripts",
+	// Type modifiers
+	"numeric", "decimal", "varchar", "char", "character", "bit", "varbit", "time",
+	"timestamp", "timestamptz", "interval", "float",
+}
+
+func init() {
+	for _, f := range safeFunctionList {
+		safeFunctions[f] = true
+	}
+}
+
+// checkFunctions refuses sql, a query or an expression of its plan, if it
+// calls a function not in safeFunctions. A name followed by a parenthesis
+// is taken for a call unless it is clearly a type, an alias with column
+// names or a common table expression; anything unclear is refused.
+func checkFunctions(sql string) error {
+	tokens := SignificantTokens(Tokenize(sql))
+	for i := 0; i+1 < len(tokens); i++ {
+		t := tokens[i]
+		// Keywords followed by a parenthesis are syntax: IN, EXISTS, CAST...
+		if !t.IsName() || !tokens[i+1].IsPunct("(") || !isCall(tokens, i) {
+			continue
+		}
+		if !safeFunctions[t.Name()] || (i > 0 && tokens[i-1].IsPunct(".") && (i < 2 || tokens[i-2].Name() != "pg_catalog")) {
+			return &PolicyError{Message: fmt.Sprintf("calling %s is not allowed on databases with access policies", t.Name())}
+		}
+	}
+	return nil
+}
+
+// isCall reports whether the name at tokens[i], followed by a parenthesis,
+// calls a function.
+func isCall(tokens []Token, i int) bool {
+	if i == 0 {
+		return true
+	}
+	prev := tokens[i-1]
+	switch {
+	case prev.Text == "::", prev.Is("AS"), prev.Is("INTO"):
+		// A type with modifiers, or an alias or target with column names
+		return false
+	case prev.IsPunct(")"):
+		// An alias with column names after a function or subquery in FROM;
+		// a call cannot follow an expression
+		return false
+	case prev.IsName():
+		// The second word of character varying(10), or an alias with
+		// column names after a table in FROM
+		if tokens[i].Name() == "varying" {
+			return false
+		}
+		if i >= 2 {
+			before := tokens[i-2]
+			if before.Is("FROM") || before.Is("JOIN") || before.Is("ONLY") || before.IsPunct(",") {
+				return false
+			}
+			if i >= 3 && before.IsPunct(".") && tokens[i-3].IsName() {
+				return false
+			}
+		}
+		return true
+	}
+	return !startsCTE(tokens, i)
+}
+
+// startsCTE reports whether tokens[i] names a common table expression: it
+// follows WITH, or the comma after the previous one, as in
+// WITH a AS (...), b (x) AS (...).
+func startsCTE(tokens []Token, i int) bool {
+	for i > 0 {
+		prev := tokens[i-1]
+		if prev.Is("WITH") || prev.Is("RECURSIVE") {
+			return true
+		}
+		if !prev.IsPunct(",") || i < 2 || !tokens[i-2].IsPunct(")") {
+			return false
+		}
+		// The comma must end name [(columns)] AS [NOT] [MATERIALIZED] (query)
+		j := matchingParen(tokens, i-2) - 1
+		for j >= 0 && (tokens[j].Is("MATERIALIZED") || tokens[j].Is("NOT")) {
+			j--
+		}
+		if j < 0 || !tokens[j].Is("AS") {
+			return false
+		}
+		j--
+		if j >= 0 && tokens[j].IsPunct(")") {
+			j = matchingParen(tokens, j) - 1
+		}
+		if j < 0 || !tokens[j].IsName() {
+			return false
+		}
+		i = j
+	}
+	return false
+}
+
+// matchingParen returns the index of the parenthesis opening the one that
+// closes at tokens[close], or -1.
+func matchingParen(tokens []Token, close int) int {
+	depth := 0
+	for j := close; j >= 0; j-- {
+		switch {
+		case tokens[j].IsPunct(")"):
+			depth++
+		case tokens[j].IsPunct("("):
+			depth--
+			if depth == 0 {
+				return j
+			}
+		}
+	}
+	return -1
+}
+
+// ApplyPolicies rewrites sql so it can only read what the policies allow.
+// A query naming a denied table or schema, or calling a function that is
+// not known to be safe, is refused. Every reference to a
+// table with denied or masked columns is replaced by a subquery that leaves
+// the denied columns out and returns MaskedValue for the masked ones, so
+// "*", whole-row references and filters all see the restricted table.
+//
+// The subqueries read the table under aliases starting with aliasPrefix;
+// VerifyPlan uses that to confirm the rewrite caught every reference.
+// Callers should pick a prefix the query cannot guess.
+func ApplyPolicies(sql string, cat *Catalog, ps PolicySet, aliasPrefix string) (string, error) {
+	if len(ps) == 0 {
+		return sql, nil
+	}
+
+	if err := checkFunctions(sql); err != nil {
+		return "", err
+	}
+
+	a := Analyze(sql, cat)
+
+	type replacement struct {
+		start, end int
+		text       string
+	}
+	var replacements []replacement
+
+	for _, blk := range a.Blocks {
+		for _, ref := range blk.Refs {
+			if ref.Kind != RefTable {
+				continue
+			}
+			if ref.Table == nil {
+				// Unresolved names are left to VerifyPlan, except where
+				// the schema alone is enough to refuse them
+				if ref.Schema != "" && ps.RelationDenied(ref.Schema, ref.Name) {
+					return "", &PolicyError{Message: fmt.Sprintf("access to %s.%s is denied", ref.Schema, ref.Name)}
+				}
+				continue
+			}
+			t := ref.Table
+			if ps.RelationDenied(t.Schema, t.Name) {
+				return "", &PolicyError{Message: fmt.Sprintf("access to %s is denied", t.QualifiedName())}
+			}
+			if !ps.restricts(t.Schema, t.Name) {
+				continue
+			}
+
+			var cols []string
+			for _, c := range t.Columns {
+				switch ps.ColumnAction(t.Schema, t.Name, c.Name) {
+				case PolicyDeny:
+				case PolicyMask:
+					cols = append(cols, fmt.Sprintf("'%s'::text AS %s", MaskedValue, quoteIdentIfNeeded(c.Name)))
+				default:
+					cols = append(cols, quoteIdentIfNeeded(c.Name))
+				}
+			}
+			if len(cols) == 0 {
+				return "", &PolicyError{Message: fmt.Sprintf("access to every column of %s is denied", t.QualifiedName())}
+			}
+
+			text := fmt.Sprintf("(SELECT %s FROM %s.%s AS %s%d)",
+				strings.Join(cols, ", "), quoteIdentIfNeeded(t.Schema), quoteIdentIfNeeded(t.Name),
+				aliasPrefix, len(replacements)+1)
+			if ref.Alias == "" {
+				// Keep the name the rest of the query uses for it
+				text += " AS " + quoteIdentIfNeeded(ref.Name)
+			}
+			replacements = append(replacements, replacement{start: ref.Start, end: ref.End, text: text})
+		}
+	}
+
+	sort.Slice(replacements, func(i, j int) bool { return replacements[i].start > replacements[j].start })
+	for _, r := range replacements {
+		sql = sql[:r.start] + r.text + sql[r.end:]
+	}
+	return sql, nil
+}
+
+// VerifyPlan checks an EXPLAIN (VERBOSE, FORMAT JSON) plan of a query
+// rewritten by ApplyPolicies. It refuses plans that scan a denied table, or
+// a table with restricted columns other than through the rewrite's
+// subqueries, which happens when the query reaches a table in a way
+// ApplyPolicies does not recognise. Tables read inside functions do not
+// show in plans, so it also refuses plans whose expressions call functions
+// ApplyPolicies would refuse. Views are expanded in plans, so both checks
+// cover what a view reads and calls.
+func VerifyPlan(plan []byte, ps PolicySet, aliasPrefix string) error {
+	if len(ps) == 0 {
+		return nil
+	}
+
+	var root interface{}
+	if err := json.Unmarshal(plan, &root); err != nil {
+		return fmt.Errorf("invalid plan: %w", err)
+	}
+	return verifyPlanNode(root, ps, aliasPrefix)
+}
+
+func verifyPlanNode(node interface{}, ps PolicySet, aliasPrefix string) error {
+	switch n := node.(type) {
+	case []interface{}:
+		for _, child := range n {
+			if err := verifyPlanNode(child, ps, aliasPrefix); err != nil {
+				return err
+			}
+		}
+	case map[string]interface{}:
+		if function, ok := n["Function Name"].(string); ok && !safeFunctions[function] {
+			return &PolicyError{Message: fmt.Sprintf("calling %s is not allowed on databases with access policies", function)}
+		}
+		for key, value := range n {
+			if planNameKeys[key] {
+				continue
+			}
+			if err := verifyPlanExpressions(value); err != nil {
+				return err
+			}
+		}
+		if relation, ok := n["Relation Name"].(string); ok {
+			schema, _ := n["Schema"].(string)
+			alias, _ := n["Alias"].(string)
+			if ps.RelationDenied(schema, relation) {
+				return &PolicyError{Message: fmt.Sprintf("access to %s.%s is denied", schema, relation)}
+			}
+			if ps.restricts(schema, relation) && !strings.HasPrefix(alias, aliasPrefix) {
+				return &PolicyError{Message: fmt.Sprintf("%s.%s has restricted columns and can only be read by naming it in FROM", schema, relation)}
+			}
+		}
+		for _, key := range []string{"Plan", "Plans"} {
+			if child, ok := n[key]; ok {
+				if err := verifyPlanNode(child, ps, aliasPrefix); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// planNameKeys are the plan fields that hold names rather than expressions.
+var planNameKeys = map[string]bool{
+	"Node Type": true, "Relation Name": true, "Schema": true, "Alias": true, "Index Name": true,
+	"CTE Name": true, "Subplan Name": true, "Function Name": true, "Parent Relationship": true,
+	"Plan": true, "Plans": true,
+}
+
+// verifyPlanExpressions checks the function calls of a plan field's
+// expressions: a string or a list of them.
+func verifyPlanExpressions(value interface{}) error {
+	switch v := value.(type) {
+	case string:
+		return checkFunctions(v)
+	case []interface{}:
+		for _, item := range v {
+			if err := verifyPlanExpressions(item); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
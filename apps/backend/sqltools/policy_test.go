@@ -0,0 +1,136 @@
+package sqltools
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+const testAliasPrefix = "_policy_test_"
+
+func testPolicyCatalog() *Catalog {
+	return &Catalog{Tables: []CatalogTable{
+		{Schema: "public", Name: "users", Columns: []CatalogColumn{
+			{Name: "id", Type: "integer"}, {Name: "email", Type: "text"}, {Name: "password_hash", Type: "text"},
+		}},
+		{Schema: "public", Name: "orders", Columns: []CatalogColumn{
+			{Name: "id", Type: "integer"}, {Name: "user_id", Type: "integer"}, {Name: "total", Type: "numeric"},
+		}},
+		{Schema: "secret", Name: "keys", Columns: []CatalogColumn{{Name: "value", Type: "text"}}},
+	}}
+}
+
+var testPolicies = PolicySet{
+	{Schema: "public", Table: "users", Column: "password_hash", Action: PolicyDeny},
+	{Schema: "public", Table: "users", Column: "email", Action: PolicyMask},
+	{Schema: "secret", Action: PolicyDeny},
+}
+
+func TestApplyPoliciesRefusesFunctionBypasses(t *testing.T) {
+	queries := []string{
+		`SELECT table_to_xml('public.users', true, false, '')`,
+		`SELECT query_to_xml('select * from users', true, false, '')`,
+		`SELECT cursor_to_xml('c', 10, true, false, '')`,
+		`SELECT schema_to_xml('public', true, false, '')`,
+		`SELECT database_to_xml(true, false, '')`,
+		`SELECT pg_catalog.table_to_xml('public.users', true, false, '')`,
+		`SELECT "table_to_xml"('public.users', true, false, '')`,
+		`SELECT U&"table_to_xml"('public.users', true, false, '')`,
+		`SELECT TABLE_TO_XML /* comment */ ('public.users', true, false, '')`,
+		`SELECT lower(query_to_xml('select * from users', true, false, '')::text)`,
+		`SELECT * FROM orders o, query_to_xml('select * from users', true, false, '') x`,
+		`SELECT * FROM orders JOIN LATERAL table_to_xml('public.users', true, false, '') x ON true`,
+		`SELECT now() AT TIME ZONE table_to_xml('public.users', true, false, '')::text`,
+		`SELECT 'a' LIKE 'b' ESCAPE table_to_xml('public.users', true, false, '')::text`,
+		`SELECT * FROM orders, dblink('select * from users') AS (x text)`,
+		`SELECT * FROM (SELECT 'select * from users' AS q) AS s (q), dblink(q) AS (x text)`,
+		`WITH a AS (SELECT 1) SELECT * FROM a, query_to_xml('select * from users', true, false, '') AS (x xml)`,
+		`SELECT public.lower(email) FROM orders`,
+		`SELECT pg_read_file('/etc/passwd')`,
+		`SELECT current_setting('app.secret')`,
+	}
+	for _, q := range queries {
+		_, err := ApplyPolicies(q, testPolicyCatalog(), testPolicies, testAliasPrefix)
+		var policyErr *PolicyError
+		if !errors.As(err, &policyErr) {
+			t.Errorf("ApplyPolicies(%q) = %v, want a policy error", q, err)
+		}
+	}
+}
+
+func TestApplyPoliciesAllowsSafeQueries(t *testing.T) {
+	queries := []string{
+		`SELECT count(*), lower(email) FROM users`,
+		`SELECT pg_catalog.upper(email) FROM users`,
+		`SELECT CAST(total AS numeric(10, 2)), total::numeric(10, 2), id::character varying(10) FROM orders`,
+		`SELECT coalesce(sum(total), 0) FILTER (WHERE total > 0) FROM orders`,
+		`SELECT row_number() OVER (PARTITION BY user_id ORDER BY total) FROM orders`,
+		`SELECT * FROM generate_series(1, 3) g(i)`,
+		`SELECT * FROM generate_series(1, 3) AS g(i)`,
+		`SELECT * FROM orders o(a, b, c)`,
+		`SELECT * FROM public.orders o(a, b, c)`,
+		`WITH t(n) AS (SELECT 1), u (m) AS MATERIALIZED (SELECT 2) SELECT * FROM t, u`,
+		`WITH RECURSIVE r(n) AS (SELECT 1 UNION ALL SELECT n + 1 FROM r WHERE n < 3) SELECT n FROM r`,
+		`SELECT id FROM orders WHERE user_id IN (SELECT id FROM users) AND EXISTS (SELECT 1)`,
+		`SELECT now() AT TIME ZONE 'UTC', date_trunc('day', now())`,
+		`SELECT 'table_to_xml(1)' AS note`,
+	}
+	for _, q := range queries {
+		if _, err := ApplyPolicies(q, testPolicyCatalog(), testPolicies, testAliasPrefix); err != nil {
+			t.Errorf("ApplyPolicies(%q) = %v", q, err)
+		}
+	}
+}
+
+func TestApplyPoliciesRewritesRestrictedTables(t *testing.T) {
+	out, err := ApplyPolicies(`SELECT * FROM users u WHERE u.id = 1`, testPolicyCatalog(), testPolicies, testAliasPrefix)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(out, "password_hash") {
+		t.Errorf("denied column survives the rewrite: %s", out)
+	}
+	if !strings.Contains(out, "'"+MaskedValue+"'::text AS email") || !strings.Contains(out, testAliasPrefix+"1") {
+		t.Errorf("rewrite does not mask email through an aliased subquery: %s", out)
+	}
+
+	if _, err := ApplyPolicies(`SELECT * FROM secret.keys`, testPolicyCatalog(), testPolicies, testAliasPrefix); err == nil {
+		t.Error("read a denied schema")
+	}
+}
+
+func TestVerifyPlan(t *testing.T) {
+	tests := []struct {
+		name    string
+		plan    string
+		refused bool
+	}{
+		{"rewritten scan", `[{"Plan": {"Node Type": "Seq Scan", "Schema": "public", "Relation Name": "users",
+			"Alias": "_policy_test_1", "Output": ["_policy_test_1.id", "'****'::text"]}}]`, false},
+		{"direct scan of a restricted table", `[{"Plan": {"Node Type": "Seq Scan", "Schema": "public",
+			"Relation Name": "users", "Alias": "users", "Output": ["users.password_hash"]}}]`, true},
+		{"denied schema", `[{"Plan": {"Node Type": "Seq Scan", "Schema": "secret", "Relation Name": "keys", "Alias": "keys"}}]`, true},
+		{"function in a view's output", `[{"Plan": {"Node Type": "Result",
+			"Output": ["table_to_xml('users'::regclass, true, false, ''::text)"]}}]`, true},
+		{"function scan", `[{"Plan": {"Node Type": "Function Scan", "Function Name": "query_to_xml", "Schema": "pg_catalog",
+			"Alias": "x", "Function Call": "query_to_xml('select * from users'::text, true, false, ''::text)"}}]`, true},
+		{"function in a nested filter", `[{"Plan": {"Node Type": "Nested Loop", "Plans": [{"Node Type": "Seq Scan",
+			"Schema": "public", "Relation Name": "orders", "Alias": "orders",
+			"Filter": "(length((database_to_xml(true, false, ''::text))::text) > 0)"}]}}]`, true},
+		{"safe expressions", `[{"Plan": {"Node Type": "Aggregate", "Output": ["count(*)", "lower(_policy_test_1.email)",
+			"(orders.total)::numeric(10,2)", "timezone('UTC'::text, now())", "COALESCE(sum(orders.total), '0'::numeric)"],
+			"Plans": [{"Node Type": "Seq Scan", "Schema": "public", "Relation Name": "orders", "Alias": "orders"}]}}]`, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := VerifyPlan([]byte(tt.plan), testPolicies, testAliasPrefix)
+			var policyErr *PolicyError
+			if tt.refused && !errors.As(err, &policyErr) {
+				t.Fatalf("VerifyPlan = %v, want a policy error", err)
+			}
+			if !tt.refused && err != nil {
+				t.Fatalf("VerifyPlan = %v", err)
+			}
+		})
+	}
+}
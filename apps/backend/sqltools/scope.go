@@ -0,0 +1,475 @@
+package sqltools
+
+import (
+	"strings"
+)
+
+type RefKind int
+
+const (
+	RefTable RefKind = iota
+	RefCTE
+	RefDerived
+)
+
+// TableRef is a relation referenced in the FROM list (or UPDATE/INSERT target)
+// of a query block.
+type TableRef struct {
+	Schema string
+	Name   string
+	Alias  string
+	Kind   RefKind
+	Table  *CatalogTable
+	Start  int
+	End    int
+}
+
+// Binding is the name the relation is visible under inside the query.
+func (r TableRef) Binding() string {
+	if r.Alias != "" {
+		return r.Alias
+	}
+	return r.Name
+}
+
+// Known reports whether the relation's columns are known from the catalog.
+func (r TableRef) Known() bool {
+	return r.Kind == RefTable && r.Table != nil
+}
+
+type joinInfo struct {
+	start, end   int
+	comma        bool
+	cross        bool
+	hasCondition bool
+}
+
+// Block is one SELECT/INSERT/UPDATE/DELETE level. Subqueries get their own
+// block whose parent is the enclosing query, mirroring SQL name resolution.
+type Block struct {
+	Parent  *Block
+	Refs    []TableRef
+	CTEs    map[string]bool
+	Aliases map[string]bool
+
+	joins     []joinInfo
+	hasWhere  bool
+	natural   bool
+	usingCols map[string]bool
+
+	depth       int
+	clause      string
+	inFrom      bool
+	inCondition bool
+	expectTable bool
+}
+
+func newBlock(parent *Block) *Block {
+	return &Block{
+		Parent:    parent,
+		CTEs:      make(map[string]bool),
+		Aliases:   make(map[string]bool),
+		usingCols: make(map[string]bool),
+	}
+}
+
+// Lookup finds a relation by binding name in this block or an enclosing one.
+func (b *Block) Lookup(binding string) *TableRef {
+	for blk := b; blk != nil; blk = blk.Parent {
+		for i := range blk.Refs {
+			if blk.Refs[i].Binding() == binding {
+				return &blk.Refs[i]
+			}
+		}
+	}
+	return nil
+}
+
+func (b *Block) isCTE(name string) bool {
+	for blk := b; blk != nil; blk = blk.Parent {
+		if blk.CTEs[name] {
+			return true
+		}
+	}
+	return false
+}
+
+func (b *Block) isAlias(name string) bool {
+	for blk := b; blk != nil; blk = blk.Parent {
+		if blk.Aliases[name] {
+			return true
+		}
+	}
+	return false
+}
+
+// Analysis is the result of walking a SQL text: its significant tokens, the
+// query block each token belongs to, and the clause in effect after it.
+type Analysis struct {
+	Tokens []Token
+	Blocks []*Block
+
+	blockOf  []*Block
+	clauseOf []string
+	ignore   map[int]bool
+	catalog  *Catalog
+}
+
+type frame struct {
+	block   *Block
+	isBlock bool
+	derived bool
+}
+
+// Analyze walks sql and resolves table references against cat. It is
+// deliberately forgiving: incomplete SQL is analysed as far as it goes.
+func Analyze(sql string, cat *Catalog) *Analysis {
+	if cat == nil {
+		cat = &Catalog{}
+	}
+	toks := SignificantTokens(Tokenize(sql))
+	a := &Analysis{
+		Tokens:   toks,
+		blockOf:  make([]*Block, len(toks)),
+		clauseOf: make([]string, len(toks)),
+		ignore:   make(map[int]bool),
+		catalog:  cat,
+	}
+
+	match := matchParens(toks)
+	cur := newBlock(nil)
+	a.Blocks = append(a.Blocks, cur)
+	stack := []frame{{block: cur, isBlock: true}}
+
+	for i := 0; i < len(toks); i++ {
+		t := toks[i]
+		a.blockOf[i] = cur
+		first := i
+
+		switch {
+		case t.IsPunct("("):
+			if i+1 < len(toks) && (toks[i+1].Is("SELECT") || toks[i+1].Is("WITH") || toks[i+1].Is("VALUES")) {
+				derived := cur.depth == 0 && cur.expectTable
+				if derived {
+					cur.expectTable = false
+				}
+				child := newBlock(cur)
+				a.Blocks = append(a.Blocks, child)
+				stack = append(stack, frame{block: child, isBlock: true, derived: derived})
+				cur = child
+			} else {
+				cur.depth++
+				stack = append(stack, frame{block: cur})
+			}
+
+		case t.IsPunct(")"):
+			if len(stack) == 1 {
+				continue
+			}
+			top := stack[len(stack)-1]
+			stack = stack[:len(stack)-1]
+			if !top.isBlock {
+				cur.depth--
+				continue
+			}
+			cur = stack[len(stack)-1].block
+			a.blockOf[i] = cur
+			if top.derived {
+				ref := TableRef{Kind: RefDerived, Start: t.Start, End: t.End}
+				i = a.parseAlias(i+1, &ref, match) - 1
+				cur.Refs = append(cur.Refs, ref)
+			}
+
+		case t.IsPunct(";") && len(stack) == 1:
+			cur = newBlock(nil)
+			a.Blocks = append(a.Blocks, cur)
+			stack = []frame{{block: cur, isBlock: true}}
+
+		case t.IsPunct(","):
+			if cur.depth == 0 && cur.inFrom && !cur.inCondition {
+				cur.expectTable = true
+				cur.joins = append(cur.joins, joinInfo{start: t.Start, end: t.End, comma: true})
+			}
+
+		case t.Kind == TokenOperator && t.Text == "::":
+			a.ignoreTypeName(i + 1)
+
+		case t.Kind == TokenKeyword:
+			i = a.keyword(i, cur, match)
+
+		case t.IsName() && cur.depth == 0 && cur.expectTable:
+			i = a.parseTableRef(i, cur, match)
+
+		case t.IsName() && cur.depth == 0 && cur.clause == "SELECT" && i > 0 && endsExpression(toks[i-1]):
+			// Implicit column alias: SELECT count(*) total
+			if !(i+1 < len(toks) && (toks[i+1].IsPunct(".") || toks[i+1].IsPunct("("))) {
+				a.ignore[i] = true
+				cur.Aliases[t.Name()] = true
+			}
+		}
+
+		// Tokens consumed by look-ahead belong to the block they were read in.
+		for k := first + 1; k <= i && k < len(toks); k++ {
+			a.blockOf[k] = cur
+		}
+		for k := first; k <= i && k < len(toks); k++ {
+			a.clauseOf[k] = cur.clause
+		}
+	}
+
+	return a
+}
+
+func (a *Analysis) keyword(i int, cur *Block, match map[int]int) int {
+	toks := a.Tokens
+	u := toks[i].Upper()
+
+	if u == "AS" {
+		if i+1 < len(toks) && toks[i+1].IsName() {
+			a.ignore[i+1] = true
+			cur.Aliases[toks[i+1].Name()] = true
+		}
+		return i
+	}
+	if u == "WITH" {
+		a.parseCTEs(i+1, cur, match)
+		return i
+	}
+	if cur.depth != 0 {
+		return i
+	}
+
+	switch {
+	case u == "FROM":
+		cur.clause = "FROM"
+		cur.inFrom = true
+		cur.inCondition = false
+		cur.expectTable = true
+	case u == "JOIN":
+		cur.clause = "FROM"
+		cur.inFrom = true
+		cur.inCondition = false
+		cur.expectTable = true
+		join := joinInfo{start: toks[i].Start, end: toks[i].End}
+		for j := i - 1; j >= 0 && toks[j].Kind == TokenKeyword && joinModifiers[toks[j].Upper()]; j-- {
+			if toks[j].Is("CROSS") || toks[j].Is("NATURAL") {
+				join.cross = true
+			}
+			if toks[j].Is("NATURAL") {
+				cur.natural = true
+			}
+			join.start = toks[j].Start
+		}
+		cur.joins = append(cur.joins, join)
+	case u == "ON" || u == "USING":
+		if cur.inFrom {
+			cur.clause = "ON"
+			cur.inCondition = true
+			cur.expectTable = false
+			if n := len(cur.joins); n > 0 {
+				cur.joins[n-1].hasCondition = true
+			}
+			if u == "USING" && i+1 < len(toks) && toks[i+1].IsPunct("(") {
+				if close, ok := match[i+1]; ok {
+					for k := i + 2; k < close; k++ {
+						if toks[k].IsName() {
+							a.ignore[k] = true
+							cur.usingCols[toks[k].Name()] = true
+						}
+					}
+				}
+			}
+		}
+	case u == "UPDATE" || u == "INTO":
+		cur.clause = u
+		cur.inFrom = false
+		cur.expectTable = true
+	case u == "TABLE" || u == "ONLY" || u == "LATERAL":
+		// Modifiers that keep waiting for a relation name.
+	case u == "WHERE":
+		cur.hasWhere = true
+		fallthrough
+	case clauseKeywords[u]:
+		cur.clause = u
+		cur.inFrom = false
+		cur.inCondition = false
+		cur.expectTable = false
+	}
+	return i
+}
+
+// parseTableRef reads a possibly qualified relation name starting at i and an
+// optional alias, returning the index of the last consumed token.
+func (a *Analysis) parseTableRef(i int, cur *Block, match map[int]int) int {
+	toks := a.Tokens
+	parts := []string{toks[i].Name()}
+	start, end := toks[i].Start, toks[i].End
+	a.ignore[i] = true
+	j := i
+	for j+2 < len(toks) && toks[j+1].IsPunct(".") && toks[j+2].IsName() {
+		parts = append(parts, toks[j+2].Name())
+		a.ignore[j+2] = true
+		end = toks[j+2].End
+		j += 2
+	}
+	cur.expectTable = false
+
+	ref := TableRef{Name: parts[len(parts)-1], Start: start, End: end}
+	if len(parts) > 1 {
+		ref.Schema = parts[len(parts)-2]
+	}
+
+	// A function in FROM, e.g. generate_series(1, 10) AS g
+	if j+1 < len(toks) && toks[j+1].IsPunct("(") {
+		ref.Kind = RefDerived
+		close, ok := match[j+1]
+		if !ok {
+			cur.Refs = append(cur.Refs, ref)
+			return j
+		}
+		a.parseAlias(close+1, &ref, match)
+		cur.Refs = append(cur.Refs, ref)
+		return j
+	}
+
+	switch {
+	case ref.Schema == "" && cur.isCTE(ref.Name):
+		ref.Kind = RefCTE
+	default:
+		ref.Table = a.catalog.FindTable(ref.Schema, ref.Name)
+	}
+
+	next := a.parseAlias(j+1, &ref, match)
+	cur.Refs = append(cur.Refs, ref)
+	return next - 1
+}
+
+// parseAlias reads "[AS] alias [(col, ...)]" at i and returns the index of the
+// first token after it.
+func (a *Analysis) parseAlias(i int, ref *TableRef, match map[int]int) int {
+	toks := a.Tokens
+	if i < len(toks) && toks[i].Is("AS") {
+		i++
+	}
+	if i < len(toks) && toks[i].IsName() {
+		ref.Alias = toks[i].Name()
+		a.ignore[i] = true
+		i++
+		if i < len(toks) && toks[i].IsPunct("(") {
+			if close, ok := match[i]; ok {
+				for k := i; k < close; k++ {
+					a.ignore[k] = true
+				}
+				i = close + 1
+			}
+		}
+	}
+	return i
+}
+
+// parseCTEs records the names defined by a WITH clause starting at i.
+func (a *Analysis) parseCTEs(i int, cur *Block, match map[int]int) {
+	toks := a.Tokens
+	if i < len(toks) && strings.EqualFold(toks[i].Text, "RECURSIVE") {
+		i++
+	}
+	for i < len(toks) && toks[i].IsName() {
+		nameIdx := i
+		i++
+		if i < len(toks) && toks[i].IsPunct("(") {
+			close, ok := match[i]
+			if !ok {
+				return
+			}
+			for k := i; k < close; k++ {
+				a.ignore[k] = true
+			}
+			i = close + 1
+		}
+		if i >= len(toks) || !toks[i].Is("AS") {
+			return
+		}
+		i++
+		for i < len(toks) && (toks[i].Is("NOT") || toks[i].Is("MATERIALIZED")) {
+			i++
+		}
+		if i >= len(toks) || !toks[i].IsPunct("(") {
+			return
+		}
+		a.ignore[nameIdx] = true
+		cur.CTEs[toks[nameIdx].Name()] = true
+		close, ok := match[i]
+		if !ok {
+			return
+		}
+		i = close + 1
+		if i >= len(toks) || !toks[i].IsPunct(",") {
+			return
+		}
+		i++
+	}
+}
+
+// ignoreTypeName marks the type name following a :: cast, including
+// multi-word types such as "timestamp with time zone".
+func (a *Analysis) ignoreTypeName(i int) {
+	toks := a.Tokens
+	typeWords := map[string]bool{"with": true, "without": true, "time": true, "zone": true, "precision": true, "varying": true}
+	for ; i < len(toks); i++ {
+		if toks[i].IsName() || (toks[i].Kind == TokenKeyword && typeWords[strings.ToLower(toks[i].Text)]) {
+			a.ignore[i] = true
+			if i+1 < len(toks) && toks[i+1].IsPunct(".") {
+				i++
+				continue
+			}
+			if i+1 < len(toks) && (toks[i+1].IsName() || toks[i+1].Kind == TokenKeyword) && typeWords[strings.ToLower(toks[i+1].Text)] {
+				continue
+			}
+		}
+		return
+	}
+}
+
+// BlockAt returns the query block that contains the byte offset.
+func (a *Analysis) BlockAt(offset int) *Block {
+	var blk *Block
+	for i, t := range a.Tokens {
+		if t.Start >= offset {
+			break
+		}
+		blk = a.blockOf[i]
+	}
+	if blk == nil && len(a.Blocks) > 0 {
+		blk = a.Blocks[0]
+	}
+	return blk
+}
+
+func matchParens(toks []Token) map[int]int {
+	match := make(map[int]int)
+	var open []int
+	for i, t := range toks {
+		switch {
+		case t.IsPunct("("):
+			open = append(open, i)
+		case t.IsPunct(")") && len(open) > 0:
+			match[open[len(open)-1]] = i
+			open = open[:len(open)-1]
+		}
+	}
+	return match
+}
+
+// endsExpression reports whether a token can be the last token of a select
+// list expression, so that a following bare name is an implicit alias.
+func endsExpression(t Token) bool {
+	switch t.Kind {
+	case TokenIdentifier, TokenQuotedIdentifier, TokenString, TokenNumber, TokenParam:
+		return true
+	case TokenPunct:
+		return t.Text == ")" || t.Text == "]"
+	case TokenKeyword:
+		return t.Is("NULL") || t.Is("TRUE") || t.Is("FALSE") || t.Is("END")
+	}
+	return false
+}
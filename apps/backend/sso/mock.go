@@ -0,0 +1,471 @@
+package sso
+
+import (
+	"bytes"
+	"compress/flate"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"html/template"
+	"io"
+	"math/big"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/beevik/etree"
+	"github.com/golang-jwt/jwt/v5"
+	dsig "github.com/russellhaering/goxmldsig"
+)
+
+const mockCodeTTL = time.Minute
+
+// MockIdP is an identity provider for local development and testing. It
+// speaks just enough OIDC and SAML for the sign-in flows and signs in
+// whoever fills in its form, with whatever email and groups they type, so it
+// must never be enabled in production.
+//
+// Its OIDC issuer is its base URL; any client ID and secret are accepted.
+// Its SAML entity ID is the base URL followed by /saml, its SSO URL is
+// /saml/sso and its signing certificate is served at /saml/certificate.
+type MockIdP struct {
+	baseURL string
+	key     *rsa.PrivateKey
+	keyID   string
+	certDER []byte
+
+	mu    sync.Mutex
+	codes map[string]mockCode
+}
+
+type mockCode struct {
+	clientID      string
+	redirectURI   string
+	nonce         string
+	codeChallenge string
+	user          mockUser
+	expiresAt     time.Time
+}
+
+type mockUser struct {
+	email  string
+	name   string
+	groups []string
+}
+
+// NewMockIdP creates a mock IdP served at baseURL, with a signing key and
+// certificate that last as long as the process.
+func NewMockIdP(baseURL string) (*MockIdP, error) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		return nil, err
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "Mock IdP"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().AddDate(1, 0, 0),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+	}
+	certDER, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		return nil, err
+	}
+	keyID, err := randomString(8)
+	if err != nil {
+		return nil, err
+	}
+	return &MockIdP{
+		baseURL: strings.TrimSuffix(baseURL, "/"),
+		key:     key,
+		keyID:   keyID,
+		certDER: certDER,
+		codes:   make(map[string]mockCode),
+	}, nil
+}
+
+// Handler serves the IdP's endpoints, relative to its base URL.
+func (m *MockIdP) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", m.discovery)
+	mux.HandleFunc("/jwks", m.jwks)
+	mux.HandleFunc("/authorize", m.authorize)
+	mux.HandleFunc("/token", m.token)
+	mux.HandleFunc("/saml/sso", m.samlSSO)
+	mux.HandleFunc("/saml/certificate", m.samlCertificate)
+	return mux
+}
+
+func (m *MockIdP) samlEntityID() string {
+	return m.baseURL + "/saml"
+}
+
+func (m *MockIdP) discovery(w http.ResponseWriter, r *http.Request) {
+	writeMockJSON(w, http.StatusOK, map[string]interface{}{
+		"issuer":                                m.baseURL,
+		"authorization_endpoint":                m.baseURL + "/authorize",
+		"token_endpoint":                        m.baseURL + "/token",
+		"jwks_uri":                              m.baseURL + "/jwks",
+		"response_types_supported":              []string{"code"},
+		"subject_types_supported":               []string{"public"},
+		"id_token_signing_alg_values_supported": []string{"RS256"},
+		"code_challenge_methods_supported":      []string{"S256"},
+	})
+}
+
+func (m *MockIdP) jwks(w http.ResponseWriter, r *http.Request) {
+	pub := m.key.PublicKey
+	writeMockJSON(w, http.StatusOK, map[string]interface{}{
+		"keys": []map[string]string{{
+			"kty": "RSA",
+			"kid": m.keyID,
+			"alg": "RS256",
+			"use": "sig",
+			"n":   base64.RawURLEncoding.EncodeToString(pub.N.Bytes()),
+			"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(pub.E)).Bytes()),
+		}},
+	})
+}
+
+// authorize shows the sign-in form for a GET and issues a code for the
+// submitted user on POST.
+func (m *MockIdP) authorize(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, "Invalid request", http.StatusBadRequest)
+		return
+	}
+	redirectURI := r.Form.Get("redirect_uri")
+	target, err := url.Parse(redirectURI)
+	if err != nil || redirectURI == "" {
+		http.Error(w, "Invalid redirect_uri", http.StatusBadRequest)
+		return
+	}
+	if r.Form.Get("code_challenge_method") != "S256" || r.Form.Get("code_challenge") == "" {
+		http.Error(w, "PKCE with S256 is required", http.StatusBadRequest)
+		return
+	}
+
+	if r.Method != http.MethodPost {
+		m.renderForm(w, "OpenID Connect", "/authorize", r.Form, []string{
+			"client_id", "redirect_uri", "state", "nonce", "code_challenge", "code_challenge_method",
+		})
+		return
+	}
+
+	user, ok := formUser(w, r)
+	if !ok {
+		return
+	}
+	code, err := randomString(16)
+	if err != nil {
+		http.Error(w, "Failed to issue code", http.StatusInternalServerError)
+		return
+	}
+	m.mu.Lock()
+	for c, issued := range m.codes {
+		if time.Now().After(issued.expiresAt) {
+			delete(m.codes, c)
+		}
+	}
+	m.codes[code] = mockCode{
+		clientID:      r.Form.Get("client_id"),
+		redirectURI:   redirectURI,
+		nonce:         r.Form.Get("nonce"),
+		codeChallenge: r.Form.Get("code_challenge"),
+		user:          user,
+		expiresAt:     time.Now().Add(mockCodeTTL),
+	}
+	m.mu.Unlock()
+
+	q := target.Query()
+	q.Set("code", code)
+	q.Set("state", r.Form.Get("state"))
+	target.RawQuery = q.Encode()
+	http.Redirect(w, r, target.String(), http.StatusFound)
+}
+
+// token redeems a code for an ID token.
+func (m *MockIdP) token(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if err := r.ParseForm(); err != nil || r.PostForm.Get("grant_type") != "authorization_code" {
+		writeMockJSON(w, http.StatusBadRequest, map[string]string{"error": "unsupported_grant_type"})
+		return
+	}
+
+	m.mu.Lock()
+	issued, ok := m.codes[r.PostForm.Get("code")]
+	delete(m.codes, r.PostForm.Get("code"))
+	m.mu.Unlock()
+
+	clientID, _, hasBasic := r.BasicAuth()
+	if hasBasic {
+		clientID, _ = url.QueryUnescape(clientID)
+	} else {
+		clientID = r.PostForm.Get("client_id")
+	}
+	sum := sha256.Sum256([]byte(r.PostForm.Get("code_verifier")))
+	switch {
+	case !ok || time.Now().After(issued.expiresAt):
+		writeMockJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid_grant", "error_description": "unknown or expired code"})
+		return
+	case issued.clientID != clientID || issued.redirectURI != r.PostForm.Get("redirect_uri"):
+		writeMockJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid_grant", "error_description": "code was issued to another client"})
+		return
+	case base64.RawURLEncoding.EncodeToString(sum[:]) != issued.codeChallenge:
+		writeMockJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid_grant", "error_description": "code_verifier does not match"})
+		return
+	}
+
+	now := time.Now()
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, jwt.MapClaims{
+		"iss":            m.baseURL,
+		"aud":            clientID,
+		"sub":            issued.user.email,
+		"email":          issued.user.email,
+		"email_verified": true,
+		"name":           issued.user.name,
+		"groups":         issued.user.groups,
+		"nonce":          issued.nonce,
+		"iat":            now.Unix(),
+		"exp":            now.Add(5 * time.Minute).Unix(),
+	})
+	token.Header["kid"] = m.keyID
+	idToken, err := token.SignedString(m.key)
+	if err != nil {
+		writeMockJSON(w, http.StatusInternalServerError, map[string]string{"error": "server_error"})
+		return
+	}
+
+	writeMockJSON(w, http.StatusOK, map[string]interface{}{
+		"access_token": idToken,
+		"token_type":   "Bearer",
+		"expires_in":   300,
+		"id_token":     idToken,
+	})
+}
+
+// samlSSO takes an AuthnRequest over the HTTP-Redirect binding, shows the
+// sign-in form, and posts a signed response to the request's ACS.
+func (m *MockIdP) samlSSO(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, "Invalid request", http.StatusBadRequest)
+		return
+	}
+	authnRequest, err := decodeAuthnRequest(r.Form.Get("SAMLRequest"))
+	if err != nil {
+		http.Error(w, "Invalid SAMLRequest", http.StatusBadRequest)
+		return
+	}
+
+	if r.Method != http.MethodPost {
+		m.renderForm(w, "SAML", "/saml/sso", r.Form, []string{"SAMLRequest", "RelayState"})
+		return
+	}
+
+	user, ok := formUser(w, r)
+	if !ok {
+		return
+	}
+	response, err := m.samlResponse(authnRequest, user)
+	if err != nil {
+		http.Error(w, "Failed to sign response", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	mockPostTemplate.Execute(w, map[string]string{
+		"ACS":          authnRequest.SelectAttrValue("AssertionConsumerServiceURL", ""),
+		"SAMLResponse": base64.StdEncoding.EncodeToString(response),
+		"RelayState":   r.Form.Get("RelayState"),
+	})
+}
+
+func (m *MockIdP) samlCertificate(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/x-pem-file")
+	pem.Encode(w, &pem.Block{Type: "CERTIFICATE", Bytes: m.certDER})
+}
+
+func decodeAuthnRequest(encoded string) (*etree.Element, error) {
+	deflated, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, err
+	}
+	raw, err := io.ReadAll(io.LimitReader(flate.NewReader(bytes.NewReader(deflated)), 1<<20))
+	if err != nil {
+		return nil, err
+	}
+	doc := etree.NewDocument()
+	if err := doc.ReadFromBytes(raw); err != nil {
+		return nil, err
+	}
+	req := doc.Root()
+	if req == nil || !isElement(req, samlProtocolNS, "AuthnRequest") || req.SelectAttrValue("AssertionConsumerServiceURL", "") == "" {
+		return nil, invalid("not an AuthnRequest")
+	}
+	return req, nil
+}
+
+// samlResponse answers the AuthnRequest with a response carrying a signed
+// assertion about user.
+func (m *MockIdP) samlResponse(authnRequest *etree.Element, user mockUser) ([]byte, error) {
+	now := time.Now().UTC()
+	requestID := authnRequest.SelectAttrValue("ID", "")
+	acs := authnRequest.SelectAttrValue("AssertionConsumerServiceURL", "")
+	audience := ""
+	if issuer := child(authnRequest, samlAssertionNS, "Issuer"); issuer != nil {
+		audience = strings.TrimSpace(issuer.Text())
+	}
+	responseID, err := randomString(16)
+	if err != nil {
+		return nil, err
+	}
+	assertionID, err := randomString(16)
+	if err != nil {
+		return nil, err
+	}
+	instant := now.Format(time.RFC3339)
+	notOnOrAfter := now.Add(5 * time.Minute).Format(time.RFC3339)
+
+	assertion := etree.NewElement("saml:Assertion")
+	assertion.CreateAttr("xmlns:saml", samlAssertionNS)
+	assertion.CreateAttr("ID", "id-"+assertionID)
+	assertion.CreateAttr("Version", "2.0")
+	assertion.CreateAttr("IssueInstant", instant)
+	assertion.CreateElement("saml:Issuer").SetText(m.samlEntityID())
+
+	subject := assertion.CreateElement("saml:Subject")
+	nameID := subject.CreateElement("saml:NameID")
+	nameID.CreateAttr("Format", samlEmailFormat)
+	nameID.SetText(user.email)
+	confirmation := subject.CreateElement("saml:SubjectConfirmation")
+	confirmation.CreateAttr("Method", samlBearer)
+	data := confirmation.CreateElement("saml:SubjectConfirmationData")
+	data.CreateAttr("InResponseTo", requestID)
+	data.CreateAttr("Recipient", acs)
+	data.CreateAttr("NotOnOrAfter", notOnOrAfter)
+
+	conditions := assertion.CreateElement("saml:Conditions")
+	conditions.CreateAttr("NotBefore", instant)
+	conditions.CreateAttr("NotOnOrAfter", notOnOrAfter)
+	conditions.CreateElement("saml:AudienceRestriction").CreateElement("saml:Audience").SetText(audience)
+
+	authn := assertion.CreateElement("saml:AuthnStatement")
+	authn.CreateAttr("AuthnInstant", instant)
+	authn.CreateElement("saml:AuthnContext").CreateElement("saml:AuthnContextClassRef").
+		SetText("urn:oasis:names:tc:SAML:2.0:ac:classes:unspecified")
+
+	statement := assertion.CreateElement("saml:AttributeStatement")
+	addAttribute := func(name string, values ...string) {
+		attr := statement.CreateElement("saml:Attribute")
+		attr.CreateAttr("Name", name)
+		for _, v := range values {
+			attr.CreateElement("saml:AttributeValue").SetText(v)
+		}
+	}
+	addAttribute("email", user.email)
+	if user.name != "" {
+		addAttribute("name", user.name)
+	}
+	if len(user.groups) > 0 {
+		addAttribute("groups", user.groups...)
+	}
+
+	signer, err := dsig.NewSigningContext(m.key, [][]byte{m.certDER})
+	if err != nil {
+		return nil, err
+	}
+	signer.Canonicalizer = dsig.MakeC14N10ExclusiveCanonicalizerWithPrefixList("")
+	signed, err := signer.SignEnveloped(assertion)
+	if err != nil {
+		return nil, err
+	}
+	// The schema puts the signature right after the issuer
+	signature := signed.RemoveChildAt(len(signed.Child) - 1)
+	signed.InsertChildAt(1, signature)
+
+	doc := etree.NewDocument()
+	response := doc.CreateElement("samlp:Response")
+	response.CreateAttr("xmlns:samlp", samlProtocolNS)
+	response.CreateAttr("xmlns:saml", samlAssertionNS)
+	response.CreateAttr("ID", "id-"+responseID)
+	response.CreateAttr("Version", "2.0")
+	response.CreateAttr("IssueInstant", instant)
+	response.CreateAttr("Destination", acs)
+	response.CreateAttr("InResponseTo", requestID)
+	response.CreateElement("saml:Issuer").SetText(m.samlEntityID())
+	response.CreateElement("samlp:Status").CreateElement("samlp:StatusCode").CreateAttr("Value", samlSuccess)
+	response.AddChild(signed)
+	return doc.WriteToBytes()
+}
+
+func formUser(w http.ResponseWriter, r *http.Request) (mockUser, bool) {
+	user := mockUser{
+		email:  strings.TrimSpace(r.PostForm.Get("email")),
+		name:   strings.TrimSpace(r.PostForm.Get("name")),
+		groups: uniqueGroups(strings.Split(r.PostForm.Get("groups"), ",")),
+	}
+	if user.email == "" {
+		http.Error(w, "Email is required", http.StatusBadRequest)
+		return user, false
+	}
+	return user, true
+}
+
+func (m *MockIdP) renderForm(w http.ResponseWriter, protocol, endpoint string, form url.Values, keep []string) {
+	hidden := make(map[string]string, len(keep))
+	for _, name := range keep {
+		hidden[name] = form.Get(name)
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	mockFormTemplate.Execute(w, map[string]interface{}{
+		"Protocol": protocol,
+		"Action":   m.baseURL + endpoint,
+		"Hidden":   hidden,
+	})
+}
+
+func writeMockJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+var mockFormTemplate = template.Must(template.New("form").Parse(`<!DOCTYPE html>
+<html>
+<head><title>Mock IdP</title></head>
+<body>
+<h1>Mock IdP ({{.Protocol}})</h1>
+<p>Signs in whoever you say. For development only.</p>
+<form method="post" action="{{.Action}}">
+{{range $name, $value := .Hidden}}<input type="hidden" name="{{$name}}" value="{{$value}}">
+{{end}}<p><label>Email <input name="email" type="email" required></label></p>
+<p><label>Name <input name="name"></label></p>
+<p><label>Groups (comma separated) <input name="groups"></label></p>
+<p><button type="submit">Sign in</button></p>
+</form>
+</body>
+</html>
+`))
+
+var mockPostTemplate = template.Must(template.New("post").Parse(`<!DOCTYPE html>
+<html>
+<head><title>Mock IdP</title></head>
+<body onload="document.forms[0].submit()">
+<form method="post" action="{{.ACS}}">
+<input type="hidden" name="SAMLResponse" value="{{.SAMLResponse}}">
+<input type="hidden" name="RelayState" value="{{.RelayState}}">
+<noscript><button type="submit">Continue</button></noscript>
+</form>
+</body>
+</html>
+`))
@@ -0,0 +1,333 @@
+package sso
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"go-backend/auth"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const (
+	// discoveryTTL is how long an issuer's discovery document is reused.
+	discoveryTTL = time.Hour
+	// clockSkew is the leeway allowed on token and assertion times.
+	clockSkew = 2 * time.Minute
+)
+
+// OIDCConfig is an organization's OpenID Connect client.
+type OIDCConfig struct {
+	Issuer       string
+	ClientID     string
+	ClientSecret string
+	// Scopes are requested besides openid, email and profile.
+	Scopes      []string
+	RedirectURL string
+	// GroupsClaim is the ID token claim holding the user's groups.
+	GroupsClaim string
+}
+
+// ErrUnsafeURL is returned for provider URLs the server must not fetch:
+// anything but https, and addresses on the server's own network.
+var ErrUnsafeURL = errors.New("provider URLs must be https and reach a public address")
+
+// Client talks to OIDC providers, caching each issuer's discovery document
+// and signing keys. Issuers are set by organization admins, so the client
+// only fetches https URLs and never connects to loopback, private or
+// link-local addresses, whatever a host name resolves to.
+type Client struct {
+	httpClient   *http.Client
+	timeout      time.Duration
+	allowPrivate bool
+
+	mu      sync.Mutex
+	issuers map[string]*issuer
+}
+
+type issuer struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+
+	keys      *auth.KeySet
+	fetchedAt time.Time
+}
+
+// NewClient returns a client for OIDC providers. allowPrivate lifts the
+// limits on where it connects, for the development mock IdP.
+func NewClient(timeout time.Duration, allowPrivate bool) *Client {
+	if timeout == 0 {
+		timeout = 10 * time.Second
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	if !allowPrivate {
+		// A proxy would be the only address dialled
+		transport.Proxy = nil
+		transport.DialContext = (&net.Dialer{
+			Timeout: timeout,
+			Control: func(_, address string, _ syscall.RawConn) error {
+				host, _, err := net.SplitHostPort(address)
+				if err != nil {
+					return err
+				}
+				if ip := net.ParseIP(host); ip == nil || !publicIP(ip) {
+					return ErrUnsafeURL
+				}
+				return nil
+			},
+		}).DialContext
+	}
+	return &Client{
+		httpClient:   &http.Client{Timeout: timeout, Transport: transport},
+		timeout:      timeout,
+		allowPrivate: allowPrivate,
+		issuers:      make(map[string]*issuer),
+	}
+}
+
+// CheckURL returns ErrUnsafeURL unless raw is a URL the client may fetch. Host
+// names are checked again for the addresses they resolve to when dialled.
+func (c *Client) CheckURL(raw string) error {
+	u, err := url.Parse(raw)
+	if err != nil || u.Hostname() == "" || u.User != nil {
+		return ErrUnsafeURL
+	}
+	if c.allowPrivate {
+		if u.Scheme != "https" && u.Scheme != "http" {
+			return ErrUnsafeURL
+		}
+		return nil
+	}
+	if u.Scheme != "https" {
+		return ErrUnsafeURL
+	}
+	if ip := net.ParseIP(u.Hostname()); ip != nil && !publicIP(ip) {
+		return ErrUnsafeURL
+	}
+	return nil
+}
+
+// cgnat is the carrier-grade NAT range, private in all but name.
+var cgnat = &net.IPNet{IP: net.IPv4(100, 64, 0, 0), Mask: net.CIDRMask(10, 32)}
+
+func publicIP(ip net.IP) bool {
+	return !(ip.IsLoopback() || ip.IsPrivate() || ip.IsUnspecified() ||
+		ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() || ip.IsInterfaceLocalMulticast() ||
+		ip.IsMulticast() || cgnat.Contains(ip))
+}
+
+// discover returns the issuer's discovery document, fetching it when it is
+// not cached or is older than discoveryTTL.
+func (c *Client) discover(ctx context.Context, issuerURL string) (*issuer, error) {
+	issuerURL = strings.TrimSuffix(issuerURL, "/")
+	if err := c.CheckURL(issuerURL); err != nil {
+		return nil, err
+	}
+
+	c.mu.Lock()
+	cached := c.issuers[issuerURL]
+	c.mu.Unlock()
+	if cached != nil && time.Since(cached.fetchedAt) < discoveryTTL {
+		return cached, nil
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, issuerURL+"/.well-known/openid-configuration", nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to build discovery request: %w", err)
+	}
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch OIDC discovery document: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("OIDC discovery returned %s", resp.Status)
+	}
+
+	var doc issuer
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(&doc); err != nil {
+		return nil, fmt.Errorf("failed to decode OIDC discovery document: %w", err)
+	}
+	if strings.TrimSuffix(doc.Issuer, "/") != issuerURL {
+		return nil, fmt.Errorf("OIDC discovery document is for issuer %q, not %q", doc.Issuer, issuerURL)
+	}
+	if doc.AuthorizationEndpoint == "" || doc.TokenEndpoint == "" || doc.JWKSURI == "" {
+		return nil, fmt.Errorf("OIDC discovery document for %q is missing endpoints", issuerURL)
+	}
+	for _, endpoint := range []string{doc.AuthorizationEndpoint, doc.TokenEndpoint, doc.JWKSURI} {
+		if err := c.CheckURL(endpoint); err != nil {
+			return nil, fmt.Errorf("OIDC discovery document for %q names %q: %w", issuerURL, endpoint, err)
+		}
+	}
+
+	doc.keys = auth.NewJWKSKeySetWithClient(doc.JWKSURI, c.httpClient)
+	if err := doc.keys.Refresh(ctx); err != nil {
+		return nil, fmt.Errorf("failed to fetch OIDC signing keys: %w", err)
+	}
+	doc.fetchedAt = time.Now()
+
+	c.mu.Lock()
+	c.issuers[issuerURL] = &doc
+	c.mu.Unlock()
+	return &doc, nil
+}
+
+// Check fetches the issuer's discovery document and keys, to catch a wrong
+// issuer when a connection is saved rather than at the first sign-in.
+func (c *Client) Check(ctx context.Context, cfg OIDCConfig) error {
+	_, err := c.discover(ctx, cfg.Issuer)
+	return err
+}
+
+// OIDCAuthURL is where to send the user to sign in: an authorization code
+// request with PKCE.
+func (c *Client) OIDCAuthURL(ctx context.Context, cfg OIDCConfig, login *Login) (string, error) {
+	iss, err := c.discover(ctx, cfg.Issuer)
+	if err != nil {
+		return "", err
+	}
+
+	u, err := url.Parse(iss.AuthorizationEndpoint)
+	if err != nil {
+		return "", fmt.Errorf("invalid authorization endpoint: %w", err)
+	}
+	scopes := append([]string{"openid", "email", "profile"}, cfg.Scopes...)
+	q := u.Query()
+	q.Set("response_type", "code")
+	q.Set("client_id", cfg.ClientID)
+	q.Set("redirect_uri", cfg.RedirectURL)
+	q.Set("scope", strings.Join(scopes, " "))
+	q.Set("state", login.State)
+	q.Set("nonce", login.Nonce)
+	q.Set("code_challenge", login.codeChallenge())
+	q.Set("code_challenge_method", "S256")
+	u.RawQuery = q.Encode()
+	return u.String(), nil
+}
+
+// OIDCExchange redeems the code the IdP returned and verifies the ID token
+// it is exchanged for.
+func (c *Client) OIDCExchange(ctx context.Context, cfg OIDCConfig, code string, login *Login) (*Identity, error) {
+	iss, err := c.discover(ctx, cfg.Issuer)
+	if err != nil {
+		return nil, err
+	}
+
+	form := url.Values{}
+	form.Set("grant_type", "authorization_code")
+	form.Set("code", code)
+	form.Set("redirect_uri", cfg.RedirectURL)
+	form.Set("code_verifier", login.CodeVerifier)
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, iss.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, fmt.Errorf("failed to build token request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	req.SetBasicAuth(url.QueryEscape(cfg.ClientID), url.QueryEscape(cfg.ClientSecret))
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to redeem authorization code: %w", err)
+	}
+	defer resp.Body.Close()
+
+	var token struct {
+		IDToken          string `json:"id_token"`
+		Error            string `json:"error"`
+		ErrorDescription string `json:"error_description"`
+	}
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(&token); err != nil {
+		return nil, fmt.Errorf("failed to decode token response (%s): %w", resp.Status, err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("token endpoint returned %s: %s %s", resp.Status, token.Error, token.ErrorDescription)
+	}
+	if token.IDToken == "" {
+		return nil, invalid("token response has no id_token")
+	}
+
+	return verifyIDToken(iss, cfg, token.IDToken, login.Nonce)
+}
+
+var idTokenMethods = []string{"RS256", "RS384", "RS512", "PS256", "PS384", "PS512", "ES256", "ES384", "ES512", "EdDSA"}
+
+func verifyIDToken(iss *issuer, cfg OIDCConfig, raw, nonce string) (*Identity, error) {
+	parser := jwt.NewParser(
+		jwt.WithValidMethods(idTokenMethods),
+		jwt.WithIssuer(iss.Issuer),
+		jwt.WithAudience(cfg.ClientID),
+		jwt.WithExpirationRequired(),
+		jwt.WithLeeway(clockSkew),
+	)
+
+	claims := jwt.MapClaims{}
+	_, err := parser.ParseWithClaims(raw, claims, func(token *jwt.Token) (interface{}, error) {
+		kid, _ := token.Header["kid"].(string)
+		keys := iss.keys.Lookup(kid, token.Method.Alg())
+		if len(keys) == 0 {
+			return nil, fmt.Errorf("no signing key for kid %q", kid)
+		}
+		set := jwt.VerificationKeySet{}
+		for _, key := range keys {
+			set.Keys = append(set.Keys, key)
+		}
+		return set, nil
+	})
+	if err != nil {
+		return nil, invalid(err.Error())
+	}
+
+	if got, _ := claims["nonce"].(string); got != nonce {
+		return nil, invalid("nonce does not match")
+	}
+	if aud, _ := claims.GetAudience(); len(aud) > 1 {
+		if azp, _ := claims["azp"].(string); azp != cfg.ClientID {
+			return nil, invalid("token was issued to another client")
+		}
+	}
+	if verified, ok := claims["email_verified"].(bool); ok && !verified {
+		return nil, invalid("email is not verified by the identity provider")
+	}
+
+	id := &Identity{}
+	id.Subject, _ = claims["sub"].(string)
+	id.Email, _ = claims["email"].(string)
+	id.Name, _ = claims["name"].(string)
+	if id.Subject == "" {
+		return nil, invalid("token has no subject")
+	}
+
+	groupsClaim := cfg.GroupsClaim
+	if groupsClaim == "" {
+		groupsClaim = "groups"
+	}
+	switch groups := claims[groupsClaim].(type) {
+	case string:
+		id.Groups = uniqueGroups(strings.Split(groups, ","))
+	case []interface{}:
+		names := make([]string, 0, len(groups))
+		for _, g := range groups {
+			if name, ok := g.(string); ok {
+				names = append(names, name)
+			}
+		}
+		id.Groups = uniqueGroups(names)
+	}
+	return id, nil
+}
@@ -0,0 +1,394 @@
+package sso
+
+import (
+	"bytes"
+	"compress/flate"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/beevik/etree"
+	dsig "github.com/russellhaering/goxmldsig"
+)
+
+const (
+	samlProtocolNS  = "urn:oasis:names:tc:SAML:2.0:protocol"
+	samlAssertionNS = "urn:oasis:names:tc:SAML:2.0:assertion"
+	samlMetadataNS  = "urn:oasis:names:tc:SAML:2.0:metadata"
+	samlPOSTBinding = "urn:oasis:names:tc:SAML:2.0:bindings:HTTP-POST"
+	samlSuccess     = "urn:oasis:names:tc:SAML:2.0:status:Success"
+	samlBearer      = "urn:oasis:names:tc:SAML:2.0:cm:bearer"
+	samlEmailFormat = "urn:oasis:names:tc:SAML:1.1:nameid-format:emailAddress"
+)
+
+// SAMLConfig is an organization's SAML identity provider and the service
+// provider it knows this server as.
+type SAMLConfig struct {
+	IdPEntityID string
+	// IdPSSOURL takes AuthnRequests over the HTTP-Redirect binding.
+	IdPSSOURL string
+	// IdPCertificate signs the IdP's responses, as PEM or base64 DER.
+	IdPCertificate string
+	SPEntityID     string
+	ACSURL         string
+	// GroupsAttribute is the attribute holding the user's groups.
+	GroupsAttribute string
+}
+
+// Attribute names IdPs commonly send a user's email and name in.
+var (
+	emailAttributes = []string{
+		"email", "mail", "emailaddress", "Email",
+		"http://schemas.xmlsoap.org/ws/2005/05/identity/claims/emailaddress",
+		"urn:oid:0.9.2342.19200300.100.1.3",
+	}
+	nameAttributes = []string{
+		"name", "displayName", "displayname",
+		"http://schemas.xmlsoap.org/ws/2005/05/identity/claims/name",
+		"urn:oid:2.16.840.1.113730.3.1.241",
+	}
+	givenNameAttributes = []string{
+		"givenName", "firstName",
+		"http://schemas.xmlsoap.org/ws/2005/05/identity/claims/givenname",
+		"urn:oid:2.5.4.42",
+	}
+	surnameAttributes = []string{
+		"sn", "surname", "lastName",
+		"http://schemas.xmlsoap.org/ws/2005/05/identity/claims/surname",
+		"urn:oid:2.5.4.4",
+	}
+)
+
+// ParseCertificate reads an IdP signing certificate given as PEM or as the
+// bare base64 DER found in IdP metadata.
+func ParseCertificate(s string) (*x509.Certificate, error) {
+	s = strings.TrimSpace(s)
+	if block, _ := pem.Decode([]byte(s)); block != nil {
+		if block.Type != "CERTIFICATE" {
+			return nil, fmt.Errorf("expected a CERTIFICATE, got %s", block.Type)
+		}
+		return x509.ParseCertificate(block.Bytes)
+	}
+	der, err := base64.StdEncoding.DecodeString(strings.Join(strings.Fields(s), ""))
+	if err != nil {
+		return nil, errors.New("certificate is neither PEM nor base64")
+	}
+	return x509.ParseCertificate(der)
+}
+
+// SAMLAuthURL is where to send the user to sign in: an AuthnRequest over the
+// HTTP-Redirect binding, with the login's state as RelayState.
+func SAMLAuthURL(cfg SAMLConfig, login *Login, now time.Time) (string, error) {
+	doc := etree.NewDocument()
+	req := doc.CreateElement("samlp:AuthnRequest")
+	req.CreateAttr("xmlns:samlp", samlProtocolNS)
+	req.CreateAttr("xmlns:saml", samlAssertionNS)
+	req.CreateAttr("ID", login.RequestID)
+	req.CreateAttr("Version", "2.0")
+	req.CreateAttr("IssueInstant", now.UTC().Format(time.RFC3339))
+	req.CreateAttr("Destination", cfg.IdPSSOURL)
+	req.CreateAttr("AssertionConsumerServiceURL", cfg.ACSURL)
+	req.CreateAttr("ProtocolBinding", samlPOSTBinding)
+	req.CreateElement("saml:Issuer").SetText(cfg.SPEntityID)
+	policy := req.CreateElement("samlp:NameIDPolicy")
+	policy.CreateAttr("AllowCreate", "true")
+
+	raw, err := doc.WriteToBytes()
+	if err != nil {
+		return "", err
+	}
+	var deflated bytes.Buffer
+	w, err := flate.NewWriter(&deflated, flate.BestCompression)
+	if err != nil {
+		return "", err
+	}
+	if _, err := w.Write(raw); err != nil {
+		return "", err
+	}
+	if err := w.Close(); err != nil {
+		return "", err
+	}
+
+	u, err := url.Parse(cfg.IdPSSOURL)
+	if err != nil {
+		return "", fmt.Errorf("invalid IdP SSO URL: %w", err)
+	}
+	q := u.Query()
+	q.Set("SAMLRequest", base64.StdEncoding.EncodeToString(deflated.Bytes()))
+	q.Set("RelayState", login.State)
+	u.RawQuery = q.Encode()
+	return u.String(), nil
+}
+
+// SAMLMetadata describes the service provider, for IdPs that are set up from
+// metadata.
+func SAMLMetadata(cfg SAMLConfig) ([]byte, error) {
+	doc := etree.NewDocument()
+	doc.CreateProcInst("xml", `version="1.0" encoding="UTF-8"`)
+	entity := doc.CreateElement("md:EntityDescriptor")
+	entity.CreateAttr("xmlns:md", samlMetadataNS)
+	entity.CreateAttr("entityID", cfg.SPEntityID)
+	sp := entity.CreateElement("md:SPSSODescriptor")
+	sp.CreateAttr("AuthnRequestsSigned", "false")
+	sp.CreateAttr("WantAssertionsSigned", "true")
+	sp.CreateAttr("protocolSupportEnumeration", samlProtocolNS)
+	sp.CreateElement("md:NameIDFormat").SetText(samlEmailFormat)
+	acs := sp.CreateElement("md:AssertionConsumerService")
+	acs.CreateAttr("Binding", samlPOSTBinding)
+	acs.CreateAttr("Location", cfg.ACSURL)
+	acs.CreateAttr("index", "0")
+	acs.CreateAttr("isDefault", "true")
+	doc.Indent(2)
+	return doc.WriteToBytes()
+}
+
+// ParseSAMLResponse verifies a base64 SAMLResponse posted to the ACS and
+// returns who it asserts signed in. The response must answer the login's
+// AuthnRequest, so IdP-initiated sign-in is not supported, and the response
+// or its assertion must be signed with the IdP's certificate. Only what the
+// signature covers is read. Encrypted assertions are not supported.
+func ParseSAMLResponse(cfg SAMLConfig, encoded string, login *Login, now time.Time) (*Identity, error) {
+	raw, err := base64.StdEncoding.DecodeString(strings.Join(strings.Fields(encoded), ""))
+	if err != nil {
+		return nil, invalid("SAMLResponse is not base64")
+	}
+	doc := etree.NewDocument()
+	if err := doc.ReadFromBytes(raw); err != nil {
+		return nil, invalid("SAMLResponse is not XML")
+	}
+	response := doc.Root()
+	if response == nil || !isElement(response, samlProtocolNS, "Response") {
+		return nil, invalid("not a SAML Response")
+	}
+
+	cert, err := ParseCertificate(cfg.IdPCertificate)
+	if err != nil {
+		return nil, fmt.Errorf("invalid IdP certificate: %w", err)
+	}
+	validator := dsig.NewDefaultValidationContext(&dsig.MemoryX509CertificateStore{Roots: []*x509.Certificate{cert}})
+
+	// With a signed response everything inside it is covered; otherwise the
+	// assertion has to carry its own signature
+	if child(response, dsig.Namespace, "Signature") != nil {
+		if response, err = validator.Validate(response); err != nil {
+			return nil, invalid("response signature: " + err.Error())
+		}
+	}
+	if len(children(response, samlAssertionNS, "EncryptedAssertion")) > 0 {
+		return nil, invalid("encrypted assertions are not supported")
+	}
+	assertions := children(response, samlAssertionNS, "Assertion")
+	if len(assertions) != 1 {
+		return nil, invalid("response must contain exactly one assertion")
+	}
+	assertion := assertions[0]
+	if child(assertion, dsig.Namespace, "Signature") != nil {
+		if assertion, err = validator.Validate(withNamespaces(assertion)); err != nil {
+			return nil, invalid("assertion signature: " + err.Error())
+		}
+	} else if child(doc.Root(), dsig.Namespace, "Signature") == nil {
+		return nil, invalid("neither the response nor the assertion is signed")
+	}
+
+	if dest := response.SelectAttrValue("Destination", ""); dest != "" && dest != cfg.ACSURL {
+		return nil, invalid("response is for another destination")
+	}
+	if response.SelectAttrValue("InResponseTo", "") != login.RequestID {
+		return nil, invalid("response does not answer this sign-in")
+	}
+	if issuer := child(response, samlAssertionNS, "Issuer"); issuer != nil && strings.TrimSpace(issuer.Text()) != cfg.IdPEntityID {
+		return nil, invalid("response is from another issuer")
+	}
+	status := child(child(response, samlProtocolNS, "Status"), samlProtocolNS, "StatusCode")
+	if status == nil || status.SelectAttrValue("Value", "") != samlSuccess {
+		code := ""
+		if status != nil {
+			code = status.SelectAttrValue("Value", "")
+		}
+		return nil, invalid("identity provider refused the sign-in: " + code)
+	}
+
+	if issuer := child(assertion, samlAssertionNS, "Issuer"); issuer == nil || strings.TrimSpace(issuer.Text()) != cfg.IdPEntityID {
+		return nil, invalid("assertion is from another issuer")
+	}
+	if err := checkConditions(child(assertion, samlAssertionNS, "Conditions"), cfg, now); err != nil {
+		return nil, err
+	}
+
+	subject := child(assertion, samlAssertionNS, "Subject")
+	if err := checkSubjectConfirmation(subject, cfg, login, now); err != nil {
+		return nil, err
+	}
+	nameID := child(subject, samlAssertionNS, "NameID")
+	if nameID == nil || strings.TrimSpace(nameID.Text()) == "" {
+		return nil, invalid("assertion has no NameID")
+	}
+
+	attrs := attributes(assertion)
+	id := &Identity{
+		Subject: strings.TrimSpace(nameID.Text()),
+		Email:   firstValue(attrs, emailAttributes),
+		Name:    firstValue(attrs, nameAttributes),
+	}
+	if id.Email == "" && strings.Contains(id.Subject, "@") {
+		id.Email = id.Subject
+	}
+	if id.Name == "" {
+		id.Name = strings.TrimSpace(firstValue(attrs, givenNameAttributes) + " " + firstValue(attrs, surnameAttributes))
+	}
+	groupsAttribute := cfg.GroupsAttribute
+	if groupsAttribute == "" {
+		groupsAttribute = "groups"
+	}
+	id.Groups = uniqueGroups(attrs[groupsAttribute])
+	return id, nil
+}
+
+func checkConditions(conditions *etree.Element, cfg SAMLConfig, now time.Time) error {
+	if conditions == nil {
+		return invalid("assertion has no conditions")
+	}
+	if err := checkWindow(conditions, now); err != nil {
+		return err
+	}
+	for _, restriction := range children(conditions, samlAssertionNS, "AudienceRestriction") {
+		for _, audience := range children(restriction, samlAssertionNS, "Audience") {
+			if strings.TrimSpace(audience.Text()) == cfg.SPEntityID {
+				return nil
+			}
+		}
+	}
+	return invalid("assertion is for another audience")
+}
+
+func checkSubjectConfirmation(subject *etree.Element, cfg SAMLConfig, login *Login, now time.Time) error {
+	if subject == nil {
+		return invalid("assertion has no subject")
+	}
+	for _, confirmation := range children(subject, samlAssertionNS, "SubjectConfirmation") {
+		if confirmation.SelectAttrValue("Method", "") != samlBearer {
+			continue
+		}
+		data := child(confirmation, samlAssertionNS, "SubjectConfirmationData")
+		if data == nil {
+			continue
+		}
+		if data.SelectAttrValue("Recipient", "") != cfg.ACSURL {
+			continue
+		}
+		if irt := data.SelectAttrValue("InResponseTo", ""); irt != "" && irt != login.RequestID {
+			continue
+		}
+		if data.SelectAttrValue("NotOnOrAfter", "") == "" || checkWindow(data, now) != nil {
+			continue
+		}
+		return nil
+	}
+	return invalid("assertion has no valid bearer confirmation for this service provider")
+}
+
+// checkWindow checks the element's NotBefore and NotOnOrAfter against now.
+func checkWindow(el *etree.Element, now time.Time) error {
+	if v := el.SelectAttrValue("NotBefore", ""); v != "" {
+		t, err := time.Parse(time.RFC3339Nano, v)
+		if err != nil {
+			return invalid("invalid NotBefore")
+		}
+		if now.Add(clockSkew).Before(t) {
+			return invalid("assertion is not valid yet")
+		}
+	}
+	if v := el.SelectAttrValue("NotOnOrAfter", ""); v != "" {
+		t, err := time.Parse(time.RFC3339Nano, v)
+		if err != nil {
+			return invalid("invalid NotOnOrAfter")
+		}
+		if !now.Add(-clockSkew).Before(t) {
+			return invalid("assertion has expired")
+		}
+	}
+	return nil
+}
+
+// attributes collects the assertion's attribute values by Name and by
+// FriendlyName.
+func attributes(assertion *etree.Element) map[string][]string {
+	attrs := make(map[string][]string)
+	for _, statement := range children(assertion, samlAssertionNS, "AttributeStatement") {
+		for _, attr := range children(statement, samlAssertionNS, "Attribute") {
+			var values []string
+			for _, v := range children(attr, samlAssertionNS, "AttributeValue") {
+				values = append(values, strings.TrimSpace(v.Text()))
+			}
+			for _, name := range []string{attr.SelectAttrValue("Name", ""), attr.SelectAttrValue("FriendlyName", "")} {
+				if name != "" {
+					attrs[name] = append(attrs[name], values...)
+				}
+			}
+		}
+	}
+	return attrs
+}
+
+func firstValue(attrs map[string][]string, names []string) string {
+	for _, name := range names {
+		for _, v := range attrs[name] {
+			if v != "" {
+				return v
+			}
+		}
+	}
+	return ""
+}
+
+// withNamespaces copies el with the namespace declarations it inherits, so
+// it still canonicalizes and resolves prefixes once detached from the
+// response.
+func withNamespaces(el *etree.Element) *etree.Element {
+	copied := el.Copy()
+	declared := make(map[string]bool)
+	for _, attr := range copied.Attr {
+		if attr.Space == "xmlns" || (attr.Space == "" && attr.Key == "xmlns") {
+			declared[attr.FullKey()] = true
+		}
+	}
+	for parent := el.Parent(); parent != nil; parent = parent.Parent() {
+		for _, attr := range parent.Attr {
+			isNS := attr.Space == "xmlns" || (attr.Space == "" && attr.Key == "xmlns")
+			if isNS && !declared[attr.FullKey()] {
+				declared[attr.FullKey()] = true
+				copied.CreateAttr(attr.FullKey(), attr.Value)
+			}
+		}
+	}
+	return copied
+}
+
+func isElement(el *etree.Element, ns, tag string) bool {
+	return el.Tag == tag && el.NamespaceURI() == ns
+}
+
+func children(el *etree.Element, ns, tag string) []*etree.Element {
+	if el == nil {
+		return nil
+	}
+	var found []*etree.Element
+	for _, c := range el.ChildElements() {
+		if isElement(c, ns, tag) {
+			found = append(found, c)
+		}
+	}
+	return found
+}
+
+func child(el *etree.Element, ns, tag string) *etree.Element {
+	if found := children(el, ns, tag); len(found) > 0 {
+		return found[0]
+	}
+	return nil
+}
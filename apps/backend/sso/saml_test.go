@@ -0,0 +1,218 @@
+package sso
+
+import (
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/beevik/etree"
+)
+
+const (
+	testSPEntityID = "https://app.example.com/api/v1/sso/saml/o1/metadata"
+	testACSURL     = "https://app.example.com/api/v1/sso/saml/o1/acs"
+)
+
+func newTestSAML(t *testing.T) (*MockIdP, SAMLConfig) {
+	t.Helper()
+	m, err := NewMockIdP("https://idp.example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return m, SAMLConfig{
+		IdPEntityID:    m.samlEntityID(),
+		IdPSSOURL:      m.baseURL + "/saml/sso",
+		IdPCertificate: string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: m.certDER})),
+		SPEntityID:     testSPEntityID,
+		ACSURL:         testACSURL,
+	}
+}
+
+func newTestLogin(t *testing.T) *Login {
+	t.Helper()
+	login, err := NewLogin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return login
+}
+
+// samlResponseFor has the mock IdP answer the AuthnRequest cfg sends for
+// login, changed by tamper when it is not nil, and returns it as posted to
+// the ACS.
+func samlResponseFor(t *testing.T, m *MockIdP, cfg SAMLConfig, login *Login, tamper func(response *etree.Element)) string {
+	t.Helper()
+	authURL, err := SAMLAuthURL(cfg, login, time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	u, err := url.Parse(authURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	authnRequest, err := decodeAuthnRequest(u.Query().Get("SAMLRequest"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	raw, err := m.samlResponse(authnRequest, mockUser{email: "ada@example.com", name: "Ada", groups: []string{"eng"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if tamper != nil {
+		doc := etree.NewDocument()
+		if err := doc.ReadFromBytes(raw); err != nil {
+			t.Fatal(err)
+		}
+		tamper(doc.Root())
+		if raw, err = doc.WriteToBytes(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return base64.StdEncoding.EncodeToString(raw)
+}
+
+func assertion(response *etree.Element) *etree.Element {
+	return child(response, samlAssertionNS, "Assertion")
+}
+
+func TestParseSAMLResponse(t *testing.T) {
+	m, cfg := newTestSAML(t)
+	login := newTestLogin(t)
+
+	id, err := ParseSAMLResponse(cfg, samlResponseFor(t, m, cfg, login, nil), login, time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if id.Subject != "ada@example.com" || id.Email != "ada@example.com" || id.Name != "Ada" {
+		t.Errorf("identity = %+v", id)
+	}
+	if len(id.Groups) != 1 || id.Groups[0] != "eng" {
+		t.Errorf("groups = %v", id.Groups)
+	}
+}
+
+func TestParseSAMLResponseRefuses(t *testing.T) {
+	evilUser := func(a *etree.Element) {
+		a.FindElement("Subject/NameID").SetText("mallory@example.com")
+	}
+
+	tests := []struct {
+		name   string
+		tamper func(response *etree.Element)
+		// edit changes the config or login the response is checked against
+		edit func(cfg *SAMLConfig, login *Login)
+		at   time.Duration
+	}{
+		{name: "signed assertion changed", tamper: func(r *etree.Element) { evilUser(assertion(r)) }},
+		{name: "attribute added to the signed assertion", tamper: func(r *etree.Element) {
+			attr := assertion(r).FindElement("AttributeStatement").CreateElement("saml:Attribute")
+			attr.CreateAttr("Name", "groups")
+			attr.CreateElement("saml:AttributeValue").SetText("admins")
+		}},
+		{name: "signature removed", tamper: func(r *etree.Element) {
+			a := assertion(r)
+			a.RemoveChild(a.FindElement("Signature"))
+		}},
+		{name: "signed by another key", edit: func(cfg *SAMLConfig, _ *Login) {
+			other, _ := newTestSAML(t)
+			cfg.IdPCertificate = base64.StdEncoding.EncodeToString(other.certDER)
+		}},
+		{name: "duplicated assertion", tamper: func(r *etree.Element) {
+			r.AddChild(assertion(r).Copy())
+		}},
+		{name: "forged assertion before the signed one", tamper: func(r *etree.Element) {
+			forged := assertion(r).Copy()
+			forged.RemoveChild(forged.FindElement("Signature"))
+			evilUser(forged)
+			r.InsertChildAt(0, forged)
+		}},
+		{name: "signed assertion wrapped in a forged one", tamper: func(r *etree.Element) {
+			signed := assertion(r)
+			forged := signed.Copy()
+			forged.RemoveChild(forged.FindElement("Signature"))
+			evilUser(forged)
+			r.RemoveChild(signed)
+			forged.AddChild(signed)
+			r.AddChild(forged)
+		}},
+		{name: "forged assertion carrying the original signature", tamper: func(r *etree.Element) {
+			signed := assertion(r)
+			forged := signed.Copy()
+			evilUser(forged)
+			forged.CreateAttr("ID", "id-forged")
+			r.RemoveChild(signed)
+			r.AddChild(forged)
+		}},
+		{name: "another audience", edit: func(cfg *SAMLConfig, _ *Login) {
+			cfg.SPEntityID = "https://other.example.com/metadata"
+		}},
+		{name: "another destination", edit: func(cfg *SAMLConfig, _ *Login) {
+			cfg.ACSURL = "https://app.example.com/api/v1/sso/saml/o2/acs"
+		}},
+		{name: "another recipient", tamper: func(r *etree.Element) {
+			// Destination is optional and outside the signature, so only the
+			// signed Recipient is left to tie the assertion to this ACS
+			r.RemoveAttr("Destination")
+		}, edit: func(cfg *SAMLConfig, _ *Login) {
+			cfg.ACSURL = "https://app.example.com/api/v1/sso/saml/o2/acs"
+		}},
+		{name: "another issuer", edit: func(cfg *SAMLConfig, _ *Login) {
+			cfg.IdPEntityID = "https://other-idp.example.com/saml"
+		}},
+		{name: "replayed for another sign-in", edit: func(_ *SAMLConfig, login *Login) {
+			login.RequestID = "id-another-request"
+		}},
+		{name: "past NotOnOrAfter", at: 10 * time.Minute},
+		{name: "before NotBefore", at: -10 * time.Minute},
+		{name: "not base64"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m, cfg := newTestSAML(t)
+			login := newTestLogin(t)
+			encoded := samlResponseFor(t, m, cfg, login, tt.tamper)
+			if tt.name == "not base64" {
+				encoded = "<Response/>"
+			}
+			if tt.edit != nil {
+				tt.edit(&cfg, login)
+			}
+
+			id, err := ParseSAMLResponse(cfg, encoded, login, time.Now().Add(tt.at))
+			if err == nil {
+				t.Fatalf("accepted as %+v", id)
+			}
+			if !errors.Is(err, ErrInvalidResponse) {
+				t.Fatalf("error = %v, want an invalid response", err)
+			}
+		})
+	}
+}
+
+func TestParseSAMLResponseReadsOnlyTheSignedAssertion(t *testing.T) {
+	m, cfg := newTestSAML(t)
+	login := newTestLogin(t)
+
+	// An assertion nested elsewhere in the response is not one of its
+	// assertions, so the signed one is still the one read
+	encoded := samlResponseFor(t, m, cfg, login, func(r *etree.Element) {
+		forged := assertion(r).Copy()
+		forged.RemoveChild(forged.FindElement("Signature"))
+		forged.FindElement("Subject/NameID").SetText("mallory@example.com")
+		r.InsertChildAt(0, etree.NewElement("samlp:Extensions"))
+		child(r, samlProtocolNS, "Extensions").AddChild(forged)
+	})
+
+	id, err := ParseSAMLResponse(cfg, encoded, login, time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if id.Subject != "ada@example.com" {
+		t.Errorf("subject = %q, read from the unsigned assertion", id.Subject)
+	}
+}